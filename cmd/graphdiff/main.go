@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func main() {
+	list := flag.Int("list", 10, "Print up to this many added, removed and reweighted edges of each kind")
+	maxRemoved := flag.Float64("max-removed", 0, "Exit with status 2 when more than this fraction of the old graph's edges were removed (e.g. 0.01); 0 disables the check")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: graphdiff [--list N] [--max-removed fraction] old.bin new.bin")
+		os.Exit(1)
+	}
+	a := readGraph(flag.Arg(0))
+	b := readGraph(flag.Arg(1))
+	d := graph.DiffGraphs(a, b)
+
+	fmt.Printf("nodes:   %d -> %d (%+d)\n", d.NodesA, d.NodesB, int64(d.NodesB)-int64(d.NodesA))
+	fmt.Printf("edges:   %d -> %d (%+d)\n", d.EdgesA, d.EdgesB, int64(d.EdgesB)-int64(d.EdgesA))
+	fmt.Printf("added:   %d\n", len(d.Added))
+	fmt.Printf("removed: %d\n", len(d.Removed))
+	fmt.Printf("changed: %d weights\n", len(d.Changed))
+	fmt.Printf("same:    %d\n", d.Same)
+	if minLat, minLon, maxLat, maxLon, ok := d.RemovedBounds(); ok {
+		fmt.Printf("removed edges lie within %f,%f,%f,%f\n", minLat, minLon, maxLat, maxLon)
+	}
+
+	for i, e := range d.Removed[:min(*list, len(d.Removed))] {
+		if i == 0 {
+			fmt.Println("\nremoved:")
+		}
+		fmt.Printf("  %s weight %d\n", formatKey(e.EdgeKey), e.Weight)
+	}
+	for i, e := range d.Added[:min(*list, len(d.Added))] {
+		if i == 0 {
+			fmt.Println("\nadded:")
+		}
+		fmt.Printf("  %s weight %d\n", formatKey(e.EdgeKey), e.Weight)
+	}
+	for i, c := range d.Changed[:min(*list, len(d.Changed))] {
+		if i == 0 {
+			fmt.Println("\nchanged:")
+		}
+		fmt.Printf("  %s weight %d -> %d\n", formatKey(c.EdgeKey), c.Old, c.New)
+	}
+
+	if *maxRemoved > 0 && d.EdgesA > 0 {
+		if frac := float64(len(d.Removed)) / float64(d.EdgesA); frac > *maxRemoved {
+			fmt.Fprintf(os.Stderr, "graphdiff: %.2f%% of edges removed, more than --max-removed %.2f%%\n", 100*frac, 100**maxRemoved)
+			os.Exit(2)
+		}
+	}
+}
+
+// readGraph loads the original (uncontracted) graph of a combined binary.
+func readGraph(path string) *graph.Graph {
+	chg, err := graph.ReadBinary(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return chg.OrigGraph()
+}
+
+func formatKey(k graph.EdgeKey) string {
+	return fmt.Sprintf("%f,%f -> %f,%f", k.FromLat.Deg(), k.FromLon.Deg(), k.ToLat.Deg(), k.ToLon.Deg())
+}
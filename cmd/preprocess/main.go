@@ -2,28 +2,86 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/azybler/map_router/pkg/ch"
 	"github.com/azybler/map_router/pkg/graph"
 	osmparser "github.com/azybler/map_router/pkg/osm"
+	"github.com/azybler/map_router/pkg/routing"
 )
 
 func main() {
-	input := flag.String("input", "", "Path to .osm.pbf file")
+	var inputs, changes pathList
+	flag.Var(&inputs, "input", "Path to an .osm.pbf, .osm (XML), .osm.bz2 or .o5m file; the format is taken from the extension, or detected from the content. Repeat to merge neighbouring extracts (objects on the seam are deduplicated by OSM ID)")
+	dataset := flag.String("dataset", "", "Way-level dataset file for incremental updates. With --input: parse the input(s), save the dataset here and build from it. Without --input: build from the saved dataset")
+	flag.Var(&changes, "changes", "OsmChange diff (.osc or .osc.gz) to apply to --dataset before building; repeat to apply several in order. The updated dataset is saved back to --dataset")
+	fromGeoJSON := flag.String("from-geojson", "", "Build from a GeoJSON FeatureCollection of LineStrings instead of OSM (optional per-line weight and oneway properties; lines join where they share a vertex). Replaces --input; profiles and OSM filters do not apply")
+	geojsonSpeed := flag.Float64("geojson-speed", 50, "Speed in km/h for weighting --from-geojson lines without a weight property by travel time; with --distance they are weighted by length")
 	output := flag.String("output", "graph.bin", "Output combined binary graph file path (base + overlay in one file)")
+	osmIDs := flag.Bool("osm-ids", false, "Also store each node's OSM node ID and each edge's OSM way ID in the combined --output (about 8 bytes per node and edge), for tracing routes back to the map")
+	snapIndex := flag.Bool("snap-index", false, "Also store the server's snapping index in the combined --output, so the server loads it instead of building it at startup (larger file, faster cold start). Not applied to --output-base/--output-overlay")
+	compress := flag.Bool("compress", false, "zstd-compress the combined --output (typically 2-3x smaller; the server decompresses transparently). Not applied to --output-base/--output-overlay")
 	outputBase := flag.String("output-base", "", "Write the metric-independent base (coords, topology, geometry) to this path instead of a combined --output")
 	outputOverlay := flag.String("output-overlay", "", "Write the metric-specific overlay (ranks, CH upward graph, edge weights) to this path; requires --output-base")
+	outputTiles := flag.String("output-tiles", "", "Also write the uncontracted graph partitioned into grid cells to this path, for servers that load only a region of it (server --tiles --region)")
+	tileSize := flag.Float64("tile-size", graph.DefaultTileSize, "Cell edge length in degrees for --output-tiles")
+	outputDistance := flag.String("output-distance", "", "Also weigh the roads by length and contract them in the travel-time graph's order, writing the distance graph here: a combined graph with --output, or an overlay on the same --output-base. The shared order takes only witness searches, so the second metric costs a fraction of another build")
 	splitFrom := flag.String("split-from", "", "Convert an existing combined graph .bin into --output-base + --output-overlay without re-parsing OSM (ignores --input and all build options)")
+	upgradeFrom := flag.String("upgrade-from", "", "Rewrite an existing combined graph .bin of an older format version to --output in the current format without re-parsing OSM (ignores --input and all build options)")
+	geojson := flag.String("geojson", "", "Also export the built graph's edges as GeoJSON LineStrings to this path, for inspecting the build in QGIS or kepler.gl")
+	nodesCSV := flag.String("nodes-csv", "", "Also export the built graph's nodes as CSV (id,lat,lng,osm_id) to this path, for analysis in pandas, NetworkX or a spreadsheet")
+	edgesCSV := flag.String("edges-csv", "", "Also export the built graph's edges as CSV (id,from,to,weight,class,...) to this path; from/to are --nodes-csv ids")
+	graphML := flag.String("graphml", "", "Also export the built graph as GraphML to this path, for NetworkX, igraph or Gephi")
+	exportFrom := flag.String("export-from", "", "Export an existing combined graph .bin to the --geojson/--nodes-csv/--edges-csv/--graphml paths without re-parsing OSM (ignores --input and all build options)")
 	bbox := flag.String("bbox", "", "Bounding box filter: minLat,minLng,maxLat,maxLng (e.g. 1.15,103.6,1.48,104.1)")
 	singapore := flag.Bool("singapore", false, "Shortcut for --bbox 1.15,103.6,1.48,104.1 (Singapore bounding box)")
 	kl := flag.Bool("kl", false, "Shortcut for --bbox 2.75,101.2,3.5,102.0 (Selangor + Kuala Lumpur bounding box)")
-	speeds := flag.String("speeds", "", "Path to a JSON speed table (default: built-in Malaysian priors)")
+	boundary := flag.String("boundary", "", "GeoJSON Polygon/MultiPolygon file (bare, Feature or FeatureCollection); keep only roads inside it. Combines with the bbox options")
+	profileName := flag.String("profile", "car", "Travel mode to build the network for: car, hgv, bike or foot")
+	profileList := flag.String("profiles", "", "Comma-separated travel modes (e.g. car,bike,foot) to build into one multi-profile --output bundle that stores shared node coordinates and geometry once; replaces --profile")
+	truckWeight := flag.Float64("truck-weight", 0, "hgv profile: gross vehicle weight in tonnes; ways with a lower maxweight are dropped (0: not checked)")
+	truckHeight := flag.Float64("truck-height", 0, "hgv profile: vehicle height in metres, checked against maxheight (0: not checked)")
+	truckWidth := flag.Float64("truck-width", 0, "hgv profile: vehicle width in metres, checked against maxwidth (0: not checked)")
+	truckLength := flag.Float64("truck-length", 0, "hgv profile: vehicle length in metres, checked against maxlength (0: not checked)")
+	speeds := flag.String("speeds", "", "Path to a JSON speed and penalty table for the selected profile, overlaid on its built-in defaults")
 	distance := flag.Bool("distance", false, "Weight edges by physical road length (shortest-distance routing) instead of travel time; ignores --speeds")
+	accessTime := flag.String("access-time", "", "Evaluate time-based :conditional access tags (access, vehicle, motor_vehicle, motorcar, hgv) and oneway:conditional (including oneway=reversible schedules) at this RFC 3339 instant (e.g. 2026-01-05T08:00:00+08:00); weekday and time of day are read in its offset. Default: conditional tags are ignored")
+	overpass := flag.String("overpass", "", "Download the --bbox/--boundary area from the Overpass API into this .osm file and build from it instead of --input. Meant for small study areas; the public API rejects large queries")
+	overpassURL := flag.String("overpass-url", osmparser.DefaultOverpassEndpoint, "Overpass API interpreter URL used by --overpass")
+	privatePenalty := flag.Float64("private-penalty", 0, "If > 1, keep private/permit/residents and destination-only ways as public edges with their weight multiplied by this factor (e.g. 10), instead of gating them through the restricted-cluster filter; addresses on them stay routable while through-routes avoid them")
+	keepParallel := flag.Bool("keep-parallel", false, "Keep every edge between the same two junctions; by default only the cheapest of parallel edges survives (public preferred over restricted)")
+	keepReversible := flag.Bool("keep-reversible", false, "Without --access-time, keep oneway=reversible ways open in each direction their oneway:conditional schedule ever allows, instead of dropping them")
+	elevation := flag.String("elevation", "", "Directory of SRTM .hgt tiles (e.g. N01E103.hgt) giving every node an elevation; edges record climb and descent, and the bike and foot profiles slow down uphill")
+	terrainTiles := flag.String("terrain-tiles", "", "Directory of Terrarium-encoded terrain PNG tiles laid out as {z}/{x}/{y}.png, as an alternative to --elevation")
+	terrainZoom := flag.Int("terrain-zoom", 12, "Zoom level of the --terrain-tiles to read")
+	hilbert := flag.Bool("hilbert", false, "Renumber nodes along a Hilbert curve through their coordinates, so nodes close on the map are close in memory (faster snapping and queries on large graphs). Changes every node ID, so graphs built with and without it do not diff node for node")
+	exactPriority := flag.Bool("exact-priority", false, "Order the contraction by the shortcuts each node really needs, found by witness search, instead of a worst-case estimate: several times slower to contract, for a smaller graph and faster queries")
+	cch := flag.Bool("cch", false, "Build a customizable contraction hierarchy: contract the topology in a minimum-degree order (or --order nd) without witness searches, then weigh it. More shortcuts than the default contraction")
+	orderName := flag.String("order", "greedy", "Contraction order: greedy (by shortcuts added, decided during contraction) or nd (nested dissection of the road network, decided beforehand: slower to contract, faster queries)")
+	checkpoint := flag.String("checkpoint", "", "Save the contraction's state to this file every --checkpoint-interval, and resume from it when it exists, so an interrupted build carries on where it stopped (one file per profile with --profiles, suffixed with the profile name)")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Minute, "How often --checkpoint saves the contraction's state")
+	maxShortcuts := flag.Int("max-shortcuts", 1000, "Stop contraction at the first node that would add more shortcuts than this, leaving the remaining nodes as an uncontracted core: lower builds faster, with slower queries")
+	coreFraction := flag.Float64("core-fraction", 0, "Stop contraction once no more than this share of the nodes is left, e.g. 0.02 to contract 98% of them, leaving the rest as an uncontracted core: the last nodes take much of the build, so a core of a few percent builds faster, for slower queries")
+	witnessSettled := flag.Int("witness-settled", 500, "Nodes each witness search may settle during contraction: higher finds more witnesses, for fewer shortcuts and a longer build")
+	witnessHops := flag.Int("witness-hops", 5, "Edges each witness search may follow from its source during contraction: higher finds more witnesses, for fewer shortcuts and a longer build")
+	deterministic := flag.Bool("deterministic", false, "Make two builds from the same inputs and flags byte-identical, for reproducibility and caching: the metadata build time is taken from $SOURCE_DATE_EPOCH (Unix seconds), or left at zero when it is unset. Cannot be combined with --checkpoint, as a resumed contraction may order nodes differently")
+	progressMode := flag.String("progress", "", "Show contraction progress as a bar on stderr (bar) or as one JSON object per line on stdout with profile, contracted, nodes, shortcuts, core, elapsed_s, eta_s and done (json, for CI); by default progress is only logged now and then")
+	adaptiveWitness := flag.Bool("adaptive-witness", false, "Start witness searches at --witness-settled and --witness-hops and double either limit whenever it cuts short more than a tenth of recent searches (up to 16 times), so the searches near the top of the hierarchy find the witnesses fixed limits miss: fewer shortcuts on dense grids, for a longer build")
+	priorityWeights := flag.String("priority-weights", "1,2,1", "Weights of a node's edge difference, contracted neighbours and level in its contraction priority, as e,n,l")
+	rankOrder := flag.Bool("rank-order", false, "Renumber nodes by contraction rank once contracted, so the upward graphs are laid out bottom to top: one-to-all searches (routing.PHAST) sweep them in one pass. Replaces the --hilbert numbering")
 	minComponent := flag.Int("min-component", 0, "Keep every strongly-connected road network with >= N nodes (0: keep only the largest, default). Use a small value like 2 to retain disconnected networks such as islands, e.g. Tasmania for all-of-Australia coverage")
 	flag.Parse()
 
@@ -45,9 +103,78 @@ func main() {
 		return
 	}
 
-	if *input == "" {
-		fmt.Fprintln(os.Stderr, "Usage: preprocess --input <file.osm.pbf> [--output graph.bin | --output-base base.bin --output-overlay overlay.bin] [--singapore | --kl | --bbox minLat,minLng,maxLat,maxLng] [--speeds <table.json> | --distance]")
+	// Conversion mode: bring an older-format graph up to date.
+	if *upgradeFrom != "" {
+		if split {
+			log.Fatal("--upgrade-from writes a combined --output; split the result with --split-from")
+		}
+		if err := upgradeCombined(*upgradeFrom, *output, *compress); err != nil {
+			log.Fatalf("Failed to upgrade %s: %v", *upgradeFrom, err)
+		}
+		return
+	}
+
+	var exports []graphExport
+	for _, e := range []graphExport{
+		{"GeoJSON", *geojson, graph.ExportGeoJSON},
+		{"node CSV", *nodesCSV, graph.ExportNodesCSV},
+		{"edge CSV", *edgesCSV, graph.ExportEdgesCSV},
+		{"GraphML", *graphML, graph.ExportGraphML},
+	} {
+		if e.path != "" {
+			exports = append(exports, e)
+		}
+	}
+
+	// Conversion mode: export an existing graph for inspection.
+	if *exportFrom != "" {
+		if len(exports) == 0 {
+			log.Fatal("--export-from requires --geojson, --nodes-csv, --edges-csv or --graphml")
+		}
+		log.Printf("Reading graph from %s...", *exportFrom)
+		chg, err := graph.ReadBinary(*exportFrom)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *exportFrom, err)
+		}
+		if err := exportGraph(chg.OrigGraph(), exports); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+
+	if len(changes) > 0 && *dataset == "" {
+		log.Fatal("--changes requires --dataset")
+	}
+	profileNames := []string{*profileName}
+	if *profileList != "" {
+		profileNames = strings.Split(*profileList, ",")
+		switch {
+		case split:
+			log.Fatal("--profiles writes a bundle to --output; it cannot be split into --output-base/--output-overlay")
+		case *dataset != "" || *overpass != "":
+			log.Fatal("--profiles cannot be combined with --dataset or --overpass, which are built for one profile")
+		case *speeds != "":
+			log.Fatal("--speeds applies to a single --profile")
+		case *outputTiles != "":
+			log.Fatal("--output-tiles holds a single --profile")
+		case *outputDistance != "":
+			log.Fatal("--output-distance holds a single --profile")
+		}
+	}
+	if *outputDistance != "" && (*distance || *privatePenalty > 1) {
+		log.Fatal("--output-distance weighs edges by length alone; it cannot be combined with --distance or --private-penalty")
+	}
+	if *overpass != "" && len(inputs) > 0 {
+		log.Fatal("--overpass and --input are mutually exclusive")
+	}
+	if *fromGeoJSON != "" && (len(inputs) > 0 || *dataset != "" || *overpass != "" || *profileList != "") {
+		log.Fatal("--from-geojson replaces --input, --dataset, --overpass and --profiles")
+	}
+	if len(inputs) == 0 && *dataset == "" && *overpass == "" && *fromGeoJSON == "" {
+		fmt.Fprintln(os.Stderr, "Usage: preprocess {--input <file.osm.pbf|.osm|.osm.bz2|.o5m> | --overpass <area.osm> | --dataset <file> [--changes <diff.osc.gz>] | --from-geojson <roads.geojson>} [--output graph.bin | --output-base base.bin --output-overlay overlay.bin] [--output-distance distance.bin] [--output-tiles tiles.bin] [--singapore | --kl | --bbox minLat,minLng,maxLat,maxLng] [--profile car | --profiles car,bike,foot] [--speeds <table.json> | --distance]")
 		fmt.Fprintln(os.Stderr, "       preprocess --split-from combined.bin --output-base base.bin --output-overlay overlay.bin")
+		fmt.Fprintln(os.Stderr, "       preprocess --upgrade-from old.bin [--output graph.bin] [--compress]")
+		fmt.Fprintln(os.Stderr, "       preprocess --export-from graph.bin [--geojson edges.geojson] [--nodes-csv nodes.csv --edges-csv edges.csv] [--graphml graph.graphml]")
 		os.Exit(1)
 	}
 
@@ -69,37 +196,318 @@ func main() {
 		log.Printf("Using bounding box filter: lat [%.4f, %.4f], lng [%.4f, %.4f]", minLat, maxLat, minLng, maxLng)
 	}
 
+	if *boundary != "" {
+		b, err := osmparser.LoadBoundary(*boundary)
+		if err != nil {
+			log.Fatalf("Failed to load boundary: %v", err)
+		}
+		opts.Boundary = b
+		log.Printf("Using boundary polygon from %s", *boundary)
+	}
+
+	truck := truckDims{*truckWeight, *truckHeight, *truckWidth, *truckLength}
 	if *distance {
 		opts.Distance = true
 		log.Println("Distance metric: weighting edges by physical road length (cm); --speeds ignored")
-	} else if *speeds != "" {
-		tbl, err := osmparser.LoadSpeedTable(*speeds)
+	}
+	profiles := make([]osmparser.Profile, len(profileNames))
+	speedTables := make([]osmparser.SpeedTable, len(profileNames))
+	hasHGV := false
+	for i, name := range profileNames {
+		profile, tbl, err := resolveProfile(name, *speeds, *distance, truck)
 		if err != nil {
-			log.Fatalf("Failed to load speed table: %v", err)
+			log.Fatal(err)
 		}
-		opts.Speeds = tbl
-		log.Printf("Using speed table from %s", *speeds)
-	} else {
-		opts.Speeds = osmparser.DefaultSpeedTable()
-		log.Println("Using built-in default speed table")
+		profiles[i], speedTables[i] = profile, tbl
+		_, isHGV := profile.(osmparser.HGVProfile)
+		hasHGV = hasHGV || isHGV
+	}
+	if truck.set() {
+		if !hasHGV {
+			log.Fatal("--truck-weight/--truck-height/--truck-width/--truck-length require --profile hgv")
+		}
+		log.Printf("Truck: %.1f t, %.2f m high, %.2f m wide, %.2f m long (0 = not checked)", *truckWeight, *truckHeight, *truckWidth, *truckLength)
 	}
+	profile := profiles[0]
+
+	if *accessTime != "" {
+		t, err := time.Parse(time.RFC3339, *accessTime)
+		if err != nil {
+			log.Fatalf("Invalid --access-time: %v", err)
+		}
+		opts.AccessTime = t
+		log.Printf("Evaluating conditional access at %s (%s)", t.Format(time.RFC3339), t.Weekday())
+	}
+
+	// From here on an interrupt stops the build cleanly: parsing and
+	// contraction return early (saving a --checkpoint), and the writes,
+	// which cannot stop midway, remove their temporary files.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *overpass != "" {
+		if err := downloadOverpass(ctx, *overpassURL, *overpass, profile, opts.BBox, opts.Boundary); err != nil {
+			log.Fatalf("Overpass download failed: %v", err)
+		}
+		inputs = pathList{*overpass}
+	}
+
+	if *privatePenalty > 1 {
+		opts.PrivatePenalty = *privatePenalty
+		log.Printf("Penalizing private and destination-only ways %.1f× instead of restricting them", *privatePenalty)
+	}
+
+	opts.KeepParallel = *keepParallel
+
+	if *keepReversible {
+		opts.KeepReversible = true
+		if *accessTime != "" {
+			log.Println("--keep-reversible has no effect with --access-time: reversible ways take the direction in force at that instant")
+		}
+	}
+
+	switch {
+	case *elevation != "" && *terrainTiles != "":
+		log.Fatal("--elevation and --terrain-tiles are mutually exclusive")
+	case *elevation != "":
+		opts.Elevation = &osmparser.HGTDir{Dir: *elevation}
+		log.Printf("Using SRTM elevation tiles from %s", *elevation)
+	case *terrainTiles != "":
+		opts.Elevation = &osmparser.TerrariumTiles{Dir: *terrainTiles, Zoom: *terrainZoom}
+		log.Printf("Using zoom %d terrain tiles from %s", *terrainZoom, *terrainTiles)
+	}
+
+	// Merged inputs may mix encodings, so each one is sniffed instead.
+	if len(inputs) == 1 {
+		opts.Format = osmparser.FormatFromPath(inputs[0])
+	}
+
+	opts.Progress = logProgress(10 * time.Second)
 
 	start := time.Now()
 
-	// Step 1: Parse OSM data.
-	log.Println("Opening OSM file...")
-	f, err := os.Open(*input)
+	// Record what the graph was actually built from: the extract(s), or the
+	// saved dataset, plus any diffs applied on top.
+	sources := slices.Clone(inputs)
+	switch {
+	case *fromGeoJSON != "":
+		sources = append(sources, *fromGeoJSON)
+	case len(sources) == 0:
+		sources = append(sources, *dataset)
+	}
+	sources = append(sources, changes...)
+
+	// Steps 1-4 for each profile: parse, build, filter, contract.
+	chOpt := ch.Options{
+		ExactPriority:       *exactPriority,
+		Customizable:        *cch,
+		CheckpointInterval:  *checkpointInterval,
+		MaxShortcutsPerNode: *maxShortcuts,
+		CoreFraction:        *coreFraction,
+		WitnessSettled:      *witnessSettled,
+		WitnessHops:         *witnessHops,
+		AdaptiveWitness:     *adaptiveWitness,
+	}
+	if *maxShortcuts <= 0 || *witnessSettled <= 0 || *witnessHops <= 0 {
+		log.Fatalf("Invalid --max-shortcuts, --witness-settled or --witness-hops (expected positive)")
+	}
+	if *coreFraction < 0 || *coreFraction >= 1 {
+		log.Fatalf("Invalid --core-fraction %v (expected at least 0 and below 1)", *coreFraction)
+	}
+	priority, err := parsePriorityWeights(*priorityWeights)
 	if err != nil {
-		log.Fatalf("Failed to open input file: %v", err)
+		log.Fatalf("Invalid --priority-weights %q: %v", *priorityWeights, err)
+	}
+	chOpt.Priority = priority
+	buildTime := time.Now().UTC()
+	if *deterministic {
+		if *checkpoint != "" {
+			log.Fatal("--deterministic cannot be combined with --checkpoint")
+		}
+		if buildTime, err = sourceDateEpoch(); err != nil {
+			log.Fatalf("Invalid SOURCE_DATE_EPOCH: %v", err)
+		}
+	}
+	progress, err := newProgressReporter(*progressMode)
+	if err != nil {
+		log.Fatalf("Invalid --progress %q: %v", *progressMode, err)
+	}
+	switch *orderName {
+	case "greedy":
+	case "nd":
+		chOpt.Order = ch.OrderNestedDissection
+	default:
+		log.Fatalf("Invalid --order %q (expected greedy or nd)", *orderName)
+	}
+	graphs := make(map[string]*graph.CHGraph, len(profiles))
+	var chResult *graph.CHGraph
+	for i, profile := range profiles {
+		name := profile.Name()
+		var chg *graph.CHGraph
+		var err error
+		chOpt.Checkpoint = *checkpoint
+		if *checkpoint != "" && len(profiles) > 1 {
+			chOpt.Checkpoint += "." + name
+		}
+		if *fromGeoJSON != "" {
+			name = "geojson"
+			geoOpt := graph.GeoJSONOptions{SpeedKmh: *geojsonSpeed}
+			if *distance {
+				geoOpt.SpeedKmh = 0
+			}
+			chOpt.Progress = progress.report(name)
+			chg, err = buildGeoJSONCH(ctx, *fromGeoJSON, geoOpt, *minComponent, *hilbert, chOpt)
+		} else {
+			opts.Profile, opts.Speeds = profile, speedTables[i]
+			log.Printf("Using the %s profile", name)
+			chOpt.Progress = progress.report(name)
+			chg, err = buildCH(ctx, inputs, *dataset, changes, opts, *minComponent, *hilbert, chOpt)
+		}
+		if ctx.Err() != nil {
+			log.Fatal("Interrupted; no output written")
+		}
+		if err != nil {
+			log.Fatalf("Failed to build the %s graph: %v", name, err)
+		}
+		if *rankOrder {
+			if chg, err = graph.RenumberByRank(chg); err != nil {
+				log.Fatalf("Failed to renumber the %s graph: %v", name, err)
+			}
+		}
+		if chg.Meta, err = buildMetadata(sources, name, *distance, buildTime); err != nil {
+			log.Fatalf("Failed to build metadata: %v", err)
+		}
+		if *snapIndex && !split {
+			chg.SnapIndex = routing.BuildSnapIndex(chg.OrigGraph())
+		}
+		log.Printf("The %s graph:\n%s", name, graph.Stats(chg.OrigGraph()))
+		graphs[name], chResult = chg, chg
+	}
+	var distCH *graph.CHGraph
+	if *outputDistance != "" {
+		chOpt.Progress = progress.report("distance")
+		var err error
+		if distCH, err = contractDistance(ctx, chResult, chOpt); ctx.Err() != nil {
+			log.Fatal("Interrupted; no output written")
+		} else if err != nil {
+			log.Fatalf("Failed to build the distance graph: %v", err)
+		}
+		if distCH.Meta, err = buildMetadata(sources, chResult.Meta.Profiles[0], true, buildTime); err != nil {
+			log.Fatalf("Failed to build metadata: %v", err)
+		}
+		distCH.SnapIndex = chResult.SnapIndex
 	}
-	defer f.Close()
 
-	log.Println("Parsing OSM data...")
-	parseResult, err := osmparser.Parse(context.Background(), f, opts)
+	// Step 5: Serialize to binary — one combined file, a split base +
+	// overlay pair, or a bundle of several profiles.
+	go func() {
+		<-ctx.Done()
+		for _, path := range []string{*output, *outputBase, *outputOverlay, *outputDistance, *outputTiles} {
+			if path != "" {
+				os.Remove(path + ".tmp")
+			}
+		}
+		log.Fatal("Interrupted while writing; partial output removed")
+	}()
+	switch {
+	case len(graphs) > 1:
+		log.Printf("Writing %d-profile bundle to %s...", len(graphs), *output)
+		if err := graph.WriteBundle(*output, graphs, graph.WriteOptions{Compress: *compress, OSMIDs: *osmIDs}); err != nil {
+			log.Fatalf("Failed to write bundle: %v", err)
+		}
+		logSize("output", *output)
+	case split:
+		log.Printf("Writing base to %s and overlay to %s...", *outputBase, *outputOverlay)
+		if err := graph.WriteBase(*outputBase, chResult); err != nil {
+			log.Fatalf("Failed to write base: %v", err)
+		}
+		if err := graph.WriteOverlay(*outputOverlay, chResult); err != nil {
+			log.Fatalf("Failed to write overlay: %v", err)
+		}
+		logSize("base", *outputBase)
+		logSize("overlay", *outputOverlay)
+		if distCH != nil {
+			log.Printf("Writing distance overlay to %s...", *outputDistance)
+			if err := graph.WriteOverlay(*outputDistance, distCH); err != nil {
+				log.Fatalf("Failed to write distance overlay: %v", err)
+			}
+			logSize("distance overlay", *outputDistance)
+		}
+	default:
+		log.Printf("Writing binary to %s...", *output)
+		if err := graph.WriteBinaryWith(*output, chResult, graph.WriteOptions{Compress: *compress, OSMIDs: *osmIDs}); err != nil {
+			log.Fatalf("Failed to write binary: %v", err)
+		}
+		logSize("output", *output)
+		if distCH != nil {
+			log.Printf("Writing distance binary to %s...", *outputDistance)
+			if err := graph.WriteBinaryWith(*outputDistance, distCH, graph.WriteOptions{Compress: *compress, OSMIDs: *osmIDs}); err != nil {
+				log.Fatalf("Failed to write distance binary: %v", err)
+			}
+			logSize("distance output", *outputDistance)
+		}
+	}
+	if err := exportGraph(chResult.OrigGraph(), exports); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	if *outputTiles != "" {
+		log.Printf("Writing %g° tiles to %s...", *tileSize, *outputTiles)
+		if err := graph.WriteTiled(*outputTiles, chResult.OrigGraph(), *tileSize, chResult.Meta); err != nil {
+			log.Fatalf("Failed to write tiles: %v", err)
+		}
+		logSize("tiles", *outputTiles)
+	}
+	log.Printf("Done in %s.", time.Since(start).Round(time.Second))
+}
+
+// truckDims are the --truck-* vehicle dimensions (0 = not checked).
+type truckDims struct {
+	weight, height, width, length float64
+}
+
+func (d truckDims) set() bool {
+	return d.weight > 0 || d.height > 0 || d.width > 0 || d.length > 0
+}
+
+// resolveProfile looks up a profile by name with its speed table: the
+// built-in defaults, overlaid with speedsPath when given, or none for
+// distance builds. An hgv profile gets the truck's dimensions.
+func resolveProfile(name, speedsPath string, distance bool, truck truckDims) (osmparser.Profile, osmparser.SpeedTable, error) {
+	// Resolve the profile first: the speed table is read for its canonical name.
+	profile, err := osmparser.ProfileByName(name, osmparser.SpeedTable{})
+	if err != nil {
+		return nil, osmparser.SpeedTable{}, err
+	}
+	var tbl osmparser.SpeedTable
+	switch {
+	case distance:
+	case speedsPath != "":
+		if tbl, err = osmparser.LoadSpeedTableFor(speedsPath, profile.Name()); err != nil {
+			return nil, tbl, fmt.Errorf("load speed table: %w", err)
+		}
+		log.Printf("Using %s speed table from %s", profile.Name(), speedsPath)
+	default:
+		tbl = osmparser.DefaultSpeedTableFor(profile.Name())
+		log.Printf("Using built-in default %s speed table", profile.Name())
+	}
+	profile, _ = osmparser.ProfileByName(profile.Name(), tbl)
+	if hgv, ok := profile.(osmparser.HGVProfile); ok {
+		hgv.Weight, hgv.Height, hgv.Width, hgv.Length = truck.weight, truck.height, truck.width, truck.length
+		profile = hgv
+	}
+	return profile, tbl, nil
+}
+
+// buildCH runs steps 1-4 of a build for opts.Profile: parse the inputs, build
+// the graph, drop bridging restricted clusters and disconnected fragments, and
+// contract it.
+func buildCH(ctx context.Context, inputs []string, dataset string, changes []string, opts osmparser.ParseOptions, minComponent int, hilbert bool, chOpt ch.Options) (*graph.CHGraph, error) {
+	// Step 1: Parse OSM data.
+	parseResult, err := parseInputs(ctx, inputs, dataset, changes, opts)
 	if err != nil {
-		log.Fatalf("Failed to parse OSM data: %v", err)
+		return nil, fmt.Errorf("parse OSM data: %w", err)
 	}
-	log.Printf("Parsed %d edges, %d nodes", len(parseResult.Edges), len(parseResult.NodeLat))
+	log.Printf("Parsed %d edges, %d nodes", len(parseResult.Edges), parseResult.NumNodes())
 
 	// Step 2: Build graph.
 	log.Println("Building graph...")
@@ -114,47 +522,178 @@ func main() {
 	log.Printf("Private-road filter: %d -> %d edges (dropped %d bridging-restricted)",
 		beforeEdges, g.NumEdges, beforeEdges-g.NumEdges)
 
+	return contractComponents(ctx, g, minComponent, hilbert, chOpt)
+}
+
+// buildGeoJSONCH builds a CH graph from a GeoJSON road network: load, then
+// the same component extraction and contraction as buildCH.
+func buildGeoJSONCH(ctx context.Context, path string, opt graph.GeoJSONOptions, minComponent int, hilbert bool, chOpt ch.Options) (*graph.CHGraph, error) {
+	log.Printf("Loading GeoJSON road network from %s...", path)
+	g, err := graph.LoadGeoJSON(path, opt)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Graph: %d nodes, %d edges", g.NumNodes, g.NumEdges)
+	return contractComponents(ctx, g, minComponent, hilbert, chOpt)
+}
+
+// reportComponents logs the largest components of a graph and warns about
+// sizeable ones being dropped, such as an island's road network, which
+// --min-component would keep.
+func reportComponents(comps *graph.Components, top []graph.ComponentStats, minComponent int) {
+	log.Printf("Strongly-connected components: %d; the largest:", comps.Len())
+	for i, c := range top {
+		kept := i == 0 || minComponent > 0 && c.Nodes >= uint32(minComponent)
+		status := "kept"
+		if !kept {
+			status = "dropped"
+		}
+		b := c.BBox
+		log.Printf("  #%d: %d nodes in %.4f,%.4f - %.4f,%.4f (%s)", i+1, c.Nodes, b.MinLat, b.MinLon, b.MaxLat, b.MaxLon, status)
+		// A dropped component of 1% of the main network is no stray
+		// fragment; at 100 nodes or more it is likely a real road network.
+		if !kept && c.Nodes >= 100 && c.Nodes >= top[0].Nodes/100 {
+			log.Printf("Warning: dropping component #%d (%d nodes); pass --min-component %d or lower to keep it", i+1, c.Nodes, c.Nodes)
+		}
+	}
+}
+
+// contractComponents keeps the connected road network(s) of g, renumbers
+// them and contracts the result (steps 3-4 of buildCH), unless ctx is
+// cancelled first.
+func contractComponents(ctx context.Context, g *graph.Graph, minComponent int, hilbert bool, chOpt ch.Options) (*graph.CHGraph, error) {
 	// Step 3: Extract connected road network(s).
 	beforeComponent := g.NumNodes
 	var componentNodes []uint32
-	if *minComponent > 0 {
-		log.Printf("Extracting all strongly-connected components with >= %d nodes...", *minComponent)
-		componentNodes = graph.LargeComponents(g, uint32(*minComponent))
+	comps := graph.StronglyConnected(g)
+	if minComponent > 0 {
+		log.Printf("Extracting all strongly-connected components with >= %d nodes...", minComponent)
+		componentNodes = comps.AtLeast(uint32(minComponent))
 	} else {
 		log.Println("Extracting largest connected component...")
-		componentNodes = graph.LargestComponent(g)
+		componentNodes = comps.Largest()
 	}
+	reportComponents(comps, comps.Top(g, 5), minComponent)
 	log.Printf("Kept %d nodes (%.1f%%); dropped %d disconnected/fragment nodes",
 		len(componentNodes), float64(len(componentNodes))/float64(beforeComponent)*100,
 		int(beforeComponent)-len(componentNodes))
+	if hilbert {
+		// Filtering renumbers the nodes anyway; give it the curve's order.
+		graph.SortHilbert(g, componentNodes)
+	}
 	g = graph.FilterToComponent(g, componentNodes)
 	log.Printf("Filtered graph: %d nodes, %d edges", g.NumNodes, g.NumEdges)
 
 	// Step 4: Contract CH.
 	log.Println("Running Contraction Hierarchies...")
-	chResult := ch.Contract(g)
+	chResult, err := ch.ContractContext(ctx, g, chOpt)
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("CH complete: %d fwd edges, %d bwd edges", len(chResult.FwdHead), len(chResult.BwdHead))
+	return chResult, nil
+}
 
-	// Step 5: Serialize to binary — either one combined file or a split
-	// base + overlay pair.
-	if split {
-		log.Printf("Writing base to %s and overlay to %s...", *outputBase, *outputOverlay)
-		if err := graph.WriteBase(*outputBase, chResult); err != nil {
-			log.Fatalf("Failed to write base: %v", err)
+// contractDistance weighs the roads of chg by length, with the penalties
+// of the restricted clusters that would be shortcuts, and contracts them in
+// chg's order, for a distance graph sharing its ranks and, when split, its
+// base.
+func contractDistance(ctx context.Context, chg *graph.CHGraph, chOpt ch.Options) (*graph.CHGraph, error) {
+	g := chg.OrigGraph()
+	g.EdgePenalty = chg.EdgePenalty
+	g.Weight = g.DistanceWeights()
+	log.Println("Contracting the distance metric in the same order...")
+	dist, err := ch.ContractRanked(ctx, g, chg.Rank, chOpt)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Distance CH complete: %d fwd edges, %d bwd edges", len(dist.FwdHead), len(dist.BwdHead))
+	return dist, nil
+}
+
+// parseInputs produces the edges to build from. Without a dataset path the
+// inputs are parsed directly. With one, the dataset is parsed from the inputs
+// (or loaded when there are none), brought up to date with the change files,
+// saved, and turned into edges.
+// parsePriorityWeights parses --priority-weights: three comma-separated
+// integers, not all zero.
+func parsePriorityWeights(s string) (ch.PriorityWeights, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return ch.PriorityWeights{}, fmt.Errorf("expected three comma-separated weights")
+	}
+	var w [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return ch.PriorityWeights{}, err
 		}
-		if err := graph.WriteOverlay(*outputOverlay, chResult); err != nil {
-			log.Fatalf("Failed to write overlay: %v", err)
+		w[i] = v
+	}
+	if w == [3]int{} {
+		return ch.PriorityWeights{}, fmt.Errorf("all weights are zero")
+	}
+	return ch.PriorityWeights{EdgeDifference: w[0], ContractedNeighbors: w[1], Level: w[2]}, nil
+}
+
+func parseInputs(ctx context.Context, inputs []string, datasetPath string, changes []string, opts osmparser.ParseOptions) (*osmparser.ParseResult, error) {
+	log.Println("Opening OSM file(s)...")
+	readers := make([]io.ReadSeeker, len(inputs))
+	for i, path := range inputs {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
 		}
-		logSize("base", *outputBase)
-		logSize("overlay", *outputOverlay)
+		defer f.Close()
+		readers[i] = f
+	}
+	if datasetPath == "" {
+		log.Println("Parsing OSM data...")
+		return osmparser.ParseAll(ctx, readers, opts)
+	}
+
+	var ds *osmparser.Dataset
+	var err error
+	if len(readers) > 0 {
+		log.Println("Parsing OSM data into a dataset...")
+		ds, err = osmparser.ParseDataset(ctx, readers, opts)
 	} else {
-		log.Printf("Writing binary to %s...", *output)
-		if err := graph.WriteBinary(*output, chResult); err != nil {
-			log.Fatalf("Failed to write binary: %v", err)
+		log.Printf("Loading dataset from %s...", datasetPath)
+		ds, err = osmparser.ReadDataset(datasetPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range changes {
+		stats, err := applyChangeFile(ds, path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Applied %s: %d created, %d modified, %d deleted", path, stats.Created, stats.Modified, stats.Deleted)
+		if stats.MissingNodes > 0 {
+			log.Printf("Warning: %d road nodes lack coordinates after %s; their segments are skipped until the next full parse", stats.MissingNodes, path)
 		}
-		logSize("output", *output)
 	}
-	log.Printf("Done in %s.", time.Since(start).Round(time.Second))
+	if len(readers) > 0 || len(changes) > 0 {
+		log.Printf("Saving dataset to %s...", datasetPath)
+		if err := osmparser.WriteDataset(datasetPath, ds); err != nil {
+			return nil, fmt.Errorf("write dataset: %w", err)
+		}
+	}
+	return ds.Result(opts), nil
+}
+
+func applyChangeFile(ds *osmparser.Dataset, path string) (osmparser.ChangeStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return osmparser.ChangeStats{}, err
+	}
+	defer f.Close()
+	stats, err := ds.ApplyChange(f)
+	if err != nil {
+		return stats, fmt.Errorf("%s: %w", path, err)
+	}
+	return stats, nil
 }
 
 // splitCombined reads an existing combined graph binary and re-serializes it as a
@@ -182,9 +721,195 @@ func splitCombined(combinedPath, basePath, overlayPath string) error {
 	return nil
 }
 
+// upgradeCombined rewrites a combined graph binary of an older format version
+// (see graph.Upgrade) in the current one.
+func upgradeCombined(oldPath, newPath string, compress bool) error {
+	log.Printf("Upgrading %s to %s...", oldPath, newPath)
+	if err := graph.Upgrade(oldPath, newPath, graph.WriteOptions{Compress: compress}); err != nil {
+		return err
+	}
+	logSize("output", newPath)
+	return nil
+}
+
+// graphExport is one requested export of the built graph.
+type graphExport struct {
+	format string
+	path   string
+	write  func(io.Writer, *graph.Graph) error
+}
+
+// exportGraph writes g to every export in turn.
+func exportGraph(g *graph.Graph, exports []graphExport) error {
+	for _, e := range exports {
+		log.Printf("Exporting %d nodes, %d edges as %s to %s...", g.NumNodes, g.NumEdges, e.format, e.path)
+		f, err := os.Create(e.path)
+		if err != nil {
+			return err
+		}
+		if err := e.write(f, g); err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %w", e.format, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		logSize(e.format, e.path)
+	}
+	return nil
+}
+
+// buildMetadata describes this run for the graph's metadata footer: the input
+// files and their SHA-256 digests (comma-separated, in the order they were
+// read, when there are several), every build flag set on the command line, the
+// profile and metric the edges were built for, the preprocess build, and
+// buildTime.
+func buildMetadata(inputs []string, profile string, distance bool, buildTime time.Time) (*graph.Metadata, error) {
+	digests := make([]string, len(inputs))
+	for i, input := range inputs {
+		d, err := fileSHA256(input)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = d
+	}
+
+	params := make(map[string]string)
+	flag.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "bbox", "singapore", "kl", "boundary", "speeds", "truck-weight", "truck-height", "truck-width", "truck-length", "distance", "access-time", "private-penalty", "keep-parallel", "keep-reversible", "min-component", "hilbert", "rank-order", "exact-priority", "cch", "order", "max-shortcuts", "core-fraction", "witness-settled", "witness-hops", "adaptive-witness", "priority-weights", "geojson-speed", "overpass-url", "elevation", "terrain-tiles", "terrain-zoom":
+			params[fl.Name] = fl.Value.String()
+		}
+	})
+
+	metric := "time"
+	if distance {
+		metric = "distance"
+	}
+	return &graph.Metadata{
+		BuildTime:    buildTime,
+		ToolVersion:  toolVersion(),
+		SourceFile:   strings.Join(inputs, ","),
+		SourceSHA256: strings.Join(digests, ","),
+		Params:       params,
+		Profiles:     []string{profile},
+		Metric:       metric,
+	}, nil
+}
+
+// sourceDateEpoch returns the build time --deterministic records:
+// $SOURCE_DATE_EPOCH, the reproducible-builds convention, or the zero time.
+func sourceDateEpoch() (time.Time, error) {
+	env := os.Getenv("SOURCE_DATE_EPOCH")
+	if env == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(env, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// toolVersion identifies this preprocess binary: its module version and, when
+// built from a git checkout, the commit (suffixed "+dirty" for uncommitted
+// changes). Empty when the build carries no information.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var rev string
+	var dirty bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	v := info.Main.Version
+	if rev != "" {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if dirty {
+			rev += "+dirty"
+		}
+		v += " (" + rev + ")"
+	}
+	return v
+}
+
 // logSize prints the on-disk size of a just-written file.
 func logSize(label, path string) {
 	if info, err := os.Stat(path); err == nil {
 		log.Printf("  %s: %s (%.1f MB)", label, path, float64(info.Size())/(1024*1024))
 	}
 }
+
+// logProgress returns a parser progress callback that logs each phase change
+// and otherwise at most once per interval, so multi-minute passes over large
+// extracts are not silent.
+func logProgress(interval time.Duration) func(osmparser.Progress) {
+	var phase osmparser.Phase
+	var last time.Time
+	return func(p osmparser.Progress) {
+		if p.Phase == phase && time.Since(last) < interval {
+			return
+		}
+		phase, last = p.Phase, time.Now()
+		switch p.Phase {
+		case osmparser.PhaseEdges:
+			log.Println("Building edges...")
+		default:
+			log.Printf("Reading %s: %.1f%% (%d / %d MB); %d ways, %d relations, %d nodes scanned",
+				p.Phase, max(p.Fraction(), 0)*100, p.BytesRead>>20, p.TotalBytes>>20, p.Ways, p.Relations, p.Nodes)
+		}
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadOverpass fetches the profile's roads inside bbox/boundary from the
+// Overpass API at endpoint and saves them as OSM XML at path, which then
+// stands in for --input (and so is hashed into the build metadata).
+func downloadOverpass(ctx context.Context, endpoint, path string, profile osmparser.Profile, bbox osmparser.BBox, boundary *osmparser.Boundary) error {
+	if bbox.IsZero() && boundary == nil {
+		return fmt.Errorf("--overpass needs an area: --bbox, --singapore, --kl or --boundary")
+	}
+	log.Printf("Downloading %s roads from %s...", profile.Name(), endpoint)
+	client := &osmparser.OverpassClient{Endpoint: endpoint}
+	data, err := client.FetchArea(ctx, profile.Highways(), bbox, boundary)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Saved %.1f MB of OSM XML to %s", float64(len(data))/(1<<20), path)
+	return nil
+}
+
+// pathList collects a repeated path flag.
+type pathList []string
+
+func (l *pathList) String() string { return strings.Join(*l, ",") }
+
+func (l *pathList) Set(path string) error {
+	*l = append(*l, path)
+	return nil
+}
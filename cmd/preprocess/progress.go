@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/azybler/map_router/pkg/ch"
+)
+
+// progressBarWidth is the number of cells in the --progress bar.
+const progressBarWidth = 30
+
+// progressReporter shows contraction progress for --progress: as a bar
+// redrawn in place on stderr, or as JSON lines on stdout for CI logs.
+// Reports are throttled; the last of each contraction always goes out.
+type progressReporter struct {
+	json     bool
+	interval time.Duration
+
+	mu   sync.Mutex
+	out  io.Writer
+	last time.Time
+	bar  string // the bar on screen, redrawn after log lines
+}
+
+// progressLine is one --progress json line.
+type progressLine struct {
+	Profile    string  `json:"profile"`
+	Contracted uint32  `json:"contracted"`
+	Nodes      uint32  `json:"nodes"`
+	Shortcuts  int     `json:"shortcuts"`
+	Core       uint32  `json:"core"`
+	ElapsedS   float64 `json:"elapsed_s"`
+	ETAS       float64 `json:"eta_s"`
+	Done       bool    `json:"done"`
+}
+
+// newProgressReporter returns the reporter for a --progress mode, nil for
+// none. The bar takes over the log's output so log lines do not run into it.
+func newProgressReporter(mode string) (*progressReporter, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "bar":
+		r := &progressReporter{out: os.Stderr, interval: 100 * time.Millisecond}
+		log.SetOutput(r)
+		return r, nil
+	case "json":
+		return &progressReporter{json: true, out: os.Stdout, interval: time.Second}, nil
+	}
+	return nil, fmt.Errorf("expected bar or json")
+}
+
+// report returns the ch.Options.Progress callback for a profile's
+// contraction; nil without a reporter.
+func (r *progressReporter) report(profile string) func(ch.Progress) {
+	if r == nil {
+		return nil
+	}
+	return func(p ch.Progress) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if !p.Done && time.Since(r.last) < r.interval {
+			return
+		}
+		r.last = time.Now()
+		if r.json {
+			json.NewEncoder(r.out).Encode(progressLine{
+				Profile:    profile,
+				Contracted: p.Contracted,
+				Nodes:      p.Nodes,
+				Shortcuts:  p.Shortcuts,
+				Core:       p.Core,
+				ElapsedS:   p.Elapsed.Seconds(),
+				ETAS:       p.ETA.Seconds(),
+				Done:       p.Done,
+			})
+			return
+		}
+		r.bar = progressBar(profile, p)
+		fmt.Fprintf(r.out, "\r\033[K%s", r.bar)
+		if p.Done {
+			fmt.Fprintln(r.out)
+			r.bar = ""
+		}
+	}
+}
+
+// Write writes a log line above the bar.
+func (r *progressReporter) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bar == "" {
+		return r.out.Write(b)
+	}
+	fmt.Fprint(r.out, "\r\033[K")
+	n, err := r.out.Write(b)
+	fmt.Fprint(r.out, r.bar)
+	return n, err
+}
+
+// progressBar draws p as one line, e.g.
+// "car [#########.....] 64.3% 46000/71526 nodes, 98000 shortcuts, ETA 12s".
+func progressBar(profile string, p ch.Progress) string {
+	frac := 0.0
+	if p.Nodes > 0 {
+		frac = float64(p.Contracted) / float64(p.Nodes)
+	}
+	filled := int(frac * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+	s := fmt.Sprintf("%s [%s] %5.1f%% %d/%d nodes, %d shortcuts", profile, bar, 100*frac, p.Contracted, p.Nodes, p.Shortcuts)
+	switch {
+	case p.Done:
+		s += fmt.Sprintf(", %d core nodes, took %s", p.Core, p.Elapsed.Round(time.Second))
+	case p.ETA > 0:
+		s += fmt.Sprintf(", ETA %s", p.ETA.Round(time.Second))
+	}
+	return s
+}
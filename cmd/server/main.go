@@ -1,25 +1,63 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/azybler/map_router/pkg/api"
+	"github.com/azybler/map_router/pkg/ch"
 	"github.com/azybler/map_router/pkg/graph"
+	osmparser "github.com/azybler/map_router/pkg/osm"
 	"github.com/azybler/map_router/pkg/routing"
 )
 
 func main() {
 	graphPath := flag.String("graph", "graph.bin", "Path to the time-metric graph: a combined binary, or a time overlay when --graph-base is set")
 	graphDistance := flag.String("graph-distance", "", "Optional distance graph: a combined binary, or a distance overlay when --graph-base is set; enables metric=\"distance\" routing")
+	profile := flag.String("profile", "car", "Profile to load when --graph/--graph-distance are multi-profile bundles (preprocess --profiles); other profiles in the file are not read")
+	validate := flag.Bool("validate", false, "Check each loaded graph's structure with graph.Validate before serving, and exit with the report if it has problems")
+	fastLoad := flag.Bool("fast-load", false, "Skip the whole-file checksum pass when loading combined graphs that carry per-section checksums; the sections are verified in the background once the server is up, and it exits if one fails")
+	tilesPath := flag.String("tiles", "", "Tiled graph file (preprocess --output-tiles) to load the time graph from instead of --graph; only the cells covering --region are read, and the region is contracted at startup")
+	region := flag.String("region", "", "Region to load from --tiles as minLat,minLng,maxLat,maxLng")
 	graphBase := flag.String("graph-base", "", "Optional shared base file (coords, topology, geometry). When set, --graph and --graph-distance are overlay files stitched onto this one base, so the base and its Snapper are held once in RAM instead of per metric")
 	port := flag.Int("port", 8080, "HTTP port")
 	corsOrigin := flag.String("cors-origin", "", "CORS allowed origin (empty = same-origin)")
+	basePath := flag.String("base-path", "", "Mount every route under this prefix (e.g. /routing serves /routing/api/v1/route), for hosting behind a shared ingress")
+	demo := flag.Bool("demo", false, "Serve a demo map page at /demo (click two points to route)")
+	healthProbe := flag.String("health-probe", "", "Deep health check route as startLat,startLng,endLat,endLng (default: two nodes of the loaded time graph)")
+	shedP99 := flag.Duration("shed-p99", 0, "Shed a share of route requests while observed p99 latency exceeds this (e.g. 250ms; 0 = disabled)")
+	shedQueueDepth := flag.Int("shed-queue-depth", 0, "Shed route requests beyond this many in flight (0 = disabled)")
+	maxBodyBytes := flag.Int64("max-body-bytes", 1024, "Maximum request body size in bytes; larger requests get 413 request_too_large")
+	cacheControl := map[string]string{}
+	flag.Func("cache-control", "Cache-Control for successful responses on one path, as path=value (e.g. \"/api/v1/stats=public, max-age=60\"); repeatable. Unlisted paths send no-store", func(s string) error {
+		path, value, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(path, "/") || value == "" {
+			return fmt.Errorf("expected path=value, got %q", s)
+		}
+		cacheControl[path] = value
+		return nil
+	})
+	var snapPolicy routing.SnapPolicy
+	flag.Func("snap-exclude", "Road classes never snapped to, as comma-separated OSM highway values (e.g. footway,steps)", func(s string) error {
+		var err error
+		snapPolicy.Exclude, err = parseRoadClasses(s)
+		return err
+	})
+	flag.Func("snap-avoid", "Road classes snapped to only when no road of another class is within --snap-avoid-meters, as comma-separated OSM highway values (e.g. service,track)", func(s string) error {
+		var err error
+		snapPolicy.Avoid, err = parseRoadClasses(s)
+		return err
+	})
+	flag.Float64Var(&snapPolicy.AvoidMeters, "snap-avoid-meters", 50, "Distance within which a road of another class rules out the --snap-avoid classes")
+	tokensPath := flag.String("tokens", "", "Optional JSON file of bearer tokens ({\"read\":[...],\"admin\":[...]}); when set, the query API requires a read or admin token")
+	ipFilterPath := flag.String("ip-filter", "", "Optional JSON file of CIDR allow/deny lists ({\"allow\":[...],\"deny\":[...]}); reloaded automatically when the file changes")
 	flag.Parse()
 
 	start := time.Now()
@@ -27,8 +65,17 @@ func main() {
 	// loadTime/loadDist resolve to either the combined path (each graph
 	// self-contained, its own Snapper) or the split path (one shared base +
 	// Snapper, per-metric overlays), depending on whether --graph-base is set.
-	loadTime := func() (*routing.Engine, *graph.CHGraph, error) { return loadEngine(*graphPath) }
-	loadDist := func() (*routing.Engine, *graph.CHGraph, error) { return loadEngine(*graphDistance) }
+	readOpt := graph.ReadOptions{FastLoad: *fastLoad}
+	loadTime := func() (*routing.Engine, *graph.CHGraph, error) { return loadEngine(*graphPath, *profile, readOpt) }
+	loadDist := func() (*routing.Engine, *graph.CHGraph, error) { return loadEngine(*graphDistance, *profile, readOpt) }
+
+	if *tilesPath != "" {
+		if *region == "" || *graphBase != "" || *graphDistance != "" {
+			log.Fatal("--tiles requires --region and cannot be combined with --graph-base or --graph-distance")
+		}
+		*graphPath = *tilesPath
+		loadTime = func() (*routing.Engine, *graph.CHGraph, error) { return loadTiledEngine(*tilesPath, *region) }
+	}
 
 	if *graphBase != "" {
 		log.Printf("Loading shared base from %s...", *graphBase)
@@ -36,7 +83,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to load base graph: %v", err)
 		}
-		// One Snapper over the base, shared by every metric engine (the grid
+		// One Snapper over the base, shared by every metric engine (the snap
 		// index is metric-independent).
 		sharedSnapper := routing.NewSnapper(base.Graph(nil))
 		log.Printf("Loaded base: %d nodes, %d orig edges (shared Snapper built)", base.NumNodes, len(base.OrigHead))
@@ -56,12 +103,15 @@ func main() {
 	}
 	log.Printf("Loaded time graph: %d nodes, %d fwd edges, %d bwd edges",
 		timeCHG.NumNodes, len(timeCHG.FwdHead), len(timeCHG.BwdHead))
+	timeEngine.SetSnapPolicy(snapPolicy)
 
 	// routers and availableMetrics are kept in lockstep: every metric registered
 	// in the map is also appended to availableMetrics (in a stable order), so the
 	// /stats advertisement can never drift from what the server can actually route.
 	routers := map[string]routing.Router{api.MetricTime: timeEngine}
 	availableMetrics := []string{api.MetricTime}
+	graphInfo := []api.GraphInfoJSON{graphInfoJSON(api.MetricTime, timeCHG)}
+	loaded := map[string]*graph.CHGraph{api.MetricTime: timeCHG}
 
 	// Load the distance graph (optional).
 	if *graphDistance != "" {
@@ -72,30 +122,82 @@ func main() {
 		}
 		log.Printf("Loaded distance graph: %d nodes, %d fwd edges, %d bwd edges",
 			distCHG.NumNodes, len(distCHG.FwdHead), len(distCHG.BwdHead))
+		distEngine.SetSnapPolicy(snapPolicy)
 		routers[api.MetricDistance] = distEngine
 		availableMetrics = append(availableMetrics, api.MetricDistance)
+		graphInfo = append(graphInfo, graphInfoJSON(api.MetricDistance, distCHG))
+		loaded[api.MetricDistance] = distCHG
+	}
+
+	if *validate {
+		for metric, chg := range loaded {
+			if r := graph.Validate(chg.OrigGraph()); !r.OK() {
+				log.Fatalf("The %s graph failed validation:\n%s", metric, r)
+			}
+		}
+		log.Printf("Validated %d graph(s)", len(loaded))
 	}
 
 	// Reclaim memory from init-time temporaries (R-tree construction doubles the
-	// heap each GC cycle). Return unused pages to the OS.
+	// heap each GC cycle; graphs written with preprocess --snap-index skip it).
+	// Return unused pages to the OS.
 	runtime.GC()
 	debug.FreeOSMemory()
 
 	log.Printf("Ready in %s (metrics: %v)", time.Since(start).Round(time.Millisecond), availableMetrics)
 
+	if *fastLoad {
+		go verifySections(loaded)
+	}
+
 	// Setup HTTP server.
 	addr := fmt.Sprintf(":%d", *port)
 	cfg := api.DefaultConfig(addr)
 	cfg.CORSOrigin = *corsOrigin
+	cfg.BasePath = *basePath
+	cfg.Demo = *demo
+	cfg.DemoCenter = graphCenter(timeCHG)
+	cfg.ShedP99Latency = *shedP99
+	cfg.ShedQueueDepth = *shedQueueDepth
+	cfg.MaxBodyBytes = *maxBodyBytes
+	cfg.CacheControl = cacheControl
+	if *ipFilterPath != "" {
+		ipf, err := api.NewIPFilter(*ipFilterPath)
+		if err != nil {
+			log.Fatalf("Failed to load IP filter: %v", err)
+		}
+		go ipf.Watch(context.Background(), 5*time.Second)
+		cfg.IPFilter = ipf
+		log.Printf("IP filter loaded from %s (hot-reloaded on change)", *ipFilterPath)
+	}
+
+	if *tokensPath != "" {
+		auth, err := api.NewTokenAuth(*tokensPath)
+		if err != nil {
+			log.Fatalf("Failed to load tokens: %v", err)
+		}
+		cfg.Auth = auth
+		log.Printf("Token authentication enabled from %s", *tokensPath)
+		if *demo {
+			log.Printf("Not serving /demo: its page cannot send a token to the API")
+		}
+	}
 
 	stats := api.StatsResponse{
 		NumNodes:         timeCHG.NumNodes,
 		NumFwdEdges:      len(timeCHG.FwdHead),
 		NumBwdEdges:      len(timeCHG.BwdHead),
 		AvailableMetrics: availableMetrics,
+		Graph:            graphStatsJSON(graph.Stats(timeCHG.OrigGraph())),
 	}
 
 	handlers := api.NewHandlersMulti(routers, stats)
+	probeStart, probeEnd, err := resolveHealthProbe(*healthProbe, timeCHG)
+	if err != nil {
+		log.Fatalf("Invalid --health-probe: %v", err)
+	}
+	handlers.SetHealthProbe(probeStart, probeEnd)
+	handlers.SetGraphInfo(graphInfo)
 	srv := api.NewServer(cfg, handlers)
 
 	if err := api.ListenAndServe(srv); err != nil {
@@ -104,26 +206,74 @@ func main() {
 	}
 }
 
-// loadEngine reads a CH graph binary and builds a routing engine over it,
-// reconstructing the original graph needed for snapping and geometry.
-func loadEngine(path string) (*routing.Engine, *graph.CHGraph, error) {
-	chg, err := graph.ReadBinary(path)
+// loadEngine reads a CH graph binary, or the given profile of a multi-profile
+// bundle, and builds a routing engine over it, reconstructing the original
+// graph needed for snapping and geometry.
+func loadEngine(path, profile string, opt graph.ReadOptions) (*routing.Engine, *graph.CHGraph, error) {
+	chg, err := readGraph(path, profile, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	orig := chg.OrigGraph()
+	if chg.SnapIndex != nil {
+		snapper, err := routing.NewSnapperFromIndex(orig, chg.SnapIndex)
+		if err == nil {
+			return routing.NewEngineWithSnapper(chg, orig, snapper), chg, nil
+		}
+		log.Printf("Ignoring the stored snap index of %s (%v); building one", path, err)
+	}
+	return routing.NewEngine(chg, orig), chg, nil
+}
+
+// loadTiledEngine reads the cells of a tiled graph that cover region, keeps
+// the largest strongly connected component (roads cut at the region's rim
+// leave dead ends) and contracts it.
+func loadTiledEngine(path, region string) (*routing.Engine, *graph.CHGraph, error) {
+	var b graph.BBox
+	if _, err := fmt.Sscanf(region, "%f,%f,%f,%f", &b.MinLat, &b.MinLon, &b.MaxLat, &b.MaxLon); err != nil {
+		return nil, nil, fmt.Errorf("invalid --region (expected minLat,minLng,maxLat,maxLng): %w", err)
+	}
+	t, err := graph.OpenTiled(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer t.Close()
+	cells := t.CellsIn(b)
+	g, err := t.LoadCells(cells)
 	if err != nil {
 		return nil, nil, err
 	}
-	origGraph := &graph.Graph{
-		NumNodes:    chg.NumNodes,
-		NumEdges:    uint32(len(chg.OrigHead)),
-		FirstOut:    chg.OrigFirstOut,
-		Head:        chg.OrigHead,
-		Weight:      chg.OrigWeight,
-		NodeLat:     chg.NodeLat,
-		NodeLon:     chg.NodeLon,
-		GeoFirstOut: chg.GeoFirstOut,
-		GeoShapeLat: chg.GeoShapeLat,
-		GeoShapeLon: chg.GeoShapeLon,
-	}
-	return routing.NewEngine(chg, origGraph), chg, nil
+	if g.NumNodes == 0 {
+		return nil, nil, fmt.Errorf("no roads in region %s", region)
+	}
+	log.Printf("Loaded %d of %d cells: %d of %d nodes, %d edges", len(cells), t.NumCells(), g.NumNodes, t.NumNodes(), g.NumEdges)
+	g = graph.FilterToComponent(g, graph.LargestComponent(g))
+	log.Printf("Contracting %d nodes...", g.NumNodes)
+	chg := ch.Contract(g)
+	if t.Meta != nil {
+		meta := *t.Meta
+		meta.BBox = graph.NodeBBox(chg.NodeLat, chg.NodeLon)
+		chg.Meta = &meta
+	}
+	return routing.NewEngine(chg, chg.OrigGraph()), chg, nil
+}
+
+// readGraph reads a combined graph binary, or one profile of a bundle.
+// Bundles are always checked in full.
+func readGraph(path, profile string, opt graph.ReadOptions) (*graph.CHGraph, error) {
+	bundle, err := graph.IsBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bundle {
+		return graph.ReadBinaryWith(path, opt)
+	}
+	graphs, err := graph.ReadBundle(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded the %s profile from bundle %s", profile, path)
+	return graphs[profile], nil
 }
 
 // loadOverlayEngine stitches a metric overlay onto the shared base and builds an
@@ -138,3 +288,106 @@ func loadOverlayEngine(base *graph.BaseGraph, snapper *routing.Snapper, overlayP
 	origGraph := base.Graph(chg.OrigWeight)
 	return routing.NewEngineWithSnapper(chg, origGraph, snapper), chg, nil
 }
+
+// parseRoadClasses parses a comma-separated list of OSM highway values.
+func parseRoadClasses(s string) ([]graph.RoadClass, error) {
+	var classes []graph.RoadClass
+	for name := range strings.SplitSeq(s, ",") {
+		c := osmparser.ParseRoadClass(name)
+		if c.String() != name || name == "" {
+			return nil, fmt.Errorf("unknown road class %q", name)
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// verifySections checks fast-loaded graphs against their per-section
+// checksums while the server is already serving, and exits on a mismatch
+// rather than keep routing over a corrupt graph.
+func verifySections(graphs map[string]*graph.CHGraph) {
+	start := time.Now()
+	for metric, chg := range graphs {
+		if err := chg.VerifySections(); err != nil {
+			log.Fatalf("The %s graph failed verification: %v", metric, err)
+		}
+	}
+	log.Printf("Graph sections verified in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// resolveHealthProbe parses the --health-probe flag, or when it is empty picks
+// two nodes of the loaded graph. After component filtering every node lies in
+// the routable network, so the default probe exercises snapping, search and
+// unpacking without the operator having to know coordinates in the region.
+func resolveHealthProbe(spec string, chg *graph.CHGraph) (start, end routing.LatLng, err error) {
+	if spec != "" {
+		if _, err := fmt.Sscanf(spec, "%f,%f,%f,%f", &start.Lat, &start.Lng, &end.Lat, &end.Lng); err != nil {
+			return start, end, fmt.Errorf("expected startLat,startLng,endLat,endLng: %w", err)
+		}
+		return start, end, nil
+	}
+	if chg.NumNodes == 0 {
+		return start, end, nil
+	}
+	a, b := uint32(0), chg.NumNodes/2
+	start = routing.LatLng{Lat: chg.NodeLat[a].Deg(), Lng: chg.NodeLon[a].Deg()}
+	end = routing.LatLng{Lat: chg.NodeLat[b].Deg(), Lng: chg.NodeLon[b].Deg()}
+	return start, end, nil
+}
+
+// graphCenter returns the centre of the graph's bounding box, used to position
+// the demo map.
+func graphCenter(chg *graph.CHGraph) api.LatLngJSON {
+	b := graph.NodeBBox(chg.NodeLat, chg.NodeLon)
+	if b == nil {
+		return api.LatLngJSON{}
+	}
+	return api.LatLngJSON{Lat: (b.MinLat + b.MaxLat) / 2, Lng: (b.MinLon + b.MaxLon) / 2}
+}
+
+// graphInfoJSON describes a loaded graph for GET /api/v1/graph from its
+// metadata footer. Graphs built before metadata was recorded still report their
+// format version and a bounding box computed from the nodes.
+func graphInfoJSON(metric string, chg *graph.CHGraph) api.GraphInfoJSON {
+	info := api.GraphInfoJSON{Metric: metric}
+	meta := chg.Meta
+	if meta == nil {
+		meta = &graph.Metadata{}
+	}
+	info.FormatVersion = meta.FormatVersion
+	if !meta.BuildTime.IsZero() {
+		info.BuildTime = meta.BuildTime.UTC().Format(time.RFC3339)
+	}
+	info.ToolVersion = meta.ToolVersion
+	info.SourceFile = meta.SourceFile
+	info.SourceSHA256 = meta.SourceSHA256
+	info.Params = meta.Params
+	info.Profiles = meta.Profiles
+	b := meta.BBox
+	if b == nil {
+		b = graph.NodeBBox(chg.NodeLat, chg.NodeLon)
+	}
+	if b != nil {
+		info.BBox = &api.BBoxJSON{MinLat: b.MinLat, MinLng: b.MinLon, MaxLat: b.MaxLat, MaxLng: b.MaxLon}
+	}
+	return info
+}
+
+// graphStatsJSON is s for GET /api/v1/stats.
+func graphStatsJSON(s *graph.GraphStats) *api.GraphStatsJSON {
+	return &api.GraphStatsJSON{
+		NumEdges:         s.Edges,
+		OutDegree:        s.OutDegree,
+		InDegree:         s.InDegree,
+		WeightHistogram:  s.WeightHistogram,
+		MinWeight:        s.MinWeight,
+		MedianWeight:     s.MedianWeight,
+		MeanWeight:       s.MeanWeight,
+		MaxWeight:        s.MaxWeight,
+		ShapePoints:      s.ShapePoints,
+		StraightEdges:    s.StraightEdges,
+		NamedEdges:       s.NamedEdges,
+		TurnRestrictions: s.TurnRestrictions,
+		MemoryBytes:      s.Memory.Total(),
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"os"
 	"sync"
 	"time"
+
+	"github.com/azybler/map_router/pkg/geo"
 )
 
 //go:embed static
@@ -286,8 +288,13 @@ func queryGoogle(req compareRequest) routeResult {
 	leg := gResp.Routes[0].Legs[0]
 	var geometry [][]float64
 	for _, step := range leg.Steps {
-		points := decodePolyline(step.Polyline.Points)
-		geometry = append(geometry, points...)
+		points, err := geo.DecodePolyline(step.Polyline.Points, 5)
+		if err != nil {
+			return routeResult{Error: fmt.Sprintf("decode failed: %v", err)}
+		}
+		for _, p := range points {
+			geometry = append(geometry, []float64{p.Lat, p.Lng})
+		}
 	}
 
 	return routeResult{
@@ -297,57 +304,6 @@ func queryGoogle(req compareRequest) routeResult {
 	}
 }
 
-// decodePolyline decodes a Google encoded polyline string into [[lat, lng], ...].
-func decodePolyline(encoded string) [][]float64 {
-	var points [][]float64
-	lat, lng := 0, 0
-	i := 0
-	for i < len(encoded) {
-		// Decode latitude.
-		shift, result := uint(0), 0
-		for {
-			if i >= len(encoded) {
-				return points
-			}
-			b := int(encoded[i]) - 63
-			i++
-			result |= (b & 0x1f) << shift
-			shift += 5
-			if b < 0x20 {
-				break
-			}
-		}
-		if result&1 != 0 {
-			lat += ^(result >> 1)
-		} else {
-			lat += result >> 1
-		}
-
-		// Decode longitude.
-		shift, result = 0, 0
-		for {
-			if i >= len(encoded) {
-				return points
-			}
-			b := int(encoded[i]) - 63
-			i++
-			result |= (b & 0x1f) << shift
-			shift += 5
-			if b < 0x20 {
-				break
-			}
-		}
-		if result&1 != 0 {
-			lng += ^(result >> 1)
-		} else {
-			lng += result >> 1
-		}
-
-		points = append(points, []float64{float64(lat) / 1e5, float64(lng) / 1e5})
-	}
-	return points
-}
-
 func truncate(s string, n int) string {
 	if len(s) <= n {
 		return s
@@ -2,7 +2,10 @@ module github.com/azybler/map_router
 
 go 1.26.0
 
-require github.com/paulmach/osm v0.9.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/paulmach/osm v0.9.0
+)
 
 require (
 	github.com/DataDog/czlib v0.0.0-20240814115052-86a9592b3985 // indirect
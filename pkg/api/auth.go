@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// authScope is the credential a route requires.
+type authScope int
+
+const (
+	scopePublic authScope = iota // no token needed (health, preflight)
+	scopeRead                    // query API: route, stats, graph
+	scopeAdmin                   // operational endpoints, such as a traffic feed; none is built in yet
+)
+
+// TokenAuth enforces bearer tokens in two scopes, loaded from a JSON file:
+//
+//	{"read": ["q-7f3a..."], "admin": ["a-91c2..."]}
+//
+// Read tokens open the query API; admin tokens open the operational
+// endpoints and, as a superset, the query API too. Keeping the scopes apart
+// lets a traffic feed be automated with an admin token that query clients
+// never hold, and query keys be handed out without admin power.
+//
+// Only SHA-256 digests of the tokens are kept in memory, and lookups compare
+// digests, so the time taken does not depend on how much of a guessed token
+// matches a real one.
+type TokenAuth struct {
+	read  map[[sha256.Size]byte]struct{}
+	admin map[[sha256.Size]byte]struct{}
+}
+
+// NewTokenAuth loads read and admin tokens from path.
+func NewTokenAuth(path string) (*TokenAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tokens: %w", err)
+	}
+	var raw struct {
+		Read  []string `json:"read"`
+		Admin []string `json:"admin"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse tokens %s: %w", path, err)
+	}
+	if len(raw.Read) == 0 && len(raw.Admin) == 0 {
+		return nil, errors.New("tokens file defines no tokens")
+	}
+	a := &TokenAuth{
+		read:  make(map[[sha256.Size]byte]struct{}, len(raw.Read)),
+		admin: make(map[[sha256.Size]byte]struct{}, len(raw.Admin)),
+	}
+	for _, t := range raw.Read {
+		if t == "" {
+			return nil, errors.New("read: empty token")
+		}
+		a.read[sha256.Sum256([]byte(t))] = struct{}{}
+	}
+	for _, t := range raw.Admin {
+		if t == "" {
+			return nil, errors.New("admin: empty token")
+		}
+		a.admin[sha256.Sum256([]byte(t))] = struct{}{}
+	}
+	return a, nil
+}
+
+// authorize checks the request's bearer token against scope. It returns 0
+// when the request may proceed, 401 when the token is missing or unknown, and
+// 403 when it is valid but lacks the scope.
+func (a *TokenAuth) authorize(r *http.Request, scope authScope) int {
+	if scope == scopePublic {
+		return 0
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return http.StatusUnauthorized
+	}
+	sum := sha256.Sum256([]byte(token))
+	_, isAdmin := a.admin[sum]
+	_, isRead := a.read[sum]
+	switch {
+	case isAdmin:
+		return 0
+	case !isRead:
+		return http.StatusUnauthorized
+	case scope == scopeAdmin:
+		return http.StatusForbidden
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestAuth(t *testing.T) *TokenAuth {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	if err := os.WriteFile(path, []byte(`{"read":["r1"],"admin":["a1"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewTokenAuth(path)
+	if err != nil {
+		t.Fatalf("NewTokenAuth: %v", err)
+	}
+	return a
+}
+
+func TestTokenAuth_Authorize(t *testing.T) {
+	a := newTestAuth(t)
+
+	tests := []struct {
+		header string
+		scope  authScope
+		want   int
+	}{
+		{"", scopePublic, 0},
+		{"", scopeRead, http.StatusUnauthorized},
+		{"Bearer nope", scopeRead, http.StatusUnauthorized},
+		{"Basic r1", scopeRead, http.StatusUnauthorized},
+		{"Bearer r1", scopeRead, 0},
+		{"Bearer r1", scopeAdmin, http.StatusForbidden},
+		{"Bearer a1", scopeRead, 0},
+		{"Bearer a1", scopeAdmin, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := a.authorize(r, tt.scope); got != tt.want {
+			t.Errorf("authorize(%q, %d) = %d, want %d", tt.header, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestTokenAuth_RejectsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	os.WriteFile(path, []byte(`{}`), 0o600)
+	if _, err := NewTokenAuth(path); err == nil {
+		t.Error("expected error for a tokens file without tokens")
+	}
+}
+
+func TestTokenAuth_Middleware(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1, Auth: newTestAuth(t)},
+		NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/api/v1/stats", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("stats without token: status = %d, WWW-Authenticate = %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := get("/api/v1/stats", "r1"); w.Code != http.StatusOK {
+		t.Errorf("stats with read token: status = %d, want 200", w.Code)
+	}
+	if w := get("/api/v1/stats", "a1"); w.Code != http.StatusOK {
+		t.Errorf("stats with admin token: status = %d, want 200", w.Code)
+	}
+	if w := get("/api/v1/health", ""); w.Code != http.StatusOK {
+		t.Errorf("health must stay public: status = %d", w.Code)
+	}
+}
+
+func TestTokenAuth_AdminScope(t *testing.T) {
+	mux := http.NewServeMux()
+	rt := newRouteTable(mux, &middleware{cfg: ServerConfig{Auth: newTestAuth(t)}, sem: make(chan struct{}, 1)}, "")
+	rt.handle(http.MethodPost, "/api/v1/admin/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, scopeAdmin, false)
+	rt.finish()
+
+	post := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/admin/test", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("admin route without token: status = %d, want 401", w.Code)
+	}
+	w := post("r1")
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != http.StatusForbidden || err != nil || resp.Error != "insufficient_scope" {
+		t.Errorf("admin route with read token: status = %d, body = %s, want 403 insufficient_scope", w.Code, w.Body.String())
+	}
+	if w := post("a1"); w.Code != http.StatusNoContent {
+		t.Errorf("admin route with admin token: status = %d, want 204", w.Code)
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	_ "embed"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+//go:embed demo.html
+var demoPage string
+
+var demoTemplate = template.Must(template.New("demo").Parse(demoPage))
+
+// demoHandler serves a minimal Leaflet page (click two points, see the route)
+// centred on the given point, so a freshly built graph can be sanity-checked
+// from a browser without running cmd/visualize.
+func demoHandler(center LatLngJSON) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := demoTemplate.Execute(w, center); err != nil {
+			log.Printf("demo page: %v", err)
+		}
+	}
+}
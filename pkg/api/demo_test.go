@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDemoPage(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1, Demo: true, DemoCenter: LatLngJSON{Lat: 1.35, Lng: 103.82}},
+		NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/demo", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "1.35") || !strings.Contains(body, "103.82") {
+		t.Errorf("demo page not centred on the configured point")
+	}
+}
+
+func TestDemoPageDisabledByDefault(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1}, NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/demo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when the demo is disabled", w.Code)
+	}
+}
+
+func TestDemoPageNotServedWithAuth(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1, Demo: true, Auth: newTestAuth(t)},
+		NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/demo", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404: the page cannot authenticate its API calls", w.Code)
+	}
+}
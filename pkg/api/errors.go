@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/azybler/map_router/pkg/routing"
+)
+
+// ErrorDocsURL is the doc_url of every error response: the table of error
+// codes in the README. Operators hosting their own docs may replace it before
+// starting the server.
+var ErrorDocsURL = "https://github.com/azybler/map_router/blob/main/README.md#error-codes"
+
+// errorSpec describes one error code in the registry.
+type errorSpec struct {
+	status    int    // HTTP status the code is always sent with
+	message   string // default human-readable message
+	retryable bool   // whether repeating the identical request may succeed
+}
+
+// errorCodes is the registry of every error code the API returns, and the
+// single place codes are mapped to HTTP statuses. Clients branch on the code;
+// message is for humans and may change. Every code passed to writeError must
+// be listed here (enforced by TestErrorCodesRegistered).
+var errorCodes = map[string]errorSpec{
+	"invalid_request":         {status: http.StatusBadRequest, message: "The request is malformed."},
+	"invalid_coordinates":     {status: http.StatusBadRequest, message: "Coordinates must be finite and within lat [-90, 90], lng [-180, 180]."},
+	"metric_unavailable":      {status: http.StatusBadRequest, message: "The requested metric is not loaded on this server."},
+	"deep_health_unavailable": {status: http.StatusBadRequest, message: "No deep health probe is configured."},
+	"unauthorized":            {status: http.StatusUnauthorized, message: "A valid bearer token is required."},
+	"insufficient_scope":      {status: http.StatusForbidden, message: "The token does not grant access to this endpoint."},
+	"forbidden":               {status: http.StatusForbidden, message: "Requests from this address are not allowed."},
+	"no_route_found":          {status: http.StatusNotFound, message: "No path connects the two points."},
+	"method_not_allowed":      {status: http.StatusMethodNotAllowed, message: "The method is not supported on this path; see the Allow header."},
+	"request_too_large":       {status: http.StatusRequestEntityTooLarge, message: "The request body exceeds the server's size limit."},
+	"point_too_far_from_road": {status: http.StatusUnprocessableEntity, message: "A point is too far from any road to snap."},
+	"ambiguous_snap":          {status: http.StatusUnprocessableEntity, message: "A point is equally close to several roads; move it closer to the intended one."},
+	"internal_error":          {status: http.StatusInternalServerError, message: "An unexpected server error occurred.", retryable: true},
+	"request_timeout":         {status: http.StatusServiceUnavailable, message: "The request did not finish in time.", retryable: true},
+	"overloaded":              {status: http.StatusServiceUnavailable, message: "The server is shedding load; retry after the Retry-After delay.", retryable: true},
+	"service_unavailable":     {status: http.StatusServiceUnavailable, message: "The server is at its concurrency limit; retry after the Retry-After delay.", retryable: true},
+}
+
+// engineErrors maps errors returned by a routing.Router to API error codes,
+// checked in order with errors.Is. A Router error missing from this table is
+// logged and reported as internal_error, so new engine errors must be added
+// here to reach clients as anything more useful.
+var engineErrors = []struct {
+	err  error
+	code string
+}{
+	{routing.ErrPointTooFar, "point_too_far_from_road"},
+	{routing.ErrAmbiguousSnap, "ambiguous_snap"},
+	{routing.ErrNoRoute, "no_route_found"},
+	{context.Canceled, "request_timeout"},
+	{context.DeadlineExceeded, "request_timeout"},
+}
+
+// engineErrorCode returns the API error code for a Router error.
+func engineErrorCode(err error) string {
+	for _, e := range engineErrors {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	log.Printf("unmapped router error: %v", err)
+	return "internal_error"
+}
+
+// writeError writes a structured error for code, optionally naming the
+// offending request field.
+func writeError(w http.ResponseWriter, code, field string) {
+	var details []FieldError
+	if field != "" {
+		details = []FieldError{{Field: field}}
+	}
+	writeFieldErrors(w, code, details)
+}
+
+// writeFieldErrors writes a structured error for code with per-field detail.
+// The first detail's field is also reported in the top-level field, which
+// predates details and is kept for existing clients.
+func writeFieldErrors(w http.ResponseWriter, code string, details []FieldError) {
+	spec, ok := errorCodes[code]
+	if !ok {
+		log.Printf("unregistered error code %q", code)
+		spec = errorCodes["internal_error"]
+	}
+	resp := ErrorResponse{
+		Error:     code,
+		Message:   spec.message,
+		Retryable: spec.retryable,
+		DocURL:    ErrorDocsURL,
+		Details:   details,
+	}
+	if len(details) > 0 {
+		resp.Field = details[0].Field
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(spec.status)
+	json.NewEncoder(w).Encode(resp)
+}
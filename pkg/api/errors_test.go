@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/routing"
+)
+
+// TestErrorCodesRegistered keeps the registry complete: every code written by
+// the package must have an entry, or clients would get an empty message.
+func TestErrorCodesRegistered(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`write(?:Error|FieldErrors)\(w, "([a-z_]+)"`)
+	seen := 0
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range re.FindAllSubmatch(src, -1) {
+			seen++
+			if _, ok := errorCodes[string(m[1])]; !ok {
+				t.Errorf("%s: error code %q is not in errorCodes", f, m[1])
+			}
+		}
+	}
+	if seen == 0 {
+		t.Fatal("found no writeError calls; has the helper been renamed?")
+	}
+	for code, spec := range errorCodes {
+		if spec.message == "" || spec.status == 0 {
+			t.Errorf("error code %q needs a status and a message", code)
+		}
+	}
+}
+
+func TestWriteFieldErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeFieldErrors(w, "invalid_coordinates", []FieldError{
+		{Field: "start", Message: "coordinates out of range"},
+		{Field: "end", Message: "coordinates must be finite numbers"},
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Error != "invalid_coordinates" || resp.Field != "start" || resp.Message == "" || resp.DocURL == "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(resp.Details) != 2 || resp.Details[1].Field != "end" {
+		t.Errorf("details = %+v", resp.Details)
+	}
+	if resp.Retryable {
+		t.Error("invalid_coordinates must not be retryable")
+	}
+
+	w = httptest.NewRecorder()
+	writeError(w, "overloaded", "")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.Retryable {
+		t.Error("overloaded must be retryable")
+	}
+}
+
+func TestEngineErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{routing.ErrPointTooFar, "point_too_far_from_road"},
+		{fmt.Errorf("start: %w", routing.ErrAmbiguousSnap), "ambiguous_snap"},
+		{routing.ErrNoRoute, "no_route_found"},
+		{context.DeadlineExceeded, "request_timeout"},
+		{errors.New("disk on fire"), "internal_error"},
+	}
+	for _, tt := range tests {
+		if got := engineErrorCode(tt.err); got != tt.want {
+			t.Errorf("engineErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+	// Every mapped code must be registered.
+	for _, e := range engineErrors {
+		if _, ok := errorCodes[e.code]; !ok {
+			t.Errorf("engine error %v maps to unregistered code %q", e.err, e.code)
+		}
+	}
+}
@@ -4,9 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"math"
 	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/azybler/map_router/pkg/routing"
 )
@@ -17,10 +25,37 @@ const (
 	MetricDistance = "distance" // shortest physical road distance
 )
 
+// Geometry coordinate precision (decimal places) selectable via ?precision=.
+// 6 places is ~11 cm, far below GPS and snapping error, and trims 20-30% off
+// geometry-heavy responses compared to full float64 output.
+const (
+	defaultPrecision = 6
+	maxPrecision     = 9
+)
+
+// deepHealthTTL is how long a deep health check's probe result is reused.
+// /health is public and never shed, so without it every ?deep=1 request
+// would run a route query on each metric.
+const deepHealthTTL = 5 * time.Second
+
 // Handlers holds the HTTP handlers and their dependencies.
 type Handlers struct {
 	routers map[string]routing.Router // keyed by metric name; MetricTime is required
 	stats   StatsResponse
+
+	// Deep health probe endpoints (see SetHealthProbe). Deep mode is
+	// unavailable until they are set.
+	probeStart, probeEnd routing.LatLng
+	probeSet             bool
+
+	// The last deep health result and when it was taken; probeMu also makes
+	// concurrent deep checks wait for one probe instead of each running it.
+	probeMu   sync.Mutex
+	probeResp HealthResponse
+	probeAt   time.Time
+	now       func() time.Time
+
+	graphs []GraphInfoJSON // see SetGraphInfo
 }
 
 // NewHandlers creates handlers serving a single time-metric router.
@@ -46,6 +81,7 @@ func NewHandlersMulti(routers map[string]routing.Router, stats StatsResponse) *H
 	return &Handlers{
 		routers: m,
 		stats:   stats,
+		now:     time.Now,
 	}
 }
 
@@ -54,24 +90,48 @@ func (h *Handlers) HandleRoute(w http.ResponseWriter, r *http.Request) {
 	// Enforce Content-Type.
 	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if mediaType != "application/json" {
-		writeError(w, http.StatusBadRequest, "invalid_request", "")
+		writeError(w, "invalid_request", "")
 		return
 	}
 
-	// Parse request.
+	// Parse request. The body size limit is applied by the route table (see
+	// ServerConfig.MaxBodyBytes).
 	var req RouteRequest
-	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_request", "")
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, "request_too_large", "")
+			return
+		}
+		writeFieldErrors(w, "invalid_request",
+			[]FieldError{{Field: "body", Message: "invalid JSON: " + err.Error()}})
 		return
 	}
 
 	// Validate coordinates.
+	var coordErrs []FieldError
 	if err := validateCoord(req.Start); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_coordinates", "start")
-		return
+		coordErrs = append(coordErrs, FieldError{Field: "start", Message: err.Error()})
 	}
 	if err := validateCoord(req.End); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_coordinates", "end")
+		coordErrs = append(coordErrs, FieldError{Field: "end", Message: err.Error()})
+	}
+	if len(coordErrs) > 0 {
+		writeFieldErrors(w, "invalid_coordinates", coordErrs)
+		return
+	}
+
+	// Response field filtering (query parameters, so the JSON body schema is
+	// unchanged for existing clients).
+	fields, fieldErr := parseRouteFields(r.URL.Query())
+	if fieldErr != nil {
+		writeFieldErrors(w, "invalid_request", []FieldError{*fieldErr})
+		return
+	}
+	scale, err := parsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeFieldErrors(w, "invalid_request",
+			[]FieldError{{Field: "precision", Message: err.Error()}})
 		return
 	}
 
@@ -81,31 +141,20 @@ func (h *Handlers) HandleRoute(w http.ResponseWriter, r *http.Request) {
 		metric = MetricTime
 	}
 	if metric != MetricTime && metric != MetricDistance {
-		writeError(w, http.StatusBadRequest, "invalid_request", "metric")
+		writeFieldErrors(w, "invalid_request",
+			[]FieldError{{Field: "metric", Message: `must be "time" or "distance"`}})
 		return
 	}
 	router, ok := h.routers[metric]
 	if !ok {
-		writeError(w, http.StatusBadRequest, "metric_unavailable", "metric")
+		writeError(w, "metric_unavailable", "metric")
 		return
 	}
 
 	// Route.
 	result, err := router.Route(r.Context(), routing.LatLng{Lat: req.Start.Lat, Lng: req.Start.Lng}, routing.LatLng{Lat: req.End.Lat, Lng: req.End.Lng})
 	if err != nil {
-		if errors.Is(err, routing.ErrPointTooFar) {
-			writeError(w, http.StatusUnprocessableEntity, "point_too_far_from_road", "")
-			return
-		}
-		if errors.Is(err, routing.ErrNoRoute) {
-			writeError(w, http.StatusNotFound, "no_route_found", "")
-			return
-		}
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-			writeError(w, http.StatusServiceUnavailable, "request_timeout", "")
-			return
-		}
-		writeError(w, http.StatusInternalServerError, "internal_error", "")
+		writeError(w, engineErrorCode(err), "")
 		return
 	}
 
@@ -114,9 +163,15 @@ func (h *Handlers) HandleRoute(w http.ResponseWriter, r *http.Request) {
 		TotalDistanceMeters: result.TotalDistanceMeters,
 	}
 	for _, seg := range result.Segments {
-		geom := make([]LatLngJSON, len(seg.Geometry))
-		for i, ll := range seg.Geometry {
-			geom[i] = LatLngJSON{Lat: ll.Lat, Lng: ll.Lng}
+		if !fields.segments {
+			break
+		}
+		var geom []LatLngJSON
+		if fields.geometry {
+			geom = make([]LatLngJSON, len(seg.Geometry))
+			for i, ll := range seg.Geometry {
+				geom[i] = LatLngJSON{Lat: roundTo(ll.Lat, scale), Lng: roundTo(ll.Lng, scale)}
+			}
 		}
 		resp.Segments = append(resp.Segments, SegmentJSON{
 			DistanceMeters: seg.DistanceMeters,
@@ -125,13 +180,183 @@ func (h *Handlers) HandleRoute(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(fields.filter(resp))
+}
+
+// SetHealthProbe configures the two known-good points routed between by a
+// deep health check. Must be called before the handlers start serving.
+func (h *Handlers) SetHealthProbe(start, end routing.LatLng) {
+	h.probeStart, h.probeEnd = start, end
+	h.probeSet = true
 }
 
 // HandleHealth handles GET /api/v1/health.
+//
+// With ?deep=1 it also runs the configured probe route through every loaded
+// metric and reports engine latency, so an orchestrator can tell a process
+// that is up but holding a broken graph from a healthy one. Any failed probe
+// turns the response into 503 "unhealthy". The result is reused for
+// deepHealthTTL, so polling deep checks cost one probe per interval.
 func (h *Handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	if !isTruthy(r.URL.Query().Get("deep")) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+		return
+	}
+	if !h.probeSet {
+		writeError(w, "deep_health_unavailable", "deep")
+		return
+	}
+
+	h.probeMu.Lock()
+	if h.probeAt.IsZero() || h.now().Sub(h.probeAt) >= deepHealthTTL {
+		resp := h.runProbes(r.Context())
+		// A probe cut short by this request's own cancellation says
+		// nothing about the graph; answer with it, but do not keep it.
+		if r.Context().Err() != nil {
+			h.probeMu.Unlock()
+			writeHealth(w, resp)
+			return
+		}
+		h.probeResp, h.probeAt = resp, h.now()
+	}
+	resp := h.probeResp
+	h.probeMu.Unlock()
+	writeHealth(w, resp)
+}
+
+// runProbes routes the health probe through every loaded metric.
+func (h *Handlers) runProbes(ctx context.Context) HealthResponse {
+	resp := HealthResponse{Status: "ok"}
+	for _, metric := range slices.Sorted(maps.Keys(h.routers)) {
+		probe := HealthProbeJSON{Metric: metric}
+		start := time.Now()
+		result, err := h.routers[metric].Route(ctx, h.probeStart, h.probeEnd)
+		probe.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+		if err != nil {
+			probe.Error = err.Error()
+			resp.Status = "unhealthy"
+		} else {
+			probe.DistanceMeters = result.TotalDistanceMeters
+		}
+		resp.Probes = append(resp.Probes, probe)
+	}
+	return resp
+}
+
+func writeHealth(w http.ResponseWriter, resp HealthResponse) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// routeFields selects which optional parts of a RouteResponse are emitted.
+// total_distance_meters is always present.
+type routeFields struct {
+	segments bool // per-segment entries
+	geometry bool // per-segment coordinate lists (the bulk of the payload)
+}
+
+// filter returns resp as it should be encoded: resp itself when nothing is
+// filtered out, so the keys of an empty route or segment stay present, or a
+// copy without the keys of the parts left out.
+func (f routeFields) filter(resp RouteResponse) any {
+	type segment struct {
+		DistanceMeters float64 `json:"distance_meters"`
+	}
+	switch {
+	case !f.segments:
+		return struct {
+			TotalDistanceMeters float64 `json:"total_distance_meters"`
+		}{resp.TotalDistanceMeters}
+	case !f.geometry:
+		var segs []segment
+		for _, s := range resp.Segments {
+			segs = append(segs, segment{s.DistanceMeters})
+		}
+		return struct {
+			TotalDistanceMeters float64   `json:"total_distance_meters"`
+			Segments            []segment `json:"segments"`
+		}{resp.TotalDistanceMeters, segs}
+	}
+	return resp
+}
+
+// parseRouteFields reads the exclude= and fields= query parameters, each a
+// comma-separated list of total_distance_meters, segments, geometry.
+// exclude drops the named parts from the default (everything); fields keeps
+// only the named parts, so geometry must be listed explicitly (and implies
+// segments). The two are mutually exclusive. A non-nil *FieldError names the
+// offending parameter.
+func parseRouteFields(q url.Values) (routeFields, *FieldError) {
+	all := routeFields{segments: true, geometry: true}
+	exclude, fieldsParam := q.Get("exclude"), q.Get("fields")
+	if exclude != "" && fieldsParam != "" {
+		return all, &FieldError{Field: "fields", Message: "cannot be combined with exclude"}
+	}
+
+	set := func(f *routeFields, name string, v bool) bool {
+		switch name {
+		case "total_distance_meters":
+		case "segments":
+			f.segments = v
+		case "geometry":
+			f.geometry = v
+			f.segments = f.segments || v // geometry lives inside segments
+		default:
+			return false
+		}
+		return true
+	}
+
+	if exclude != "" {
+		f := all
+		for name := range strings.SplitSeq(exclude, ",") {
+			if name = strings.TrimSpace(name); !set(&f, name, false) {
+				return all, &FieldError{Field: "exclude", Message: "unknown field " + strconv.Quote(name)}
+			}
+		}
+		return f, nil
+	}
+	if fieldsParam != "" {
+		var f routeFields
+		for name := range strings.SplitSeq(fieldsParam, ",") {
+			if name = strings.TrimSpace(name); !set(&f, name, true) {
+				return all, &FieldError{Field: "fields", Message: "unknown field " + strconv.Quote(name)}
+			}
+		}
+		return f, nil
+	}
+	return all, nil
+}
+
+// parsePrecision parses the ?precision= parameter (decimal places, 0 to
+// maxPrecision, default defaultPrecision) and returns the rounding scale 10^n.
+func parsePrecision(v string) (float64, error) {
+	n := defaultPrecision
+	if v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 || n > maxPrecision {
+			return 0, fmt.Errorf("must be an integer from 0 to %d", maxPrecision)
+		}
+	}
+	return math.Pow10(n), nil
+}
+
+// roundTo rounds v to the decimal precision given by scale (10^places).
+func roundTo(v, scale float64) float64 {
+	return math.Round(v*scale) / scale
+}
+
+// isTruthy reports whether a boolean query parameter is set.
+func isTruthy(v string) bool {
+	switch v {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
 }
 
 // HandleStats handles GET /api/v1/stats.
@@ -140,6 +365,24 @@ func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(h.stats)
 }
 
+// SetGraphInfo sets the per-metric graph descriptions served by
+// GET /api/v1/graph. Must be called before the handlers start serving.
+func (h *Handlers) SetGraphInfo(graphs []GraphInfoJSON) {
+	h.graphs = slices.SortedFunc(slices.Values(graphs), func(a, b GraphInfoJSON) int {
+		return strings.Compare(a.Metric, b.Metric)
+	})
+}
+
+// HandleGraph handles GET /api/v1/graph.
+func (h *Handlers) HandleGraph(w http.ResponseWriter, r *http.Request) {
+	resp := GraphResponse{Graphs: h.graphs}
+	if resp.Graphs == nil {
+		resp.Graphs = []GraphInfoJSON{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 func validateCoord(ll LatLngJSON) error {
 	if math.IsNaN(ll.Lat) || math.IsNaN(ll.Lng) || math.IsInf(ll.Lat, 0) || math.IsInf(ll.Lng, 0) {
 		return errors.New("coordinates must be finite numbers")
@@ -149,9 +392,3 @@ func validateCoord(ll LatLngJSON) error {
 	}
 	return nil
 }
-
-func writeError(w http.ResponseWriter, status int, code, field string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Field: field})
-}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/azybler/map_router/pkg/routing"
 )
@@ -315,3 +316,244 @@ func TestNewHandlersMulti_CopiesRouters(t *testing.T) {
 		t.Errorf("error = %q, want metric_unavailable", e.Error)
 	}
 }
+
+func getHealth(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/health"+query, nil)
+	w := httptest.NewRecorder()
+	h.HandleHealth(w, req)
+	return w
+}
+
+func TestHandleHealth_DeepProbesEveryMetric(t *testing.T) {
+	h := NewHandlersMulti(map[string]routing.Router{
+		MetricTime:     &mockRouter{result: routeResult(111)},
+		MetricDistance: &mockRouter{result: routeResult(222)},
+	}, StatsResponse{})
+	h.SetHealthProbe(routing.LatLng{Lat: 1.3, Lng: 103.8}, routing.LatLng{Lat: 1.35, Lng: 103.85})
+
+	w := getHealth(t, h, "?deep=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200. body: %s", w.Code, w.Body.String())
+	}
+	var resp HealthResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "ok" || len(resp.Probes) != 2 {
+		t.Fatalf("resp = %+v, want ok with 2 probes", resp)
+	}
+	if resp.Probes[0].Metric != MetricDistance || resp.Probes[0].DistanceMeters != 222 {
+		t.Errorf("probe[0] = %+v, want distance/222", resp.Probes[0])
+	}
+	if resp.Probes[1].Metric != MetricTime || resp.Probes[1].DistanceMeters != 111 {
+		t.Errorf("probe[1] = %+v, want time/111", resp.Probes[1])
+	}
+}
+
+func TestHandleHealth_DeepProbeFailureIsUnhealthy(t *testing.T) {
+	h := NewHandlers(&mockRouter{err: routing.ErrNoRoute}, StatsResponse{})
+	h.SetHealthProbe(routing.LatLng{Lat: 1.3, Lng: 103.8}, routing.LatLng{Lat: 1.35, Lng: 103.85})
+
+	w := getHealth(t, h, "?deep=true")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", w.Code)
+	}
+	var resp HealthResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Status != "unhealthy" || len(resp.Probes) != 1 || resp.Probes[0].Error == "" {
+		t.Errorf("resp = %+v, want unhealthy with a probe error", resp)
+	}
+}
+
+// countingRouter is a mockRouter that counts its queries.
+type countingRouter struct {
+	mockRouter
+	calls int
+}
+
+func (c *countingRouter) Route(ctx context.Context, start, end routing.LatLng) (*routing.RouteResult, error) {
+	c.calls++
+	return c.mockRouter.Route(ctx, start, end)
+}
+
+func TestHandleHealth_DeepProbeIsReused(t *testing.T) {
+	router := &countingRouter{mockRouter: mockRouter{result: routeResult(111)}}
+	h := NewHandlers(router, StatsResponse{})
+	h.SetHealthProbe(routing.LatLng{Lat: 1.3, Lng: 103.8}, routing.LatLng{Lat: 1.35, Lng: 103.85})
+	now := time.Unix(1_700_000_000, 0)
+	h.now = func() time.Time { return now }
+
+	for range 10 {
+		if w := getHealth(t, h, "?deep=1"); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+	if router.calls != 1 {
+		t.Errorf("%d probe queries for 10 deep checks within the TTL, want 1", router.calls)
+	}
+	now = now.Add(deepHealthTTL)
+	getHealth(t, h, "?deep=1")
+	if router.calls != 2 {
+		t.Errorf("%d probe queries after the TTL, want 2", router.calls)
+	}
+	getHealth(t, h, "")
+	if router.calls != 2 {
+		t.Error("a plain health check ran the probe")
+	}
+}
+
+func TestHandleHealth_DeepWithoutProbe(t *testing.T) {
+	h := NewHandlers(&mockRouter{}, StatsResponse{})
+	if w := getHealth(t, h, "?deep=1"); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 when no probe is configured", w.Code)
+	}
+}
+
+func postRouteQuery(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `{"start":{"lat":1.3,"lng":103.8},"end":{"lat":1.35,"lng":103.85}}`
+	req := httptest.NewRequest("POST", "/api/v1/route"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.HandleRoute(w, req)
+	return w
+}
+
+func TestHandleRoute_FieldFiltering(t *testing.T) {
+	h := NewHandlers(&mockRouter{result: routeResult(111)}, StatsResponse{})
+
+	tests := []struct {
+		query        string
+		wantSegments bool
+		wantGeometry bool
+	}{
+		{"", true, true},
+		{"?exclude=geometry", true, false},
+		{"?exclude=segments", false, false},
+		{"?fields=total_distance_meters", false, false},
+		{"?fields=total_distance_meters,segments", true, false},
+		{"?fields=geometry", true, true},
+	}
+	for _, tt := range tests {
+		w := postRouteQuery(t, h, tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, w.Code)
+		}
+		body := w.Body.String()
+		if got := strings.Contains(body, `"segments"`); got != tt.wantSegments {
+			t.Errorf("%q: segments present = %v, want %v", tt.query, got, tt.wantSegments)
+		}
+		if got := strings.Contains(body, `"geometry"`); got != tt.wantGeometry {
+			t.Errorf("%q: geometry present = %v, want %v", tt.query, got, tt.wantGeometry)
+		}
+		if !strings.Contains(body, `"total_distance_meters":111`) {
+			t.Errorf("%q: total_distance_meters missing: %s", tt.query, body)
+		}
+	}
+}
+
+func TestHandleRoute_UnfilteredKeepsEmptyKeys(t *testing.T) {
+	// A same-node route has no segments; a segment may have no geometry.
+	// Unfiltered responses still carry both keys.
+	for _, result := range []*routing.RouteResult{
+		{TotalDistanceMeters: 0},
+		{Segments: []routing.Segment{{DistanceMeters: 0}}},
+	} {
+		h := NewHandlers(&mockRouter{result: result}, StatsResponse{})
+		body := postRouteQuery(t, h, "").Body.String()
+		if !strings.Contains(body, `"segments"`) {
+			t.Errorf("segments key missing: %s", body)
+		}
+		if len(result.Segments) > 0 && !strings.Contains(body, `"geometry"`) {
+			t.Errorf("geometry key missing: %s", body)
+		}
+	}
+}
+
+func TestHandleRoute_FieldFilteringInvalid(t *testing.T) {
+	h := NewHandlers(&mockRouter{result: routeResult(111)}, StatsResponse{})
+
+	for query, field := range map[string]string{
+		"?exclude=bogus":                    "exclude",
+		"?fields=bogus":                     "fields",
+		"?exclude=geometry&fields=segments": "fields",
+	} {
+		w := postRouteQuery(t, h, query)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: status = %d, want 400", query, w.Code)
+		}
+		var e ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if e.Error != "invalid_request" || e.Field != field {
+			t.Errorf("%q: error = %q field = %q, want invalid_request/%s", query, e.Error, e.Field, field)
+		}
+	}
+}
+
+func TestHandleRoute_Precision(t *testing.T) {
+	mock := &mockRouter{result: &routing.RouteResult{
+		TotalDistanceMeters: 10,
+		Segments: []routing.Segment{
+			{DistanceMeters: 10, Geometry: []routing.LatLng{{Lat: 1.123456789, Lng: 103.987654321}}},
+		},
+	}}
+	h := NewHandlers(mock, StatsResponse{})
+
+	tests := []struct {
+		query   string
+		wantLat float64
+		wantLng float64
+	}{
+		{"", 1.123457, 103.987654},
+		{"?precision=3", 1.123, 103.988},
+		{"?precision=0", 1, 104},
+	}
+	for _, tt := range tests {
+		w := postRouteQuery(t, h, tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, w.Code)
+		}
+		var resp RouteResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		got := resp.Segments[0].Geometry[0]
+		if got.Lat != tt.wantLat || got.Lng != tt.wantLng {
+			t.Errorf("%q: got %v,%v, want %v,%v", tt.query, got.Lat, got.Lng, tt.wantLat, tt.wantLng)
+		}
+	}
+
+	for _, q := range []string{"?precision=-1", "?precision=10", "?precision=x"} {
+		if w := postRouteQuery(t, h, q); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestHandleGraph(t *testing.T) {
+	h := NewHandlers(&mockRouter{}, StatsResponse{})
+
+	// Before SetGraphInfo the list is empty, not null.
+	w := httptest.NewRecorder()
+	h.HandleGraph(w, httptest.NewRequest("GET", "/api/v1/graph", nil))
+	if got := strings.TrimSpace(w.Body.String()); got != `{"graphs":[]}` {
+		t.Errorf("empty body = %s, want {\"graphs\":[]}", got)
+	}
+
+	h.SetGraphInfo([]GraphInfoJSON{
+		{Metric: MetricTime, FormatVersion: 3, Profiles: []string{"car"}},
+		{Metric: MetricDistance, FormatVersion: 3, BBox: &BBoxJSON{MinLat: 1, MinLng: 103, MaxLat: 2, MaxLng: 104}},
+	})
+	w = httptest.NewRecorder()
+	h.HandleGraph(w, httptest.NewRequest("GET", "/api/v1/graph", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp GraphResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Graphs) != 2 || resp.Graphs[0].Metric != MetricDistance || resp.Graphs[1].Metric != MetricTime {
+		t.Fatalf("graphs = %+v, want distance then time", resp.Graphs)
+	}
+	if resp.Graphs[0].BBox == nil || resp.Graphs[0].BBox.MaxLng != 104 {
+		t.Errorf("bbox = %+v", resp.Graphs[0].BBox)
+	}
+}
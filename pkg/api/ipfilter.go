@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IPFilter enforces CIDR allow/deny lists loaded from a JSON file:
+//
+//	{"allow": ["10.0.0.0/8", "192.168.1.7"], "deny": ["10.6.6.0/24"]}
+//
+// Deny always wins. An empty allow list admits every address not denied; a
+// non-empty one admits only addresses it covers. Bare addresses are treated
+// as single-host prefixes.
+//
+// The rules are swapped atomically, so Reload (or Watch) can pick up edits to
+// the file while requests are being served. A reload that fails to parse keeps
+// the previous rules in force rather than opening or closing the server.
+type IPFilter struct {
+	path  string
+	rules atomic.Pointer[ipRules]
+	mtime atomic.Int64 // UnixNano of the file version currently loaded
+}
+
+type ipRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPFilter loads the allow/deny lists from path.
+func NewIPFilter(path string) (*IPFilter, error) {
+	f := &IPFilter{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload re-reads the config file and atomically replaces the active rules.
+func (f *IPFilter) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("stat ip filter: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("read ip filter: %w", err)
+	}
+	rules, err := parseIPRules(data)
+	if err != nil {
+		return fmt.Errorf("parse ip filter %s: %w", f.path, err)
+	}
+	f.rules.Store(rules)
+	f.mtime.Store(info.ModTime().UnixNano())
+	return nil
+}
+
+// Watch polls the config file every interval and reloads it when its
+// modification time changes, until ctx is done. Reload errors are logged and
+// the previous rules stay active.
+func (f *IPFilter) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			info, err := os.Stat(f.path)
+			if err != nil {
+				log.Printf("ip filter: %v (keeping previous rules)", err)
+				continue
+			}
+			if info.ModTime().UnixNano() == f.mtime.Load() {
+				continue
+			}
+			if err := f.Reload(); err != nil {
+				log.Printf("ip filter: %v (keeping previous rules)", err)
+				continue
+			}
+			log.Printf("ip filter: reloaded %s", f.path)
+		}
+	}
+}
+
+// Allowed reports whether addr passes the current allow/deny lists.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	rules := f.rules.Load()
+	addr = addr.Unmap()
+	for _, p := range rules.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, p := range rules.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedRequest applies the filter to the request's peer address. Requests
+// whose RemoteAddr cannot be parsed are rejected: the filter exists to fail
+// closed.
+func (f *IPFilter) allowedRequest(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return f.Allowed(addr)
+}
+
+func parseIPRules(data []byte) (*ipRules, error) {
+	var raw struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	rules := &ipRules{}
+	var err error
+	if rules.allow, err = parsePrefixes(raw.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if rules.deny, err = parsePrefixes(raw.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	return rules, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeIPFilter(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ipfilter.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIPFilter_AllowDeny(t *testing.T) {
+	f, err := NewIPFilter(writeIPFilter(t, `{"allow":["10.0.0.0/8","192.168.1.7"],"deny":["10.6.6.0/24"]}`))
+	if err != nil {
+		t.Fatalf("NewIPFilter: %v", err)
+	}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.6.6.9", false}, // deny wins over allow
+		{"192.168.1.7", true},
+		{"192.168.1.8", false}, // not in allow list
+		{"::ffff:10.1.2.3", true},
+	}
+	for _, tt := range tests {
+		if got := f.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestIPFilter_DenyOnly(t *testing.T) {
+	f, err := NewIPFilter(writeIPFilter(t, `{"deny":["2001:db8::/32"]}`))
+	if err != nil {
+		t.Fatalf("NewIPFilter: %v", err)
+	}
+	if !f.allowedRequest("203.0.113.5:4321") {
+		t.Error("address outside deny list must be allowed when allow list is empty")
+	}
+	if f.allowedRequest("[2001:db8::1]:4321") {
+		t.Error("denied IPv6 address must be rejected")
+	}
+	if f.allowedRequest("garbage") {
+		t.Error("unparseable RemoteAddr must be rejected")
+	}
+}
+
+func TestIPFilter_ReloadKeepsRulesOnError(t *testing.T) {
+	path := writeIPFilter(t, `{"deny":["198.51.100.0/24"]}`)
+	f, err := NewIPFilter(path)
+	if err != nil {
+		t.Fatalf("NewIPFilter: %v", err)
+	}
+	addr := netip.MustParseAddr("198.51.100.1")
+
+	os.WriteFile(path, []byte(`{"deny":["not-a-cidr"]}`), 0o644)
+	if err := f.Reload(); err == nil {
+		t.Fatal("Reload of invalid file must fail")
+	}
+	if f.Allowed(addr) {
+		t.Error("failed reload must keep the previous rules")
+	}
+
+	os.WriteFile(path, []byte(`{}`), 0o644)
+	if err := f.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !f.Allowed(addr) {
+		t.Error("successful reload must replace the rules")
+	}
+}
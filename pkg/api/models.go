@@ -16,19 +16,30 @@ type LatLngJSON struct {
 // RouteResponse is the JSON response for a successful route query.
 type RouteResponse struct {
 	TotalDistanceMeters float64       `json:"total_distance_meters"`
-	Segments            []SegmentJSON `json:"segments"`
+	Segments            []SegmentJSON `json:"segments"` // left out only when filtered out (see routeFields)
 }
 
 // SegmentJSON represents a road segment in the response.
 type SegmentJSON struct {
 	DistanceMeters float64      `json:"distance_meters"`
-	Geometry       []LatLngJSON `json:"geometry"`
+	Geometry       []LatLngJSON `json:"geometry"` // left out only when filtered out (see routeFields)
 }
 
-// ErrorResponse is the JSON response for errors.
+// ErrorResponse is the JSON response for errors. Clients should branch on
+// Error, a stable code from the registry in errors.go, never on Message.
 type ErrorResponse struct {
-	Error string `json:"error"`
-	Field string `json:"field,omitempty"`
+	Error     string       `json:"error"`
+	Field     string       `json:"field,omitempty"` // first offending field; see Details
+	Message   string       `json:"message,omitempty"`
+	Retryable bool         `json:"retryable"` // repeating the identical request may succeed
+	DocURL    string       `json:"doc_url,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes one invalid request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message,omitempty"`
 }
 
 // StatsResponse is the JSON response for GET /api/v1/stats.
@@ -37,9 +48,67 @@ type StatsResponse struct {
 	NumFwdEdges      int      `json:"num_fwd_edges"`
 	NumBwdEdges      int      `json:"num_bwd_edges"`
 	AvailableMetrics []string `json:"available_metrics"`
+
+	// Graph summarizes the time graph's road network, when the server
+	// computed it at startup.
+	Graph *GraphStatsJSON `json:"graph,omitempty"`
+}
+
+// GraphStatsJSON is graph.GraphStats of the original (uncontracted) road
+// graph. Histogram entries past the largest value are left out.
+type GraphStatsJSON struct {
+	NumEdges         uint32   `json:"num_edges"`
+	OutDegree        []uint32 `json:"out_degree"`       // nodes by outgoing edge count; the 17th entry counts 16 or more
+	InDegree         []uint32 `json:"in_degree"`        // likewise by incoming edge count
+	WeightHistogram  []uint32 `json:"weight_histogram"` // edges by weight: entry i counts weights of i bits
+	MinWeight        uint32   `json:"min_weight"`
+	MedianWeight     float64  `json:"median_weight"`
+	MeanWeight       float64  `json:"mean_weight"`
+	MaxWeight        uint32   `json:"max_weight"`
+	ShapePoints      int      `json:"shape_points"`
+	StraightEdges    uint32   `json:"straight_edges"`
+	NamedEdges       uint32   `json:"named_edges"`
+	TurnRestrictions int      `json:"turn_restrictions"`
+	MemoryBytes      int64    `json:"memory_bytes"` // estimate for the road graph's arrays, CH overlay excluded
 }
 
 // HealthResponse is the JSON response for GET /api/v1/health.
 type HealthResponse struct {
-	Status string `json:"status"`
+	Status string            `json:"status"`           // "ok", or "unhealthy" when a deep probe fails
+	Probes []HealthProbeJSON `json:"probes,omitempty"` // deep mode only: one per loaded metric
+}
+
+// HealthProbeJSON reports one synthetic route query run by a deep health check.
+type HealthProbeJSON struct {
+	Metric         string  `json:"metric"`
+	LatencyMs      float64 `json:"latency_ms"`
+	DistanceMeters float64 `json:"distance_meters,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// GraphResponse is the JSON response for GET /api/v1/graph.
+type GraphResponse struct {
+	Graphs []GraphInfoJSON `json:"graphs"` // one per loaded metric, sorted by metric
+}
+
+// GraphInfoJSON describes one loaded graph file, from its metadata footer.
+// Build fields are omitted for graphs written before metadata was recorded.
+type GraphInfoJSON struct {
+	Metric        string            `json:"metric"`
+	FormatVersion uint32            `json:"format_version"`
+	BuildTime     string            `json:"build_time,omitempty"` // RFC 3339, UTC
+	ToolVersion   string            `json:"tool_version,omitempty"`
+	SourceFile    string            `json:"source_file,omitempty"`
+	SourceSHA256  string            `json:"source_sha256,omitempty"`
+	BBox          *BBoxJSON         `json:"bbox,omitempty"`
+	Params        map[string]string `json:"params,omitempty"`
+	Profiles      []string          `json:"profiles,omitempty"`
+}
+
+// BBoxJSON is a geographic bounding box.
+type BBoxJSON struct {
+	MinLat float64 `json:"min_lat"`
+	MinLng float64 `json:"min_lng"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLng float64 `json:"max_lng"`
 }
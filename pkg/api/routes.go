@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// routeTable registers method-specific routes on a ServeMux and remembers
+// which methods each path accepts. Go 1.22 method patterns answer a request
+// whose path matches but whose method does not with a bare-text 405, skip the
+// middleware (no security or CORS headers), and leave OPTIONS unanswered
+// unless a pattern names it. finish closes that gap with one method-less
+// fallback per path: OPTIONS gets 204 with Allow (and the CORS preflight
+// headers when CORS is on), every other method a JSON 405 with Allow. The
+// fallback is public: browsers send preflights without credentials.
+type routeTable struct {
+	mux     *http.ServeMux
+	mw      *middleware
+	prefix  string              // ServerConfig.BasePath, normalized
+	methods map[string][]string // path → registered methods, in registration order
+	paths   []string            // registration order, for deterministic setup
+}
+
+func newRouteTable(mux *http.ServeMux, mw *middleware, prefix string) *routeTable {
+	return &routeTable{mux: mux, mw: mw, prefix: prefix, methods: make(map[string][]string)}
+}
+
+// handle registers handler for method and path, wrapped in the middleware.
+// Methods that carry a body get the path's configured body limit, and a
+// configured Cache-Control policy replaces the default "no-store".
+func (rt *routeTable) handle(method, path string, handler http.HandlerFunc, scope authScope, sheddable bool) {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		handler = limitBody(handler, rt.mw.cfg.bodyLimit(path))
+	}
+	if policy := rt.mw.cfg.CacheControl[path]; policy != "" {
+		handler = withCacheControl(handler, policy)
+	}
+	full := rt.prefix + path
+	rt.mux.HandleFunc(method+" "+full, rt.mw.wrap(handler, scope, sheddable))
+	if _, ok := rt.methods[full]; !ok {
+		rt.paths = append(rt.paths, full)
+	}
+	rt.methods[full] = append(rt.methods[full], method)
+}
+
+// finish registers the OPTIONS/405 fallback for every path handled so far.
+// Method patterns are more specific than the fallback, so they still win.
+func (rt *routeTable) finish() {
+	for _, path := range rt.paths {
+		rt.mux.HandleFunc(path, rt.mw.wrap(rt.fallback(allowHeader(rt.methods[path])), scopePublic, false))
+	}
+}
+
+func (rt *routeTable) fallback(allow string) http.HandlerFunc {
+	cors := rt.mw.cfg.CORSOrigin != ""
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		if r.Method != http.MethodOptions {
+			writeError(w, "method_not_allowed", "")
+			return
+		}
+		if cors {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// allowHeader renders the Allow value for a path's registered methods. GET
+// patterns also serve HEAD, and OPTIONS is always answered by the fallback.
+func allowHeader(methods []string) string {
+	allow := slices.Clone(methods)
+	if slices.Contains(allow, http.MethodGet) {
+		allow = append(allow, http.MethodHead)
+	}
+	allow = append(allow, http.MethodOptions)
+	return strings.Join(allow, ", ")
+}
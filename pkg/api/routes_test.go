@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutes_MethodNotAllowed(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1}, NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	tests := []struct {
+		method, path, allow string
+	}{
+		{"GET", "/api/v1/route", "POST, OPTIONS"},
+		{"DELETE", "/api/v1/stats", "GET, HEAD, OPTIONS"},
+		{"POST", "/api/v1/health", "GET, HEAD, OPTIONS"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: status = %d, want 405", tt.method, tt.path, w.Code)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error != "method_not_allowed" {
+			t.Errorf("%s %s: body = %s, want method_not_allowed", tt.method, tt.path, w.Body.String())
+		}
+	}
+}
+
+func TestRoutes_Options(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1}, NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/api/v1/stats", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("Allow = %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Error("CORS headers must not be sent when CORS is disabled")
+	}
+}
+
+func TestRoutes_CORSPreflight(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1, CORSOrigin: "https://example.com"},
+		NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/api/v1/route", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "POST, OPTIONS" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want POST, OPTIONS", got)
+	}
+}
+
+func TestRoutes_UnknownPathIs404(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1}, NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestRoutes_BodyLimit(t *testing.T) {
+	body := `{"start":{"lat":1.3,"lng":103.8},"end":{"lat":1.29,"lng":103.85}}`
+	post := func(srv *http.Server, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/route", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+	h := NewHandlers(&mockRouter{result: routeResult(100)}, StatsResponse{})
+
+	small := NewServer(ServerConfig{MaxConcurrent: 1, MaxBodyBytes: 32}, h)
+	for _, chunked := range []bool{false, true} {
+		if w := post(small, body, chunked); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%v: status = %d, want 413", chunked, w.Code)
+		}
+	}
+
+	// A per-path override lifts the limit for that endpoint only.
+	override := NewServer(ServerConfig{MaxConcurrent: 1, MaxBodyBytes: 32,
+		BodyLimits: map[string]int64{"/api/v1/route": 4096}}, h)
+	if w := post(override, body, false); w.Code != http.StatusOK {
+		t.Errorf("with override: status = %d, want 200", w.Code)
+	}
+
+	// The default applies when nothing is configured.
+	def := NewServer(ServerConfig{MaxConcurrent: 1}, h)
+	if w := post(def, body+strings.Repeat(" ", defaultMaxBodyBytes), false); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("default limit: status = %d, want 413", w.Code)
+	}
+}
+
+func TestRoutes_CacheControl(t *testing.T) {
+	srv := NewServer(ServerConfig{MaxConcurrent: 1,
+		CacheControl: map[string]string{"/api/v1/stats": "public, max-age=60", "/api/v1/health": "public, max-age=5"}},
+		NewHandlers(&mockRouter{}, StatsResponse{}))
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/api/v1/stats", "public, max-age=60"},
+		{"/api/v1/graph", "no-store"},         // unlisted
+		{"/api/v1/health?deep=1", "no-store"}, // error response (no probe configured)
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got := w.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRoutes_BasePath(t *testing.T) {
+	for _, base := range []string{"/routing", "/routing/", "routing"} {
+		srv := NewServer(ServerConfig{MaxConcurrent: 1, BasePath: base, Demo: true},
+			NewHandlers(&mockRouter{}, StatsResponse{}))
+
+		for path, want := range map[string]int{
+			"/routing/api/v1/stats": http.StatusOK,
+			"/routing/demo":         http.StatusOK,
+			"/api/v1/stats":         http.StatusNotFound,
+		} {
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != want {
+				t.Errorf("base %q: GET %s = %d, want %d", base, path, w.Code, want)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/routing/api/v1/route", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("base %q: GET route = %d, want 405", base, w.Code)
+		}
+	}
+}
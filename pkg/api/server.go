@@ -7,17 +7,50 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// defaultMaxBodyBytes bounds request bodies when ServerConfig.MaxBodyBytes is
+// unset. A route request is ~100 bytes; 1 KiB leaves room for whitespace.
+const defaultMaxBodyBytes = 1 << 10
+
 // ServerConfig holds server configuration.
 type ServerConfig struct {
-	Addr           string
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
-	MaxConcurrent  int
-	CORSOrigin     string
+	Addr          string
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	MaxConcurrent int
+	CORSOrigin    string
+
+	// Adaptive load shedding (see loadShedder). Zero disables each trigger.
+	ShedP99Latency time.Duration // shed a share of requests while observed p99 exceeds this
+	ShedQueueDepth int           // shed requests beyond this many in flight
+
+	IPFilter *IPFilter // optional CIDR allow/deny lists; nil admits everyone
+
+	Auth *TokenAuth // optional read/admin bearer tokens; nil leaves the API open
+
+	// Request body limits. MaxBodyBytes applies to every endpoint that reads a
+	// body (0 = defaultMaxBodyBytes); BodyLimits overrides it per path, for
+	// endpoints that legitimately accept larger payloads. Oversized bodies get
+	// 413 request_too_large.
+	MaxBodyBytes int64
+	BodyLimits   map[string]int64
+
+	// CacheControl overrides the Cache-Control header per path (e.g.
+	// "/api/v1/stats" → "public, max-age=60"). It is applied to successful
+	// responses only; errors and unlisted paths are always "no-store".
+	CacheControl map[string]string
+
+	// BasePath mounts every route under a prefix (e.g. "/routing" serves
+	// /routing/api/v1/route), for hosting behind a shared ingress without path
+	// rewriting. BodyLimits and CacheControl keys stay unprefixed.
+	BasePath string
+
+	Demo       bool       // serve the embedded demo map page at GET /demo (not with Auth: the page sends no token)
+	DemoCenter LatLngJSON // initial map centre for the demo page
 }
 
 // DefaultConfig returns sensible defaults.
@@ -28,6 +61,7 @@ func DefaultConfig(addr string) ServerConfig {
 		WriteTimeout:  5 * time.Second,
 		MaxConcurrent: runtime.NumCPU() * 2,
 		CORSOrigin:    "",
+		MaxBodyBytes:  defaultMaxBodyBytes,
 	}
 }
 
@@ -35,20 +69,31 @@ func DefaultConfig(addr string) ServerConfig {
 func NewServer(cfg ServerConfig, handlers *Handlers) *http.Server {
 	mux := http.NewServeMux()
 
-	// Concurrency limiter.
-	sem := make(chan struct{}, cfg.MaxConcurrent)
+	mw := &middleware{
+		cfg:  cfg,
+		sem:  make(chan struct{}, cfg.MaxConcurrent), // concurrency limiter
+		shed: newLoadShedder(cfg.ShedP99Latency, cfg.ShedQueueDepth),
+	}
 
-	// Routes.
-	mux.HandleFunc("POST /api/v1/route", withMiddleware(handlers.HandleRoute, sem, cfg))
-	mux.HandleFunc("GET /api/v1/health", withMiddleware(handlers.HandleHealth, sem, cfg))
-	mux.HandleFunc("GET /api/v1/stats", withMiddleware(handlers.HandleStats, sem, cfg))
+	// Routes. Health and stats are never shed: they are cheap, and an
+	// orchestrator must still see a live server while it sheds route traffic.
+	rt := newRouteTable(mux, mw, normalizeBasePath(cfg.BasePath))
+	// Health stays public so orchestrators need no credentials.
+	rt.handle(http.MethodPost, "/api/v1/route", handlers.HandleRoute, scopeRead, true)
+	rt.handle(http.MethodGet, "/api/v1/health", handlers.HandleHealth, scopePublic, false)
+	rt.handle(http.MethodGet, "/api/v1/stats", handlers.HandleStats, scopeRead, false)
+	rt.handle(http.MethodGet, "/api/v1/graph", handlers.HandleGraph, scopeRead, false)
 
-	// CORS preflight for POST endpoint.
-	if cfg.CORSOrigin != "" {
-		noop := func(http.ResponseWriter, *http.Request) {}
-		mux.HandleFunc("OPTIONS /api/v1/route", withMiddleware(noop, sem, cfg))
+	// The demo page calls the query API without a token, so with Auth it
+	// could only show 401s.
+	if cfg.Demo && cfg.Auth == nil {
+		rt.handle(http.MethodGet, "/demo", demoHandler(cfg.DemoCenter), scopePublic, false)
 	}
 
+	// 405 with Allow for wrong methods, and OPTIONS (including CORS
+	// preflight) on every route.
+	rt.finish()
+
 	return &http.Server{
 		Addr:         cfg.Addr,
 		Handler:      mux,
@@ -57,6 +102,73 @@ func NewServer(cfg ServerConfig, handlers *Handlers) *http.Server {
 	}
 }
 
+// normalizeBasePath turns "routing", "/routing/" and "/routing" into
+// "/routing", and "" or "/" into "".
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// bodyLimit returns the request body limit for path.
+func (cfg *ServerConfig) bodyLimit(path string) int64 {
+	if n, ok := cfg.BodyLimits[path]; ok && n > 0 {
+		return n
+	}
+	if cfg.MaxBodyBytes > 0 {
+		return cfg.MaxBodyBytes
+	}
+	return defaultMaxBodyBytes
+}
+
+// limitBody caps the request body at limit bytes. Requests that declare a
+// larger Content-Length are rejected up front; chunked bodies that run over
+// surface as *http.MaxBytesError from the handler's read.
+func limitBody(handler http.HandlerFunc, limit int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, "request_too_large", "")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		handler(w, r)
+	}
+}
+
+// withCacheControl applies policy to 2xx responses of handler. The middleware
+// has already set "no-store", which stays in force for any error status.
+func withCacheControl(handler http.HandlerFunc, policy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(&cachePolicyWriter{ResponseWriter: w, policy: policy}, r)
+	}
+}
+
+// cachePolicyWriter sets Cache-Control when the status is committed.
+type cachePolicyWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (cw *cachePolicyWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status >= 200 && status < 300 {
+			cw.Header().Set("Cache-Control", cw.policy)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cachePolicyWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
 // ListenAndServe starts the server and blocks until shutdown signal.
 func ListenAndServe(srv *http.Server) error {
 	// Graceful shutdown on SIGTERM/SIGINT.
@@ -80,37 +192,78 @@ func ListenAndServe(srv *http.Server) error {
 	}
 }
 
-// withMiddleware wraps a handler with logging, recovery, security headers,
-// and concurrency limiting.
-func withMiddleware(handler http.HandlerFunc, sem chan struct{}, cfg ServerConfig) http.HandlerFunc {
+// middleware holds the state shared by every wrapped route.
+type middleware struct {
+	cfg  ServerConfig
+	sem  chan struct{}
+	shed *loadShedder // nil when load shedding is disabled
+}
+
+// wrap wraps a handler with logging, recovery, security headers, token
+// authentication for scope, concurrency limiting and, when sheddable,
+// adaptive load shedding.
+func (m *middleware) wrap(handler http.HandlerFunc, scope authScope, sheddable bool) http.HandlerFunc {
+	cfg := m.cfg
+	shed := m.shed
+	if !sheddable {
+		shed = nil
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Network-level access control, before any other work.
+		if cfg.IPFilter != nil && !cfg.IPFilter.allowedRequest(r.RemoteAddr) {
+			writeError(w, "forbidden", "")
+			return
+		}
+
 		// Security headers.
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("Cache-Control", "no-store")
 
-		// CORS.
+		// CORS. Preflight requests are answered by the route table's
+		// OPTIONS fallback, which knows each path's methods.
 		if cfg.CORSOrigin != "" {
 			w.Header().Set("Access-Control-Allow-Origin", cfg.CORSOrigin)
 			w.Header().Set("Vary", "Origin")
+		}
 
-			// Handle preflight requests.
-			if r.Method == http.MethodOptions {
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-				w.Header().Set("Access-Control-Max-Age", "86400")
-				w.WriteHeader(http.StatusNoContent)
+		// Token authentication. Runs before shedding so rejected credentials
+		// never count towards the latency estimate.
+		if cfg.Auth != nil {
+			switch cfg.Auth.authorize(r, scope) {
+			case http.StatusUnauthorized:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="map_router"`)
+				writeError(w, "unauthorized", "")
+				return
+			case http.StatusForbidden:
+				writeError(w, "insufficient_scope", "")
 				return
 			}
 		}
 
-		// Concurrency limiter.
+		// Adaptive load shedding.
+		start := time.Now()
+		if shed != nil && !shed.admit() {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, "overloaded", "")
+			return
+		}
+
+		// Concurrency limiter. Only requests that get a slot are timed: a
+		// near-instant rejection would pull p99 down just when the server
+		// is overloaded.
 		select {
-		case sem <- struct{}{}:
-			defer func() { <-sem }()
+		case m.sem <- struct{}{}:
+			defer func() { <-m.sem }()
+			if shed != nil {
+				defer func() { shed.done(time.Since(start)) }()
+			}
 		default:
+			if shed != nil {
+				shed.cancel()
+			}
 			w.Header().Set("Retry-After", "1")
-			http.Error(w, `{"error":"service_unavailable"}`, http.StatusServiceUnavailable)
+			writeError(w, "service_unavailable", "")
 			return
 		}
 
@@ -118,7 +271,7 @@ func withMiddleware(handler http.HandlerFunc, sem chan struct{}, cfg ServerConfi
 		defer func() {
 			if rec := recover(); rec != nil {
 				log.Printf("panic: %v", rec)
-				http.Error(w, `{"error":"internal_error"}`, http.StatusInternalServerError)
+				writeError(w, "internal_error", "")
 			}
 		}()
 
@@ -126,7 +279,6 @@ func withMiddleware(handler http.HandlerFunc, sem chan struct{}, cfg ServerConfi
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 
-		start := time.Now()
 		handler(w, r.WithContext(ctx))
 		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start).Round(time.Microsecond))
 	}
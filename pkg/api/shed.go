@@ -0,0 +1,145 @@
+package api
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	shedWindow        = 10 * time.Second // latency samples older than this are ignored
+	shedSamples       = 1024             // ring buffer capacity
+	shedRecomputeEach = 64               // recompute p99 every N observations...
+	shedRecomputeAge  = time.Second      // ...or when the estimate is older than this
+	maxShedFraction   = 0.95             // always admit some traffic so p99 can recover
+)
+
+// loadShedder is an adaptive overload guard layered in front of the fixed
+// concurrency semaphore. The semaphore only bounds how many requests run at
+// once; it cannot tell that each of them has become slow (GC pressure, a
+// noisy neighbour, pathological long-haul queries). The shedder watches the
+// observed p99 latency and the number of in-flight requests and starts
+// rejecting a fraction of new work before the semaphore saturates.
+//
+// Shedding is probabilistic, proportional to how far p99 overshoots the
+// threshold, and never total: admitted requests keep producing samples, so
+// the estimate tracks recovery instead of freezing at its overloaded value.
+// Samples also age out after shedWindow, so an idle server always recovers.
+type loadShedder struct {
+	p99Threshold time.Duration // 0 disables latency-based shedding
+	maxInFlight  int64         // 0 disables depth-based shedding
+
+	inFlight atomic.Int64
+	p99      atomic.Int64 // nanoseconds; see recomputeLocked
+
+	mu         sync.Mutex
+	samples    [shedSamples]shedSample
+	next       int
+	count      int
+	computedAt time.Time
+	scratch    []time.Duration
+
+	now func() time.Time // stubbed in tests
+}
+
+type shedSample struct {
+	at  time.Time
+	dur time.Duration
+}
+
+// newLoadShedder returns a shedder for the given thresholds, or nil when both
+// are disabled (the middleware then skips it entirely).
+func newLoadShedder(p99Threshold time.Duration, maxInFlight int) *loadShedder {
+	if p99Threshold <= 0 && maxInFlight <= 0 {
+		return nil
+	}
+	return &loadShedder{
+		p99Threshold: p99Threshold,
+		maxInFlight:  int64(maxInFlight),
+		scratch:      make([]time.Duration, 0, shedSamples),
+		now:          time.Now,
+	}
+}
+
+// admit reports whether a new request should be served. On true the caller
+// must call done with the request's latency once it finishes, or cancel if
+// it is not served after all.
+func (s *loadShedder) admit() bool {
+	depth := s.inFlight.Add(1)
+	if s.maxInFlight > 0 && depth > s.maxInFlight {
+		s.inFlight.Add(-1)
+		return false
+	}
+	if s.p99Threshold > 0 {
+		if frac := s.shedFraction(); frac > 0 && rand.Float64() < frac {
+			s.inFlight.Add(-1)
+			return false
+		}
+	}
+	return true
+}
+
+// done records the latency of an admitted request.
+func (s *loadShedder) done(d time.Duration) {
+	s.inFlight.Add(-1)
+	s.observe(d)
+}
+
+// cancel releases an admitted request that was not served, without
+// recording a latency for it.
+func (s *loadShedder) cancel() {
+	s.inFlight.Add(-1)
+}
+
+// shedFraction is the share of new requests to reject given the current p99.
+// An overloaded estimate is refreshed once it goes stale, so a burst of slow
+// requests followed by silence does not keep shedding forever.
+func (s *loadShedder) shedFraction() float64 {
+	p99 := time.Duration(s.p99.Load())
+	if p99 <= s.p99Threshold {
+		return 0
+	}
+	s.mu.Lock()
+	if now := s.now(); now.Sub(s.computedAt) >= shedRecomputeAge {
+		p99 = s.recomputeLocked(now)
+	}
+	s.mu.Unlock()
+	if p99 <= s.p99Threshold {
+		return 0
+	}
+	return min(float64(p99-s.p99Threshold)/float64(s.p99Threshold), maxShedFraction)
+}
+
+func (s *loadShedder) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.samples[s.next] = shedSample{at: now, dur: d}
+	s.next = (s.next + 1) % shedSamples
+	s.count++
+	if s.count%shedRecomputeEach == 0 || now.Sub(s.computedAt) >= shedRecomputeAge {
+		s.recomputeLocked(now)
+	}
+}
+
+// recomputeLocked refreshes and returns the p99 over samples younger than
+// shedWindow.
+func (s *loadShedder) recomputeLocked(now time.Time) time.Duration {
+	s.computedAt = now
+	s.scratch = s.scratch[:0]
+	for _, smp := range s.samples {
+		if !smp.at.IsZero() && now.Sub(smp.at) <= shedWindow {
+			s.scratch = append(s.scratch, smp.dur)
+		}
+	}
+	var p99 time.Duration
+	if len(s.scratch) > 0 {
+		slices.Sort(s.scratch)
+		p99 = s.scratch[(len(s.scratch)*99)/100]
+	}
+	s.p99.Store(int64(p99))
+	return p99
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewLoadShedder_DisabledIsNil(t *testing.T) {
+	if s := newLoadShedder(0, 0); s != nil {
+		t.Errorf("newLoadShedder(0, 0) = %v, want nil", s)
+	}
+}
+
+func TestLoadShedder_QueueDepth(t *testing.T) {
+	s := newLoadShedder(0, 2)
+	if !s.admit() || !s.admit() {
+		t.Fatal("first two requests must be admitted")
+	}
+	if s.admit() {
+		t.Fatal("third concurrent request must be shed")
+	}
+	s.done(time.Millisecond)
+	if !s.admit() {
+		t.Error("request must be admitted once depth drops below the limit")
+	}
+}
+
+func TestLoadShedder_P99(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newLoadShedder(100*time.Millisecond, 0)
+	s.now = func() time.Time { return now }
+
+	// Fast traffic: never shed.
+	for range shedRecomputeEach {
+		s.observe(10 * time.Millisecond)
+	}
+	if f := s.shedFraction(); f != 0 {
+		t.Fatalf("shedFraction with fast traffic = %v, want 0", f)
+	}
+
+	// Slow traffic: p99 at 3x the threshold sheds the maximum fraction.
+	for range shedSamples {
+		s.observe(300 * time.Millisecond)
+	}
+	if f := s.shedFraction(); f != maxShedFraction {
+		t.Fatalf("shedFraction with slow traffic = %v, want %v", f, maxShedFraction)
+	}
+
+	// Silence: once the samples age out the shedder recovers on its own.
+	now = now.Add(shedWindow + shedRecomputeAge)
+	if f := s.shedFraction(); f != 0 {
+		t.Errorf("shedFraction after window expiry = %v, want 0", f)
+	}
+}
+
+func TestLoadShedder_ConcurrencyRejectsNotSampled(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	m := &middleware{
+		sem:  make(chan struct{}, 1),
+		shed: newLoadShedder(100*time.Millisecond, 0),
+	}
+	m.shed.now = func() time.Time { return now }
+	for range shedSamples {
+		m.shed.observe(300 * time.Millisecond)
+	}
+	want := time.Duration(m.shed.p99.Load())
+
+	// Saturate the concurrency limiter: every request the shedder admits
+	// is turned away at once, and must leave no sample behind. The shedder
+	// admits about one in twenty, so this would refill its window.
+	m.sem <- struct{}{}
+	h := m.wrap(func(http.ResponseWriter, *http.Request) {}, scopePublic, true)
+	for range 40 * shedSamples {
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/route", nil))
+	}
+	if got := time.Duration(m.shed.p99.Load()); got != want {
+		t.Errorf("p99 after concurrency rejections = %v, want %v", got, want)
+	}
+	if n := m.shed.inFlight.Load(); n != 0 {
+		t.Errorf("in flight after rejections = %d, want 0", n)
+	}
+}
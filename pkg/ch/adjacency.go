@@ -0,0 +1,212 @@
+package ch
+
+import "math/bits"
+
+// adjChunkBits sets the size of adjacency's chunks: 1<<16 entries, 768 KiB.
+const (
+	adjChunkBits = 16
+	adjChunkSize = 1 << adjChunkBits
+	adjChunkMask = adjChunkSize - 1
+)
+
+// adjacency holds the mutable adjacency lists of a contraction. Rather than
+// a slice per node, each with its own header, allocation and append slack,
+// the lists live in blocks carved from a few large chunks: node u's list is
+// size[u] entries at block[u], with room for capacity[u]. A list that
+// outgrows its block moves to one of the next power of two, and the block
+// it leaves goes to a pool for the next list to grow to that size. Lists
+// can also be cut short for good. Once the unused blocks take up a third
+// of the chunks, the chunks are compacted. Chunks are never resized, so a
+// list stays put until it moves.
+type adjacency struct {
+	chunks         [][]adjEntry
+	tail           int      // index of the chunk blocks are carved from, -1 for none
+	block          []uint32 // chunk<<adjChunkBits | offset
+	size, capacity []uint32
+	pool           [adjChunkBits + 1][]uint32 // freed blocks of 1<<k entries
+
+	allocated int // entries in carved blocks, in use or not
+	wasted    int // entries in pooled blocks, abandoned blocks and chunk ends
+
+	live []int32 // per chunk, the lists in it not dropped yet; see drop
+}
+
+// newAdjacency returns lists for n nodes with room for degree[u] entries
+// each.
+func newAdjacency(n uint32, degree []uint32) *adjacency {
+	a := &adjacency{
+		tail:     -1,
+		block:    make([]uint32, n),
+		size:     make([]uint32, n),
+		capacity: make([]uint32, n),
+	}
+	for u := range n {
+		a.capacity[u] = degree[u]
+		a.block[u] = a.alloc(degree[u])
+	}
+	return a
+}
+
+// of returns u's list. It stays valid until the next add, which may move
+// the lists.
+func (a *adjacency) of(u uint32) []adjEntry {
+	b := a.block[u]
+	off := b & adjChunkMask
+	return a.chunks[b>>adjChunkBits][off : off+a.size[u]]
+}
+
+// add appends e to u's list.
+func (a *adjacency) add(u uint32, e adjEntry) {
+	if a.size[u] == a.capacity[u] {
+		a.grow(u)
+	}
+	b := a.block[u]
+	a.chunks[b>>adjChunkBits][b&adjChunkMask+a.size[u]] = e
+	a.size[u]++
+}
+
+// grow moves u's list to a block of the next power of two, at least 4.
+func (a *adjacency) grow(u uint32) {
+	old, oldBlock, oldCapacity := a.of(u), a.block[u], a.capacity[u]
+	capacity := max(4, uint32(1)<<bits.Len32(oldCapacity))
+	b := a.alloc(capacity)
+	copy(a.chunks[b>>adjChunkBits][b&adjChunkMask:], old)
+	a.block[u], a.capacity[u] = b, capacity
+	a.free(oldBlock, oldCapacity)
+	a.maybeCompact()
+}
+
+// retain keeps the entries of u's list that keep reports true for and
+// gives up the rest of its block, the list being done growing.
+func (a *adjacency) retain(u uint32, keep func(adjEntry) bool) {
+	list := a.of(u)
+	n := uint32(0)
+	for _, e := range list {
+		if keep(e) {
+			list[n] = e
+			n++
+		}
+	}
+	a.wasted += int(a.capacity[u] - n)
+	a.size[u], a.capacity[u] = n, n
+	a.maybeCompact()
+}
+
+// alloc returns a block of n entries, from the pool if it has one. Blocks
+// longer than a chunk get one of their own.
+func (a *adjacency) alloc(n uint32) uint32 {
+	if k, ok := poolClass(n); ok && len(a.pool[k]) > 0 {
+		pool := a.pool[k]
+		a.pool[k] = pool[:len(pool)-1]
+		a.wasted -= int(n)
+		return pool[len(pool)-1]
+	}
+	if n > adjChunkSize {
+		a.chunks = append(a.chunks, make([]adjEntry, n))
+		a.allocated += int(n)
+		return uint32(len(a.chunks)-1) << adjChunkBits
+	}
+	if a.tail < 0 || len(a.chunks[a.tail])+int(n) > adjChunkSize {
+		if a.tail >= 0 {
+			a.wasted += adjChunkSize - len(a.chunks[a.tail])
+			a.allocated += adjChunkSize - len(a.chunks[a.tail])
+		}
+		a.chunks = append(a.chunks, make([]adjEntry, 0, adjChunkSize))
+		a.tail = len(a.chunks) - 1
+	}
+	c := a.chunks[a.tail]
+	off := len(c)
+	a.chunks[a.tail] = c[:off+int(n)]
+	a.allocated += int(n)
+	return uint32(a.tail)<<adjChunkBits | uint32(off)
+}
+
+// free gives up the block b of n entries: a block of its own is freed, one
+// of a power of two is pooled, and any other is left to the next
+// compaction.
+func (a *adjacency) free(b, n uint32) {
+	if c := b >> adjChunkBits; len(a.chunks[c]) > adjChunkSize {
+		// retain may have cut the list short of its chunk.
+		a.allocated -= len(a.chunks[c])
+		a.wasted -= len(a.chunks[c]) - int(n)
+		a.chunks[c] = nil
+		return
+	}
+	if k, ok := poolClass(n); ok {
+		a.pool[k] = append(a.pool[k], b)
+	}
+	a.wasted += int(n)
+}
+
+// poolClass returns k for a block of n = 1<<k entries, which can be pooled.
+func poolClass(n uint32) (int, bool) {
+	k := bits.TrailingZeros32(n)
+	return k, n == 1<<k && k <= adjChunkBits
+}
+
+// maybeCompact compacts the chunks once a third of them is unused.
+func (a *adjacency) maybeCompact() {
+	if a.wasted > a.allocated/3 {
+		a.compact()
+	}
+}
+
+// compact copies the lists into fresh chunks, dropping the pooled and
+// abandoned blocks. It goes through the old chunks in turn and frees each
+// once its lists are copied, so it needs little more memory than the lists.
+func (a *adjacency) compact() {
+	old, live := a.chunks, a.liveCounts()
+
+	// Bucket the nodes by chunk.
+	first := make([]uint32, len(old)+1)
+	for c, k := range live {
+		first[c+1] = first[c] + uint32(k)
+	}
+	byChunk := make([]uint32, len(a.block))
+	for u, b := range a.block {
+		c := b >> adjChunkBits
+		byChunk[first[c]] = uint32(u)
+		first[c]++
+	}
+
+	a.chunks, a.tail, a.allocated, a.wasted = nil, -1, 0, 0
+	for k := range a.pool {
+		a.pool[k] = a.pool[k][:0]
+	}
+	for _, u := range byChunk {
+		b := a.block[u]
+		c, off := b>>adjChunkBits, b&adjChunkMask
+		nb := a.alloc(a.capacity[u])
+		copy(a.chunks[nb>>adjChunkBits][nb&adjChunkMask:], old[c][off:off+a.size[u]])
+		a.block[u] = nb
+		if live[c]--; live[c] == 0 {
+			old[c] = nil
+		}
+	}
+}
+
+// liveCounts returns the number of lists in each chunk.
+func (a *adjacency) liveCounts() []int32 {
+	live := make([]int32, len(a.chunks))
+	for _, b := range a.block {
+		live[b>>adjChunkBits]++
+	}
+	return live
+}
+
+// drop marks u's list as no longer needed, and frees its chunk once all
+// the lists in it are dropped. Lists cannot be added to after a drop.
+func (a *adjacency) drop(u uint32) {
+	if a.live == nil {
+		a.live = a.liveCounts()
+	}
+	c := a.block[u] >> adjChunkBits
+	if a.live[c]--; a.live[c] == 0 {
+		a.chunks[c] = nil
+	}
+}
+
+// release frees the lists.
+func (a *adjacency) release() {
+	*a = adjacency{}
+}
@@ -0,0 +1,55 @@
+package ch
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestAdjacency(t *testing.T) {
+	const n = 500
+	rng := rand.New(rand.NewPCG(1, 0))
+	degree := make([]uint32, n)
+	for u := range degree {
+		degree[u] = rng.Uint32N(6)
+	}
+	degree[7] = adjChunkSize + 1 // a list with a chunk of its own
+	a := newAdjacency(n, degree)
+	want := make([][]adjEntry, n)
+
+	// Grow the lists, now and then cutting one short, long enough to pool,
+	// reuse and compact blocks many times over.
+	for i := range 400000 {
+		u := rng.Uint32N(n)
+		if i%2 == 0 {
+			u = 7
+		}
+		if rng.IntN(1000) == 0 {
+			keep := func(e adjEntry) bool { return e.weight%3 != 0 }
+			a.retain(u, keep)
+			want[u] = slices.DeleteFunc(want[u], func(e adjEntry) bool { return !keep(e) })
+			continue
+		}
+		e := adjEntry{to: rng.Uint32N(n), weight: uint32(i), middle: -1}
+		a.add(u, e)
+		want[u] = append(want[u], e)
+	}
+	for u := range uint32(n) {
+		if !slices.Equal(a.of(u), want[u]) {
+			t.Fatalf("node %d: list of %d entries, want %d", u, len(a.of(u)), len(want[u]))
+		}
+	}
+	if a.wasted > a.allocated/3 {
+		t.Errorf("%d of %d entries unused, want a third at most", a.wasted, a.allocated)
+	}
+
+	// Dropping every list frees every chunk.
+	for u := range uint32(n) {
+		a.drop(u)
+	}
+	for c, chunk := range a.chunks {
+		if chunk != nil {
+			t.Errorf("chunk %d not freed", c)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package ch
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// A customizable contraction hierarchy (CCH) splits contraction in two. The
+// metric-independent phase contracts the graph's topology in a given order
+// without witness searches: contracting a node joins all its remaining
+// neighbours, which gives the chordal supergraph whose arcs join every pair
+// of nodes any metric could need a shortcut between. Customization then
+// weighs the arcs for one metric bottom-up through their lower triangles,
+// with no searches at all, so a new cost function (traffic, another
+// profile) takes a customization of seconds instead of a full contraction.
+
+// CCH is the metric-independent part of a customizable contraction
+// hierarchy: an order and the arcs of the chordal supergraph it gives.
+// NewCCH builds it once; Customize weighs it for any number of metrics.
+type CCH struct {
+	g     *graph.Graph
+	rank  []uint32
+	order []uint32 // nodes by rank
+
+	// Arcs join each node to its higher-ranked neighbours: node v's are
+	// upHead[firstUp[v]:firstUp[v+1]], sorted by rank. An arc stands for
+	// both directions; customization weighs each.
+	firstUp []uint32
+	upHead  []uint32
+
+	// edgeArc is the arc of each original edge (noArc for self-loops), and
+	// edgeUp whether the edge runs from the arc's lower end to its higher.
+	edgeArc []uint32
+	edgeUp  []bool
+}
+
+const noArc = ^uint32(0)
+
+// NewCCH contracts g's topology in the order rank gives, rank[v] being v's
+// position as in CHGraph.Rank. Any permutation gives a correct hierarchy;
+// how good an order it is decides the number of arcs. MinDegreeOrder suits
+// city-sized graphs, NestedDissectionOrder any size.
+func NewCCH(g *graph.Graph, rank []uint32) (*CCH, error) {
+	n := g.NumNodes
+	order, err := rankOrder(rank, n)
+	if err != nil {
+		return nil, err
+	}
+
+	byRank := func(a, b uint32) int { return cmp.Compare(rank[a], rank[b]) }
+	up := undirectedNeighbors(g)
+	for v := range up {
+		up[v] = slices.DeleteFunc(up[v], func(u uint32) bool { return rank[u] < rank[v] })
+		slices.SortFunc(up[v], byRank)
+	}
+	// Contracting v makes its higher neighbours a clique. Joining them to
+	// the lowest of them is enough: contracting that one passes them on.
+	for _, v := range order {
+		if len(up[v]) > 1 {
+			p := up[v][0]
+			up[p] = mergeSorted(up[p], up[v][1:], byRank)
+		}
+	}
+
+	c := &CCH{g: g, rank: rank, order: order, firstUp: make([]uint32, n+1)}
+	for v := range n {
+		c.firstUp[v+1] = c.firstUp[v] + uint32(len(up[v]))
+	}
+	c.upHead = make([]uint32, 0, c.firstUp[n])
+	for v := range up {
+		c.upHead = append(c.upHead, up[v]...)
+		up[v] = nil
+	}
+
+	c.edgeArc = make([]uint32, g.NumEdges)
+	c.edgeUp = make([]bool, g.NumEdges)
+	for u := range n {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			v := g.Head[e]
+			switch {
+			case u == v:
+				c.edgeArc[e] = noArc
+			case rank[u] < rank[v]:
+				c.edgeArc[e], c.edgeUp[e] = c.arc(u, v), true
+			default:
+				c.edgeArc[e] = c.arc(v, u)
+			}
+		}
+	}
+	log.Printf("CCH: %d arcs for %d nodes and %d edges", len(c.upHead), n, g.NumEdges)
+	return c, nil
+}
+
+// NumArcs returns the number of arcs, each of which becomes up to one
+// upward edge in each direction.
+func (c *CCH) NumArcs() int { return len(c.upHead) }
+
+// rankOrder returns the nodes by rank, checking that rank gives each of n
+// nodes its own.
+func rankOrder(rank []uint32, n uint32) ([]uint32, error) {
+	if len(rank) != int(n) {
+		return nil, fmt.Errorf("%d ranks for %d nodes", len(rank), n)
+	}
+	order := make([]uint32, n)
+	seen := make([]bool, n)
+	for v, r := range rank {
+		if r >= n || seen[r] {
+			return nil, fmt.Errorf("ranks are not a permutation: node %d has rank %d", v, r)
+		}
+		seen[r] = true
+		order[r] = uint32(v)
+	}
+	return order, nil
+}
+
+// arc returns the index of the arc between lo and the higher-ranked hi.
+func (c *CCH) arc(lo, hi uint32) uint32 {
+	arcs := c.upHead[c.firstUp[lo]:c.firstUp[lo+1]]
+	i, ok := slices.BinarySearchFunc(arcs, c.rank[hi], func(x, r uint32) int { return cmp.Compare(c.rank[x], r) })
+	if !ok {
+		panic(fmt.Sprintf("ch: no CCH arc %d-%d", lo, hi))
+	}
+	return c.firstUp[lo] + uint32(i)
+}
+
+// Customize weighs the hierarchy with weight, indexed like the graph's
+// edges, and returns it as a CHGraph whose original edge weights are
+// weight. Each direction of an arc takes the lightest of its original edges
+// and of the paths through its lower triangles, a triangle's middle node
+// becoming the shortcut's middle; directions no path takes are left out.
+func (c *CCH) Customize(weight []uint32) (*graph.CHGraph, error) {
+	if len(weight) != int(c.g.NumEdges) {
+		return nil, fmt.Errorf("%d weights for %d edges", len(weight), c.g.NumEdges)
+	}
+
+	// up is the weight of an arc from its lower end to its higher, down
+	// the other way.
+	arcs := len(c.upHead)
+	up, down := make([]uint32, arcs), make([]uint32, arcs)
+	upMiddle, downMiddle := make([]int32, arcs), make([]int32, arcs)
+	for i := range arcs {
+		up[i], down[i] = maxUint32, maxUint32
+		upMiddle[i], downMiddle[i] = -1, -1
+	}
+	for e, a := range c.edgeArc {
+		switch {
+		case a == noArc:
+		case c.edgeUp[e]:
+			up[a] = min(up[a], weight[e])
+		default:
+			down[a] = min(down[a], weight[e])
+		}
+	}
+
+	// A node's arcs are final once the nodes below it are done, so one pass
+	// in rank order relaxes every arc y-z through each lower neighbour v.
+	for _, v := range c.order {
+		lo, hi := c.firstUp[v], c.firstUp[v+1]
+		for i := lo; i < hi; i++ {
+			for j := i + 1; j < hi; j++ {
+				a := c.arc(c.upHead[i], c.upHead[j])
+				if w := addWeights(down[i], up[j]); w < up[a] {
+					up[a], upMiddle[a] = w, int32(v)
+				}
+				if w := addWeights(down[j], up[i]); w < down[a] {
+					down[a], downMiddle[a] = w, int32(v)
+				}
+			}
+		}
+	}
+
+	chg := overlayGraph(c.g, c.rank)
+	chg.OrigWeight = weight
+	chg.FwdFirstOut, chg.FwdHead, chg.FwdWeight, chg.FwdMiddle = c.upwardGraph(up, upMiddle)
+	chg.BwdFirstOut, chg.BwdHead, chg.BwdWeight, chg.BwdMiddle = c.upwardGraph(down, downMiddle)
+	return chg, nil
+}
+
+// upwardGraph lays out the arcs with a weight in CSR form, as the forward
+// or backward upward graph of a CHGraph.
+func (c *CCH) upwardGraph(weight []uint32, middle []int32) (firstOut, head, w []uint32, mid []int32) {
+	n := c.g.NumNodes
+	firstOut = make([]uint32, n+1)
+	for v := range n {
+		deg := uint32(0)
+		for a := c.firstUp[v]; a < c.firstUp[v+1]; a++ {
+			if weight[a] != maxUint32 {
+				deg++
+			}
+		}
+		firstOut[v+1] = firstOut[v] + deg
+	}
+	head = make([]uint32, 0, firstOut[n])
+	w = make([]uint32, 0, firstOut[n])
+	mid = make([]int32, 0, firstOut[n])
+	for a, wa := range weight {
+		if wa != maxUint32 {
+			head, w, mid = append(head, c.upHead[a]), append(w, wa), append(mid, middle[a])
+		}
+	}
+	return firstOut, head, w, mid
+}
+
+// addWeights adds two weights, maxUint32 standing for no path.
+func addWeights(a, b uint32) uint32 {
+	return uint32(min(uint64(a)+uint64(b), uint64(maxUint32)))
+}
+
+// contractCustomizable builds g's CCH in MinDegreeOrder, or
+// NestedDissectionOrder for OrderNestedDissection, and customizes it with
+// g's own weights, neither of which can fail.
+func contractCustomizable(g *graph.Graph, order Ordering) *graph.CHGraph {
+	if g.NumNodes == 0 {
+		return &graph.CHGraph{}
+	}
+	rank := MinDegreeOrder
+	if order == OrderNestedDissection {
+		rank = NestedDissectionOrder
+	}
+	c, err := NewCCH(g, rank(g))
+	if err != nil {
+		panic(err)
+	}
+	chg, err := c.Customize(g.Weight)
+	if err != nil {
+		panic(err)
+	}
+	return chg
+}
+
+// MinDegreeOrder returns an order for NewCCH, as ranks, by the minimum
+// degree heuristic of sparse elimination: it repeatedly takes the node with
+// the fewest remaining neighbours, counting those that contracting earlier
+// nodes joined it to. It needs no weights. The last nodes' neighbourhoods
+// grow with the graph, so it suits city-sized graphs rather than countries.
+func MinDegreeOrder(g *graph.Graph) []uint32 {
+	n := g.NumNodes
+	adj := undirectedNeighbors(g)
+	pq := newContractionPQ(int(n))
+	for v := range n {
+		pq.Push(v, len(adj[v]))
+	}
+	rank := make([]uint32, n)
+	done := make([]bool, n)
+	order := uint32(0)
+	for pq.Len() > 0 {
+		it := pq.Pop()
+		v := it.node
+		if done[v] || it.priority != len(adj[v]) {
+			continue // stale entry
+		}
+		done[v] = true
+		rank[v] = order
+		order++
+		for _, u := range adj[v] {
+			adj[u] = mergeSorted(adj[u], adj[v], cmp.Compare[uint32])
+			adj[u] = slices.DeleteFunc(adj[u], func(x uint32) bool { return x == u || x == v })
+			pq.Push(u, len(adj[u]))
+		}
+		adj[v] = nil
+	}
+	return rank
+}
+
+// undirectedNeighbors returns each node's neighbours along edges either
+// way, sorted by ID, without duplicates or the node itself.
+func undirectedNeighbors(g *graph.Graph) [][]uint32 {
+	adj := make([][]uint32, g.NumNodes)
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			if v := g.Head[e]; v != u {
+				adj[u] = append(adj[u], v)
+				adj[v] = append(adj[v], u)
+			}
+		}
+	}
+	for v := range adj {
+		slices.Sort(adj[v])
+		adj[v] = slices.Clip(slices.Compact(adj[v]))
+	}
+	return adj
+}
+
+// mergeSorted returns the union of a and b, both sorted by cmp without
+// duplicates, in a new slice.
+func mergeSorted(a, b []uint32, cmp func(x, y uint32) int) []uint32 {
+	out := make([]uint32, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch c := cmp(a[0], b[0]); {
+		case c < 0:
+			out, a = append(out, a[0]), a[1:]
+		case c > 0:
+			out, b = append(out, b[0]), b[1:]
+		default:
+			out, a, b = append(out, a[0]), a[1:], b[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
+}
@@ -0,0 +1,82 @@
+package ch
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestCCHCustomize(t *testing.T) {
+	g := buildGridGraph(t, 20, 4)
+	c, err := NewCCH(g, MinDegreeOrder(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Customize with the graph's weights, then with random ones.
+	rng := rand.New(rand.NewPCG(5, 0))
+	other := make([]uint32, g.NumEdges)
+	for e := range other {
+		other[e] = 50 + rng.Uint32N(500)
+	}
+	for _, weight := range [][]uint32{g.Weight, other} {
+		chg, err := c.Customize(weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkMiddles(t, chg)
+		h := *g
+		h.Weight = weight
+		for range 200 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(chg, s, d), plainDijkstra(&h, s, d); got != want {
+				t.Errorf("s=%d d=%d: CCH=%d, Dijkstra=%d", s, d, got, want)
+			}
+		}
+	}
+
+	if _, err := c.Customize(other[1:]); err == nil {
+		t.Error("Customize accepted too few weights")
+	}
+	rank := MinDegreeOrder(g)
+	rank[0] = rank[1]
+	if _, err := NewCCH(g, rank); err == nil {
+		t.Error("NewCCH accepted ranks that are not a permutation")
+	}
+}
+
+// checkMiddles checks that every shortcut of chg unpacks: the lightest
+// overlay edges from its tail to its middle and on to its head weigh as much
+// as the shortcut.
+func checkMiddles(t *testing.T, chg *graph.CHGraph) {
+	t.Helper()
+	lightest := func(from, to uint32) uint32 {
+		w := maxUint32
+		for i := chg.FwdFirstOut[from]; i < chg.FwdFirstOut[from+1]; i++ {
+			if chg.FwdHead[i] == to {
+				w = min(w, chg.FwdWeight[i])
+			}
+		}
+		for i := chg.BwdFirstOut[to]; i < chg.BwdFirstOut[to+1]; i++ {
+			if chg.BwdHead[i] == from {
+				w = min(w, chg.BwdWeight[i])
+			}
+		}
+		return w
+	}
+	for u := range chg.NumNodes {
+		for i := chg.FwdFirstOut[u]; i < chg.FwdFirstOut[u+1]; i++ {
+			v, m := chg.FwdHead[i], chg.FwdMiddle[i]
+			if m >= 0 && addWeights(lightest(u, uint32(m)), lightest(uint32(m), v)) != chg.FwdWeight[i] {
+				t.Fatalf("shortcut %d->%d via %d does not unpack", u, v, m)
+			}
+		}
+		for i := chg.BwdFirstOut[u]; i < chg.BwdFirstOut[u+1]; i++ {
+			v, m := chg.BwdHead[i], chg.BwdMiddle[i]
+			if m >= 0 && addWeights(lightest(v, uint32(m)), lightest(uint32(m), u)) != chg.BwdWeight[i] {
+				t.Fatalf("shortcut %d->%d via %d does not unpack", v, u, m)
+			}
+		}
+	}
+}
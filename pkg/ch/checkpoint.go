@@ -0,0 +1,229 @@
+package ch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// checkpointMagic prefixes a contraction checkpoint; bump the digit on
+// layout changes.
+const checkpointMagic = "MPRCKPT1"
+
+// defaultCheckpointInterval is the default of Options.CheckpointInterval.
+const defaultCheckpointInterval = 10 * time.Minute
+
+// checkpoint is the saved state of a contraction: with the graph it was
+// taken from, enough to carry on where it stopped.
+type checkpoint struct {
+	Nodes, Edges uint32
+	GraphCRC     uint32 // of FirstOut, Head and Weight
+	Order        Ordering
+
+	Contracted          []bool
+	Rank                []uint32
+	ContractedNeighbors []int
+	Level               []int
+	Shortcuts           []savedShortcut // every shortcut added, by tail
+}
+
+type savedShortcut struct {
+	From, To, Weight uint32
+	Middle           int32
+}
+
+// resume restores the state saved in opt.Checkpoint, if there is one for
+// this graph. A missing file is a fresh start; an unreadable one or one
+// for another graph is logged and ignored, to be overwritten.
+func (c *contractor) resume() {
+	path := c.opt.Checkpoint
+	c.lastCheckpoint = time.Now()
+	err := c.restore(path)
+	switch {
+	case err == nil:
+		log.Printf("Resuming contraction from %s: %d/%d nodes contracted, %d shortcuts",
+			path, c.order, c.g.NumNodes, c.totalShortcuts)
+	case !errors.Is(err, fs.ErrNotExist):
+		log.Printf("Ignoring checkpoint %s: %v", path, err)
+	}
+}
+
+// restore loads the checkpoint at path into the freshly built c.
+func (c *contractor) restore(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != checkpointMagic {
+		return fmt.Errorf("not a contraction checkpoint")
+	}
+	var cp checkpoint
+	if err := gob.NewDecoder(br).Decode(&cp); err != nil {
+		return fmt.Errorf("decode checkpoint: %w", err)
+	}
+
+	g := c.g
+	n := int(g.NumNodes)
+	switch {
+	case cp.Nodes != g.NumNodes || cp.Edges != g.NumEdges || cp.GraphCRC != graphCRC(g):
+		return fmt.Errorf("taken from another graph (%d nodes, %d edges)", cp.Nodes, cp.Edges)
+	case cp.Order != c.opt.Order:
+		return fmt.Errorf("taken with another contraction order")
+	case len(cp.Contracted) != n || len(cp.Rank) != n || len(cp.ContractedNeighbors) != n || len(cp.Level) != n:
+		return fmt.Errorf("node arrays do not match the graph")
+	}
+	for _, sc := range cp.Shortcuts {
+		if sc.From >= g.NumNodes || sc.To >= g.NumNodes || sc.Middle < 0 || int(sc.Middle) >= n {
+			return fmt.Errorf("shortcut %d->%d via %d is out of range", sc.From, sc.To, sc.Middle)
+		}
+	}
+
+	for _, sc := range cp.Shortcuts {
+		c.outAdj.add(sc.From, adjEntry{to: sc.To, weight: sc.Weight, middle: sc.Middle})
+		c.inAdj.add(sc.To, adjEntry{to: sc.From, weight: sc.Weight, middle: sc.Middle})
+	}
+	c.contracted, c.rank = cp.Contracted, cp.Rank
+	c.contractedNeighbors, c.level = cp.ContractedNeighbors, cp.Level
+	c.order = 0
+	for u, done := range c.contracted {
+		if done {
+			c.prune(uint32(u))
+			c.order++
+		}
+	}
+	c.totalShortcuts = len(cp.Shortcuts)
+	return nil
+}
+
+// maybeCheckpoint saves the state when opt.CheckpointInterval has passed
+// since the last save. It is called between contractions, when the state
+// is consistent; a failed save is logged and contraction carries on.
+func (c *contractor) maybeCheckpoint() {
+	interval := c.opt.CheckpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	if c.opt.Checkpoint == "" || time.Since(c.lastCheckpoint) < interval {
+		return
+	}
+	if err := c.saveCheckpoint(c.opt.Checkpoint); err != nil {
+		log.Printf("Checkpoint failed: %v", err)
+	} else {
+		log.Printf("Checkpoint: %d/%d nodes contracted, saved to %s", c.order, c.g.NumNodes, c.opt.Checkpoint)
+	}
+	c.lastCheckpoint = time.Now()
+}
+
+// cancelled saves the state of a contraction stopped by err, if it is
+// checkpointed and got anywhere, for the next run to resume from.
+func (c *contractor) cancelled(err error) {
+	log.Printf("Contraction cancelled at %d/%d nodes: %v", c.order, c.g.NumNodes, err)
+	if c.opt.Checkpoint == "" || c.order == c.startOrder {
+		return
+	}
+	if err := c.saveCheckpoint(c.opt.Checkpoint); err != nil {
+		log.Printf("Checkpoint failed: %v", err)
+	} else {
+		log.Printf("Checkpoint saved to %s", c.opt.Checkpoint)
+	}
+}
+
+// saveCheckpoint writes the state to path, through a temporary file so a
+// crash mid-write leaves the previous checkpoint intact.
+func (c *contractor) saveCheckpoint(path string) error {
+	cp := checkpoint{
+		Nodes:               c.g.NumNodes,
+		Edges:               c.g.NumEdges,
+		GraphCRC:            graphCRC(c.g),
+		Order:               c.opt.Order,
+		Contracted:          c.contracted,
+		Rank:                c.rank,
+		ContractedNeighbors: c.contractedNeighbors,
+		Level:               c.level,
+		Shortcuts:           make([]savedShortcut, 0, c.totalShortcuts),
+	}
+	// A shortcut is in its tail's out list unless prune dropped it from
+	// there, for leading down to a node contracted earlier; then it is in
+	// that node's in list.
+	for u := range c.g.NumNodes {
+		for _, e := range c.outAdj.of(u) {
+			if e.middle >= 0 {
+				cp.Shortcuts = append(cp.Shortcuts, savedShortcut{From: u, To: e.to, Weight: e.weight, Middle: e.middle})
+			}
+		}
+		for _, e := range c.inAdj.of(u) {
+			if e.middle >= 0 && c.contracted[u] && c.contracted[e.to] && c.rank[u] < c.rank[e.to] {
+				cp.Shortcuts = append(cp.Shortcuts, savedShortcut{From: e.to, To: u, Weight: e.weight, Middle: e.middle})
+			}
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // clean up on error
+	bw := bufio.NewWriter(f)
+	if _, err := bw.WriteString(checkpointMagic); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gob.NewEncoder(bw).Encode(&cp); err != nil {
+		f.Close()
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// removeCheckpoint deletes the checkpoint of a finished contraction.
+func (c *contractor) removeCheckpoint() {
+	if c.opt.Checkpoint == "" {
+		return
+	}
+	if err := os.Remove(c.opt.Checkpoint); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Removing checkpoint: %v", err)
+	}
+}
+
+// graphCRC checksums g's topology and weights, to tell whether a
+// checkpoint belongs to it.
+func graphCRC(g *graph.Graph) uint32 {
+	h := crc32.NewIEEE()
+	buf := make([]byte, 0, 64<<10)
+	for _, s := range [][]uint32{g.FirstOut, g.Head, g.Weight} {
+		for _, x := range s {
+			buf = binary.LittleEndian.AppendUint32(buf, x)
+			if len(buf) == cap(buf) {
+				h.Write(buf)
+				buf = buf[:0]
+			}
+		}
+	}
+	h.Write(buf)
+	return h.Sum32()
+}
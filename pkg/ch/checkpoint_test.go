@@ -0,0 +1,98 @@
+package ch
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	g := buildGridGraph(t, 20, 8)
+	path := filepath.Join(t.TempDir(), "contract.ckpt")
+	opt := Options{Order: OrderNestedDissection, Checkpoint: path}
+
+	// Contract half the nodes, as a build that crashed would have.
+	order := nestedDissection(g)
+	c := newContractor(g, opt)
+	c.contractInOrder(context.Background(), order[:len(order)/2])
+	if err := c.saveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newContractor(g, opt)
+	if err := r.restore(path); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if r.order != c.order || r.totalShortcuts != c.totalShortcuts || !slices.Equal(r.rank, c.rank) || !slices.Equal(r.level, c.level) {
+		t.Errorf("restored order %d, %d shortcuts; want %d, %d", r.order, r.totalShortcuts, c.order, c.totalShortcuts)
+	}
+	for u := range g.NumNodes {
+		if !slices.Equal(r.outAdj.of(u), c.outAdj.of(u)) {
+			t.Fatalf("node %d: restored out-edges %v, want %v", u, r.outAdj.of(u), c.outAdj.of(u))
+		}
+	}
+
+	// Resuming gives the same hierarchy as an uninterrupted run, and
+	// removes the checkpoint.
+	chg := ContractWith(g, opt)
+	if want := ContractWith(g, Options{Order: OrderNestedDissection}); !slices.Equal(chg.Rank, want.Rank) {
+		t.Error("resumed contraction ranks differ from an uninterrupted run's")
+	}
+	rng := rand.New(rand.NewPCG(9, 0))
+	for range 100 {
+		s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+		if got, want := chDijkstra(chg, s, d), plainDijkstra(g, s, d); got != want {
+			t.Errorf("s=%d d=%d: CH=%d, Dijkstra=%d", s, d, got, want)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left behind: %v", err)
+	}
+
+	// A checkpoint of another graph is refused.
+	if err := c.saveCheckpoint(path); err != nil {
+		t.Fatal(err)
+	}
+	other := buildGridGraph(t, 20, 9)
+	if err := newContractor(other, opt).restore(path); err == nil {
+		t.Error("restored a checkpoint of another graph")
+	}
+	if err := newContractor(g, Options{}).restore(path); err == nil {
+		t.Error("restored a checkpoint of another order")
+	}
+}
+
+func TestContractCancel(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	for _, batches := range []bool{false, true} {
+		// Cancel halfway, from the progress reports.
+		path := filepath.Join(t.TempDir(), "contract.ckpt")
+		ctx, cancel := context.WithCancel(context.Background())
+		opt := Options{Checkpoint: path, Progress: func(p Progress) {
+			if p.Contracted >= p.Nodes/2 {
+				cancel()
+			}
+		}}
+		if chg, err := contractContext(ctx, g, opt, batches); chg != nil || !errors.Is(err, context.Canceled) {
+			t.Fatalf("batches=%v: cancelled contraction returned %v", batches, err)
+		}
+
+		// The checkpoint it saved resumes to a correct hierarchy.
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("batches=%v: no checkpoint: %v", batches, err)
+		}
+		opt.Progress = nil
+		chg := contract(g, opt, batches)
+		rng := rand.New(rand.NewPCG(10, 0))
+		for range 100 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(chg, s, d), plainDijkstra(g, s, d); got != want {
+				t.Errorf("batches=%v s=%d d=%d: CH=%d, Dijkstra=%d", batches, s, d, got, want)
+			}
+		}
+	}
+}
@@ -1,14 +1,152 @@
 package ch
 
 import (
+	"context"
 	"log"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/azybler/map_router/pkg/graph"
 )
 
-// maxShortcutsPerNode is the limit on shortcuts a single contraction can create.
-// Nodes exceeding this form an uncontracted "core" at the top of the hierarchy.
-const maxShortcutsPerNode = 1000
+// Defaults of the Options limits.
+const (
+	defaultMaxShortcutsPerNode = 1000
+	defaultWitnessSettled      = 500
+	defaultWitnessHops         = 5
+)
+
+// defaultPriority weighs the edge difference once and contracted neighbours
+// twice, spreading contraction evenly over the graph.
+var defaultPriority = PriorityWeights{EdgeDifference: 1, ContractedNeighbors: 2, Level: 1}
+
+// minBatchContraction is the node count from which Contract contracts in
+// parallel batches. Smaller graphs take the sequential path, whose one node
+// at a time order gives a slightly smaller overlay and is fast enough.
+const minBatchContraction = 1 << 14
+
+// Ordering selects how nodes are ordered for contraction.
+type Ordering int
+
+const (
+	// OrderGreedy contracts the node of lowest priority next, from the
+	// shortcuts it would add and its contracted neighbours. Customizable
+	// hierarchies use MinDegreeOrder instead.
+	OrderGreedy Ordering = iota
+
+	// OrderNestedDissection contracts in NestedDissectionOrder, decided
+	// before contraction from the graph's shape alone.
+	OrderNestedDissection
+)
+
+// Options tunes Contract; the zero value is the defaults.
+type Options struct {
+	Order Ordering
+
+	// ExactPriority scores nodes by the shortcuts a witness search finds
+	// necessary instead of the in×out worst case. Every priority update then
+	// runs witness searches, making contraction several times slower, for a
+	// smaller overlay and faster queries. Only OrderGreedy has priorities.
+	ExactPriority bool
+
+	// Customizable builds a customizable hierarchy (see CCH) in the
+	// chosen order and customizes it with the graph's weights. It needs
+	// no witness searches, but gives more shortcuts than a contraction;
+	// ExactPriority does not apply.
+	Customizable bool
+
+	// Checkpoint, when set, is a file the contraction saves its state to
+	// every CheckpointInterval (default 10 minutes) and resumes from if it
+	// exists, so an interrupted build carries on where it stopped. A
+	// checkpoint of another graph or order is ignored and overwritten; it
+	// is removed once contraction completes. Customizable builds take
+	// seconds and are not checkpointed.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+
+	// MaxShortcutsPerNode is the limit on shortcuts a single contraction
+	// can create (default 1000). The first node exceeding it stops
+	// contraction, and the remaining nodes form an uncontracted "core" at
+	// the top of the hierarchy, which queries search by plain
+	// bidirectional Dijkstra. A lower limit builds faster for slower
+	// queries.
+	MaxShortcutsPerNode int
+
+	// CoreFraction, when above zero, stops contraction once no more than
+	// this share of the nodes is left, e.g. 0.02 to contract 98% of them,
+	// leaving the rest as the core. Contracting the last few percent takes
+	// a large part of the build, as the nodes left have the most
+	// neighbours, so a core of a few percent builds much faster, for
+	// queries that search it without shortcuts. Customizable builds
+	// contract every node.
+	CoreFraction float64
+
+	// WitnessSettled and WitnessHops bound each witness search: the nodes
+	// it settles (default 500) and the edges from its source (default 5).
+	// Higher limits find more witnesses, so fewer shortcuts and faster
+	// queries, for a longer build.
+	WitnessSettled, WitnessHops int
+
+	// AdaptiveWitness starts witness searches at WitnessSettled and
+	// WitnessHops and doubles either whenever it cut short more than a
+	// tenth of the recent searches, up to 16 times its start. Searches grow
+	// towards the top of the hierarchy, where fixed limits miss witnesses
+	// and add needless shortcuts, most of all on dense urban grids.
+	AdaptiveWitness bool
+
+	// Priority weighs the terms of a node's contraction priority; the
+	// zero value is the defaults.
+	Priority PriorityWeights
+
+	// Progress, when set, is called on the contracting goroutine every
+	// thousandth of the nodes or so, and once more with Done set when
+	// contraction stops. It should return quickly. Customizable builds
+	// do not report.
+	Progress func(Progress)
+}
+
+// Progress reports how far a contraction has got; see Options.Progress.
+type Progress struct {
+	Contracted, Nodes uint32
+	Shortcuts         int
+
+	// Core is the number of nodes not contracted yet; in the Done report,
+	// those left uncontracted at the top of the hierarchy.
+	Core uint32
+
+	// ETA estimates the time left from the rate so far, zero until there
+	// is one. Later nodes take longer to contract, so it errs short.
+	Elapsed, ETA time.Duration
+	Done         bool
+}
+
+// PriorityWeights weighs the terms of a node's contraction priority, the
+// lowest being contracted first: its edge difference (shortcuts added less
+// edges removed), its contracted neighbours, and its level, one more than
+// the highest of its contracted neighbours'. The defaults are 1, 2 and 1.
+type PriorityWeights struct {
+	EdgeDifference, ContractedNeighbors, Level int
+}
+
+// withDefaults fills in the options left zero.
+func (o Options) withDefaults() Options {
+	if o.MaxShortcutsPerNode <= 0 {
+		o.MaxShortcutsPerNode = defaultMaxShortcutsPerNode
+	}
+	if o.WitnessSettled <= 0 {
+		o.WitnessSettled = defaultWitnessSettled
+	}
+	if o.WitnessHops <= 0 {
+		o.WitnessHops = defaultWitnessHops
+	}
+	if o.Priority == (PriorityWeights{}) {
+		o.Priority = defaultPriority
+	}
+	return o
+}
 
 // adjEntry represents an edge in the mutable adjacency list.
 type adjEntry struct {
@@ -17,140 +155,538 @@ type adjEntry struct {
 	middle int32 // -1 for original edges, else the contracted node ID
 }
 
+// contractor holds the state of one contraction: the mutable adjacency
+// lists, which nodes are contracted, and the ranks handed out so far.
+type contractor struct {
+	g                   *graph.Graph
+	opt                 Options
+	outAdj, inAdj       *adjacency
+	contracted          []bool
+	rank                []uint32
+	contractedNeighbors []int
+	level               []int
+
+	order          uint32 // next rank
+	coreTarget     uint32 // nodes to leave uncontracted, from CoreFraction
+	totalShortcuts int
+	lastCheckpoint time.Time
+
+	start      time.Time
+	startOrder uint32 // nodes contracted before start, by a checkpoint
+}
+
 // Contract performs Contraction Hierarchies preprocessing on the given graph.
+// Graphs of minBatchContraction nodes or more contract independent nodes in
+// parallel batches; the result depends only on the graph, not on the
+// number of CPUs.
 func Contract(g *graph.Graph) *graph.CHGraph {
+	return ContractWith(g, Options{})
+}
+
+// ContractWith is Contract with options.
+func ContractWith(g *graph.Graph, opt Options) *graph.CHGraph {
+	chg, _ := ContractContext(context.Background(), g, opt) // fails only when cancelled
+	return chg
+}
+
+// ContractContext is ContractWith, stopping with ctx's error if ctx is
+// cancelled before contraction completes. Contraction checks between
+// nodes, so it stops within one node's contraction, or one batch's, having
+// first saved a checkpoint if opt.Checkpoint is set, for the next run to
+// resume from. Customizable builds take seconds and run to the end.
+func ContractContext(ctx context.Context, g *graph.Graph, opt Options) (*graph.CHGraph, error) {
+	if opt.Customizable {
+		return contractCustomizable(g, opt.Order), nil
+	}
+	return contractContext(ctx, g, opt, g.NumNodes >= minBatchContraction)
+}
+
+// contract is contractContext without cancellation.
+func contract(g *graph.Graph, opt Options, batches bool) *graph.CHGraph {
+	chg, _ := contractContext(context.Background(), g, opt, batches)
+	return chg
+}
+
+// ContractRanked contracts g in the order of rank, a node's position in
+// it, as given by the Rank of another hierarchy over the same nodes. The
+// order is decided once, by the costlier contraction of one metric, and
+// each further metric of the same roads, e.g. distance next to travel
+// time, takes only the witness searches of contracting in it; the
+// overlays then share their ranks. Like OrderNestedDissection, it stops at
+// a node needing more than MaxShortcutsPerNode shortcuts. opt.Order and
+// opt.Customizable do not apply, and it is not checkpointed.
+func ContractRanked(ctx context.Context, g *graph.Graph, rank []uint32, opt Options) (*graph.CHGraph, error) {
+	order, err := rankOrder(rank, g.NumNodes)
+	if err != nil {
+		return nil, err
+	}
+	opt.Checkpoint = ""
+	return contractFixed(ctx, g, opt, false, order)
+}
+
+func contractContext(ctx context.Context, g *graph.Graph, opt Options, batches bool) (*graph.CHGraph, error) {
+	var fixed []uint32
+	if opt.Order == OrderNestedDissection && g.NumNodes > 0 {
+		fixed = nestedDissection(g)
+	}
+	return contractFixed(ctx, g, opt, batches, fixed)
+}
+
+// contractFixed contracts g in the order fixed, or in one decided during
+// contraction when it is nil.
+func contractFixed(ctx context.Context, g *graph.Graph, opt Options, batches bool, fixed []uint32) (*graph.CHGraph, error) {
 	n := g.NumNodes
 	if n == 0 {
-		return &graph.CHGraph{}
+		return &graph.CHGraph{}, nil
+	}
+
+	c := newContractor(g, opt)
+	log.Printf("Starting contraction of %d nodes...", n)
+	if opt.Checkpoint != "" {
+		c.resume()
+	}
+	c.start, c.startOrder = time.Now(), c.order
+	var err error
+	switch {
+	case fixed != nil:
+		err = c.contractInOrder(ctx, fixed)
+	case batches:
+		err = c.contractBatches(ctx)
+	default:
+		err = c.contractSequential(ctx)
+	}
+	if err != nil {
+		c.cancelled(err)
+		return nil, err
 	}
 
-	// Build mutable forward and reverse adjacency lists from the CSR graph.
-	outAdj := make([][]adjEntry, n)
-	inAdj := make([][]adjEntry, n)
+	c.reportProgress(true)
 
+	// Assign ranks to remaining uncontracted core nodes, in the fixed order
+	// if there is one.
+	coreSize := uint32(0)
+	rankCore := func(i uint32) {
+		if !c.contracted[i] {
+			c.contracted[i] = true
+			c.rank[i] = c.order
+			c.order++
+			coreSize++
+		}
+	}
+	if fixed != nil {
+		for _, i := range fixed {
+			rankCore(i)
+		}
+	} else {
+		for i := range n {
+			rankCore(i)
+		}
+	}
+
+	log.Printf("Contraction complete: %d shortcuts created (%.1fx original edges), %d core nodes",
+		c.totalShortcuts, float64(c.totalShortcuts)/float64(g.NumEdges), coreSize)
+	c.removeCheckpoint()
+
+	// Build forward and backward upward CSR overlay.
+	return buildOverlay(g, c.outAdj, c.inAdj, c.rank, n-coreSize), nil
+}
+
+// newContractor builds mutable forward and reverse adjacency lists from the
+// CSR graph.
+func newContractor(g *graph.Graph, opt Options) *contractor {
+	n := g.NumNodes
+	c := &contractor{
+		g:                   g,
+		opt:                 opt.withDefaults(),
+		contracted:          make([]bool, n),
+		rank:                make([]uint32, n),
+		contractedNeighbors: make([]int, n),
+		level:               make([]int, n),
+		coreTarget:          uint32(min(max(opt.CoreFraction, 0), 1) * float64(n)),
+	}
+	outDegree, inDegree := make([]uint32, n), make([]uint32, n)
+	for u := range n {
+		outDegree[u] = g.FirstOut[u+1] - g.FirstOut[u]
+	}
+	for _, v := range g.Head {
+		inDegree[v]++
+	}
+	c.outAdj, c.inAdj = newAdjacency(n, outDegree), newAdjacency(n, inDegree)
 	for u := range n {
 		start, end := g.EdgesFrom(u)
 		for e := start; e < end; e++ {
 			v := g.Head[e]
 			w := g.Weight[e]
-			outAdj[u] = append(outAdj[u], adjEntry{to: v, weight: w, middle: -1})
-			inAdj[v] = append(inAdj[v], adjEntry{to: u, weight: w, middle: -1})
+			c.outAdj.add(u, adjEntry{to: v, weight: w, middle: -1})
+			c.inAdj.add(v, adjEntry{to: u, weight: w, middle: -1})
 		}
 	}
+	return c
+}
 
-	contracted := make([]bool, n)
-	rank := make([]uint32, n)
-	contractedNeighbors := make([]int, n)
-	level := make([]int, n)
-
-	// Initialize priority queue with all nodes.
-	pq := newContractionPQ(int(n))
-	for i := range n {
-		pq.Push(i, computePriority(outAdj, inAdj, i, contracted, contractedNeighbors[i], level[i]))
+// priority returns node's current contraction priority (lower = contract
+// first). ws is used for exact priorities.
+func (c *contractor) priority(ws *witnessState, node uint32) int {
+	var ed int
+	if c.opt.ExactPriority {
+		ed = exactEdgeDifference(ws, c.outAdj, c.inAdj, node, c.contracted)
+	} else {
+		ed = edgeDifference(c.outAdj, c.inAdj, node, c.contracted)
 	}
+	w := c.opt.Priority
+	return w.EdgeDifference*ed + w.ContractedNeighbors*c.contractedNeighbors[node] + w.Level*c.level[node]
+}
 
-	// Pre-allocate reusable witness search state.
-	ws := newWitnessState(n)
+// newWitnessState returns a witness state bounded by the options' limits.
+func (c *contractor) newWitnessState() *witnessState {
+	return newWitnessState(c.g.NumNodes, c.opt.WitnessSettled, c.opt.WitnessHops)
+}
 
-	log.Printf("Starting contraction of %d nodes...", n)
+// coreReached reports whether contraction has left no more nodes than the
+// CoreFraction target, logging that it stops if so.
+func (c *contractor) coreReached() bool {
+	left := c.g.NumNodes - c.order
+	if left > c.coreTarget || left == 0 {
+		return false
+	}
+	log.Printf("Stopping contraction: %d nodes remain in core, the target of %.1f%%.",
+		left, 100*c.opt.CoreFraction)
+	return true
+}
 
-	var totalShortcuts int
-	order := uint32(0)
+// contractSequential contracts one node at a time, always the one of lowest
+// priority, until all are contracted, the core target is reached, one needs
+// too many shortcuts or ctx is cancelled.
+func (c *contractor) contractSequential(ctx context.Context) error {
+	n := c.g.NumNodes
 
-	// Adaptive log interval: frequent near the end.
-	logInterval := uint32(50000)
+	// Pre-allocate reusable witness search state.
+	ws := c.newWitnessState()
+
+	// Initialize priority queue with all nodes.
+	pq := newContractionPQ(int(n))
+	for i := range n {
+		if !c.contracted[i] {
+			pq.Push(i, c.priority(ws, i))
+		}
+	}
 
 	for pq.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.coreReached() {
+			break
+		}
+
 		// Pop minimum-priority node.
 		entry := pq.Pop()
 		node := entry.node
 
-		if contracted[node] {
+		if c.contracted[node] {
 			continue
 		}
 
 		// Lazy update: recompute priority and re-insert if it changed.
-		newPriority := computePriority(outAdj, inAdj, node, contracted, contractedNeighbors[node], level[node])
+		newPriority := c.priority(ws, node)
 		if newPriority > entry.priority && pq.Len() > 0 && newPriority > pq.PeekPriority() {
 			pq.Push(node, newPriority)
 			continue
 		}
 
 		// Find shortcuts needed using batch witness search.
-		shortcuts := findShortcuts(ws, outAdj, inAdj, node, contracted)
+		shortcuts := findShortcuts(ws, c.outAdj, c.inAdj, node, c.contracted)
 
 		// If contracting this node would produce too many shortcuts,
 		// stop contraction entirely. Remaining nodes form a "core"
 		// at the top of the hierarchy with original edges preserved.
-		if len(shortcuts) > maxShortcutsPerNode {
+		if len(shortcuts) > c.opt.MaxShortcutsPerNode {
 			log.Printf("Stopping contraction: node %d would create %d shortcuts (limit %d). %d nodes remain in core.",
-				node, len(shortcuts), maxShortcutsPerNode, n-order)
+				node, len(shortcuts), c.opt.MaxShortcutsPerNode, n-c.order)
 			break
 		}
 
-		// Contract this node.
-		contracted[node] = true
-		rank[node] = order
-		order++
-		totalShortcuts += len(shortcuts)
+		c.contract(node, shortcuts, false)
+		c.adaptWitnessLimits(ws)
+		c.maybeCheckpoint()
+	}
+	return nil
+}
 
-		// Add shortcuts to adjacency lists.
-		for _, sc := range shortcuts {
-			outAdj[sc.from] = append(outAdj[sc.from], adjEntry{to: sc.to, weight: sc.weight, middle: int32(node)})
-			inAdj[sc.to] = append(inAdj[sc.to], adjEntry{to: sc.from, weight: sc.weight, middle: int32(node)})
+// contractInOrder contracts the nodes in the given order, stopping like
+// contractSequential at the core target, at a node that needs too many
+// shortcuts or when ctx is cancelled.
+func (c *contractor) contractInOrder(ctx context.Context, order []uint32) error {
+	ws := c.newWitnessState()
+	for _, node := range order {
+		if c.contracted[node] {
+			continue // before a checkpoint
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.coreReached() {
+			return nil
+		}
+		shortcuts := findShortcuts(ws, c.outAdj, c.inAdj, node, c.contracted)
+		if len(shortcuts) > c.opt.MaxShortcutsPerNode {
+			log.Printf("Stopping contraction: node %d would create %d shortcuts (limit %d). %d nodes remain in core.",
+				node, len(shortcuts), c.opt.MaxShortcutsPerNode, c.g.NumNodes-c.order)
+			return nil
+		}
+		c.contract(node, shortcuts, false)
+		c.adaptWitnessLimits(ws)
+		c.maybeCheckpoint()
+	}
+	return nil
+}
 
-		// Update neighbors' contracted neighbor count and level.
-		for _, e := range outAdj[node] {
-			if !contracted[e.to] {
-				contractedNeighbors[e.to]++
-				if level[node]+1 > level[e.to] {
-					level[e.to] = level[node] + 1
-				}
+// contractBatches contracts the graph in rounds. Each round takes, among
+// the lowest-priority eighth of the remaining nodes, those whose priority is
+// lower than all their remaining neighbours'. No two are adjacent, so their
+// witness searches run concurrently over adjacency lists nobody writes to,
+// each search treating the whole batch as contracted so that two batch nodes
+// never serve as each other's witness. The batch is then contracted in
+// priority order and the priorities of its neighbours refreshed. Ties are
+// broken by a hash of the node ID, so the order does not follow the input
+// numbering and is the same on any number of CPUs.
+//
+// The last round before the core target takes only the batch's
+// lowest-priority nodes that fit. Each worker holds a witness state of 4
+// bytes per node. Cancelling ctx stops contraction between rounds.
+func (c *contractor) contractBatches(ctx context.Context) error {
+	n := c.g.NumNodes
+	workers := runtime.GOMAXPROCS(0)
+
+	states := make([]*witnessState, workers)
+	for w := range states {
+		states[w] = c.newWitnessState()
+	}
+
+	prio := make([]int, n)
+	var remaining []uint32
+	for i := range n {
+		if !c.contracted[i] {
+			remaining = append(remaining, i)
+		}
+	}
+	forEachParallel(len(remaining), workers, func(w, i int) { prio[remaining[i]] = c.priority(states[w], remaining[i]) })
+
+	cmp := func(a, b uint32) int {
+		if prio[a] != prio[b] {
+			return prio[a] - prio[b]
+		}
+		return int(tieBreak(a)) - int(tieBreak(b))
+	}
+
+	var sorted []int
+	var shortcuts [][]shortcut // per batch node, reused from round to round
+	var batch, update []uint32
+	queued := make([]bool, n)
+	for len(remaining) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.coreReached() {
+			return nil
+		}
+		sorted = sorted[:0]
+		for _, u := range remaining {
+			sorted = append(sorted, prio[u])
+		}
+		slices.Sort(sorted)
+		limit := sorted[len(sorted)/8]
+
+		batch = batch[:0]
+		for _, u := range remaining {
+			if prio[u] <= limit && c.localMinimum(u, cmp) {
+				batch = append(batch, u)
 			}
 		}
-		for _, e := range inAdj[node] {
-			if !contracted[e.to] {
-				contractedNeighbors[e.to]++
-				if level[node]+1 > level[e.to] {
-					level[e.to] = level[node] + 1
+		slices.SortFunc(batch, cmp)
+		if c.coreTarget > 0 {
+			batch = batch[:min(len(batch), int(n-c.order-c.coreTarget))]
+		}
+
+		for _, u := range batch {
+			c.contracted[u] = true
+		}
+		for len(shortcuts) < len(batch) {
+			shortcuts = append(shortcuts, nil)
+		}
+		forEachParallel(len(batch), workers, func(w, i int) {
+			shortcuts[i] = append(shortcuts[i][:0], findShortcuts(states[w], c.outAdj, c.inAdj, batch[i], c.contracted)...)
+		})
+
+		// As in contractSequential, a node needing too many shortcuts stops
+		// contraction; the rest of its batch is independent of it and is
+		// still contracted.
+		var stop []uint32
+		for i, u := range batch {
+			if len(shortcuts[i]) > c.opt.MaxShortcutsPerNode {
+				c.contracted[u] = false
+				stop = append(stop, u)
+				continue
+			}
+			c.contract(u, shortcuts[i], true)
+		}
+		if len(stop) > 0 {
+			log.Printf("Stopping contraction: %d nodes, first %d, would create more than %d shortcuts each. %d nodes remain in core.",
+				len(stop), stop[0], c.opt.MaxShortcutsPerNode, n-c.order)
+			return nil
+		}
+
+		update = update[:0]
+		for _, u := range batch {
+			for _, adj := range [2][]adjEntry{c.outAdj.of(u), c.inAdj.of(u)} {
+				for _, e := range adj {
+					if !c.contracted[e.to] && !queued[e.to] {
+						queued[e.to] = true
+						update = append(update, e.to)
+					}
 				}
 			}
 		}
+		forEachParallel(len(update), workers, func(w, i int) { prio[update[i]] = c.priority(states[w], update[i]) })
+		for _, v := range update {
+			queued[v] = false
+		}
+		remaining = slices.DeleteFunc(remaining, func(u uint32) bool { return c.contracted[u] })
+		c.adaptWitnessLimits(states...)
+		c.maybeCheckpoint()
+	}
+	return nil
+}
 
-		// Adaptive logging: more frequent as we approach the end.
-		remaining := n - order
-		if remaining < 1000 {
-			logInterval = 100
-		} else if remaining < 10000 {
-			logInterval = 1000
-		} else if remaining < 100000 {
-			logInterval = 10000
-		} else {
-			logInterval = 50000
+// localMinimum reports whether u orders before all its uncontracted
+// neighbours by cmp.
+func (c *contractor) localMinimum(u uint32, cmp func(a, b uint32) int) bool {
+	for _, adj := range [2][]adjEntry{c.outAdj.of(u), c.inAdj.of(u)} {
+		for _, e := range adj {
+			if e.to != u && !c.contracted[e.to] && cmp(e.to, u) < 0 {
+				return false
+			}
 		}
+	}
+	return true
+}
+
+// tieBreak scrambles a node ID to order nodes of equal priority.
+func tieBreak(u uint32) uint32 {
+	u ^= u >> 16
+	u *= 0x7feb352d
+	u ^= u >> 15
+	u *= 0x846ca68b
+	u ^= u >> 16
+	return u
+}
 
-		if order%logInterval == 0 {
-			log.Printf("Contracted %d/%d nodes, %d shortcuts so far", order, n, totalShortcuts)
+// forEachParallel calls fn(w, i) for each i in [0, n) on up to workers
+// goroutines, w being the calling worker's index in [0, workers). Indexes
+// are handed out one at a time, as contractions vary widely in cost.
+func forEachParallel(n, workers int, fn func(w, i int)) {
+	workers = min(workers, n)
+	if workers <= 1 {
+		for i := range n {
+			fn(0, i)
 		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(w, i)
+			}
+		})
 	}
+	wg.Wait()
+}
 
-	// Assign ranks to remaining uncontracted core nodes.
-	coreSize := uint32(0)
-	for i := range n {
-		if !contracted[i] {
-			contracted[i] = true
-			rank[i] = order
-			order++
-			coreSize++
+// contract gives node the next rank and adds its shortcuts to the
+// adjacency lists. With dedupe, a shortcut is left out when an edge as short
+// already joins its ends, as when two nodes of a batch bypass the same pair
+// of neighbours.
+func (c *contractor) contract(node uint32, shortcuts []shortcut, dedupe bool) {
+	c.contracted[node] = true
+	c.rank[node] = c.order
+	c.order++
+
+	// Add shortcuts to adjacency lists.
+	for _, sc := range shortcuts {
+		if dedupe && slices.ContainsFunc(c.outAdj.of(sc.from), func(e adjEntry) bool { return e.to == sc.to && e.weight <= sc.weight }) {
+			continue
 		}
+		c.totalShortcuts++
+		c.outAdj.add(sc.from, adjEntry{to: sc.to, weight: sc.weight, middle: int32(node)})
+		c.inAdj.add(sc.to, adjEntry{to: sc.from, weight: sc.weight, middle: int32(node)})
 	}
 
-	log.Printf("Contraction complete: %d shortcuts created (%.1fx original edges), %d core nodes",
-		totalShortcuts, float64(totalShortcuts)/float64(g.NumEdges), coreSize)
+	// Update neighbors' contracted neighbor count and level.
+	for _, adj := range [2][]adjEntry{c.outAdj.of(node), c.inAdj.of(node)} {
+		for _, e := range adj {
+			if !c.contracted[e.to] {
+				c.contractedNeighbors[e.to]++
+				if c.level[node]+1 > c.level[e.to] {
+					c.level[e.to] = c.level[node] + 1
+				}
+			}
+		}
+	}
+	c.prune(node)
 
-	// Build forward and backward upward CSR overlay.
-	return buildOverlay(g, outAdj, inAdj, rank)
+	c.logProgress()
+	c.reportProgress(false)
+}
+
+// prune drops the edges to nodes contracted before node from its lists,
+// giving back their room. They lead down, so the overlay has no use for
+// them, and contraction only follows edges to uncontracted nodes; each
+// stays in the lower node's list, where it leads up. The nodes of a batch,
+// contracted but not all ranked yet, are never neighbours.
+func (c *contractor) prune(node uint32) {
+	up := func(e adjEntry) bool { return !c.contracted[e.to] || c.rank[e.to] > c.rank[node] }
+	c.outAdj.retain(node, up)
+	c.inAdj.retain(node, up)
+}
+
+// logProgress logs every so many contractions, more often near the end.
+func (c *contractor) logProgress() {
+	logInterval := uint32(50000)
+	remaining := c.g.NumNodes - c.order
+	if remaining < 1000 {
+		logInterval = 100
+	} else if remaining < 10000 {
+		logInterval = 1000
+	} else if remaining < 100000 {
+		logInterval = 10000
+	}
+
+	if c.order%logInterval == 0 {
+		log.Printf("Contracted %d/%d nodes, %d shortcuts so far", c.order, c.g.NumNodes, c.totalShortcuts)
+	}
+}
+
+// reportProgress calls opt.Progress every thousandth of the nodes, or at
+// once when done.
+func (c *contractor) reportProgress(done bool) {
+	n := c.g.NumNodes
+	if c.opt.Progress == nil || (!done && c.order%max(1, n/1000) != 0) {
+		return
+	}
+	p := Progress{
+		Contracted: c.order,
+		Nodes:      n,
+		Shortcuts:  c.totalShortcuts,
+		Core:       n - c.order,
+		Elapsed:    time.Since(c.start),
+		Done:       done,
+	}
+	if ran := c.order - c.startOrder; !done && ran > 0 {
+		p.ETA = time.Duration(float64(p.Elapsed) / float64(ran) * float64(p.Core))
+	}
+	c.opt.Progress(p)
 }
 
 // shortcut represents a shortcut edge to be added.
@@ -163,17 +699,17 @@ type shortcut struct {
 // Uses batch witness search: one Dijkstra per incoming neighbor instead of one
 // per (incoming, outgoing) pair. This reduces search count from O(|in|*|out|)
 // to O(|in|).
-func findShortcuts(ws *witnessState, outAdj, inAdj [][]adjEntry, node uint32, contracted []bool) []shortcut {
+func findShortcuts(ws *witnessState, outAdj, inAdj *adjacency, node uint32, contracted []bool) []shortcut {
 	// Collect active incoming and outgoing neighbors using reusable buffers.
 	ws.incoming = ws.incoming[:0]
-	for _, e := range inAdj[node] {
+	for _, e := range inAdj.of(node) {
 		if !contracted[e.to] {
 			ws.incoming = append(ws.incoming, e)
 		}
 	}
 
 	ws.outgoing = ws.outgoing[:0]
-	for _, e := range outAdj[node] {
+	for _, e := range outAdj.of(node) {
 		if !contracted[e.to] {
 			ws.outgoing = append(ws.outgoing, e)
 		}
@@ -224,17 +760,18 @@ func findShortcuts(ws *witnessState, outAdj, inAdj [][]adjEntry, node uint32, co
 	return ws.shortcuts
 }
 
-// computePriority returns the priority for a node (lower = contract first).
-func computePriority(outAdj, inAdj [][]adjEntry, node uint32, contracted []bool, contractedNeighbors, level int) int {
+// edgeDifference estimates the shortcuts contracting node adds less the
+// edges it removes.
+func edgeDifference(outAdj, inAdj *adjacency, node uint32, contracted []bool) int {
 	// Count active incoming/outgoing edges.
 	activeIn := 0
-	for _, e := range inAdj[node] {
+	for _, e := range inAdj.of(node) {
 		if !contracted[e.to] {
 			activeIn++
 		}
 	}
 	activeOut := 0
-	for _, e := range outAdj[node] {
+	for _, e := range outAdj.of(node) {
 		if !contracted[e.to] {
 			activeOut++
 		}
@@ -243,93 +780,101 @@ func computePriority(outAdj, inAdj [][]adjEntry, node uint32, contracted []bool,
 	// Count shortcuts that would be needed (simplified: worst case = in * out).
 	// For accurate count we'd run witness search, but for ordering a simpler
 	// heuristic is faster and good enough.
-	edgeDifference := activeIn*activeOut - (activeIn + activeOut)
+	return activeIn*activeOut - (activeIn + activeOut)
+}
 
-	return edgeDifference + 2*contractedNeighbors + level
+// exactEdgeDifference is edgeDifference counting the shortcuts a witness
+// search finds necessary rather than every in/out pair.
+func exactEdgeDifference(ws *witnessState, outAdj, inAdj *adjacency, node uint32, contracted []bool) int {
+	shortcuts := len(findShortcuts(ws, outAdj, inAdj, node, contracted))
+	// findShortcuts leaves the active neighbours in ws.incoming/outgoing.
+	return shortcuts - (len(ws.incoming) + len(ws.outgoing))
 }
 
 // buildOverlay creates forward and backward upward CSR graphs from the
-// contracted adjacency lists and node ranks.
-func buildOverlay(orig *graph.Graph, outAdj, inAdj [][]adjEntry, rank []uint32) *graph.CHGraph {
+// contracted adjacency lists and node ranks. The CSR arrays are sized by a
+// counting pass and filled straight from the adjacency lists, whose chunks
+// are released as the lists in them are consumed, so peak memory is the
+// adjacency lists plus the final arrays rather than an intermediate edge
+// list on top.
+//
+// Nodes ranked coreRank or higher form the uncontracted core, if contraction
+// stopped early. Edges between core nodes are kept both ways up and down,
+// so the query's upward searches become plain bidirectional Dijkstra once
+// they enter the core, and find paths that wander up and down in it.
+func buildOverlay(orig *graph.Graph, outAdj, inAdj *adjacency, rank []uint32, coreRank uint32) *graph.CHGraph {
 	n := orig.NumNodes
 
-	// Collect forward upward edges: edge u→v where rank[u] < rank[v].
-	type csrEdge struct {
-		from, to uint32
-		weight   uint32
-		middle   int32
+	// Forward upward edges are u→v with rank[u] < rank[v]; backward upward
+	// edges v→u with rank[u] < rank[v], stored as u→v in the backward graph
+	// (for the backward search from the target).
+	keep := func(u uint32, e adjEntry) bool {
+		return rank[u] < rank[e.to] || (rank[u] >= coreRank && rank[e.to] >= coreRank && u != e.to)
 	}
-
-	var fwdEdges, bwdEdges []csrEdge
-
-	for u := range n {
-		for _, e := range outAdj[u] {
-			if rank[u] < rank[e.to] {
-				fwdEdges = append(fwdEdges, csrEdge{from: u, to: e.to, weight: e.weight, middle: e.middle})
-			}
-		}
-		// Backward upward: for edges v→u where rank[u] < rank[v],
-		// store as u→v in the backward graph (for backward search from target).
-		for _, e := range inAdj[u] {
-			if rank[u] < rank[e.to] {
-				bwdEdges = append(bwdEdges, csrEdge{from: u, to: e.to, weight: e.weight, middle: e.middle})
+	buildCSR := func(adj *adjacency) (firstOut, head []uint32, weight []uint32, middle []int32) {
+		firstOut = make([]uint32, n+1)
+		for u := range n {
+			var deg uint32
+			for _, e := range adj.of(u) {
+				if keep(u, e) {
+					deg++
+				}
 			}
+			firstOut[u+1] = firstOut[u] + deg
 		}
-	}
 
-	log.Printf("Overlay: %d forward upward edges, %d backward upward edges", len(fwdEdges), len(bwdEdges))
-
-	buildCSR := func(edges []csrEdge) (firstOut, head []uint32, weight []uint32, middle []int32) {
-		numEdges := uint32(len(edges))
-		firstOut = make([]uint32, n+1)
+		numEdges := firstOut[n]
 		head = make([]uint32, numEdges)
 		weight = make([]uint32, numEdges)
 		middle = make([]int32, numEdges)
-
-		// Count edges per source.
-		for _, e := range edges {
-			firstOut[e.from+1]++
-		}
-		for i := uint32(1); i <= n; i++ {
-			firstOut[i] += firstOut[i-1]
-		}
-
-		// Place edges.
-		pos := make([]uint32, n)
-		copy(pos, firstOut[:n])
-		for _, e := range edges {
-			idx := pos[e.from]
-			head[idx] = e.to
-			weight[idx] = e.weight
-			middle[idx] = e.middle
-			pos[e.from]++
+		for u := range n {
+			idx := firstOut[u]
+			for _, e := range adj.of(u) {
+				if keep(u, e) {
+					head[idx], weight[idx], middle[idx] = e.to, e.weight, e.middle
+					idx++
+				}
+			}
+			adj.drop(u)
 		}
-
+		adj.release()
 		return
 	}
 
-	fwdFirstOut, fwdHead, fwdWeight, fwdMiddle := buildCSR(fwdEdges)
-	bwdFirstOut, bwdHead, bwdWeight, bwdMiddle := buildCSR(bwdEdges)
+	fwdFirstOut, fwdHead, fwdWeight, fwdMiddle := buildCSR(outAdj)
+	bwdFirstOut, bwdHead, bwdWeight, bwdMiddle := buildCSR(inAdj)
+
+	log.Printf("Overlay: %d forward upward edges, %d backward upward edges", len(fwdHead), len(bwdHead))
+
+	chg := overlayGraph(orig, rank)
+	chg.FwdFirstOut, chg.FwdHead, chg.FwdWeight, chg.FwdMiddle = fwdFirstOut, fwdHead, fwdWeight, fwdMiddle
+	chg.BwdFirstOut, chg.BwdHead, chg.BwdWeight, chg.BwdMiddle = bwdFirstOut, bwdHead, bwdWeight, bwdMiddle
+	return chg
+}
 
+// overlayGraph returns a CHGraph over orig's nodes and edges with the given
+// ranks, for the caller to fill in the upward graphs.
+func overlayGraph(orig *graph.Graph, rank []uint32) *graph.CHGraph {
 	return &graph.CHGraph{
-		NumNodes:     n,
+		NumNodes:     orig.NumNodes,
 		NodeLat:      orig.NodeLat,
 		NodeLon:      orig.NodeLon,
 		Rank:         rank,
-		FwdFirstOut:  fwdFirstOut,
-		FwdHead:      fwdHead,
-		FwdWeight:    fwdWeight,
-		FwdMiddle:    fwdMiddle,
-		BwdFirstOut:  bwdFirstOut,
-		BwdHead:      bwdHead,
-		BwdWeight:    bwdWeight,
-		BwdMiddle:    bwdMiddle,
 		OrigFirstOut: orig.FirstOut,
 		OrigHead:     orig.Head,
 		OrigWeight:   orig.Weight,
 		GeoFirstOut:  orig.GeoFirstOut,
 		GeoShapeLat:  orig.GeoShapeLat,
 		GeoShapeLon:  orig.GeoShapeLon,
+		EdgeName:     orig.EdgeName,
+		Names:        orig.Names,
+		EdgeAttr:     orig.EdgeAttr,
+		EdgeLimits:   orig.EdgeLimits,
+		EdgePenalty:  orig.EdgePenalty,
+		NodeOSMID:    orig.NodeOSMID,
+		EdgeWayID:    orig.EdgeWayID,
+
+		TurnRestrictions: orig.TurnRestrictions,
 	}
 }
 
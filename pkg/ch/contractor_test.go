@@ -1,7 +1,11 @@
 package ch
 
 import (
+	"context"
 	"math"
+	"math/rand/v2"
+	"runtime"
+	"slices"
 	"testing"
 
 	"github.com/paulmach/osm"
@@ -284,3 +288,211 @@ func TestContractLinearGraph(t *testing.T) {
 		t.Errorf("linear chain: CH=%d, Dijkstra=%d", dist, expected)
 	}
 }
+
+// buildGridGraph creates a side×side grid whose streets run both ways,
+// except every third row one way, with weights from a small set so that
+// many paths tie.
+func buildGridGraph(t *testing.T, side int, seed uint64) *graph.Graph {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(seed, 0))
+	b := graph.NewBuilder()
+	for r := range side {
+		for c := range side {
+			b.AddNode(1+float64(r)*0.001, 103+float64(c)*0.001)
+		}
+	}
+	add := func(u, v int, twoWay bool) {
+		w := uint32(100 * (1 + rng.IntN(2)))
+		if _, err := b.AddEdge(uint32(u), uint32(v), w); err != nil {
+			t.Fatal(err)
+		}
+		if twoWay {
+			if _, err := b.AddEdge(uint32(v), uint32(u), w); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for r := range side {
+		for c := range side {
+			u := r*side + c
+			if c+1 < side {
+				add(u, u+1, r%3 != 0)
+			}
+			if r+1 < side {
+				add(u, u+side, true)
+			}
+		}
+	}
+	return b.Freeze()
+}
+
+func TestContractBatches(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	ch := contract(g, Options{}, true)
+
+	rng := rand.New(rand.NewPCG(2, 0))
+	for range 300 {
+		s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+		if got, want := chDijkstra(ch, s, d), plainDijkstra(g, s, d); got != want {
+			t.Errorf("s=%d d=%d: CH=%d, Dijkstra=%d", s, d, got, want)
+		}
+	}
+
+	// The order does not depend on the number of workers.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	if one := contract(g, Options{}, true); !slices.Equal(one.Rank, ch.Rank) || !slices.Equal(one.FwdHead, ch.FwdHead) {
+		t.Error("contraction on one CPU differs")
+	}
+}
+
+func TestContractExactPriority(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	heuristic := contract(g, Options{}, false)
+	rng := rand.New(rand.NewPCG(3, 0))
+	for _, batches := range []bool{false, true} {
+		ch := contract(g, Options{ExactPriority: true}, batches)
+		for range 100 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(ch, s, d), plainDijkstra(g, s, d); got != want {
+				t.Errorf("batches=%v s=%d d=%d: CH=%d, Dijkstra=%d", batches, s, d, got, want)
+			}
+		}
+		if !batches {
+			exact, worst := len(ch.FwdHead)+len(ch.BwdHead), len(heuristic.FwdHead)+len(heuristic.BwdHead)
+			if exact >= worst {
+				t.Errorf("exact priority overlay has %d edges, heuristic %d", exact, worst)
+			}
+		}
+	}
+}
+
+func TestContractOptions(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	defaults := contract(g, Options{}, false)
+	rng := rand.New(rand.NewPCG(4, 0))
+	for name, opt := range map[string]Options{
+		"small core limit":  {MaxShortcutsPerNode: 2},
+		"short witnesses":   {WitnessSettled: 3, WitnessHops: 1, MaxShortcutsPerNode: 1 << 20},
+		"edge difference":   {Priority: PriorityWeights{EdgeDifference: 1}},
+		"neighbours, level": {Priority: PriorityWeights{ContractedNeighbors: 1, Level: 3}},
+	} {
+		for _, batches := range []bool{false, true} {
+			ch := contract(g, opt, batches)
+			for range 100 {
+				s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+				if got, want := chDijkstra(ch, s, d), plainDijkstra(g, s, d); got != want {
+					t.Errorf("%s, batches=%v s=%d d=%d: CH=%d, Dijkstra=%d", name, batches, s, d, got, want)
+				}
+			}
+			if name == "short witnesses" && !batches && len(ch.FwdHead) <= len(defaults.FwdHead) {
+				t.Errorf("short witness searches gave %d forward edges, defaults %d", len(ch.FwdHead), len(defaults.FwdHead))
+			}
+		}
+	}
+}
+
+func TestContractShortcutLimit(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	for _, limit := range []int{2, 0} {
+		c := newContractor(g, Options{MaxShortcutsPerNode: limit})
+		c.contractSequential(context.Background())
+		if core := g.NumNodes - c.order; (core > 0) != (limit > 0) {
+			t.Errorf("limit %d left a core of %d nodes", limit, core)
+		}
+	}
+}
+
+func TestContractCoreFraction(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	rng := rand.New(rand.NewPCG(7, 0))
+	for _, order := range []Ordering{OrderGreedy, OrderNestedDissection} {
+		for _, batches := range []bool{false, true} {
+			var last Progress
+			opt := Options{Order: order, CoreFraction: 0.1, MaxShortcutsPerNode: 1 << 20, Progress: func(p Progress) { last = p }}
+			ch := contract(g, opt, batches)
+			if last.Core != g.NumNodes/10 {
+				t.Errorf("order %d, batches=%v: core of %d nodes, want %d", order, batches, last.Core, g.NumNodes/10)
+			}
+			for range 100 {
+				s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+				if got, want := chDijkstra(ch, s, d), plainDijkstra(g, s, d); got != want {
+					t.Errorf("order %d, batches=%v s=%d d=%d: CH=%d, Dijkstra=%d", order, batches, s, d, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestContractRanked(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	rng := rand.New(rand.NewPCG(8, 0))
+	primary := Contract(g)
+
+	// A second metric of the same roads, contracted in the first's order.
+	other := *g
+	other.Weight = make([]uint32, g.NumEdges)
+	for e := range other.Weight {
+		other.Weight[e] = 50 + rng.Uint32N(500)
+	}
+	for _, opt := range []Options{{}, {MaxShortcutsPerNode: 2}, {CoreFraction: 0.05}} {
+		chg, err := ContractRanked(context.Background(), &other, primary.Rank, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(chg.Rank, primary.Rank) {
+			t.Errorf("%+v: ranks differ from the order given", opt)
+		}
+		for range 100 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(chg, s, d), plainDijkstra(&other, s, d); got != want {
+				t.Errorf("%+v s=%d d=%d: CH=%d, Dijkstra=%d", opt, s, d, got, want)
+			}
+		}
+	}
+	if _, err := ContractRanked(context.Background(), g, primary.Rank[1:], Options{}); err == nil {
+		t.Error("accepted too few ranks")
+	}
+}
+
+func TestContractProgress(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	for _, batches := range []bool{false, true} {
+		var reports []Progress
+		ch := contract(g, Options{Progress: func(p Progress) { reports = append(reports, p) }}, batches)
+		if len(reports) < 100 {
+			t.Fatalf("batches=%v: %d reports", batches, len(reports))
+		}
+		for i, p := range reports[1:] {
+			if prev := reports[i]; p.Contracted < prev.Contracted || p.Shortcuts < prev.Shortcuts || prev.Done {
+				t.Errorf("batches=%v: report %+v after %+v", batches, p, prev)
+			}
+		}
+		last := reports[len(reports)-1]
+		if !last.Done || last.Contracted+last.Core != g.NumNodes || last.Nodes != g.NumNodes {
+			t.Errorf("batches=%v: last report %+v", batches, last)
+		}
+		if edges := len(ch.FwdHead) + len(ch.BwdHead); last.Shortcuts == 0 || last.Shortcuts > edges {
+			t.Errorf("batches=%v: %d shortcuts reported, overlay has %d edges", batches, last.Shortcuts, edges)
+		}
+	}
+}
+
+func TestContractAdaptiveWitness(t *testing.T) {
+	g := buildGridGraph(t, 40, 1)
+	tight := Options{WitnessSettled: 20, WitnessHops: 2, MaxShortcutsPerNode: 1 << 20}
+	fixed := contract(g, tight, false)
+	tight.AdaptiveWitness = true
+	rng := rand.New(rand.NewPCG(5, 0))
+	for _, batches := range []bool{false, true} {
+		ch := contract(g, tight, batches)
+		for range 100 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(ch, s, d), plainDijkstra(g, s, d); got != want {
+				t.Errorf("batches=%v s=%d d=%d: CH=%d, Dijkstra=%d", batches, s, d, got, want)
+			}
+		}
+		if !batches && len(ch.FwdHead) >= len(fixed.FwdHead) {
+			t.Errorf("adaptive limits gave %d forward edges, fixed %d", len(ch.FwdHead), len(fixed.FwdHead))
+		}
+	}
+}
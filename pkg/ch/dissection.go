@@ -0,0 +1,332 @@
+package ch
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// ndLeafSize is the cell size from which NestedDissectionOrder stops
+// cutting and ranks a cell's nodes by degree.
+const ndLeafSize = 32
+
+// NestedDissectionOrder returns an order, as ranks, by nested dissection
+// with inertial flow. A cell (at first the whole graph) is cut in two by a
+// small set of separator nodes, which are ranked above both halves, and
+// each half is ordered the same way. To cut a cell, its nodes are sorted
+// along four directions through their coordinates; for each, the first and
+// last quarter are tied to a source and a sink and a minimum node cut
+// between them is found by max-flow, and the smallest of the four cuts is
+// taken. Long routes then cross few separators, so contraction adds fewer
+// shortcuts and queries settle fewer nodes. It needs no weights.
+func NestedDissectionOrder(g *graph.Graph) []uint32 {
+	order := nestedDissection(g)
+	rank := make([]uint32, g.NumNodes)
+	for r, v := range order {
+		rank[v] = uint32(r)
+	}
+	return rank
+}
+
+// nestedDissection returns g's nodes in nested dissection order.
+func nestedDissection(g *graph.Graph) []uint32 {
+	n := g.NumNodes
+	d := &dissector{
+		g:     g,
+		adj:   undirectedNeighbors(g),
+		local: make([]int32, n),
+		order: make([]uint32, 0, n),
+	}
+	for i := range d.local {
+		d.local[i] = -1
+	}
+	cell := make([]uint32, n)
+	for i := range cell {
+		cell[i] = uint32(i)
+	}
+	d.dissect(cell)
+	return d.order
+}
+
+// dissector holds the state of a nested dissection. Cells are subslices of
+// one node slice, reordered in place as they are split.
+type dissector struct {
+	g     *graph.Graph
+	adj   [][]uint32
+	local []int32 // a node's index in the cell being examined, else -1
+	order []uint32
+}
+
+// dissect appends cell's nodes to the order, separators after the parts
+// they separate. It reorders cell.
+func (d *dissector) dissect(cell []uint32) {
+	if sizes := d.components(cell); len(sizes) > 1 {
+		for _, size := range sizes {
+			d.dissect(cell[:size])
+			cell = cell[size:]
+		}
+		return
+	}
+	if len(cell) <= ndLeafSize {
+		slices.SortFunc(cell, func(a, b uint32) int {
+			return cmp.Or(cmp.Compare(len(d.adj[a]), len(d.adj[b])), cmp.Compare(a, b))
+		})
+		d.order = append(d.order, cell...)
+		return
+	}
+	rest := d.separate(cell)
+	d.dissect(cell[:rest])
+	d.dissect(cell[rest:])
+}
+
+// components reorders cell so each connected component of the subgraph it
+// induces is contiguous, and returns their sizes.
+func (d *dissector) components(cell []uint32) []int {
+	d.enter(cell)
+	defer d.leave(cell)
+	seen := make([]bool, len(cell))
+	queue := make([]uint32, 0, len(cell))
+	var sizes []int
+	for i, v := range cell {
+		if seen[i] {
+			continue
+		}
+		start := len(queue)
+		seen[i] = true
+		queue = append(queue, v)
+		for k := start; k < len(queue); k++ {
+			for _, u := range d.adj[queue[k]] {
+				if j := d.local[u]; j >= 0 && !seen[j] {
+					seen[j] = true
+					queue = append(queue, u)
+				}
+			}
+		}
+		sizes = append(sizes, len(queue)-start)
+	}
+	copy(cell, queue)
+	return sizes
+}
+
+// separate finds a small node separator of the connected cell and reorders
+// cell to put it last, returning the number of nodes before it.
+func (d *dissector) separate(cell []uint32) int {
+	d.enter(cell)
+	defer d.leave(cell)
+	lat := func(v uint32) int64 { return int64(d.g.NodeLat[v]) }
+	lon := func(v uint32) int64 { return int64(d.g.NodeLon[v]) }
+	directions := []func(v uint32) int64{
+		lat,
+		lon,
+		func(v uint32) int64 { return lat(v) + lon(v) },
+		func(v uint32) int64 { return lat(v) - lon(v) },
+	}
+	var best []bool
+	bestSize := len(cell) + 1
+	byKey := slices.Clone(cell)
+	for _, key := range directions {
+		slices.SortFunc(byKey, func(a, b uint32) int { return cmp.Or(cmp.Compare(key(a), key(b)), cmp.Compare(a, b)) })
+		if sep, size := d.minCut(cell, byKey); size < bestSize {
+			best, bestSize = sep, size
+		}
+	}
+
+	// Stable partition: the rest in their order, then the separator.
+	out := make([]uint32, 0, len(cell))
+	for i, v := range cell {
+		if !best[i] {
+			out = append(out, v)
+		}
+	}
+	rest := len(out)
+	for i, v := range cell {
+		if best[i] {
+			out = append(out, v)
+		}
+	}
+	copy(cell, out)
+	return rest
+}
+
+// minCut ties the first and last quarter of byKey to a source and a sink
+// and returns a minimum set of cell's nodes (by index) whose removal
+// disconnects them, and its size. Each node i is split into an entry 2i and
+// an exit 2i+1 joined by an arc of capacity 1, so that cuts are node sets;
+// all other arcs have capacity 2, more than any flow through them.
+func (d *dissector) minCut(cell, byKey []uint32) ([]bool, int) {
+	m := len(cell)
+	end := max(1, m/4)
+	f := newFlowNetwork(2*m + 2)
+	s, t := int32(2*m), int32(2*m+1)
+	for i, v := range cell {
+		in, out := int32(2*i), int32(2*i+1)
+		f.addArc(in, out, 1)
+		for _, u := range d.adj[v] {
+			if j := d.local[u]; j >= 0 {
+				f.addArc(out, 2*j, 2)
+			}
+		}
+	}
+	for _, v := range byKey[:end] {
+		f.addArc(s, 2*d.local[v], 2)
+	}
+	for _, v := range byKey[m-end:] {
+		f.addArc(2*d.local[v]+1, t, 2)
+	}
+	f.freeze()
+	f.maxFlow(s, t)
+
+	reach := f.reachable(s)
+	sep := make([]bool, m)
+	size := 0
+	for i := range m {
+		if reach[2*i] && !reach[2*i+1] {
+			sep[i] = true
+			size++
+		}
+	}
+	return sep, size
+}
+
+// enter indexes cell's nodes in d.local; leave clears them.
+func (d *dissector) enter(cell []uint32) {
+	for i, v := range cell {
+		d.local[v] = int32(i)
+	}
+}
+
+func (d *dissector) leave(cell []uint32) {
+	for _, v := range cell {
+		d.local[v] = -1
+	}
+}
+
+// flowNetwork is a residual graph for Dinic's max-flow: arcs are added in
+// pairs, arc a's reverse being a^1, then laid out by tail in CSR form.
+type flowNetwork struct {
+	n        int
+	tail     []int32 // while building
+	head     []int32
+	capacity []int32
+
+	first []int32 // arcs of node v: arcs[first[v]:first[v+1]]
+	arcs  []int32
+	level []int32
+	next  []int32 // per node, the next arc for the blocking-flow search
+}
+
+func newFlowNetwork(n int) *flowNetwork {
+	return &flowNetwork{n: n}
+}
+
+// addArc adds an arc from u to v and its reverse of capacity 0.
+func (f *flowNetwork) addArc(u, v, capacity int32) {
+	f.tail = append(f.tail, u, v)
+	f.head = append(f.head, v, u)
+	f.capacity = append(f.capacity, capacity, 0)
+}
+
+// freeze groups the arcs by tail.
+func (f *flowNetwork) freeze() {
+	f.first = make([]int32, f.n+1)
+	for _, u := range f.tail {
+		f.first[u+1]++
+	}
+	for v := range f.n {
+		f.first[v+1] += f.first[v]
+	}
+	f.arcs = make([]int32, len(f.tail))
+	fill := slices.Clone(f.first[:f.n])
+	for a, u := range f.tail {
+		f.arcs[fill[u]] = int32(a)
+		fill[u]++
+	}
+	f.tail = nil
+	f.level = make([]int32, f.n)
+	f.next = make([]int32, f.n)
+}
+
+// maxFlow pushes as much flow from s to t as the capacities allow. Every
+// path crosses a unit arc, so each augmentation carries 1.
+func (f *flowNetwork) maxFlow(s, t int32) {
+	for f.levels(s, t) {
+		copy(f.next, f.first[:f.n])
+		for f.augment(s, t) {
+		}
+	}
+}
+
+// levels computes BFS distances from s over arcs with capacity left and
+// reports whether t is reachable.
+func (f *flowNetwork) levels(s, t int32) bool {
+	for i := range f.level {
+		f.level[i] = -1
+	}
+	f.level[s] = 0
+	queue := []int32{s}
+	for k := 0; k < len(queue); k++ {
+		u := queue[k]
+		for _, a := range f.arcs[f.first[u]:f.first[u+1]] {
+			if v := f.head[a]; f.capacity[a] > 0 && f.level[v] < 0 {
+				f.level[v] = f.level[u] + 1
+				queue = append(queue, v)
+			}
+		}
+	}
+	return f.level[t] >= 0
+}
+
+// augment finds one path from s to t along increasing levels and pushes a
+// unit of flow along it, reporting whether there was one. Arcs found to
+// lead nowhere are skipped by later calls in the same phase.
+func (f *flowNetwork) augment(s, t int32) bool {
+	var path []int32 // arcs from s
+	u := s
+	for u != t {
+		advanced := false
+		for ; f.next[u] < f.first[u+1]; f.next[u]++ {
+			a := f.arcs[f.next[u]]
+			if v := f.head[a]; f.capacity[a] > 0 && f.level[v] == f.level[u]+1 {
+				path = append(path, a)
+				u = v
+				advanced = true
+				break
+			}
+		}
+		if advanced {
+			continue
+		}
+		if u == s {
+			return false
+		}
+		// Dead end: retreat and skip the arc that led here.
+		f.level[u] = -1
+		a := path[len(path)-1]
+		path = path[:len(path)-1]
+		u = f.head[a^1]
+		f.next[u]++
+	}
+	for _, a := range path {
+		f.capacity[a]--
+		f.capacity[a^1]++
+	}
+	return true
+}
+
+// reachable marks the nodes reachable from s over arcs with capacity left.
+func (f *flowNetwork) reachable(s int32) []bool {
+	seen := make([]bool, f.n)
+	seen[s] = true
+	queue := []int32{s}
+	for k := 0; k < len(queue); k++ {
+		u := queue[k]
+		for _, a := range f.arcs[f.first[u]:f.first[u+1]] {
+			if v := f.head[a]; f.capacity[a] > 0 && !seen[v] {
+				seen[v] = true
+				queue = append(queue, v)
+			}
+		}
+	}
+	return seen
+}
@@ -0,0 +1,55 @@
+package ch
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestNestedDissectionOrder(t *testing.T) {
+	g := buildGridGraph(t, 25, 6)
+	rank := NestedDissectionOrder(g)
+	sorted := slices.Clone(rank)
+	slices.Sort(sorted)
+	for i, r := range sorted {
+		if r != uint32(i) {
+			t.Fatalf("ranks are not a permutation: sorted[%d] = %d", i, r)
+		}
+	}
+
+	// The first cut of the grid is a line across it, at most a diagonal,
+	// which leaves it in at least two parts and takes the top ranks.
+	d := &dissector{g: g, adj: undirectedNeighbors(g), local: make([]int32, g.NumNodes)}
+	for i := range d.local {
+		d.local[i] = -1
+	}
+	cell := make([]uint32, g.NumNodes)
+	for i := range cell {
+		cell[i] = uint32(i)
+	}
+	d.components(cell)
+	rest := d.separate(cell)
+	sep := cell[rest:]
+	if len(sep) == 0 || len(sep) > 2*25 {
+		t.Fatalf("top separator has %d nodes", len(sep))
+	}
+	if parts := d.components(cell[:rest]); len(parts) < 2 {
+		t.Errorf("top separator leaves %d part", len(parts))
+	}
+	for _, v := range sep {
+		if rank[v] < g.NumNodes-uint32(len(sep)) {
+			t.Errorf("separator node %d has rank %d, below the top %d", v, rank[v], len(sep))
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(7, 0))
+	for _, opt := range []Options{{Order: OrderNestedDissection}, {Order: OrderNestedDissection, Customizable: true}} {
+		chg := ContractWith(g, opt)
+		for range 150 {
+			s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+			if got, want := chDijkstra(chg, s, d), plainDijkstra(g, s, d); got != want {
+				t.Errorf("%+v s=%d d=%d: CH=%d, Dijkstra=%d", opt, s, d, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package ch
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// EdgeChange gives an original edge, by index, a new weight in
+// milliseconds. A closed road takes a weight longer than any detour.
+type EdgeChange struct {
+	Edge, Weight uint32
+}
+
+// Repair returns chg's hierarchy for its graph with changes applied to the
+// edge weights, contracting again only the nodes the changes reach rather
+// than the whole graph. The order is kept. Going up it, a node takes back
+// the shortcuts it had unless an edge it met when contracted has changed,
+// or a witness search of its could have crossed one that got slower; then
+// it is contracted again, and any of its shortcuts that come out different
+// are changes passed on to the nodes above.
+//
+// chg must come from Contract, with its Rank: graph files do not keep
+// ranks. A customizable hierarchy is re-weighed with Customize instead.
+// opt must carry the witness limits chg was contracted with, as the hop
+// limit bounds where its witness searches went.
+func Repair(chg *graph.CHGraph, changes []EdgeChange, opt Options) (*graph.CHGraph, error) {
+	n := chg.NumNodes
+	order, err := rankOrder(chg.Rank, n)
+	if err != nil {
+		return nil, err
+	}
+	g := chg.OrigGraph()
+	g.EdgePenalty = chg.EdgePenalty
+	g.Weight = slices.Clone(chg.OrigWeight)
+	for _, c := range changes {
+		if c.Edge >= g.NumEdges {
+			return nil, fmt.Errorf("edge %d changed, graph has %d", c.Edge, g.NumEdges)
+		}
+		g.Weight[c.Edge] = c.Weight
+	}
+
+	r := newRepairer(chg, opt)
+	tail := func(e uint32) uint32 {
+		u, _ := slices.BinarySearch(g.FirstOut, e+1)
+		return uint32(u - 1)
+	}
+	type pair struct{ from, to uint32 }
+	pairs := map[pair]bool{}
+	for _, c := range changes {
+		pairs[pair{tail(c.Edge), g.Head[c.Edge]}] = true
+	}
+	for p := range pairs {
+		var before, after []uint32
+		start, end := g.EdgesFrom(p.from)
+		for e := start; e < end; e++ {
+			if g.Head[e] == p.to {
+				before, after = append(before, chg.OrigWeight[e]), append(after, g.Weight[e])
+			}
+		}
+		r.changed(p.from, p.to, before, after, 0)
+	}
+
+	c := newContractor(g, opt)
+	c.start = time.Now()
+	ws := c.newWitnessState()
+	recontracted := 0
+	for _, v := range order[:r.coreRank] {
+		old := r.shortcuts[r.firstShortcut[v]:r.firstShortcut[v+1]]
+		if !r.dirty[v] {
+			c.contract(v, old, false)
+			continue
+		}
+		recontracted++
+		shortcuts := keepShortcuts(c, v, old, findShortcuts(ws, c.outAdj, c.inAdj, v, c.contracted))
+		r.compare(old, shortcuts, chg.Rank[v]+1)
+		c.contract(v, shortcuts, false)
+	}
+	for _, v := range order[r.coreRank:] {
+		c.contracted[v] = true
+		c.rank[v] = c.order
+		c.order++
+	}
+
+	log.Printf("Repair: %d of %d nodes contracted again for %d changed edges, %d shortcuts",
+		recontracted, r.coreRank, len(changes), c.totalShortcuts)
+	return buildOverlay(g, c.outAdj, c.inAdj, c.rank, r.coreRank), nil
+}
+
+// keepShortcuts returns the shortcuts contracting v again adds: those the
+// witness searches found needed and those it had before, each pair of
+// neighbours joined once through v's lightest edges to them. Keeping a
+// shortcut the searches now find a witness for does no harm, while
+// dropping it would change the graph the nodes above were contracted in.
+func keepShortcuts(c *contractor, v uint32, old, needed []shortcut) []shortcut {
+	lightest := func(adj *adjacency) map[uint32]uint32 {
+		m := map[uint32]uint32{}
+		for _, e := range adj.of(v) {
+			if w, ok := m[e.to]; !c.contracted[e.to] && (!ok || e.weight < w) {
+				m[e.to] = e.weight
+			}
+		}
+		return m
+	}
+	in, out := lightest(c.inAdj), lightest(c.outAdj)
+	var shortcuts []shortcut
+	seen := map[[2]uint32]bool{}
+	for _, sc := range slices.Concat(needed, old) {
+		a, okIn := in[sc.from]
+		b, okOut := out[sc.to]
+		if okIn && okOut && !seen[[2]uint32{sc.from, sc.to}] {
+			seen[[2]uint32{sc.from, sc.to}] = true
+			shortcuts = append(shortcuts, shortcut{from: sc.from, to: sc.to, weight: a + b})
+		}
+	}
+	return shortcuts
+}
+
+// repairer tracks which nodes a Repair must contract again.
+type repairer struct {
+	rank  []uint32
+	hops  int    // the most hops a witness search of chg's contraction took
+	dirty []bool // nodes to contract again
+
+	// The old shortcuts of each node, shortcuts[firstShortcut[v]:
+	// firstShortcut[v+1]], and the edges leaving and reaching each node in
+	// the old overlay, likewise.
+	firstShortcut     []uint32
+	shortcuts         []shortcut
+	firstOut, firstIn []uint32
+	out, in           []repairEdge
+
+	// coreRank is the rank of the lowest node left uncontracted, or the
+	// node count if all were contracted.
+	coreRank uint32
+}
+
+// newRepairer collects chg's shortcuts by the node they bypass, its
+// neighbours and its core.
+func newRepairer(chg *graph.CHGraph, opt Options) *repairer {
+	n := chg.NumNodes
+	opt = opt.withDefaults()
+	r := &repairer{
+		rank:          chg.Rank,
+		hops:          opt.WitnessHops,
+		dirty:         make([]bool, n),
+		firstShortcut: make([]uint32, n+1),
+		firstOut:      make([]uint32, n+1),
+		firstIn:       make([]uint32, n+1),
+		coreRank:      n,
+	}
+	if opt.AdaptiveWitness {
+		r.hops *= adaptMaxFactor
+	}
+
+	// Edges between core nodes are the only ones leading down, and are in
+	// the forward graph of one end and the backward graph of the other.
+	// Any other edge is in one of them, that of its lower end.
+	for u := range n {
+		for _, w := range chg.FwdHead[chg.FwdFirstOut[u]:chg.FwdFirstOut[u+1]] {
+			if chg.Rank[u] > chg.Rank[w] {
+				r.coreRank = min(r.coreRank, chg.Rank[w])
+			}
+		}
+		for _, w := range chg.BwdHead[chg.BwdFirstOut[u]:chg.BwdFirstOut[u+1]] {
+			if chg.Rank[u] > chg.Rank[w] {
+				r.coreRank = min(r.coreRank, chg.Rank[w])
+			}
+		}
+	}
+	each := func(fn func(from, to, weight uint32, middle int32)) {
+		for u := range n {
+			for i := chg.FwdFirstOut[u]; i < chg.FwdFirstOut[u+1]; i++ {
+				fn(u, chg.FwdHead[i], chg.FwdWeight[i], chg.FwdMiddle[i])
+			}
+			if chg.Rank[u] >= r.coreRank {
+				continue
+			}
+			for i := chg.BwdFirstOut[u]; i < chg.BwdFirstOut[u+1]; i++ {
+				fn(chg.BwdHead[i], u, chg.BwdWeight[i], chg.BwdMiddle[i])
+			}
+		}
+	}
+	each(func(from, to, _ uint32, middle int32) {
+		if middle >= 0 {
+			r.firstShortcut[middle+1]++
+		}
+		r.firstOut[from+1]++
+		r.firstIn[to+1]++
+	})
+	for v := range n {
+		r.firstShortcut[v+1] += r.firstShortcut[v]
+		r.firstOut[v+1] += r.firstOut[v]
+		r.firstIn[v+1] += r.firstIn[v]
+	}
+	r.shortcuts = make([]shortcut, r.firstShortcut[n])
+	r.out, r.in = make([]repairEdge, r.firstOut[n]), make([]repairEdge, r.firstIn[n])
+	nextShortcut := slices.Clone(r.firstShortcut[:n])
+	nextOut, nextIn := slices.Clone(r.firstOut[:n]), slices.Clone(r.firstIn[:n])
+	each(func(from, to, weight uint32, middle int32) {
+		since := uint32(0)
+		if middle >= 0 {
+			r.shortcuts[nextShortcut[middle]] = shortcut{from: from, to: to, weight: weight}
+			nextShortcut[middle]++
+			since = chg.Rank[middle] + 1
+		}
+		r.out[nextOut[from]] = repairEdge{to, since}
+		r.in[nextIn[to]] = repairEdge{from, since}
+		nextOut[from]++
+		nextIn[to]++
+	})
+	return r
+}
+
+// compare marks the nodes to contract again as the shortcuts of a node
+// contracted again differ from its old ones; after is the rank above it.
+func (r *repairer) compare(old, shortcuts []shortcut, after uint32) {
+	byPair := func(a, b shortcut) int {
+		return cmp.Or(cmp.Compare(a.from, b.from), cmp.Compare(a.to, b.to), cmp.Compare(a.weight, b.weight))
+	}
+	old, shortcuts = slices.Clone(old), slices.Clone(shortcuts)
+	slices.SortFunc(old, byPair)
+	slices.SortFunc(shortcuts, byPair)
+	for len(old) > 0 || len(shortcuts) > 0 {
+		var next shortcut
+		switch {
+		case len(old) == 0:
+			next = shortcuts[0]
+		case len(shortcuts) == 0 || byPair(old[0], shortcuts[0]) < 0:
+			next = old[0]
+		default:
+			next = shortcuts[0]
+		}
+		var before, now []uint32
+		for len(old) > 0 && old[0].from == next.from && old[0].to == next.to {
+			before, old = append(before, old[0].weight), old[1:]
+		}
+		for len(shortcuts) > 0 && shortcuts[0].from == next.from && shortcuts[0].to == next.to {
+			now, shortcuts = append(now, shortcuts[0].weight), shortcuts[1:]
+		}
+		r.changed(next.from, next.to, before, now, after)
+	}
+}
+
+// changed marks the nodes to contract again as the edges from→to, which
+// nodes ranked after or higher meet, went from weights before to now: the
+// lower end, whose contraction meets them, if the weights differ, and if
+// the lightest got heavier, the nodes whose witness searches could have
+// taken it.
+func (r *repairer) changed(from, to uint32, before, now []uint32, after uint32) {
+	slices.Sort(before)
+	slices.Sort(now)
+	if slices.Equal(before, now) {
+		return
+	}
+	if r.rank[from] < r.rank[to] {
+		r.dirty[from] = true
+	} else {
+		r.dirty[to] = true
+	}
+	lightest := func(w []uint32) uint32 {
+		if len(w) == 0 {
+			return maxUint32
+		}
+		return w[0]
+	}
+	if lightest(now) > lightest(before) {
+		r.markWitnesses(from, to, after)
+	}
+}
+
+// markWitnesses marks the nodes whose witness searches could have taken
+// the edge from→to, which nodes ranked after or higher met. Contracting v,
+// a search starts at a node u with an edge to v and reaches from within
+// r.hops-1 more edges, all of them there since before v and leading
+// through nodes ranked above v to to. The search follows such chains of
+// edges back from from, keeping the ranks v may have for each: from the
+// highest rank an edge of it appeared at, up to below its lowest node.
+func (r *repairer) markWitnesses(from, to, after uint32) {
+	type label struct {
+		node   uint32
+		lo, hi uint32 // v may be ranked lo up to hi, excluded
+	}
+	labels := map[uint32][]label{}
+	frontier := []label{{from, after, min(r.rank[from], r.rank[to])}}
+	for hop := range r.hops {
+		var next []label
+		for _, l := range frontier {
+			for _, e := range r.out[r.firstOut[l.node]:r.firstOut[l.node+1]] {
+				if rank := r.rank[e.node]; max(l.lo, e.since) <= rank && rank < l.hi {
+					r.dirty[e.node] = true
+				}
+			}
+			if hop+1 == r.hops {
+				continue
+			}
+			for _, e := range r.in[r.firstIn[l.node]:r.firstIn[l.node+1]] {
+				u := label{e.node, max(l.lo, e.since), min(l.hi, r.rank[e.node])}
+				if u.lo >= u.hi || slices.ContainsFunc(labels[u.node], func(x label) bool { return x.lo <= u.lo && x.hi >= u.hi }) {
+					continue
+				}
+				labels[u.node] = append(labels[u.node], u)
+				next = append(next, u)
+			}
+		}
+		frontier = next
+	}
+}
+
+// repairEdge is an edge of the old overlay as seen from one end: its other
+// end, and the rank from which on it was there, one above that of the node
+// it bypasses or 0 for an original edge.
+type repairEdge struct {
+	node, since uint32
+}
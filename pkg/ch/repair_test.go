@@ -0,0 +1,67 @@
+package ch
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	g := buildGridGraph(t, 30, 1)
+	rng := rand.New(rand.NewPCG(6, 0))
+	for name, opt := range map[string]Options{
+		"defaults":        {},
+		"small core":      {MaxShortcutsPerNode: 2},
+		"short witnesses": {WitnessSettled: 10, WitnessHops: 2},
+	} {
+		for _, batches := range []bool{false, true} {
+			chg := contract(g, opt, batches)
+			// Slow some roads down, close some and speed some up, three
+			// times over, checking each repair against Dijkstra on the
+			// changed graph.
+			for round := range 3 {
+				var changes []EdgeChange
+				for range 10 {
+					e := rng.Uint32N(g.NumEdges)
+					w := []uint32{chg.OrigWeight[e] / 2, chg.OrigWeight[e] * 3, 1 << 24}[rng.IntN(3)]
+					changes = append(changes, EdgeChange{Edge: e, Weight: max(1, w)})
+				}
+				repaired, err := Repair(chg, changes, opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				changed := repaired.OrigGraph()
+				for range 100 {
+					s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+					if got, want := chDijkstra(repaired, s, d), plainDijkstra(changed, s, d); got != want {
+						t.Errorf("%s, batches=%v, round %d: s=%d d=%d: CH=%d, Dijkstra=%d", name, batches, round, s, d, got, want)
+					}
+				}
+				if !slices.Equal(repaired.Rank, chg.Rank) {
+					t.Errorf("%s, batches=%v, round %d: order changed", name, batches, round)
+				}
+				chg = repaired
+			}
+		}
+	}
+}
+
+func TestRepairUnchanged(t *testing.T) {
+	g := buildGridGraph(t, 20, 2)
+	chg := Contract(g)
+	same, err := Repair(chg, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(same.FwdHead, chg.FwdHead) || !slices.Equal(same.FwdWeight, chg.FwdWeight) ||
+		!slices.Equal(same.BwdHead, chg.BwdHead) || !slices.Equal(same.BwdWeight, chg.BwdWeight) {
+		t.Error("repair without changes gave another overlay")
+	}
+	if _, err := Repair(chg, []EdgeChange{{Edge: g.NumEdges, Weight: 1}}, Options{}); err == nil {
+		t.Error("accepted a change to an edge out of range")
+	}
+	chg.Rank = nil
+	if _, err := Repair(chg, nil, Options{}); err == nil {
+		t.Error("accepted a hierarchy without ranks")
+	}
+}
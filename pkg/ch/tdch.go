@@ -0,0 +1,262 @@
+package ch
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// A time-dependent contraction hierarchy (TCH) contracts a graph whose
+// edges take travel time functions (TTFs) of the departure time rather
+// than fixed weights. Contracting v links the TTFs of each edge u→v and
+// v→w into a shortcut u→w, unless a witness path u→w avoiding v is never
+// slower, whatever the departure. Two nodes are joined by one edge at
+// most: a shortcut merges with the edge it meets, taking the faster of the
+// two at each time and remembering which way that is (graph.TDVia).
+//
+// Telling witnesses exactly needs a profile search over TTFs. The search
+// here is the static one over each edge's slowest travel time: a path
+// found is a witness if even its slowest is no slower than the shortcut's
+// fastest, or if its linked TTF is never slower than the shortcut's. That
+// keeps some shortcuts a profile search would drop but never drops one
+// that is needed.
+
+// ContractTimeDependent builds the time-dependent hierarchy of g whose
+// edges take ttf, indexed like g's edges, contracting in the order rank
+// gives, as NewCCH does. A static hierarchy's ranks for g's free-flow
+// weights, as in CHGraph.Rank, make a good order: the roads a fast route
+// takes change little with the time of day. routing.NewTDRouter answers
+// departure-time queries on it.
+func ContractTimeDependent(g *graph.Graph, ttf []graph.TTF, rank []uint32) (*graph.TDCHGraph, error) {
+	n := g.NumNodes
+	order, err := rankOrder(rank, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(ttf) != int(g.NumEdges) {
+		return nil, fmt.Errorf("%d travel time functions for %d edges", len(ttf), g.NumEdges)
+	}
+	for e, f := range ttf {
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("edge %d: %w", e, err)
+		}
+	}
+
+	c := &tdContractor{
+		edges:      make([]graph.TDEdge, 0, g.NumEdges),
+		out:        make([][]uint32, n),
+		in:         make([][]uint32, n),
+		contracted: make([]bool, n),
+		dist:       make([]uint32, n),
+		pred:       make([]uint32, n),
+	}
+	for i := range c.dist {
+		c.dist[i] = maxUint32
+	}
+	for u := range n {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			c.addEdge(graph.TDEdge{From: u, To: g.Head[e], TTF: ttf[e]})
+		}
+	}
+	for e := range g.NumEdges {
+		if edge := c.edges[e]; edge.From != edge.To {
+			c.join(edge.From, edge.To, edge.TTF, graph.TDVia{First: int32(e), Second: -1})
+		}
+	}
+	for _, v := range order {
+		c.contract(v)
+	}
+
+	tg := &graph.TDCHGraph{NumNodes: n, Rank: rank, Edges: c.edges}
+	tg.FwdFirstOut, tg.FwdEdge = upwardEdges(rank, c.out, func(e uint32) uint32 { return c.edges[e].To })
+	tg.BwdFirstOut, tg.BwdEdge = upwardEdges(rank, c.in, func(e uint32) uint32 { return c.edges[e].From })
+	breakpoints := 0
+	for _, e := range c.edges[g.NumEdges:] {
+		breakpoints += len(e.TTF)
+	}
+	shortcuts := len(c.edges) - int(g.NumEdges)
+	log.Printf("TCH: %d shortcuts for %d nodes and %d edges, %.1f breakpoints each",
+		shortcuts, n, g.NumEdges, float64(breakpoints)/float64(max(1, shortcuts)))
+	return tg, nil
+}
+
+// tdContractor holds the state of a time-dependent contraction.
+type tdContractor struct {
+	edges      []graph.TDEdge
+	bound      []uint32   // each edge's slowest travel time, rounded up
+	out, in    [][]uint32 // the edges leaving and reaching each node, as indices into edges
+	contracted []bool
+
+	// The witness search's distances over bound, and the edge each node
+	// was reached by.
+	dist, pred []uint32
+	touched    []uint32
+	heap       witnessHeap
+}
+
+// contract adds the shortcuts contracting v needs.
+func (c *tdContractor) contract(v uint32) {
+	c.contracted[v] = true
+	var ins, outs []uint32
+	for _, e := range c.in[v] {
+		if !c.contracted[c.edges[e].From] {
+			ins = append(ins, e)
+		}
+	}
+	for _, e := range c.out[v] {
+		if !c.contracted[c.edges[e].To] {
+			outs = append(outs, e)
+		}
+	}
+
+	type candidate struct {
+		to  uint32
+		ttf graph.TTF
+		via graph.TDVia
+	}
+	candidates := make([]candidate, 0, len(outs))
+	for _, a := range ins {
+		u := c.edges[a].From
+		candidates = candidates[:0]
+		limit := uint32(0)
+		for _, b := range outs {
+			if w := c.edges[b].To; w != u {
+				f := graph.LinkTTF(c.edges[a].TTF, c.edges[b].TTF)
+				candidates = append(candidates, candidate{w, f, graph.TDVia{First: int32(a), Second: int32(b)}})
+				limit = max(limit, uint32(math.Ceil(f.Max())))
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		c.witnessSearch(u, v, limit)
+		for _, sc := range candidates {
+			if !c.witnessed(u, sc.to, sc.ttf) {
+				c.join(u, sc.to, sc.ttf, sc.via)
+			}
+		}
+	}
+}
+
+// join adds an edge u→w of TTF f going the way via, merging it with the
+// edge between them if there is one.
+func (c *tdContractor) join(u, w uint32, f graph.TTF, via graph.TDVia) {
+	i := slices.IndexFunc(c.out[u], func(e uint32) bool { return c.edges[e].To == w })
+	if i < 0 {
+		id := via.First
+		if via.Second >= 0 {
+			id = c.addEdge(graph.TDEdge{From: u, To: w, TTF: f, Via: []graph.TDVia{via}})
+		}
+		c.out[u] = append(c.out[u], uint32(id))
+		c.in[w] = append(c.in[w], uint32(id))
+		return
+	}
+
+	id := c.out[u][i]
+	e := &c.edges[id]
+	switch {
+	case e.TTF.AtMost(f):
+	case e.Via == nil:
+		// An original edge: a shortcut takes its place, as the faster of
+		// it and f.
+		merged := graph.TDEdge{From: u, To: w, TTF: f, Via: []graph.TDVia{via}}
+		if !f.AtMost(e.TTF) {
+			merged.TTF = graph.MinTTF(e.TTF, f)
+			merged.Via = append(merged.Via, graph.TDVia{First: int32(id), Second: -1})
+		}
+		next := uint32(c.addEdge(merged))
+		c.out[u][i] = next
+		c.in[w][slices.Index(c.in[w], id)] = next
+	case f.AtMost(e.TTF):
+		e.TTF, e.Via = f, append(e.Via[:0], via)
+		c.bound[id] = uint32(math.Ceil(f.Max()))
+	default:
+		e.TTF, e.Via = graph.MinTTF(e.TTF, f), append(e.Via, via)
+		c.bound[id] = uint32(math.Ceil(e.TTF.Max()))
+	}
+}
+
+// addEdge appends e to the edges, without listing it, and returns its
+// index.
+func (c *tdContractor) addEdge(e graph.TDEdge) int32 {
+	c.edges = append(c.edges, e)
+	c.bound = append(c.bound, uint32(math.Ceil(e.TTF.Max())))
+	return int32(len(c.edges) - 1)
+}
+
+// witnessSearch runs Dijkstra over the edges' slowest travel times from u
+// among the uncontracted nodes other than v, up to limit and within the
+// static contraction's default witness limits.
+func (c *tdContractor) witnessSearch(u, v, limit uint32) {
+	for _, x := range c.touched {
+		c.dist[x] = maxUint32
+	}
+	c.touched = append(c.touched[:0], u)
+	c.heap.Reset()
+	c.dist[u] = 0
+	c.heap.Push(u, 0, 0)
+	for settled := 0; c.heap.Len() > 0 && settled < defaultWitnessSettled; {
+		cur := c.heap.Pop()
+		if cur.dist > c.dist[cur.node] {
+			continue
+		}
+		settled++
+		if cur.hops >= defaultWitnessHops {
+			continue
+		}
+		for _, id := range c.out[cur.node] {
+			x := c.edges[id].To
+			if x == v || c.contracted[x] {
+				continue
+			}
+			d := addWeights(cur.dist, c.bound[id])
+			if d > limit || d >= c.dist[x] {
+				continue
+			}
+			if c.dist[x] == maxUint32 {
+				c.touched = append(c.touched, x)
+			}
+			c.dist[x], c.pred[x] = d, id
+			c.heap.Push(x, d, cur.hops+1)
+		}
+	}
+}
+
+// witnessed reports whether the path to w the last witness search found
+// is never slower than f.
+func (c *tdContractor) witnessed(u, w uint32, f graph.TTF) bool {
+	if c.dist[w] == maxUint32 || u == w {
+		return false
+	}
+	if float64(c.dist[w]) <= f.Min() {
+		return true
+	}
+	var path []uint32
+	for x := w; x != u; x = c.edges[c.pred[x]].From {
+		path = append(path, c.pred[x])
+	}
+	p := c.edges[path[len(path)-1]].TTF
+	for _, id := range slices.Backward(path[:len(path)-1]) {
+		p = graph.LinkTTF(p, c.edges[id].TTF)
+	}
+	return p.AtMost(f)
+}
+
+// upwardEdges lays out the listed edges climbing from each node, as other
+// gives their far ends, in CSR form.
+func upwardEdges(rank []uint32, lists [][]uint32, other func(e uint32) uint32) (firstOut, edges []uint32) {
+	firstOut = make([]uint32, len(rank)+1)
+	for v, list := range lists {
+		for _, e := range list {
+			if rank[v] < rank[other(e)] {
+				edges = append(edges, e)
+			}
+		}
+		firstOut[v+1] = uint32(len(edges))
+	}
+	return firstOut, edges
+}
@@ -1,9 +1,6 @@
 package ch
 
-const (
-	maxSettled = 500 // max nodes settled during witness search
-	maxHops    = 5   // max hops from source
-)
+import "log"
 
 // witnessHeapItem is an entry in the witness search min-heap.
 type witnessHeapItem struct {
@@ -83,13 +80,20 @@ type witnessState struct {
 	touched []uint32 // list of nodes touched (for fast reset)
 	heap    witnessHeap
 
+	maxSettled int // max nodes settled during witness search
+	maxHops    int // max hops from source
+
+	// Searches run, and how many of them maxSettled or maxHops cut short,
+	// since the last adaptWitnessLimits.
+	searches, settledCuts, hopCuts int
+
 	// Reusable buffers for findShortcuts (avoids per-call allocations).
 	incoming  []adjEntry
 	outgoing  []adjEntry
 	shortcuts []shortcut
 }
 
-func newWitnessState(numNodes uint32) *witnessState {
+func newWitnessState(numNodes uint32, maxSettled, maxHops int) *witnessState {
 	dist := make([]uint32, numNodes)
 	for i := range dist {
 		dist[i] = maxUint32
@@ -97,6 +101,9 @@ func newWitnessState(numNodes uint32) *witnessState {
 	return &witnessState{
 		dist: dist,
 		heap: witnessHeap{items: make([]witnessHeapItem, 0, 256)},
+
+		maxSettled: maxSettled,
+		maxHops:    maxHops,
 	}
 }
 
@@ -117,7 +124,7 @@ const maxUint32 = ^uint32(0)
 // This replaces the per-(in,out)-pair witness search with a single search
 // per incoming neighbor, reducing the number of searches from O(|in|*|out|)
 // to O(|in|).
-func batchWitnessSearch(ws *witnessState, outAdj [][]adjEntry, source, excluded uint32, maxWeight uint32, contracted []bool) {
+func batchWitnessSearch(ws *witnessState, outAdj *adjacency, source, excluded uint32, maxWeight uint32, contracted []bool) {
 	ws.reset()
 
 	ws.dist[source] = 0
@@ -125,6 +132,8 @@ func batchWitnessSearch(ws *witnessState, outAdj [][]adjEntry, source, excluded
 	ws.heap.Push(source, 0, 0)
 
 	settled := 0
+	hopCut := false
+	ws.searches++
 
 	for ws.heap.Len() > 0 {
 		cur := ws.heap.Pop()
@@ -135,7 +144,10 @@ func batchWitnessSearch(ws *witnessState, outAdj [][]adjEntry, source, excluded
 		}
 
 		settled++
-		if settled >= maxSettled {
+		if settled >= ws.maxSettled {
+			if cur.dist <= maxWeight {
+				ws.settledCuts++
+			}
 			break
 		}
 
@@ -143,12 +155,13 @@ func batchWitnessSearch(ws *witnessState, outAdj [][]adjEntry, source, excluded
 			continue
 		}
 
-		if cur.hops >= maxHops {
+		if cur.hops >= ws.maxHops {
+			hopCut = true
 			continue
 		}
 
 		// Relax outgoing neighbors.
-		for _, e := range outAdj[cur.node] {
+		for _, e := range outAdj.of(cur.node) {
 			if e.to == excluded || contracted[e.to] {
 				continue
 			}
@@ -167,4 +180,50 @@ func batchWitnessSearch(ws *witnessState, outAdj [][]adjEntry, source, excluded
 			}
 		}
 	}
+	if hopCut {
+		ws.hopCuts++
+	}
+}
+
+// Adaptive witness limits (Options.AdaptiveWitness).
+const (
+	adaptMinSearches = 2000 // searches between adaptations
+	adaptCutRate     = 0.1  // share of cut-short searches that loosens a limit
+	adaptMaxFactor   = 16   // a limit grows to at most this times its start
+)
+
+// adaptWitnessLimits loosens the limits of states, which share them, when
+// more than adaptCutRate of the searches since the last adaptation were cut
+// short by one, doubling it. Searches near the top of the hierarchy span
+// more nodes, and a search cut short misses witnesses, adding shortcuts a
+// full one would find unnecessary. The states' counts are summed, so the
+// limits do not depend on how searches were spread over them.
+func (c *contractor) adaptWitnessLimits(states ...*witnessState) {
+	if !c.opt.AdaptiveWitness {
+		return
+	}
+	var searches, settledCuts, hopCuts int
+	for _, ws := range states {
+		searches += ws.searches
+		settledCuts += ws.settledCuts
+		hopCuts += ws.hopCuts
+	}
+	if searches < adaptMinSearches {
+		return
+	}
+	settled, hops := states[0].maxSettled, states[0].maxHops
+	if float64(settledCuts) > adaptCutRate*float64(searches) {
+		settled = min(2*settled, adaptMaxFactor*c.opt.WitnessSettled)
+	}
+	if float64(hopCuts) > adaptCutRate*float64(searches) {
+		hops = min(2*hops, adaptMaxFactor*c.opt.WitnessHops)
+	}
+	if settled != states[0].maxSettled || hops != states[0].maxHops {
+		log.Printf("Witness limits raised to %d settled nodes, %d hops: of %d searches, %d hit the node limit, %d the hop limit",
+			settled, hops, searches, settledCuts, hopCuts)
+	}
+	for _, ws := range states {
+		ws.maxSettled, ws.maxHops = settled, hops
+		ws.searches, ws.settledCuts, ws.hopCuts = 0, 0, 0
+	}
 }
@@ -0,0 +1,26 @@
+package geo
+
+import "math"
+
+// Bearing returns the initial great-circle bearing from a to b, in degrees
+// clockwise from north in [0, 360).
+func Bearing(a, b LatLng) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+	y := math.Sin(dLng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLng)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// Destination returns the point distanceMeters from p along the great circle
+// setting off at bearing, in degrees clockwise from north. Its longitude is
+// in [-180, 180).
+func Destination(p LatLng, bearing, distanceMeters float64) LatLng {
+	lat1, lng1 := p.Lat*math.Pi/180, p.Lng*math.Pi/180
+	theta := bearing * math.Pi / 180
+	delta := distanceMeters / earthRadiusMeters // angular distance
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lng2 := lng1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	lng := math.Mod(lng2*180/math.Pi+540, 360) - 180
+	return LatLng{Lat: lat2 * 180 / math.Pi, Lng: lng}
+}
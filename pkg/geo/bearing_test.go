@@ -0,0 +1,52 @@
+package geo
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestBearing(t *testing.T) {
+	for _, tc := range []struct {
+		a, b LatLng
+		want float64
+	}{
+		{LatLng{1, 103}, LatLng{1.001, 103}, 0},
+		{LatLng{1, 103}, LatLng{1, 103.001}, 90},
+		{LatLng{1, 103}, LatLng{0.999, 103}, 180},
+		{LatLng{1, 103}, LatLng{1, 102.999}, 270},
+		{LatLng{1, 103}, LatLng{1.001, 103.001}, 45},
+		// Across the antimeridian, eastwards.
+		{LatLng{0, 179.999}, LatLng{0, -179.999}, 90},
+	} {
+		got := Bearing(tc.a, tc.b)
+		if d := math.Abs(got - tc.want); min(d, 360-d) > 0.1 || got < 0 || got >= 360 {
+			t.Errorf("Bearing(%v, %v) = %.2f, want %.0f", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestDestination(t *testing.T) {
+	// 1° of latitude is about 111.2 km.
+	if got := Destination(LatLng{1, 103}, 0, 111_195); math.Abs(got.Lat-2) > 1e-4 || math.Abs(got.Lng-103) > 1e-9 {
+		t.Errorf("Destination 111 km north = %v, want {2 103}", got)
+	}
+	// Eastwards over the antimeridian wraps the longitude.
+	if got := Destination(LatLng{0, 179.9}, 90, 22_239); math.Abs(got.Lng+179.9) > 1e-4 {
+		t.Errorf("Destination over the antimeridian = %v, want longitude -179.9", got)
+	}
+
+	// Destination goes the distance along the bearing.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		p := LatLng{Lat: rng.Float64()*160 - 80, Lng: rng.Float64()*360 - 180}
+		bearing, dist := rng.Float64()*360, rng.Float64()*50_000
+		q := Destination(p, bearing, dist)
+		if d := Haversine(p.Lat, p.Lng, q.Lat, q.Lng); math.Abs(d-dist) > 1e-3 {
+			t.Fatalf("Destination(%v, %.1f, %.1f) = %v, %.3f m away", p, bearing, dist, q, d)
+		}
+		if b := Bearing(p, q); dist > 1 && min(math.Abs(b-bearing), 360-math.Abs(b-bearing)) > 1e-6 {
+			t.Fatalf("Destination(%v, %.1f, %.1f) = %v, at bearing %.6f", p, bearing, dist, q, b)
+		}
+	}
+}
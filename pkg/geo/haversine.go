@@ -75,3 +75,13 @@ func PointToSegmentDist(pLat, pLon, aLat, aLon, bLat, bLon float64) (dist float6
 	ey := py - (ay + t*dy)
 	return math.Sqrt(ex*ex+ey*ey) * degToMeters, t
 }
+
+// PointToBoxDist returns a lower bound in meters on PointToSegmentDist from
+// point P to any segment within the box, for pruning spatial index searches.
+// It measures in the same projection, at the box latitude farthest from the
+// equator, where the projection shrinks longitudes the most.
+func PointToBoxDist(pLat, pLon, minLat, minLon, maxLat, maxLon float64) float64 {
+	dy := max(minLat-pLat, pLat-maxLat, 0)
+	dx := max(minLon-pLon, pLon-maxLon, 0) * math.Cos(max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180)
+	return math.Sqrt(dx*dx+dy*dy) * degToMeters
+}
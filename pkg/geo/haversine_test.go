@@ -2,6 +2,7 @@ package geo
 
 import (
 	"math"
+	"math/rand/v2"
 	"testing"
 )
 
@@ -130,6 +131,29 @@ func TestPointToSegmentDist(t *testing.T) {
 	}
 }
 
+func TestPointToBoxDist(t *testing.T) {
+	// A box at a high latitude, where the projection's scale varies most
+	// across it, and segments between random points inside it.
+	const minLat, minLon, maxLat, maxLon = 69.5, 18.9, 70.0, 19.6
+	rng := rand.New(rand.NewPCG(1, 2))
+	in := func(lo, hi float64) float64 { return lo + rng.Float64()*(hi-lo) }
+	for range 2000 {
+		aLat, aLon := in(minLat, maxLat), in(minLon, maxLon)
+		bLat, bLon := in(minLat, maxLat), in(minLon, maxLon)
+		pLat, pLon := in(minLat-1, maxLat+1), in(minLon-2, maxLon+2)
+		lower := PointToBoxDist(pLat, pLon, minLat, minLon, maxLat, maxLon)
+		if d, _ := PointToSegmentDist(pLat, pLon, aLat, aLon, bLat, bLon); lower > d {
+			t.Fatalf("point %v,%v: bound %f m exceeds the distance %f m to segment %v,%v-%v,%v", pLat, pLon, lower, d, aLat, aLon, bLat, bLon)
+		}
+	}
+	if d := PointToBoxDist(69.7, 19, minLat, minLon, maxLat, maxLon); d != 0 {
+		t.Errorf("point inside the box: %f m", d)
+	}
+	if d := PointToBoxDist(70.01, 19, minLat, minLon, maxLat, maxLon); math.Abs(d-1112) > 2 {
+		t.Errorf("point 0.01° north of the box: %f m, want ~1112", d)
+	}
+}
+
 func BenchmarkHaversine(b *testing.B) {
 	for b.Loop() {
 		Haversine(1.3521, 103.8198, 1.2905, 103.8520)
@@ -0,0 +1,7 @@
+package geo
+
+// LatLng is a position in degrees.
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrBadPolyline is returned for a string that is not an encoded polyline.
+var ErrBadPolyline = errors.New("invalid encoded polyline")
+
+// EncodePolyline encodes points in the encoded polyline format of Google's
+// Maps APIs, with coordinates rounded to precision decimal places: 5 for
+// Google and OSRM's "polyline" geometries, 6 for OSRM's "polyline6" and
+// Valhalla. Each coordinate is stored as its difference from the previous
+// point, so a route's geometry takes a few bytes per point.
+func EncodePolyline(points []LatLng, precision int) string {
+	scale := math.Pow10(precision)
+	var b strings.Builder
+	b.Grow(len(points) * 8)
+	var lat, lng int64
+	for _, p := range points {
+		nextLat, nextLng := int64(math.Round(p.Lat*scale)), int64(math.Round(p.Lng*scale))
+		encodePolylineValue(&b, nextLat-lat)
+		encodePolylineValue(&b, nextLng-lng)
+		lat, lng = nextLat, nextLng
+	}
+	return b.String()
+}
+
+// encodePolylineValue appends v zigzag-encoded, five bits to a character,
+// lowest first, with 0x20 set on all but the last.
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|u&0x1f) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
+
+// DecodePolyline decodes an encoded polyline (see EncodePolyline) written at
+// precision decimal places. It returns ErrBadPolyline for a string with a
+// character outside the format or a coordinate cut short.
+func DecodePolyline(s string, precision int) ([]LatLng, error) {
+	scale := math.Pow10(precision)
+	var points []LatLng
+	var lat, lng int64
+	for i := 0; i < len(s); {
+		var dLat, dLng int64
+		var err error
+		if dLat, i, err = decodePolylineValue(s, i); err != nil {
+			return nil, err
+		}
+		if dLng, i, err = decodePolylineValue(s, i); err != nil {
+			return nil, err
+		}
+		lat, lng = lat+dLat, lng+dLng
+		points = append(points, LatLng{Lat: float64(lat) / scale, Lng: float64(lng) / scale})
+	}
+	return points, nil
+}
+
+// decodePolylineValue decodes the value starting at s[i] and returns it with
+// the index after it.
+func decodePolylineValue(s string, i int) (int64, int, error) {
+	var u uint64
+	for shift := uint(0); ; shift += 5 {
+		if i >= len(s) {
+			return 0, i, fmt.Errorf("%w: ends inside a coordinate", ErrBadPolyline)
+		}
+		c := s[i]
+		// A 64-bit value takes at most 13 characters.
+		if c < 63 || c > 63+0x3f || shift > 60 {
+			return 0, i, fmt.Errorf("%w: bad character %q at %d", ErrBadPolyline, c, i)
+		}
+		i++
+		u |= (uint64(c-63) & 0x1f) << shift
+		if c-63 < 0x20 {
+			break
+		}
+	}
+	v := int64(u >> 1)
+	if u&1 != 0 {
+		v = ^v
+	}
+	return v, i, nil
+}
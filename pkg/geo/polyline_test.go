@@ -0,0 +1,62 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	// The example from Google's documentation of the format.
+	points := []LatLng{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}
+	const want = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+	if got := EncodePolyline(points, 5); got != want {
+		t.Errorf("EncodePolyline = %q, want %q", got, want)
+	}
+	got, err := DecodePolyline(want, 5)
+	if err != nil || !slices.Equal(got, points) {
+		t.Errorf("DecodePolyline = %v, %v; want %v", got, err, points)
+	}
+
+	if got := EncodePolyline(nil, 5); got != "" {
+		t.Errorf("EncodePolyline(nil) = %q, want empty", got)
+	}
+	if got, err := DecodePolyline("", 5); err != nil || len(got) != 0 {
+		t.Errorf(`DecodePolyline("") = %v, %v; want no points`, got, err)
+	}
+}
+
+func TestPolylineRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	points := make([]LatLng, 200)
+	for i := range points {
+		points[i] = LatLng{Lat: rng.Float64()*180 - 90, Lng: rng.Float64()*360 - 180}
+	}
+	for _, precision := range []int{5, 6} {
+		got, err := DecodePolyline(EncodePolyline(points, precision), precision)
+		if err != nil || len(got) != len(points) {
+			t.Fatalf("precision %d: decoded %d points, %v; want %d", precision, len(got), err, len(points))
+		}
+		tol := math.Pow10(-precision) / 2 * (1 + 1e-9)
+		for i, p := range got {
+			if math.Abs(p.Lat-points[i].Lat) > tol || math.Abs(p.Lng-points[i].Lng) > tol {
+				t.Fatalf("precision %d: point %d decoded as %v, want %v", precision, i, p, points[i])
+			}
+		}
+	}
+}
+
+func TestDecodePolylineInvalid(t *testing.T) {
+	for _, s := range []string{
+		"_p~iF",              // a latitude without its longitude
+		"_p~iF~ps|",          // a longitude cut short
+		"_p~iF~ps|U\n",       // a character outside the format
+		"~~~~~~~~~~~~~~~~~?", // a value too long for 64 bits
+	} {
+		if _, err := DecodePolyline(s, 5); !errors.Is(err, ErrBadPolyline) {
+			t.Errorf("DecodePolyline(%q) error = %v, want ErrBadPolyline", s, err)
+		}
+	}
+}
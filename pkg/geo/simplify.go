@@ -0,0 +1,58 @@
+package geo
+
+// Simplify returns the points of a polyline with those that stray less than
+// toleranceMeters from the line removed, for drawing route geometry or an
+// isochrone's outline with fewer points. The first and last points are
+// always kept, so a closed ring stays closed.
+//
+// A radial pass first drops each point within toleranceMeters of the last
+// one kept, which thins dense runs of shape points in linear time.
+// Douglas-Peucker then keeps, between two kept points, the one farthest from
+// the segment joining them while it lies more than toleranceMeters off it,
+// and splits there. The simplified line passes within toleranceMeters of
+// every point Douglas-Peucker drops, and within twice that of those the
+// radial pass drops. The result is a new slice.
+func Simplify(points []LatLng, toleranceMeters float64) []LatLng {
+	if len(points) <= 2 || toleranceMeters <= 0 {
+		return append([]LatLng(nil), points...)
+	}
+
+	// Radial distance pre-pass.
+	radial := []LatLng{points[0]}
+	for _, p := range points[1 : len(points)-1] {
+		last := radial[len(radial)-1]
+		if EquirectangularDist(last.Lat, last.Lng, p.Lat, p.Lng) > toleranceMeters {
+			radial = append(radial, p)
+		}
+	}
+	radial = append(radial, points[len(points)-1])
+
+	// Douglas-Peucker, with an explicit stack of spans to split.
+	keep := make([]bool, len(radial))
+	keep[0], keep[len(radial)-1] = true, true
+	stack := [][2]int{{0, len(radial) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		a, b := radial[span[0]], radial[span[1]]
+		far, farDist := -1, toleranceMeters
+		for i := span[0] + 1; i < span[1]; i++ {
+			p := radial[i]
+			if d, _ := PointToSegmentDist(p.Lat, p.Lng, a.Lat, a.Lng, b.Lat, b.Lng); d > farDist {
+				far, farDist = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			stack = append(stack, [2]int{span[0], far}, [2]int{far, span[1]})
+		}
+	}
+
+	out := radial[:0]
+	for i, p := range radial {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
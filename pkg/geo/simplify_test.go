@@ -0,0 +1,73 @@
+package geo
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	// An L around a corner at (1.3, 103.81), 0.001° (111 m) apart, with a
+	// 5 m wobble at (1.3, 103.805) and a point 1 m past the start.
+	points := []LatLng{
+		{1.3, 103.80},
+		{1.3, 103.80001},
+		{1.3, 103.801},
+		{1.3, 103.803},
+		{1.30005, 103.805},
+		{1.3, 103.807},
+		{1.3, 103.81},
+		{1.302, 103.81},
+		{1.305, 103.81},
+	}
+	for _, tc := range []struct {
+		tolerance float64
+		want      []LatLng
+	}{
+		// Only the corner strays 20 m.
+		{20, []LatLng{{1.3, 103.80}, {1.3, 103.81}, {1.305, 103.81}}},
+		// At 2 m the wobble stays, the point 1 m past the start goes, and
+		// so do the points on the straight runs.
+		{2, []LatLng{{1.3, 103.80}, {1.3, 103.803}, {1.30005, 103.805}, {1.3, 103.807}, {1.3, 103.81}, {1.305, 103.81}}},
+		// No tolerance keeps every point.
+		{0, points},
+	} {
+		got := Simplify(points, tc.tolerance)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("Simplify(%v m) = %v, want %v", tc.tolerance, got, tc.want)
+		}
+	}
+
+	// The input is left as it was.
+	if points[1] != (LatLng{1.3, 103.80001}) {
+		t.Errorf("Simplify changed its input: %v", points)
+	}
+}
+
+func TestSimplifyWithinTolerance(t *testing.T) {
+	// A closed ring: a circle of 500 m radius in 360 steps.
+	var ring []LatLng
+	for deg := range 361 {
+		a := float64(deg) * math.Pi / 180
+		ring = append(ring, LatLng{1.3 + 0.0045*math.Sin(a), 103.8 + 0.0045*math.Cos(a)})
+	}
+	const tolerance = 10.0
+	got := Simplify(ring, tolerance)
+	if len(got) < 4 || len(got) > len(ring)/4 {
+		t.Fatalf("Simplify kept %d of %d points", len(got), len(ring))
+	}
+	if got[0] != ring[0] || got[len(got)-1] != ring[len(ring)-1] {
+		t.Errorf("Simplify moved the ends of the ring: %v .. %v", got[0], got[len(got)-1])
+	}
+	// Every dropped point lies within the tolerance of the simplified line.
+	for _, p := range ring {
+		best := math.Inf(1)
+		for i := 0; i+1 < len(got); i++ {
+			d, _ := PointToSegmentDist(p.Lat, p.Lng, got[i].Lat, got[i].Lng, got[i+1].Lat, got[i+1].Lng)
+			best = min(best, d)
+		}
+		if best > 2*tolerance {
+			t.Errorf("point %v is %.1f m off the simplified ring", p, best)
+		}
+	}
+}
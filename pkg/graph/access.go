@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"math"
+
+	"github.com/azybler/map_router/pkg/geo"
+)
+
+// Accessors for library users, so walking a graph does not take CSR index
+// math against the exported slices.
+
+// LatLng is a position in degrees.
+type LatLng = geo.LatLng
+
+// Edge is one edge of a Graph (or of a CHGraph's original graph).
+type Edge struct {
+	Index  uint32 // index into the graph's edge arrays (Head, Weight, EdgeAttr, ...)
+	From   uint32
+	To     uint32
+	Weight uint32 // 0 for views without weights (BaseGraph.Graph(nil))
+}
+
+// CHEdge is one edge of a CHGraph's forward or backward upward graph. It is
+// stored at From and leads to the higher-ranked To; a backward edge stands
+// for travel from To to From.
+type CHEdge struct {
+	Index  uint32 // index into FwdHead/FwdWeight/FwdMiddle, or the Bwd arrays
+	From   uint32
+	To     uint32
+	Weight uint32
+	Middle int32 // node the shortcut bypasses, -1 for an original edge
+}
+
+// Coordinate returns the position of node u.
+func (g *Graph) Coordinate(u uint32) LatLng {
+	return LatLng{Lat: g.NodeLat[u].Deg(), Lng: g.NodeLon[u].Deg()}
+}
+
+// Edge returns edge e. Its source is found by binary search over FirstOut;
+// EachOutEdge gets it for free.
+func (g *Graph) Edge(e uint32) Edge {
+	return g.edge(edgeTail(g.FirstOut, e), e)
+}
+
+// EachOutEdge calls fn with each edge leaving u, in index order, until fn
+// returns false.
+func (g *Graph) EachOutEdge(u uint32, fn func(Edge) bool) {
+	start, end := g.EdgesFrom(u)
+	for e := start; e < end; e++ {
+		if !fn(g.edge(u, e)) {
+			return
+		}
+	}
+}
+
+// EdgeLength returns the length of edge e in metres, along its geometry.
+func (g *Graph) EdgeLength(e uint32) float64 {
+	from := g.Coordinate(edgeTail(g.FirstOut, e))
+	d := 0.0
+	step := func(to LatLng) {
+		d += geo.Haversine(from.Lat, from.Lng, to.Lat, to.Lng)
+		from = to
+	}
+	if g.GeoFirstOut != nil {
+		for i := g.GeoFirstOut[e]; i < g.GeoFirstOut[e+1]; i++ {
+			step(LatLng{Lat: g.GeoShapeLat[i].Deg(), Lng: g.GeoShapeLon[i].Deg()})
+		}
+	}
+	step(g.Coordinate(g.Head[e]))
+	return d
+}
+
+// DistanceWeights returns the weights of g's edges by length, in
+// centimetres and at least 1: those the parser gives with
+// ParseOptions.Distance, less any PrivatePenalty, multiplied by the edges'
+// EdgePenalty as FilterBridgingRestricted multiplied their travel times.
+func (g *Graph) DistanceWeights() []uint32 {
+	w := make([]uint32, g.NumEdges)
+	for e := range g.NumEdges {
+		w[e] = max(1, uint32(math.Round(g.EdgeLength(e)*100)))
+		if g.EdgePenalty != nil && g.EdgePenalty[e] > 1 {
+			w[e] = penalize(w[e], float64(g.EdgePenalty[e]))
+		}
+	}
+	return w
+}
+
+func (g *Graph) edge(u, e uint32) Edge {
+	ed := Edge{Index: e, From: u, To: g.Head[e]}
+	if g.Weight != nil {
+		ed.Weight = g.Weight[e]
+	}
+	return ed
+}
+
+// Coordinate returns the position of node u.
+func (chg *CHGraph) Coordinate(u uint32) LatLng {
+	return LatLng{Lat: chg.NodeLat[u].Deg(), Lng: chg.NodeLon[u].Deg()}
+}
+
+// OrigEdge returns original edge e; see Graph.Edge.
+func (chg *CHGraph) OrigEdge(e uint32) Edge {
+	return Edge{Index: e, From: edgeTail(chg.OrigFirstOut, e), To: chg.OrigHead[e], Weight: chg.OrigWeight[e]}
+}
+
+// EachOrigEdge calls fn with each original edge leaving u, in index order,
+// until fn returns false.
+func (chg *CHGraph) EachOrigEdge(u uint32, fn func(Edge) bool) {
+	for e := chg.OrigFirstOut[u]; e < chg.OrigFirstOut[u+1]; e++ {
+		if !fn(Edge{Index: e, From: u, To: chg.OrigHead[e], Weight: chg.OrigWeight[e]}) {
+			return
+		}
+	}
+}
+
+// EachFwdEdge calls fn with each forward upward edge stored at u, until fn
+// returns false.
+func (chg *CHGraph) EachFwdEdge(u uint32, fn func(CHEdge) bool) {
+	eachCHEdge(u, chg.FwdFirstOut, chg.FwdHead, chg.FwdWeight, chg.FwdMiddle, fn)
+}
+
+// EachBwdEdge calls fn with each backward upward edge stored at u, until fn
+// returns false.
+func (chg *CHGraph) EachBwdEdge(u uint32, fn func(CHEdge) bool) {
+	eachCHEdge(u, chg.BwdFirstOut, chg.BwdHead, chg.BwdWeight, chg.BwdMiddle, fn)
+}
+
+func eachCHEdge(u uint32, firstOut, head, weight []uint32, middle []int32, fn func(CHEdge) bool) {
+	for e := firstOut[u]; e < firstOut[u+1]; e++ {
+		if !fn(CHEdge{Index: e, From: u, To: head[e], Weight: weight[e], Middle: middle[e]}) {
+			return
+		}
+	}
+}
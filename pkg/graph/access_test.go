@@ -0,0 +1,92 @@
+package graph_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	"github.com/azybler/map_router/pkg/geo"
+	"github.com/azybler/map_router/pkg/graph"
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+func TestAccessors(t *testing.T) {
+	chg := buildTestCH(t)
+	g := chg.OrigGraph()
+
+	if got, want := g.Coordinate(2), (graph.LatLng{Lat: 1.2, Lng: 103.2}); got != want {
+		t.Errorf("Coordinate(2) = %+v, want %+v", got, want)
+	}
+	if got, want := chg.Coordinate(3), (graph.LatLng{Lat: 1.3, Lng: 103.3}); got != want {
+		t.Errorf("CHGraph.Coordinate(3) = %+v, want %+v", got, want)
+	}
+
+	// Edges are sorted by source, then target: 0->1, 0->3, 1->0, 1->2, 2->1, 3->0.
+	if got, want := g.Edge(4), (graph.Edge{Index: 4, From: 2, To: 1, Weight: 200}); got != want {
+		t.Errorf("Edge(4) = %+v, want %+v", got, want)
+	}
+	if got, want := chg.OrigEdge(5), (graph.Edge{Index: 5, From: 3, To: 0, Weight: 300}); got != want {
+		t.Errorf("OrigEdge(5) = %+v, want %+v", got, want)
+	}
+
+	var out []graph.Edge
+	g.EachOutEdge(0, func(e graph.Edge) bool { out = append(out, e); return true })
+	want := []graph.Edge{{Index: 0, From: 0, To: 1, Weight: 100}, {Index: 1, From: 0, To: 3, Weight: 300}}
+	if !slices.Equal(out, want) {
+		t.Errorf("EachOutEdge(0) = %+v, want %+v", out, want)
+	}
+	out = nil
+	chg.EachOrigEdge(0, func(e graph.Edge) bool { out = append(out, e); return false })
+	if !slices.Equal(out, want[:1]) {
+		t.Errorf("EachOrigEdge(0) stopping after one = %+v, want %+v", out, want[:1])
+	}
+
+	// Every upward edge is visited once, at its lower-ranked end.
+	var fwd, bwd int
+	for u := range chg.NumNodes {
+		chg.EachFwdEdge(u, func(e graph.CHEdge) bool {
+			fwd++
+			if e.From != u || chg.FwdHead[e.Index] != e.To || chg.FwdMiddle[e.Index] != e.Middle || chg.Rank[e.To] <= chg.Rank[u] {
+				t.Errorf("forward edge %+v at node %d", e, u)
+			}
+			return true
+		})
+		chg.EachBwdEdge(u, func(e graph.CHEdge) bool {
+			bwd++
+			if e.From != u || chg.BwdWeight[e.Index] != e.Weight || chg.Rank[e.To] <= chg.Rank[u] {
+				t.Errorf("backward edge %+v at node %d", e, u)
+			}
+			return true
+		})
+	}
+	if fwd != len(chg.FwdHead) || bwd != len(chg.BwdHead) {
+		t.Errorf("visited %d forward and %d backward edges, want %d and %d", fwd, bwd, len(chg.FwdHead), len(chg.BwdHead))
+	}
+}
+
+func TestEdgeLength(t *testing.T) {
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 1, ToNodeID: 2, Weight: 1000, ShapeLats: []float64{1.01}, ShapeLons: []float64{103}},
+			{FromNodeID: 2, ToNodeID: 1, Weight: 1000},
+		},
+		NodeLat: map[osm.NodeID]float64{1: 1, 2: 1.01},
+		NodeLon: map[osm.NodeID]float64{1: 103, 2: 103.01},
+	})
+	around := geo.Haversine(1, 103, 1.01, 103) + geo.Haversine(1.01, 103, 1.01, 103.01)
+	straight := geo.Haversine(1.01, 103.01, 1, 103)
+	for e := range g.NumEdges {
+		want := straight
+		if g.Coordinate(g.Edge(e).From).Lat == 1 {
+			want = around
+		}
+		if got := g.EdgeLength(e); math.Abs(got-want) > 1e-6 {
+			t.Errorf("edge %d: length %.3f m, want %.3f", e, got, want)
+		}
+		if got := g.DistanceWeights()[e]; got != uint32(math.Round(want*100)) {
+			t.Errorf("edge %d: distance weight %d cm, want %.0f", e, got, want*100)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// Attributes section (header flag flagAttrs), after the geometry:
+//
+//	[uint32 numNames][uint32 blobLen][blob: Name 0x00 Ref 0x00, per name]
+//	EdgeName [NumOrigEdges]uint32
+//	EdgeAttr [NumOrigEdges]{Class uint8, Flags uint8, SpeedKmh uint16}
+//
+// It is written whenever the graph carries EdgeAttr, i.e. for every graph
+// built by preprocess.
+
+// maxNameBlob bounds the name table on load (guards against corrupt lengths).
+const maxNameBlob = 1 << 30
+
+// EdgeAttr is written as raw memory; its layout must stay 4 packed bytes.
+var _ [4]byte = [unsafe.Sizeof(EdgeAttr{})]byte{}
+
+func writeAttrSection(w io.Writer, chg *CHGraph) error {
+	if err := writeNames(w, chg.Names); err != nil {
+		return err
+	}
+
+	var err error
+	if chg.EdgeName != nil {
+		err = writeUint32Slice(w, chg.EdgeName)
+	} else {
+		err = writeZeros(w, int64(len(chg.OrigHead))*4)
+	}
+	if err != nil {
+		return fmt.Errorf("write EdgeName: %w", err)
+	}
+	if err := writeEdgeAttrs(w, chg.EdgeAttr); err != nil {
+		return fmt.Errorf("write EdgeAttr: %w", err)
+	}
+	return nil
+}
+
+func readAttrSection(r io.Reader, chg *CHGraph, numEdges int) error {
+	var err error
+	if chg.Names, err = readNames(r); err != nil {
+		return err
+	}
+	if chg.EdgeName, err = readUint32Slice(r, numEdges); err != nil {
+		return fmt.Errorf("read EdgeName: %w", err)
+	}
+	if err := checkEdgeNames(chg.EdgeName, len(chg.Names)); err != nil {
+		return err
+	}
+	if chg.EdgeAttr, err = readEdgeAttrs(r, numEdges); err != nil {
+		return fmt.Errorf("read EdgeAttr: %w", err)
+	}
+	return nil
+}
+
+// writeNames writes the interned name table: [uint32 numNames][uint32
+// blobLen][blob]. An empty table is written as the single unnamed entry.
+func writeNames(w io.Writer, names []RoadName) error {
+	if len(names) == 0 {
+		names = []RoadName{{}}
+	}
+	var blob bytes.Buffer
+	for _, n := range names {
+		blob.WriteString(n.Name)
+		blob.WriteByte(0)
+		blob.WriteString(n.Ref)
+		blob.WriteByte(0)
+	}
+	if err := binary.Write(w, binary.LittleEndian, [2]uint32{uint32(len(names)), uint32(blob.Len())}); err != nil {
+		return fmt.Errorf("write Names: %w", err)
+	}
+	if _, err := w.Write(blob.Bytes()); err != nil {
+		return fmt.Errorf("write Names: %w", err)
+	}
+	return nil
+}
+
+func readNames(r io.Reader) ([]RoadName, error) {
+	var counts [2]uint32
+	if err := binary.Read(r, binary.LittleEndian, &counts); err != nil {
+		return nil, fmt.Errorf("read Names: %w", err)
+	}
+	numNames, blobLen := counts[0], counts[1]
+	if numNames == 0 || blobLen > maxNameBlob || uint64(numNames)*2 > uint64(blobLen) {
+		return nil, fmt.Errorf("name table of %d names in %d bytes out of range", numNames, blobLen)
+	}
+	blob := make([]byte, blobLen)
+	if _, err := io.ReadFull(r, blob); err != nil {
+		return nil, fmt.Errorf("read Names: %w", err)
+	}
+	parts := bytes.Split(blob, []byte{0})
+	if len(parts) != int(numNames)*2+1 || len(parts[len(parts)-1]) != 0 {
+		return nil, fmt.Errorf("name table holds %d strings, want %d", len(parts)-1, numNames*2)
+	}
+	names := make([]RoadName, numNames)
+	for i := range names {
+		names[i] = RoadName{Name: string(parts[2*i]), Ref: string(parts[2*i+1])}
+	}
+	return names, nil
+}
+
+// checkEdgeNames validates name indexes against a table of numNames.
+func checkEdgeNames(edgeName []uint32, numNames int) error {
+	for i, n := range edgeName {
+		if int(n) >= numNames {
+			return fmt.Errorf("EdgeName[%d]=%d >= %d names", i, n, numNames)
+		}
+	}
+	return nil
+}
+
+func writeEdgeAttrs(w io.Writer, attrs []EdgeAttr) error {
+	return writeRaw(w, attrs, 4, swapEdgeAttrs)
+}
+
+func readEdgeAttrs(r io.Reader, n int) ([]EdgeAttr, error) {
+	return readRaw[EdgeAttr](r, n, 4, swapEdgeAttrs)
+}
+
+// swapEdgeAttrs byte-swaps the SpeedKmh of each EdgeAttr in b, its only
+// multi-byte field.
+func swapEdgeAttrs(b []byte) {
+	for i := 0; i+4 <= len(b); i += 4 {
+		b[i+2], b[i+3] = b[i+3], b[i+2]
+	}
+}
@@ -1,36 +1,71 @@
 package graph
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"io/fs"
 	"math"
 	"os"
-	"runtime"
-	"unsafe"
+	"slices"
 )
 
 const (
 	magicBytes = "MPROUTER"
 	version    = uint32(3) // v3 format: edge weights are travel time (ms), or distance (cm) for shortest-distance graphs
+	// versionFlags is v3 plus a flags word after the header, written only
+	// when a flag is set so that plain files stay readable by v3 readers.
+	versionFlags = uint32(4)
 	// Load-time sanity bounds on header counts (guard against corrupt/oversized
 	// files). Sized for continent-scale graphs: all-of-Australia at full
-	// shape-node resolution is well within these. uint32 indices structurally
-	// support up to ~4.29e9, so these remain conservative.
+	// shape-node resolution is well within these. Larger graphs are written
+	// with flagLarge, which lifts them to maxLargeNodes/maxLargeEdges.
 	maxNodes = 64_000_000
 	maxEdges = 256_000_000
+	// The structural limits of the in-memory layout: node IDs are int32 in
+	// the Middle arrays (-1 marks an original edge), and edge IDs and CSR
+	// offsets are uint32 with ^uint32(0) reserved as a sentinel.
+	maxLargeNodes = math.MaxInt32
+	maxLargeEdges = math.MaxUint32 - 1
+	// maxOptionalBytes bounds the length-prefixed geometry sections, which
+	// have no count in the header; with flagLarge only the platform does.
+	maxOptionalBytes = math.MaxUint32
 )
 
-func init() {
-	// The binary format uses unsafe.Slice to write native-endian data.
-	// Verify we're on a little-endian platform to prevent silent corruption.
-	if runtime.GOARCH == "s390x" || runtime.GOARCH == "ppc64" {
-		panic("map_router binary format requires a little-endian platform")
-	}
-}
+// Header flags (version 4).
+const (
+	flagZstd    = uint32(1) << 0 // sections after the header are one zstd frame; see compress.go
+	flagAttrs   = uint32(1) << 1 // an edge attributes section follows the geometry; see attrs.go
+	flagCoord32 = uint32(1) << 2 // coordinates are int32 1e-7 degrees instead of float64 degrees
+	// flagPointRefs: coordinates are uint32 references into the shared point
+	// table of a bundle (see bundle.go); set only on a bundle's profile sections.
+	flagPointRefs = uint32(1) << 3
+	// flagSectionCRCs: a per-section checksum table follows the flags word;
+	// see checksum.go.
+	flagSectionCRCs = uint32(1) << 4
+	// flagTurns: a turn restriction section follows the attributes section;
+	// see turns.go.
+	flagTurns = uint32(1) << 5
+	// flagOSMIDs: an OSM ID section follows the turn restriction section;
+	// see osmids.go.
+	flagOSMIDs = uint32(1) << 6
+	// flagSnapRTree: a snap index section follows the OSM ID section; see
+	// snapindex.go.
+	flagSnapRTree = uint32(1) << 7
+	// flagLarge: header counts may exceed maxNodes/maxEdges, up to
+	// maxLargeNodes/maxLargeEdges. Set only on graphs that need it, so
+	// ordinary files keep the tighter bounds against corrupt headers.
+	flagLarge = uint32(1) << 8
+	// flagLimits: a vehicle limits section follows the snap index section;
+	// see limits.go.
+	flagLimits = uint32(1) << 9
+	knownFlags = flagZstd | flagAttrs | flagCoord32 | flagPointRefs | flagSectionCRCs | flagTurns | flagOSMIDs | flagSnapRTree | flagLarge | flagLimits
+)
 
-// fileHeader is the binary header.
+// fileHeader is the binary header. Version 4 files follow it with a uint32
+// of header flags (and, with flagSectionCRCs, the checksum table).
 type fileHeader struct {
 	Magic        [8]byte
 	Version      uint32
@@ -41,9 +76,39 @@ type fileHeader struct {
 	NumBwdEdges  uint32
 }
 
+// WriteOptions controls optional encodings of WriteBinaryWith.
+type WriteOptions struct {
+	// Compress writes the sections zstd-compressed, typically 2-3x smaller.
+	// ReadBinary decompresses transparently.
+	Compress bool
+
+	// Float64Coords writes coordinates as float64 degrees, the layout of
+	// files from before fixed-point coordinates, twice the size. Without
+	// Compress or edge attributes the file is then readable by v3 readers.
+	Float64Coords bool
+
+	// OSMIDs writes the graph's NodeOSMID and EdgeWayID, so they survive a
+	// load. It adds 8 bytes per node and original edge; graphs that carry
+	// neither are written without them.
+	OSMIDs bool
+}
+
+// ReadOptions controls ReadBinaryWith.
+type ReadOptions struct {
+	// FastLoad skips the whole-file CRC32 pass for files that carry
+	// per-section checksums; call VerifySections on the result to check it
+	// later. Files without them are always checked in full.
+	FastLoad bool
+}
+
 // WriteBinary serializes a CHResult to a binary file.
-// Uses unsafe.Slice for fast zero-copy I/O.
+// Slices are written as raw memory on little-endian hosts; see endian.go.
 func WriteBinary(path string, chg *CHGraph) error {
+	return WriteBinaryWith(path, chg, WriteOptions{})
+}
+
+// WriteBinaryWith is WriteBinary with options.
+func WriteBinaryWith(path string, chg *CHGraph, opt WriteOptions) error {
 	tmpPath := path + ".tmp"
 	f, err := os.Create(tmpPath)
 	if err != nil {
@@ -54,6 +119,26 @@ func WriteBinary(path string, chg *CHGraph) error {
 		os.Remove(tmpPath) // clean up on error
 	}()
 
+	if err := writeBinary(f, chg, opt, nil); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	// Atomic rename.
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	return nil
+}
+
+// writeBinary writes chg as a combined graph at f's current offset, CRC32
+// trailer and metadata footer included. With pts the coordinates are written
+// as references into a bundle's shared point table (see bundle.go).
+func writeBinary(f *os.File, chg *CHGraph, opt WriteOptions, pts *pointIndex) error {
+	var err error
 	crcWriter := crc32Writer{w: f, hash: crc32.NewIEEE()}
 	w := &crcWriter
 
@@ -84,18 +169,97 @@ func WriteBinary(path string, chg *CHGraph) error {
 		NumBwdEdges:  numBwdEdges,
 	}
 	copy(hdr.Magic[:], magicBytes)
+	var flags uint32
+	if opt.Compress {
+		flags |= flagZstd
+	}
+	if chg.EdgeAttr != nil {
+		flags |= flagAttrs
+	}
+	if len(chg.TurnRestrictions) > 0 {
+		flags |= flagTurns
+	}
+	if opt.OSMIDs && (chg.NodeOSMID != nil || chg.EdgeWayID != nil) {
+		flags |= flagOSMIDs
+	}
+	if chg.SnapIndex != nil {
+		flags |= flagSnapRTree
+	}
+	if chg.EdgeLimits != nil {
+		flags |= flagLimits
+	}
+	large, err := needsLarge(chg)
+	if err != nil {
+		return err
+	}
+	if large {
+		flags |= flagLarge
+	}
+	writeCoords, writeShape := writeCoordSlice, writeLenPrefixedCoord
+	switch {
+	case pts != nil:
+		flags |= flagPointRefs
+	case opt.Float64Coords:
+		writeCoords, writeShape = writeCoordsAsFloat64, writeLenPrefixedCoordAsFloat64
+	default:
+		flags |= flagCoord32
+	}
+	// The checksum table is left out only when it would be the one thing
+	// keeping the legacy float64 layout from being readable by v3 readers.
+	var crcs []uint32
+	if !opt.Float64Coords || flags != 0 {
+		flags |= flagSectionCRCs
+		if crcs, err = sectionCRCs(chg, flags&flagOSMIDs != 0); err != nil {
+			return err
+		}
+	}
+	if flags != 0 {
+		hdr.Version = versionFlags
+	}
 	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
+	if flags != 0 {
+		if err := binary.Write(w, binary.LittleEndian, flags); err != nil {
+			return fmt.Errorf("write header flags: %w", err)
+		}
+	}
+	if flags&flagSectionCRCs != 0 {
+		if err := writeLenPrefixedUint32(w, crcs); err != nil {
+			return fmt.Errorf("write section checksums: %w", err)
+		}
+	}
+	var zs *zstdSection
+	if flags&flagZstd != 0 {
+		if zs, err = beginZstd(f); err != nil {
+			return fmt.Errorf("start compressed sections: %w", err)
+		}
+		crcWriter.w = zs
+	}
 
-	// Node data.
-	if err := writeFloat64Slice(w, chg.NodeLat); err != nil {
-		return fmt.Errorf("write NodeLat: %w", err)
+	// Node data. Every section is written straight from chg's slices (or
+	// generated in small chunks), never via a whole-graph temporary, so writing
+	// adds little to the memory of the graph itself.
+	if pts != nil {
+		if err := pts.writeRefs(w, chg.NodeLat, chg.NodeLon); err != nil {
+			return fmt.Errorf("write NodePoint: %w", err)
+		}
+	} else {
+		if err := writeCoords(w, chg.NodeLat); err != nil {
+			return fmt.Errorf("write NodeLat: %w", err)
+		}
+		if err := writeCoords(w, chg.NodeLon); err != nil {
+			return fmt.Errorf("write NodeLon: %w", err)
+		}
 	}
-	if err := writeFloat64Slice(w, chg.NodeLon); err != nil {
-		return fmt.Errorf("write NodeLon: %w", err)
+	// A graph read back from a binary has no Rank (ReadBinary skips it);
+	// write zeros so the section keeps its size.
+	if chg.Rank != nil {
+		err = writeUint32Slice(w, chg.Rank)
+	} else {
+		err = writeZeros(w, int64(chg.NumNodes)*4)
 	}
-	if err := writeUint32Slice(w, chg.Rank); err != nil {
+	if err != nil {
 		return fmt.Errorf("write Rank: %w", err)
 	}
 
@@ -142,11 +306,50 @@ func WriteBinary(path string, chg *CHGraph) error {
 	if err := writeLenPrefixedUint32(w, chg.GeoFirstOut); err != nil {
 		return fmt.Errorf("write GeoFirstOut: %w", err)
 	}
-	if err := writeLenPrefixedFloat64(w, chg.GeoShapeLat); err != nil {
-		return fmt.Errorf("write GeoShapeLat: %w", err)
+	if pts != nil {
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(chg.GeoShapeLat))); err != nil {
+			return fmt.Errorf("write GeoShapePoint: %w", err)
+		}
+		if err := pts.writeRefs(w, chg.GeoShapeLat, chg.GeoShapeLon); err != nil {
+			return fmt.Errorf("write GeoShapePoint: %w", err)
+		}
+	} else {
+		if err := writeShape(w, chg.GeoShapeLat); err != nil {
+			return fmt.Errorf("write GeoShapeLat: %w", err)
+		}
+		if err := writeShape(w, chg.GeoShapeLon); err != nil {
+			return fmt.Errorf("write GeoShapeLon: %w", err)
+		}
 	}
-	if err := writeLenPrefixedFloat64(w, chg.GeoShapeLon); err != nil {
-		return fmt.Errorf("write GeoShapeLon: %w", err)
+	if flags&flagAttrs != 0 {
+		if err := writeAttrSection(w, chg); err != nil {
+			return err
+		}
+	}
+	if flags&flagTurns != 0 {
+		if err := writeTurnSection(w, chg.TurnRestrictions); err != nil {
+			return err
+		}
+	}
+	if flags&flagOSMIDs != 0 {
+		if err := writeOSMIDSection(w, chg); err != nil {
+			return err
+		}
+	}
+	if flags&flagSnapRTree != 0 {
+		if err := writeSnapIndexSection(w, chg.SnapIndex); err != nil {
+			return err
+		}
+	}
+	if flags&flagLimits != 0 {
+		if err := writeLimitsSection(w, chg.EdgeLimits); err != nil {
+			return err
+		}
+	}
+	if zs != nil {
+		if err := zs.finish(); err != nil {
+			return err
+		}
 	}
 
 	// Write CRC32 trailer.
@@ -154,28 +357,81 @@ func WriteBinary(path string, chg *CHGraph) error {
 	if err := binary.Write(f, binary.LittleEndian, checksum); err != nil {
 		return fmt.Errorf("write CRC32: %w", err)
 	}
+	return writeMetaFooter(f, footerMeta(chg.Meta, chg.NodeLat, chg.NodeLon))
+}
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("close temp file: %w", err)
-	}
+// ReadBinary deserializes a CHResult from a binary file, decompressing it if
+// it was written with WriteOptions.Compress. Files of older format versions
+// are converted on load where possible; see Upgrade.
+func ReadBinary(path string) (*CHGraph, error) {
+	return ReadBinaryWith(path, ReadOptions{})
+}
 
-	// Atomic rename.
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("rename: %w", err)
+// ReadBinaryWith is ReadBinary with options.
+func ReadBinaryWith(path string, opt ReadOptions) (*CHGraph, error) {
+	f, src, err := openSection(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return readBinary(src, nil, opt)
+}
 
-	return nil
+// ReadBinaryAt is ReadBinaryWith over the size bytes of r, e.g. a graph held
+// in memory (bytes.Reader) or inside a larger file. The returned graph does
+// not reference r.
+func ReadBinaryAt(r io.ReaderAt, size int64, opt ReadOptions) (*CHGraph, error) {
+	return readBinary(io.NewSectionReader(r, 0, size), nil, opt)
 }
 
-// ReadBinary deserializes a CHResult from a binary file.
-func ReadBinary(path string) (*CHGraph, error) {
-	f, err := os.Open(path)
+// ReadBinaryFS is ReadBinary for a file in fsys, so a small regional graph can
+// be compiled into a self-contained binary with go:embed:
+//
+//	//go:embed graph.bin
+//	var graphFS embed.FS
+//	...
+//	chg, err := graph.ReadBinaryFS(graphFS, "graph.bin")
+//
+// Files that do not implement io.ReaderAt are read into memory first.
+func ReadBinaryFS(fsys fs.FS, path string) (*CHGraph, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open: %w", err)
 	}
 	defer f.Close()
+	if ra, ok := f.(io.ReaderAt); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return ReadBinaryAt(ra, info.Size(), ReadOptions{})
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return ReadBinaryAt(bytes.NewReader(data), int64(len(data)), ReadOptions{})
+}
 
-	crcReader := crc32Reader{r: f, hash: crc32.NewIEEE()}
+// openSection opens path for reading as one section spanning the whole file.
+func openSection(path string) (*os.File, *io.SectionReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, io.NewSectionReader(f, 0, info.Size()), nil
+}
+
+// readBinary reads a combined graph occupying all of src. pts resolves point
+// references in a bundle's profile sections and is nil for standalone files.
+func readBinary(src *io.SectionReader, pts *pointTable, opt ReadOptions) (*CHGraph, error) {
+	var err error
+	crcReader := crc32Reader{r: src, hash: crc32.NewIEEE()}
 	r := &crcReader
 
 	// Read and validate header.
@@ -184,27 +440,92 @@ func ReadBinary(path string) (*CHGraph, error) {
 		return nil, fmt.Errorf("read header: %w", err)
 	}
 
+	if string(hdr.Magic[:]) == bundleMagic {
+		return nil, fmt.Errorf("file is a multi-profile bundle; read it with ReadBundle")
+	}
 	if string(hdr.Magic[:]) != magicBytes {
 		return nil, fmt.Errorf("invalid magic bytes: %q", hdr.Magic)
 	}
-	if hdr.Version != version {
+	switch hdr.Version {
+	case versionDistanceMm, version, versionFlags:
+	case versionNoOrig:
+		return nil, errVersionTooOld(hdr.Version)
+	default:
 		return nil, fmt.Errorf("unsupported version: %d", hdr.Version)
 	}
-	if hdr.NumNodes > maxNodes {
-		return nil, fmt.Errorf("NumNodes %d exceeds limit %d", hdr.NumNodes, maxNodes)
+	var flags uint32
+	if hdr.Version == versionFlags {
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return nil, fmt.Errorf("read header flags: %w", err)
+		}
+		if flags&^knownFlags != 0 {
+			return nil, fmt.Errorf("unsupported header flags: %#x", flags&^knownFlags)
+		}
 	}
-	if hdr.NumFwdEdges > maxEdges || hdr.NumBwdEdges > maxEdges || hdr.NumOrigEdges > maxEdges {
-		return nil, fmt.Errorf("edge count exceeds limit %d", maxEdges)
+	nodeLimit, edgeLimit := uint32(maxNodes), uint32(maxEdges)
+	optLimit := uint64(maxOptionalBytes)
+	if flags&flagLarge != 0 {
+		nodeLimit, edgeLimit, optLimit = maxLargeNodes, maxLargeEdges, math.MaxInt
+		// Without the tight bounds, check the counts against the file
+		// instead: every node and edge takes at least 4 bytes in it.
+		need := 4 * (uint64(hdr.NumNodes) + uint64(hdr.NumFwdEdges) + uint64(hdr.NumBwdEdges) + uint64(hdr.NumOrigEdges))
+		if flags&flagZstd == 0 && need > uint64(src.Size()) {
+			return nil, fmt.Errorf("header counts need at least %d bytes, file has %d", need, src.Size())
+		}
+	}
+	if hdr.NumNodes > nodeLimit {
+		return nil, fmt.Errorf("NumNodes %d exceeds limit %d", hdr.NumNodes, nodeLimit)
+	}
+	if hdr.NumFwdEdges > edgeLimit || hdr.NumBwdEdges > edgeLimit || hdr.NumOrigEdges > edgeLimit {
+		return nil, fmt.Errorf("edge count exceeds limit %d", edgeLimit)
 	}
-
 	result := &CHGraph{NumNodes: hdr.NumNodes}
+	if flags&flagSectionCRCs != 0 {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, fmt.Errorf("read section checksums: %w", err)
+		}
+		if n > maxSections {
+			return nil, fmt.Errorf("%d section checksums exceed limit %d", n, maxSections)
+		}
+		if result.sectionCRCs, err = readUint32Slice(r, int(n)); err != nil {
+			return nil, fmt.Errorf("read section checksums: %w", err)
+		}
+	}
+	fast := opt.FastLoad && result.sectionCRCs != nil
+	if fast {
+		crcReader.hash = nopHash{}
+	}
+	closeSections := func() error { return nil }
+	if flags&flagZstd != 0 {
+		dec, closeDec, err := openZstd(src)
+		if err != nil {
+			return nil, err
+		}
+		defer closeDec()
+		crcReader.r, closeSections = dec, closeDec
+	}
 
 	// Node data.
-	if result.NodeLat, err = readFloat64Slice(r, int(hdr.NumNodes)); err != nil {
-		return nil, fmt.Errorf("read NodeLat: %w", err)
-	}
-	if result.NodeLon, err = readFloat64Slice(r, int(hdr.NumNodes)); err != nil {
-		return nil, fmt.Errorf("read NodeLon: %w", err)
+	coord32, pointRefs := flags&flagCoord32 != 0, flags&flagPointRefs != 0
+	if pointRefs != (pts != nil) {
+		return nil, fmt.Errorf("point references are only valid inside a bundle")
+	}
+	if pointRefs {
+		refs, err := readUint32Slice(r, int(hdr.NumNodes))
+		if err != nil {
+			return nil, fmt.Errorf("read NodePoint: %w", err)
+		}
+		if result.NodeLat, result.NodeLon, err = pts.resolve(refs); err != nil {
+			return nil, fmt.Errorf("read NodePoint: %w", err)
+		}
+	} else {
+		if result.NodeLat, err = readCoords(r, int(hdr.NumNodes), coord32); err != nil {
+			return nil, fmt.Errorf("read NodeLat: %w", err)
+		}
+		if result.NodeLon, err = readCoords(r, int(hdr.NumNodes), coord32); err != nil {
+			return nil, fmt.Errorf("read NodeLon: %w", err)
+		}
 	}
 	// Skip Rank (only used during preprocessing, not at query time).
 	if err := skipBytes(r, int(hdr.NumNodes)*4); err != nil {
@@ -251,19 +572,63 @@ func ReadBinary(path string) (*CHGraph, error) {
 	}
 
 	// Geometry (length-prefixed, optional for small test graphs).
-	result.GeoFirstOut, _ = readUint32SliceOptional(r)
-	result.GeoShapeLat, _ = readFloat64SliceOptional(r)
-	result.GeoShapeLon, _ = readFloat64SliceOptional(r)
+	result.GeoFirstOut, _ = readUint32SliceOptional(r, optLimit)
+	if pointRefs {
+		refs, _ := readUint32SliceOptional(r, optLimit)
+		if result.GeoShapeLat, result.GeoShapeLon, err = pts.resolve(refs); err != nil {
+			return nil, fmt.Errorf("read GeoShapePoint: %w", err)
+		}
+	} else {
+		result.GeoShapeLat, _ = readCoordsOptional(r, coord32, optLimit)
+		result.GeoShapeLon, _ = readCoordsOptional(r, coord32, optLimit)
+	}
+	if flags&flagAttrs != 0 {
+		if err := readAttrSection(r, result, int(hdr.NumOrigEdges)); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagTurns != 0 {
+		if err := validateCSR(result.OrigFirstOut, result.OrigHead, hdr.NumNodes); err != nil {
+			return nil, fmt.Errorf("original CSR invalid: %w", err)
+		}
+		if result.TurnRestrictions, err = readTurnSection(r, result.OrigFirstOut, result.OrigHead); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagOSMIDs != 0 {
+		if err := readOSMIDSection(r, result, int(hdr.NumNodes), int(hdr.NumOrigEdges)); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagSnapRTree != 0 {
+		if result.SnapIndex, err = readSnapIndexSection(r, result); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagLimits != 0 {
+		if result.EdgeLimits, err = readLimitsSection(r, int(hdr.NumOrigEdges)); err != nil {
+			return nil, err
+		}
+	}
+	if err := closeSections(); err != nil {
+		return nil, fmt.Errorf("read compressed sections: %w", err)
+	}
 
 	// Read and validate CRC32.
 	expectedCRC := crcReader.hash.Sum32()
 	var storedCRC uint32
-	if err := binary.Read(f, binary.LittleEndian, &storedCRC); err != nil {
+	if err := binary.Read(src, binary.LittleEndian, &storedCRC); err != nil {
 		return nil, fmt.Errorf("read CRC32: %w", err)
 	}
-	if storedCRC != expectedCRC {
+	if !fast && storedCRC != expectedCRC {
 		return nil, fmt.Errorf("CRC32 mismatch: stored=%08x computed=%08x", storedCRC, expectedCRC)
 	}
+	if result.Meta, err = loadMeta(src, hdr.Version); err != nil {
+		return nil, err
+	}
+	if hdr.Version == versionDistanceMm {
+		upgradeV2(result)
+	}
 
 	// Validate CSR invariants.
 	if err := validateCSR(result.FwdFirstOut, result.FwdHead, hdr.NumNodes); err != nil {
@@ -276,6 +641,35 @@ func ReadBinary(path string) (*CHGraph, error) {
 	return result, nil
 }
 
+// needsLarge reports whether chg is past the sanity bounds readers apply
+// to ordinary files, and so must be written with flagLarge. It fails when
+// chg is past what the format can represent at all.
+func needsLarge(chg *CHGraph) (bool, error) {
+	nodes := uint64(chg.NumNodes)
+	edges := uint64(max(len(chg.FwdHead), len(chg.BwdHead), len(chg.OrigHead)))
+	points, boxes := uint64(len(chg.GeoShapeLat)), uint64(0)
+	if chg.SnapIndex != nil {
+		boxes = uint64(len(chg.SnapIndex.MinLat))
+	}
+	if nodes > maxLargeNodes || edges > maxLargeEdges || points > math.MaxUint32 || boxes > math.MaxUint32 {
+		return false, fmt.Errorf("graph of %d nodes, %d edges, %d shape points and %d snap index boxes exceeds the format's limits of %d nodes, %d edges and %d points or boxes",
+			nodes, edges, points, boxes, maxLargeNodes, maxLargeEdges, uint64(math.MaxUint32))
+	}
+	// Shape points are counted at 8 bytes, their size as float64 coordinates.
+	return nodes > maxNodes || edges > maxEdges || points*8 > maxOptionalBytes, nil
+}
+
+// checkBounds fails for graphs past maxNodes/maxEdges, for the formats
+// without flagLarge, whose readers could not load them back.
+func checkBounds(format string, nodes uint32, edges ...int) error {
+	most := slices.Max(append(edges, 0))
+	if nodes > maxNodes || most > maxEdges {
+		return fmt.Errorf("graph of %d nodes, %d edges exceeds the %s format's limits of %d and %d; write a combined file instead",
+			nodes, most, format, maxNodes, maxEdges)
+	}
+	return nil
+}
+
 // validateCSR checks CSR invariants.
 func validateCSR(firstOut, head []uint32, numNodes uint32) error {
 	if uint32(len(firstOut)) != numNodes+1 {
@@ -298,6 +692,19 @@ func validateCSR(firstOut, head []uint32, numNodes uint32) error {
 	return nil
 }
 
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n int64) error {
+	var buf [32 * 1024]byte
+	for n > 0 {
+		k := min(n, int64(len(buf)))
+		if _, err := w.Write(buf[:k]); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
 // skipBytes reads and discards n bytes from r.
 // Used to skip fields that are written for format compatibility but not needed at runtime.
 func skipBytes(r io.Reader, n int) error {
@@ -312,69 +719,48 @@ func skipBytes(r io.Reader, n int) error {
 	return nil
 }
 
-// Zero-copy I/O helpers using unsafe.Slice.
+// Slice I/O helpers; see endian.go.
 
 func writeUint32Slice(w io.Writer, s []uint32) error {
-	if len(s) == 0 {
-		return nil
-	}
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*4)
-	_, err := w.Write(b)
-	return err
+	return writeRaw(w, s, 4, swap32)
 }
 
 func writeInt32Slice(w io.Writer, s []int32) error {
-	if len(s) == 0 {
-		return nil
-	}
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*4)
-	_, err := w.Write(b)
-	return err
+	return writeRaw(w, s, 4, swap32)
 }
 
 func writeFloat64Slice(w io.Writer, s []float64) error {
-	if len(s) == 0 {
-		return nil
-	}
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*8)
-	_, err := w.Write(b)
-	return err
+	return writeRaw(w, s, 8, swap64)
 }
 
 func readUint32Slice(r io.Reader, n int) ([]uint32, error) {
-	if n == 0 {
-		return nil, nil
-	}
-	s := make([]uint32, n)
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), n*4)
-	if _, err := io.ReadFull(r, b); err != nil {
-		return nil, err
-	}
-	return s, nil
+	return readRaw[uint32](r, n, 4, swap32)
 }
 
 func readInt32Slice(r io.Reader, n int) ([]int32, error) {
-	if n == 0 {
-		return nil, nil
-	}
-	s := make([]int32, n)
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), n*4)
-	if _, err := io.ReadFull(r, b); err != nil {
-		return nil, err
-	}
-	return s, nil
+	return readRaw[int32](r, n, 4, swap32)
 }
 
 func readFloat64Slice(r io.Reader, n int) ([]float64, error) {
-	if n == 0 {
-		return nil, nil
+	return readRaw[float64](r, n, 8, swap64)
+}
+
+// readCoords reads n coordinates stored as Coords (coord32) or, in files
+// from before fixed-point coordinates, as float64 degrees.
+func readCoords(r io.Reader, n int, coord32 bool) ([]Coord, error) {
+	if coord32 {
+		return readCoordSlice(r, n)
 	}
-	s := make([]float64, n)
-	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), n*8)
-	if _, err := io.ReadFull(r, b); err != nil {
-		return nil, err
+	deg, err := readFloat64Slice(r, n)
+	return toCoords(deg), err
+}
+
+func readCoordsOptional(r io.Reader, coord32 bool, maxBytes uint64) ([]Coord, error) {
+	if coord32 {
+		return readCoordSliceOptional(r, maxBytes)
 	}
-	return s, nil
+	deg, err := readFloat64SliceOptional(r, maxBytes)
+	return toCoords(deg), err
 }
 
 func writeLenPrefixedUint32(w io.Writer, s []uint32) error {
@@ -394,24 +780,25 @@ func writeLenPrefixedFloat64(w io.Writer, s []float64) error {
 }
 
 // readUint32SliceOptional reads a uint32 length prefix then the slice data.
-// Returns nil, nil if at EOF or data unavailable.
-func readUint32SliceOptional(r io.Reader) ([]uint32, error) {
+// Returns nil, nil if at EOF or data unavailable, or if the data would take
+// more than maxBytes (see maxOptionalBytes).
+func readUint32SliceOptional(r io.Reader, maxBytes uint64) ([]uint32, error) {
 	var n uint32
 	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
 		return nil, nil // EOF or error — geometry is optional
 	}
-	if n == 0 || n > math.MaxUint32/4 {
+	if n == 0 || uint64(n)*4 > maxBytes {
 		return nil, nil
 	}
 	return readUint32Slice(r, int(n))
 }
 
-func readFloat64SliceOptional(r io.Reader) ([]float64, error) {
+func readFloat64SliceOptional(r io.Reader, maxBytes uint64) ([]float64, error) {
 	var n uint32
 	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
 		return nil, nil
 	}
-	if n == 0 || n > math.MaxUint32/8 {
+	if n == 0 || uint64(n)*8 > maxBytes {
 		return nil, nil
 	}
 	return readFloat64Slice(r, int(n))
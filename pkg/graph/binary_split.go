@@ -24,7 +24,10 @@ import (
 const (
 	baseMagic    = "MPRBASE1"
 	overlayMagic = "MPROVLY1"
-	splitVersion = uint32(1)
+	// splitVersion 2 stores base coordinates as int32 Coords; version 1 files,
+	// with float64 degrees, are still read.
+	splitVersion        = uint32(2)
+	splitVersionFloat64 = uint32(1)
 )
 
 // baseHeader is the header of a base file.
@@ -52,12 +55,14 @@ type overlayHeader struct {
 // overlay that were built from different sources can be detected at load time.
 // It deliberately covers only what makes node/edge indices meaningful (node
 // count, coordinates, original CSR); geometry lives solely in the base and can
-// never be addressed by an overlay, so it is excluded.
-func topologyIdentity(numNodes uint32, nodeLat, nodeLon []float64, origFirstOut, origHead []uint32) uint32 {
+// never be addressed by an overlay, so it is excluded. Coordinates are hashed
+// as float64 degrees, as in version 1 files, so identities are stable across
+// the coordinate encoding.
+func topologyIdentity(numNodes uint32, nodeLat, nodeLon []Coord, origFirstOut, origHead []uint32) uint32 {
 	h := crc32.NewIEEE()
 	_ = binary.Write(h, binary.LittleEndian, numNodes)
-	_ = writeFloat64Slice(h, nodeLat)
-	_ = writeFloat64Slice(h, nodeLon)
+	_ = writeCoordsAsFloat64(h, nodeLat)
+	_ = writeCoordsAsFloat64(h, nodeLon)
 	_ = writeUint32Slice(h, origFirstOut)
 	_ = writeUint32Slice(h, origHead)
 	return h.Sum32()
@@ -65,7 +70,11 @@ func topologyIdentity(numNodes uint32, nodeLat, nodeLon []float64, origFirstOut,
 
 // WriteBase serializes the metric-independent half of a CHGraph to a base file.
 func WriteBase(path string, chg *CHGraph) error {
-	return writeSplitFile(path, func(w io.Writer) error {
+	if err := checkBounds("split", chg.NumNodes, len(chg.OrigHead)); err != nil {
+		return err
+	}
+	meta := footerMeta(chg.Meta, chg.NodeLat, chg.NodeLon)
+	return writeSplitFile(path, meta, func(w io.Writer) error {
 		hdr := baseHeader{
 			Version:      splitVersion,
 			NumNodes:     chg.NumNodes,
@@ -77,10 +86,10 @@ func WriteBase(path string, chg *CHGraph) error {
 			return fmt.Errorf("write header: %w", err)
 		}
 
-		if err := writeFloat64Slice(w, chg.NodeLat); err != nil {
+		if err := writeCoordSlice(w, chg.NodeLat); err != nil {
 			return fmt.Errorf("write NodeLat: %w", err)
 		}
-		if err := writeFloat64Slice(w, chg.NodeLon); err != nil {
+		if err := writeCoordSlice(w, chg.NodeLon); err != nil {
 			return fmt.Errorf("write NodeLon: %w", err)
 		}
 		if err := writeUint32Slice(w, chg.OrigFirstOut); err != nil {
@@ -92,10 +101,10 @@ func WriteBase(path string, chg *CHGraph) error {
 		if err := writeLenPrefixedUint32(w, chg.GeoFirstOut); err != nil {
 			return fmt.Errorf("write GeoFirstOut: %w", err)
 		}
-		if err := writeLenPrefixedFloat64(w, chg.GeoShapeLat); err != nil {
+		if err := writeLenPrefixedCoord(w, chg.GeoShapeLat); err != nil {
 			return fmt.Errorf("write GeoShapeLat: %w", err)
 		}
-		if err := writeLenPrefixedFloat64(w, chg.GeoShapeLon); err != nil {
+		if err := writeLenPrefixedCoord(w, chg.GeoShapeLon); err != nil {
 			return fmt.Errorf("write GeoShapeLon: %w", err)
 		}
 		return nil
@@ -105,7 +114,11 @@ func WriteBase(path string, chg *CHGraph) error {
 // WriteOverlay serializes the metric-specific half of a CHGraph to an overlay
 // file, stamped with the paired base's topology identity.
 func WriteOverlay(path string, chg *CHGraph) error {
-	return writeSplitFile(path, func(w io.Writer) error {
+	if err := checkBounds("split", chg.NumNodes, len(chg.FwdHead), len(chg.BwdHead)); err != nil {
+		return err
+	}
+	meta := footerMeta(chg.Meta, chg.NodeLat, chg.NodeLon)
+	return writeSplitFile(path, meta, func(w io.Writer) error {
 		var numShortcuts uint32
 		for _, m := range chg.FwdMiddle {
 			if m >= 0 {
@@ -171,13 +184,13 @@ func WriteOverlay(path string, chg *CHGraph) error {
 
 // ReadBase deserializes a base file.
 func ReadBase(path string) (*BaseGraph, error) {
-	f, err := os.Open(path)
+	f, src, err := openSection(path)
 	if err != nil {
-		return nil, fmt.Errorf("open: %w", err)
+		return nil, err
 	}
 	defer f.Close()
 
-	crcReader := crc32Reader{r: f, hash: crc32.NewIEEE()}
+	crcReader := crc32Reader{r: src, hash: crc32.NewIEEE()}
 	r := &crcReader
 
 	var hdr baseHeader
@@ -187,7 +200,7 @@ func ReadBase(path string) (*BaseGraph, error) {
 	if string(hdr.Magic[:]) != baseMagic {
 		return nil, fmt.Errorf("invalid base magic bytes: %q", hdr.Magic)
 	}
-	if hdr.Version != splitVersion {
+	if hdr.Version != splitVersion && hdr.Version != splitVersionFloat64 {
 		return nil, fmt.Errorf("unsupported base version: %d", hdr.Version)
 	}
 	if hdr.NumNodes > maxNodes {
@@ -198,10 +211,11 @@ func ReadBase(path string) (*BaseGraph, error) {
 	}
 
 	b := &BaseGraph{NumNodes: hdr.NumNodes, Identity: hdr.Identity}
-	if b.NodeLat, err = readFloat64Slice(r, int(hdr.NumNodes)); err != nil {
+	coord32 := hdr.Version != splitVersionFloat64
+	if b.NodeLat, err = readCoords(r, int(hdr.NumNodes), coord32); err != nil {
 		return nil, fmt.Errorf("read NodeLat: %w", err)
 	}
-	if b.NodeLon, err = readFloat64Slice(r, int(hdr.NumNodes)); err != nil {
+	if b.NodeLon, err = readCoords(r, int(hdr.NumNodes), coord32); err != nil {
 		return nil, fmt.Errorf("read NodeLon: %w", err)
 	}
 	if b.OrigFirstOut, err = readUint32Slice(r, int(hdr.NumNodes+1)); err != nil {
@@ -210,11 +224,14 @@ func ReadBase(path string) (*BaseGraph, error) {
 	if b.OrigHead, err = readUint32Slice(r, int(hdr.NumOrigEdges)); err != nil {
 		return nil, fmt.Errorf("read OrigHead: %w", err)
 	}
-	b.GeoFirstOut, _ = readUint32SliceOptional(r)
-	b.GeoShapeLat, _ = readFloat64SliceOptional(r)
-	b.GeoShapeLon, _ = readFloat64SliceOptional(r)
+	b.GeoFirstOut, _ = readUint32SliceOptional(r, maxOptionalBytes)
+	b.GeoShapeLat, _ = readCoordsOptional(r, coord32, maxOptionalBytes)
+	b.GeoShapeLon, _ = readCoordsOptional(r, coord32, maxOptionalBytes)
 
-	if err := verifyCRC(f, &crcReader); err != nil {
+	if err := verifyCRC(src, &crcReader); err != nil {
+		return nil, err
+	}
+	if b.Meta, err = loadMeta(src, hdr.Version); err != nil {
 		return nil, err
 	}
 
@@ -233,13 +250,13 @@ func ReadBase(path string) (*BaseGraph, error) {
 // full CHGraph whose base-half slices are shared with base (not copied). The
 // overlay's stamped identity must match base.Identity.
 func ReadOverlay(path string, base *BaseGraph) (*CHGraph, error) {
-	f, err := os.Open(path)
+	f, src, err := openSection(path)
 	if err != nil {
-		return nil, fmt.Errorf("open: %w", err)
+		return nil, err
 	}
 	defer f.Close()
 
-	crcReader := crc32Reader{r: f, hash: crc32.NewIEEE()}
+	crcReader := crc32Reader{r: src, hash: crc32.NewIEEE()}
 	r := &crcReader
 
 	var hdr overlayHeader
@@ -249,7 +266,7 @@ func ReadOverlay(path string, base *BaseGraph) (*CHGraph, error) {
 	if string(hdr.Magic[:]) != overlayMagic {
 		return nil, fmt.Errorf("invalid overlay magic bytes: %q", hdr.Magic)
 	}
-	if hdr.Version != splitVersion {
+	if hdr.Version != splitVersion && hdr.Version != splitVersionFloat64 {
 		return nil, fmt.Errorf("unsupported overlay version: %d", hdr.Version)
 	}
 	if hdr.NumNodes != base.NumNodes {
@@ -307,7 +324,10 @@ func ReadOverlay(path string, base *BaseGraph) (*CHGraph, error) {
 		return nil, fmt.Errorf("read BwdMiddle: %w", err)
 	}
 
-	if err := verifyCRC(f, &crcReader); err != nil {
+	if err := verifyCRC(src, &crcReader); err != nil {
+		return nil, err
+	}
+	if chg.Meta, err = loadMeta(src, hdr.Version); err != nil {
 		return nil, err
 	}
 
@@ -321,9 +341,9 @@ func ReadOverlay(path string, base *BaseGraph) (*CHGraph, error) {
 }
 
 // writeSplitFile runs body against a CRC-wrapping writer over a temp file, then
-// appends the CRC32 trailer and atomically renames into place. Mirrors the
-// durability contract of WriteBinary.
-func writeSplitFile(path string, body func(w io.Writer) error) error {
+// appends the CRC32 trailer and the optional metadata footer and atomically
+// renames into place. Mirrors the durability contract of WriteBinary.
+func writeSplitFile(path string, meta *Metadata, body func(w io.Writer) error) error {
 	tmpPath := path + ".tmp"
 	f, err := os.Create(tmpPath)
 	if err != nil {
@@ -342,6 +362,9 @@ func writeSplitFile(path string, body func(w io.Writer) error) error {
 	if err := binary.Write(f, binary.LittleEndian, crcWriter.hash.Sum32()); err != nil {
 		return fmt.Errorf("write CRC32: %w", err)
 	}
+	if err := writeMetaFooter(f, meta); err != nil {
+		return err
+	}
 	if err := f.Close(); err != nil {
 		return fmt.Errorf("close temp file: %w", err)
 	}
@@ -351,12 +374,12 @@ func writeSplitFile(path string, body func(w io.Writer) error) error {
 	return nil
 }
 
-// verifyCRC reads the trailing CRC32 from f and compares it to what the reader
+// verifyCRC reads the trailing CRC32 from r and compares it to what the reader
 // accumulated over the payload.
-func verifyCRC(f *os.File, cr *crc32Reader) error {
+func verifyCRC(r io.Reader, cr *crc32Reader) error {
 	expected := cr.hash.Sum32()
 	var stored uint32
-	if err := binary.Read(f, binary.LittleEndian, &stored); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &stored); err != nil {
 		return fmt.Errorf("read CRC32: %w", err)
 	}
 	if stored != expected {
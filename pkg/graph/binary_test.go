@@ -1,9 +1,18 @@
 package graph_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/paulmach/osm"
 
@@ -51,7 +60,7 @@ func TestBinaryRoundTrip(t *testing.T) {
 
 	for i := uint32(0); i < original.NumNodes; i++ {
 		if loaded.NodeLat[i] != original.NodeLat[i] {
-			t.Errorf("NodeLat[%d]: got %f, want %f", i, loaded.NodeLat[i], original.NodeLat[i])
+			t.Errorf("NodeLat[%d]: got %d, want %d", i, loaded.NodeLat[i], original.NodeLat[i])
 		}
 	}
 
@@ -101,3 +110,304 @@ func TestBinaryTruncatedFile(t *testing.T) {
 		t.Fatal("expected error for truncated file")
 	}
 }
+
+func TestBinaryCompressedRoundTrip(t *testing.T) {
+	original := buildTestCH(t)
+	original.Meta = &graph.Metadata{Metric: "time"}
+
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "plain.graph.bin")
+	zstdPath := filepath.Join(dir, "zstd.graph.bin")
+	if err := graph.WriteBinary(plainPath, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	if err := graph.WriteBinaryWith(zstdPath, original, graph.WriteOptions{Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+
+	plain, err := graph.ReadBinary(plainPath)
+	if err != nil {
+		t.Fatalf("ReadBinary(plain): %v", err)
+	}
+	loaded, err := graph.ReadBinary(zstdPath)
+	if err != nil {
+		t.Fatalf("ReadBinary(compressed): %v", err)
+	}
+	if loaded.Meta.FormatVersion != 4 {
+		t.Errorf("compressed format version = %d, want 4", loaded.Meta.FormatVersion)
+	}
+	if loaded.Meta.Metric != "time" {
+		t.Errorf("metadata footer lost: %+v", loaded.Meta)
+	}
+	if !reflect.DeepEqual(loaded, plain) {
+		// Meta differs only in FormatVersion, checked above.
+		loaded.Meta, plain.Meta = nil, nil
+		if !reflect.DeepEqual(loaded, plain) {
+			t.Error("compressed graph differs from the uncompressed one")
+		}
+	}
+
+	// Corruption inside the frame must not load.
+	data, err := os.ReadFile(zstdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(zstdPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadBinary(zstdPath); err == nil {
+		t.Error("ReadBinary accepted a corrupted compressed file")
+	}
+}
+
+// writeOldVersion writes chg (without metadata) and rewrites its header
+// version to v, fixing up the CRC32 trailer, to stand in for a file written
+// by an older release.
+func writeOldVersion(t *testing.T, path string, chg *graph.CHGraph, v uint32) {
+	t.Helper()
+	chg.Meta, chg.EdgeAttr = nil, nil
+	if err := graph.WriteBinaryWith(path, chg, graph.WriteOptions{Float64Coords: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[8:], v)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(data[:len(data)-4]))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readerOnlyFile hides the io.ReaderAt of an fs.File.
+type readerOnlyFile struct{ fs.File }
+
+type readerOnlyFS struct{ fs.FS }
+
+func (f readerOnlyFS) Open(name string) (fs.File, error) {
+	file, err := f.FS.Open(name)
+	return readerOnlyFile{file}, err
+}
+
+func TestReadBinaryFS(t *testing.T) {
+	original := buildTestCH(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "graph.bin")
+	if err := graph.WriteBinaryWith(path, original, graph.WriteOptions{Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	want, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{"data/graph.bin": {Data: data}}
+	for name, fsys := range map[string]fs.FS{"ReaderAt": fsys, "Reader": readerOnlyFS{fsys}} {
+		got, err := graph.ReadBinaryFS(fsys, "data/graph.bin")
+		if err != nil {
+			t.Fatalf("%s: ReadBinaryFS: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ReadBinaryFS differs from ReadBinary", name)
+		}
+	}
+	if _, err := graph.ReadBinaryFS(fsys, "missing.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: err = %v, want fs.ErrNotExist", err)
+	}
+
+	// A graph embedded after a 3-byte prefix, e.g. inside a larger blob.
+	blob := append([]byte("abc"), data...)
+	got, err := graph.ReadBinaryAt(io.NewSectionReader(bytes.NewReader(blob), 3, int64(len(data))), int64(len(data)), graph.ReadOptions{})
+	if err != nil {
+		t.Fatalf("ReadBinaryAt: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("ReadBinaryAt differs from ReadBinary")
+	}
+}
+
+func TestBinaryReadsV2DistanceGraph(t *testing.T) {
+	original := buildTestCH(t) // weights read as millimetres in a v2 file
+	dir := t.TempDir()
+	v2 := filepath.Join(dir, "v2.graph.bin")
+	writeOldVersion(t, v2, original, 2)
+
+	loaded, err := graph.ReadBinary(v2)
+	if err != nil {
+		t.Fatalf("ReadBinary(v2): %v", err)
+	}
+	if loaded.Meta.FormatVersion != 2 || loaded.Meta.Metric != "distance" {
+		t.Errorf("meta = %+v, want format version 2, metric distance", loaded.Meta)
+	}
+	for i, w := range original.OrigWeight {
+		if want := (w + 5) / 10; loaded.OrigWeight[i] != want {
+			t.Errorf("OrigWeight[%d] = %d, want %d cm", i, loaded.OrigWeight[i], want)
+		}
+	}
+
+	upgraded := filepath.Join(dir, "v4.graph.bin")
+	if err := graph.Upgrade(v2, upgraded, graph.WriteOptions{}); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	again, err := graph.ReadBinary(upgraded)
+	if err != nil {
+		t.Fatalf("ReadBinary(upgraded): %v", err)
+	}
+	if again.Meta.FormatVersion != 4 || again.Meta.Metric != "distance" {
+		t.Errorf("upgraded meta = %+v, want format version 4, metric distance", again.Meta)
+	}
+	if !reflect.DeepEqual(again.FwdWeight, loaded.FwdWeight) {
+		t.Errorf("upgraded FwdWeight = %v, want %v (converted once)", again.FwdWeight, loaded.FwdWeight)
+	}
+}
+
+func TestBinaryRejectsV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v1.graph.bin")
+	writeOldVersion(t, path, buildTestCH(t), 1)
+	_, err := graph.ReadBinary(path)
+	if err == nil || !strings.Contains(err.Error(), "preprocess") {
+		t.Errorf("ReadBinary(v1) error = %v, want a rebuild hint", err)
+	}
+}
+
+func TestBinaryEdgeAttributes(t *testing.T) {
+	result := &osmparser.ParseResult{
+		Names: []osmparser.RoadName{{}, {Name: "Orchard Road"}, {Name: "Ayer Rajah Expressway", Ref: "AYE"}},
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100, Name: 1, Class: osmparser.ClassPrimary, SpeedKmh: 50, Roundabout: true},
+			{FromNodeID: 20, ToNodeID: 30, Weight: 200, Name: 2, Class: osmparser.ClassMotorway, SpeedKmh: 90,
+				Flags: osmparser.WayOneway | osmparser.WayBridge | osmparser.WayToll},
+			{FromNodeID: 30, ToNodeID: 10, Weight: 300, Class: osmparser.ClassService, SpeedKmh: 20, Restricted: true,
+				Flags: osmparser.WayTunnel | osmparser.WayFerry | osmparser.WayUnpaved},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.0, 20: 1.1, 30: 1.2},
+		NodeLon: map[osm.NodeID]float64{10: 103.0, 20: 103.1, 30: 103.2},
+	}
+	wantFlags := []graph.EdgeFlags{
+		graph.FlagRoundabout,
+		graph.FlagOneway | graph.FlagBridge | graph.FlagToll,
+		graph.FlagRestricted | graph.FlagTunnel | graph.FlagFerry | graph.FlagUnpaved,
+	}
+	g := graph.Build(result)
+	for e := range g.Head {
+		a, want := g.EdgeAttribute(uint32(e)), result.Edges[e]
+		if a.Class != want.Class || a.SpeedKmh != uint16(want.SpeedKmh) || a.Flags != wantFlags[e] {
+			t.Errorf("edge %d attr = %+v, built from %+v", e, a, want)
+		}
+	}
+	if got := wantFlags[2].Names(); !reflect.DeepEqual(got, []string{"restricted", "tunnel", "ferry", "unpaved"}) {
+		t.Errorf("Names() = %v", got)
+	}
+
+	original := ch.Contract(g)
+	path := filepath.Join(t.TempDir(), "attrs.graph.bin")
+	if err := graph.WriteBinary(path, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	loaded, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Names, original.Names) {
+		t.Errorf("Names = %v, want %v", loaded.Names, original.Names)
+	}
+	if !reflect.DeepEqual(loaded.EdgeName, original.EdgeName) {
+		t.Errorf("EdgeName = %v, want %v", loaded.EdgeName, original.EdgeName)
+	}
+	if !reflect.DeepEqual(loaded.EdgeAttr, original.EdgeAttr) {
+		t.Errorf("EdgeAttr = %+v, want %+v", loaded.EdgeAttr, original.EdgeAttr)
+	}
+}
+
+func TestBinaryFixedPointCoords(t *testing.T) {
+	original := buildTestCH(t)
+	dir := t.TempDir()
+	fixedPath := filepath.Join(dir, "fixed.graph.bin")
+	floatPath := filepath.Join(dir, "float.graph.bin")
+	if err := graph.WriteBinary(fixedPath, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	if err := graph.WriteBinaryWith(floatPath, original, graph.WriteOptions{Float64Coords: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+
+	fixed, err := graph.ReadBinary(fixedPath)
+	if err != nil {
+		t.Fatalf("ReadBinary(fixed): %v", err)
+	}
+	float, err := graph.ReadBinary(floatPath)
+	if err != nil {
+		t.Fatalf("ReadBinary(float64): %v", err)
+	}
+	fixed.Meta, float.Meta = nil, nil
+	if !reflect.DeepEqual(fixed, float) {
+		t.Error("float64-coordinate file reads back differently from the fixed-point one")
+	}
+	for i, c := range original.NodeLat {
+		if fixed.NodeLat[i] != c {
+			t.Errorf("NodeLat[%d] = %d, want %d", i, fixed.NodeLat[i], c)
+		}
+	}
+
+	fi, _ := os.Stat(fixedPath)
+	ff, _ := os.Stat(floatPath)
+	// 4 bytes saved per node coordinate (the test graph has no shape points).
+	if want := ff.Size() - int64(original.NumNodes)*8; fi.Size() != want {
+		t.Errorf("fixed-point file is %d bytes, want %d", fi.Size(), want)
+	}
+}
+
+func TestCoordRoundTrip(t *testing.T) {
+	for _, deg := range []float64{0, 1.3521, 103.8198, -33.8688123, 151.2092955, 180, -180} {
+		c := graph.ToCoord(deg)
+		if c.Deg() != deg {
+			t.Errorf("ToCoord(%v).Deg() = %v", deg, c.Deg())
+		}
+	}
+	if got := graph.ToCoord(1.00000004); got != 10000000 {
+		t.Errorf("ToCoord rounds to %d, want 10000000", got)
+	}
+}
+
+func TestBinaryLargeGraphBounds(t *testing.T) {
+	dir := t.TempDir()
+	// A header past the default bounds, with and without flagLarge (1<<8).
+	header := func(flags uint32) string {
+		var buf bytes.Buffer
+		hdr := struct {
+			Magic                                                    [8]byte
+			Version, NumNodes, NumOrig, NumShortcuts, NumFwd, NumBwd uint32
+			Flags                                                    uint32
+		}{Version: 4, NumNodes: 100_000_000, NumOrig: 300_000_000, Flags: flags}
+		copy(hdr.Magic[:], "MPROUTER")
+		binary.Write(&buf, binary.LittleEndian, hdr)
+		path := filepath.Join(dir, "large.graph.bin")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	if _, err := graph.ReadBinary(header(0)); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("ReadBinary without flagLarge: error = %v, want a limit error", err)
+	}
+	// With the flag the counts are allowed, but must fit in the file.
+	if _, err := graph.ReadBinary(header(1 << 8)); err == nil || !strings.Contains(err.Error(), "need at least") {
+		t.Errorf("ReadBinary with flagLarge: error = %v, want a file size error", err)
+	}
+
+	// Formats without the flag refuse to write what they could not read.
+	big := &graph.CHGraph{NumNodes: 100_000_000}
+	if err := graph.WriteBase(filepath.Join(dir, "base.bin"), big); err == nil {
+		t.Error("WriteBase accepted a graph past the split format's bounds")
+	}
+	big.NumNodes = 1 << 31 // past int32 node IDs
+	if err := graph.WriteBinary(filepath.Join(dir, "huge.bin"), big); err == nil {
+		t.Error("WriteBinary accepted a graph past the format's limits")
+	}
+}
@@ -1,14 +1,19 @@
 package graph
 
 import (
-	"sort"
+	"cmp"
+	"math"
+	"sync"
+	"sync/atomic"
 
 	"github.com/paulmach/osm"
 
 	osmparser "github.com/azybler/map_router/pkg/osm"
 )
 
-// Build creates a CSR Graph from parsed OSM edges.
+// Build creates a CSR Graph from parsed OSM edges. Nodes are numbered in
+// order of first appearance in result.Edges. The phases run in parallel on
+// large inputs.
 func Build(result *osmparser.ParseResult) *Graph {
 	edges := result.Edges
 	if len(edges) == 0 {
@@ -16,25 +21,7 @@ func Build(result *osmparser.ParseResult) *Graph {
 	}
 
 	// Step 1: Collect all unique node IDs and build a compact mapping.
-	nodeSet := make(map[osm.NodeID]uint32)
-	var nodeIDs []osm.NodeID
-
-	addNode := func(id osm.NodeID) uint32 {
-		if idx, ok := nodeSet[id]; ok {
-			return idx
-		}
-		idx := uint32(len(nodeIDs))
-		nodeSet[id] = idx
-		nodeIDs = append(nodeIDs, id)
-		return idx
-	}
-
-	// Pre-collect all nodes referenced by edges.
-	for i := range edges {
-		addNode(edges[i].FromNodeID)
-		addNode(edges[i].ToNodeID)
-	}
-
+	nodeIndex, nodeIDs := numberNodes(edges)
 	numNodes := uint32(len(nodeIDs))
 
 	// Step 2: Build compact edge list with remapped indices.
@@ -43,28 +30,47 @@ func Build(result *osmparser.ParseResult) *Graph {
 		to         uint32
 		weight     uint32
 		restricted bool
+		name       uint32
+		limits     VehicleLimits
+		attr       EdgeAttr
+		wayID      osm.WayID
 		shapeLats  []float64
 		shapeLons  []float64
 	}
 
+	var hasLimits atomic.Bool
 	compact := make([]compactEdge, len(edges))
-	for i, e := range edges {
-		compact[i] = compactEdge{
-			from:       nodeSet[e.FromNodeID],
-			to:         nodeSet[e.ToNodeID],
-			weight:     e.Weight,
-			restricted: e.Restricted,
-			shapeLats:  e.ShapeLats,
-			shapeLons:  e.ShapeLons,
+	parallelFor(len(edges), func(lo, hi int) {
+		limits := false
+		for i, e := range edges[lo:hi] {
+			from, _ := nodeIndex.get(e.FromNodeID)
+			to, _ := nodeIndex.get(e.ToNodeID)
+			limits = limits || !e.Limits.IsZero()
+			compact[lo+i] = compactEdge{
+				from:       from,
+				to:         to,
+				weight:     e.Weight,
+				restricted: e.Restricted,
+				name:       e.Name,
+				limits:     e.Limits,
+				attr:       edgeAttr(e),
+				wayID:      e.WayID,
+				shapeLats:  e.ShapeLats,
+				shapeLons:  e.ShapeLons,
+			}
 		}
-	}
+		if limits {
+			hasLimits.Store(true)
+		}
+	})
 
-	// Step 3: Sort edges by source node.
-	sort.Slice(compact, func(i, j int) bool {
-		if compact[i].from != compact[j].from {
-			return compact[i].from < compact[j].from
+	// Step 3: Sort edges by source node. Parallel edges keep their input
+	// order.
+	sortStableParallel(compact, func(a, b compactEdge) int {
+		if c := cmp.Compare(a.from, b.from); c != 0 {
+			return c
 		}
-		return compact[i].to < compact[j].to
+		return cmp.Compare(a.to, b.to)
 	})
 
 	// Step 4: Build CSR arrays.
@@ -73,39 +79,76 @@ func Build(result *osmparser.ParseResult) *Graph {
 	head := make([]uint32, numEdges)
 	weight := make([]uint32, numEdges)
 	edgeRestricted := make([]bool, numEdges)
+	edgeName := make([]uint32, numEdges)
+	edgeWayID := make([]osm.WayID, numEdges)
+	edgeAttrs := make([]EdgeAttr, numEdges)
+	var edgeLimits []VehicleLimits
+	if hasLimits.Load() {
+		edgeLimits = make([]VehicleLimits, numEdges)
+	}
 
-	// Geometry arrays.
+	// Geometry arrays: offsets first, so the shape points can be converted
+	// in parallel.
 	geoFirstOut := make([]uint32, numEdges+1)
-	var geoShapeLat, geoShapeLon []float64
-
 	for i, e := range compact {
-		head[i] = e.to
-		weight[i] = e.weight
-		edgeRestricted[i] = e.restricted
-		geoFirstOut[i] = uint32(len(geoShapeLat))
-		geoShapeLat = append(geoShapeLat, e.shapeLats...)
-		geoShapeLon = append(geoShapeLon, e.shapeLons...)
+		geoFirstOut[i+1] = geoFirstOut[i] + uint32(len(e.shapeLats))
 	}
-	geoFirstOut[numEdges] = uint32(len(geoShapeLat))
+	geoShapeLat := make([]Coord, geoFirstOut[numEdges])
+	geoShapeLon := make([]Coord, geoFirstOut[numEdges])
 
-	// Build FirstOut via counting.
-	for _, e := range compact {
-		firstOut[e.from+1]++
-	}
-	// Prefix sum.
-	for i := uint32(1); i <= numNodes; i++ {
-		firstOut[i] += firstOut[i-1]
+	parallelFor(len(compact), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			e := &compact[i]
+			head[i] = e.to
+			weight[i] = e.weight
+			edgeRestricted[i] = e.restricted
+			edgeName[i] = e.name
+			edgeWayID[i] = e.wayID
+			edgeAttrs[i] = e.attr
+			if edgeLimits != nil {
+				edgeLimits[i] = e.limits
+			}
+			for k := range e.shapeLats {
+				geoShapeLat[geoFirstOut[i]+uint32(k)] = ToCoord(e.shapeLats[k])
+				geoShapeLon[geoFirstOut[i]+uint32(k)] = ToCoord(e.shapeLons[k])
+			}
+
+			// Build FirstOut from the sorted sources: edge i is the first of
+			// every node after the previous edge's source, up to its own.
+			u := uint32(0)
+			if i > 0 {
+				u = compact[i-1].from + 1
+			}
+			for ; u <= e.from; u++ {
+				firstOut[u] = uint32(i)
+			}
+		}
+	})
+	for u := compact[numEdges-1].from + 1; u <= numNodes; u++ {
+		firstOut[u] = numEdges
 	}
 
 	// Step 5: Populate node coordinates.
-	nodeLat := make([]float64, numNodes)
-	nodeLon := make([]float64, numNodes)
-	for id, idx := range nodeSet {
-		nodeLat[idx] = result.NodeLat[id]
-		nodeLon[idx] = result.NodeLon[id]
+	nodeLat := make([]Coord, numNodes)
+	nodeLon := make([]Coord, numNodes)
+	parallelFor(len(nodeIDs), func(lo, hi int) {
+		for idx := lo; idx < hi; idx++ {
+			lat, lon, _ := result.NodeCoord(nodeIDs[idx])
+			nodeLat[idx], nodeLon[idx] = ToCoord(lat), ToCoord(lon)
+		}
+	})
+
+	// Step 6: Carry the interned road names over; the parser guarantees index
+	// 0 is the unnamed entry, so a hand-built result without names gets one.
+	names := []RoadName{{}}
+	if len(result.Names) > 0 {
+		names = make([]RoadName, len(result.Names))
+		for i, n := range result.Names {
+			names[i] = RoadName(n)
+		}
 	}
 
-	return &Graph{
+	g := &Graph{
 		NumNodes:       numNodes,
 		NumEdges:       numEdges,
 		FirstOut:       firstOut,
@@ -117,5 +160,146 @@ func Build(result *osmparser.ParseResult) *Graph {
 		GeoFirstOut:    geoFirstOut,
 		GeoShapeLat:    geoShapeLat,
 		GeoShapeLon:    geoShapeLon,
+		EdgeName:       edgeName,
+		Names:          names,
+		EdgeAttr:       edgeAttrs,
+		EdgeLimits:     edgeLimits,
+		NodeOSMID:      nodeIDs,
+		EdgeWayID:      edgeWayID,
 	}
+	g.TurnRestrictions = buildTurnRestrictions(result.Restrictions, nodeIndex, g)
+	return g
+}
+
+// nodeShards is the number of shards of a nodeIndex, each filled by its own
+// goroutine.
+const nodeShards = 64
+
+// nodeIndex maps OSM node IDs to node indexes, sharded by ID.
+type nodeIndex [nodeShards]map[osm.NodeID]uint32
+
+func nodeShard(id osm.NodeID) int {
+	return int(uint64(id) * 0x9E3779B97F4A7C15 >> 58) // Fibonacci hashing to 6 bits
+}
+
+func (ni *nodeIndex) get(id osm.NodeID) (uint32, bool) {
+	idx, ok := ni[nodeShard(id)][id]
+	return idx, ok
+}
+
+// numberNodes numbers the endpoints of edges in order of first appearance
+// and returns the mapping with the IDs by index. Endpoint occurrences are
+// numbered by position (edge*2 for the source, edge*2+1 for the target):
+// each shard records the first position of its IDs, and a node's index is
+// the number of first positions before its own.
+func numberNodes(edges []osmparser.RawEdge) (*nodeIndex, []osm.NodeID) {
+	endpoint := func(pos int) osm.NodeID {
+		if pos%2 == 0 {
+			return edges[pos/2].FromNodeID
+		}
+		return edges[pos/2].ToNodeID
+	}
+	numPos := 2 * len(edges)
+
+	// Bucket the occurrences by shard, per range of positions.
+	type occurrence struct {
+		id  osm.NodeID
+		pos uint32
+	}
+	bounds := chunkBounds(numPos)
+	buckets := make([][nodeShards][]occurrence, len(bounds)-1)
+	forChunks(bounds, func(c, lo, hi int) {
+		for pos := lo; pos < hi; pos++ {
+			id := endpoint(pos)
+			s := nodeShard(id)
+			buckets[c][s] = append(buckets[c][s], occurrence{id, uint32(pos)})
+		}
+	})
+
+	// Record each ID's first position, shard by shard.
+	first := make([]bool, numPos)
+	ni := new(nodeIndex)
+	var wg sync.WaitGroup
+	for s := range nodeShards {
+		wg.Go(func() {
+			m := make(map[osm.NodeID]uint32)
+			for c := range buckets {
+				for _, o := range buckets[c][s] {
+					if _, ok := m[o.id]; !ok {
+						m[o.id] = o.pos
+						first[o.pos] = true
+					}
+				}
+			}
+			ni[s] = m
+		})
+	}
+	wg.Wait()
+
+	// Rank the first positions: count per range, then number within each
+	// from the range's offset.
+	offset := make([]uint32, len(bounds))
+	forChunks(bounds, func(c, lo, hi int) {
+		for _, f := range first[lo:hi] {
+			if f {
+				offset[c+1]++
+			}
+		}
+	})
+	for c := 1; c < len(offset); c++ {
+		offset[c] += offset[c-1]
+	}
+	idxAt := make([]uint32, numPos)
+	nodeIDs := make([]osm.NodeID, offset[len(offset)-1])
+	forChunks(bounds, func(c, lo, hi int) {
+		idx := offset[c]
+		for pos := lo; pos < hi; pos++ {
+			if first[pos] {
+				idxAt[pos] = idx
+				nodeIDs[idx] = endpoint(pos)
+				idx++
+			}
+		}
+	})
+
+	// Replace the first positions in the shards by the indexes.
+	for s := range nodeShards {
+		wg.Go(func() {
+			for id, pos := range ni[s] {
+				ni[s][id] = idxAt[pos]
+			}
+		})
+	}
+	wg.Wait()
+	return ni, nodeIDs
+}
+
+// edgeAttr derives the serialized attributes of a parsed edge.
+func edgeAttr(e osmparser.RawEdge) EdgeAttr {
+	a := EdgeAttr{Class: e.Class, SpeedKmh: uint16(min(math.Round(e.SpeedKmh), math.MaxUint16))}
+	if e.Roundabout {
+		a.Flags |= FlagRoundabout
+	}
+	if e.Restricted {
+		a.Flags |= FlagRestricted
+	}
+	for _, m := range wayFlagMap {
+		if e.Flags.Has(m.way) {
+			a.Flags |= m.edge
+		}
+	}
+	return a
+}
+
+// wayFlagMap maps the parser's way flags to edge flags.
+var wayFlagMap = [...]struct {
+	way  osmparser.WayFlags
+	edge EdgeFlags
+}{
+	{osmparser.WayOneway, FlagOneway},
+	{osmparser.WayBridge, FlagBridge},
+	{osmparser.WayTunnel, FlagTunnel},
+	{osmparser.WayToll, FlagToll},
+	{osmparser.WayFerry, FlagFerry},
+	{osmparser.WayUnpaved, FlagUnpaved},
 }
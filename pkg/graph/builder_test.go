@@ -119,10 +119,10 @@ func TestBuildCarriesRestrictedFlag(t *testing.T) {
 	for u := uint32(0); u < g.NumNodes; u++ {
 		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
 			from, to := u, g.Head[e]
-			if g.NodeLon[from] == 103.81 && g.NodeLon[to] == 103.82 && !g.EdgeRestricted[e] {
+			if g.NodeLon[from].Deg() == 103.81 && g.NodeLon[to].Deg() == 103.82 && !g.EdgeRestricted[e] {
 				t.Error("edge 2->3 should be restricted")
 			}
-			if g.NodeLon[from] == 103.80 && g.NodeLon[to] == 103.81 && g.EdgeRestricted[e] {
+			if g.NodeLon[from].Deg() == 103.80 && g.NodeLon[to].Deg() == 103.81 && g.EdgeRestricted[e] {
 				t.Error("edge 1->2 should not be restricted")
 			}
 		}
@@ -170,3 +170,117 @@ func TestBuildCSRInvariants(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildCarriesRoadNames(t *testing.T) {
+	pr := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 3, ToNodeID: 2, Weight: 100, Name: 2},
+			{FromNodeID: 1, ToNodeID: 2, Weight: 100, Name: 1},
+			{FromNodeID: 2, ToNodeID: 1, Weight: 100, Name: 1},
+		},
+		NodeLat: map[osm.NodeID]float64{1: 1.30, 2: 1.30, 3: 1.30},
+		NodeLon: map[osm.NodeID]float64{1: 103.80, 2: 103.81, 3: 103.82},
+		Names:   []osmparser.RoadName{{}, {Name: "Jalan Satu"}, {Ref: "AH2"}},
+	}
+	g := Build(pr)
+	if uint32(len(g.EdgeName)) != g.NumEdges {
+		t.Fatalf("EdgeName len %d != NumEdges %d", len(g.EdgeName), g.NumEdges)
+	}
+	for u := uint32(0); u < g.NumNodes; u++ {
+		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+			want := RoadName{Name: "Jalan Satu"}
+			if g.NodeLon[u].Deg() == 103.82 {
+				want = RoadName{Ref: "AH2"}
+			}
+			if got := g.EdgeRoadName(e); got != want {
+				t.Errorf("edge %d (from lon %v) name = %+v, want %+v", e, g.NodeLon[u], got, want)
+			}
+		}
+	}
+
+	// Names survive component filtering alongside their edges.
+	filtered := FilterToComponent(g, LargestComponent(g))
+	for e := uint32(0); e < filtered.NumEdges; e++ {
+		if got := filtered.EdgeRoadName(e); got.Name != "Jalan Satu" {
+			t.Errorf("filtered edge %d name = %+v, want Jalan Satu", e, got)
+		}
+	}
+}
+
+func TestBuildCarriesVehicleLimits(t *testing.T) {
+	low := osmparser.VehicleLimits{MaxHeight: 3.2}
+	pr := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 1, ToNodeID: 2, Weight: 100, Limits: low},
+			{FromNodeID: 2, ToNodeID: 1, Weight: 100, Limits: low},
+			{FromNodeID: 2, ToNodeID: 3, Weight: 100},
+			{FromNodeID: 3, ToNodeID: 2, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{1: 1.30, 2: 1.30, 3: 1.30},
+		NodeLon: map[osm.NodeID]float64{1: 103.80, 2: 103.81, 3: 103.82},
+	}
+	check := func(label string, g *Graph) {
+		t.Helper()
+		if uint32(len(g.EdgeLimits)) != g.NumEdges {
+			t.Fatalf("%s: EdgeLimits len %d != NumEdges %d", label, len(g.EdgeLimits), g.NumEdges)
+		}
+		for u := uint32(0); u < g.NumNodes; u++ {
+			for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+				touches1 := g.NodeLon[u].Deg() == 103.80 || g.NodeLon[g.Head[e]].Deg() == 103.80
+				if got := g.EdgeAdmits(e, 0, 4.0, 0, 0); got == touches1 {
+					t.Errorf("%s: edge %d admits a 4 m truck = %v, want %v", label, e, got, !touches1)
+				}
+			}
+		}
+	}
+	g := Build(pr)
+	check("built", g)
+	check("filtered", FilterToComponent(g, LargestComponent(g)))
+
+	// Without limits no array is allocated and everything is admitted.
+	pr.Edges[0].Limits, pr.Edges[1].Limits = osmparser.VehicleLimits{}, osmparser.VehicleLimits{}
+	if g := Build(pr); g.EdgeLimits != nil || !g.EdgeAdmits(0, 40, 4.5, 2.6, 18) {
+		t.Error("a graph without limits should carry no EdgeLimits and admit every vehicle")
+	}
+}
+
+func TestBuildCarriesOSMProvenance(t *testing.T) {
+	pr := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 30, ToNodeID: 20, Weight: 100, WayID: 8},
+			{FromNodeID: 20, ToNodeID: 30, Weight: 100, WayID: 8},
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100, WayID: 7},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100, WayID: 7},
+			{FromNodeID: 40, ToNodeID: 50, Weight: 100, WayID: 9}, // separate island
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.30, 20: 1.30, 30: 1.30, 40: 1.31, 50: 1.31},
+		NodeLon: map[osm.NodeID]float64{10: 103.80, 20: 103.81, 30: 103.82, 40: 103.80, 50: 103.81},
+	}
+	check := func(label string, g *Graph) {
+		t.Helper()
+		if uint32(len(g.NodeOSMID)) != g.NumNodes || uint32(len(g.EdgeWayID)) != g.NumEdges {
+			t.Fatalf("%s: %d node IDs for %d nodes, %d way IDs for %d edges", label, len(g.NodeOSMID), g.NumNodes, len(g.EdgeWayID), g.NumEdges)
+		}
+		for u := uint32(0); u < g.NumNodes; u++ {
+			if lat, _, _ := pr.NodeCoord(g.NodeOSMID[u]); lat != g.NodeLat[u].Deg() {
+				t.Errorf("%s: node %d maps to OSM node %d at the wrong place", label, u, g.NodeOSMID[u])
+			}
+			for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+				from, to := g.NodeOSMID[u], g.NodeOSMID[g.Head[e]]
+				want := osm.WayID(7)
+				if from == 30 || to == 30 {
+					want = 8
+				}
+				if g.EdgeWayID[e] != want {
+					t.Errorf("%s: edge %d->%d way = %d, want %d", label, from, to, g.EdgeWayID[e], want)
+				}
+			}
+		}
+	}
+	g := Build(pr)
+	filtered := FilterToComponent(g, LargestComponent(g))
+	if filtered.NumNodes != 3 {
+		t.Fatalf("filtered graph has %d nodes, want 3", filtered.NumNodes)
+	}
+	check("filtered", filtered)
+}
@@ -0,0 +1,328 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+)
+
+// Multi-profile bundles.
+//
+// A bundle holds the CH graphs of several profiles (car, bike, foot, ...) in
+// one file. The profiles cover mostly the same roads, so their node
+// coordinates and shape points are stored once, in a shared point table, and
+// each profile refers to points by index:
+//
+//	[bundleHeader]
+//	[PointLat [NumPoints]Coord][PointLon [NumPoints]Coord]
+//	[profile section]...   one combined graph per profile (flagPointRefs)
+//	[TOC [NumProfiles]bundleEntry][uint32 CRC32]
+//
+// A profile section is a complete combined graph, CRC32 trailer and metadata
+// footer included, whose NodeLat/NodeLon are replaced by one point reference
+// per node and whose GeoShapeLat/Lon by one per shape point. The trailing CRC32
+// covers the point table, the TOC and then the header as finally written. Readers
+// seek straight to the profiles they are asked for and never read the others.
+const (
+	bundleMagic   = "MPRBUNDL"
+	bundleVersion = uint32(1)
+	// maxProfileName is the size of a TOC name field.
+	maxProfileName = 16
+	maxPoints      = maxNodes * 8 // nodes plus shape points, across profiles
+)
+
+// bundleHeader is the header of a bundle file. TOCOffset is filled in once
+// the profile sections are written.
+type bundleHeader struct {
+	Magic       [8]byte
+	Version     uint32
+	NumProfiles uint32
+	NumPoints   uint32
+	TOCOffset   uint64
+}
+
+// bundleEntry locates one profile section.
+type bundleEntry struct {
+	Name   [maxProfileName]byte
+	Offset uint64
+	Length uint64
+}
+
+func (e *bundleEntry) name() string {
+	return string(bytes.TrimRight(e.Name[:], "\x00"))
+}
+
+// pointIndex interns coordinates into a bundle's shared point table.
+type pointIndex struct {
+	ids      map[[2]Coord]uint32
+	lat, lon []Coord
+}
+
+func newPointIndex() *pointIndex {
+	return &pointIndex{ids: make(map[[2]Coord]uint32)}
+}
+
+func (p *pointIndex) add(lat, lon []Coord) {
+	for i := range lat {
+		k := [2]Coord{lat[i], lon[i]}
+		if _, ok := p.ids[k]; !ok {
+			p.ids[k] = uint32(len(p.lat))
+			p.lat = append(p.lat, lat[i])
+			p.lon = append(p.lon, lon[i])
+		}
+	}
+}
+
+// writeRefs writes the point index of each coordinate pair, all of which must
+// have been added, in chunks so no slice of them all is built.
+func (p *pointIndex) writeRefs(w io.Writer, lat, lon []Coord) error {
+	var buf [1024]uint32
+	for len(lat) > 0 {
+		n := min(len(lat), len(buf))
+		for i := range n {
+			buf[i] = p.ids[[2]Coord{lat[i], lon[i]}]
+		}
+		if err := writeUint32Slice(w, buf[:n]); err != nil {
+			return err
+		}
+		lat, lon = lat[n:], lon[n:]
+	}
+	return nil
+}
+
+// pointTable is a bundle's shared point table on load.
+type pointTable struct {
+	lat, lon []Coord
+}
+
+// resolve turns point references back into coordinates.
+func (p *pointTable) resolve(refs []uint32) (lat, lon []Coord, err error) {
+	if refs == nil {
+		return nil, nil, nil
+	}
+	lat, lon = make([]Coord, len(refs)), make([]Coord, len(refs))
+	for i, r := range refs {
+		if int(r) >= len(p.lat) {
+			return nil, nil, fmt.Errorf("point %d out of range (%d points)", r, len(p.lat))
+		}
+		lat[i], lon[i] = p.lat[r], p.lon[r]
+	}
+	return lat, lon, nil
+}
+
+// WriteBundle writes the graphs of several profiles, keyed by profile name,
+// into one bundle file. Node coordinates and shape points shared between the
+// profiles are stored once. opt applies to every profile section
+// (Float64Coords is ignored: a bundle always stores Coords).
+func WriteBundle(path string, graphs map[string]*CHGraph, opt WriteOptions) error {
+	if len(graphs) == 0 {
+		return fmt.Errorf("bundle needs at least one profile")
+	}
+	names := make([]string, 0, len(graphs))
+	for name := range graphs {
+		if name == "" || len(name) > maxProfileName {
+			return fmt.Errorf("profile name %q must be 1-%d bytes", name, maxProfileName)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pts := newPointIndex()
+	for _, name := range names {
+		chg := graphs[name]
+		pts.add(chg.NodeLat, chg.NodeLon)
+		pts.add(chg.GeoShapeLat, chg.GeoShapeLon)
+	}
+	if len(pts.lat) > maxPoints {
+		return fmt.Errorf("%d distinct points exceed limit %d", len(pts.lat), maxPoints)
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(tmpPath) // clean up on error
+	}()
+
+	hdr := bundleHeader{Version: bundleVersion, NumProfiles: uint32(len(names)), NumPoints: uint32(len(pts.lat))}
+	copy(hdr.Magic[:], bundleMagic)
+	if err := binary.Write(f, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	crc := crc32.NewIEEE()
+	for _, s := range [][]Coord{pts.lat, pts.lon} {
+		if err := writeCoordSlice(io.MultiWriter(f, crc), s); err != nil {
+			return fmt.Errorf("write points: %w", err)
+		}
+	}
+
+	toc := make([]bundleEntry, len(names))
+	for i, name := range names {
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if err := writeBinary(f, graphs[name], opt, pts); err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		end, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		copy(toc[i].Name[:], name)
+		toc[i].Offset, toc[i].Length = uint64(start), uint64(end-start)
+	}
+
+	tocOffset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	hdr.TOCOffset = uint64(tocOffset)
+	var hdrBuf bytes.Buffer
+	_ = binary.Write(&hdrBuf, binary.LittleEndian, &hdr)
+	if _, err := f.WriteAt(hdrBuf.Bytes(), 0); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := binary.Write(io.MultiWriter(f, crc), binary.LittleEndian, toc); err != nil {
+		return fmt.Errorf("write TOC: %w", err)
+	}
+	// The header is hashed last: TOCOffset is only known now.
+	crc.Write(hdrBuf.Bytes())
+	if err := binary.Write(f, binary.LittleEndian, crc.Sum32()); err != nil {
+		return fmt.Errorf("write CRC32: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// IsBundle reports whether the file at path is a multi-profile bundle.
+func IsBundle(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	var magic [8]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false, nil
+	}
+	return string(magic[:]) == bundleMagic, nil
+}
+
+// BundleProfiles returns the profile names in a bundle, sorted.
+func BundleProfiles(path string) ([]string, error) {
+	f, src, err := openSection(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, toc, err := readBundleIndex(src)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(toc))
+	for i := range toc {
+		names[i] = toc[i].name()
+	}
+	return names, nil
+}
+
+// ReadBundle reads the named profiles from a bundle, or all of them when none
+// are named. Sections of other profiles are not read.
+func ReadBundle(path string, profiles ...string) (map[string]*CHGraph, error) {
+	f, src, err := openSection(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pts, toc, err := readBundleIndex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	want, missing := make(map[string]bool, len(profiles)), make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		want[p], missing[p] = true, true
+	}
+	graphs := make(map[string]*CHGraph)
+	for i := range toc {
+		e := &toc[i]
+		name := e.name()
+		if len(want) > 0 && !want[name] {
+			continue
+		}
+		if e.Offset+e.Length > uint64(src.Size()) {
+			return nil, fmt.Errorf("profile %s: section out of range", name)
+		}
+		chg, err := readBinary(io.NewSectionReader(src, int64(e.Offset), int64(e.Length)), pts, ReadOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		graphs[name] = chg
+		delete(missing, name)
+	}
+	for name := range missing {
+		return nil, fmt.Errorf("profile %q not in bundle", name)
+	}
+	return graphs, nil
+}
+
+// readBundleIndex reads and verifies a bundle's header, point table and TOC.
+func readBundleIndex(src *io.SectionReader) (*pointTable, []bundleEntry, error) {
+	var hdr bundleHeader
+	if err := binary.Read(src, binary.LittleEndian, &hdr); err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(hdr.Magic[:]) != bundleMagic {
+		return nil, nil, fmt.Errorf("invalid bundle magic bytes: %q", hdr.Magic)
+	}
+	if hdr.Version != bundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version: %d", hdr.Version)
+	}
+	if hdr.NumPoints > maxPoints {
+		return nil, nil, fmt.Errorf("NumPoints %d exceeds limit %d", hdr.NumPoints, maxPoints)
+	}
+	tocSize := uint64(hdr.NumProfiles) * uint64(binary.Size(bundleEntry{}))
+	if hdr.TOCOffset+tocSize+4 != uint64(src.Size()) {
+		return nil, nil, fmt.Errorf("TOC of %d profiles at %d does not end the file", hdr.NumProfiles, hdr.TOCOffset)
+	}
+
+	crc := crc32.NewIEEE()
+	r := io.TeeReader(src, crc)
+	pts := &pointTable{}
+	var err error
+	if pts.lat, err = readCoordSlice(r, int(hdr.NumPoints)); err != nil {
+		return nil, nil, fmt.Errorf("read points: %w", err)
+	}
+	if pts.lon, err = readCoordSlice(r, int(hdr.NumPoints)); err != nil {
+		return nil, nil, fmt.Errorf("read points: %w", err)
+	}
+
+	tail := io.NewSectionReader(src, int64(hdr.TOCOffset), int64(tocSize)+4)
+	toc := make([]bundleEntry, hdr.NumProfiles)
+	if err := binary.Read(io.TeeReader(tail, crc), binary.LittleEndian, toc); err != nil {
+		return nil, nil, fmt.Errorf("read TOC: %w", err)
+	}
+	var stored uint32
+	if err := binary.Read(tail, binary.LittleEndian, &stored); err != nil {
+		return nil, nil, fmt.Errorf("read CRC32: %w", err)
+	}
+	_ = binary.Write(crc, binary.LittleEndian, &hdr)
+	if got := crc.Sum32(); got != stored {
+		return nil, nil, fmt.Errorf("CRC32 mismatch: stored=%08x computed=%08x", stored, got)
+	}
+	return pts, toc, nil
+}
@@ -0,0 +1,143 @@
+package graph_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+// buildFootCH is buildTestCH's network plus a footpath to a fifth node, with
+// a shape point, standing in for another profile over the same roads.
+func buildFootCH(t *testing.T) *graph.CHGraph {
+	t.Helper()
+	result := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 1000},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 1000},
+			{FromNodeID: 20, ToNodeID: 30, Weight: 2000},
+			{FromNodeID: 30, ToNodeID: 20, Weight: 2000},
+			{FromNodeID: 10, ToNodeID: 40, Weight: 3000},
+			{FromNodeID: 40, ToNodeID: 10, Weight: 3000},
+			{FromNodeID: 30, ToNodeID: 50, Weight: 500, ShapeLats: []float64{1.25}, ShapeLons: []float64{103.25}},
+			{FromNodeID: 50, ToNodeID: 30, Weight: 500, ShapeLats: []float64{1.25}, ShapeLons: []float64{103.25}},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.0, 20: 1.1, 30: 1.2, 40: 1.3, 50: 1.3},
+		NodeLon: map[osm.NodeID]float64{10: 103.0, 20: 103.1, 30: 103.2, 40: 103.3, 50: 103.2},
+	}
+	return ch.Contract(graph.Build(result))
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	car, foot := buildTestCH(t), buildFootCH(t)
+	foot.Meta = &graph.Metadata{Profiles: []string{"foot"}}
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "graph.bin")
+	if err := graph.WriteBundle(bundle, map[string]*graph.CHGraph{"car": car, "foot": foot}, graph.WriteOptions{}); err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+
+	names, err := graph.BundleProfiles(bundle)
+	if err != nil {
+		t.Fatalf("BundleProfiles: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"car", "foot"}) {
+		t.Errorf("profiles = %v, want [car foot]", names)
+	}
+
+	// A profile read from the bundle matches the same graph read standalone.
+	single := filepath.Join(dir, "foot.bin")
+	if err := graph.WriteBinary(single, foot); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	want, err := graph.ReadBinary(single)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	got, err := graph.ReadBundle(bundle, "foot")
+	if err != nil {
+		t.Fatalf("ReadBundle: %v", err)
+	}
+	if len(got) != 1 || got["foot"] == nil {
+		t.Fatalf("ReadBundle(foot) returned profiles %v", reflect.ValueOf(got).MapKeys())
+	}
+	if !reflect.DeepEqual(got["foot"].Meta.Profiles, []string{"foot"}) {
+		t.Errorf("foot metadata = %+v", got["foot"].Meta)
+	}
+	got["foot"].Meta, want.Meta = nil, nil
+	if !reflect.DeepEqual(got["foot"], want) {
+		t.Error("foot graph read from the bundle differs from the standalone file")
+	}
+
+	all, err := graph.ReadBundle(bundle)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("ReadBundle(all) = %d profiles, %v", len(all), err)
+	}
+	if _, err := graph.ReadBundle(bundle, "bike"); err == nil || !strings.Contains(err.Error(), "bike") {
+		t.Errorf("ReadBundle(bike) error = %v, want a missing-profile error", err)
+	}
+	if _, err := graph.ReadBinary(bundle); err == nil || !strings.Contains(err.Error(), "ReadBundle") {
+		t.Errorf("ReadBinary(bundle) error = %v, want a pointer to ReadBundle", err)
+	}
+	if ok, err := graph.IsBundle(bundle); !ok || err != nil {
+		t.Errorf("IsBundle(bundle) = %v, %v", ok, err)
+	}
+	if ok, _ := graph.IsBundle(single); ok {
+		t.Error("IsBundle(standalone graph) = true")
+	}
+}
+
+func TestBundleSharesPoints(t *testing.T) {
+	car, foot := buildTestCH(t), buildFootCH(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "graph.bin")
+	if err := graph.WriteBundle(bundle, map[string]*graph.CHGraph{"car": car, "foot": foot}, graph.WriteOptions{}); err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+	var separate int64
+	for name, chg := range map[string]*graph.CHGraph{"car": car, "foot": foot} {
+		path := filepath.Join(dir, name+".bin")
+		if err := graph.WriteBinary(path, chg); err != nil {
+			t.Fatalf("WriteBinary: %v", err)
+		}
+		fi, _ := os.Stat(path)
+		separate += fi.Size()
+	}
+	// Six distinct points (five nodes, one shape point) are stored once as
+	// 8-byte pairs; each profile keeps a 4-byte reference per node or shape
+	// point instead of 8 bytes of coordinates, and one shape length prefix
+	// instead of two. The bundle adds a 28-byte header and a 32-byte TOC
+	// entry per profile plus its CRC32. (Graphs this small come out larger;
+	// country-sized ones share most of their points.)
+	fi, _ := os.Stat(bundle)
+	refs := int64(car.NumNodes+foot.NumNodes) + int64(len(foot.GeoShapeLat))
+	want := separate - refs*4 - 2*4 + 6*8 + 28 + 2*32 + 4
+	if fi.Size() != want {
+		t.Errorf("bundle is %d bytes, want %d (separate files: %d)", fi.Size(), want, separate)
+	}
+}
+
+func TestBundleCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBundle(path, map[string]*graph.CHGraph{"car": buildTestCH(t)}, graph.WriteOptions{}); err != nil {
+		t.Fatalf("WriteBundle: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[30] ^= 0xff // inside the point table
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadBundle(path); err == nil {
+		t.Error("ReadBundle accepted a corrupted point table")
+	}
+}
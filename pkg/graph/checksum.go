@@ -0,0 +1,162 @@
+package graph
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Per-section checksums (header flag flagSectionCRCs).
+//
+// Files written with the flag carry a table of CRC32s right after the flags
+// word, one per section array:
+//
+//	[uint32 numSections][numSections]uint32
+//
+// Each CRC covers an array as it is held in memory once loaded (Coords for
+// coordinates, resolved points in a bundle), not its bytes on disk, so the
+// table is independent of compression and coordinate encoding and can be
+// checked at any time after the load. ReadBinaryWith with FastLoad skips the
+// whole-file CRC32 pass for such files, and VerifySections checks the loaded
+// graph afterwards, e.g. in the background once a server is up.
+
+// maxSections bounds the checksum table on load.
+const maxSections = 64
+
+// checksumSection is one array covered by the checksum table; write feeds its
+// contents to a hash.
+type checksumSection struct {
+	name  string
+	write func(w io.Writer) error
+}
+
+// checksumSections lists chg's sections in file order. Rank is left out: it
+// is not kept on load. The attribute sections are empty for graphs without
+// edge attributes; the turn restriction section is listed only for graphs
+// with restrictions, the OSM ID sections only with osmIDs and the snap index
+// and vehicle limits only for graphs with them, so tables written before
+// they existed still match.
+func checksumSections(chg *CHGraph, osmIDs bool) []checksumSection {
+	u32 := func(s []uint32) func(io.Writer) error {
+		return func(w io.Writer) error { return writeUint32Slice(w, s) }
+	}
+	i32 := func(s []int32) func(io.Writer) error {
+		return func(w io.Writer) error { return writeInt32Slice(w, s) }
+	}
+	coords := func(s []Coord) func(io.Writer) error {
+		return func(w io.Writer) error { return writeCoordSlice(w, s) }
+	}
+	attrs := len(chg.EdgeAttr) > 0
+	secs := []checksumSection{
+		{"NodeLat", coords(chg.NodeLat)},
+		{"NodeLon", coords(chg.NodeLon)},
+		{"FwdFirstOut", u32(chg.FwdFirstOut)},
+		{"FwdHead", u32(chg.FwdHead)},
+		{"FwdWeight", u32(chg.FwdWeight)},
+		{"FwdMiddle", i32(chg.FwdMiddle)},
+		{"BwdFirstOut", u32(chg.BwdFirstOut)},
+		{"BwdHead", u32(chg.BwdHead)},
+		{"BwdWeight", u32(chg.BwdWeight)},
+		{"BwdMiddle", i32(chg.BwdMiddle)},
+		{"OrigFirstOut", u32(chg.OrigFirstOut)},
+		{"OrigHead", u32(chg.OrigHead)},
+		{"OrigWeight", u32(chg.OrigWeight)},
+		{"GeoFirstOut", u32(chg.GeoFirstOut)},
+		{"GeoShapeLat", coords(chg.GeoShapeLat)},
+		{"GeoShapeLon", coords(chg.GeoShapeLon)},
+		{"Names", func(w io.Writer) error {
+			if !attrs {
+				return nil
+			}
+			return writeNames(w, chg.Names)
+		}},
+		{"EdgeName", func(w io.Writer) error {
+			switch {
+			case !attrs:
+				return nil
+			case chg.EdgeName == nil: // written as zeros
+				return writeZeros(w, int64(len(chg.OrigHead))*4)
+			}
+			return writeUint32Slice(w, chg.EdgeName)
+		}},
+		{"EdgeAttr", func(w io.Writer) error { return writeEdgeAttrs(w, chg.EdgeAttr) }},
+	}
+	if len(chg.TurnRestrictions) > 0 {
+		secs = append(secs, checksumSection{"TurnRestrictions", func(w io.Writer) error {
+			return writeTurnSection(w, chg.TurnRestrictions)
+		}})
+	}
+	if osmIDs {
+		secs = append(secs,
+			checksumSection{"NodeOSMID", func(w io.Writer) error {
+				if chg.NodeOSMID == nil {
+					return writeZeros(w, int64(chg.NumNodes)*8)
+				}
+				return writeInt64s(w, chg.NodeOSMID)
+			}},
+			checksumSection{"EdgeWayID", func(w io.Writer) error {
+				if chg.EdgeWayID == nil {
+					return writeZeros(w, int64(len(chg.OrigHead))*8)
+				}
+				return writeInt64s(w, chg.EdgeWayID)
+			}},
+		)
+	}
+	if chg.SnapIndex != nil {
+		secs = append(secs, checksumSection{"SnapIndex", func(w io.Writer) error {
+			return writeSnapIndexSection(w, chg.SnapIndex)
+		}})
+	}
+	if chg.EdgeLimits != nil {
+		secs = append(secs, checksumSection{"EdgeLimits", func(w io.Writer) error {
+			return writeLimitsSection(w, chg.EdgeLimits)
+		}})
+	}
+	return secs
+}
+
+// sectionCRCs computes the checksum table of chg.
+func sectionCRCs(chg *CHGraph, osmIDs bool) ([]uint32, error) {
+	secs := checksumSections(chg, osmIDs)
+	crcs := make([]uint32, len(secs))
+	for i, s := range secs {
+		h := crc32.NewIEEE()
+		if err := s.write(h); err != nil {
+			return nil, fmt.Errorf("checksum %s: %w", s.name, err)
+		}
+		crcs[i] = h.Sum32()
+	}
+	return crcs, nil
+}
+
+// VerifySections checks the graph against the per-section checksums of the
+// file it was read from and names the first section that does not match.
+// Graphs read from files without the table, and graphs never read from a
+// file, have nothing to check and always pass.
+func (chg *CHGraph) VerifySections() error {
+	if chg.sectionCRCs == nil {
+		return nil
+	}
+	// A graph read from a file carries OSM IDs exactly when the file did.
+	osmIDs := chg.NodeOSMID != nil
+	got, err := sectionCRCs(chg, osmIDs)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(chg.sectionCRCs) {
+		return fmt.Errorf("checksum table has %d sections, want %d", len(chg.sectionCRCs), len(got))
+	}
+	names := checksumSections(chg, osmIDs)
+	for i := range got {
+		if got[i] != chg.sectionCRCs[i] {
+			return fmt.Errorf("section %s: CRC32 mismatch: stored=%08x computed=%08x", names[i].name, chg.sectionCRCs[i], got[i])
+		}
+	}
+	return nil
+}
+
+// nopHash stands in for the whole-file CRC32 when FastLoad skips it.
+type nopHash struct{}
+
+func (nopHash) Write(p []byte) (int, error) { return len(p), nil }
+func (nopHash) Sum32() uint32               { return 0 }
@@ -0,0 +1,77 @@
+package graph_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestFastLoad(t *testing.T) {
+	original := buildTestCH(t)
+	original.Meta = nil // the file then ends with the CRC32 trailer
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBinary(path, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+
+	full, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	fast, err := graph.ReadBinaryWith(path, graph.ReadOptions{FastLoad: true})
+	if err != nil {
+		t.Fatalf("ReadBinaryWith(FastLoad): %v", err)
+	}
+	if !reflect.DeepEqual(fast, full) {
+		t.Error("fast load differs from a full load")
+	}
+	if err := fast.VerifySections(); err != nil {
+		t.Errorf("VerifySections: %v", err)
+	}
+
+	// Damage the last byte of the last section, the edge attributes.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadBinary(path); err == nil || !strings.Contains(err.Error(), "CRC32 mismatch") {
+		t.Errorf("ReadBinary of a damaged file: err = %v, want CRC32 mismatch", err)
+	}
+	fast, err = graph.ReadBinaryWith(path, graph.ReadOptions{FastLoad: true})
+	if err != nil {
+		t.Fatalf("ReadBinaryWith(FastLoad) of a damaged file: %v", err)
+	}
+	if err := fast.VerifySections(); err == nil || !strings.Contains(err.Error(), "EdgeAttr") {
+		t.Errorf("VerifySections: err = %v, want an EdgeAttr mismatch", err)
+	}
+}
+
+// TestFastLoadWithoutChecksums checks that files without a checksum table are
+// still checked in full.
+func TestFastLoadWithoutChecksums(t *testing.T) {
+	original := buildTestCH(t)
+	original.Meta, original.EdgeAttr = nil, nil
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBinaryWith(path, original, graph.WriteOptions{Float64Coords: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadBinaryWith(path, graph.ReadOptions{FastLoad: true}); err == nil || !strings.Contains(err.Error(), "CRC32 mismatch") {
+		t.Errorf("err = %v, want CRC32 mismatch", err)
+	}
+}
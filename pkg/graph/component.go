@@ -1,5 +1,11 @@
 package graph
 
+import (
+	"cmp"
+	"slices"
+	"sync/atomic"
+)
+
 // UnionFind implements a disjoint-set data structure with path compression
 // and union by rank.
 type UnionFind struct {
@@ -52,6 +58,70 @@ func (uf *UnionFind) Union(x, y uint32) bool {
 	return true
 }
 
+// UnionEdges unions the endpoints of every edge e of g for which keep(e)
+// holds. On large graphs the unions run in parallel, linking roots with
+// compare-and-swap; afterwards set sizes are recounted, and ranks, which the
+// concurrent links ignore, only weaken the balancing of later Unions.
+func (uf *UnionFind) UnionEdges(g *Graph, keep func(e uint32) bool) {
+	if len(chunkBounds(int(g.NumNodes))) == 2 {
+		for u := range g.NumNodes {
+			for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+				if keep(e) {
+					uf.Union(u, g.Head[e])
+				}
+			}
+		}
+		return
+	}
+	parallelFor(int(g.NumNodes), func(lo, hi int) {
+		for u := uint32(lo); u < uint32(hi); u++ {
+			for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+				if keep(e) {
+					uf.unionAtomic(u, g.Head[e])
+				}
+			}
+		}
+	})
+	clear(uf.size)
+	for x := range uint32(len(uf.parent)) {
+		uf.size[uf.Find(x)]++
+	}
+}
+
+// findAtomic is Find for concurrent use with unionAtomic.
+func (uf *UnionFind) findAtomic(x uint32) uint32 {
+	for {
+		p := atomic.LoadUint32(&uf.parent[x])
+		if p == x {
+			return x
+		}
+		gp := atomic.LoadUint32(&uf.parent[p])
+		if gp != p {
+			atomic.CompareAndSwapUint32(&uf.parent[x], p, gp) // path halving; losing the race is harmless
+		}
+		x = gp
+	}
+}
+
+// unionAtomic merges the sets of x and y, safely alongside other
+// unionAtomic calls. A root is only ever linked under a lower-numbered root,
+// so every parent is below its child and concurrent links cannot form a
+// cycle.
+func (uf *UnionFind) unionAtomic(x, y uint32) {
+	for {
+		rx, ry := uf.findAtomic(x), uf.findAtomic(y)
+		if rx == ry {
+			return
+		}
+		if rx < ry {
+			rx, ry = ry, rx
+		}
+		if atomic.CompareAndSwapUint32(&uf.parent[rx], rx, ry) {
+			return
+		}
+	}
+}
+
 // computeSCC labels every node with the id of its strongly connected component
 // (SCC) and returns the per-component node counts.
 //
@@ -152,35 +222,121 @@ func computeSCC(g *Graph) (comp []uint32, sizes []uint32) {
 	return comp, sizes
 }
 
-// LargestComponent returns the node indices belonging to the largest strongly
-// connected component of the directed graph, in ascending index order. This is
-// the right choice for a single contiguous road network (one landmass).
-func LargestComponent(g *Graph) []uint32 {
-	n := g.NumNodes
-	if n == 0 {
-		return nil
-	}
+// Components is the strongly connected component decomposition of a graph,
+// from StronglyConnected.
+type Components struct {
+	comp  []uint32 // component of each node
+	sizes []uint32 // node count of each component
+}
 
+// ComponentStats describes one strongly connected component.
+type ComponentStats struct {
+	Nodes uint32
+	BBox  BBox // extent of its nodes
+}
+
+// StronglyConnected computes g's strongly connected components.
+func StronglyConnected(g *Graph) *Components {
 	comp, sizes := computeSCC(g)
+	return &Components{comp: comp, sizes: sizes}
+}
+
+// Len returns the number of components.
+func (c *Components) Len() int { return len(c.sizes) }
 
+// Largest returns the node indices of the largest component, in ascending
+// index order; see LargestComponent.
+func (c *Components) Largest() []uint32 {
+	if len(c.sizes) == 0 {
+		return nil
+	}
 	// Find the largest SCC by node count.
 	best := uint32(0)
-	for c := uint32(1); c < uint32(len(sizes)); c++ {
-		if sizes[c] > sizes[best] {
-			best = c
+	for k := uint32(1); k < uint32(len(c.sizes)); k++ {
+		if c.sizes[k] > c.sizes[best] {
+			best = k
 		}
 	}
 
 	// Collect its nodes in ascending index order.
-	nodes := make([]uint32, 0, sizes[best])
-	for i := uint32(0); i < n; i++ {
-		if comp[i] == best {
-			nodes = append(nodes, i)
+	nodes := make([]uint32, 0, c.sizes[best])
+	for i, k := range c.comp {
+		if k == best {
+			nodes = append(nodes, uint32(i))
+		}
+	}
+	return nodes
+}
+
+// AtLeast returns the node indices of every component with at least
+// minNodes nodes, in ascending index order; see LargeComponents.
+func (c *Components) AtLeast(minNodes uint32) []uint32 {
+	keep := make([]bool, len(c.sizes))
+	var keptNodes int
+	for k, sz := range c.sizes {
+		if sz >= minNodes {
+			keep[k] = true
+			keptNodes += int(sz)
+		}
+	}
+
+	nodes := make([]uint32, 0, keptNodes)
+	for i, k := range c.comp {
+		if keep[k] {
+			nodes = append(nodes, uint32(i))
 		}
 	}
 	return nodes
 }
 
+// Top describes the n largest components, largest first; Top(g, 1)[0] is
+// the component Largest returns. g must be the graph c was computed from.
+func (c *Components) Top(g *Graph, n int) []ComponentStats {
+	order := make([]uint32, len(c.sizes))
+	for k := range order {
+		order[k] = uint32(k)
+	}
+	slices.SortStableFunc(order, func(a, b uint32) int { return cmp.Compare(c.sizes[b], c.sizes[a]) })
+	order = order[:min(n, len(order))]
+
+	const other = -1
+	rank := make([]int32, len(c.sizes))
+	for k := range rank {
+		rank[k] = other
+	}
+	stats := make([]ComponentStats, len(order))
+	for r, k := range order {
+		rank[k] = int32(r)
+		stats[r].Nodes = c.sizes[k]
+	}
+	seen := make([]bool, len(order))
+	for u, k := range c.comp {
+		r := rank[k]
+		if r == other {
+			continue
+		}
+		lat, lon := g.NodeLat[u].Deg(), g.NodeLon[u].Deg()
+		b := &stats[r].BBox
+		if !seen[r] {
+			*b, seen[r] = BBox{MinLat: lat, MinLon: lon, MaxLat: lat, MaxLon: lon}, true
+			continue
+		}
+		b.MinLat, b.MaxLat = min(b.MinLat, lat), max(b.MaxLat, lat)
+		b.MinLon, b.MaxLon = min(b.MinLon, lon), max(b.MaxLon, lon)
+	}
+	return stats
+}
+
+// LargestComponent returns the node indices belonging to the largest strongly
+// connected component of the directed graph, in ascending index order. This is
+// the right choice for a single contiguous road network (one landmass).
+func LargestComponent(g *Graph) []uint32 {
+	if g.NumNodes == 0 {
+		return nil
+	}
+	return StronglyConnected(g).Largest()
+}
+
 // LargeComponents returns the node indices of every strongly connected component
 // with at least minNodes nodes, in ascending index order. Unlike
 // LargestComponent it keeps multiple disconnected road networks, which is what a
@@ -194,129 +350,187 @@ func LargestComponent(g *Graph) []uint32 {
 // endpoints to different components correctly yields "no route found" (you can't
 // drive between disconnected road networks).
 func LargeComponents(g *Graph, minNodes uint32) []uint32 {
-	n := g.NumNodes
-	if n == 0 {
+	if g.NumNodes == 0 {
 		return nil
 	}
-
-	comp, sizes := computeSCC(g)
-
-	keep := make([]bool, len(sizes))
-	var keptNodes int
-	for c, sz := range sizes {
-		if sz >= minNodes {
-			keep[c] = true
-			keptNodes += int(sz)
-		}
-	}
-
-	nodes := make([]uint32, 0, keptNodes)
-	for i := uint32(0); i < n; i++ {
-		if keep[comp[i]] {
-			nodes = append(nodes, i)
-		}
-	}
-	return nodes
+	return StronglyConnected(g).AtLeast(minNodes)
 }
 
-// FilterToComponent creates a new graph containing only the specified nodes.
+// FilterToComponent creates a new graph containing only the specified nodes,
+// numbered in the order given. Large graphs are filtered in parallel. When
+// nodes is every node in order, as for a graph that is one component
+// already, the arrays are copied as they are instead of gathered. Either
+// way the result owns its arrays, so callers may rewrite its weights without
+// touching g; only the interned Names, which nothing writes, are shared.
 func FilterToComponent(g *Graph, nodes []uint32) *Graph {
 	if len(nodes) == 0 {
 		return &Graph{}
 	}
+	if isIdentity(nodes, g.NumNodes) {
+		// EdgeRestricted is dropped, as by a filtered copy.
+		h := &Graph{
+			NumNodes:    g.NumNodes,
+			NumEdges:    g.NumEdges,
+			FirstOut:    slices.Clone(g.FirstOut),
+			Head:        slices.Clone(g.Head),
+			Weight:      slices.Clone(g.Weight),
+			NodeLat:     slices.Clone(g.NodeLat),
+			NodeLon:     slices.Clone(g.NodeLon),
+			GeoFirstOut: slices.Clone(g.GeoFirstOut),
+			GeoShapeLat: slices.Clone(g.GeoShapeLat),
+			GeoShapeLon: slices.Clone(g.GeoShapeLon),
+			EdgeName:    slices.Clone(g.EdgeName),
+			Names:       g.Names,
+			EdgeAttr:    slices.Clone(g.EdgeAttr),
+			EdgeLimits:  slices.Clone(g.EdgeLimits),
+			EdgePenalty: slices.Clone(g.EdgePenalty),
+			NodeOSMID:   slices.Clone(g.NodeOSMID),
+			EdgeWayID:   slices.Clone(g.EdgeWayID),
+		}
+		h.TurnRestrictions = remapTurnRestrictions(g.TurnRestrictions, func(e uint32) (uint32, bool) { return e, true })
+		return h
+	}
 
 	// Build old→new node index mapping.
-	oldToNew := make(map[uint32]uint32, len(nodes))
-	for newIdx, oldIdx := range nodes {
-		oldToNew[oldIdx] = uint32(newIdx)
-	}
+	const dropped = ^uint32(0)
+	oldToNew := make([]uint32, g.NumNodes)
+	parallelFor(len(oldToNew), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			oldToNew[i] = dropped
+		}
+	})
+	parallelFor(len(nodes), func(lo, hi int) {
+		for newIdx := lo; newIdx < hi; newIdx++ {
+			oldToNew[nodes[newIdx]] = uint32(newIdx)
+		}
+	})
 
 	numNodes := uint32(len(nodes))
 
-	// Collect edges that are fully within the component.
-	type edge struct {
-		from, to, weight uint32
-		shapeLats        []float64
-		shapeLons        []float64
-	}
-	var edges []edge
-
-	for _, oldU := range nodes {
-		start, end := g.EdgesFrom(oldU)
-		for e := start; e < end; e++ {
-			oldV := g.Head[e]
-			if newV, ok := oldToNew[oldV]; ok {
-				var shapeLats, shapeLons []float64
-				if g.GeoFirstOut != nil {
-					geoStart := g.GeoFirstOut[e]
-					geoEnd := g.GeoFirstOut[e+1]
-					if geoEnd > geoStart {
-						shapeLats = make([]float64, geoEnd-geoStart)
-						copy(shapeLats, g.GeoShapeLat[geoStart:geoEnd])
-						shapeLons = make([]float64, geoEnd-geoStart)
-						copy(shapeLons, g.GeoShapeLon[geoStart:geoEnd])
-					}
+	// Count the edges that are fully within the component per node.
+	firstOut := make([]uint32, numNodes+1)
+	parallelFor(len(nodes), func(lo, hi int) {
+		for newU := lo; newU < hi; newU++ {
+			start, end := g.EdgesFrom(nodes[newU])
+			for e := start; e < end; e++ {
+				if oldToNew[g.Head[e]] != dropped {
+					firstOut[newU+1]++
 				}
-				edges = append(edges, edge{
-					from:      oldToNew[oldU],
-					to:        newV,
-					weight:    g.Weight[e],
-					shapeLats: shapeLats,
-					shapeLons: shapeLons,
-				})
 			}
 		}
+	})
+	for i := uint32(1); i <= numNodes; i++ {
+		firstOut[i] += firstOut[i-1]
 	}
+	numEdges := firstOut[numNodes]
 
-	numEdges := uint32(len(edges))
-
-	// Build CSR arrays.
-	firstOut := make([]uint32, numNodes+1)
+	// Map each new edge to its old index, each node's edges in their old
+	// order; every per-edge array is then gathered through the mapping.
 	head := make([]uint32, numEdges)
-	weight := make([]uint32, numEdges)
-	geoFirstOut := make([]uint32, numEdges+1)
-	var geoShapeLat, geoShapeLon []float64
+	oldEdge := make([]uint32, numEdges)
+	parallelFor(len(nodes), func(lo, hi int) {
+		for newU := lo; newU < hi; newU++ {
+			idx := firstOut[newU]
+			start, end := g.EdgesFrom(nodes[newU])
+			for e := start; e < end; e++ {
+				if newV := oldToNew[g.Head[e]]; newV != dropped {
+					head[idx], oldEdge[idx] = newV, e
+					idx++
+				}
+			}
+		}
+	})
 
-	// Count edges per node.
-	for _, e := range edges {
-		firstOut[e.from+1]++
+	h := &Graph{
+		NumNodes:    numNodes,
+		NumEdges:    numEdges,
+		FirstOut:    firstOut,
+		Head:        head,
+		Weight:      gather(g.Weight, oldEdge),
+		NodeLat:     gather(g.NodeLat, nodes),
+		NodeLon:     gather(g.NodeLon, nodes),
+		EdgeName:    gather(g.EdgeName, oldEdge),
+		Names:       g.Names,
+		EdgeAttr:    gather(g.EdgeAttr, oldEdge),
+		EdgeLimits:  gather(g.EdgeLimits, oldEdge),
+		EdgePenalty: gather(g.EdgePenalty, oldEdge),
+		NodeOSMID:   gather(g.NodeOSMID, nodes),
+		EdgeWayID:   gather(g.EdgeWayID, oldEdge),
 	}
-	for i := uint32(1); i <= numNodes; i++ {
-		firstOut[i] += firstOut[i-1]
+	h.GeoFirstOut, h.GeoShapeLat, h.GeoShapeLon = gatherGeometry(g, oldEdge)
+
+	if len(g.TurnRestrictions) > 0 {
+		newEdge := make([]uint32, g.NumEdges) // old→new edge index
+		parallelFor(len(newEdge), func(lo, hi int) {
+			for e := lo; e < hi; e++ {
+				newEdge[e] = dropped
+			}
+		})
+		parallelFor(len(oldEdge), func(lo, hi int) {
+			for ne := lo; ne < hi; ne++ {
+				newEdge[oldEdge[ne]] = uint32(ne)
+			}
+		})
+		h.TurnRestrictions = remapTurnRestrictions(g.TurnRestrictions, func(e uint32) (uint32, bool) {
+			ne := newEdge[e]
+			return ne, ne != dropped
+		})
 	}
+	return h
+}
 
-	// Place edges into CSR order.
-	pos := make([]uint32, numNodes)
-	copy(pos, firstOut[:numNodes])
-	for _, e := range edges {
-		idx := pos[e.from]
-		head[idx] = e.to
-		weight[idx] = e.weight
-		geoFirstOut[idx] = uint32(len(geoShapeLat))
-		geoShapeLat = append(geoShapeLat, e.shapeLats...)
-		geoShapeLon = append(geoShapeLon, e.shapeLons...)
-		pos[e.from]++
+// isIdentity reports whether nodes is 0, 1, ..., n-1.
+func isIdentity(nodes []uint32, n uint32) bool {
+	if len(nodes) != int(n) {
+		return false
 	}
-	geoFirstOut[numEdges] = uint32(len(geoShapeLat))
+	for i, u := range nodes {
+		if u != uint32(i) {
+			return false
+		}
+	}
+	return true
+}
 
-	// Copy node coordinates.
-	nodeLat := make([]float64, numNodes)
-	nodeLon := make([]float64, numNodes)
-	for newIdx, oldIdx := range nodes {
-		nodeLat[newIdx] = g.NodeLat[oldIdx]
-		nodeLon[newIdx] = g.NodeLon[oldIdx]
+// gather returns src[idx[0]], src[idx[1]], ..., or nil for a nil src.
+func gather[T any](src []T, idx []uint32) []T {
+	if src == nil {
+		return nil
 	}
+	dst := make([]T, len(idx))
+	parallelFor(len(idx), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			dst[i] = src[idx[i]]
+		}
+	})
+	return dst
+}
 
-	return &Graph{
-		NumNodes:    numNodes,
-		NumEdges:    numEdges,
-		FirstOut:    firstOut,
-		Head:        head,
-		Weight:      weight,
-		NodeLat:     nodeLat,
-		NodeLon:     nodeLon,
-		GeoFirstOut: geoFirstOut,
-		GeoShapeLat: geoShapeLat,
-		GeoShapeLon: geoShapeLon,
+// gatherGeometry returns the geometry arrays of the edges oldEdge maps to,
+// in the same order. A graph without geometry gets empty arrays, every edge
+// straight.
+func gatherGeometry(g *Graph, oldEdge []uint32) (geoFirstOut []uint32, lat, lon []Coord) {
+	geoFirstOut = make([]uint32, len(oldEdge)+1)
+	if g.GeoFirstOut == nil {
+		return geoFirstOut, []Coord{}, []Coord{}
 	}
+	parallelFor(len(oldEdge), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			e := oldEdge[i]
+			geoFirstOut[i+1] = g.GeoFirstOut[e+1] - g.GeoFirstOut[e]
+		}
+	})
+	for i := 1; i < len(geoFirstOut); i++ {
+		geoFirstOut[i] += geoFirstOut[i-1]
+	}
+	n := geoFirstOut[len(oldEdge)]
+	lat, lon = make([]Coord, n), make([]Coord, n)
+	parallelFor(len(oldEdge), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			e := oldEdge[i]
+			copy(lat[geoFirstOut[i]:geoFirstOut[i+1]], g.GeoShapeLat[g.GeoFirstOut[e]:])
+			copy(lon[geoFirstOut[i]:geoFirstOut[i+1]], g.GeoShapeLon[g.GeoFirstOut[e]:])
+		}
+	})
+	return geoFirstOut, lat, lon
 }
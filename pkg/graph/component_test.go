@@ -1,6 +1,9 @@
 package graph
 
 import (
+	"math/rand/v2"
+	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/paulmach/osm"
@@ -69,6 +72,81 @@ func TestLargestComponent(t *testing.T) {
 	}
 }
 
+func TestComponentsTop(t *testing.T) {
+	// A mainland triangle, an island pair and a one-way stub.
+	result := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100},
+			{FromNodeID: 20, ToNodeID: 30, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 10, Weight: 100},
+			{FromNodeID: 40, ToNodeID: 50, Weight: 100},
+			{FromNodeID: 50, ToNodeID: 40, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 60, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.0, 20: 1.1, 30: 1.2, 40: 2.0, 50: 2.5, 60: 3.0},
+		NodeLon: map[osm.NodeID]float64{10: 103.0, 20: 103.3, 30: 103.2, 40: 104.0, 50: 104.5, 60: 105.0},
+	}
+	g := Build(result)
+	comps := StronglyConnected(g)
+	if comps.Len() != 3 {
+		t.Fatalf("%d components, want 3", comps.Len())
+	}
+	top := comps.Top(g, 2)
+	want := []ComponentStats{
+		{Nodes: 3, BBox: BBox{MinLat: 1.0, MinLon: 103.0, MaxLat: 1.2, MaxLon: 103.3}},
+		{Nodes: 2, BBox: BBox{MinLat: 2.0, MinLon: 104.0, MaxLat: 2.5, MaxLon: 104.5}},
+	}
+	if !reflect.DeepEqual(top, want) {
+		t.Errorf("Top(2) = %+v, want %+v", top, want)
+	}
+	if len(comps.Top(g, 10)) != 3 {
+		t.Error("Top(10) does not list all 3 components")
+	}
+	if got := comps.AtLeast(2); len(got) != 5 {
+		t.Errorf("AtLeast(2) kept %d nodes, want 5", len(got))
+	}
+}
+
+func TestUnionEdgesParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	// Sparse random edges over enough nodes for the parallel path, half of
+	// them unioned.
+	rng := rand.New(rand.NewPCG(5, 6))
+	const n = 2 * minParallel
+	b := NewBuilder()
+	for range n {
+		b.AddNode(0, 0)
+	}
+	for range n / 2 {
+		if _, err := b.AddEdge(uint32(rng.IntN(n)), uint32(rng.IntN(n)), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := b.Freeze()
+	keep := func(e uint32) bool { return e%2 == 0 }
+
+	want := NewUnionFind(n)
+	for u := range g.NumNodes {
+		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
+			if keep(e) {
+				want.Union(u, g.Head[e])
+			}
+		}
+	}
+	got := NewUnionFind(n)
+	got.UnionEdges(g, keep)
+	for x := range uint32(n) {
+		rw, rg := want.Find(x), got.Find(x)
+		// Same partition: x is with its representative under both.
+		if want.Find(rg) != rw || got.Find(rw) != rg {
+			t.Fatalf("node %d: representatives %d and %d disagree", x, rw, rg)
+		}
+		if want.size[rw] != got.size[rg] {
+			t.Fatalf("node %d: set size %d, want %d", x, got.size[rg], want.size[rw])
+		}
+	}
+}
+
 // TestLargestComponentStronglyConnected verifies that the routing component is
 // the largest STRONGLY connected component, not the weakly connected one.
 //
@@ -102,7 +180,7 @@ func TestLargestComponentStronglyConnected(t *testing.T) {
 
 	// The one-directional dead-end nodes (lat 2.0 and 2.5) must be excluded.
 	for _, idx := range nodes {
-		if lat := g.NodeLat[idx]; lat == 2.0 || lat == 2.5 {
+		if lat := g.NodeLat[idx].Deg(); lat == 2.0 || lat == 2.5 {
 			t.Errorf("node at lat %.1f is one-directional and must not be in the routing component", lat)
 		}
 	}
@@ -172,6 +250,54 @@ func TestFilterToComponent(t *testing.T) {
 	}
 }
 
+func TestFilterToComponentIdentity(t *testing.T) {
+	// A two-way street 0-1-2 whose 1->2 edge has a bend.
+	b := NewBuilder()
+	for i := range 3 {
+		b.AddNode(1, 103+float64(i)*0.001)
+	}
+	for _, e := range [][2]uint32{{0, 1}, {1, 0}, {2, 1}} {
+		if _, err := b.AddEdge(e[0], e[1], 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.AddEdge(1, 2, 10, LatLng{Lat: 1.0005, Lng: 103.0015}); err != nil {
+		t.Fatal(err)
+	}
+	g := b.Freeze()
+	g.EdgeRestricted = make([]bool, g.NumEdges)
+
+	h := FilterToComponent(g, []uint32{0, 1, 2})
+	if h.NumEdges != 4 || h.EdgeRestricted != nil {
+		t.Errorf("identity filter kept %d edges and EdgeRestricted %v, want 4 and none", h.NumEdges, h.EdgeRestricted)
+	}
+	if !reflect.DeepEqual(h.Head, g.Head) || !reflect.DeepEqual(h.Weight, g.Weight) || !reflect.DeepEqual(h.GeoShapeLon, g.GeoShapeLon) {
+		t.Error("identity filter changed the graph")
+	}
+	// The result owns its arrays: rewriting them leaves g as it was.
+	head, weight, lat, shape := g.Head[0], g.Weight[0], g.NodeLat[0], g.GeoShapeLon[0]
+	h.Head[0], h.Weight[0], h.NodeLat[0], h.GeoShapeLon[0] = 2, 999, 0, 0
+	if g.Head[0] != head || g.Weight[0] != weight || g.NodeLat[0] != lat || g.GeoShapeLon[0] != shape {
+		t.Error("writing to the identity filter's arrays changed the input graph")
+	}
+
+	// Any other order copies, the bend moving with its edge.
+	h = FilterToComponent(g, []uint32{2, 1, 0})
+	if &h.Head[0] == &g.Head[0] || h.NumEdges != 4 {
+		t.Fatal("reordered filter shares arrays or lost edges")
+	}
+	h.EachOutEdge(1, func(e Edge) bool {
+		n := h.GeoFirstOut[e.Index+1] - h.GeoFirstOut[e.Index]
+		switch {
+		case e.To == 0 && (n != 1 || h.GeoShapeLon[h.GeoFirstOut[e.Index]] != ToCoord(103.0015)): // old 1->2
+			t.Errorf("edge to node 0 has %d shape points, want the bend", n)
+		case e.To != 0 && n != 0:
+			t.Errorf("edge to node %d has %d shape points, want 0", e.To, n)
+		}
+		return true
+	})
+}
+
 func TestFilterToComponentEmptyGraph(t *testing.T) {
 	g := &Graph{}
 	nodes := LargestComponent(g)
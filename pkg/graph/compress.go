@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed combined files.
+//
+// With WriteOptions.Compress the sections after the header are written as a
+// single zstd frame, framed by its byte length so the CRC32 trailer and
+// metadata footer still sit at known offsets:
+//
+//	[header (v4, flagZstd)][uint64 frame length][zstd frame][CRC32][footer]
+//
+// The CRC32 still covers the header and the uncompressed sections, so a
+// compressed file and its uncompressed twin carry the same checksum. The flag
+// lives in the version-4 header; files without flags keep the version-3
+// header, so uncompressed output stays readable by older readers.
+
+// zstdSection is an open compressed section being written to a file.
+type zstdSection struct {
+	f     *os.File
+	start int64 // offset of the length prefix
+	cw    countingWriter
+	enc   *zstd.Encoder
+}
+
+// beginZstd reserves the length prefix at f's current offset and starts a
+// zstd frame after it. Writes to the returned section are compressed.
+func beginZstd(f *os.File) (*zstdSection, error) {
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	var placeholder [8]byte
+	if _, err := f.Write(placeholder[:]); err != nil {
+		return nil, err
+	}
+	s := &zstdSection{f: f, start: start, cw: countingWriter{w: f}}
+	if s.enc, err = zstd.NewWriter(&s.cw, zstd.WithEncoderLevel(zstd.SpeedBetterCompression)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *zstdSection) Write(p []byte) (int, error) { return s.enc.Write(p) }
+
+// finish flushes the frame and fills in its length prefix.
+func (s *zstdSection) finish() error {
+	if err := s.enc.Close(); err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(s.cw.n))
+	if _, err := s.f.WriteAt(n[:], s.start); err != nil {
+		return fmt.Errorf("write compressed length: %w", err)
+	}
+	return nil
+}
+
+// openZstd reads the length prefix at src's current offset and returns a
+// reader of the decompressed frame. close releases the decoder and leaves src
+// positioned just after the frame, whatever was read of it; it may be called
+// more than once.
+func openZstd(src *io.SectionReader) (r io.Reader, close func() error, err error) {
+	var n uint64
+	if err := binary.Read(src, binary.LittleEndian, &n); err != nil {
+		return nil, nil, fmt.Errorf("read compressed length: %w", err)
+	}
+	if n > uint64(src.Size()) {
+		return nil, nil, fmt.Errorf("compressed length %d exceeds file size %d", n, src.Size())
+	}
+	lr := &io.LimitedReader{R: src, N: int64(n)}
+	dec, err := zstd.NewReader(lr, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	closed := false
+	return dec, func() error {
+		if closed {
+			return nil
+		}
+		closed = true
+		dec.Close()
+		_, err := io.Copy(io.Discard, lr)
+		return err
+	}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Coord is a latitude or longitude in OSM's own fixed-point precision of
+// 1e-7 degrees (about 1.1 cm). Node coordinates and shape points are stored
+// as Coords, half the size of float64, and converted to degrees at the API
+// boundary with Deg.
+type Coord int32
+
+// coordScale is the number of Coord units per degree.
+const coordScale = 1e7
+
+// ToCoord converts degrees to the nearest Coord.
+func ToCoord(deg float64) Coord {
+	return Coord(math.Round(deg * coordScale))
+}
+
+// Deg returns c in degrees. Coordinates with at most 7 decimals (everything
+// from OSM) survive ToCoord and Deg unchanged.
+func (c Coord) Deg() float64 {
+	return float64(c) / coordScale
+}
+
+// NodeLatLng returns the coordinates of node n in degrees.
+func (g *Graph) NodeLatLng(n uint32) (lat, lng float64) {
+	return g.NodeLat[n].Deg(), g.NodeLon[n].Deg()
+}
+
+// toCoords converts a slice of degrees, as stored by files from before
+// fixed-point coordinates.
+func toCoords(deg []float64) []Coord {
+	if deg == nil {
+		return nil
+	}
+	cs := make([]Coord, len(deg))
+	for i, d := range deg {
+		cs[i] = ToCoord(d)
+	}
+	return cs
+}
+
+func writeCoordSlice(w io.Writer, s []Coord) error {
+	return writeRaw(w, s, 4, swap32)
+}
+
+func readCoordSlice(r io.Reader, n int) ([]Coord, error) {
+	return readRaw[Coord](r, n, 4, swap32)
+}
+
+func writeLenPrefixedCoord(w io.Writer, s []Coord) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	return writeCoordSlice(w, s)
+}
+
+func readCoordSliceOptional(r io.Reader, maxBytes uint64) ([]Coord, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, nil
+	}
+	if n == 0 || uint64(n)*4 > maxBytes {
+		return nil, nil
+	}
+	return readCoordSlice(r, int(n))
+}
+
+// writeCoordsAsFloat64 writes s as float64 degrees, the layout of files from
+// before fixed-point coordinates, in chunks so no float64 copy of the whole
+// slice is made.
+func writeCoordsAsFloat64(w io.Writer, s []Coord) error {
+	var buf [1024]float64
+	for len(s) > 0 {
+		n := min(len(s), len(buf))
+		for i, c := range s[:n] {
+			buf[i] = c.Deg()
+		}
+		if err := writeFloat64Slice(w, buf[:n]); err != nil {
+			return err
+		}
+		s = s[n:]
+	}
+	return nil
+}
+
+func writeLenPrefixedCoordAsFloat64(w io.Writer, s []Coord) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	return writeCoordsAsFloat64(w, s)
+}
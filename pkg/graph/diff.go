@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"cmp"
+	"slices"
+)
+
+// EdgeKey identifies an edge across builds by its endpoint coordinates. Node
+// and edge indexes are renumbered by every build, coordinates are not.
+type EdgeKey struct {
+	FromLat, FromLon Coord
+	ToLat, ToLon     Coord
+}
+
+// DiffEdge is an edge present in only one of the diffed graphs.
+type DiffEdge struct {
+	EdgeKey
+	Weight uint32
+}
+
+// WeightChange is an edge present in both diffed graphs with different
+// weights.
+type WeightChange struct {
+	EdgeKey
+	Old, New uint32
+}
+
+// GraphDiff describes how graph b differs from graph a.
+type GraphDiff struct {
+	NodesA, NodesB uint32
+	EdgesA, EdgesB uint32
+
+	Added   []DiffEdge     // in b only
+	Removed []DiffEdge     // in a only
+	Changed []WeightChange // in both, with a different weight
+	Same    int            // in both, with the same weight
+}
+
+// DiffGraphs compares two builds of a road network, e.g. before and after a
+// data update, matching edges by their endpoint coordinates. Parallel edges
+// between the same two points are compared by their lowest weight, the one
+// routing uses. The edge lists are sorted by key, so the output of two runs
+// can itself be diffed.
+func DiffGraphs(a, b *Graph) *GraphDiff {
+	d := &GraphDiff{NodesA: a.NumNodes, NodesB: b.NumNodes, EdgesA: a.NumEdges, EdgesB: b.NumEdges}
+	wa, wb := edgeWeights(a), edgeWeights(b)
+	for k, w := range wa {
+		switch nw, ok := wb[k]; {
+		case !ok:
+			d.Removed = append(d.Removed, DiffEdge{k, w})
+		case nw != w:
+			d.Changed = append(d.Changed, WeightChange{k, w, nw})
+		default:
+			d.Same++
+		}
+	}
+	for k, w := range wb {
+		if _, ok := wa[k]; !ok {
+			d.Added = append(d.Added, DiffEdge{k, w})
+		}
+	}
+	slices.SortFunc(d.Added, func(x, y DiffEdge) int { return compareEdgeKeys(x.EdgeKey, y.EdgeKey) })
+	slices.SortFunc(d.Removed, func(x, y DiffEdge) int { return compareEdgeKeys(x.EdgeKey, y.EdgeKey) })
+	slices.SortFunc(d.Changed, func(x, y WeightChange) int { return compareEdgeKeys(x.EdgeKey, y.EdgeKey) })
+	return d
+}
+
+// edgeWeights maps each distinct edge of g to its lowest weight.
+func edgeWeights(g *Graph) map[EdgeKey]uint32 {
+	m := make(map[EdgeKey]uint32, g.NumEdges)
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			v := g.Head[e]
+			k := EdgeKey{g.NodeLat[u], g.NodeLon[u], g.NodeLat[v], g.NodeLon[v]}
+			if w, ok := m[k]; !ok || g.Weight[e] < w {
+				m[k] = g.Weight[e]
+			}
+		}
+	}
+	return m
+}
+
+func compareEdgeKeys(x, y EdgeKey) int {
+	return cmp.Or(
+		cmp.Compare(x.FromLat, y.FromLat),
+		cmp.Compare(x.FromLon, y.FromLon),
+		cmp.Compare(x.ToLat, y.ToLat),
+		cmp.Compare(x.ToLon, y.ToLon),
+	)
+}
+
+// RemovedBounds returns the bounding box, in degrees, of the endpoints of the
+// removed edges, and false when none were removed. A dropped district shows
+// up as a small box holding most of the removals.
+func (d *GraphDiff) RemovedBounds() (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	if len(d.Removed) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	lo := [2]Coord{d.Removed[0].FromLat, d.Removed[0].FromLon}
+	hi := lo
+	for _, e := range d.Removed {
+		for _, p := range [][2]Coord{{e.FromLat, e.FromLon}, {e.ToLat, e.ToLon}} {
+			lo[0], lo[1] = min(lo[0], p[0]), min(lo[1], p[1])
+			hi[0], hi[1] = max(hi[0], p[0]), max(hi[1], p[1])
+		}
+	}
+	return lo[0].Deg(), lo[1].Deg(), hi[0].Deg(), hi[1].Deg(), true
+}
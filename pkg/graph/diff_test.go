@@ -0,0 +1,43 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestDiffGraphs(t *testing.T) {
+	// The foot graph has the car graph's roads at ten times the weight plus a
+	// footpath 30<->50.
+	car, foot := buildTestCH(t).OrigGraph(), buildFootCH(t).OrigGraph()
+
+	d := graph.DiffGraphs(car, foot)
+	if d.NodesA != 4 || d.NodesB != 5 || d.EdgesA != 6 || d.EdgesB != 8 {
+		t.Errorf("counts: %d/%d nodes, %d/%d edges, want 4/5, 6/8", d.NodesA, d.NodesB, d.EdgesA, d.EdgesB)
+	}
+	if len(d.Added) != 2 || len(d.Removed) != 0 || len(d.Changed) != 6 || d.Same != 0 {
+		t.Fatalf("got %d added, %d removed, %d changed, %d same, want 2, 0, 6, 0",
+			len(d.Added), len(d.Removed), len(d.Changed), d.Same)
+	}
+	for _, c := range d.Changed {
+		if c.New != 10*c.Old {
+			t.Errorf("%+v: want the weight scaled by ten", c)
+		}
+	}
+	if _, _, _, _, ok := d.RemovedBounds(); ok {
+		t.Error("RemovedBounds reports removals, want none")
+	}
+
+	d = graph.DiffGraphs(foot, car)
+	if len(d.Removed) != 2 || len(d.Added) != 0 {
+		t.Fatalf("reverse: got %d removed, %d added, want 2, 0", len(d.Removed), len(d.Added))
+	}
+	minLat, minLon, maxLat, maxLon, ok := d.RemovedBounds()
+	if !ok || minLat != 1.2 || maxLat != 1.3 || minLon != 103.2 || maxLon != 103.2 {
+		t.Errorf("RemovedBounds = %v,%v,%v,%v,%v, want 1.2,103.2,1.3,103.2", minLat, minLon, maxLat, maxLon, ok)
+	}
+
+	if d := graph.DiffGraphs(car, car); d.Same != 6 || len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+		t.Errorf("self-diff: %+v, want 6 unchanged edges", d)
+	}
+}
@@ -0,0 +1,74 @@
+package graph
+
+import (
+	"encoding/binary"
+	"io"
+	"unsafe"
+)
+
+// The file formats are little-endian. The slice I/O helpers below write and
+// read a slice as its raw memory, which is that layout on little-endian
+// hosts; big-endian hosts byte-swap each element on the way, through a small
+// buffer on write, so a file built on one architecture loads on any other.
+
+// littleEndian reports whether the host's memory layout is the files'. It is
+// a variable so tests can exercise the swapping path.
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// swapChunk is the buffer size for writing byte-swapped slices; a multiple
+// of every element size.
+const swapChunk = 64 << 10
+
+// writeRaw writes s, size bytes per element, in file byte order: its memory
+// as is on little-endian hosts, else swapped a chunk at a time.
+func writeRaw[T any](w io.Writer, s []T, size int, swap func([]byte)) error {
+	if len(s) == 0 {
+		return nil
+	}
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*size)
+	if littleEndian {
+		_, err := w.Write(b)
+		return err
+	}
+	buf := make([]byte, min(len(b), swapChunk))
+	for len(b) > 0 {
+		n := copy(buf, b)
+		swap(buf[:n])
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// readRaw reads n elements of size bytes written by writeRaw, swapping them
+// in place on big-endian hosts.
+func readRaw[T any](r io.Reader, n, size int, swap func([]byte)) ([]T, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	s := make([]T, n)
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), n*size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if !littleEndian {
+		swap(b)
+	}
+	return s, nil
+}
+
+// swap32 reverses the bytes of each 4-byte word of b.
+func swap32(b []byte) {
+	for i := 0; i+4 <= len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+}
+
+// swap64 reverses the bytes of each 8-byte word of b.
+func swap64(b []byte) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		binary.LittleEndian.PutUint64(b[i:], binary.BigEndian.Uint64(b[i:]))
+	}
+}
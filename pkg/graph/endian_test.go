@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+// bigEndian runs the rest of a test as if on a big-endian host: slices are
+// byte-swapped on the way to and from the file.
+func bigEndian(t *testing.T) {
+	saved := littleEndian
+	littleEndian = false
+	t.Cleanup(func() { littleEndian = saved })
+}
+
+func TestSwappedSliceIO(t *testing.T) {
+	bigEndian(t)
+
+	// On a big-endian host the word 0x01020304 is the bytes 01 02 03 04 in
+	// memory, which is what 0x04030201 is here; the file holds 04 03 02 01.
+	var buf bytes.Buffer
+	if err := writeUint32Slice(&buf, []uint32{0x04030201}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{4, 3, 2, 1}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("uint32 written as % x, want % x", buf.Bytes(), want)
+	}
+	buf.Reset()
+	if err := writeInt64s(&buf, []uint64{0x0807060504030201}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{8, 7, 6, 5, 4, 3, 2, 1}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("uint64 written as % x, want % x", buf.Bytes(), want)
+	}
+	// Only SpeedKmh is a multi-byte field of EdgeAttr.
+	buf.Reset()
+	if err := writeEdgeAttrs(&buf, []EdgeAttr{{Class: 1, Flags: 2, SpeedKmh: 0x0403}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 4, 3}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("EdgeAttr written as % x, want % x", buf.Bytes(), want)
+	}
+
+	// Slices longer than the swap buffer round-trip.
+	long := make([]uint32, swapChunk/4*3+1)
+	for i := range long {
+		long[i] = uint32(i) * 0x01010101
+	}
+	buf.Reset()
+	if err := writeUint32Slice(&buf, long); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readUint32Slice(&buf, len(long))
+	if err != nil || !reflect.DeepEqual(got, long) {
+		t.Errorf("long slice did not round-trip (err %v)", err)
+	}
+}
+
+func TestSwappedBinaryRoundTrip(t *testing.T) {
+	// Two nodes, one shortcut-free upward edge 0->1 with a bend.
+	chg := &CHGraph{
+		NumNodes:     2,
+		NodeLat:      []Coord{ToCoord(1.0), ToCoord(1.001)},
+		NodeLon:      []Coord{ToCoord(103.0), ToCoord(103.001)},
+		FwdFirstOut:  []uint32{0, 1, 1},
+		FwdHead:      []uint32{1},
+		FwdWeight:    []uint32{500},
+		FwdMiddle:    []int32{-1},
+		BwdFirstOut:  []uint32{0, 0, 0},
+		OrigFirstOut: []uint32{0, 1, 1},
+		OrigHead:     []uint32{1},
+		OrigWeight:   []uint32{500},
+		GeoFirstOut:  []uint32{0, 1},
+		GeoShapeLat:  []Coord{ToCoord(1.0005)},
+		GeoShapeLon:  []Coord{ToCoord(103.0002)},
+		Names:        []RoadName{{}, {Name: "Jalan Besar"}},
+		EdgeName:     []uint32{1},
+		EdgeAttr:     []EdgeAttr{{Class: 3, Flags: 1, SpeedKmh: 300}},
+		NodeOSMID:    []osm.NodeID{1 << 40, 7},
+		EdgeWayID:    []osm.WayID{1<<33 + 5},
+		EdgeLimits:   []VehicleLimits{{MaxWeight: 7.5, MaxHeight: 3.2}},
+	}
+	// Every section goes through the swapping path both ways.
+	bigEndian(t)
+	dir := t.TempDir()
+	swapped := filepath.Join(dir, "swapped.bin")
+	if err := WriteBinaryWith(swapped, chg, WriteOptions{OSMIDs: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBinary(swapped)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	for name, pair := range map[string][2]any{
+		"FwdWeight":   {got.FwdWeight, chg.FwdWeight},
+		"GeoShapeLat": {got.GeoShapeLat, chg.GeoShapeLat},
+		"EdgeAttr":    {got.EdgeAttr, chg.EdgeAttr},
+		"NodeOSMID":   {got.NodeOSMID, chg.NodeOSMID},
+		"EdgeWayID":   {got.EdgeWayID, chg.EdgeWayID},
+		"EdgeLimits":  {got.EdgeLimits, chg.EdgeLimits},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// geoJSONEdge holds the properties of one exported edge. Attributes are
+// omitted when the graph carries none.
+type geoJSONEdge struct {
+	ID         uint32 `json:"id"`
+	From       uint32 `json:"from"`
+	To         uint32 `json:"to"`
+	Weight     uint32 `json:"weight"`
+	Class      string `json:"class,omitempty"`
+	SpeedKmh   uint16 `json:"speed_kmh,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	Roundabout bool   `json:"roundabout,omitempty"`
+	Restricted bool   `json:"restricted,omitempty"`
+	Oneway     bool   `json:"oneway,omitempty"`
+	Bridge     bool   `json:"bridge,omitempty"`
+	Tunnel     bool   `json:"tunnel,omitempty"`
+	Toll       bool   `json:"toll,omitempty"`
+	Ferry      bool   `json:"ferry,omitempty"`
+	Unpaved    bool   `json:"unpaved,omitempty"`
+}
+
+// ExportGeoJSON writes every edge of g as a GeoJSON LineString feature, from
+// its source node through its shape points to its target node, in one
+// FeatureCollection. Properties are the edge index, the node indexes it joins,
+// its weight and, when g carries them, its road class, speed, name and flags.
+// The output is streamed, so whole-country graphs export in constant memory,
+// and loads in QGIS, kepler.gl and geojson.io for inspecting a build.
+func ExportGeoJSON(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriterSize(w, 1<<16)
+	bw.WriteString(`{"type":"FeatureCollection","features":[`)
+	var buf []byte
+	first := true
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			v := g.Head[e]
+			props := geoJSONEdge{ID: e, From: u, To: v, Weight: g.Weight[e]}
+			if g.EdgeAttr != nil {
+				a := g.EdgeAttr[e]
+				props.Class, props.SpeedKmh = a.Class.String(), a.SpeedKmh
+				props.Roundabout, props.Restricted = a.Flags.Has(FlagRoundabout), a.Flags.Has(FlagRestricted)
+				props.Oneway, props.Bridge, props.Tunnel = a.Flags.Has(FlagOneway), a.Flags.Has(FlagBridge), a.Flags.Has(FlagTunnel)
+				props.Toll, props.Ferry, props.Unpaved = a.Flags.Has(FlagToll), a.Flags.Has(FlagFerry), a.Flags.Has(FlagUnpaved)
+			}
+			name := g.EdgeRoadName(e)
+			props.Name, props.Ref = name.Name, name.Ref
+			p, err := json.Marshal(props)
+			if err != nil {
+				return fmt.Errorf("edge %d: %w", e, err)
+			}
+
+			buf = buf[:0]
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+			buf = append(buf, "\n"+`{"type":"Feature","geometry":{"type":"LineString","coordinates":[`...)
+			buf = appendPosition(buf, g.NodeLat[u], g.NodeLon[u])
+			if g.GeoFirstOut != nil {
+				for i := g.GeoFirstOut[e]; i < g.GeoFirstOut[e+1]; i++ {
+					buf = append(buf, ',')
+					buf = appendPosition(buf, g.GeoShapeLat[i], g.GeoShapeLon[i])
+				}
+			}
+			buf = append(buf, ',')
+			buf = appendPosition(buf, g.NodeLat[v], g.NodeLon[v])
+			buf = append(buf, `]},"properties":`...)
+			buf = append(buf, p...)
+			buf = append(buf, '}')
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	bw.WriteString("\n]}\n")
+	return bw.Flush()
+}
+
+// appendPosition appends a GeoJSON [lon,lat] position in the shortest decimal
+// form that reads back to the same Coords.
+func appendPosition(buf []byte, lat, lon Coord) []byte {
+	buf = append(buf, '[')
+	buf = strconv.AppendFloat(buf, lon.Deg(), 'f', -1, 64)
+	buf = append(buf, ',')
+	buf = strconv.AppendFloat(buf, lat.Deg(), 'f', -1, 64)
+	return append(buf, ']')
+}
+
+// ExportNodesCSV writes the nodes of g as CSV with the header
+// id,lat,lng,osm_id; osm_id is empty when g does not carry OSM IDs (graphs
+// read from a binary written without them). Together with ExportEdgesCSV it
+// loads into pandas, NetworkX, igraph or a spreadsheet. For a CHGraph, export
+// chg.OrigGraph().
+func ExportNodesCSV(w io.Writer, g *Graph) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "lat", "lng", "osm_id"})
+	rec := make([]string, 4)
+	for u := range g.NumNodes {
+		lat, lng := g.NodeLatLng(u)
+		rec[0] = strconv.FormatUint(uint64(u), 10)
+		rec[1] = strconv.FormatFloat(lat, 'f', -1, 64)
+		rec[2] = strconv.FormatFloat(lng, 'f', -1, 64)
+		rec[3] = ""
+		if g.NodeOSMID != nil {
+			rec[3] = strconv.FormatInt(int64(g.NodeOSMID[u]), 10)
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportEdgesCSV writes the edges of g as CSV with the header
+// id,from,to,weight,class,speed_kmh,name,ref, one true/false column per edge
+// flag (roundabout,restricted,oneway,bridge,tunnel,toll,ferry,unpaved) and
+// way_id, where from and to are node ids of ExportNodesCSV. Columns g does
+// not carry are left empty.
+func ExportEdgesCSV(w io.Writer, g *Graph) error {
+	cw := csv.NewWriter(w)
+	header := append([]string{"id", "from", "to", "weight", "class", "speed_kmh", "name", "ref"}, edgeFlagNames[:]...)
+	cw.Write(append(header, "way_id"))
+	rec := make([]string, len(header)+1)
+	flagCols := rec[8 : 8+len(edgeFlagNames)]
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			clear(rec)
+			rec[0] = strconv.FormatUint(uint64(e), 10)
+			rec[1] = strconv.FormatUint(uint64(u), 10)
+			rec[2] = strconv.FormatUint(uint64(g.Head[e]), 10)
+			rec[3] = strconv.FormatUint(uint64(g.Weight[e]), 10)
+			if g.EdgeAttr != nil {
+				a := g.EdgeAttr[e]
+				rec[4] = a.Class.String()
+				rec[5] = strconv.FormatUint(uint64(a.SpeedKmh), 10)
+				for i := range flagCols {
+					flagCols[i] = strconv.FormatBool(a.Flags&(1<<i) != 0)
+				}
+			}
+			name := g.EdgeRoadName(e)
+			rec[6], rec[7] = name.Name, name.Ref
+			if g.EdgeWayID != nil {
+				rec[len(rec)-1] = strconv.FormatInt(int64(g.EdgeWayID[e]), 10)
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportGraphML writes g as a directed GraphML graph: nodes carry lat and
+// lng, edges weight and, when g carries them, class, speed_kmh, name, ref and
+// a boolean per edge flag that is set.
+// Node ids are "n" plus the node index, edge ids "e" plus the edge index.
+func ExportGraphML(w io.Writer, g *Graph) error {
+	bw := bufio.NewWriterSize(w, 1<<16)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, k := range [][3]string{
+		{"lat", "node", "double"},
+		{"lng", "node", "double"},
+		{"weight", "edge", "long"},
+		{"class", "edge", "string"},
+		{"speed_kmh", "edge", "int"},
+		{"name", "edge", "string"},
+		{"ref", "edge", "string"},
+	} {
+		fmt.Fprintf(bw, `  <key id="%s" for="%s" attr.name="%s" attr.type="%s"/>`+"\n", k[0], k[1], k[0], k[2])
+	}
+	for _, name := range edgeFlagNames {
+		fmt.Fprintf(bw, `  <key id="%s" for="edge" attr.name="%s" attr.type="boolean"><default>false</default></key>`+"\n", name, name)
+	}
+	bw.WriteString(`  <graph id="G" edgedefault="directed">` + "\n")
+	for u := range g.NumNodes {
+		lat, lng := g.NodeLatLng(u)
+		fmt.Fprintf(bw, `    <node id="n%d"><data key="lat">%s</data><data key="lng">%s</data></node>`+"\n",
+			u, strconv.FormatFloat(lat, 'f', -1, 64), strconv.FormatFloat(lng, 'f', -1, 64))
+	}
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			fmt.Fprintf(bw, `    <edge id="e%d" source="n%d" target="n%d"><data key="weight">%d</data>`, e, u, g.Head[e], g.Weight[e])
+			if g.EdgeAttr != nil {
+				a := g.EdgeAttr[e]
+				if c := a.Class.String(); c != "" {
+					fmt.Fprintf(bw, `<data key="class">%s</data>`, c)
+				}
+				fmt.Fprintf(bw, `<data key="speed_kmh">%d</data>`, a.SpeedKmh)
+				for _, name := range a.Flags.Names() {
+					fmt.Fprintf(bw, `<data key="%s">true</data>`, name)
+				}
+			}
+			name := g.EdgeRoadName(e)
+			for _, d := range [][2]string{{"name", name.Name}, {"ref", name.Ref}} {
+				if d[1] == "" {
+					continue
+				}
+				fmt.Fprintf(bw, `<data key="%s">`, d[0])
+				if err := xml.EscapeText(bw, []byte(d[1])); err != nil {
+					return err
+				}
+				bw.WriteString("</data>")
+			}
+			bw.WriteString("</edge>\n")
+		}
+	}
+	bw.WriteString("  </graph>\n</graphml>\n")
+	return bw.Flush()
+}
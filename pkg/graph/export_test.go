@@ -0,0 +1,159 @@
+package graph_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestExportGeoJSON(t *testing.T) {
+	g := buildFootCH(t).OrigGraph()
+	var buf bytes.Buffer
+	if err := graph.ExportGeoJSON(&buf, g); err != nil {
+		t.Fatalf("ExportGeoJSON: %v", err)
+	}
+
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string       `json:"type"`
+				Coordinates [][2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				ID, From, To, Weight uint32
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if fc.Type != "FeatureCollection" || len(fc.Features) != int(g.NumEdges) {
+		t.Fatalf("got %s of %d features, want FeatureCollection of %d", fc.Type, len(fc.Features), g.NumEdges)
+	}
+	for _, f := range fc.Features {
+		p := f.Properties
+		if f.Geometry.Type != "LineString" || p.Weight != g.Weight[p.ID] || p.To != g.Head[p.ID] {
+			t.Errorf("feature %+v does not match edge %d", f, p.ID)
+		}
+		coords := f.Geometry.Coordinates
+		fromLat, fromLng := g.NodeLatLng(p.From)
+		toLat, toLng := g.NodeLatLng(p.To)
+		if coords[0] != [2]float64{fromLng, fromLat} || coords[len(coords)-1] != [2]float64{toLng, toLat} {
+			t.Errorf("edge %d runs %v, want from %v,%v to %v,%v", p.ID, coords, fromLng, fromLat, toLng, toLat)
+		}
+		// The footpath to node 50 carries a shape point at 1.25, 103.25.
+		if p.Weight == 500 && (len(coords) != 3 || coords[1] != [2]float64{103.25, 1.25}) {
+			t.Errorf("footpath %d: coordinates %v, want the shape point in the middle", p.ID, coords)
+		}
+	}
+
+	buf.Reset()
+	if err := graph.ExportGeoJSON(&buf, &graph.Graph{}); err != nil {
+		t.Fatalf("ExportGeoJSON(empty): %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("empty export is not valid JSON: %s", buf.String())
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	g := buildFootCH(t).OrigGraph()
+	g.Names = []graph.RoadName{{}, {Name: `Jalan "Besar", Kuala Lumpur`}}
+	g.EdgeName = make([]uint32, g.NumEdges)
+	g.EdgeName[0] = 1
+
+	var nodes, edges bytes.Buffer
+	if err := graph.ExportNodesCSV(&nodes, g); err != nil {
+		t.Fatalf("ExportNodesCSV: %v", err)
+	}
+	if err := graph.ExportEdgesCSV(&edges, g); err != nil {
+		t.Fatalf("ExportEdgesCSV: %v", err)
+	}
+
+	nodeRecs, err := csv.NewReader(&nodes).ReadAll()
+	if err != nil {
+		t.Fatalf("nodes: %v", err)
+	}
+	if len(nodeRecs) != int(g.NumNodes)+1 || nodeRecs[0][0] != "id" {
+		t.Fatalf("got %d node rows, want a header and %d nodes", len(nodeRecs), g.NumNodes)
+	}
+	for u, rec := range nodeRecs[1:] {
+		lat, lng := g.NodeLatLng(uint32(u))
+		if rec[0] != strconv.Itoa(u) || rec[1] != strconv.FormatFloat(lat, 'f', -1, 64) || rec[2] != strconv.FormatFloat(lng, 'f', -1, 64) {
+			t.Errorf("node row %v, want %d at %v,%v", rec, u, lat, lng)
+		}
+	}
+
+	edgeRecs, err := csv.NewReader(&edges).ReadAll()
+	if err != nil {
+		t.Fatalf("edges: %v", err)
+	}
+	if len(edgeRecs) != int(g.NumEdges)+1 {
+		t.Fatalf("got %d edge rows, want a header and %d edges", len(edgeRecs), g.NumEdges)
+	}
+	for _, rec := range edgeRecs[1:] {
+		e, _ := strconv.Atoi(rec[0])
+		from, _ := strconv.Atoi(rec[1])
+		start, end := g.EdgesFrom(uint32(from))
+		if uint32(e) < start || uint32(e) >= end || rec[2] != strconv.Itoa(int(g.Head[e])) || rec[3] != strconv.Itoa(int(g.Weight[e])) {
+			t.Errorf("edge row %v does not match edge %d", rec, e)
+		}
+		if rec[6] != g.EdgeRoadName(uint32(e)).Name {
+			t.Errorf("edge %d: name %q, want %q", e, rec[6], g.EdgeRoadName(uint32(e)).Name)
+		}
+	}
+}
+
+func TestExportGraphML(t *testing.T) {
+	g := buildFootCH(t).OrigGraph()
+	g.Names = []graph.RoadName{{}, {Name: "Tom & Jerry <Lane>"}}
+	g.EdgeName = make([]uint32, g.NumEdges)
+	g.EdgeName[0] = 1
+
+	var buf bytes.Buffer
+	if err := graph.ExportGraphML(&buf, g); err != nil {
+		t.Fatalf("ExportGraphML: %v", err)
+	}
+	var doc struct {
+		Graph struct {
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+				Data   []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("output is not valid XML: %v\n%s", err, buf.String())
+	}
+	if doc.Graph.EdgeDefault != "directed" || len(doc.Graph.Nodes) != int(g.NumNodes) || len(doc.Graph.Edges) != int(g.NumEdges) {
+		t.Fatalf("got %s graph of %d nodes, %d edges, want directed with %d, %d",
+			doc.Graph.EdgeDefault, len(doc.Graph.Nodes), len(doc.Graph.Edges), g.NumNodes, g.NumEdges)
+	}
+	first := doc.Graph.Edges[0]
+	if first.Source != "n0" || first.Target != "n"+strconv.Itoa(int(g.Head[0])) {
+		t.Errorf("edge 0 runs %s->%s, want n0->n%d", first.Source, first.Target, g.Head[0])
+	}
+	var name string
+	for _, d := range first.Data {
+		if d.Key == "name" {
+			name = d.Value
+		}
+	}
+	if name != "Tom & Jerry <Lane>" {
+		t.Errorf("edge 0 name %q, want it escaped and read back", name)
+	}
+}
@@ -0,0 +1,214 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/azybler/map_router/pkg/geo"
+)
+
+// GeoJSONOptions controls how LoadGeoJSON weights lines.
+type GeoJSONOptions struct {
+	// SpeedKmh weights lines without a weight property by travel time (ms)
+	// at this speed; 0 weights them by length (cm), for a shortest-distance
+	// graph.
+	SpeedKmh float64
+}
+
+// LoadGeoJSON builds a Graph from a GeoJSON road network: LineString and
+// MultiLineString geometries, bare or in Features or a FeatureCollection,
+// for road data that does not come from OSM. Lines are joined where they
+// share a vertex: every endpoint, and every vertex used more than once,
+// becomes a node, and the vertices between become edge geometry. Feature
+// properties:
+//
+//	weight  the line's total weight in the graph's metric (ms or cm),
+//	        shared among its edges by length; without it, see GeoJSONOptions
+//	oneway  true, "yes" or 1: drivable in drawing order only;
+//	        "-1" or -1: against it only; otherwise both ways
+//
+// Other geometries are ignored, as are edges that would start and end at
+// the same node (a closed line touching no other).
+func LoadGeoJSON(path string, opt GeoJSONOptions) (*Graph, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := ParseGeoJSON(data, opt)
+	if err != nil {
+		return nil, fmt.Errorf("GeoJSON %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// ParseGeoJSON parses GeoJSON as for LoadGeoJSON.
+func ParseGeoJSON(data []byte, opt GeoJSONOptions) (*Graph, error) {
+	var lines []geoLine
+	if err := addGeoJSON(&lines, data, nil); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no LineString or MultiLineString geometry found")
+	}
+
+	// A vertex is a node when it ends a line or is used more than once.
+	uses := make(map[[2]Coord]int)
+	for _, l := range lines {
+		for i, p := range l.pts {
+			if i == 0 || i == len(l.pts)-1 {
+				uses[p] += 2
+			} else {
+				uses[p]++
+			}
+		}
+	}
+
+	b := NewBuilder()
+	nodes := make(map[[2]Coord]uint32)
+	node := func(p [2]Coord) uint32 {
+		n, ok := nodes[p]
+		if !ok {
+			n = b.AddNode(p[0].Deg(), p[1].Deg())
+			nodes[p] = n
+		}
+		return n
+	}
+	for _, l := range lines {
+		total := l.length(0, len(l.pts)-1)
+		start := 0
+		for i := 1; i < len(l.pts); i++ {
+			if uses[l.pts[i]] < 2 {
+				continue
+			}
+			from, to := node(l.pts[start]), node(l.pts[i])
+			if from != to {
+				length := l.length(start, i)
+				var w float64
+				switch {
+				case l.weight != nil && total > 0:
+					w = *l.weight * length / total
+				case opt.SpeedKmh > 0:
+					w = length / (opt.SpeedKmh / 3.6) * 1000
+				default:
+					w = length * 100
+				}
+				weight := uint32(max(1, min(math.Round(w), math.MaxUint32)))
+				var shape, back []LatLng
+				for _, p := range l.pts[start+1 : i] {
+					shape = append(shape, LatLng{Lat: p[0].Deg(), Lng: p[1].Deg()})
+				}
+				for k := len(shape) - 1; k >= 0; k-- {
+					back = append(back, shape[k])
+				}
+				if l.fwd {
+					b.AddEdge(from, to, weight, shape...)
+				}
+				if l.bwd {
+					b.AddEdge(to, from, weight, back...)
+				}
+			}
+			start = i
+		}
+	}
+	return b.Freeze(), nil
+}
+
+// geoLine is one LineString, its vertices as (lat, lon) with repeats
+// removed.
+type geoLine struct {
+	pts      [][2]Coord
+	weight   *float64
+	fwd, bwd bool
+}
+
+// length returns the length in metres of the line from vertex i to vertex j.
+func (l geoLine) length(i, j int) float64 {
+	d := 0.0
+	for k := i; k < j; k++ {
+		a, b := l.pts[k], l.pts[k+1]
+		d += geo.Haversine(a[0].Deg(), a[1].Deg(), b[0].Deg(), b[1].Deg())
+	}
+	return d
+}
+
+// addGeoJSON appends the lines of one GeoJSON object; props are those of the
+// enclosing Feature.
+func addGeoJSON(lines *[]geoLine, data []byte, props map[string]any) error {
+	var obj struct {
+		Type        string            `json:"type"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometry    json.RawMessage   `json:"geometry"`
+		Properties  map[string]any    `json:"properties"`
+		Features    []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	switch obj.Type {
+	case "FeatureCollection":
+		for _, f := range obj.Features {
+			if err := addGeoJSON(lines, f, nil); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if len(obj.Geometry) > 0 && string(obj.Geometry) != "null" {
+			return addGeoJSON(lines, obj.Geometry, obj.Properties)
+		}
+	case "LineString":
+		var pos [][]float64
+		if err := json.Unmarshal(obj.Coordinates, &pos); err != nil {
+			return fmt.Errorf("linestring coordinates: %w", err)
+		}
+		return addGeoLine(lines, pos, props)
+	case "MultiLineString":
+		var parts [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &parts); err != nil {
+			return fmt.Errorf("multilinestring coordinates: %w", err)
+		}
+		for _, pos := range parts {
+			if err := addGeoLine(lines, pos, props); err != nil {
+				return err
+			}
+		}
+	}
+	// Other geometry types (points, polygons) are not roads and are ignored.
+	return nil
+}
+
+func addGeoLine(lines *[]geoLine, pos [][]float64, props map[string]any) error {
+	l := geoLine{fwd: true, bwd: true}
+	for _, p := range pos {
+		if len(p) < 2 || math.Abs(p[1]) > 90 || math.Abs(p[0]) > 180 {
+			return fmt.Errorf("invalid position %v, want [lng, lat]", p)
+		}
+		pt := [2]Coord{ToCoord(p[1]), ToCoord(p[0])}
+		if n := len(l.pts); n == 0 || l.pts[n-1] != pt {
+			l.pts = append(l.pts, pt)
+		}
+	}
+	if len(l.pts) < 2 {
+		return nil
+	}
+	switch w := props["weight"].(type) {
+	case nil:
+	case float64:
+		if !(w >= 0) {
+			return fmt.Errorf("invalid weight %v", w)
+		}
+		l.weight = &w
+	default:
+		return fmt.Errorf("weight %v is not a number", w)
+	}
+	switch props["oneway"] {
+	case true, "yes", "true", "1", 1.0:
+		l.bwd = false
+	case "-1", -1.0:
+		l.fwd = false
+	}
+	*lines = append(*lines, l)
+	return nil
+}
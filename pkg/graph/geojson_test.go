@@ -0,0 +1,108 @@
+package graph_test
+
+import (
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestParseGeoJSON(t *testing.T) {
+	// A two-way street A-B-C with a bend at (1.0005, 103.0005) before B, and
+	// a oneway street crossing it at B, from D through B to E, weighted 900
+	// in total.
+	data := []byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","properties":{},"geometry":{"type":"LineString",
+			"coordinates":[[103.0,1.0],[103.0005,1.0005],[103.001,1.0],[103.002,1.0]]}},
+		{"type":"Feature","properties":{"oneway":"yes","weight":900},"geometry":{"type":"MultiLineString",
+			"coordinates":[[[103.001,0.999],[103.001,1.0],[103.001,1.001],[103.001,1.001]]]}},
+		{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[103.0,1.0]}}
+	]}`)
+	g, err := graph.ParseGeoJSON(data, graph.GeoJSONOptions{})
+	if err != nil {
+		t.Fatalf("ParseGeoJSON: %v", err)
+	}
+	// Nodes A, B, C, D, E; edges A<->B, B<->C, D->B, B->E.
+	if g.NumNodes != 5 || g.NumEdges != 6 {
+		t.Fatalf("graph has %d nodes, %d edges, want 5, 6", g.NumNodes, g.NumEdges)
+	}
+	find := func(lat, lng float64) uint32 {
+		t.Helper()
+		for u := range g.NumNodes {
+			if g.Coordinate(u) == (graph.LatLng{Lat: lat, Lng: lng}) {
+				return u
+			}
+		}
+		t.Fatalf("no node at %v,%v", lat, lng)
+		return 0
+	}
+	a, b, c := find(1.0, 103.0), find(1.0, 103.001), find(1.0, 103.002)
+	d, e := find(0.999, 103.001), find(1.001, 103.001)
+	edge := func(from, to uint32) (graph.Edge, bool) {
+		var found graph.Edge
+		ok := false
+		g.EachOutEdge(from, func(ed graph.Edge) bool {
+			if ed.To == to {
+				found, ok = ed, true
+			}
+			return !ok
+		})
+		return found, ok
+	}
+
+	// Unweighted lines are weighted by length in centimetres, bend included.
+	ab, ok := edge(a, b)
+	if !ok {
+		t.Fatal("no edge A->B")
+	}
+	if ab.Weight < 15700 || ab.Weight > 15800 { // 2 × 78.6 m
+		t.Errorf("A->B weight = %d cm, want ~15730", ab.Weight)
+	}
+	if n := g.GeoFirstOut[ab.Index+1] - g.GeoFirstOut[ab.Index]; n != 1 {
+		t.Errorf("A->B has %d shape points, want the bend", n)
+	}
+	if _, ok := edge(b, a); !ok {
+		t.Error("no edge B->A on a two-way line")
+	}
+	if _, ok := edge(c, b); !ok {
+		t.Error("no edge C->B on a two-way line")
+	}
+
+	// The oneway line is split at B, its weight shared by length.
+	db, ok1 := edge(d, b)
+	be, ok2 := edge(b, e)
+	if !ok1 || !ok2 {
+		t.Fatal("oneway line not split into D->B and B->E")
+	}
+	if db.Weight != 450 || be.Weight != 450 {
+		t.Errorf("oneway weights = %d, %d, want 450, 450", db.Weight, be.Weight)
+	}
+	if _, ok := edge(b, d); ok {
+		t.Error("edge B->D against a oneway line")
+	}
+
+	// Travel time at a speed instead of length.
+	g, err = graph.ParseGeoJSON(data, graph.GeoJSONOptions{SpeedKmh: 36})
+	if err != nil {
+		t.Fatalf("ParseGeoJSON: %v", err)
+	}
+	bc := graph.Edge{}
+	g.EachOutEdge(find(1.0, 103.001), func(ed graph.Edge) bool {
+		if ed.To == find(1.0, 103.002) {
+			bc = ed
+		}
+		return true
+	})
+	if bc.Weight < 11100 || bc.Weight > 11150 { // 111.3 m at 10 m/s
+		t.Errorf("B->C weight at 36 km/h = %d ms, want ~11120", bc.Weight)
+	}
+
+	for _, bad := range []string{
+		`{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0,0]}}`,
+		`{"type":"LineString","coordinates":[[0,95],[0,0]]}`,
+		`{"type":"Feature","properties":{"weight":"fast"},"geometry":{"type":"LineString","coordinates":[[0,0],[0,1]]}}`,
+	} {
+		if _, err := graph.ParseGeoJSON([]byte(bad), graph.GeoJSONOptions{}); err == nil {
+			t.Errorf("ParseGeoJSON(%s) succeeded", bad)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package graph
 
+import (
+	"github.com/paulmach/osm"
+
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
 // CHGraph holds the output of contraction hierarchies preprocessing.
 type CHGraph struct {
 	NumNodes uint32
-	NodeLat  []float64
-	NodeLon  []float64
+	NodeLat  []Coord
+	NodeLon  []Coord
 	Rank     []uint32
 
 	// Forward upward graph (edges where rank[source] < rank[target]).
@@ -26,8 +32,44 @@ type CHGraph struct {
 
 	// Original edge geometry (carried through from the base graph).
 	GeoFirstOut []uint32
-	GeoShapeLat []float64
-	GeoShapeLon []float64
+	GeoShapeLat []Coord
+	GeoShapeLon []Coord
+
+	// Original edge road names (carried through from the base graph; see
+	// Graph.EdgeName).
+	EdgeName []uint32
+	Names    []RoadName
+
+	// Original edge vehicle limits (see Graph.EdgeLimits).
+	EdgeLimits []VehicleLimits
+
+	// Original edge weight penalties (see Graph.EdgePenalty). Build-time only.
+	EdgePenalty []float32
+
+	// Original edge attributes (see Graph.EdgeAttr). EdgeName, Names and
+	// EdgeAttr are serialized together in the attributes section, so they
+	// survive a binary load when the file has one.
+	EdgeAttr []EdgeAttr
+
+	// Turn restrictions over the original edges (see Graph.TurnRestrictions).
+	TurnRestrictions []TurnRestriction
+
+	// OSM provenance of nodes and original edges (see Graph.NodeOSMID).
+	NodeOSMID []osm.NodeID
+	EdgeWayID []osm.WayID
+
+	// SnapIndex is the router's snapping index over the original edges, kept
+	// so a server need not build it on load. Optional: nil unless set before
+	// writing (preprocess --snap-index) or read from a file that had one.
+	SnapIndex *SnapIndex
+
+	// Meta describes how the graph was built. Optional on write (nil writes no
+	// footer); always non-nil after a read, carrying at least FormatVersion.
+	Meta *Metadata
+
+	// sectionCRCs is the checksum table of the file the graph was read from,
+	// nil when it had none (see VerifySections).
+	sectionCRCs []uint32
 }
 
 // BaseGraph holds the metric-independent parts of a CH graph: node coordinates,
@@ -42,8 +84,8 @@ type CHGraph struct {
 // counterpart WriteOverlay/ReadOverlay.
 type BaseGraph struct {
 	NumNodes uint32
-	NodeLat  []float64
-	NodeLon  []float64
+	NodeLat  []Coord
+	NodeLon  []Coord
 
 	// Original graph topology (needed for R-tree snapping and geometry). Edge
 	// WEIGHTS are metric-specific and live in the overlay, not here.
@@ -52,13 +94,16 @@ type BaseGraph struct {
 
 	// Original edge geometry.
 	GeoFirstOut []uint32
-	GeoShapeLat []float64
-	GeoShapeLon []float64
+	GeoShapeLat []Coord
+	GeoShapeLon []Coord
 
 	// Identity is a content hash over the topology (NumNodes + coords + original
 	// CSR). It is written into every overlay so a base/overlay mismatch is
 	// rejected at load time instead of silently addressing the wrong roads.
 	Identity uint32
+
+	// Meta is the base file's build metadata (see CHGraph.Meta).
+	Meta *Metadata
 }
 
 // Graph builds a *Graph view over this base for snapping/geometry, using the
@@ -80,6 +125,32 @@ func (b *BaseGraph) Graph(origWeight []uint32) *Graph {
 	}
 }
 
+// OrigGraph returns a *Graph view over the original (uncontracted) graph
+// carried by chg, for snapping, geometry and edge attributes. Like
+// BaseGraph.Graph it shares chg's backing slices.
+func (chg *CHGraph) OrigGraph() *Graph {
+	return &Graph{
+		NumNodes:    chg.NumNodes,
+		NumEdges:    uint32(len(chg.OrigHead)),
+		FirstOut:    chg.OrigFirstOut,
+		Head:        chg.OrigHead,
+		Weight:      chg.OrigWeight,
+		NodeLat:     chg.NodeLat,
+		NodeLon:     chg.NodeLon,
+		GeoFirstOut: chg.GeoFirstOut,
+		GeoShapeLat: chg.GeoShapeLat,
+		GeoShapeLon: chg.GeoShapeLon,
+		EdgeName:    chg.EdgeName,
+		Names:       chg.Names,
+		EdgeAttr:    chg.EdgeAttr,
+		EdgeLimits:  chg.EdgeLimits,
+		NodeOSMID:   chg.NodeOSMID,
+		EdgeWayID:   chg.EdgeWayID,
+
+		TurnRestrictions: chg.TurnRestrictions,
+	}
+}
+
 // Graph represents a directed graph in CSR (Compressed Sparse Row) format.
 type Graph struct {
 	NumNodes uint32
@@ -93,14 +164,144 @@ type Graph struct {
 	// (nil after a binary load — the server treats all edges as normal).
 	EdgeRestricted []bool // len: NumEdges (build-time only)
 
-	NodeLat []float64 // len: NumNodes
-	NodeLon []float64 // len: NumNodes
+	NodeLat []Coord // len: NumNodes
+	NodeLon []Coord // len: NumNodes
 
 	// Edge geometry: intermediate shape nodes for rendering.
 	// GeoFirstOut[i]..GeoFirstOut[i+1] indexes into GeoShapeLat/Lon for edge i.
-	GeoFirstOut []uint32  // len: NumEdges + 1
-	GeoShapeLat []float64 // flattened intermediate lat coords
-	GeoShapeLon []float64 // flattened intermediate lon coords
+	GeoFirstOut []uint32 // len: NumEdges + 1
+	GeoShapeLat []Coord  // flattened intermediate lat coords
+	GeoShapeLon []Coord  // flattened intermediate lon coords
+
+	// EdgeName[i] indexes Names for edge i (0 = unnamed). Populated by Build and
+	// carried through the preprocessing filters and contraction; serialized
+	// in the attributes section of combined binaries.
+	EdgeName []uint32   // len: NumEdges
+	Names    []RoadName // interned; Names[0] is the unnamed zero value
+
+	// EdgeAttr[i] holds edge i's road class, speed and flags, for turn
+	// instructions, avoid filters and styling. Populated by Build and carried
+	// like EdgeName; nil for hand-built graphs and binaries without an
+	// attributes section.
+	EdgeAttr []EdgeAttr // len: NumEdges
+
+	// EdgeLimits[i] holds the maxweight/maxheight/maxwidth/maxlength of edge
+	// i's way, for dimension-aware routing. nil when no edge has a limit.
+	// Populated by Build and carried like EdgeName; serialized in the
+	// vehicle limits section of combined binaries.
+	EdgeLimits []VehicleLimits // len: NumEdges
+
+	// EdgePenalty[i] is the factor FilterBridgingRestricted multiplied edge
+	// i's weight by (1 for most edges), so that DistanceWeights can penalize
+	// the same shortcut clusters. nil when no edge is penalized. Carried like
+	// EdgeLimits; NOT serialized.
+	EdgePenalty []float32 // len: NumEdges (build-time only)
+
+	// TurnRestrictions are the OSM turn restrictions of the profile, for a
+	// turn-aware engine. Populated by Build and carried through the
+	// preprocessing filters and contraction; serialized in the turn
+	// restriction section of combined binaries. The current engine ignores
+	// them.
+	TurnRestrictions []TurnRestriction
+
+	// NodeOSMID[u] is the OSM node ID of node u, and EdgeWayID[e] the OSM way
+	// edge e was built from, so routes can be traced back to the map for QA
+	// and editing feedback. Populated by Build and carried through the
+	// preprocessing filters and contraction; serialized only when written
+	// with WriteOptions.OSMIDs, nil after loading any other binary.
+	// Hand-built graphs may leave them nil.
+	NodeOSMID []osm.NodeID // len: NumNodes
+	EdgeWayID []osm.WayID  // len: NumEdges
+}
+
+// EdgeWay returns the OSM way edge e was built from, or 0 when the graph
+// carries no OSM IDs.
+func (g *Graph) EdgeWay(e uint32) osm.WayID {
+	if g.EdgeWayID == nil {
+		return 0
+	}
+	return g.EdgeWayID[e]
+}
+
+// VehicleLimits are an edge's physical restrictions; zero fields are unset.
+type VehicleLimits = osmparser.VehicleLimits
+
+// RoadClass is an edge's OSM highway class.
+type RoadClass = osmparser.RoadClass
+
+// EdgeAttr are the attributes of one edge beyond its geometry and name.
+type EdgeAttr struct {
+	Class    RoadClass
+	Flags    EdgeFlags
+	SpeedKmh uint16 // free-flow speed in the edge's direction, rounded; 0 = unknown
+}
+
+// EdgeFlags are boolean edge attributes, serialized as one byte per edge in
+// the attributes section. Files written before a flag existed read it as
+// unset, which is why the surface flag marks unpaved rather than paved roads.
+type EdgeFlags uint8
+
+const (
+	FlagRoundabout EdgeFlags = 1 << iota // part of a roundabout or circular junction
+	FlagRestricted                       // private/destination access (last-mile only)
+	FlagOneway                           // the way is traversable in one direction only
+	FlagBridge                           // on a bridge
+	FlagTunnel                           // in a tunnel
+	FlagToll                             // toll road
+	FlagFerry                            // ferry route
+	FlagUnpaved                          // unpaved surface
+)
+
+// edgeFlagNames names the flags by bit, for annotations and exports.
+var edgeFlagNames = [8]string{"roundabout", "restricted", "oneway", "bridge", "tunnel", "toll", "ferry", "unpaved"}
+
+// Has reports whether every flag in f2 is set.
+func (f EdgeFlags) Has(f2 EdgeFlags) bool { return f&f2 == f2 }
+
+// Names returns the names of the flags set in f ("roundabout", "oneway",
+// ...), in bit order.
+func (f EdgeFlags) Names() []string {
+	var names []string
+	for i, name := range edgeFlagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RoadName is the street name and route reference of an edge's way.
+type RoadName struct {
+	Name string // e.g. "Orchard Road"
+	Ref  string // ref and int_ref, ";"-separated, e.g. "PIE;AH2"
+}
+
+// EdgeRoadName returns the road name of edge e, or the zero value when the
+// graph carries no names.
+func (g *Graph) EdgeRoadName(e uint32) RoadName {
+	if g.EdgeName == nil {
+		return RoadName{}
+	}
+	return g.Names[g.EdgeName[e]]
+}
+
+// EdgeAttribute returns the attributes of edge e, or the zero value when the
+// graph carries none.
+func (g *Graph) EdgeAttribute(e uint32) EdgeAttr {
+	if g.EdgeAttr == nil {
+		return EdgeAttr{}
+	}
+	return g.EdgeAttr[e]
+}
+
+// EdgeAdmits reports whether a vehicle of the given weight (t), height, width
+// and length (m) may use edge e. Zero dimensions are not checked; every edge
+// admits every vehicle when the graph carries no limits.
+func (g *Graph) EdgeAdmits(e uint32, weight, height, width, length float64) bool {
+	if g.EdgeLimits == nil {
+		return true
+	}
+	return g.EdgeLimits[e].Admits(weight, height, width, length)
 }
 
 // EdgesFrom returns the range of edge indices for edges originating from node u.
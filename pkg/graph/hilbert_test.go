@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+func TestSortHilbert(t *testing.T) {
+	// An 8×8 grid of nodes 1e-7° apart, one aligned block of the curve, with
+	// OSM IDs shuffled against the grid so Build numbers them out of order.
+	const n = 8
+	result := &osmparser.ParseResult{NodeLat: map[osm.NodeID]float64{}, NodeLon: map[osm.NodeID]float64{}}
+	id := func(i, j int) osm.NodeID { return osm.NodeID(1 + (i*n+j)*37%(n*n)) }
+	for i := range n {
+		for j := range n {
+			result.NodeLat[id(i, j)], result.NodeLon[id(i, j)] = float64(i)*1e-7, float64(j)*1e-7
+			if j+1 < n {
+				result.Edges = append(result.Edges,
+					osmparser.RawEdge{FromNodeID: id(i, j), ToNodeID: id(i, j+1), Weight: 1},
+					osmparser.RawEdge{FromNodeID: id(i, j+1), ToNodeID: id(i, j), Weight: 1})
+			}
+			if i+1 < n {
+				result.Edges = append(result.Edges,
+					osmparser.RawEdge{FromNodeID: id(i, j), ToNodeID: id(i+1, j), Weight: 2},
+					osmparser.RawEdge{FromNodeID: id(i+1, j), ToNodeID: id(i, j), Weight: 2})
+			}
+		}
+	}
+	g := Build(result)
+	nodes := LargestComponent(g)
+	SortHilbert(g, nodes)
+	h := FilterToComponent(g, nodes)
+
+	if h.NumNodes != g.NumNodes || h.NumEdges != g.NumEdges {
+		t.Fatalf("renumbered graph has %d nodes, %d edges, want %d, %d", h.NumNodes, h.NumEdges, g.NumNodes, g.NumEdges)
+	}
+	// Consecutive nodes along the curve are grid neighbours.
+	for u := uint32(1); u < h.NumNodes; u++ {
+		d := abs(h.NodeLat[u]-h.NodeLat[u-1]) + abs(h.NodeLon[u]-h.NodeLon[u-1])
+		if d != 1 {
+			t.Errorf("nodes %d and %d are %d grid steps apart, want 1", u-1, u, d)
+		}
+	}
+	// Every edge survives with its weight, between the same positions.
+	type key struct{ fromLat, fromLon, toLat, toLon Coord }
+	edges := func(g *Graph) map[key]uint32 {
+		m := make(map[key]uint32)
+		for u := range g.NumNodes {
+			start, end := g.EdgesFrom(u)
+			for e := start; e < end; e++ {
+				m[key{g.NodeLat[u], g.NodeLon[u], g.NodeLat[g.Head[e]], g.NodeLon[g.Head[e]]}] = g.Weight[e]
+			}
+		}
+		return m
+	}
+	want, got := edges(g), edges(h)
+	for k, w := range want {
+		if got[k] != w {
+			t.Errorf("edge %+v weight %d after renumbering, want %d", k, got[k], w)
+		}
+	}
+	for u := range h.NumNodes {
+		if h.NodeOSMID[u] != g.NodeOSMID[nodes[u]] {
+			t.Errorf("NodeOSMID[%d] = %d, want %d", u, h.NodeOSMID[u], g.NodeOSMID[nodes[u]])
+		}
+	}
+}
+
+func abs(c Coord) Coord {
+	if c < 0 {
+		return -c
+	}
+	return c
+}
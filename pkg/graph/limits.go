@@ -0,0 +1,30 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+)
+
+// Vehicle limits section (header flag flagLimits), after the snap index
+// section:
+//
+//	EdgeLimits [NumOrigEdges][4]float32 // MaxWeight, MaxHeight, MaxWidth, MaxLength
+//
+// It is written whenever the graph carries EdgeLimits, which Build sets only
+// when some way has a limit, so a loaded server can check a vehicle's
+// dimensions against each edge (Graph.EdgeAdmits).
+
+func writeLimitsSection(w io.Writer, limits []VehicleLimits) error {
+	if err := writeRaw(w, limits, 16, swap32); err != nil {
+		return fmt.Errorf("write EdgeLimits: %w", err)
+	}
+	return nil
+}
+
+func readLimitsSection(r io.Reader, numEdges int) ([]VehicleLimits, error) {
+	limits, err := readRaw[VehicleLimits](r, numEdges, 16, swap32)
+	if err != nil {
+		return nil, fmt.Errorf("read EdgeLimits: %w", err)
+	}
+	return limits, nil
+}
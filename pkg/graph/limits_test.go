@@ -0,0 +1,60 @@
+package graph_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestBinaryVehicleLimits(t *testing.T) {
+	original := buildTestCH(t)
+	dir := t.TempDir()
+
+	// A graph without limits is written without the section.
+	plain := filepath.Join(dir, "plain.graph.bin")
+	if err := graph.WriteBinary(plain, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	loaded, err := graph.ReadBinary(plain)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if loaded.EdgeLimits != nil {
+		t.Errorf("EdgeLimits = %v, want nil", loaded.EdgeLimits)
+	}
+
+	original.EdgeLimits = make([]graph.VehicleLimits, len(original.OrigHead))
+	original.EdgeLimits[0] = graph.VehicleLimits{MaxWeight: 7.5, MaxHeight: 3.2, MaxWidth: 2.3, MaxLength: 12}
+	withLimits := filepath.Join(dir, "limits.graph.bin")
+	if err := graph.WriteBinaryWith(withLimits, original, graph.WriteOptions{Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	loaded, err = graph.ReadBinary(withLimits)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.EdgeLimits, original.EdgeLimits) {
+		t.Errorf("EdgeLimits = %v, want %v", loaded.EdgeLimits, original.EdgeLimits)
+	}
+	if err := loaded.VerifySections(); err != nil {
+		t.Errorf("VerifySections: %v", err)
+	}
+	g := loaded.OrigGraph()
+	if g.EdgeAdmits(0, 0, 4, 0, 0) || !g.EdgeAdmits(0, 0, 3, 0, 0) {
+		t.Error("a loaded graph does not check the stored height limit")
+	}
+
+	// Upgrade keeps them.
+	upgraded := filepath.Join(dir, "upgraded.graph.bin")
+	if err := graph.Upgrade(withLimits, upgraded, graph.WriteOptions{}); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if loaded, err = graph.ReadBinary(upgraded); err != nil {
+		t.Fatalf("ReadBinary(upgraded): %v", err)
+	}
+	if !reflect.DeepEqual(loaded.EdgeLimits, original.EdgeLimits) {
+		t.Error("Upgrade dropped the vehicle limits")
+	}
+}
@@ -0,0 +1,181 @@
+package graph
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Metadata describes how a graph file was built. It is stored as an optional
+// footer after the CRC32 trailer of combined, base and overlay files:
+//
+//	[JSON][uint32 len][uint32 crc32(JSON)][metaMagic]
+//
+// Readers that predate the footer stop at the CRC32 trailer and never see it,
+// and files written before it existed simply have no footer, so neither side
+// needs a format version bump. Because the footer is anchored at the end of the
+// file it can be read without loading the graph itself.
+type Metadata struct {
+	// FormatVersion is the header version of the file the metadata was read
+	// from. Populated on read; never serialized.
+	FormatVersion uint32 `json:"-"`
+
+	BuildTime    time.Time         `json:"build_time"`
+	ToolVersion  string            `json:"tool_version,omitempty"`  // preprocess module version and VCS revision
+	SourceFile   string            `json:"source_file,omitempty"`   // input path(s) as given to preprocess, comma-separated
+	SourceSHA256 string            `json:"source_sha256,omitempty"` // hex digest of each input file, comma-separated
+	BBox         *BBox             `json:"bbox,omitempty"`          // extent of the graph's nodes
+	Params       map[string]string `json:"params,omitempty"`        // preprocessing parameters (flag name → value)
+	Profiles     []string          `json:"profiles,omitempty"`      // vehicle profiles, e.g. ["car"]
+	Metric       string            `json:"metric,omitempty"`        // "time" or "distance"
+}
+
+// BBox is a geographic bounding box.
+type BBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// NodeBBox returns the bounding box of the given node coordinates, or nil when
+// there are none.
+func NodeBBox(lat, lon []Coord) *BBox {
+	if len(lat) == 0 || len(lat) != len(lon) {
+		return nil
+	}
+	minLat, maxLat, minLon, maxLon := lat[0], lat[0], lon[0], lon[0]
+	for i := 1; i < len(lat); i++ {
+		minLat, maxLat = min(minLat, lat[i]), max(maxLat, lat[i])
+		minLon, maxLon = min(minLon, lon[i]), max(maxLon, lon[i])
+	}
+	return &BBox{MinLat: minLat.Deg(), MinLon: minLon.Deg(), MaxLat: maxLat.Deg(), MaxLon: maxLon.Deg()}
+}
+
+const (
+	metaMagic      = "MPRMETA1"
+	metaFooterSize = 4 + 4 + len(metaMagic) // len + crc + magic
+	maxMetaSize    = 1 << 20                // guard against corrupt lengths
+)
+
+// writeMetaFooter appends meta as a footer to w. A nil meta writes nothing.
+func writeMetaFooter(w io.Writer, meta *Metadata) error {
+	if meta == nil {
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+	trailer := struct {
+		Len   uint32
+		CRC   uint32
+		Magic [8]byte
+	}{Len: uint32(len(data)), CRC: crc32.ChecksumIEEE(data)}
+	copy(trailer.Magic[:], metaMagic)
+	if err := binary.Write(w, binary.LittleEndian, &trailer); err != nil {
+		return fmt.Errorf("write metadata trailer: %w", err)
+	}
+	return nil
+}
+
+// footerMeta returns the metadata to write for a graph: a copy of meta with
+// BBox filled in from the node coordinates when unset, or nil when meta is nil.
+func footerMeta(meta *Metadata, lat, lon []Coord) *Metadata {
+	if meta == nil {
+		return nil
+	}
+	m := *meta
+	if m.BBox == nil {
+		m.BBox = NodeBBox(lat, lon)
+	}
+	return &m
+}
+
+// errNoMetadata reports a file without a metadata footer.
+var errNoMetadata = errors.New("no metadata footer")
+
+// readMetaFooter reads the metadata footer anchored at the end of src.
+// Returns errNoMetadata when the file has none.
+func readMetaFooter(src *io.SectionReader) (*Metadata, error) {
+	size := src.Size()
+	if size < int64(metaFooterSize) {
+		return nil, errNoMetadata
+	}
+	var trailer struct {
+		Len   uint32
+		CRC   uint32
+		Magic [8]byte
+	}
+	tr := io.NewSectionReader(src, size-int64(metaFooterSize), int64(metaFooterSize))
+	if err := binary.Read(tr, binary.LittleEndian, &trailer); err != nil {
+		return nil, err
+	}
+	if string(trailer.Magic[:]) != metaMagic {
+		return nil, errNoMetadata
+	}
+	if trailer.Len > maxMetaSize || int64(trailer.Len) > size-int64(metaFooterSize) {
+		return nil, fmt.Errorf("metadata length %d out of range", trailer.Len)
+	}
+	data := make([]byte, trailer.Len)
+	if _, err := src.ReadAt(data, size-int64(metaFooterSize)-int64(trailer.Len)); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	if got := crc32.ChecksumIEEE(data); got != trailer.CRC {
+		return nil, fmt.Errorf("metadata CRC32 mismatch: stored=%08x computed=%08x", trailer.CRC, got)
+	}
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// ReadMetadata reads the metadata of a combined, base, overlay or tiled graph file
+// without loading the graph: only the header's magic and version and the
+// footer are read. A file without a footer yields metadata carrying only
+// FormatVersion.
+func ReadMetadata(path string) (*Metadata, error) {
+	f, src, err := openSection(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hdr struct {
+		Magic   [8]byte
+		Version uint32
+	}
+	if err := binary.Read(src, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	switch string(hdr.Magic[:]) {
+	case magicBytes, baseMagic, overlayMagic, tiledMagic:
+	case bundleMagic:
+		return nil, fmt.Errorf("%s is a multi-profile bundle; its metadata is per profile (see ReadBundle)", path)
+	default:
+		return nil, fmt.Errorf("invalid magic bytes: %q", hdr.Magic)
+	}
+	return loadMeta(src, hdr.Version)
+}
+
+// loadMeta reads the metadata footer for a file whose header reported
+// version. A file without a footer yields metadata carrying only the version,
+// so callers can always report what format they loaded.
+func loadMeta(src *io.SectionReader, version uint32) (*Metadata, error) {
+	meta, err := readMetaFooter(src)
+	if errors.Is(err, errNoMetadata) {
+		meta, err = &Metadata{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.FormatVersion = version
+	return meta, nil
+}
@@ -0,0 +1,126 @@
+package graph_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	chg := buildTestCH(t)
+	built := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	chg.Meta = &graph.Metadata{
+		BuildTime:    built,
+		ToolVersion:  "v1.2.0 (0123456789ab)",
+		SourceFile:   "sg.osm.pbf",
+		SourceSHA256: "abc123",
+		Params:       map[string]string{"singapore": "true"},
+		Profiles:     []string{"car"},
+		Metric:       "time",
+	}
+
+	dir := t.TempDir()
+	combined := filepath.Join(dir, "graph.bin")
+	base := filepath.Join(dir, "base.bin")
+	overlay := filepath.Join(dir, "overlay.bin")
+	if err := graph.WriteBinary(combined, chg); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	if err := graph.WriteBase(base, chg); err != nil {
+		t.Fatalf("WriteBase: %v", err)
+	}
+	if err := graph.WriteOverlay(overlay, chg); err != nil {
+		t.Fatalf("WriteOverlay: %v", err)
+	}
+
+	loaded, err := graph.ReadBinary(combined)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	b, err := graph.ReadBase(base)
+	if err != nil {
+		t.Fatalf("ReadBase: %v", err)
+	}
+	ov, err := graph.ReadOverlay(overlay, b)
+	if err != nil {
+		t.Fatalf("ReadOverlay: %v", err)
+	}
+
+	for name, m := range map[string]*graph.Metadata{"combined": loaded.Meta, "base": b.Meta, "overlay": ov.Meta} {
+		if m == nil {
+			t.Fatalf("%s: Meta is nil", name)
+		}
+		if m.FormatVersion == 0 {
+			t.Errorf("%s: FormatVersion not populated", name)
+		}
+		if !m.BuildTime.Equal(built) || m.ToolVersion != chg.Meta.ToolVersion || m.SourceSHA256 != "abc123" || m.Metric != "time" || m.Params["singapore"] != "true" {
+			t.Errorf("%s: metadata mismatch: %+v", name, m)
+		}
+		// BBox is filled in from the nodes when the writer leaves it unset.
+		want := graph.BBox{MinLat: 1.0, MinLon: 103.0, MaxLat: 1.3, MaxLon: 103.3}
+		if m.BBox == nil || *m.BBox != want {
+			t.Errorf("%s: BBox = %+v, want %+v", name, m.BBox, want)
+		}
+	}
+	if chg.Meta.BBox != nil {
+		t.Error("writing must not mutate the caller's Metadata")
+	}
+
+	// ReadMetadata returns the same without loading the graph.
+	for path, want := range map[string]*graph.Metadata{combined: loaded.Meta, base: b.Meta, overlay: ov.Meta} {
+		m, err := graph.ReadMetadata(path)
+		if err != nil {
+			t.Fatalf("ReadMetadata(%s): %v", filepath.Base(path), err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("ReadMetadata(%s) = %+v, want %+v", filepath.Base(path), m, want)
+		}
+	}
+	notGraph := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notGraph, []byte("not a graph file at all"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadMetadata(notGraph); err == nil {
+		t.Error("ReadMetadata accepted a file that is not a graph")
+	}
+}
+
+func TestMetadataAbsent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBinary(path, buildTestCH(t)); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	loaded, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	// Version 4: built graphs carry an attributes section.
+	if loaded.Meta == nil || loaded.Meta.FormatVersion != 4 || !loaded.Meta.BuildTime.IsZero() {
+		t.Errorf("Meta without footer = %+v, want version-only", loaded.Meta)
+	}
+}
+
+func TestMetadataCorrupt(t *testing.T) {
+	chg := buildTestCH(t)
+	chg.Meta = &graph.Metadata{SourceFile: "x.osm.pbf"}
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBinary(path, chg); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte inside the JSON payload (just before the 16-byte trailer).
+	data[len(data)-17] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.ReadBinary(path); err == nil {
+		t.Error("expected error for corrupt metadata footer")
+	}
+}
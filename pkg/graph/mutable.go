@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Builder assembles a Graph by hand, for networks that do not come from OSM
+// (warehouse layouts, campus maps, test fixtures). Add nodes and edges in
+// any order, remove edges again, then Freeze the result into a CSR Graph
+// ready for ch.Contract. The zero value is an empty builder.
+type Builder struct {
+	lat, lon []Coord
+	edges    []builderEdge
+}
+
+type builderEdge struct {
+	from, to, weight uint32
+	shapeLat         []Coord
+	shapeLon         []Coord
+	removed          bool
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// AddNode adds a node at lat, lng (degrees) and returns its index, which
+// the frozen graph keeps.
+func (b *Builder) AddNode(lat, lng float64) uint32 {
+	b.lat = append(b.lat, ToCoord(lat))
+	b.lon = append(b.lon, ToCoord(lng))
+	return uint32(len(b.lat) - 1)
+}
+
+// AddEdge adds a directed edge from one node to another and returns its ID
+// for RemoveEdge. weight is in the graph's metric: travel time in
+// milliseconds, or distance in centimetres for a shortest-distance graph.
+// shape lists the points between the two nodes, if the edge is not
+// straight. Add an edge each way for a two-way connection.
+func (b *Builder) AddEdge(from, to, weight uint32, shape ...LatLng) (uint32, error) {
+	if n := uint32(len(b.lat)); from >= n || to >= n {
+		return 0, fmt.Errorf("edge %d->%d: node out of range (%d nodes)", from, to, n)
+	}
+	e := builderEdge{from: from, to: to, weight: weight}
+	for _, p := range shape {
+		e.shapeLat = append(e.shapeLat, ToCoord(p.Lat))
+		e.shapeLon = append(e.shapeLon, ToCoord(p.Lng))
+	}
+	b.edges = append(b.edges, e)
+	return uint32(len(b.edges) - 1), nil
+}
+
+// RemoveEdge removes the edge AddEdge returned id for.
+func (b *Builder) RemoveEdge(id uint32) error {
+	if id >= uint32(len(b.edges)) || b.edges[id].removed {
+		return fmt.Errorf("edge %d does not exist", id)
+	}
+	b.edges[id].removed = true
+	return nil
+}
+
+// NumNodes returns the number of nodes added so far.
+func (b *Builder) NumNodes() uint32 {
+	return uint32(len(b.lat))
+}
+
+// Freeze returns the graph built so far in CSR form. Edges are ordered by
+// source, then target, parallel edges in the order they were added. The
+// builder stays usable; later changes do not affect the returned graph.
+func (b *Builder) Freeze() *Graph {
+	edges := make([]builderEdge, 0, len(b.edges))
+	for _, e := range b.edges {
+		if !e.removed {
+			edges = append(edges, e)
+		}
+	}
+	slices.SortStableFunc(edges, func(x, y builderEdge) int {
+		return cmp.Or(cmp.Compare(x.from, y.from), cmp.Compare(x.to, y.to))
+	})
+
+	numNodes, numEdges := uint32(len(b.lat)), uint32(len(edges))
+	g := &Graph{
+		NumNodes:    numNodes,
+		NumEdges:    numEdges,
+		FirstOut:    make([]uint32, numNodes+1),
+		Head:        make([]uint32, numEdges),
+		Weight:      make([]uint32, numEdges),
+		NodeLat:     slices.Clone(b.lat),
+		NodeLon:     slices.Clone(b.lon),
+		GeoFirstOut: make([]uint32, numEdges+1),
+	}
+	for i, e := range edges {
+		g.FirstOut[e.from+1]++
+		g.Head[i], g.Weight[i] = e.to, e.weight
+		g.GeoFirstOut[i] = uint32(len(g.GeoShapeLat))
+		g.GeoShapeLat = append(g.GeoShapeLat, e.shapeLat...)
+		g.GeoShapeLon = append(g.GeoShapeLon, e.shapeLon...)
+	}
+	g.GeoFirstOut[numEdges] = uint32(len(g.GeoShapeLat))
+	for i := uint32(1); i <= numNodes; i++ {
+		g.FirstOut[i] += g.FirstOut[i-1]
+	}
+	return g
+}
@@ -0,0 +1,67 @@
+package graph_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestBuilder(t *testing.T) {
+	b := graph.NewBuilder()
+	dock := b.AddNode(1.0, 103.0)
+	aisle := b.AddNode(1.0, 103.001)
+	shelf := b.AddNode(1.001, 103.001)
+
+	mustAdd := func(from, to, weight uint32, shape ...graph.LatLng) uint32 {
+		t.Helper()
+		id, err := b.AddEdge(from, to, weight, shape...)
+		if err != nil {
+			t.Fatalf("AddEdge(%d, %d): %v", from, to, err)
+		}
+		return id
+	}
+	mustAdd(aisle, shelf, 20)
+	mustAdd(dock, aisle, 10, graph.LatLng{Lat: 1.0005, Lng: 103.0005})
+	blocked := mustAdd(dock, shelf, 5)
+	mustAdd(aisle, dock, 10)
+	mustAdd(shelf, aisle, 20)
+	if _, err := b.AddEdge(dock, 7, 1); err == nil {
+		t.Error("AddEdge accepted an unknown node")
+	}
+	if err := b.RemoveEdge(blocked); err != nil {
+		t.Fatalf("RemoveEdge: %v", err)
+	}
+	if err := b.RemoveEdge(blocked); err == nil {
+		t.Error("RemoveEdge removed an edge twice")
+	}
+
+	g := b.Freeze()
+	if g.NumNodes != 3 || g.NumEdges != 4 {
+		t.Fatalf("frozen graph has %d nodes, %d edges, want 3, 4", g.NumNodes, g.NumEdges)
+	}
+	if want := []uint32{0, 1, 3, 4}; !slices.Equal(g.FirstOut, want) {
+		t.Errorf("FirstOut = %v, want %v", g.FirstOut, want)
+	}
+	if want := []uint32{aisle, dock, shelf, aisle}; !slices.Equal(g.Head, want) {
+		t.Errorf("Head = %v, want %v", g.Head, want)
+	}
+	if got, want := g.Coordinate(shelf), (graph.LatLng{Lat: 1.001, Lng: 103.001}); got != want {
+		t.Errorf("Coordinate(shelf) = %+v, want %+v", got, want)
+	}
+	if g.GeoFirstOut[1]-g.GeoFirstOut[0] != 1 || g.GeoShapeLat[0] != graph.ToCoord(1.0005) {
+		t.Errorf("dock->aisle shape = %v, want one point at 1.0005", g.GeoShapeLat[g.GeoFirstOut[0]:g.GeoFirstOut[1]])
+	}
+
+	// Later changes leave the frozen graph alone.
+	b.AddNode(2, 104)
+	if g.NumNodes != 3 || len(g.NodeLat) != 3 {
+		t.Error("AddNode after Freeze changed the frozen graph")
+	}
+
+	chg := ch.Contract(g)
+	if chg.NumNodes != 3 || len(chg.OrigHead) != 4 {
+		t.Errorf("contracted graph has %d nodes, %d original edges, want 3, 4", chg.NumNodes, len(chg.OrigHead))
+	}
+}
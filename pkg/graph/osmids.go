@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/paulmach/osm"
+)
+
+// OSM ID section (header flag flagOSMIDs), after the turn restriction
+// section:
+//
+//	NodeOSMID [NumNodes]int64
+//	EdgeWayID [NumOrigEdges]int64
+//
+// It is optional (WriteOptions.OSMIDs) because it is as large as the node
+// coordinates and original edges together, and routing never reads it; it is
+// there for tracing routes and snaps back to the map. An array the graph does
+// not carry is written as zeros.
+
+func writeOSMIDSection(w io.Writer, chg *CHGraph) error {
+	var err error
+	if chg.NodeOSMID != nil {
+		err = writeInt64s(w, chg.NodeOSMID)
+	} else {
+		err = writeZeros(w, int64(chg.NumNodes)*8)
+	}
+	if err != nil {
+		return fmt.Errorf("write NodeOSMID: %w", err)
+	}
+	if chg.EdgeWayID != nil {
+		err = writeInt64s(w, chg.EdgeWayID)
+	} else {
+		err = writeZeros(w, int64(len(chg.OrigHead))*8)
+	}
+	if err != nil {
+		return fmt.Errorf("write EdgeWayID: %w", err)
+	}
+	return nil
+}
+
+func readOSMIDSection(r io.Reader, chg *CHGraph, numNodes, numEdges int) error {
+	var err error
+	if chg.NodeOSMID, err = readInt64s[osm.NodeID](r, numNodes); err != nil {
+		return fmt.Errorf("read NodeOSMID: %w", err)
+	}
+	if chg.EdgeWayID, err = readInt64s[osm.WayID](r, numEdges); err != nil {
+		return fmt.Errorf("read EdgeWayID: %w", err)
+	}
+	return nil
+}
+
+// writeInt64s writes s as raw memory, like writeUint32Slice. It takes
+// unsigned words too.
+func writeInt64s[T ~int64 | ~uint64](w io.Writer, s []T) error {
+	return writeRaw(w, s, 8, swap64)
+}
+
+func readInt64s[T ~int64 | ~uint64](r io.Reader, n int) ([]T, error) {
+	return readRaw[T](r, n, 8, swap64)
+}
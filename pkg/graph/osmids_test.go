@@ -0,0 +1,59 @@
+package graph_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestBinaryOSMIDs(t *testing.T) {
+	original := buildTestCH(t)
+	if original.NodeOSMID == nil || original.EdgeWayID == nil {
+		t.Fatal("Build left the OSM IDs nil")
+	}
+	dir := t.TempDir()
+
+	plain := filepath.Join(dir, "plain.graph.bin")
+	if err := graph.WriteBinary(plain, original); err != nil {
+		t.Fatalf("WriteBinary: %v", err)
+	}
+	loaded, err := graph.ReadBinary(plain)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if loaded.NodeOSMID != nil || loaded.EdgeWayID != nil {
+		t.Error("OSM IDs were written without WriteOptions.OSMIDs")
+	}
+
+	withIDs := filepath.Join(dir, "ids.graph.bin")
+	if err := graph.WriteBinaryWith(withIDs, original, graph.WriteOptions{OSMIDs: true, Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	loaded, err = graph.ReadBinary(withIDs)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.NodeOSMID, original.NodeOSMID) || !reflect.DeepEqual(loaded.EdgeWayID, original.EdgeWayID) {
+		t.Errorf("OSM IDs = %v, %v, want %v, %v", loaded.NodeOSMID, loaded.EdgeWayID, original.NodeOSMID, original.EdgeWayID)
+	}
+	if err := loaded.VerifySections(); err != nil {
+		t.Errorf("VerifySections: %v", err)
+	}
+	if got, want := loaded.OrigGraph().EdgeWay(0), original.EdgeWayID[0]; got != want {
+		t.Errorf("EdgeWay(0) = %d, want %d", got, want)
+	}
+
+	// Upgrade keeps them.
+	upgraded := filepath.Join(dir, "upgraded.graph.bin")
+	if err := graph.Upgrade(withIDs, upgraded, graph.WriteOptions{}); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if loaded, err = graph.ReadBinary(upgraded); err != nil {
+		t.Fatalf("ReadBinary(upgraded): %v", err)
+	}
+	if !reflect.DeepEqual(loaded.NodeOSMID, original.NodeOSMID) {
+		t.Error("Upgrade dropped the OSM IDs")
+	}
+}
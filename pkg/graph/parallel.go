@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// minParallel is the input size below which the parallel helpers run on the
+// calling goroutine; smaller inputs finish faster than goroutines start.
+const minParallel = 1 << 14
+
+// chunkBounds splits [0, n) into one contiguous range per CPU, or a single
+// range for small n: range c is bounds[c]..bounds[c+1].
+func chunkBounds(n int) []int {
+	workers := runtime.GOMAXPROCS(0)
+	if n < minParallel || workers == 1 {
+		return []int{0, n}
+	}
+	chunk := (n + workers - 1) / workers
+	var bounds []int
+	for lo := 0; lo < n; lo += chunk {
+		bounds = append(bounds, lo)
+	}
+	return append(bounds, n)
+}
+
+// forChunks calls fn on each range of bounds (see chunkBounds), concurrently
+// when there are several. fn must only write state owned by its range c.
+func forChunks(bounds []int, fn func(c, lo, hi int)) {
+	if len(bounds) == 2 {
+		fn(0, bounds[0], bounds[1])
+		return
+	}
+	var wg sync.WaitGroup
+	for c := 0; c+1 < len(bounds); c++ {
+		wg.Go(func() { fn(c, bounds[c], bounds[c+1]) })
+	}
+	wg.Wait()
+}
+
+// parallelFor calls fn on ranges covering [0, n), concurrently on large n.
+func parallelFor(n int, fn func(lo, hi int)) {
+	forChunks(chunkBounds(n), func(_, lo, hi int) { fn(lo, hi) })
+}
+
+// sortStableParallel sorts s stably by cmp: runs of s are sorted
+// concurrently and then merged pairwise, each level's merges running
+// concurrently too. The result is the same as slices.SortStableFunc's.
+func sortStableParallel[T any](s []T, cmp func(a, b T) int) {
+	bounds := chunkBounds(len(s))
+	forChunks(bounds, func(_, lo, hi int) { slices.SortStableFunc(s[lo:hi], cmp) })
+	if len(bounds) == 2 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	src, dst := s, make([]T, len(s))
+	for len(bounds) > 2 {
+		var next []int
+		for i := 0; i+1 < len(bounds); i += 2 {
+			next = append(next, bounds[i])
+			if i+2 >= len(bounds) { // odd run out: carry it to the next level
+				copy(dst[bounds[i]:bounds[i+1]], src[bounds[i]:bounds[i+1]])
+				continue
+			}
+			lo, mid, hi := bounds[i], bounds[i+1], bounds[i+2]
+			wg.Go(func() { mergeStable(dst[lo:hi], src[lo:mid], src[mid:hi], cmp) })
+		}
+		wg.Wait()
+		bounds = append(next, len(s))
+		src, dst = dst, src
+	}
+	if &src[0] != &s[0] {
+		copy(s, src)
+	}
+}
+
+// mergeStable merges sorted a and b into dst, taking from a on ties.
+func mergeStable[T any](dst, a, b []T, cmp func(a, b T) int) {
+	i, j, k := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		if cmp(b[j], a[i]) < 0 {
+			dst[k] = b[j]
+			j++
+		} else {
+			dst[k] = a[i]
+			i++
+		}
+		k++
+	}
+	k += copy(dst[k:], a[i:])
+	copy(dst[k:], b[j:])
+}
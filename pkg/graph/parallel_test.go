@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+func TestSortStableParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(5)) // an odd run count exercises the carry
+	type pair struct{ key, seq int }
+	rng := rand.New(rand.NewPCG(1, 2))
+	s := make([]pair, 3*minParallel+7)
+	for i := range s {
+		s[i] = pair{rng.IntN(500), i}
+	}
+	want := slices.Clone(s)
+	byKey := func(a, b pair) int { return cmp.Compare(a.key, b.key) }
+	slices.SortStableFunc(want, byKey)
+	sortStableParallel(s, byKey)
+	if !slices.Equal(s, want) {
+		t.Error("sortStableParallel differs from slices.SortStableFunc")
+	}
+}
+
+// TestBuildParallel builds a graph large enough for the parallel phases and
+// checks it against the sequential definition of Build.
+func TestBuildParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	rng := rand.New(rand.NewPCG(3, 4))
+	const numIDs = 5000
+	result := &osmparser.ParseResult{NodeLat: map[osm.NodeID]float64{}, NodeLon: map[osm.NodeID]float64{}}
+	for id := osm.NodeID(1); id <= numIDs; id++ {
+		result.NodeLat[id], result.NodeLon[id] = rng.Float64(), rng.Float64()
+	}
+	for range 2 * minParallel {
+		e := osmparser.RawEdge{
+			FromNodeID: osm.NodeID(1 + rng.IntN(numIDs)),
+			ToNodeID:   osm.NodeID(1 + rng.IntN(numIDs)),
+			Weight:     uint32(rng.IntN(1000)),
+		}
+		if rng.IntN(4) == 0 {
+			e.ShapeLats, e.ShapeLons = []float64{rng.Float64()}, []float64{rng.Float64()}
+		}
+		result.Edges = append(result.Edges, e)
+	}
+	g := Build(result)
+
+	// Nodes are numbered by first appearance.
+	var ids []osm.NodeID
+	seen := map[osm.NodeID]uint32{}
+	for _, e := range result.Edges {
+		for _, id := range []osm.NodeID{e.FromNodeID, e.ToNodeID} {
+			if _, ok := seen[id]; !ok {
+				seen[id] = uint32(len(ids))
+				ids = append(ids, id)
+			}
+		}
+	}
+	if !slices.Equal(g.NodeOSMID, ids) {
+		t.Fatal("nodes are not numbered in order of first appearance")
+	}
+	// Edges are stably sorted by (from, to), geometry alongside.
+	want := slices.Clone(result.Edges)
+	slices.SortStableFunc(want, func(a, b osmparser.RawEdge) int {
+		return cmp.Or(cmp.Compare(seen[a.FromNodeID], seen[b.FromNodeID]), cmp.Compare(seen[a.ToNodeID], seen[b.ToNodeID]))
+	})
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			w := want[e]
+			if u != seen[w.FromNodeID] || g.Head[e] != seen[w.ToNodeID] || g.Weight[e] != w.Weight {
+				t.Fatalf("edge %d = %d->%d (%d), want %d->%d (%d)", e, u, g.Head[e], g.Weight[e], seen[w.FromNodeID], seen[w.ToNodeID], w.Weight)
+			}
+			if n := g.GeoFirstOut[e+1] - g.GeoFirstOut[e]; int(n) != len(w.ShapeLats) || n > 0 && g.GeoShapeLat[g.GeoFirstOut[e]] != ToCoord(w.ShapeLats[0]) {
+				t.Fatalf("edge %d has the wrong geometry", e)
+			}
+		}
+	}
+
+	// Filtering in reverse order reverses the numbering and keeps each
+	// node's edges.
+	nodes := make([]uint32, g.NumNodes)
+	for i := range nodes {
+		nodes[i] = g.NumNodes - 1 - uint32(i)
+	}
+	f := FilterToComponent(g, nodes)
+	if f.NumEdges != g.NumEdges {
+		t.Fatalf("FilterToComponent kept %d of %d edges", f.NumEdges, g.NumEdges)
+	}
+	for newU, oldU := range nodes {
+		fs, fe := f.EdgesFrom(uint32(newU))
+		gs, ge := g.EdgesFrom(oldU)
+		if fe-fs != ge-gs {
+			t.Fatalf("node %d has %d edges after filtering, want %d", oldU, fe-fs, ge-gs)
+		}
+		for k := range fe - fs {
+			if f.Head[fs+k] != g.NumNodes-1-g.Head[gs+k] || f.Weight[fs+k] != g.Weight[gs+k] ||
+				f.GeoFirstOut[fs+k+1]-f.GeoFirstOut[fs+k] != g.GeoFirstOut[gs+k+1]-g.GeoFirstOut[gs+k] {
+				t.Fatalf("edge %d of node %d differs after filtering", k, oldU)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package graph
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// RenumberByRank returns chg with each node renumbered to its rank, so the
+// upward graphs lead from lower node indexes to higher ones, bar the edges
+// within an uncontracted core, and a node's Rank is its index. A sweep
+// through the node indexes then visits the nodes in rank order, reading
+// BwdFirstOut and its edges in sequence, as PHAST does down the hierarchy
+// (see routing.NewPHAST), and an upward search reads the overlay in
+// rising order. The edges of each node are sorted by their head. The
+// renumbering gives up the map locality of SortHilbert, and drops chg's
+// SnapIndex, which is built over the old numbering.
+//
+// chg must carry its Rank, as it has after contraction: graph files do not
+// keep ranks.
+func RenumberByRank(chg *CHGraph) (*CHGraph, error) {
+	n := chg.NumNodes
+	if len(chg.Rank) != int(n) {
+		return nil, fmt.Errorf("%d ranks for %d nodes", len(chg.Rank), n)
+	}
+	order := make([]uint32, n)
+	seen := make([]bool, n)
+	for v, r := range chg.Rank {
+		if r >= n || seen[r] {
+			return nil, fmt.Errorf("ranks are not a permutation: node %d has rank %d", v, r)
+		}
+		seen[r] = true
+		order[r] = uint32(v)
+	}
+
+	g := chg.OrigGraph()
+	g.EdgePenalty = chg.EdgePenalty
+	h := FilterToComponent(g, order)
+	out := &CHGraph{
+		NumNodes:     n,
+		NodeLat:      h.NodeLat,
+		NodeLon:      h.NodeLon,
+		Rank:         make([]uint32, n),
+		OrigFirstOut: h.FirstOut,
+		OrigHead:     h.Head,
+		OrigWeight:   h.Weight,
+		GeoFirstOut:  h.GeoFirstOut,
+		GeoShapeLat:  h.GeoShapeLat,
+		GeoShapeLon:  h.GeoShapeLon,
+		EdgeName:     h.EdgeName,
+		Names:        h.Names,
+		EdgeAttr:     h.EdgeAttr,
+		EdgeLimits:   h.EdgeLimits,
+		EdgePenalty:  h.EdgePenalty,
+		NodeOSMID:    h.NodeOSMID,
+		EdgeWayID:    h.EdgeWayID,
+		Meta:         chg.Meta,
+
+		TurnRestrictions: h.TurnRestrictions,
+	}
+	for v := range n {
+		out.Rank[v] = v
+	}
+	out.FwdFirstOut, out.FwdHead, out.FwdWeight, out.FwdMiddle = renumberUpward(chg.Rank, order, chg.FwdFirstOut, chg.FwdHead, chg.FwdWeight, chg.FwdMiddle)
+	out.BwdFirstOut, out.BwdHead, out.BwdWeight, out.BwdMiddle = renumberUpward(chg.Rank, order, chg.BwdFirstOut, chg.BwdHead, chg.BwdWeight, chg.BwdMiddle)
+	return out, nil
+}
+
+// renumberUpward lays out an upward graph for the nodes renumbered to
+// their ranks, node order[i] becoming i, each node's edges sorted by head.
+func renumberUpward(rank, order, firstOut, head, weight []uint32, middle []int32) (newFirstOut, newHead, newWeight []uint32, newMiddle []int32) {
+	n := len(order)
+	newFirstOut = make([]uint32, n+1)
+	for i, v := range order {
+		newFirstOut[i+1] = newFirstOut[i] + firstOut[v+1] - firstOut[v]
+	}
+	m := newFirstOut[n]
+	newHead, newWeight, newMiddle = make([]uint32, m), make([]uint32, m), make([]int32, m)
+	parallelFor(n, func(lo, hi int) {
+		var idx []uint32
+		for i := lo; i < hi; i++ {
+			v := order[i]
+			idx = idx[:0]
+			for e := firstOut[v]; e < firstOut[v+1]; e++ {
+				idx = append(idx, e)
+			}
+			slices.SortStableFunc(idx, func(a, b uint32) int { return cmp.Compare(rank[head[a]], rank[head[b]]) })
+			for k, e := range idx {
+				j := newFirstOut[i] + uint32(k)
+				newHead[j], newWeight[j], newMiddle[j] = rank[head[e]], weight[e], middle[e]
+				if middle[e] >= 0 {
+					newMiddle[j] = int32(rank[middle[e]])
+				}
+			}
+		}
+	})
+	return newFirstOut, newHead, newWeight, newMiddle
+}
@@ -0,0 +1,92 @@
+package graph_test
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestRenumberByRank(t *testing.T) {
+	// A 10×10 grid, one-way along every third row.
+	const side = 10
+	b := graph.NewBuilder()
+	for r := range side {
+		for c := range side {
+			b.AddNode(1+float64(r)*0.001, 103+float64(c)*0.001)
+		}
+	}
+	for u := uint32(0); u < side*side; u++ {
+		if u%side+1 < side {
+			b.AddEdge(u, u+1, 100+u%7*10)
+			if u/side%3 != 0 {
+				b.AddEdge(u+1, u, 100+u%5*10)
+			}
+		}
+		if u+side < side*side {
+			b.AddEdge(u, u+side, 150)
+			b.AddEdge(u+side, u, 150)
+		}
+	}
+	g := b.Freeze()
+
+	for _, opt := range []ch.Options{{}, {MaxShortcutsPerNode: 2}} {
+		chg := ch.ContractWith(g, opt)
+		r, err := graph.RenumberByRank(chg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rank := chg.Rank
+		for v := range chg.NumNodes {
+			u := rank[v]
+			if r.Rank[u] != u || r.NodeLat[u] != chg.NodeLat[v] || r.NodeLon[u] != chg.NodeLon[v] {
+				t.Fatalf("node %d: renumbered to %d with rank %d at %v,%v", v, u, r.Rank[u], r.NodeLat[u], r.NodeLon[u])
+			}
+			// The same edges, renumbered, sorted by head.
+			for _, up := range []struct {
+				name                          string
+				first, head, weight, newFirst []uint32
+				newHead, newWeight            []uint32
+				middle, newMiddle             []int32
+			}{
+				{"forward", chg.FwdFirstOut, chg.FwdHead, chg.FwdWeight, r.FwdFirstOut, r.FwdHead, r.FwdWeight, chg.FwdMiddle, r.FwdMiddle},
+				{"backward", chg.BwdFirstOut, chg.BwdHead, chg.BwdWeight, r.BwdFirstOut, r.BwdHead, r.BwdWeight, chg.BwdMiddle, r.BwdMiddle},
+			} {
+				type edge struct {
+					head, weight uint32
+					middle       int32
+				}
+				var want, got []edge
+				for e := up.first[v]; e < up.first[v+1]; e++ {
+					m := up.middle[e]
+					if m >= 0 {
+						m = int32(rank[m])
+					}
+					want = append(want, edge{rank[up.head[e]], up.weight[e], m})
+				}
+				for e := up.newFirst[u]; e < up.newFirst[u+1]; e++ {
+					got = append(got, edge{up.newHead[e], up.newWeight[e], up.newMiddle[e]})
+				}
+				byHead := func(a, b edge) int { return cmp.Compare(a.head, b.head) }
+				if !slices.IsSortedFunc(got, byHead) {
+					t.Errorf("%s edges of %d are not sorted by head", up.name, u)
+				}
+				slices.SortStableFunc(want, byHead)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s edges of %d: got %v, want %v", up.name, u, got, want)
+				}
+			}
+		}
+		if r.OrigGraph().NumEdges != g.NumEdges {
+			t.Errorf("%d original edges, want %d", r.OrigGraph().NumEdges, g.NumEdges)
+		}
+	}
+
+	chg := ch.Contract(g)
+	chg.Rank = nil
+	if _, err := graph.RenumberByRank(chg); err == nil {
+		t.Error("accepted a hierarchy without ranks")
+	}
+}
@@ -1,6 +1,10 @@
 package graph
 
-import "log"
+import (
+	"log"
+
+	"github.com/paulmach/osm"
+)
 
 // addSat returns a+b, saturating at the uint32 max instead of wrapping. Guards
 // the safety-critical distance accumulation in the local Dijkstras below.
@@ -33,7 +37,8 @@ const maxRestrictedPenaltyFactor = 50.0
 // destinations — matching Google's behavior — without becoming cut-throughs.
 // Clusters with ≤1 gateway are always inlined at normal weight (no pair to
 // shortcut). Public edges are always kept. The returned graph carries no
-// restricted flags. Note the reported route distance is measured from geometry,
+// restricted flags; its EdgePenalty records each edge's factor when any
+// cluster is penalized. Note the reported route distance is measured from geometry,
 // not weight, so penalties never distort distances.
 //
 // If g.EdgeRestricted is nil, g is returned unchanged.
@@ -45,18 +50,18 @@ func FilterBridgingRestricted(g *Graph) *Graph {
 
 	isPublic := make([]bool, n)
 	inRestricted := make([]bool, n)
-	uf := NewUnionFind(n)
 	for u := uint32(0); u < n; u++ {
 		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
 			v := g.Head[e]
 			if g.EdgeRestricted[e] {
 				inRestricted[u], inRestricted[v] = true, true
-				uf.Union(u, v)
 			} else {
 				isPublic[u], isPublic[v] = true, true
 			}
 		}
 	}
+	uf := NewUnionFind(n)
+	uf.UnionEdges(g, func(e uint32) bool { return g.EdgeRestricted[e] })
 
 	gateways := make(map[uint32][]uint32)
 	for u := uint32(0); u < n; u++ {
@@ -93,22 +98,27 @@ func FilterBridgingRestricted(g *Graph) *Graph {
 	// carry their cluster's penalty factor.
 	hasGeo := g.GeoFirstOut != nil
 	firstOut := make([]uint32, n+1)
-	var head, weight, geoFirstOut []uint32
-	var geoLat, geoLon []float64
+	var head, weight, geoFirstOut, edgeName []uint32
+	var geoLat, geoLon []Coord
+	var edgeLimits []VehicleLimits
+	var edgePenalty []float32
+	if nPenalized+nCapPenalized > 0 {
+		edgePenalty = make([]float32, 0, g.NumEdges)
+	}
+	var edgeAttrs []EdgeAttr
+	var edgeWayID []osm.WayID
 	for u := uint32(0); u < n; u++ {
 		firstOut[u] = uint32(len(head))
 		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
-			w := g.Weight[e]
+			w, f := g.Weight[e], 1.0
 			if g.EdgeRestricted[e] {
-				if f := clusterFactor[uf.Find(u)]; f > 1.0 {
-					scaled := float64(w) * f
-					if scaled >= float64(^uint32(0)) {
-						w = ^uint32(0)
-					} else {
-						w = uint32(scaled)
-					}
+				if cf := clusterFactor[uf.Find(u)]; cf > 1.0 {
+					w, f = penalize(w, cf), cf
 				}
 			}
+			if edgePenalty != nil {
+				edgePenalty = append(edgePenalty, float32(f))
+			}
 			if hasGeo {
 				geoFirstOut = append(geoFirstOut, uint32(len(geoLat)))
 				gs, ge := g.GeoFirstOut[e], g.GeoFirstOut[e+1]
@@ -117,6 +127,18 @@ func FilterBridgingRestricted(g *Graph) *Graph {
 			}
 			head = append(head, g.Head[e])
 			weight = append(weight, w)
+			if g.EdgeName != nil {
+				edgeName = append(edgeName, g.EdgeName[e])
+			}
+			if g.EdgeLimits != nil {
+				edgeLimits = append(edgeLimits, g.EdgeLimits[e])
+			}
+			if g.EdgeAttr != nil {
+				edgeAttrs = append(edgeAttrs, g.EdgeAttr[e])
+			}
+			if g.EdgeWayID != nil {
+				edgeWayID = append(edgeWayID, g.EdgeWayID[e])
+			}
 		}
 	}
 	firstOut[n] = uint32(len(head))
@@ -135,10 +157,29 @@ func FilterBridgingRestricted(g *Graph) *Graph {
 		GeoFirstOut: geoFirstOut,
 		GeoShapeLat: geoLat,
 		GeoShapeLon: geoLon,
+		EdgeName:    edgeName,
+		Names:       g.Names,
+		EdgeAttr:    edgeAttrs,
+		EdgeLimits:  edgeLimits,
+		EdgePenalty: edgePenalty,
+		NodeOSMID:   g.NodeOSMID,
+		EdgeWayID:   edgeWayID,
+		// Every edge keeps its index, so restrictions carry over as is.
+		TurnRestrictions: g.TurnRestrictions,
 		// EdgeRestricted intentionally nil — survivors are ordinary edges.
 	}
 }
 
+// penalize returns weight w multiplied by factor f, saturating at the uint32
+// max.
+func penalize(w uint32, f float64) uint32 {
+	scaled := float64(w) * f
+	if scaled >= float64(^uint32(0)) {
+		return ^uint32(0)
+	}
+	return uint32(scaled)
+}
+
 // clusterPenaltyFactor returns the smallest weight multiplier that makes every
 // through-cluster path at least as slow as its public alternative (1.0 when the
 // cluster is not a shortcut at all), capped at maxRestrictedPenaltyFactor.
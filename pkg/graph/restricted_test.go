@@ -16,7 +16,7 @@ func hasEdgeByLon(g *Graph, fromLon, toLon float64) bool {
 func edgeWeightByLon(g *Graph, fromLon, toLon float64) uint32 {
 	for u := uint32(0); u < g.NumNodes; u++ {
 		for e := g.FirstOut[u]; e < g.FirstOut[u+1]; e++ {
-			if g.NodeLon[u] == fromLon && g.NodeLon[g.Head[e]] == toLon {
+			if g.NodeLon[u].Deg() == fromLon && g.NodeLon[g.Head[e]].Deg() == toLon {
 				return g.Weight[e]
 			}
 		}
@@ -135,3 +135,27 @@ func TestFilterKeepsSlowMultiGateEstate(t *testing.T) {
 		t.Error("public chain must be preserved")
 	}
 }
+
+func TestFilterPenalizesBothMetrics(t *testing.T) {
+	raw := Build(interiorFastBridgeParse())
+	lengths := raw.DistanceWeights()
+	g := FilterBridgingRestricted(raw)
+	g = FilterToComponent(g, LargestComponent(g))
+	if g.EdgePenalty == nil {
+		t.Fatal("no edge penalties recorded for a penalized cluster")
+	}
+	// Neither the filter nor the component filter reorders these edges.
+	dist := g.DistanceWeights()
+	for e := range g.NumEdges {
+		f := float64(g.EdgePenalty[e])
+		if restricted := raw.EdgeRestricted[e]; restricted != (f > 1) {
+			t.Fatalf("edge %d: restricted %v, penalty %.2f", e, restricted, f)
+		}
+		if want := penalize(raw.Weight[e], f); g.Weight[e] != want {
+			t.Errorf("edge %d: time weight %d, want %d", e, g.Weight[e], want)
+		}
+		if want := penalize(lengths[e], f); dist[e] != want {
+			t.Errorf("edge %d: distance weight %d, want %d", e, dist[e], want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Snap index section (header flag flagSnapRTree), after the OSM ID section:
+//
+//	[uint32 Fanout][uint32 numEntries][uint32 numLevels]
+//	Levels [numLevels+1]uint32
+//	Edge   [numEntries]uint32
+//	MinLat, MinLon, MaxLat, MaxLon [Levels[numLevels]]Coord
+//
+// It is written whenever the graph carries a SnapIndex (preprocess
+// --snap-index).
+
+// maxSnapFanout bounds the children of a tree node on load.
+const maxSnapFanout = 1024
+
+// SnapIndex is the packed R-tree the router snaps query points with (see
+// routing.BuildSnapIndex), kept with the graph so a server can load it
+// instead of building it. The router decides the order of the entries; the
+// graph package only stores and validates the index. An entry holds just its
+// edge: the router finds the edge's source node in the graph.
+//
+// The tree is stored level by level in the box arrays, from the entries up
+// to the root: level l holds boxes Levels[l]..Levels[l+1], and box i of a
+// level above the entries covers boxes Fanout*i up to Fanout*(i+1) of the
+// level below. Each level has a box per Fanout boxes of the one below,
+// rounded up, and the top level has a single box (none without entries).
+type SnapIndex struct {
+	Fanout uint32   // children per tree node
+	Levels []uint32 // start of each level in the box arrays, and their end
+
+	Edge []uint32 // original edge of each entry, one per edge
+
+	// Bounding boxes: the entries' polylines first, then the tree nodes.
+	MinLat, MinLon, MaxLat, MaxLon []Coord
+}
+
+func writeSnapIndexSection(w io.Writer, idx *SnapIndex) error {
+	hdr := struct {
+		Fanout, NumEntries, NumLevels uint32
+	}{idx.Fanout, uint32(len(idx.Edge)), uint32(len(idx.Levels) - 1)}
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return fmt.Errorf("write SnapIndex: %w", err)
+	}
+	for _, s := range [][]uint32{idx.Levels, idx.Edge} {
+		if err := writeUint32Slice(w, s); err != nil {
+			return fmt.Errorf("write SnapIndex: %w", err)
+		}
+	}
+	for _, s := range [][]Coord{idx.MinLat, idx.MinLon, idx.MaxLat, idx.MaxLon} {
+		if err := writeCoordSlice(w, s); err != nil {
+			return fmt.Errorf("write SnapIndex: %w", err)
+		}
+	}
+	return nil
+}
+
+// readSnapIndexSection reads the snap index and checks it against the
+// original graph, so a damaged index cannot send the router out of bounds.
+func readSnapIndexSection(r io.Reader, chg *CHGraph) (*SnapIndex, error) {
+	var hdr struct {
+		Fanout, NumEntries, NumLevels uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("read SnapIndex: %w", err)
+	}
+	// Each level above the entries is at most half the one below, so a
+	// valid tree over 2^32 entries has no more than 33 levels.
+	if hdr.Fanout < 2 || hdr.Fanout > maxSnapFanout || int(hdr.NumEntries) > len(chg.OrigHead) || hdr.NumLevels < 1 || hdr.NumLevels > 33 {
+		return nil, fmt.Errorf("read SnapIndex: invalid header %+v", hdr)
+	}
+	var err error
+	idx := &SnapIndex{Fanout: hdr.Fanout}
+	if idx.Levels, err = readUint32Slice(r, int(hdr.NumLevels)+1); err != nil {
+		return nil, fmt.Errorf("read SnapIndex: %w", err)
+	}
+	// The level sizes follow from the entry count; check them before
+	// reading the boxes they size.
+	size, start := uint64(hdr.NumEntries), uint64(0)
+	for l := range hdr.NumLevels {
+		if uint64(idx.Levels[l]) != start || uint64(idx.Levels[l+1]) != start+size {
+			return nil, fmt.Errorf("SnapIndex: level %d spans %d..%d, want %d boxes from %d", l, idx.Levels[l], idx.Levels[l+1], size, start)
+		}
+		start += size
+		size = (size + uint64(hdr.Fanout) - 1) / uint64(hdr.Fanout)
+	}
+	if top := idx.Levels[hdr.NumLevels] - idx.Levels[hdr.NumLevels-1]; top > 1 {
+		return nil, fmt.Errorf("SnapIndex: %d boxes at the top level, want one", top)
+	}
+	if idx.Edge, err = readUint32Slice(r, int(hdr.NumEntries)); err != nil {
+		return nil, fmt.Errorf("read SnapIndex: %w", err)
+	}
+	for _, s := range []*[]Coord{&idx.MinLat, &idx.MinLon, &idx.MaxLat, &idx.MaxLon} {
+		if *s, err = readCoordSlice(r, int(start)); err != nil {
+			return nil, fmt.Errorf("read SnapIndex: %w", err)
+		}
+	}
+
+	seen := make([]bool, len(chg.OrigHead))
+	for i, e := range idx.Edge {
+		if int(e) >= len(seen) || seen[e] {
+			return nil, fmt.Errorf("SnapIndex: entry %d: edge %d out of range or repeated", i, e)
+		}
+		seen[e] = true
+	}
+	return idx, nil
+}
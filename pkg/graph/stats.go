@@ -0,0 +1,199 @@
+package graph
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"unsafe"
+)
+
+// maxDegreeBucket is the last bucket of GraphStats' degree distributions,
+// counting nodes of that degree or more.
+const maxDegreeBucket = 16
+
+// GraphStats summarizes a graph's shape and size; see Stats.
+type GraphStats struct {
+	Nodes, Edges uint32
+
+	// OutDegree[d] and InDegree[d] count the nodes with d outgoing or
+	// incoming edges, the last entry those with maxDegreeBucket or more;
+	// entries past the largest degree are left out.
+	OutDegree, InDegree []uint32
+
+	// WeightHistogram[i] counts the edges whose weight needs i bits: entry 0
+	// weight 0, entry 1 weight 1, entry i weights 2^(i-1) to 2^i-1. Entries
+	// past the largest weight are left out.
+	WeightHistogram          []uint32
+	MinWeight, MaxWeight     uint32
+	MedianWeight, MeanWeight float64
+
+	ShapePoints      int    // total shape points, edge endpoints excluded
+	StraightEdges    uint32 // edges without shape points
+	MaxEdgePoints    uint32 // most shape points on one edge
+	NamedEdges       uint32 // edges with a name (EdgeName > 0)
+	DistinctNames    int    // entries of Names, the unnamed entry included
+	TurnRestrictions int    // turn restrictions
+
+	Memory MemoryStats
+}
+
+// MemoryStats estimates the memory a graph's arrays take, in bytes, by
+// group. It counts slice lengths, not capacities or the allocator's
+// overhead.
+type MemoryStats struct {
+	Topology   int64 // FirstOut, Head, Weight
+	Nodes      int64 // NodeLat, NodeLon
+	Geometry   int64 // GeoFirstOut, GeoShapeLat, GeoShapeLon
+	Attributes int64 // EdgeName, Names, EdgeAttr, EdgeLimits, EdgePenalty, EdgeRestricted, TurnRestrictions
+	OSMIDs     int64 // NodeOSMID, EdgeWayID
+}
+
+// Total returns the sum of the groups.
+func (m MemoryStats) Total() int64 {
+	return m.Topology + m.Nodes + m.Geometry + m.Attributes + m.OSMIDs
+}
+
+// Stats computes g's statistics. It reads every array a few times but
+// allocates little beyond a per-node in-degree count.
+func Stats(g *Graph) *GraphStats {
+	s := &GraphStats{
+		Nodes:            g.NumNodes,
+		Edges:            g.NumEdges,
+		DistinctNames:    len(g.Names),
+		TurnRestrictions: len(g.TurnRestrictions),
+	}
+	bucket := func(d uint32) uint32 { return min(d, maxDegreeBucket) }
+	out := make([]uint32, maxDegreeBucket+1)
+	in := make([]uint32, maxDegreeBucket+1)
+	indeg := make([]uint32, g.NumNodes)
+	for _, v := range g.Head {
+		indeg[v]++
+	}
+	for u := range g.NumNodes {
+		out[bucket(g.FirstOut[u+1]-g.FirstOut[u])]++
+		in[bucket(indeg[u])]++
+	}
+	s.OutDegree, s.InDegree = trimZeros(out), trimZeros(in)
+
+	if len(g.Weight) > 0 {
+		var hist [33]uint32
+		var sum uint64
+		s.MinWeight = g.Weight[0]
+		for _, w := range g.Weight {
+			hist[bits.Len32(w)]++
+			sum += uint64(w)
+			s.MinWeight, s.MaxWeight = min(s.MinWeight, w), max(s.MaxWeight, w)
+		}
+		s.WeightHistogram = trimZeros(hist[:])
+		s.MeanWeight = float64(sum) / float64(len(g.Weight))
+		s.MedianWeight = medianUint32(g.Weight)
+	}
+
+	s.ShapePoints = len(g.GeoShapeLat)
+	for e := range g.NumEdges {
+		n := uint32(0)
+		if g.GeoFirstOut != nil {
+			n = g.GeoFirstOut[e+1] - g.GeoFirstOut[e]
+		}
+		if n == 0 {
+			s.StraightEdges++
+		}
+		s.MaxEdgePoints = max(s.MaxEdgePoints, n)
+		if g.EdgeName != nil && g.EdgeName[e] > 0 {
+			s.NamedEdges++
+		}
+	}
+
+	m := &s.Memory
+	m.Topology = bytesOf(g.FirstOut) + bytesOf(g.Head) + bytesOf(g.Weight)
+	m.Nodes = bytesOf(g.NodeLat) + bytesOf(g.NodeLon)
+	m.Geometry = bytesOf(g.GeoFirstOut) + bytesOf(g.GeoShapeLat) + bytesOf(g.GeoShapeLon)
+	m.Attributes = bytesOf(g.EdgeName) + bytesOf(g.Names) + bytesOf(g.EdgeAttr) +
+		bytesOf(g.EdgeLimits) + bytesOf(g.EdgePenalty) + bytesOf(g.EdgeRestricted) + bytesOf(g.TurnRestrictions)
+	for _, n := range g.Names {
+		m.Attributes += int64(len(n.Name) + len(n.Ref))
+	}
+	for _, tr := range g.TurnRestrictions {
+		m.Attributes += bytesOf(tr.Edges)
+	}
+	m.OSMIDs = bytesOf(g.NodeOSMID) + bytesOf(g.EdgeWayID)
+	return s
+}
+
+// String summarizes s in a few lines, for logs.
+func (s *GraphStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d nodes, %d edges, %d turn restrictions\n", s.Nodes, s.Edges, s.TurnRestrictions)
+	fmt.Fprintf(&b, "out-degree: %s\n", degreeString(s.OutDegree))
+	fmt.Fprintf(&b, "in-degree: %s\n", degreeString(s.InDegree))
+	fmt.Fprintf(&b, "weights: min %d, median %.0f, mean %.0f, max %d\n", s.MinWeight, s.MedianWeight, s.MeanWeight, s.MaxWeight)
+	fmt.Fprintf(&b, "geometry: %d shape points, %d straight edges, at most %d points on an edge\n", s.ShapePoints, s.StraightEdges, s.MaxEdgePoints)
+	fmt.Fprintf(&b, "names: %d named edges, %d distinct names\n", s.NamedEdges, s.DistinctNames)
+	m := s.Memory
+	fmt.Fprintf(&b, "memory: %s (topology %s, nodes %s, geometry %s, attributes %s, OSM IDs %s)",
+		mib(m.Total()), mib(m.Topology), mib(m.Nodes), mib(m.Geometry), mib(m.Attributes), mib(m.OSMIDs))
+	return b.String()
+}
+
+// degreeString lists a degree distribution as "degree:count" pairs.
+func degreeString(counts []uint32) string {
+	var parts []string
+	for d, n := range counts {
+		label := fmt.Sprint(d)
+		if d == maxDegreeBucket {
+			label += "+"
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d", label, n))
+	}
+	return strings.Join(parts, " ")
+}
+
+func mib(n int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// bytesOf returns the size of s's elements.
+func bytesOf[T any](s []T) int64 {
+	var zero T
+	return int64(len(s)) * int64(unsafe.Sizeof(zero))
+}
+
+// trimZeros drops s's trailing zero entries.
+func trimZeros(s []uint32) []uint32 {
+	n := len(s)
+	for n > 0 && s[n-1] == 0 {
+		n--
+	}
+	return s[:n]
+}
+
+// medianUint32 returns the median of s, by counting rather than sorting so
+// s is left alone and no copy is made: one pass per 16-bit half.
+func medianUint32(s []uint32) float64 {
+	kth := func(k int) uint32 {
+		var counts [1 << 16]int
+		for _, w := range s {
+			counts[w>>16]++
+		}
+		hi := 0
+		for ; k >= counts[hi]; hi++ {
+			k -= counts[hi]
+		}
+		clear(counts[:])
+		for _, w := range s {
+			if int(w>>16) == hi {
+				counts[w&0xffff]++
+			}
+		}
+		lo := 0
+		for ; k >= counts[lo]; lo++ {
+			k -= counts[lo]
+		}
+		return uint32(hi)<<16 | uint32(lo)
+	}
+	n := len(s)
+	if n%2 == 1 {
+		return float64(kth(n / 2))
+	}
+	return (float64(kth(n/2-1)) + float64(kth(n/2))) / 2
+}
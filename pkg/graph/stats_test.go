@@ -0,0 +1,63 @@
+package graph_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestStats(t *testing.T) {
+	// Edges 0->1, 0->3, 1->0, 1->2, 2->1, 3->0 weighing 100, 300, 100, 200,
+	// 200, 300.
+	s := graph.Stats(buildTestCH(t).OrigGraph())
+	if s.Nodes != 4 || s.Edges != 6 {
+		t.Fatalf("Stats counts %d nodes, %d edges, want 4, 6", s.Nodes, s.Edges)
+	}
+	if want := []uint32{0, 2, 2}; !reflect.DeepEqual(s.OutDegree, want) || !reflect.DeepEqual(s.InDegree, want) {
+		t.Errorf("degrees out %v, in %v, want %v for both", s.OutDegree, s.InDegree, want)
+	}
+	// 100 needs 7 bits, 200 8 and 300 9.
+	if want := []uint32{0, 0, 0, 0, 0, 0, 0, 2, 2, 2}; !reflect.DeepEqual(s.WeightHistogram, want) {
+		t.Errorf("WeightHistogram = %v, want %v", s.WeightHistogram, want)
+	}
+	if s.MinWeight != 100 || s.MaxWeight != 300 || s.MedianWeight != 200 || s.MeanWeight != 200 {
+		t.Errorf("weights min %d, median %g, mean %g, max %d, want 100, 200, 200, 300",
+			s.MinWeight, s.MedianWeight, s.MeanWeight, s.MaxWeight)
+	}
+	if s.StraightEdges != 6 || s.ShapePoints != 0 {
+		t.Errorf("%d straight edges, %d shape points, want 6, 0", s.StraightEdges, s.ShapePoints)
+	}
+	if s.Memory.Topology != (5+6+6)*4 || s.Memory.Nodes != 2*4*4 {
+		t.Errorf("memory %+v, want topology 68 and nodes 32 bytes", s.Memory)
+	}
+	if !strings.Contains(s.String(), "out-degree: 0:0 1:2 2:2") {
+		t.Errorf("String() =\n%s", s)
+	}
+
+	// Medians past 16 bits, bends and high degrees.
+	b := graph.NewBuilder()
+	hub := b.AddNode(1, 103)
+	for i, w := range []uint32{70000, 5, 131072, 70001, 70000, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9} {
+		v := b.AddNode(1.001, 103+float64(i)*0.001)
+		if _, err := b.AddEdge(hub, v, w, graph.LatLng{Lat: 1.0005, Lng: 103}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s = graph.Stats(b.Freeze())
+	if s.MedianWeight != 9 || s.MaxEdgePoints != 1 || s.ShapePoints != 18 {
+		t.Errorf("median %g, %d points at most, %d in all, want 9, 1, 18", s.MedianWeight, s.MaxEdgePoints, s.ShapePoints)
+	}
+	if len(s.OutDegree) != 17 || s.OutDegree[16] != 1 || s.OutDegree[0] != 18 {
+		t.Errorf("OutDegree = %v, want 18 sinks and the hub in the 16+ bucket", s.OutDegree)
+	}
+	b = graph.NewBuilder()
+	u, v := b.AddNode(0, 0), b.AddNode(0, 1)
+	for _, w := range []uint32{70000, 131072, 65536} {
+		b.AddEdge(u, v, w)
+	}
+	if s := graph.Stats(b.Freeze()); s.MedianWeight != 70000 {
+		t.Errorf("median of 70000, 131072, 65536 = %g", s.MedianWeight)
+	}
+}
@@ -0,0 +1,703 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// Tiled graphs.
+//
+// A tiled file stores the original (uncontracted) graph partitioned into a
+// grid of cells so that a server can load only the region it serves out of a
+// country- or continent-scale graph:
+//
+//	[tiledHeader]
+//	[cell block][uint32 CRC32]...   one per non-empty cell, in cell order
+//	[boundary block][uint32 CRC32]  edges whose endpoints lie in different cells
+//	[index][uint32 CRC32]           names, cell entries, boundary entry
+//	[metadata footer]
+//
+// Nodes are renumbered so that each cell's nodes are contiguous (cell c owns
+// global nodes FirstNode..FirstNode+NumNodes). A cell block holds its nodes'
+// coordinates and the CSR of the edges between them, with heads local to the
+// cell; the boundary block holds every other edge with global endpoints,
+// sorted by source. Both carry weights, geometry and, with tileAttrs, names
+// and attributes:
+//
+//	cell:     [uint32 m] NodeLat, NodeLon [n]Coord  FirstOut [n+1]  edges
+//	boundary: [uint32 m] From [m]                                  edges
+//	edges:    Head [m]  Weight [m]  GeoFirstOut [m+1]  ShapeLat, ShapeLon
+//	          [GeoFirstOut[m]]Coord  (tileAttrs: EdgeName [m]  EdgeAttr [m])
+//
+// The index and boundary are read when the file is opened; cell blocks are
+// read on demand by Region and LoadCells, each verified by its own CRC32.
+// The result is a plain Graph, to be contracted by the caller.
+const (
+	tiledMagic   = "MPRTILED"
+	tiledVersion = uint32(1)
+
+	// tileAttrs: blocks carry EdgeName and EdgeAttr, and the index a name table.
+	tileAttrs = uint32(1) << 0
+
+	// DefaultTileSize is the default cell edge length in degrees, about 28 km
+	// at the equator.
+	DefaultTileSize = 0.25
+	maxTileCells    = 1 << 24
+)
+
+// tiledHeader is the header of a tiled file. IndexOffset and IndexLength are
+// filled in once the blocks are written.
+type tiledHeader struct {
+	Magic       [8]byte
+	Version     uint32
+	Flags       uint32
+	NumNodes    uint32
+	NumEdges    uint32
+	Rows        uint32
+	Cols        uint32
+	OriginLat   Coord // south-west corner of cell 0
+	OriginLon   Coord
+	CellSize    Coord
+	IndexOffset uint64
+	IndexLength uint64
+}
+
+// tileEntry locates one block. NumNodes is 0 for empty cells and the
+// boundary block.
+type tileEntry struct {
+	Offset    uint64
+	Length    uint64
+	FirstNode uint32
+	NumNodes  uint32
+}
+
+// tileEdges are the edges of one block in storage order.
+type tileEdges struct {
+	head, weight       []uint32
+	geoFirstOut        []uint32
+	shapeLat, shapeLon []Coord
+	name               []uint32
+	attr               []EdgeAttr
+}
+
+func newTileEdges() *tileEdges {
+	return &tileEdges{geoFirstOut: []uint32{0}}
+}
+
+// add appends edge e of g with the given head.
+func (t *tileEdges) add(g *Graph, e, head uint32) {
+	t.head = append(t.head, head)
+	t.weight = append(t.weight, g.Weight[e])
+	if g.GeoFirstOut != nil {
+		s, end := g.GeoFirstOut[e], g.GeoFirstOut[e+1]
+		t.shapeLat = append(t.shapeLat, g.GeoShapeLat[s:end]...)
+		t.shapeLon = append(t.shapeLon, g.GeoShapeLon[s:end]...)
+	}
+	t.geoFirstOut = append(t.geoFirstOut, uint32(len(t.shapeLat)))
+	if g.EdgeAttr != nil {
+		t.attr = append(t.attr, g.EdgeAttr[e])
+		name := uint32(0)
+		if g.EdgeName != nil {
+			name = g.EdgeName[e]
+		}
+		t.name = append(t.name, name)
+	}
+}
+
+// copyEdge appends edge i of src with the given head.
+func (t *tileEdges) copyEdge(src *tileEdges, i, head uint32) {
+	t.head = append(t.head, head)
+	t.weight = append(t.weight, src.weight[i])
+	s, end := src.geoFirstOut[i], src.geoFirstOut[i+1]
+	t.shapeLat = append(t.shapeLat, src.shapeLat[s:end]...)
+	t.shapeLon = append(t.shapeLon, src.shapeLon[s:end]...)
+	t.geoFirstOut = append(t.geoFirstOut, uint32(len(t.shapeLat)))
+	if src.attr != nil {
+		t.name = append(t.name, src.name[i])
+		t.attr = append(t.attr, src.attr[i])
+	}
+}
+
+func (t *tileEdges) write(w io.Writer, attrs bool) error {
+	for _, s := range [][]uint32{t.head, t.weight, t.geoFirstOut} {
+		if err := writeUint32Slice(w, s); err != nil {
+			return err
+		}
+	}
+	for _, s := range [][]Coord{t.shapeLat, t.shapeLon} {
+		if err := writeCoordSlice(w, s); err != nil {
+			return err
+		}
+	}
+	if !attrs {
+		return nil
+	}
+	if err := writeUint32Slice(w, t.name); err != nil {
+		return err
+	}
+	return writeEdgeAttrs(w, t.attr)
+}
+
+func readTileEdges(r io.Reader, m int, attrs bool) (*tileEdges, error) {
+	t := &tileEdges{}
+	var err error
+	if t.head, err = readUint32Slice(r, m); err != nil {
+		return nil, fmt.Errorf("read Head: %w", err)
+	}
+	if t.weight, err = readUint32Slice(r, m); err != nil {
+		return nil, fmt.Errorf("read Weight: %w", err)
+	}
+	if t.geoFirstOut, err = readUint32Slice(r, m+1); err != nil {
+		return nil, fmt.Errorf("read GeoFirstOut: %w", err)
+	}
+	for i := 1; i <= m; i++ {
+		if t.geoFirstOut[i] < t.geoFirstOut[i-1] {
+			return nil, fmt.Errorf("GeoFirstOut not monotonic at %d", i)
+		}
+	}
+	numShape := int(t.geoFirstOut[m])
+	if t.geoFirstOut[0] != 0 || numShape > maxPoints {
+		return nil, fmt.Errorf("%d shape points out of range", numShape)
+	}
+	if t.shapeLat, err = readCoordSlice(r, numShape); err != nil {
+		return nil, fmt.Errorf("read shape points: %w", err)
+	}
+	if t.shapeLon, err = readCoordSlice(r, numShape); err != nil {
+		return nil, fmt.Errorf("read shape points: %w", err)
+	}
+	if !attrs {
+		return t, nil
+	}
+	if t.name, err = readUint32Slice(r, m); err != nil {
+		return nil, fmt.Errorf("read EdgeName: %w", err)
+	}
+	if t.attr, err = readEdgeAttrs(r, m); err != nil {
+		return nil, fmt.Errorf("read EdgeAttr: %w", err)
+	}
+	return t, nil
+}
+
+// WriteTiled writes the graph g partitioned into square cells of cellDeg
+// degrees (DefaultTileSize when <= 0). Names and attributes are kept when g
+// carries EdgeAttr; CH data is not stored, since a region must be contracted
+// on its own. meta, if non-nil, is written as the metadata footer.
+func WriteTiled(path string, g *Graph, cellDeg float64, meta *Metadata) error {
+	if cellDeg <= 0 {
+		cellDeg = DefaultTileSize
+	}
+	if g.NumNodes == 0 {
+		return fmt.Errorf("cannot tile an empty graph")
+	}
+	if err := checkBounds("tiled", g.NumNodes, int(g.NumEdges)); err != nil {
+		return err
+	}
+	hdr := tiledHeader{Version: tiledVersion, NumNodes: g.NumNodes, NumEdges: g.NumEdges, CellSize: ToCoord(cellDeg)}
+	copy(hdr.Magic[:], tiledMagic)
+	if hdr.CellSize <= 0 {
+		return fmt.Errorf("tile size %g is below coordinate precision", cellDeg)
+	}
+	attrs := g.EdgeAttr != nil
+	if attrs {
+		hdr.Flags |= tileAttrs
+	}
+
+	// Lay the grid over the nodes' bounding box.
+	hdr.OriginLat, hdr.OriginLon = g.NodeLat[0], g.NodeLon[0]
+	maxLat, maxLon := hdr.OriginLat, hdr.OriginLon
+	for u := range g.NumNodes {
+		hdr.OriginLat, maxLat = min(hdr.OriginLat, g.NodeLat[u]), max(maxLat, g.NodeLat[u])
+		hdr.OriginLon, maxLon = min(hdr.OriginLon, g.NodeLon[u]), max(maxLon, g.NodeLon[u])
+	}
+	hdr.Rows = uint32((int64(maxLat)-int64(hdr.OriginLat))/int64(hdr.CellSize)) + 1
+	hdr.Cols = uint32((int64(maxLon)-int64(hdr.OriginLon))/int64(hdr.CellSize)) + 1
+	numCells := int(hdr.Rows) * int(hdr.Cols)
+	if numCells > maxTileCells {
+		return fmt.Errorf("%dx%d cells exceed limit %d; use a larger tile size", hdr.Rows, hdr.Cols, maxTileCells)
+	}
+
+	// Renumber the nodes cell by cell (counting sort, stable in node order).
+	cellOf := make([]uint32, g.NumNodes)
+	cells := make([]tileEntry, numCells)
+	for u := range g.NumNodes {
+		r := (int64(g.NodeLat[u]) - int64(hdr.OriginLat)) / int64(hdr.CellSize)
+		c := (int64(g.NodeLon[u]) - int64(hdr.OriginLon)) / int64(hdr.CellSize)
+		cellOf[u] = uint32(r)*hdr.Cols + uint32(c)
+		cells[cellOf[u]].NumNodes++
+	}
+	next := make([]uint32, numCells)
+	var first uint32
+	for c := range cells {
+		cells[c].FirstNode, next[c] = first, first
+		first += cells[c].NumNodes
+	}
+	newID := make([]uint32, g.NumNodes)
+	order := make([]uint32, g.NumNodes)
+	for u := range g.NumNodes {
+		newID[u] = next[cellOf[u]]
+		order[newID[u]] = u
+		next[cellOf[u]]++
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(tmpPath) // clean up on error
+	}()
+	if err := binary.Write(f, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	offset := uint64(binary.Size(hdr))
+
+	// writeBlock writes one block and its CRC32, returning its length.
+	writeBlock := func(write func(w io.Writer) error) (uint64, error) {
+		cw := &crc32Writer{w: f, hash: crc32.NewIEEE()}
+		cnt := &countingWriter{w: cw}
+		if err := write(cnt); err != nil {
+			return 0, err
+		}
+		if err := binary.Write(f, binary.LittleEndian, cw.hash.Sum32()); err != nil {
+			return 0, err
+		}
+		return uint64(cnt.n) + 4, nil
+	}
+
+	var boundaryFrom []uint32
+	boundary := newTileEdges()
+	for c := range cells {
+		e := &cells[c]
+		if e.NumNodes == 0 {
+			continue
+		}
+		nodes := order[e.FirstNode : e.FirstNode+e.NumNodes]
+		lat, lon := make([]Coord, len(nodes)), make([]Coord, len(nodes))
+		firstOut := make([]uint32, 1, len(nodes)+1)
+		edges := newTileEdges()
+		for i, u := range nodes {
+			lat[i], lon[i] = g.NodeLat[u], g.NodeLon[u]
+			start, end := g.EdgesFrom(u)
+			for ei := start; ei < end; ei++ {
+				v := g.Head[ei]
+				if cellOf[v] == uint32(c) {
+					edges.add(g, ei, newID[v]-e.FirstNode)
+				} else {
+					boundaryFrom = append(boundaryFrom, newID[u])
+					boundary.add(g, ei, newID[v])
+				}
+			}
+			firstOut = append(firstOut, uint32(len(edges.head)))
+		}
+		n, err := writeBlock(func(w io.Writer) error {
+			if err := binary.Write(w, binary.LittleEndian, uint32(len(edges.head))); err != nil {
+				return err
+			}
+			if err := writeCoordSlice(w, lat); err != nil {
+				return err
+			}
+			if err := writeCoordSlice(w, lon); err != nil {
+				return err
+			}
+			if err := writeUint32Slice(w, firstOut); err != nil {
+				return err
+			}
+			return edges.write(w, attrs)
+		})
+		if err != nil {
+			return fmt.Errorf("write cell %d: %w", c, err)
+		}
+		e.Offset, e.Length = offset, n
+		offset += n
+	}
+
+	bEntry := tileEntry{Offset: offset}
+	bEntry.Length, err = writeBlock(func(w io.Writer) error {
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(boundaryFrom))); err != nil {
+			return err
+		}
+		if err := writeUint32Slice(w, boundaryFrom); err != nil {
+			return err
+		}
+		return boundary.write(w, attrs)
+	})
+	if err != nil {
+		return fmt.Errorf("write boundary: %w", err)
+	}
+	offset += bEntry.Length
+
+	// The index is hashed together with the header, which is only final once
+	// the index length is known.
+	var index bytes.Buffer
+	if attrs {
+		if err := writeNames(&index, g.Names); err != nil {
+			return err
+		}
+	}
+	_ = binary.Write(&index, binary.LittleEndian, cells)
+	_ = binary.Write(&index, binary.LittleEndian, &bEntry)
+	hdr.IndexOffset, hdr.IndexLength = offset, uint64(index.Len())+4
+	var hdrBuf bytes.Buffer
+	_ = binary.Write(&hdrBuf, binary.LittleEndian, &hdr)
+	crc := crc32.NewIEEE()
+	crc.Write(index.Bytes())
+	crc.Write(hdrBuf.Bytes())
+	if _, err := f.Write(index.Bytes()); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := binary.Write(f, binary.LittleEndian, crc.Sum32()); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	if _, err := f.WriteAt(hdrBuf.Bytes(), 0); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	if err := writeMetaFooter(f, footerMeta(meta, g.NodeLat, g.NodeLon)); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// TiledGraph is an open tiled file. Its index and boundary edges are held in
+// memory; cells are read from the file on demand. Close releases the file.
+type TiledGraph struct {
+	f   *os.File
+	src *io.SectionReader
+	hdr tiledHeader
+
+	cells        []tileEntry
+	names        []RoadName
+	boundaryFrom []uint32
+	boundary     *tileEdges
+
+	// Meta is the file's build metadata (see CHGraph.Meta).
+	Meta *Metadata
+}
+
+// OpenTiled opens a tiled file written by WriteTiled and reads its index and
+// boundary edges.
+func OpenTiled(path string) (*TiledGraph, error) {
+	f, src, err := openSection(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &TiledGraph{f: f, src: src}
+	if err := t.readIndex(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Close closes the underlying file.
+func (t *TiledGraph) Close() error {
+	return t.f.Close()
+}
+
+// NumNodes returns the node count of the whole graph.
+func (t *TiledGraph) NumNodes() uint32 { return t.hdr.NumNodes }
+
+// NumEdges returns the edge count of the whole graph.
+func (t *TiledGraph) NumEdges() uint32 { return t.hdr.NumEdges }
+
+// NumCells returns the number of grid cells, empty ones included.
+func (t *TiledGraph) NumCells() int { return len(t.cells) }
+
+func (t *TiledGraph) attrs() bool { return t.hdr.Flags&tileAttrs != 0 }
+
+func (t *TiledGraph) readIndex() error {
+	hdr := &t.hdr
+	if err := binary.Read(t.src, binary.LittleEndian, hdr); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if string(hdr.Magic[:]) != tiledMagic {
+		return fmt.Errorf("invalid tiled magic bytes: %q", hdr.Magic)
+	}
+	if hdr.Version != tiledVersion {
+		return fmt.Errorf("unsupported tiled version: %d", hdr.Version)
+	}
+	if hdr.Flags&^tileAttrs != 0 {
+		return fmt.Errorf("unknown tiled flags: %#x", hdr.Flags&^tileAttrs)
+	}
+	if hdr.NumNodes > maxNodes || hdr.NumEdges > maxEdges {
+		return fmt.Errorf("header counts out of range: %d nodes, %d edges", hdr.NumNodes, hdr.NumEdges)
+	}
+	numCells := uint64(hdr.Rows) * uint64(hdr.Cols)
+	if numCells == 0 || numCells > maxTileCells || hdr.CellSize <= 0 {
+		return fmt.Errorf("grid of %dx%d cells of %d out of range", hdr.Rows, hdr.Cols, hdr.CellSize)
+	}
+	if hdr.IndexOffset+hdr.IndexLength > uint64(t.src.Size()) || hdr.IndexLength < 4 {
+		return fmt.Errorf("index at %d+%d out of range", hdr.IndexOffset, hdr.IndexLength)
+	}
+
+	crc := crc32.NewIEEE()
+	ir := io.NewSectionReader(t.src, int64(hdr.IndexOffset), int64(hdr.IndexLength)-4)
+	r := io.TeeReader(ir, crc)
+	var err error
+	if t.attrs() {
+		if t.names, err = readNames(r); err != nil {
+			return err
+		}
+	}
+	t.cells = make([]tileEntry, numCells)
+	if err := binary.Read(r, binary.LittleEndian, t.cells); err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+	var bEntry tileEntry
+	if err := binary.Read(r, binary.LittleEndian, &bEntry); err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+	var stored uint32
+	if err := binary.Read(io.NewSectionReader(t.src, int64(hdr.IndexOffset+hdr.IndexLength)-4, 4), binary.LittleEndian, &stored); err != nil {
+		return fmt.Errorf("read index CRC32: %w", err)
+	}
+	_ = binary.Write(crc, binary.LittleEndian, hdr)
+	if got := crc.Sum32(); got != stored {
+		return fmt.Errorf("index CRC32 mismatch: stored=%08x computed=%08x", stored, got)
+	}
+
+	var next uint32
+	for c := range t.cells {
+		e := &t.cells[c]
+		if e.FirstNode != next || e.NumNodes > hdr.NumNodes-next {
+			return fmt.Errorf("cell %d: nodes %d+%d out of order", c, e.FirstNode, e.NumNodes)
+		}
+		if e.NumNodes > 0 && e.Offset+e.Length > hdr.IndexOffset {
+			return fmt.Errorf("cell %d: block out of range", c)
+		}
+		next += e.NumNodes
+	}
+	if next != hdr.NumNodes {
+		return fmt.Errorf("cells hold %d nodes, header says %d", next, hdr.NumNodes)
+	}
+
+	if err := t.readBoundary(bEntry); err != nil {
+		return fmt.Errorf("boundary: %w", err)
+	}
+	if t.Meta, err = loadMeta(t.src, hdr.Version); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *TiledGraph) readBoundary(e tileEntry) error {
+	r, verify, err := t.block(e)
+	if err != nil {
+		return err
+	}
+	var m uint32
+	if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+		return err
+	}
+	if m > t.hdr.NumEdges {
+		return fmt.Errorf("%d edges out of range", m)
+	}
+	if t.boundaryFrom, err = readUint32Slice(r, int(m)); err != nil {
+		return fmt.Errorf("read From: %w", err)
+	}
+	if t.boundary, err = readTileEdges(r, int(m), t.attrs()); err != nil {
+		return err
+	}
+	if err := verify(); err != nil {
+		return err
+	}
+	for i, from := range t.boundaryFrom {
+		if from >= t.hdr.NumNodes || t.boundary.head[i] >= t.hdr.NumNodes {
+			return fmt.Errorf("edge %d: %d->%d out of range", i, from, t.boundary.head[i])
+		}
+		if i > 0 && from < t.boundaryFrom[i-1] {
+			return fmt.Errorf("edges not sorted by source at %d", i)
+		}
+	}
+	return checkEdgeNames(t.boundary.name, len(t.names))
+}
+
+// block returns a reader over block e and a function that verifies the block's
+// CRC32 once it has been read.
+func (t *TiledGraph) block(e tileEntry) (io.Reader, func() error, error) {
+	if e.Length < 4 || e.Offset+e.Length > uint64(t.src.Size()) {
+		return nil, nil, fmt.Errorf("block at %d+%d out of range", e.Offset, e.Length)
+	}
+	sr := io.NewSectionReader(t.src, int64(e.Offset), int64(e.Length)-4)
+	cr := &crc32Reader{r: sr, hash: crc32.NewIEEE()}
+	verify := func() error {
+		if pos, _ := sr.Seek(0, io.SeekCurrent); pos != sr.Size() {
+			return fmt.Errorf("%d trailing bytes", sr.Size()-pos)
+		}
+		var stored uint32
+		if err := binary.Read(io.NewSectionReader(t.src, int64(e.Offset+e.Length)-4, 4), binary.LittleEndian, &stored); err != nil {
+			return fmt.Errorf("read CRC32: %w", err)
+		}
+		if got := cr.hash.Sum32(); got != stored {
+			return fmt.Errorf("CRC32 mismatch: stored=%08x computed=%08x", stored, got)
+		}
+		return nil
+	}
+	return cr, verify, nil
+}
+
+// tileCell is one loaded cell.
+type tileCell struct {
+	lat, lon []Coord
+	firstOut []uint32
+	edges    *tileEdges
+}
+
+func (t *TiledGraph) readCell(c int) (*tileCell, error) {
+	e := t.cells[c]
+	r, verify, err := t.block(e)
+	if err != nil {
+		return nil, err
+	}
+	var m uint32
+	if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+		return nil, err
+	}
+	if m > t.hdr.NumEdges {
+		return nil, fmt.Errorf("%d edges out of range", m)
+	}
+	cell := &tileCell{}
+	n := int(e.NumNodes)
+	if cell.lat, err = readCoordSlice(r, n); err != nil {
+		return nil, fmt.Errorf("read NodeLat: %w", err)
+	}
+	if cell.lon, err = readCoordSlice(r, n); err != nil {
+		return nil, fmt.Errorf("read NodeLon: %w", err)
+	}
+	if cell.firstOut, err = readUint32Slice(r, n+1); err != nil {
+		return nil, fmt.Errorf("read FirstOut: %w", err)
+	}
+	if cell.edges, err = readTileEdges(r, int(m), t.attrs()); err != nil {
+		return nil, err
+	}
+	if err := verify(); err != nil {
+		return nil, err
+	}
+	if err := validateCSR(cell.firstOut, cell.edges.head, e.NumNodes); err != nil {
+		return nil, err
+	}
+	if err := checkEdgeNames(cell.edges.name, len(t.names)); err != nil {
+		return nil, err
+	}
+	return cell, nil
+}
+
+// CellsIn returns the non-empty cells that intersect b, in ascending order.
+func (t *TiledGraph) CellsIn(b BBox) []int {
+	h := &t.hdr
+	// span works in Coords, like the cell assignment in WriteTiled.
+	span := func(lo, hi float64, origin Coord, n uint32) (int64, int64) {
+		size := int64(h.CellSize)
+		first := floorDiv(int64(ToCoord(lo))-int64(origin), size)
+		last := floorDiv(int64(ToCoord(hi))-int64(origin), size)
+		return max(first, 0), min(last, int64(n)-1)
+	}
+	r0, r1 := span(b.MinLat, b.MaxLat, h.OriginLat, h.Rows)
+	c0, c1 := span(b.MinLon, b.MaxLon, h.OriginLon, h.Cols)
+	var cells []int
+	for r := r0; r <= r1; r++ {
+		for c := c0; c <= c1; c++ {
+			if id := int(r)*int(h.Cols) + int(c); t.cells[id].NumNodes > 0 {
+				cells = append(cells, id)
+			}
+		}
+	}
+	return cells
+}
+
+// floorDiv is a / b rounded towards negative infinity, for b > 0.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b < 0 {
+		q--
+	}
+	return q
+}
+
+// Region loads the cells intersecting b (see LoadCells). Nodes outside b but
+// in a cell it touches are included.
+func (t *TiledGraph) Region(b BBox) (*Graph, error) {
+	return t.LoadCells(t.CellsIn(b))
+}
+
+// LoadCells reads the given cells and returns the graph of their nodes: the
+// edges within each cell plus the boundary edges between loaded cells. Nodes
+// are numbered in cell order; edges leading out of the loaded cells are
+// dropped, so the result may not be strongly connected near its rim.
+func (t *TiledGraph) LoadCells(cells []int) (*Graph, error) {
+	cells = append([]int(nil), cells...)
+	sort.Ints(cells)
+
+	// subFirst[i] is the first node of cells[i] in the result.
+	subFirst := make([]uint32, len(cells)+1)
+	for i, c := range cells {
+		if c < 0 || c >= len(t.cells) {
+			return nil, fmt.Errorf("cell %d out of range", c)
+		}
+		if i > 0 && c == cells[i-1] {
+			return nil, fmt.Errorf("cell %d listed twice", c)
+		}
+		subFirst[i+1] = subFirst[i] + t.cells[c].NumNodes
+	}
+	// toSub maps a global node to the result, or false when its cell is not loaded.
+	toSub := func(v uint32) (uint32, bool) {
+		i := sort.Search(len(cells), func(i int) bool {
+			e := t.cells[cells[i]]
+			return e.FirstNode+e.NumNodes > v
+		})
+		if i == len(cells) || v < t.cells[cells[i]].FirstNode {
+			return 0, false
+		}
+		return subFirst[i] + v - t.cells[cells[i]].FirstNode, true
+	}
+
+	n := subFirst[len(cells)]
+	g := &Graph{NumNodes: n, FirstOut: make([]uint32, 1, n+1)}
+	g.NodeLat, g.NodeLon = make([]Coord, 0, n), make([]Coord, 0, n)
+	out := newTileEdges()
+	for i, c := range cells {
+		if t.cells[c].NumNodes == 0 {
+			continue
+		}
+		cell, err := t.readCell(c)
+		if err != nil {
+			return nil, fmt.Errorf("cell %d: %w", c, err)
+		}
+		g.NodeLat = append(g.NodeLat, cell.lat...)
+		g.NodeLon = append(g.NodeLon, cell.lon...)
+
+		first := t.cells[c].FirstNode
+		b := sort.Search(len(t.boundaryFrom), func(j int) bool { return t.boundaryFrom[j] >= first })
+		for u := range uint32(len(cell.lat)) {
+			for e := cell.firstOut[u]; e < cell.firstOut[u+1]; e++ {
+				out.copyEdge(cell.edges, e, subFirst[i]+cell.edges.head[e])
+			}
+			for ; b < len(t.boundaryFrom) && t.boundaryFrom[b] == first+u; b++ {
+				if v, ok := toSub(t.boundary.head[b]); ok {
+					out.copyEdge(t.boundary, uint32(b), v)
+				}
+			}
+			g.FirstOut = append(g.FirstOut, uint32(len(out.head)))
+		}
+	}
+
+	g.NumEdges = uint32(len(out.head))
+	g.Head, g.Weight = out.head, out.weight
+	g.GeoFirstOut, g.GeoShapeLat, g.GeoShapeLon = out.geoFirstOut, out.shapeLat, out.shapeLon
+	if t.attrs() {
+		g.EdgeName, g.Names, g.EdgeAttr = out.name, t.names, out.attr
+	}
+	return g, nil
+}
@@ -0,0 +1,118 @@
+package graph_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// edgeSet describes every edge of g by its endpoints, weight, shape and
+// attributes, independent of node numbering.
+func edgeSet(g *graph.Graph) []string {
+	var edges []string
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			v := g.Head[e]
+			shape := g.GeoShapeLat[g.GeoFirstOut[e]:g.GeoFirstOut[e+1]]
+			edges = append(edges, fmt.Sprintf("%d,%d->%d,%d w=%d shape=%v attr=%+v",
+				g.NodeLat[u], g.NodeLon[u], g.NodeLat[v], g.NodeLon[v], g.Weight[e], shape, g.EdgeAttribute(e)))
+		}
+	}
+	slices.Sort(edges)
+	return edges
+}
+
+func writeTestTiles(t *testing.T) (string, *graph.Graph) {
+	t.Helper()
+	g := buildFootCH(t).OrigGraph()
+	path := filepath.Join(t.TempDir(), "tiles.bin")
+	// 0.15° cells put nodes 10 and 20 in one cell and 30, 40, 50 in three others.
+	if err := graph.WriteTiled(path, g, 0.15, &graph.Metadata{Profiles: []string{"foot"}}); err != nil {
+		t.Fatalf("WriteTiled: %v", err)
+	}
+	return path, g
+}
+
+func TestTiledRegion(t *testing.T) {
+	path, g := writeTestTiles(t)
+	tg, err := graph.OpenTiled(path)
+	if err != nil {
+		t.Fatalf("OpenTiled: %v", err)
+	}
+	defer tg.Close()
+	if tg.NumNodes() != g.NumNodes || tg.NumEdges() != g.NumEdges || tg.NumCells() != 9 {
+		t.Fatalf("got %d nodes, %d edges, %d cells; want %d, %d, 9", tg.NumNodes(), tg.NumEdges(), tg.NumCells(), g.NumNodes, g.NumEdges)
+	}
+	if tg.Meta == nil || !slices.Equal(tg.Meta.Profiles, []string{"foot"}) {
+		t.Errorf("Meta = %+v", tg.Meta)
+	}
+
+	// The whole extent reassembles the original graph.
+	all, err := tg.Region(graph.BBox{MinLat: 0, MinLon: 100, MaxLat: 2, MaxLon: 105})
+	if err != nil {
+		t.Fatalf("Region: %v", err)
+	}
+	if got, want := edgeSet(all), edgeSet(g); !slices.Equal(got, want) {
+		t.Errorf("full region edges:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(all.Names) != len(g.Names) {
+		t.Errorf("Names: got %d, want %d", len(all.Names), len(g.Names))
+	}
+
+	// The south-west corner holds nodes 10 and 20 and the two edges between them.
+	sw, err := tg.Region(graph.BBox{MinLat: 0.9, MinLon: 102.9, MaxLat: 1.05, MaxLon: 103.05})
+	if err != nil {
+		t.Fatalf("Region: %v", err)
+	}
+	if sw.NumNodes != 2 || sw.NumEdges != 2 {
+		t.Fatalf("corner region: %d nodes, %d edges; want 2, 2", sw.NumNodes, sw.NumEdges)
+	}
+
+	// Two adjacent cells are joined by the boundary edges between them.
+	cells := tg.CellsIn(graph.BBox{MinLat: 1.2, MinLon: 103.2, MaxLat: 1.3, MaxLon: 103.2})
+	if len(cells) != 2 {
+		t.Fatalf("CellsIn: got %v, want 2 cells", cells)
+	}
+	ne, err := tg.LoadCells(cells)
+	if err != nil {
+		t.Fatalf("LoadCells: %v", err)
+	}
+	if ne.NumNodes != 2 || ne.NumEdges != 2 {
+		t.Errorf("nodes 30 and 50: %d nodes, %d edges; want 2, 2", ne.NumNodes, ne.NumEdges)
+	}
+	if cells := tg.CellsIn(graph.BBox{MinLat: 5, MinLon: 5, MaxLat: 6, MaxLon: 6}); len(cells) != 0 {
+		t.Errorf("CellsIn outside the grid: %v", cells)
+	}
+}
+
+// TestTiledCorruptCell checks that a damaged cell is only detected, by its own
+// CRC32, when it is loaded.
+func TestTiledCorruptCell(t *testing.T) {
+	path, _ := writeTestTiles(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[64] ^= 0xff // inside the first cell block, after the header
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tg, err := graph.OpenTiled(path)
+	if err != nil {
+		t.Fatalf("OpenTiled: %v", err)
+	}
+	defer tg.Close()
+	if _, err := tg.Region(graph.BBox{MinLat: 1.25, MinLon: 103.25, MaxLat: 1.3, MaxLon: 103.3}); err != nil {
+		t.Errorf("undamaged cell: %v", err)
+	}
+	if _, err := tg.Region(graph.BBox{MinLat: 1.0, MinLon: 103.0, MaxLat: 1.0, MaxLon: 103.0}); err == nil || !strings.Contains(err.Error(), "CRC32") {
+		t.Errorf("damaged cell: err = %v, want CRC32 mismatch", err)
+	}
+}
@@ -0,0 +1,261 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// TTFPeriod is the period of travel time functions: a day, in milliseconds.
+const TTFPeriod = 24 * 60 * 60 * 1000
+
+// ttfEpsilon is the slack, in milliseconds, within which TTF comparisons
+// treat times as equal, absorbing floating-point error in linked TTFs.
+const ttfEpsilon = 1e-6
+
+// TTFPoint is a breakpoint of a TTF: leaving At milliseconds into the
+// period takes Travel milliseconds.
+type TTFPoint struct {
+	At, Travel float64
+}
+
+// TTF is a travel time function: the travel time of an edge, or a path, by
+// departure time. It is linear between breakpoints, which are sorted by At
+// in [0, TTFPeriod), and repeats every TTFPeriod, the last breakpoint
+// running on to the first of the next period; a single breakpoint is a
+// constant. Times are float64 since linking puts breakpoints between
+// milliseconds. TTFs are FIFO: leaving later never arrives earlier.
+type TTF []TTFPoint
+
+// ConstantTTF returns the TTF of an edge that always takes w milliseconds.
+func ConstantTTF(w uint32) TTF {
+	return TTF{{At: 0, Travel: float64(w)}}
+}
+
+// Validate checks that f is a TTF: breakpoints in order within the period,
+// with non-negative travel times, and FIFO.
+func (f TTF) Validate() error {
+	if len(f) == 0 {
+		return fmt.Errorf("no breakpoints")
+	}
+	for i, p := range f {
+		switch {
+		case p.At < 0 || p.At >= TTFPeriod || (i > 0 && p.At <= f[i-1].At):
+			return fmt.Errorf("breakpoint %d at %v is out of order", i, p.At)
+		case p.Travel < 0 || math.IsNaN(p.Travel) || math.IsInf(p.Travel, 0):
+			return fmt.Errorf("breakpoint %d has travel time %v", i, p.Travel)
+		}
+		if next, at := f.next(i); at+next.Travel < p.At+p.Travel-ttfEpsilon {
+			return fmt.Errorf("breakpoint %d to %d arrives earlier for leaving later", i, (i+1)%len(f))
+		}
+	}
+	return nil
+}
+
+// next returns the breakpoint after f[i] and its time, a period on from
+// f[0] when i is the last.
+func (f TTF) next(i int) (TTFPoint, float64) {
+	if i+1 < len(f) {
+		return f[i+1], f[i+1].At
+	}
+	return f[0], f[0].At + TTFPeriod
+}
+
+// Eval returns the travel time of leaving at t, in milliseconds from the
+// start of any period.
+func (f TTF) Eval(t float64) float64 {
+	if len(f) == 1 {
+		return f[0].Travel
+	}
+	t = math.Mod(t, TTFPeriod)
+	if t < 0 {
+		t += TTFPeriod
+	}
+	i := sort.Search(len(f), func(i int) bool { return f[i].At > t }) - 1
+	if i < 0 {
+		i = len(f) - 1
+		t += TTFPeriod
+	}
+	p := f[i]
+	next, at := f.next(i)
+	return p.Travel + (next.Travel-p.Travel)*(t-p.At)/(at-p.At)
+}
+
+// Min returns f's least travel time.
+func (f TTF) Min() float64 {
+	m := math.Inf(1)
+	for _, p := range f {
+		m = min(m, p.Travel)
+	}
+	return m
+}
+
+// Max returns f's greatest travel time.
+func (f TTF) Max() float64 {
+	m := math.Inf(-1)
+	for _, p := range f {
+		m = max(m, p.Travel)
+	}
+	return m
+}
+
+// AtMost reports whether f never takes longer than g. Both are linear
+// between their breakpoints, so comparing at those is enough.
+func (f TTF) AtMost(g TTF) bool {
+	for _, p := range f {
+		if p.Travel > g.Eval(p.At)+ttfEpsilon {
+			return false
+		}
+	}
+	for _, p := range g {
+		if f.Eval(p.At) > p.Travel+ttfEpsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// LinkTTF returns the TTF of taking f then g: leaving at t takes f(t) and
+// then g(t + f(t)). Its breakpoints are f's and the departures that reach
+// g's breakpoints, between which both parts are linear. FIFO makes the
+// arrivals climb with the departures, so one walk through f's segments,
+// keeping pace through g's breakpoints, finds them in order.
+func LinkTTF(f, g TTF) TTF {
+	h := make(TTF, 0, len(f)+len(g))
+
+	// b is g's breakpoint j, base periods on, the first after the arrival
+	// of leaving at f[0].
+	start := f[0].At + f[0].Travel
+	base := TTFPeriod * math.Floor(start/TTFPeriod)
+	j := sort.Search(len(g), func(j int) bool { return g[j].At+base > start })
+	if j == len(g) {
+		j, base = 0, base+TTFPeriod
+	}
+	for i, p := range f {
+		next, t1 := f.next(i)
+		a0, a1 := p.At+p.Travel, t1+next.Travel
+
+		// a0 lies between b and the breakpoint before it.
+		b, pj, pbase := g[j].At+base, j-1, base
+		if pj < 0 {
+			pj, pbase = len(g)-1, base-TTFPeriod
+		}
+		bp := g[pj].At + pbase
+		h = append(h, TTFPoint{At: p.At, Travel: p.Travel + g[pj].Travel + (g[j].Travel-g[pj].Travel)*(a0-bp)/(b-bp)})
+
+		for ; b < a1; b = g[j].At + base {
+			if b > a0 {
+				t := p.At + (b-a0)*(t1-p.At)/(a1-a0)
+				h = append(h, TTFPoint{At: t, Travel: b - t + g[j].Travel})
+			}
+			if j++; j == len(g) {
+				j, base = 0, base+TTFPeriod
+			}
+		}
+	}
+
+	// The last segment runs into the next period: its departures there
+	// come first.
+	wrap := sort.Search(len(h), func(i int) bool { return h[i].At >= TTFPeriod })
+	for i := wrap; i < len(h); i++ {
+		h[i].At -= TTFPeriod
+	}
+	h = slices.Concat(h[wrap:], h[:wrap])
+
+	out := h[:0]
+	for _, p := range h {
+		if len(out) == 0 || p.At-out[len(out)-1].At > ttfEpsilon {
+			out = append(out, p)
+		}
+	}
+	return out.simplify()
+}
+
+// MinTTF returns the TTF of taking the faster of f and g: its breakpoints
+// are theirs and the times where they cross.
+func MinTTF(f, g TTF) TTF {
+	at := make([]float64, 0, 2*(len(f)+len(g)))
+	for _, p := range f {
+		at = append(at, p.At)
+	}
+	for _, p := range g {
+		at = append(at, p.At)
+	}
+	slices.Sort(at)
+	at = slices.Compact(at)
+	var cross []float64
+	for i, t0 := range at {
+		t1 := TTFPeriod + at[0]
+		if i+1 < len(at) {
+			t1 = at[i+1]
+		}
+		d0, d1 := f.Eval(t0)-g.Eval(t0), f.Eval(t1)-g.Eval(t1)
+		if (d0 < 0 && d1 > 0) || (d0 > 0 && d1 < 0) {
+			cross = append(cross, math.Mod(t0+(t1-t0)*d0/(d0-d1), TTFPeriod))
+		}
+	}
+	at = append(at, cross...)
+	slices.Sort(at)
+
+	h := make(TTF, 0, len(at))
+	for _, t := range at {
+		if len(h) > 0 && t-h[len(h)-1].At <= ttfEpsilon {
+			continue
+		}
+		h = append(h, TTFPoint{At: t, Travel: min(f.Eval(t), g.Eval(t))})
+	}
+	return h.simplify()
+}
+
+// simplify drops the breakpoints that lie on the line between their
+// neighbours, in place.
+func (f TTF) simplify() TTF {
+	if f.Max()-f.Min() <= ttfEpsilon {
+		return f[:1]
+	}
+	out := f[:1]
+	for i := 1; i < len(f); i++ {
+		prev := out[len(out)-1]
+		next, at := f.next(i)
+		line := prev.Travel + (next.Travel-prev.Travel)*(f[i].At-prev.At)/(at-prev.At)
+		if math.Abs(line-f[i].Travel) > ttfEpsilon {
+			out = append(out, f[i])
+		}
+	}
+	return out
+}
+
+// TDEdge is an edge of a time-dependent contraction hierarchy: an original
+// edge, or a shortcut whose TTF is the fastest of the ways in Via.
+type TDEdge struct {
+	From, To uint32
+	TTF      TTF
+	Via      []TDVia // nil for an original edge
+}
+
+// TDVia is a way a shortcut can go: edge First then edge Second, or the
+// original edge First alone when Second is -1.
+type TDVia struct {
+	First, Second int32
+}
+
+// TDCHGraph is a time-dependent contraction hierarchy (see
+// ch.ContractTimeDependent). Unlike a CHGraph it is held in memory only:
+// preprocess does not build it and it cannot be written with the graph, so
+// it is for library users who contract their own travel time functions.
+type TDCHGraph struct {
+	NumNodes uint32
+	Rank     []uint32
+
+	// Edges are the graph's edges, indexed as in it, then the shortcuts.
+	// A shortcut takes the place of the edges it is the fastest of, so the
+	// upward graphs join two nodes by one edge at most.
+	Edges []TDEdge
+
+	// The upward edges as indices into Edges: those leaving each node for
+	// higher ones, FwdEdge[FwdFirstOut[v]:FwdFirstOut[v+1]], and those
+	// reaching each node from higher ones, likewise in BwdEdge.
+	FwdFirstOut, FwdEdge []uint32
+	BwdFirstOut, BwdEdge []uint32
+}
@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomTTF returns a FIFO TTF of n breakpoints taking between lo and hi.
+func randomTTF(rng *rand.Rand, n int, lo, hi float64) TTF {
+	for {
+		f := make(TTF, 0, n)
+		at := rng.Float64() * TTFPeriod / float64(n)
+		for range n {
+			f = append(f, TTFPoint{At: at, Travel: lo + (hi-lo)*rng.Float64()})
+			at += TTFPeriod / float64(n) * (0.5 + rng.Float64()/2)
+		}
+		if f.Validate() == nil {
+			return f
+		}
+	}
+}
+
+func TestLinkAndMinTTF(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 0))
+	for i := range 200 {
+		f := randomTTF(rng, 1+rng.IntN(6), 1e5, 5e6)
+		g := randomTTF(rng, 1+rng.IntN(6), 1e5, 5e6)
+		link, fastest := LinkTTF(f, g), MinTTF(f, g)
+		for _, h := range []TTF{link, fastest} {
+			if err := h.Validate(); err != nil {
+				t.Fatalf("case %d: %v", i, err)
+			}
+		}
+		for range 50 {
+			at := rng.Float64() * 2 * TTFPeriod
+			if got, want := link.Eval(at), f.Eval(at)+g.Eval(at+f.Eval(at)); math.Abs(got-want) > 1e-6*want {
+				t.Fatalf("case %d: linked TTF takes %.3f at %.0f, want %.3f", i, got, at, want)
+			}
+			if got, want := fastest.Eval(at), min(f.Eval(at), g.Eval(at)); math.Abs(got-want) > 1e-6*want {
+				t.Fatalf("case %d: faster TTF takes %.3f at %.0f, want %.3f", i, got, at, want)
+			}
+		}
+		if !fastest.AtMost(f) || !fastest.AtMost(g) {
+			t.Errorf("case %d: the faster of two TTFs is slower than one", i)
+		}
+	}
+}
+
+func TestTTFValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		f    TTF
+	}{
+		{"empty", nil},
+		{"out of order", TTF{{At: 10, Travel: 1}, {At: 5, Travel: 1}}},
+		{"past the period", TTF{{At: TTFPeriod, Travel: 1}}},
+		{"negative travel", TTF{{At: 0, Travel: -1}}},
+		{"not FIFO", TTF{{At: 0, Travel: 1e7}, {At: 1000, Travel: 0}}},
+	} {
+		if tc.f.Validate() == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+	if err := (TTF{{At: 0, Travel: 1000}, {At: 1000, Travel: 1500}}).Validate(); err != nil {
+		t.Errorf("valid TTF: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+// Turn restriction section (header flag flagTurns), after the attributes
+// section:
+//
+//	[uint32 numRestrictions][uint32 numEdgeRefs]
+//	First [numRestrictions+1]uint32  restriction i is Edges[First[i]:First[i+1]]
+//	Edges [numEdgeRefs]uint32        original edge indexes
+//	Only  [numRestrictions]uint8     1 = only_*, 0 = no_*
+//
+// It is written whenever the graph carries restrictions.
+
+// TurnRestriction is an OSM turn restriction over original edges: the edge
+// arriving at the via node, the edges of the via way(s) if any, and the edge
+// leaving. Each edge ends where the next one starts, so a via-node
+// restriction has two edges and a via-way restriction more.
+type TurnRestriction struct {
+	Edges []uint32
+	Only  bool // the sequence is mandatory once its first edge is taken (only_*); otherwise it is forbidden (no_*)
+}
+
+// buildTurnRestrictions resolves the parser's node-path restrictions to edges
+// of a freshly built graph; nodes maps OSM node IDs to node indexes.
+// Restrictions over a movement the graph does not allow (a step against a
+// oneway) restrict nothing and are dropped.
+func buildTurnRestrictions(rs []osmparser.TurnRestriction, nodes *nodeIndex, g *Graph) []TurnRestriction {
+	var out []TurnRestriction
+next:
+	for _, r := range rs {
+		tr := TurnRestriction{Edges: make([]uint32, 0, len(r.Path)-1), Only: r.Only}
+		for i := 0; i+1 < len(r.Path); i++ {
+			u, ok1 := nodes.get(r.Path[i])
+			v, ok2 := nodes.get(r.Path[i+1])
+			if !ok1 || !ok2 {
+				continue next
+			}
+			e, ok := cheapestEdgeBetween(g, u, v)
+			if !ok {
+				continue next
+			}
+			tr.Edges = append(tr.Edges, e)
+		}
+		if len(tr.Edges) >= 2 {
+			out = append(out, tr)
+		}
+	}
+	return out
+}
+
+// cheapestEdgeBetween returns the lowest-weight edge from u to v.
+func cheapestEdgeBetween(g *Graph, u, v uint32) (uint32, bool) {
+	best, found := uint32(0), false
+	start, end := g.EdgesFrom(u)
+	for e := start; e < end; e++ {
+		if g.Head[e] == v && (!found || g.Weight[e] < g.Weight[best]) {
+			best, found = e, true
+		}
+	}
+	return best, found
+}
+
+// remapTurnRestrictions renumbers the edges of rs through newEdge, dropping
+// restrictions that lose an edge (newEdge reports false).
+func remapTurnRestrictions(rs []TurnRestriction, newEdge func(uint32) (uint32, bool)) []TurnRestriction {
+	var out []TurnRestriction
+next:
+	for _, r := range rs {
+		edges := make([]uint32, len(r.Edges))
+		for i, e := range r.Edges {
+			ne, ok := newEdge(e)
+			if !ok {
+				continue next
+			}
+			edges[i] = ne
+		}
+		out = append(out, TurnRestriction{Edges: edges, Only: r.Only})
+	}
+	return out
+}
+
+func writeTurnSection(w io.Writer, rs []TurnRestriction) error {
+	first := make([]uint32, len(rs)+1)
+	only := make([]uint8, len(rs))
+	var edges []uint32
+	for i, r := range rs {
+		first[i+1] = first[i] + uint32(len(r.Edges))
+		edges = append(edges, r.Edges...)
+		if r.Only {
+			only[i] = 1
+		}
+	}
+	if err := binary.Write(w, binary.LittleEndian, [2]uint32{uint32(len(rs)), first[len(rs)]}); err != nil {
+		return fmt.Errorf("write TurnRestrictions: %w", err)
+	}
+	if err := writeUint32Slice(w, first); err != nil {
+		return fmt.Errorf("write TurnRestrictions: %w", err)
+	}
+	if err := writeUint32Slice(w, edges); err != nil {
+		return fmt.Errorf("write TurnRestrictions: %w", err)
+	}
+	if _, err := w.Write(only); err != nil {
+		return fmt.Errorf("write TurnRestrictions: %w", err)
+	}
+	return nil
+}
+
+// readTurnSection reads the turn restriction section and checks it against
+// the original graph: every edge must exist and end where the next starts.
+func readTurnSection(r io.Reader, firstOut, head []uint32) ([]TurnRestriction, error) {
+	var counts [2]uint32
+	if err := binary.Read(r, binary.LittleEndian, &counts); err != nil {
+		return nil, fmt.Errorf("read TurnRestrictions: %w", err)
+	}
+	n, m := counts[0], counts[1]
+	if n > maxEdges || m > maxEdges {
+		return nil, fmt.Errorf("read TurnRestrictions: %d restrictions over %d edges exceed limit %d", n, m, maxEdges)
+	}
+	first, err := readUint32Slice(r, int(n)+1)
+	if err != nil {
+		return nil, fmt.Errorf("read TurnRestrictions: %w", err)
+	}
+	edges, err := readUint32Slice(r, int(m))
+	if err != nil {
+		return nil, fmt.Errorf("read TurnRestrictions: %w", err)
+	}
+	only := make([]uint8, n)
+	if _, err := io.ReadFull(r, only); err != nil {
+		return nil, fmt.Errorf("read TurnRestrictions: %w", err)
+	}
+
+	if first[0] != 0 || first[n] != m {
+		return nil, fmt.Errorf("TurnRestrictions: offsets span %d..%d, want 0..%d", first[0], first[n], m)
+	}
+	rs := make([]TurnRestriction, n)
+	for i := range rs {
+		if first[i+1] > m || first[i+1] < first[i]+2 {
+			return nil, fmt.Errorf("TurnRestrictions[%d]: offsets %d..%d of %d edges, want at least two edges", i, first[i], first[i+1], m)
+		}
+		rs[i] = TurnRestriction{Edges: edges[first[i]:first[i+1]:first[i+1]], Only: only[i] != 0}
+		for k, e := range rs[i].Edges {
+			if e >= uint32(len(head)) {
+				return nil, fmt.Errorf("TurnRestrictions[%d]: edge %d >= %d edges", i, e, len(head))
+			}
+			if k > 0 && edgeTail(firstOut, e) != head[rs[i].Edges[k-1]] {
+				return nil, fmt.Errorf("TurnRestrictions[%d]: edges %d and %d do not connect", i, rs[i].Edges[k-1], e)
+			}
+		}
+	}
+	return rs, nil
+}
+
+// edgeTail returns the node edge e leaves from.
+func edgeTail(firstOut []uint32, e uint32) uint32 {
+	return uint32(sort.Search(len(firstOut)-1, func(u int) bool { return firstOut[u+1] > e }))
+}
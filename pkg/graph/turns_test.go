@@ -0,0 +1,117 @@
+package graph_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+// buildTurnGraph builds 1-2-3-4 with a spur 2-5 that is one-way into 2, and a
+// separate component 7-8-9, with restrictions over each.
+func buildTurnGraph() *graph.Graph {
+	result := &osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 1, ToNodeID: 2, Weight: 100},
+			{FromNodeID: 2, ToNodeID: 1, Weight: 100},
+			{FromNodeID: 2, ToNodeID: 3, Weight: 100},
+			{FromNodeID: 3, ToNodeID: 2, Weight: 100},
+			{FromNodeID: 3, ToNodeID: 4, Weight: 100},
+			{FromNodeID: 4, ToNodeID: 3, Weight: 100},
+			{FromNodeID: 5, ToNodeID: 2, Weight: 100},
+			{FromNodeID: 7, ToNodeID: 8, Weight: 100},
+			{FromNodeID: 8, ToNodeID: 7, Weight: 100},
+			{FromNodeID: 8, ToNodeID: 9, Weight: 100},
+			{FromNodeID: 9, ToNodeID: 8, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{1: 1.0, 2: 1.1, 3: 1.2, 4: 1.3, 5: 1.1, 7: 2.0, 8: 2.1, 9: 2.2},
+		NodeLon: map[osm.NodeID]float64{1: 103.0, 2: 103.1, 3: 103.2, 4: 103.3, 5: 103.2, 7: 104.0, 8: 104.1, 9: 104.2},
+		Restrictions: []osmparser.TurnRestriction{
+			{ID: 1, Path: []osm.NodeID{1, 2, 3}},                // via node
+			{ID: 2, Path: []osm.NodeID{1, 2, 3, 4}, Only: true}, // via way 2-3
+			{ID: 3, Path: []osm.NodeID{3, 2, 5}},                // against the one-way spur
+			{ID: 4, Path: []osm.NodeID{7, 8, 9}},                // in the small component
+		},
+	}
+	return graph.Build(result)
+}
+
+// restrictionPaths returns the node coordinates each restriction runs
+// through, which survive renumbering.
+func restrictionPaths(firstOut, head []uint32, lat []graph.Coord, rs []graph.TurnRestriction) [][]graph.Coord {
+	tail := func(e uint32) uint32 {
+		u := uint32(0)
+		for firstOut[u+1] <= e {
+			u++
+		}
+		return u
+	}
+	var out [][]graph.Coord
+	for _, r := range rs {
+		path := []graph.Coord{lat[tail(r.Edges[0])]}
+		for _, e := range r.Edges {
+			path = append(path, lat[head[e]])
+		}
+		out = append(out, path)
+	}
+	return out
+}
+
+func TestTurnRestrictions(t *testing.T) {
+	g := buildTurnGraph()
+	lats := func(ls ...float64) []graph.Coord {
+		var cs []graph.Coord
+		for _, l := range ls {
+			cs = append(cs, graph.ToCoord(l))
+		}
+		return cs
+	}
+	want := [][]graph.Coord{lats(1.0, 1.1, 1.2), lats(1.0, 1.1, 1.2, 1.3), lats(2.0, 2.1, 2.2)}
+	if got := restrictionPaths(g.FirstOut, g.Head, g.NodeLat, g.TurnRestrictions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("built restrictions run %v, want %v (the one against the one-way dropped)", got, want)
+	}
+	if !g.TurnRestrictions[1].Only || g.TurnRestrictions[0].Only {
+		t.Errorf("Only = %v, %v, want false, true", g.TurnRestrictions[0].Only, g.TurnRestrictions[1].Only)
+	}
+
+	g = graph.FilterToComponent(graph.FilterBridgingRestricted(g), graph.LargestComponent(g))
+	want = want[:2]
+	if got := restrictionPaths(g.FirstOut, g.Head, g.NodeLat, g.TurnRestrictions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("filtered restrictions run %v, want %v", got, want)
+	}
+
+	original := ch.Contract(g)
+	path := filepath.Join(t.TempDir(), "turns.graph.bin")
+	if err := graph.WriteBinaryWith(path, original, graph.WriteOptions{Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	loaded, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.TurnRestrictions, original.TurnRestrictions) {
+		t.Errorf("TurnRestrictions = %+v, want %+v", loaded.TurnRestrictions, original.TurnRestrictions)
+	}
+	if err := loaded.VerifySections(); err != nil {
+		t.Errorf("VerifySections: %v", err)
+	}
+}
+
+func TestTurnRestrictionsRejectDisconnected(t *testing.T) {
+	chg := ch.Contract(buildTurnGraph())
+	// Edge 0 (1->2) followed by itself does not connect.
+	chg.TurnRestrictions = []graph.TurnRestriction{{Edges: []uint32{0, 0}}}
+	path := filepath.Join(t.TempDir(), "turns.graph.bin")
+	if err := graph.WriteBinaryWith(path, chg, graph.WriteOptions{}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	if _, err := graph.ReadBinary(path); err == nil || !strings.Contains(err.Error(), "do not connect") {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+}
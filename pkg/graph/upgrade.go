@@ -0,0 +1,61 @@
+package graph
+
+import "fmt"
+
+// Combined format versions and what ReadBinary does with each:
+//
+//	v1  CH upward graphs, geometry; no original-edge sections. Rejected: the
+//	    original graph needed for snapping cannot be recovered, so the file
+//	    must be rebuilt with preprocess.
+//	v2  adds the original edges (OrigFirstOut/OrigHead/OrigWeight); weights
+//	    are road length in millimetres. Read and converted to a v3 distance
+//	    graph (weights in centimetres) on load.
+//	v3  same layout; weights are travel time (ms), or distance (cm) for
+//	    shortest-distance graphs (Metadata.Metric says which).
+//	v4  v3 plus a header flags word: flagZstd (compressed sections),
+//	    flagAttrs (edge attributes section, see attrs.go), flagCoord32
+//	    (int32 Coords; without it coordinates are float64 degrees, converted
+//	    on load), flagSectionCRCs (per-section checksums, see checksum.go)
+//	    flagTurns (turn restriction section, see turns.go), flagOSMIDs
+//	    (OSM ID section, see osmids.go), flagSnapRTree (snap index
+//	    section, see snapindex.go) and flagLimits (vehicle limits section,
+//	    see limits.go).
+//
+// A converted graph reports the version it was read from in
+// Metadata.FormatVersion. Upgrade rewrites such a file in the current format
+// so the conversion is paid once.
+const (
+	versionNoOrig     = uint32(1)
+	versionDistanceMm = uint32(2)
+)
+
+// Upgrade reads a graph binary of any readable version and rewrites it to dst
+// in the current format. src and dst may be the same path; the file is
+// replaced atomically. OSM IDs in src are kept whatever opt.OSMIDs says.
+func Upgrade(src, dst string, opt WriteOptions) error {
+	chg, err := ReadBinary(src)
+	if err != nil {
+		return err
+	}
+	opt.OSMIDs = opt.OSMIDs || chg.NodeOSMID != nil
+	return WriteBinaryWith(dst, chg, opt)
+}
+
+// upgradeV2 converts the millimetre distance weights of a v2 graph to the
+// centimetres of a v3 distance graph. Each weight is rounded on its own, so
+// a shortcut may differ from the sum of its parts by a centimetre or so.
+func upgradeV2(chg *CHGraph) {
+	for _, ws := range [][]uint32{chg.FwdWeight, chg.BwdWeight, chg.OrigWeight} {
+		for i, w := range ws {
+			ws[i] = max(uint32((uint64(w)+5)/10), 1)
+		}
+	}
+	if chg.Meta.Metric == "" {
+		chg.Meta.Metric = "distance"
+	}
+}
+
+// errVersionTooOld explains why a v1 graph cannot be loaded.
+func errVersionTooOld(v uint32) error {
+	return fmt.Errorf("graph format v%d predates the original-edge sections and cannot be upgraded; rebuild it with preprocess", v)
+}
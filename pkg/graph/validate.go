@@ -0,0 +1,267 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationReport is the result of Validate: the graph's problems by kind,
+// as the indexes of the nodes, edges or restrictions concerned. A report
+// without problems is OK.
+type ValidationReport struct {
+	// Structure lists array lengths that disagree with NumNodes/NumEdges and
+	// FirstOut bounds other than 0..NumEdges. When it is not empty nothing
+	// else is checked, and the graph cannot be repaired.
+	Structure []string
+
+	NonMonotonic      []uint32 // nodes u with FirstOut[u+1] < FirstOut[u]; not repairable
+	DanglingHeads     []uint32 // edges whose head is not a node
+	ZeroWeight        []uint32 // edges of weight 0, which searches cross for free
+	BadGeometry       []uint32 // edges whose GeoFirstOut range is inverted or past the shape arrays
+	OrphanShapePoints int      // shape points in no edge's range
+	BadNames          []uint32 // edges whose EdgeName is not an index of Names
+	BadRestrictions   []int    // turn restrictions over missing or unconnected edges
+}
+
+// OK reports whether Validate found no problems.
+func (r *ValidationReport) OK() bool {
+	return len(r.Structure) == 0 && len(r.NonMonotonic) == 0 && len(r.DanglingHeads) == 0 &&
+		len(r.ZeroWeight) == 0 && len(r.BadGeometry) == 0 && r.OrphanShapePoints == 0 &&
+		len(r.BadNames) == 0 && len(r.BadRestrictions) == 0
+}
+
+// Repairable reports whether Repair can fix the problems found.
+func (r *ValidationReport) Repairable() bool {
+	return len(r.Structure) == 0 && len(r.NonMonotonic) == 0
+}
+
+// String describes the problems one kind per line, with the first few
+// indexes of each.
+func (r *ValidationReport) String() string {
+	if r.OK() {
+		return "ok"
+	}
+	var b strings.Builder
+	for _, s := range r.Structure {
+		fmt.Fprintf(&b, "structure: %s\n", s)
+	}
+	list := func(what string, n int, idx func(i int) int) {
+		if n == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%d %s:", n, what)
+		for i := range min(n, 5) {
+			fmt.Fprintf(&b, " %d", idx(i))
+		}
+		if n > 5 {
+			b.WriteString(" ...")
+		}
+		b.WriteByte('\n')
+	}
+	u32 := func(s []uint32) func(int) int { return func(i int) int { return int(s[i]) } }
+	list("nodes with decreasing FirstOut", len(r.NonMonotonic), u32(r.NonMonotonic))
+	list("edges with dangling heads", len(r.DanglingHeads), u32(r.DanglingHeads))
+	list("zero-weight edges", len(r.ZeroWeight), u32(r.ZeroWeight))
+	list("edges with bad geometry ranges", len(r.BadGeometry), u32(r.BadGeometry))
+	list("edges with bad names", len(r.BadNames), u32(r.BadNames))
+	list("bad turn restrictions", len(r.BadRestrictions), func(i int) int { return r.BadRestrictions[i] })
+	if r.OrphanShapePoints > 0 {
+		fmt.Fprintf(&b, "%d orphan shape points\n", r.OrphanShapePoints)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Validate checks g's CSR invariants and the consistency of its optional
+// arrays, for graphs from sources other than Build (hand-built, converted,
+// or read from an untrusted file) and as a check before serving. It never
+// modifies g; see Repair.
+func Validate(g *Graph) *ValidationReport {
+	r := &ValidationReport{}
+	length := func(name string, n, want int, optional bool) {
+		if n != want && !(optional && n == 0) {
+			r.Structure = append(r.Structure, fmt.Sprintf("%s has %d entries, want %d", name, n, want))
+		}
+	}
+	nodes, edges := int(g.NumNodes), int(g.NumEdges)
+	length("FirstOut", len(g.FirstOut), nodes+1, false)
+	length("Head", len(g.Head), edges, false)
+	length("Weight", len(g.Weight), edges, true)
+	length("NodeLat", len(g.NodeLat), nodes, false)
+	length("NodeLon", len(g.NodeLon), nodes, false)
+	length("GeoFirstOut", len(g.GeoFirstOut), edges+1, true)
+	length("GeoShapeLon", len(g.GeoShapeLon), len(g.GeoShapeLat), false)
+	length("EdgeName", len(g.EdgeName), edges, true)
+	length("EdgeAttr", len(g.EdgeAttr), edges, true)
+	length("EdgeLimits", len(g.EdgeLimits), edges, true)
+	length("EdgePenalty", len(g.EdgePenalty), edges, true)
+	length("EdgeRestricted", len(g.EdgeRestricted), edges, true)
+	length("NodeOSMID", len(g.NodeOSMID), nodes, true)
+	length("EdgeWayID", len(g.EdgeWayID), edges, true)
+	if len(g.FirstOut) == nodes+1 && (g.FirstOut[0] != 0 || g.FirstOut[nodes] != g.NumEdges) {
+		r.Structure = append(r.Structure, fmt.Sprintf("FirstOut spans %d..%d, want 0..%d", g.FirstOut[0], g.FirstOut[nodes], edges))
+	}
+	if len(r.Structure) > 0 {
+		return r
+	}
+
+	for u := range g.NumNodes {
+		if g.FirstOut[u+1] < g.FirstOut[u] {
+			r.NonMonotonic = append(r.NonMonotonic, u)
+		}
+	}
+	var covered []bool
+	if g.GeoFirstOut != nil {
+		covered = make([]bool, len(g.GeoShapeLat))
+	}
+	for e := range g.NumEdges {
+		if g.Head[e] >= g.NumNodes {
+			r.DanglingHeads = append(r.DanglingHeads, e)
+		}
+		if g.Weight != nil && g.Weight[e] == 0 {
+			r.ZeroWeight = append(r.ZeroWeight, e)
+		}
+		if g.EdgeName != nil && int(g.EdgeName[e]) >= len(g.Names) {
+			r.BadNames = append(r.BadNames, e)
+		}
+		if g.GeoFirstOut != nil {
+			start, end := g.GeoFirstOut[e], g.GeoFirstOut[e+1]
+			if start > end || int(end) > len(covered) {
+				r.BadGeometry = append(r.BadGeometry, e)
+				continue
+			}
+			for i := start; i < end; i++ {
+				covered[i] = true
+			}
+		}
+	}
+	for _, c := range covered {
+		if !c {
+			r.OrphanShapePoints++
+		}
+	}
+	if g.GeoFirstOut == nil {
+		r.OrphanShapePoints = len(g.GeoShapeLat)
+	}
+
+	if len(r.NonMonotonic) == 0 {
+		for i, tr := range g.TurnRestrictions {
+			if !validRestriction(g, tr) {
+				r.BadRestrictions = append(r.BadRestrictions, i)
+			}
+		}
+	}
+	return r
+}
+
+// validRestriction reports whether tr's edges exist and each ends where the
+// next starts. FirstOut must be monotonic.
+func validRestriction(g *Graph, tr TurnRestriction) bool {
+	if len(tr.Edges) < 2 {
+		return false
+	}
+	for k, e := range tr.Edges {
+		if e >= g.NumEdges || k > 0 && edgeTail(g.FirstOut, e) != g.Head[tr.Edges[k-1]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Repair returns a copy of g with the problems Validate finds fixed: edges
+// with dangling heads and bad turn restrictions are dropped, zero weights
+// become 1, bad geometry ranges become straight edges, bad names become
+// unnamed, and orphan shape points are left out. Node arrays and Names are
+// shared with g. It fails when the problems are not repairable (see
+// ValidationReport.Repairable), and returns g itself when there are none.
+func Repair(g *Graph) (*Graph, error) {
+	r := Validate(g)
+	switch {
+	case r.OK():
+		return g, nil
+	case !r.Repairable():
+		return nil, fmt.Errorf("graph cannot be repaired:\n%s", r)
+	}
+
+	mark := func(idx []uint32) []bool {
+		s := make([]bool, g.NumEdges)
+		for _, e := range idx {
+			s[e] = true
+		}
+		return s
+	}
+	dangling, badGeo, badName := mark(r.DanglingHeads), mark(r.BadGeometry), mark(r.BadNames)
+
+	h := &Graph{
+		NumNodes:  g.NumNodes,
+		FirstOut:  make([]uint32, g.NumNodes+1),
+		NodeLat:   g.NodeLat,
+		NodeLon:   g.NodeLon,
+		Names:     g.Names,
+		NodeOSMID: g.NodeOSMID,
+	}
+	if g.GeoFirstOut != nil {
+		h.GeoFirstOut = []uint32{0}
+	}
+	const dropped = ^uint32(0)
+	newEdge := make([]uint32, g.NumEdges)
+	for u := range g.NumNodes {
+		start, end := g.EdgesFrom(u)
+		for e := start; e < end; e++ {
+			if dangling[e] {
+				newEdge[e] = dropped
+				continue
+			}
+			newEdge[e] = uint32(len(h.Head))
+			h.Head = append(h.Head, g.Head[e])
+			if g.Weight != nil {
+				h.Weight = append(h.Weight, max(g.Weight[e], 1))
+			}
+			if g.EdgeName != nil {
+				name := g.EdgeName[e]
+				if badName[e] {
+					name = 0
+				}
+				h.EdgeName = append(h.EdgeName, name)
+			}
+			if g.EdgeAttr != nil {
+				h.EdgeAttr = append(h.EdgeAttr, g.EdgeAttr[e])
+			}
+			if g.EdgeLimits != nil {
+				h.EdgeLimits = append(h.EdgeLimits, g.EdgeLimits[e])
+			}
+			if g.EdgePenalty != nil {
+				h.EdgePenalty = append(h.EdgePenalty, g.EdgePenalty[e])
+			}
+			if g.EdgeRestricted != nil {
+				h.EdgeRestricted = append(h.EdgeRestricted, g.EdgeRestricted[e])
+			}
+			if g.EdgeWayID != nil {
+				h.EdgeWayID = append(h.EdgeWayID, g.EdgeWayID[e])
+			}
+			if g.GeoFirstOut != nil {
+				if !badGeo[e] {
+					h.GeoShapeLat = append(h.GeoShapeLat, g.GeoShapeLat[g.GeoFirstOut[e]:g.GeoFirstOut[e+1]]...)
+					h.GeoShapeLon = append(h.GeoShapeLon, g.GeoShapeLon[g.GeoFirstOut[e]:g.GeoFirstOut[e+1]]...)
+				}
+				h.GeoFirstOut = append(h.GeoFirstOut, uint32(len(h.GeoShapeLat)))
+			}
+		}
+		h.FirstOut[u+1] = uint32(len(h.Head))
+	}
+	h.NumEdges = uint32(len(h.Head))
+
+	bad := make(map[int]bool, len(r.BadRestrictions))
+	for _, i := range r.BadRestrictions {
+		bad[i] = true
+	}
+	var good []TurnRestriction
+	for i, tr := range g.TurnRestrictions {
+		if !bad[i] {
+			good = append(good, tr)
+		}
+	}
+	h.TurnRestrictions = remapTurnRestrictions(good, func(e uint32) (uint32, bool) {
+		return newEdge[e], newEdge[e] != dropped
+	})
+	return h, nil
+}
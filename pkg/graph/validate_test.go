@@ -0,0 +1,81 @@
+package graph_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestValidate(t *testing.T) {
+	if r := graph.Validate(buildTestCH(t).OrigGraph()); !r.OK() {
+		t.Fatalf("built graph fails validation:\n%s", r)
+	}
+
+	// A triangle 0->1->2->0 with a chord 0->2, each edge with one shape
+	// point, then broken in every repairable way.
+	b := graph.NewBuilder()
+	for i := range 3 {
+		b.AddNode(1, 103+float64(i)*0.001)
+	}
+	for _, e := range [][2]uint32{{0, 1}, {0, 2}, {1, 2}, {2, 0}} {
+		if _, err := b.AddEdge(e[0], e[1], 100, graph.LatLng{Lat: 1.001, Lng: 103}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := b.Freeze()
+	g.Names = []graph.RoadName{{}, {Name: "Main"}}
+	g.EdgeName = []uint32{1, 0, 7, 1}
+	g.TurnRestrictions = []graph.TurnRestriction{{Edges: []uint32{0, 2}}, {Edges: []uint32{0, 3}}, {Edges: []uint32{1, 3}}}
+	g.Head[1] = 9                            // dangling chord 0->2
+	g.Weight[2] = 0                          // free 1->2
+	g.GeoShapeLat = append(g.GeoShapeLat, 0) // orphan point 4
+	g.GeoShapeLon = append(g.GeoShapeLon, 0)
+	g.GeoFirstOut[3], g.GeoFirstOut[4] = 4, 3 // 1->2 gets points 2-3, 2->0's range is inverted
+
+	r := graph.Validate(g)
+	check := func(name string, got, want []uint32) {
+		t.Helper()
+		if !slices.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	check("DanglingHeads", r.DanglingHeads, []uint32{1})
+	check("ZeroWeight", r.ZeroWeight, []uint32{2})
+	check("BadGeometry", r.BadGeometry, []uint32{3})
+	check("BadNames", r.BadNames, []uint32{2})
+	if r.OrphanShapePoints != 1 {
+		t.Errorf("OrphanShapePoints = %d, want 1", r.OrphanShapePoints)
+	}
+	// 0->1 then 2->0 does not connect; 0->2 leads to nowhere valid.
+	if !slices.Equal(r.BadRestrictions, []int{1, 2}) {
+		t.Errorf("BadRestrictions = %v, want [1 2]", r.BadRestrictions)
+	}
+	if r.OK() || !r.Repairable() || !strings.Contains(r.String(), "1 zero-weight edges: 2") {
+		t.Errorf("report OK=%v Repairable=%v:\n%s", r.OK(), r.Repairable(), r)
+	}
+
+	h, err := graph.Repair(g)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if r := graph.Validate(h); !r.OK() {
+		t.Fatalf("repaired graph fails validation:\n%s", r)
+	}
+	check("repaired Head", h.Head, []uint32{1, 2, 0})
+	check("repaired Weight", h.Weight, []uint32{100, 1, 100})
+	check("repaired GeoFirstOut", h.GeoFirstOut, []uint32{0, 1, 3, 3})
+	check("repaired EdgeName", h.EdgeName, []uint32{1, 0, 1})
+	if len(h.TurnRestrictions) != 1 || !slices.Equal(h.TurnRestrictions[0].Edges, []uint32{0, 1}) {
+		t.Errorf("repaired TurnRestrictions = %+v, want the 0->1->2 one renumbered", h.TurnRestrictions)
+	}
+
+	g.FirstOut = g.FirstOut[:2]
+	if r := graph.Validate(g); r.Repairable() || len(r.Structure) == 0 {
+		t.Errorf("short FirstOut: report %+v", r)
+	}
+	if _, err := graph.Repair(g); err == nil {
+		t.Error("Repair accepted a graph with a short FirstOut")
+	}
+}
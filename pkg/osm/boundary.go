@@ -0,0 +1,151 @@
+package osm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Boundary is a polygonal area filter loaded from GeoJSON, the polygon
+// counterpart of BBox for extracts whose shape is not a rectangle (a city, a
+// state with a ragged border). A point is inside when it falls inside any of
+// the polygons' outer rings and outside all of that polygon's holes.
+type Boundary struct {
+	polygons [][]ring // each polygon: outer ring followed by holes
+	bbox     BBox     // overall extent, for a cheap first rejection
+}
+
+// ring is a closed sequence of [lng, lat] vertices.
+type ring [][2]float64
+
+// LoadBoundary reads a GeoJSON file holding a Polygon or MultiPolygon, bare
+// or wrapped in a Feature or FeatureCollection. Every polygon found is part
+// of the boundary.
+func LoadBoundary(path string) (*Boundary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ParseBoundary(data)
+	if err != nil {
+		return nil, fmt.Errorf("boundary %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// ParseBoundary parses GeoJSON as for LoadBoundary.
+func ParseBoundary(data []byte) (*Boundary, error) {
+	b := &Boundary{}
+	if err := b.add(data); err != nil {
+		return nil, err
+	}
+	if len(b.polygons) == 0 {
+		return nil, errors.New("no Polygon or MultiPolygon geometry found")
+	}
+	first := true
+	for _, poly := range b.polygons {
+		for _, pt := range poly[0] {
+			if first {
+				b.bbox = BBox{MinLat: pt[1], MaxLat: pt[1], MinLng: pt[0], MaxLng: pt[0]}
+				first = false
+			}
+			b.bbox.MinLat, b.bbox.MaxLat = min(b.bbox.MinLat, pt[1]), max(b.bbox.MaxLat, pt[1])
+			b.bbox.MinLng, b.bbox.MaxLng = min(b.bbox.MinLng, pt[0]), max(b.bbox.MaxLng, pt[0])
+		}
+	}
+	return b, nil
+}
+
+// add appends the polygons of one GeoJSON object.
+func (b *Boundary) add(data []byte) error {
+	var obj struct {
+		Type        string            `json:"type"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometry    json.RawMessage   `json:"geometry"`
+		Features    []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	switch obj.Type {
+	case "FeatureCollection":
+		for _, f := range obj.Features {
+			if err := b.add(f); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if len(obj.Geometry) > 0 && string(obj.Geometry) != "null" {
+			return b.add(obj.Geometry)
+		}
+	case "Polygon":
+		var poly []ring
+		if err := json.Unmarshal(obj.Coordinates, &poly); err != nil {
+			return fmt.Errorf("polygon coordinates: %w", err)
+		}
+		return b.addPolygon(poly)
+	case "MultiPolygon":
+		var polys [][]ring
+		if err := json.Unmarshal(obj.Coordinates, &polys); err != nil {
+			return fmt.Errorf("multipolygon coordinates: %w", err)
+		}
+		for _, poly := range polys {
+			if err := b.addPolygon(poly); err != nil {
+				return err
+			}
+		}
+	}
+	// Other geometry types (points, lines) bound no area and are ignored.
+	return nil
+}
+
+func (b *Boundary) addPolygon(poly []ring) error {
+	if len(poly) == 0 {
+		return nil
+	}
+	for _, r := range poly {
+		if len(r) < 3 {
+			return fmt.Errorf("polygon ring has %d vertices, need at least 3", len(r))
+		}
+	}
+	b.polygons = append(b.polygons, poly)
+	return nil
+}
+
+// Contains reports whether the point lies inside the boundary.
+func (b *Boundary) Contains(lat, lng float64) bool {
+	if !b.bbox.Contains(lat, lng) {
+		return false
+	}
+	for _, poly := range b.polygons {
+		if !poly[0].contains(lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range poly[1:] {
+			if hole.contains(lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// contains is the even-odd ray-casting test. The ring may or may not repeat
+// its first vertex at the end.
+func (r ring) contains(lat, lng float64) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
@@ -0,0 +1,79 @@
+package osm
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// A 10x10 square with a 2x2 hole, plus a separate 1x1 island.
+const testBoundary = `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "properties": {}, "geometry": {
+      "type": "MultiPolygon",
+      "coordinates": [
+        [[[0,0],[10,0],[10,10],[0,10],[0,0]], [[4,4],[6,4],[6,6],[4,6],[4,4]]],
+        [[[20,20],[21,20],[21,21],[20,21]]]
+      ]
+    }},
+    {"type": "Feature", "properties": {}, "geometry": {"type": "Point", "coordinates": [50, 50]}}
+  ]
+}`
+
+func TestBoundaryContains(t *testing.T) {
+	b, err := ParseBoundary([]byte(testBoundary))
+	if err != nil {
+		t.Fatalf("ParseBoundary: %v", err)
+	}
+	tests := []struct {
+		name     string
+		lat, lng float64
+		want     bool
+	}{
+		{"inside outer ring", 1, 1, true},
+		{"inside hole", 5, 5, false},
+		{"between hole and edge", 5, 8, true},
+		{"island without closing vertex", 20.5, 20.5, true},
+		{"outside everything", 15, 15, false},
+		{"point feature adds no area", 50, 50, false},
+	}
+	for _, tt := range tests {
+		if got := b.Contains(tt.lat, tt.lng); got != tt.want {
+			t.Errorf("%s: Contains(%v, %v) = %v, want %v", tt.name, tt.lat, tt.lng, got, tt.want)
+		}
+	}
+}
+
+func TestParseBoundaryErrors(t *testing.T) {
+	for _, in := range []string{
+		`{"type": "Point", "coordinates": [1, 2]}`,
+		`{"type": "Polygon", "coordinates": [[[0,0],[1,1]]]}`,
+		`not json`,
+	} {
+		if _, err := ParseBoundary([]byte(in)); err == nil {
+			t.Errorf("ParseBoundary(%s): expected error", in)
+		}
+	}
+}
+
+func TestParseWithBoundary(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Covers nodes 1 and 2 (lat 1.3000-1.3010) but not 3 or 4.
+	b, err := ParseBoundary([]byte(`{"type":"Polygon","coordinates":[[[103.79,1.29],[103.81,1.29],[103.81,1.3015],[103.79,1.3015]]]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Parse(context.Background(), f, ParseOptions{Boundary: b})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(result.Edges) != 2 {
+		t.Errorf("got %d edges, want 2 (segment 1-2 in both directions)", len(result.Edges))
+	}
+}
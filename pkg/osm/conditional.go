@@ -0,0 +1,266 @@
+package osm
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/osm"
+)
+
+// conditionalKeys are the access (see accessParent) and direction tags whose ":conditional"
+// variants are evaluated when ParseOptions.AccessTime is set. oneway is
+// among them so reversible lanes and peak-hour one-way streets
+// ("oneway:conditional=yes @ (Mo-Fr 07:00-09:00)") take their direction at
+// that instant.
+var conditionalKeys = []string{"access", "vehicle", "motor_vehicle", "motorcar", "hgv", "oneway"}
+
+// applyConditional returns tags with every conditionalKeys entry replaced by
+// the value of its ":conditional" variant in force at t. The original tags are
+// returned untouched when no condition applies, so the common case allocates
+// nothing.
+//
+// Only time-based conditions are understood (a subset of the opening_hours
+// syntax: weekday lists and ranges plus time spans, e.g.
+// "no @ (Mo-Fr 07:00-09:00,16:00-19:00; Sa 22:00-06:00)"). Rules with any
+// other condition (weight, wet, vehicle type, month ranges, ...) never match.
+func applyConditional(tags osm.Tags, t time.Time) osm.Tags {
+	var out osm.Tags
+	for _, key := range conditionalKeys {
+		v, ok := conditionalValue(tags.Find(key+":conditional"), t)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(osm.Tags, 0, len(tags)+1)
+			out = append(out, tags...)
+		}
+		out = setTag(out, key, v)
+	}
+	if out == nil {
+		return tags
+	}
+	return out
+}
+
+func setTag(tags osm.Tags, key, value string) osm.Tags {
+	for i := range tags {
+		if tags[i].Key == key {
+			tags[i].Value = value
+			return tags
+		}
+	}
+	return append(tags, osm.Tag{Key: key, Value: value})
+}
+
+// reversibleWindows reads the schedules of a oneway=reversible way from its
+// oneway:conditional tag: fwd collects the conditions of the rules opening
+// the way's own direction ("yes"), bwd those opening the opposite one
+// ("-1"), each as a "; "-joined opening_hours schedule. Rules with any
+// non-time condition are left out, as conditionHolds would never match them.
+func reversibleWindows(tags osm.Tags) (fwd, bwd string) {
+	var fwdRules, bwdRules []string
+	for _, rule := range splitTopLevel(tags.Find("oneway:conditional"), ';') {
+		v, cond, ok := strings.Cut(rule, "@")
+		if !ok {
+			continue
+		}
+		cond = strings.TrimSpace(cond)
+		if strings.HasPrefix(cond, "(") && strings.HasSuffix(cond, ")") {
+			cond = cond[1 : len(cond)-1]
+		}
+		if !isSchedule(cond) {
+			continue
+		}
+		switch strings.TrimSpace(v) {
+		case "yes", "true", "1":
+			fwdRules = append(fwdRules, cond)
+		case "-1", "reverse":
+			bwdRules = append(bwdRules, cond)
+		}
+	}
+	return strings.Join(fwdRules, "; "), strings.Join(bwdRules, "; ")
+}
+
+// isSchedule reports whether every rule of an opening_hours-style schedule
+// parses.
+func isSchedule(sched string) bool {
+	for _, rule := range strings.Split(sched, ";") {
+		if _, ok := parseScheduleRule(strings.TrimSpace(rule)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionalValue evaluates a conditional restriction value ("value @
+// condition; value @ condition"). The last rule whose condition holds at t
+// wins, as in the OSM conditional restrictions scheme.
+func conditionalValue(raw string, t time.Time) (string, bool) {
+	if raw == "" {
+		return "", false
+	}
+	var value string
+	var found bool
+	for _, rule := range splitTopLevel(raw, ';') {
+		v, cond, ok := strings.Cut(rule, "@")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		cond = strings.TrimSpace(cond)
+		if strings.HasPrefix(cond, "(") && strings.HasSuffix(cond, ")") {
+			cond = cond[1 : len(cond)-1]
+		}
+		if v != "" && conditionHolds(cond, t) {
+			value, found = v, true
+		}
+	}
+	return value, found
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// conditionHolds reports whether a condition ("A AND B", where each part is a
+// schedule) holds at t. Any part that is not a schedule fails the condition.
+func conditionHolds(cond string, t time.Time) bool {
+	for _, p := range strings.Split(cond, " AND ") {
+		if !scheduleHolds(strings.TrimSpace(p), t) {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduleHolds evaluates an opening_hours-style schedule: ";"-separated
+// rules, any of which may match. Unparseable rules never match.
+func scheduleHolds(sched string, t time.Time) bool {
+	for _, rule := range strings.Split(sched, ";") {
+		if r, ok := parseScheduleRule(strings.TrimSpace(rule)); ok && r.holds(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleRule is one opening_hours rule: a weekday set and time spans, either
+// of which may be absent (meaning every day / all day).
+type scheduleRule struct {
+	days  [7]bool // indexed by time.Weekday; all true when no selector is given
+	spans [][2]int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"Su": time.Sunday, "Mo": time.Monday, "Tu": time.Tuesday, "We": time.Wednesday,
+	"Th": time.Thursday, "Fr": time.Friday, "Sa": time.Saturday,
+}
+
+func parseScheduleRule(s string) (scheduleRule, bool) {
+	var r scheduleRule
+	if s == "" {
+		return r, false
+	}
+	s = strings.ReplaceAll(s, ", ", ",")
+	dayPart, timePart := "", s
+	if fields := strings.Fields(s); len(fields) == 2 {
+		dayPart, timePart = fields[0], fields[1]
+	} else if len(fields) != 1 {
+		return r, false
+	} else if !strings.Contains(s, ":") {
+		dayPart, timePart = s, ""
+	}
+
+	if dayPart == "" {
+		r.days = [7]bool{true, true, true, true, true, true, true}
+	} else {
+		for _, sel := range strings.Split(dayPart, ",") {
+			from, to, isRange := strings.Cut(sel, "-")
+			d1, ok1 := weekdayNames[from]
+			d2, ok2 := d1, true
+			if isRange {
+				d2, ok2 = weekdayNames[to]
+			}
+			if !ok1 || !ok2 {
+				return r, false
+			}
+			for d := d1; ; d = (d + 1) % 7 {
+				r.days[d] = true
+				if d == d2 {
+					break
+				}
+			}
+		}
+	}
+
+	if timePart == "" {
+		r.spans = [][2]int{{0, 24 * 60}}
+		return r, true
+	}
+	for _, span := range strings.Split(timePart, ",") {
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return r, false
+		}
+		start, ok1 := parseClock(from)
+		end, ok2 := parseClock(to)
+		if !ok1 || !ok2 {
+			return r, false
+		}
+		r.spans = append(r.spans, [2]int{start, end})
+	}
+	return r, true
+}
+
+// parseClock parses "HH:MM" (00:00-24:00) into minutes since midnight.
+func parseClock(s string) (int, bool) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, false
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hh < 0 || mm < 0 || mm > 59 || hh*60+mm > 24*60 {
+		return 0, false
+	}
+	return hh*60 + mm, true
+}
+
+// holds reports whether t falls inside the rule. A span that wraps past
+// midnight ("22:00-06:00") belongs to the day it starts on, so its early
+// hours match on the following day.
+func (r scheduleRule) holds(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, s := range r.spans {
+		start, end := s[0], s[1]
+		if start < end {
+			if r.days[today] && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		if (r.days[today] && minute >= start) || (r.days[yesterday] && minute < end) {
+			return true
+		}
+	}
+	return false
+}
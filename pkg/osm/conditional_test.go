@@ -0,0 +1,130 @@
+package osm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConditionalValue(t *testing.T) {
+	// 2026-01-05 is a Monday.
+	mon0800 := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	mon1200 := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	sat0300 := time.Date(2026, 1, 10, 3, 0, 0, 0, time.UTC)
+	sun0300 := time.Date(2026, 1, 11, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		raw    string
+		at     time.Time
+		want   string
+		wantOK bool
+	}{
+		{"no @ (Mo-Fr 07:00-09:00)", mon0800, "no", true},
+		{"no @ (Mo-Fr 07:00-09:00)", mon1200, "", false},
+		{"no @ (Mo-Fr 07:00-09:00, 11:00-13:00)", mon1200, "no", true},
+		{"no @ 07:00-09:00", mon0800, "no", true},
+		{"private @ (Sa,Su)", sun0300, "private", true},
+		// Friday night span runs into Saturday morning, but not Sunday's.
+		{"no @ (Fr 22:00-06:00)", sat0300, "no", true},
+		{"no @ (Fr 22:00-06:00)", sun0300, "", false},
+		// The last matching rule wins.
+		{"no @ (Mo-Fr); destination @ (Mo 07:00-09:00)", mon0800, "destination", true},
+		{"no @ (Mo-Su 07:00-09:00; Sa 00:00-24:00)", sat0300, "no", true},
+		// Non-time conditions never hold.
+		{"no @ (weight>7.5)", mon0800, "", false},
+		{"no @ (Mo-Fr 07:00-09:00 AND wet)", mon0800, "", false},
+		{"no @ (Jan-Mar)", mon0800, "", false},
+		{"", mon0800, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := conditionalValue(tt.raw, tt.at)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("conditionalValue(%q, %s) = %q, %v; want %q, %v", tt.raw, tt.at.Format("Mon 15:04"), got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestApplyConditionalAccess(t *testing.T) {
+	mon0800 := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	mon1200 := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	way := tags("highway", "residential", "motor_vehicle:conditional", "no @ (Mo-Fr 07:00-09:00)")
+
+	if keep, _ := classifyAccess(applyConditional(way, mon0800)); keep {
+		t.Error("school-run closure should drop the way at 08:00 on a weekday")
+	}
+	if keep, _ := classifyAccess(applyConditional(way, mon1200)); !keep {
+		t.Error("way should be open outside the closure")
+	}
+	if got := applyConditional(way, mon1200); &got[0] != &way[0] {
+		t.Error("applyConditional should return the original tags when nothing applies")
+	}
+
+	gated := tags("highway", "service", "access", "private", "access:conditional", "yes @ (Mo-Fr 07:00-19:00)")
+	if _, restricted := classifyAccess(applyConditional(gated, mon1200)); restricted {
+		t.Error("access:conditional=yes should lift the private restriction during the day")
+	}
+	if gated.Find("access") != "private" {
+		t.Error("applyConditional must not modify its input")
+	}
+}
+
+func TestReversibleWays(t *testing.T) {
+	data := `<osm version="0.6">
+  <node id="1" lat="1.3000" lon="103.8000"/>
+  <node id="2" lat="1.3010" lon="103.8000"/>
+  <way id="10">
+    <nd ref="1"/><nd ref="2"/>
+    <tag k="highway" v="primary"/>
+    <tag k="oneway" v="reversible"/>
+    <tag k="oneway:conditional" v="yes @ (Mo-Fr 06:00-10:00); -1 @ (Mo-Fr 16:00-20:00); -1 @ (weight>3.5)"/>
+  </way>
+</osm>`
+	parse := func(opt ParseOptions) []RawEdge {
+		t.Helper()
+		result, err := Parse(context.Background(), strings.NewReader(data), opt)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		return result.Edges
+	}
+	// 2026-01-05 is a Monday.
+	at := func(hour int) time.Time { return time.Date(2026, 1, 5, hour, 0, 0, 0, time.UTC) }
+
+	if edges := parse(ParseOptions{}); len(edges) != 0 {
+		t.Errorf("reversible way without a reference time kept %d edges", len(edges))
+	}
+	if edges := parse(ParseOptions{AccessTime: at(8)}); len(edges) != 1 || edges[0].FromNodeID != 1 {
+		t.Errorf("morning peak: got %+v, want the single edge 1->2", edges)
+	}
+	if edges := parse(ParseOptions{AccessTime: at(17)}); len(edges) != 1 || edges[0].FromNodeID != 2 {
+		t.Errorf("evening peak: got %+v, want the single edge 2->1", edges)
+	}
+	if edges := parse(ParseOptions{AccessTime: at(12)}); len(edges) != 0 {
+		t.Errorf("midday: reversible way is closed but kept %d edges", len(edges))
+	}
+
+	edges := parse(ParseOptions{KeepReversible: true})
+	if len(edges) != 2 {
+		t.Fatalf("KeepReversible: got %d edges, want 2", len(edges))
+	}
+	for _, e := range edges {
+		want := "Mo-Fr 06:00-10:00"
+		if e.FromNodeID == 2 {
+			want = "Mo-Fr 16:00-20:00"
+		}
+		if e.Window != want {
+			t.Errorf("edge %d->%d window = %q, want %q", e.FromNodeID, e.ToNodeID, e.Window, want)
+		}
+	}
+}
+
+func TestApplyConditionalOneway(t *testing.T) {
+	way := tags("highway", "residential", "oneway:conditional", "yes @ (Mo-Fr 07:00-09:00)")
+	if fwd, bwd := directionFlags(applyConditional(way, time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC))); !fwd || bwd {
+		t.Errorf("peak-hour one-way: Direction = (%v,%v), want (true,false)", fwd, bwd)
+	}
+	if fwd, bwd := directionFlags(applyConditional(way, time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC))); !fwd || !bwd {
+		t.Errorf("off-peak: Direction = (%v,%v), want (true,true)", fwd, bwd)
+	}
+}
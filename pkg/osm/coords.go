@@ -0,0 +1,136 @@
+package osm
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/paulmach/osm"
+)
+
+// coordScale is OSM's own coordinate precision: 1e-7 degrees (~1 cm).
+const coordScale = 1e7
+
+// NodeCoords is a compact, read-only node coordinate store: node IDs sorted
+// ascending alongside fixed-point coordinates at OSM's 1e-7 degree precision.
+// At 16 bytes per node it is several times smaller than a pair of
+// map[osm.NodeID]float64, which dominated preprocessing memory on
+// country-sized extracts. Lookups are binary searches.
+type NodeCoords struct {
+	ids      []osm.NodeID
+	lat, lon []int32
+	ele      []float32 // metres; NaN = unknown. nil without elevation data
+}
+
+// Len returns the number of nodes stored.
+func (c *NodeCoords) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.ids)
+}
+
+// Get returns the coordinates of node id.
+func (c *NodeCoords) Get(id osm.NodeID) (lat, lon float64, ok bool) {
+	if c == nil {
+		return 0, 0, false
+	}
+	i, found := slices.BinarySearch(c.ids, id)
+	if !found {
+		return 0, 0, false
+	}
+	return float64(c.lat[i]) / coordScale, float64(c.lon[i]) / coordScale, true
+}
+
+// Elevation returns the elevation of node id in metres, when one was
+// assigned (see ParseOptions.Elevation).
+func (c *NodeCoords) Elevation(id osm.NodeID) (float64, bool) {
+	if c == nil || c.ele == nil {
+		return 0, false
+	}
+	i, found := slices.BinarySearch(c.ids, id)
+	if !found || math.IsNaN(float64(c.ele[i])) {
+		return 0, false
+	}
+	return float64(c.ele[i]), true
+}
+
+// setElevations looks up every node in p and returns how many got a value.
+func (c *NodeCoords) setElevations(p ElevationProvider) int {
+	c.ele = make([]float32, len(c.ids))
+	n := 0
+	for i := range c.ids {
+		v, ok := p.Elevation(float64(c.lat[i])/coordScale, float64(c.lon[i])/coordScale)
+		if !ok {
+			c.ele[i] = float32(math.NaN())
+			continue
+		}
+		c.ele[i] = float32(v)
+		n++
+	}
+	return n
+}
+
+// coordBuilder fills a NodeCoords for a known set of node IDs as nodes stream
+// past. Extracts list nodes in ascending ID order, so it keeps a cursor and
+// usually finds each node a few slots ahead instead of searching the whole
+// ID list.
+type coordBuilder struct {
+	c      *NodeCoords
+	found  []bool
+	cursor int
+}
+
+// newCoordBuilder takes ownership of ids, which may be unsorted and contain
+// duplicates.
+func newCoordBuilder(ids []osm.NodeID) *coordBuilder {
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	return &coordBuilder{
+		c: &NodeCoords{
+			ids: ids,
+			lat: make([]int32, len(ids)),
+			lon: make([]int32, len(ids)),
+		},
+		found: make([]bool, len(ids)),
+	}
+}
+
+// index returns the slot of id, or false when id is not wanted.
+func (b *coordBuilder) index(id osm.NodeID) (int, bool) {
+	ids := b.c.ids
+	from := b.cursor
+	if from > 0 && ids[from-1] >= id {
+		from = 0 // input went backwards (unsorted file, or the next input)
+	}
+	i := from + sort.Search(len(ids)-from, func(k int) bool { return ids[from+k] >= id })
+	b.cursor = i
+	return i, i < len(ids) && ids[i] == id
+}
+
+// set records the coordinates of id, reporting false when id is not wanted.
+func (b *coordBuilder) set(id osm.NodeID, lat, lon float64) bool {
+	i, ok := b.index(id)
+	if !ok {
+		return false
+	}
+	b.c.lat[i] = int32(math.Round(lat * coordScale))
+	b.c.lon[i] = int32(math.Round(lon * coordScale))
+	b.found[i] = true
+	return true
+}
+
+// finish drops the IDs that never received coordinates and returns the store.
+func (b *coordBuilder) finish() *NodeCoords {
+	c := b.c
+	n := 0
+	for i, ok := range b.found {
+		if ok {
+			c.ids[n], c.lat[n], c.lon[n] = c.ids[i], c.lat[i], c.lon[i]
+			n++
+		}
+	}
+	c.ids, c.lat, c.lon = c.ids[:n], c.lat[:n], c.lon[:n]
+	b.found = nil
+	return c
+}
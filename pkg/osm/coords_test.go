@@ -0,0 +1,61 @@
+package osm
+
+import (
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestCoordBuilder(t *testing.T) {
+	b := newCoordBuilder([]osm.NodeID{30, 10, 20, 10, 40})
+
+	// Ascending stream, as in a PBF, with unwanted nodes interleaved.
+	for _, n := range []struct {
+		id       osm.NodeID
+		lat, lon float64
+		want     bool
+	}{
+		{5, 1, 1, false},
+		{10, 1.3000001, 103.8, true},
+		{15, 1, 1, false},
+		{30, -33.8688197, 151.2092955, true},
+		{50, 1, 1, false},
+		// A second input restarts at low IDs.
+		{20, 1.3, -0.0000001, true},
+	} {
+		if got := b.set(n.id, n.lat, n.lon); got != n.want {
+			t.Errorf("set(%d) = %v, want %v", n.id, got, n.want)
+		}
+	}
+
+	c := b.finish()
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d, want 3 (node 40 never arrived)", c.Len())
+	}
+	if _, _, ok := c.Get(40); ok {
+		t.Error("Get(40) found a node that was never set")
+	}
+	for _, want := range []struct {
+		id       osm.NodeID
+		lat, lon float64
+	}{
+		{10, 1.3000001, 103.8},
+		{20, 1.3, -0.0000001},
+		{30, -33.8688197, 151.2092955},
+	} {
+		lat, lon, ok := c.Get(want.id)
+		if !ok || lat != want.lat || lon != want.lon {
+			t.Errorf("Get(%d) = %v, %v, %v; want %v, %v", want.id, lat, lon, ok, want.lat, want.lon)
+		}
+	}
+}
+
+func TestNodeCoordsNil(t *testing.T) {
+	var c *NodeCoords
+	if c.Len() != 0 {
+		t.Error("nil NodeCoords should be empty")
+	}
+	if _, _, ok := c.Get(1); ok {
+		t.Error("nil NodeCoords should find nothing")
+	}
+}
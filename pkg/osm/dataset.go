@@ -0,0 +1,361 @@
+package osm
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// Dataset is the way-level intermediate of a parse: the raw OSM objects the
+// car network is built from. A ParseResult is already reduced to directed
+// edges and cannot absorb edits; a Dataset keeps ways, nodes and restriction
+// relations by ID, so daily OsmChange diffs can be applied to it (ApplyChange)
+// and the edges rebuilt (Result) without re-reading the full extract.
+//
+// Ways are kept by highway class alone (the classes of the profile the
+// dataset was parsed for), before access, direction and speed are evaluated,
+// so Result honours whatever ParseOptions it is given as long as its profile
+// uses no other classes.
+type Dataset struct {
+	Ways      map[osm.WayID]DatasetWay
+	Nodes     map[osm.NodeID]DatasetNode // nodes referenced by Ways only
+	Relations map[osm.RelationID]DatasetRelation
+	Highways  []string // sorted highway classes kept; empty = car classes (datasets saved before profiles)
+}
+
+// DatasetWay is a stored way.
+type DatasetWay struct {
+	Nodes []osm.NodeID
+	Tags  osm.Tags
+}
+
+// DatasetNode is a stored node. Tags are kept only for nodes that may carry
+// barrier or traffic-control information.
+type DatasetNode struct {
+	Lat, Lon float64
+	Tags     osm.Tags
+}
+
+// DatasetRelation is a stored turn restriction relation.
+type DatasetRelation struct {
+	Tags    osm.Tags
+	Members osm.Members
+}
+
+// ChangeStats summarizes one ApplyChange call.
+type ChangeStats struct {
+	Created, Modified, Deleted int // objects per action, of every type
+	// MissingNodes counts nodes referenced by stored ways whose coordinates
+	// are unknown: typically an existing node newly joined to a road, which
+	// the diff does not repeat. Segments touching them are skipped until the
+	// next full parse.
+	MissingNodes int
+}
+
+func newDataset() *Dataset {
+	return &Dataset{
+		Ways:      make(map[osm.WayID]DatasetWay),
+		Nodes:     make(map[osm.NodeID]DatasetNode),
+		Relations: make(map[osm.RelationID]DatasetRelation),
+	}
+}
+
+// keepsWay is the loose pre-filter for stored ways: any highway class of the
+// dataset's profile, whatever its access tags say.
+func (ds *Dataset) keepsWay(tags osm.Tags) bool {
+	hw := tags.Find("highway")
+	if len(ds.Highways) == 0 {
+		return carHighways[hw]
+	}
+	_, ok := slices.BinarySearch(ds.Highways, hw)
+	return ok
+}
+
+// isRestrictionRelation is the pre-filter for stored relations. Which modes a
+// restriction binds is decided in Result.
+func isRestrictionRelation(r *osm.Relation) bool {
+	return r.Tags.Find("type") == "restriction"
+}
+
+// datasetNode trims a node to what the edge builder may consult.
+func datasetNode(n *osm.Node) DatasetNode {
+	dn := DatasetNode{Lat: n.Lat, Lon: n.Lon}
+	if n.Tags.Find("barrier") != "" || n.Tags.Find("highway") != "" {
+		dn.Tags = n.Tags
+	}
+	return dn
+}
+
+// ParseDataset reads OSM inputs into a Dataset. Like ParseAll, objects
+// repeated across inputs are kept once. Only the Format and Progress options
+// and the profile's highway classes apply; the rest take effect in Result.
+func ParseDataset(ctx context.Context, inputs []io.ReadSeeker, opts ...ParseOptions) (*Dataset, error) {
+	opt := resolveOptions(opts)
+	srcs, err := resolveSources(inputs, opt.Format)
+	if err != nil {
+		return nil, err
+	}
+	progress := newProgressTracker(opt.Progress, srcs)
+	ds := newDataset()
+	ds.Highways = slices.Sorted(slices.Values(opt.Profile.Highways()))
+
+	err = scanSources(ctx, srcs, scanFilter{ways: true, relations: true}, progress, PhaseWays, func(obj osm.Object) {
+		switch o := obj.(type) {
+		case *osm.Way:
+			if _, dup := ds.Ways[o.ID]; !dup && ds.keepsWay(o.Tags) {
+				ds.Ways[o.ID] = DatasetWay{Nodes: o.Nodes.NodeIDs(), Tags: o.Tags}
+			}
+		case *osm.Relation:
+			if _, dup := ds.Relations[o.ID]; !dup && isRestrictionRelation(o) {
+				ds.Relations[o.ID] = DatasetRelation{Tags: o.Tags, Members: o.Members}
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pass 1 (ways): %w", err)
+	}
+
+	referenced := ds.referencedNodes()
+	err = scanSources(ctx, srcs, scanFilter{nodes: true}, progress, PhaseNodes, func(obj osm.Object) {
+		if n, ok := obj.(*osm.Node); ok {
+			if _, needed := referenced[n.ID]; needed {
+				ds.Nodes[n.ID] = datasetNode(n)
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pass 2 (nodes): %w", err)
+	}
+
+	log.Printf("Dataset: %d ways, %d nodes, %d restriction relations", len(ds.Ways), len(ds.Nodes), len(ds.Relations))
+	return ds, nil
+}
+
+func (ds *Dataset) referencedNodes() map[osm.NodeID]struct{} {
+	referenced := make(map[osm.NodeID]struct{})
+	for _, w := range ds.Ways {
+		for _, id := range w.Nodes {
+			referenced[id] = struct{}{}
+		}
+	}
+	return referenced
+}
+
+// Result builds routing edges from the dataset, exactly as ParseAll would
+// from the equivalent extract. Ways and relations are processed in ID order so
+// the output is deterministic.
+func (ds *Dataset) Result(opts ...ParseOptions) *ParseResult {
+	opt := resolveOptions(opts)
+	names := newNameTable()
+
+	wayIDs := make([]osm.WayID, 0, len(ds.Ways))
+	for id := range ds.Ways {
+		wayIDs = append(wayIDs, id)
+	}
+	slices.Sort(wayIDs)
+	ways := make([]wayInfo, 0, len(wayIDs))
+	for _, id := range wayIDs {
+		w := ds.Ways[id]
+		if wi, ok := opt.newWayInfo(id, w.Nodes, w.Tags, names); ok {
+			ways = append(ways, wi)
+		}
+	}
+
+	relIDs := make([]osm.RelationID, 0, len(ds.Relations))
+	for id := range ds.Relations {
+		relIDs = append(relIDs, id)
+	}
+	slices.Sort(relIDs)
+	var raw []rawRestriction
+	for _, id := range relIDs {
+		r := ds.Relations[id]
+		if rr, ok := parseRestriction(&osm.Relation{ID: id, Tags: r.Tags, Members: r.Members}, opt.Profile.TurnRestrictionModes()); ok {
+			raw = append(raw, rr)
+		}
+	}
+	restrictions := resolveWayRestrictions(raw, ways)
+
+	var refs []osm.NodeID
+	for _, w := range ways {
+		refs = append(refs, w.NodeIDs...)
+	}
+	nodes := newNodeSet(refs, opt.Profile)
+	for _, id := range nodes.coords.ids {
+		if n, ok := ds.Nodes[id]; ok {
+			nodes.add(id, n.Lat, n.Lon, n.Tags)
+		}
+	}
+	nodes.finish()
+	return buildResult(ways, restrictions, nodes, names, opt)
+}
+
+// ApplyChange applies an OsmChange document (.osc, optionally gzipped) to the
+// dataset. Actions are applied in document order. Nodes the diff creates or
+// modifies are adopted when a stored way references them once the whole diff
+// is applied; nodes no stored way references any more are dropped.
+func (ds *Dataset) ApplyChange(r io.Reader) (ChangeStats, error) {
+	var stats ChangeStats
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return stats, fmt.Errorf("osc: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	pending := make(map[osm.NodeID]DatasetNode)
+	dec := xml.NewDecoder(r)
+	var action string
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("osc: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "create", "modify", "delete":
+				action = t.Name.Local
+				continue
+			case "node", "way", "relation":
+			default:
+				continue
+			}
+			if action == "" {
+				return stats, fmt.Errorf("osc: <%s> outside create/modify/delete", t.Name.Local)
+			}
+			if err := ds.applyElement(dec, t, action, pending); err != nil {
+				return stats, err
+			}
+			switch action {
+			case "create":
+				stats.Created++
+			case "modify":
+				stats.Modified++
+			case "delete":
+				stats.Deleted++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "create", "modify", "delete":
+				action = ""
+			}
+		}
+	}
+
+	referenced := ds.referencedNodes()
+	for id, n := range pending {
+		if _, ok := referenced[id]; ok {
+			ds.Nodes[id] = n
+		}
+	}
+	for id := range ds.Nodes {
+		if _, ok := referenced[id]; !ok {
+			delete(ds.Nodes, id)
+		}
+	}
+	stats.MissingNodes = len(referenced) - len(ds.Nodes)
+	return stats, nil
+}
+
+func (ds *Dataset) applyElement(dec *xml.Decoder, start xml.StartElement, action string, pending map[osm.NodeID]DatasetNode) error {
+	remove := action == "delete"
+	switch start.Name.Local {
+	case "node":
+		var n osm.Node
+		if err := dec.DecodeElement(&n, &start); err != nil {
+			return fmt.Errorf("osc node: %w", err)
+		}
+		switch _, stored := ds.Nodes[n.ID]; {
+		case remove:
+			delete(ds.Nodes, n.ID)
+			delete(pending, n.ID)
+		case stored:
+			ds.Nodes[n.ID] = datasetNode(&n)
+		default:
+			pending[n.ID] = datasetNode(&n)
+		}
+	case "way":
+		var w osm.Way
+		if err := dec.DecodeElement(&w, &start); err != nil {
+			return fmt.Errorf("osc way: %w", err)
+		}
+		if remove || !ds.keepsWay(w.Tags) {
+			delete(ds.Ways, w.ID)
+		} else {
+			ds.Ways[w.ID] = DatasetWay{Nodes: w.Nodes.NodeIDs(), Tags: w.Tags}
+		}
+	case "relation":
+		var r osm.Relation
+		if err := dec.DecodeElement(&r, &start); err != nil {
+			return fmt.Errorf("osc relation: %w", err)
+		}
+		if remove || !isRestrictionRelation(&r) {
+			delete(ds.Relations, r.ID)
+		} else {
+			ds.Relations[r.ID] = DatasetRelation{Tags: r.Tags, Members: r.Members}
+		}
+	}
+	return nil
+}
+
+// datasetMagic prefixes a saved dataset; bump the digit on layout changes.
+const datasetMagic = "MPRDSET1"
+
+// WriteDataset saves ds to path.
+func WriteDataset(path string, ds *Dataset) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if _, err := bw.WriteString(datasetMagic); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gob.NewEncoder(bw).Encode(ds); err != nil {
+		f.Close()
+		return fmt.Errorf("encode dataset: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadDataset loads a dataset saved by WriteDataset.
+func ReadDataset(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(datasetMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != datasetMagic {
+		return nil, fmt.Errorf("%s: not a dataset file", path)
+	}
+	ds := newDataset()
+	if err := gob.NewDecoder(br).Decode(ds); err != nil {
+		return nil, fmt.Errorf("decode dataset: %w", err)
+	}
+	return ds, nil
+}
@@ -0,0 +1,144 @@
+package osm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTinyDataset(t *testing.T) *Dataset {
+	t.Helper()
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ds, err := ParseDataset(context.Background(), []io.ReadSeeker{f})
+	if err != nil {
+		t.Fatalf("ParseDataset: %v", err)
+	}
+	return ds
+}
+
+func TestDatasetResultMatchesParse(t *testing.T) {
+	ds := loadTinyDataset(t)
+	if len(ds.Ways) != 2 {
+		t.Errorf("dataset holds %d ways, want 2 (the footway is not stored)", len(ds.Ways))
+	}
+
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := Parse(context.Background(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ds.Result()
+	if len(got.Edges) != len(want.Edges) || got.NumNodes() != want.NumNodes() || len(got.NodeControls) != len(want.NodeControls) {
+		t.Errorf("Result: %d edges, %d nodes, %d controls; Parse: %d, %d, %d",
+			len(got.Edges), got.NumNodes(), len(got.NodeControls),
+			len(want.Edges), want.NumNodes(), len(want.NodeControls))
+	}
+}
+
+const tinyChange = `<?xml version="1.0" encoding="UTF-8"?>
+<osmChange version="0.6">
+  <modify>
+    <way id="11">
+      <nd ref="3"/>
+      <nd ref="4"/>
+      <tag k="highway" v="primary"/>
+    </way>
+  </modify>
+  <delete>
+    <way id="10"/>
+  </delete>
+  <create>
+    <node id="6" lat="1.3020" lon="103.8020"/>
+    <way id="13">
+      <nd ref="4"/>
+      <nd ref="6"/>
+      <tag k="highway" v="residential"/>
+    </way>
+  </create>
+</osmChange>`
+
+func TestDatasetApplyChange(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		ds := loadTinyDataset(t)
+		var in io.Reader = strings.NewReader(tinyChange)
+		if gz {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(tinyChange))
+			zw.Close()
+			in = &buf
+		}
+
+		stats, err := ds.ApplyChange(in)
+		if err != nil {
+			t.Fatalf("gzip=%v: ApplyChange: %v", gz, err)
+		}
+		if stats != (ChangeStats{Created: 2, Modified: 1, Deleted: 1}) {
+			t.Errorf("gzip=%v: stats = %+v", gz, stats)
+		}
+
+		// Way 11 is now two-way, way 10 is gone, way 13 is new. Both are
+		// unnamed and meet only at node 4, so they form one road 3-6.
+		result := ds.Result()
+		if len(result.Edges) != 2 {
+			t.Errorf("gzip=%v: got %d edges, want 2", gz, len(result.Edges))
+		}
+		// Nodes 1 and 2 were only on way 10; node 6 was adopted.
+		if _, ok := ds.Nodes[1]; ok {
+			t.Errorf("gzip=%v: node 1 should have been pruned", gz)
+		}
+		if _, ok := ds.Nodes[6]; !ok {
+			t.Errorf("gzip=%v: node 6 should have been adopted", gz)
+		}
+	}
+}
+
+func TestDatasetApplyChangeMissingNode(t *testing.T) {
+	ds := loadTinyDataset(t)
+	// Node 5 exists in the extract but was never stored (footway only).
+	stats, err := ds.ApplyChange(strings.NewReader(`<osmChange><modify>
+  <way id="12"><nd ref="4"/><nd ref="5"/><tag k="highway" v="service"/></way>
+</modify></osmChange>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.MissingNodes != 1 {
+		t.Errorf("MissingNodes = %d, want 1", stats.MissingNodes)
+	}
+}
+
+func TestDatasetRoundTrip(t *testing.T) {
+	ds := loadTinyDataset(t)
+	path := filepath.Join(t.TempDir(), "tiny.dataset")
+	if err := WriteDataset(path, ds); err != nil {
+		t.Fatalf("WriteDataset: %v", err)
+	}
+	got, err := ReadDataset(path)
+	if err != nil {
+		t.Fatalf("ReadDataset: %v", err)
+	}
+	if len(got.Ways) != len(ds.Ways) || len(got.Nodes) != len(ds.Nodes) {
+		t.Errorf("round trip: %d ways, %d nodes; want %d, %d", len(got.Ways), len(got.Nodes), len(ds.Ways), len(ds.Nodes))
+	}
+	if n := got.Nodes[2]; n.Tags.Find("highway") != "traffic_signals" {
+		t.Errorf("node 2 tags = %v, want traffic_signals kept", n.Tags)
+	}
+
+	if _, err := ReadDataset("testdata/tiny.osm"); err == nil {
+		t.Error("ReadDataset accepted a non-dataset file")
+	}
+}
@@ -0,0 +1,220 @@
+package osm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ElevationProvider looks up terrain height. Set as ParseOptions.Elevation,
+// it assigns every routing node an elevation, from which edges get their
+// total climb and descent and grade-aware profiles (GradeProfile) adjust
+// their travel times.
+type ElevationProvider interface {
+	// Elevation returns the height in metres above sea level at a point,
+	// or false where the data has no value (outside coverage, voids).
+	Elevation(lat, lon float64) (float64, bool)
+}
+
+// GradeProfile is implemented by profiles whose speed depends on the slope,
+// such as walking and cycling. Without elevation data the grade is zero.
+type GradeProfile interface {
+	// GradeFactor scales the profile's speed on a stretch climbing grade
+	// (rise over run; negative downhill). 1 means flat-ground speed.
+	GradeFactor(grade float64) float64
+}
+
+// maxGrade bounds the slope used for travel times. Elevation models are
+// coarse (30-90 m cells) next to short road segments, so steeper readings
+// are mostly noise, e.g. a bridge deck sampled at the valley floor.
+const maxGrade = 0.3
+
+// srtmVoid marks a missing sample in SRTM .hgt files.
+const srtmVoid = -32768
+
+// HGTDir reads SRTM .hgt tiles (1 or 3 arc-second, as distributed by NASA
+// and viewfinderpanoramas.org) from a directory. Tiles are named after
+// their south-west corner, e.g. N01E103.hgt, and loaded on first use.
+// Heights are bilinearly interpolated between the four surrounding samples.
+type HGTDir struct {
+	Dir string
+
+	mu    sync.Mutex
+	tiles map[[2]int]*hgtTile // keyed by the corner's integer lat/lon; nil = missing
+}
+
+type hgtTile struct {
+	size    int // samples per side: 1201 (3") or 3601 (1")
+	samples []int16
+}
+
+// Elevation implements ElevationProvider.
+func (h *HGTDir) Elevation(lat, lon float64) (float64, bool) {
+	south, west := math.Floor(lat), math.Floor(lon)
+	t := h.tile(int(south), int(west))
+	if t == nil {
+		return 0, false
+	}
+	// Row 0 is the tile's northern edge.
+	span := float64(t.size - 1)
+	return bilinear((lon-west)*span, (1-(lat-south))*span, t.size, t.size, func(x, y int) (float64, bool) {
+		v := t.samples[y*t.size+x]
+		return float64(v), v != srtmVoid
+	})
+}
+
+func (h *HGTDir) tile(lat, lon int) *hgtTile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := [2]int{lat, lon}
+	if t, ok := h.tiles[key]; ok {
+		return t
+	}
+	if h.tiles == nil {
+		h.tiles = make(map[[2]int]*hgtTile)
+	}
+	t, err := loadHGT(filepath.Join(h.Dir, hgtName(lat, lon)))
+	if err != nil && !os.IsNotExist(err) {
+		// A damaged tile is treated like a missing one; say so once.
+		log.Printf("Warning: elevation: %v", err)
+	}
+	h.tiles[key] = t
+	return t
+}
+
+// hgtName returns the file name of the tile whose south-west corner is at
+// lat, lon, e.g. N01E103.hgt or S34W071.hgt.
+func hgtName(lat, lon int) string {
+	ns, ew := 'N', 'E'
+	if lat < 0 {
+		ns, lat = 'S', -lat
+	}
+	if lon < 0 {
+		ew, lon = 'W', -lon
+	}
+	return fmt.Sprintf("%c%02d%c%03d.hgt", ns, lat, ew, lon)
+}
+
+func loadHGT(path string) (*hgtTile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var size int
+	switch len(data) {
+	case 1201 * 1201 * 2:
+		size = 1201
+	case 3601 * 3601 * 2:
+		size = 3601
+	default:
+		return nil, fmt.Errorf("%s: unexpected size %d bytes for an SRTM tile", path, len(data))
+	}
+	samples := make([]int16, size*size)
+	for i := range samples {
+		samples[i] = int16(binary.BigEndian.Uint16(data[2*i:]))
+	}
+	return &hgtTile{size: size, samples: samples}, nil
+}
+
+// TerrariumTiles reads Terrarium-encoded terrain PNG tiles (the format of
+// the AWS "Terrain Tiles" open dataset) stored as Dir/{z}/{x}/{y}.png at a
+// single web-mercator zoom level. Each pixel encodes
+// (R*256 + G + B/256) - 32768 metres. Tiles are loaded on first use.
+type TerrariumTiles struct {
+	Dir  string
+	Zoom int
+
+	mu    sync.Mutex
+	tiles map[[2]int]image.Image // nil = missing
+}
+
+// Elevation implements ElevationProvider.
+func (tt *TerrariumTiles) Elevation(lat, lon float64) (float64, bool) {
+	if lat < -85.0511 || lat > 85.0511 {
+		return 0, false
+	}
+	n := math.Exp2(float64(tt.Zoom))
+	fx := (lon + 180) / 360 * n
+	sinLat := math.Sin(lat * math.Pi / 180)
+	fy := (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * n
+	tx, ty := int(math.Floor(fx)), int(math.Floor(fy))
+	img := tt.tile(tx, ty)
+	if img == nil {
+		return 0, false
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Pixel centres sit half a pixel in from the tile edges.
+	px := (fx-float64(tx))*float64(w) - 0.5
+	py := (fy-float64(ty))*float64(h) - 0.5
+	return bilinear(px, py, w, h, func(x, y int) (float64, bool) {
+		r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return float64(r>>8)*256 + float64(g>>8) + float64(bl>>8)/256 - 32768, true
+	})
+}
+
+func (tt *TerrariumTiles) tile(x, y int) image.Image {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	key := [2]int{x, y}
+	if img, ok := tt.tiles[key]; ok {
+		return img
+	}
+	if tt.tiles == nil {
+		tt.tiles = make(map[[2]int]image.Image)
+	}
+	path := filepath.Join(tt.Dir, fmt.Sprint(tt.Zoom), fmt.Sprint(x), fmt.Sprintf("%d.png", y))
+	img, err := loadPNG(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: elevation: %s: %v", path, err)
+	}
+	tt.tiles[key] = img
+	return img
+}
+
+func loadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// bilinear interpolates a w×h grid at fractional position (x, y), clamped to
+// the grid. Samples that sample reports missing are left out and the
+// remaining weights renormalised; it fails when every weighted sample is
+// missing.
+func bilinear(x, y float64, w, h int, sample func(x, y int) (float64, bool)) (float64, bool) {
+	x = min(max(x, 0), float64(w-1))
+	y = min(max(y, 0), float64(h-1))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	dx, dy := x-float64(x0), y-float64(y0)
+
+	var sum, weight float64
+	for _, c := range [4]struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - dx) * (1 - dy)},
+		{x1, y0, dx * (1 - dy)},
+		{x0, y1, (1 - dx) * dy},
+		{x1, y1, dx * dy},
+	} {
+		if v, ok := sample(c.x, c.y); ok {
+			sum += v * c.w
+			weight += c.w
+		}
+	}
+	if weight == 0 {
+		return 0, false
+	}
+	return sum / weight, true
+}
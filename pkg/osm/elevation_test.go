@@ -0,0 +1,142 @@
+package osm
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// elevationFunc adapts a function to ElevationProvider.
+type elevationFunc func(lat, lon float64) (float64, bool)
+
+func (f elevationFunc) Elevation(lat, lon float64) (float64, bool) { return f(lat, lon) }
+
+func TestHGTName(t *testing.T) {
+	cases := []struct {
+		lat, lon int
+		want     string
+	}{
+		{1, 103, "N01E103.hgt"},
+		{-34, -71, "S34W071.hgt"},
+		{0, 0, "N00E000.hgt"},
+	}
+	for _, c := range cases {
+		if got := hgtName(c.lat, c.lon); got != c.want {
+			t.Errorf("hgtName(%d, %d) = %q, want %q", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestHGTDirElevation(t *testing.T) {
+	const size = 1201
+	// Height rises 1 m per sample eastwards; row 0 is the northern edge.
+	data := make([]byte, size*size*2)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := int16(x)
+			if x == 600 && y == 600 {
+				v = srtmVoid
+			}
+			binary.BigEndian.PutUint16(data[2*(y*size+x):], uint16(v))
+		}
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "N01E103.hgt"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := &HGTDir{Dir: dir}
+
+	if got, ok := h.Elevation(1.3, 103.25); !ok || math.Abs(got-300) > 1e-6 {
+		t.Errorf("Elevation(1.3, 103.25) = %v, %v; want 300", got, ok)
+	}
+	// Halfway between samples 10 and 11.
+	if got, ok := h.Elevation(1.5, 103+10.5/1200); !ok || math.Abs(got-10.5) > 1e-6 {
+		t.Errorf("interpolated elevation = %v, %v; want 10.5", got, ok)
+	}
+	if _, ok := h.Elevation(1.5, 103.5); ok {
+		t.Error("void sample reported a value")
+	}
+	if _, ok := h.Elevation(2.5, 103.5); ok {
+		t.Error("missing tile reported a value")
+	}
+}
+
+func TestTerrariumTilesElevation(t *testing.T) {
+	// One zoom-0 tile covering the world, 250.5 m everywhere.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	v := 250.5 + 32768
+	c := color.RGBA{R: uint8(int(v) / 256), G: uint8(int(v) % 256), B: uint8((v - math.Floor(v)) * 256), A: 255}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "0", "0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "0", "0", "0.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tt := &TerrariumTiles{Dir: dir}
+	if got, ok := tt.Elevation(1.3, 103.8); !ok || math.Abs(got-250.5) > 1e-6 {
+		t.Errorf("Elevation = %v, %v; want 250.5", got, ok)
+	}
+	if _, ok := (&TerrariumTiles{Dir: dir, Zoom: 1}).Elevation(1.3, 103.8); ok {
+		t.Error("missing tile reported a value")
+	}
+}
+
+func TestParseWithElevation(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// About 9 m per 100 m northwards: a 9% grade along way 10.
+	slope := elevationFunc(func(lat, lon float64) (float64, bool) {
+		return (lat - 1.3) * 8000, true
+	})
+	result, err := Parse(context.Background(), f, ParseOptions{Profile: FootProfile{}, Elevation: slope})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if ele, ok := result.NodeElevation(2); !ok || math.Abs(ele-8) > 1e-3 {
+		t.Errorf("node 2 elevation = %v, %v; want 8", ele, ok)
+	}
+
+	var up, down *RawEdge
+	for i := range result.Edges {
+		e := &result.Edges[i]
+		switch {
+		case e.FromNodeID == 1 && e.ToNodeID == 2:
+			up = e
+		case e.FromNodeID == 2 && e.ToNodeID == 1:
+			down = e
+		}
+	}
+	if up == nil || down == nil {
+		t.Fatalf("missing edges between nodes 1 and 2: %+v", result.Edges)
+	}
+	if math.Abs(up.Ascent-8) > 1e-3 || up.Descent != 0 {
+		t.Errorf("1->2 climb = +%v/-%v, want +8/-0", up.Ascent, up.Descent)
+	}
+	if math.Abs(down.Descent-8) > 1e-3 || down.Ascent != 0 {
+		t.Errorf("2->1 climb = +%v/-%v, want +0/-8", down.Ascent, down.Descent)
+	}
+	if up.Weight <= down.Weight {
+		t.Errorf("uphill weight %d should exceed downhill weight %d", up.Weight, down.Weight)
+	}
+}
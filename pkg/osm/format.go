@@ -0,0 +1,124 @@
+package osm
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/paulmach/osm"
+	"github.com/paulmach/osm/osmpbf"
+	"github.com/paulmach/osm/osmxml"
+)
+
+// Format identifies an OSM input encoding.
+type Format int
+
+const (
+	FormatAuto     Format = iota // detect from the leading bytes of the input
+	FormatPBF                    // .osm.pbf
+	FormatXML                    // .osm (e.g. JOSM exports, hand-edited extracts)
+	FormatXMLBzip2               // .osm.bz2
+	FormatO5M                    // .o5m (osmconvert/osmfilter)
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatPBF:
+		return "pbf"
+	case FormatXML:
+		return "xml"
+	case FormatXMLBzip2:
+		return "xml+bzip2"
+	case FormatO5M:
+		return "o5m"
+	}
+	return "auto"
+}
+
+// FormatFromPath infers the format from a file name's extension, returning
+// FormatAuto when the extension is not recognized.
+func FormatFromPath(path string) Format {
+	p := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(p, ".pbf"):
+		return FormatPBF
+	case strings.HasSuffix(p, ".osm.bz2"), strings.HasSuffix(p, ".xml.bz2"):
+		return FormatXMLBzip2
+	case strings.HasSuffix(p, ".osm"), strings.HasSuffix(p, ".xml"):
+		return FormatXML
+	case strings.HasSuffix(p, ".o5m"):
+		return FormatO5M
+	}
+	return FormatAuto
+}
+
+// detectFormat sniffs the leading bytes of rs and rewinds it. bzip2 streams
+// start with "BZh"; o5m with a reset byte and header dataset; XML with "<"
+// (after an optional BOM and whitespace). Anything else is assumed to be PBF,
+// whose first bytes are a length prefix.
+func detectFormat(rs io.ReadSeeker) (Format, error) {
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(rs, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatAuto, fmt.Errorf("detect format: %w", err)
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return FormatAuto, fmt.Errorf("detect format: %w", err)
+	}
+	head := buf[:n]
+	if bytes.HasPrefix(head, []byte("BZh")) {
+		return FormatXMLBzip2, nil
+	}
+	if bytes.HasPrefix(head, []byte{o5mReset, o5mHeader}) {
+		return FormatO5M, nil
+	}
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if bytes.HasPrefix(head, []byte("<")) {
+		return FormatXML, nil
+	}
+	return FormatPBF, nil
+}
+
+// scanFilter selects which element types a pass needs. PBF skips the others
+// without decoding them; the other formats decode everything and scanSources
+// drops what the pass does not need.
+type scanFilter struct {
+	nodes, ways, relations bool
+}
+
+func (f scanFilter) admits(obj osm.Object) bool {
+	switch obj.(type) {
+	case *osm.Node:
+		return f.nodes
+	case *osm.Way:
+		return f.ways
+	case *osm.Relation:
+		return f.relations
+	}
+	return false
+}
+
+// newScanner rewinds rs and returns a scanner over it for one parse pass.
+func newScanner(ctx context.Context, rs io.ReadSeeker, format Format, want scanFilter) (osm.Scanner, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	switch format {
+	case FormatPBF:
+		s := osmpbf.New(ctx, rs, 1)
+		s.SkipNodes = !want.nodes
+		s.SkipWays = !want.ways
+		s.SkipRelations = !want.relations
+		return s, nil
+	case FormatXML:
+		return osmxml.New(ctx, rs), nil
+	case FormatXMLBzip2:
+		return osmxml.New(ctx, bzip2.NewReader(rs)), nil
+	case FormatO5M:
+		return newO5MScanner(ctx, rs), nil
+	}
+	return nil, fmt.Errorf("unsupported format %v", format)
+}
@@ -0,0 +1,67 @@
+package osm
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Format
+	}{
+		{"malaysia.osm.pbf", FormatPBF},
+		{"extract.OSM", FormatXML},
+		{"josm.xml", FormatXML},
+		{"extract.osm.bz2", FormatXMLBzip2},
+		{"graph.bin", FormatAuto},
+	}
+	for _, tt := range tests {
+		if got := FormatFromPath(tt.path); got != tt.want {
+			t.Errorf("FormatFromPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		head string
+		want Format
+	}{
+		{"BZh91AY&SY", FormatXMLBzip2},
+		{"<?xml version=\"1.0\"?><osm/>", FormatXML},
+		{"\xef\xbb\xbf\n  <osm version=\"0.6\">", FormatXML},
+		{"\x00\x00\x00\x0d\x0a\x09OSMHeader", FormatPBF},
+	}
+	for _, tt := range tests {
+		got, err := detectFormat(strings.NewReader(tt.head))
+		if err != nil || got != tt.want {
+			t.Errorf("detectFormat(%q) = %v, %v; want %v", tt.head, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseXMLAndBzip2(t *testing.T) {
+	for _, path := range []string{"testdata/tiny.osm", "testdata/tiny.osm.bz2"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		result, err := Parse(context.Background(), f)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", path, err)
+		}
+		// Way 10: two segments, both directions. Way 11: oneway, one segment.
+		// Way 12 is a footway and dropped.
+		if len(result.Edges) != 5 {
+			t.Errorf("%s: got %d edges, want 5", path, len(result.Edges))
+		}
+		if result.NumNodes() != 4 {
+			t.Errorf("%s: got %d node coordinates, want 4", path, result.NumNodes())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package osm
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// VehicleLimits are the physical restrictions of a way: the heaviest, tallest,
+// widest and longest vehicle allowed on it. Zero means no limit is tagged.
+type VehicleLimits struct {
+	MaxWeight float32 // tonnes
+	MaxHeight float32 // metres
+	MaxWidth  float32 // metres
+	MaxLength float32 // metres
+}
+
+// IsZero reports whether no limit is set.
+func (l VehicleLimits) IsZero() bool { return l == VehicleLimits{} }
+
+// Admits reports whether a vehicle of the given weight (t), height, width and
+// length (m) may use the way. A zero dimension is not checked.
+func (l VehicleLimits) Admits(weight, height, width, length float64) bool {
+	exceeds := func(limit float32, v float64) bool { return limit > 0 && v > 0 && v > float64(limit) }
+	return !exceeds(l.MaxWeight, weight) && !exceeds(l.MaxHeight, height) &&
+		!exceeds(l.MaxWidth, width) && !exceeds(l.MaxLength, length)
+}
+
+// wayLimits reads maxweight, maxheight, maxwidth and maxlength. Where both the
+// legal limit and a :physical one are tagged, the tighter applies.
+func wayLimits(tags osm.Tags) VehicleLimits {
+	tightest := func(parse func(string) (float64, bool), keys ...string) float32 {
+		var best float64
+		for _, k := range keys {
+			if v, ok := parse(tags.Find(k)); ok && (best == 0 || v < best) {
+				best = v
+			}
+		}
+		return float32(best)
+	}
+	return VehicleLimits{
+		MaxWeight: tightest(parseWeightTonnes, "maxweight"),
+		MaxHeight: tightest(parseLengthMetres, "maxheight", "maxheight:physical"),
+		MaxWidth:  tightest(parseLengthMetres, "maxwidth", "maxwidth:physical"),
+		MaxLength: tightest(parseLengthMetres, "maxlength"),
+	}
+}
+
+// parseWeightTonnes parses a maxweight value: a bare number (tonnes) or one
+// with a unit — t, kg, st (short tons) or lbs. Non-numeric values (none,
+// unsigned, default) report false.
+func parseWeightTonnes(s string) (float64, bool) {
+	num, unit, ok := splitQuantity(s)
+	if !ok {
+		return 0, false
+	}
+	switch unit {
+	case "", "t":
+		return num, true
+	case "kg":
+		return num / 1000, true
+	case "st":
+		return num * 0.90718474, true
+	case "lbs", "lb":
+		return num * 0.00045359237, true
+	}
+	return 0, false
+}
+
+// parseLengthMetres parses a maxheight/maxwidth/maxlength value: a bare number
+// (metres), one with a unit (m, cm, mm, ft, in, mi), or imperial feet and
+// inches written 12'6" or "12 ft 6 in". Non-numeric values (none, default,
+// below_default) report false.
+func parseLengthMetres(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if ft, in, ok := parseFeetInches(s); ok {
+		return ft*0.3048 + in*0.0254, true
+	}
+	num, unit, ok := splitQuantity(s)
+	if !ok {
+		return 0, false
+	}
+	switch unit {
+	case "", "m":
+		return num, true
+	case "cm":
+		return num / 100, true
+	case "mm":
+		return num / 1000, true
+	case "ft":
+		return num * 0.3048, true
+	case "in":
+		return num * 0.0254, true
+	case "mi":
+		return num * 1609.344, true
+	}
+	return 0, false
+}
+
+// parseFeetInches parses 12'6", 12' and "12 ft 6 in".
+func parseFeetInches(s string) (ft, in float64, ok bool) {
+	if f, rest, found := strings.Cut(s, "'"); found {
+		ft, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		rest = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "\""))
+		if rest == "" {
+			return ft, 0, true
+		}
+		in, err := strconv.ParseFloat(rest, 64)
+		return ft, in, err == nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 4 && fields[1] == "ft" && fields[3] == "in" {
+		ft, err1 := strconv.ParseFloat(fields[0], 64)
+		in, err2 := strconv.ParseFloat(fields[2], 64)
+		return ft, in, err1 == nil && err2 == nil
+	}
+	return 0, 0, false
+}
+
+// splitQuantity splits "7.5 t", "7.5t" or "7,5" into a positive number and a
+// lower-case unit.
+func splitQuantity(s string) (float64, string, bool) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != ',' })
+	if i < 0 {
+		i = len(s)
+	}
+	num, err := strconv.ParseFloat(strings.ReplaceAll(s[:i], ",", "."), 64)
+	if err != nil || num <= 0 || math.IsInf(num, 0) {
+		return 0, "", false
+	}
+	return num, strings.ToLower(strings.TrimSpace(s[i:])), true
+}
@@ -0,0 +1,72 @@
+package osm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseWeightTonnes(t *testing.T) {
+	cases := []struct {
+		in     string
+		want   float64
+		wantOK bool
+	}{
+		{"7.5", 7.5, true},
+		{"7.5 t", 7.5, true},
+		{"7,5t", 7.5, true},
+		{"7500 kg", 7.5, true},
+		{"10 st", 9.0718474, true},
+		{"20000 lbs", 9.0718474, true},
+		{"none", 0, false},
+		{"default", 0, false},
+		{"-3", 0, false},
+		{"5 furlongs", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseWeightTonnes(c.in)
+		if ok != c.wantOK || math.Abs(got-c.want) > 1e-6 {
+			t.Errorf("parseWeightTonnes(%q) = %v, %v; want %v, %v", c.in, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestParseLengthMetres(t *testing.T) {
+	cases := []struct {
+		in     string
+		want   float64
+		wantOK bool
+	}{
+		{"4.2", 4.2, true},
+		{"4.2 m", 4.2, true},
+		{"420 cm", 4.2, true},
+		{`12'6"`, 3.81, true},
+		{"12'", 3.6576, true},
+		{"12 ft 6 in", 3.81, true},
+		{"14 ft", 4.2672, true},
+		{"below_default", 0, false},
+		{"", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseLengthMetres(c.in)
+		if ok != c.wantOK || math.Abs(got-c.want) > 1e-6 {
+			t.Errorf("parseLengthMetres(%q) = %v, %v; want %v, %v", c.in, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestWayLimits(t *testing.T) {
+	l := wayLimits(tags("highway", "primary", "maxweight", "10", "maxheight", "4.5", "maxheight:physical", "4.1", "maxlength", "12 m"))
+	want := VehicleLimits{MaxWeight: 10, MaxHeight: 4.1, MaxLength: 12}
+	if l != want {
+		t.Errorf("wayLimits = %+v, want %+v", l, want)
+	}
+	if !l.Admits(7.5, 4.0, 2.5, 10) {
+		t.Error("a 7.5 t, 4.0 m truck should fit")
+	}
+	if l.Admits(0, 4.2, 0, 0) {
+		t.Error("a 4.2 m truck should not pass a 4.1 m bridge")
+	}
+	if l.Admits(12, 0, 0, 0) {
+		t.Error("a 12 t truck should not pass a 10 t limit")
+	}
+}
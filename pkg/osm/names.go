@@ -0,0 +1,62 @@
+package osm
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// RoadName is the street name and route reference of a way, as shown to users
+// in responses and turn instructions.
+type RoadName struct {
+	Name string // name tag, e.g. "Orchard Road"
+	Ref  string // ref and int_ref merged, ";"-separated, e.g. "PIE;AH2"
+}
+
+// IsZero reports whether the way carried neither a name nor a ref.
+func (n RoadName) IsZero() bool {
+	return n.Name == "" && n.Ref == ""
+}
+
+// wayRoadName extracts a way's name and refs. int_ref values are appended to
+// ref, skipping any the way already lists under ref.
+func wayRoadName(tags osm.Tags) RoadName {
+	var refs []string
+	for _, key := range []string{"ref", "int_ref"} {
+		for r := range strings.SplitSeq(tags.Find(key), ";") {
+			r = strings.TrimSpace(r)
+			if r != "" && !slices.Contains(refs, r) {
+				refs = append(refs, r)
+			}
+		}
+	}
+	return RoadName{
+		Name: strings.TrimSpace(tags.Find("name")),
+		Ref:  strings.Join(refs, ";"),
+	}
+}
+
+// nameTable interns road names so every edge of a way (and every way of the
+// same street) shares one entry. Index 0 is always the unnamed zero value.
+type nameTable struct {
+	names []RoadName
+	index map[RoadName]uint32
+}
+
+func newNameTable() *nameTable {
+	return &nameTable{
+		names: []RoadName{{}},
+		index: map[RoadName]uint32{{}: 0},
+	}
+}
+
+func (t *nameTable) intern(n RoadName) uint32 {
+	if idx, ok := t.index[n]; ok {
+		return idx
+	}
+	idx := uint32(len(t.names))
+	t.names = append(t.names, n)
+	t.index[n] = idx
+	return idx
+}
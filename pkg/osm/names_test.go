@@ -0,0 +1,69 @@
+package osm
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestWayRoadName(t *testing.T) {
+	tests := []struct {
+		kv   []string
+		want RoadName
+	}{
+		{[]string{"highway", "service"}, RoadName{}},
+		{[]string{"name", " Orchard Road "}, RoadName{Name: "Orchard Road"}},
+		{[]string{"ref", "PIE"}, RoadName{Ref: "PIE"}},
+		{[]string{"ref", "1; AH2", "int_ref", "AH2;E1"}, RoadName{Ref: "1;AH2;E1"}},
+		{[]string{"name", "Jalan Tun Razak", "int_ref", "AH141"}, RoadName{Name: "Jalan Tun Razak", Ref: "AH141"}},
+	}
+	for _, tt := range tests {
+		if got := wayRoadName(tags(tt.kv...)); got != tt.want {
+			t.Errorf("wayRoadName(%q) = %+v, want %+v", tt.kv, got, tt.want)
+		}
+	}
+}
+
+func TestNameTableInterns(t *testing.T) {
+	nt := newNameTable()
+	if idx := nt.intern(RoadName{}); idx != 0 {
+		t.Errorf("unnamed interned at %d, want 0", idx)
+	}
+	a := nt.intern(RoadName{Name: "A"})
+	b := nt.intern(RoadName{Ref: "A"})
+	if a == b || nt.intern(RoadName{Name: "A"}) != a {
+		t.Errorf("intern: a=%d b=%d, want distinct stable indices", a, b)
+	}
+	if len(nt.names) != 3 {
+		t.Errorf("table has %d entries, want 3", len(nt.names))
+	}
+}
+
+func TestParseRoadNames(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	result, err := Parse(context.Background(), f)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(result.Names) == 0 || !result.Names[0].IsZero() {
+		t.Fatalf("Names[0] = %+v, want the unnamed zero value", result.Names)
+	}
+	for _, e := range result.Edges {
+		got := result.Names[e.Name]
+		var want RoadName
+		switch {
+		case e.FromNodeID <= 3 && e.ToNodeID <= 3:
+			want = RoadName{Name: "Jalan Satu"}
+		default:
+			want = RoadName{Ref: "1;AH2"}
+		}
+		if got != want {
+			t.Errorf("edge %d->%d name = %+v, want %+v", e.FromNodeID, e.ToNodeID, got, want)
+		}
+	}
+}
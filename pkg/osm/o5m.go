@@ -0,0 +1,360 @@
+package osm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/paulmach/osm"
+)
+
+// o5m dataset types. Types 0xf0 and above carry no length.
+const (
+	o5mNode      = 0x10
+	o5mWay       = 0x11
+	o5mRelation  = 0x12
+	o5mHeader    = 0xe0
+	o5mEndOfFile = 0xfe
+	o5mReset     = 0xff
+)
+
+const (
+	o5mStringTableSize = 15000 // strings remembered for back-references
+	o5mMaxTableString  = 250   // longer strings are never added to the table
+	o5mCoordScale      = 1e-7  // coordinates are stored in 100-nanodegree units
+)
+
+// o5mScanner reads the o5m format produced by osmconvert and osmfilter. It
+// implements osm.Scanner, yielding *osm.Node, *osm.Way and *osm.Relation.
+// Deleted objects (o5c change files) and author metadata are skipped.
+//
+// o5m is a stream of length-prefixed datasets whose ids, coordinates and
+// references are delta-coded against the previous dataset, and whose tag
+// strings may refer back to a table of recently seen strings. A reset byte
+// (0xff) clears both, which is how files are made seekable.
+type o5mScanner struct {
+	ctx context.Context
+	r   *bufio.Reader
+	buf []byte
+
+	obj osm.Object
+	err error
+
+	// Delta state, cleared by a reset.
+	nodeID, wayID, relID int64
+	timestamp, changeset int64
+	lon, lat             int64
+	wayRef               int64
+	memberRef            [3]int64 // node, way, relation members
+
+	table [o5mStringTableSize][2]string
+	next  int // slot the next string goes into
+}
+
+func newO5MScanner(ctx context.Context, r io.Reader) *o5mScanner {
+	return &o5mScanner{ctx: ctx, r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// Scan advances to the next node, way or relation.
+func (s *o5mScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for {
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+		typ, err := s.r.ReadByte()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		switch {
+		case typ == o5mReset:
+			s.reset()
+			continue
+		case typ == o5mEndOfFile:
+			return false
+		case typ >= 0xf0:
+			continue // other length-less markers carry no data
+		}
+
+		n, err := readUvarint(s.r)
+		if err != nil {
+			s.err = fmt.Errorf("o5m: dataset 0x%02x length: %w", typ, err)
+			return false
+		}
+		if n > 1<<28 {
+			s.err = fmt.Errorf("o5m: dataset 0x%02x length %d out of range", typ, n)
+			return false
+		}
+		if uint64(cap(s.buf)) < n {
+			s.buf = make([]byte, n)
+		}
+		s.buf = s.buf[:n]
+		if _, err := io.ReadFull(s.r, s.buf); err != nil {
+			s.err = fmt.Errorf("o5m: dataset 0x%02x: %w", typ, err)
+			return false
+		}
+
+		d := &o5mDataset{s: s, b: s.buf}
+		var obj osm.Object
+		switch typ {
+		case o5mNode:
+			obj = d.node()
+		case o5mWay:
+			obj = d.way()
+		case o5mRelation:
+			obj = d.relation()
+		default:
+			continue // header, bounding box, timestamp, sync, jump
+		}
+		if d.err != nil {
+			s.err = fmt.Errorf("o5m: dataset 0x%02x: %w", typ, d.err)
+			return false
+		}
+		if obj == nil {
+			continue // deleted object
+		}
+		s.obj = obj
+		return true
+	}
+}
+
+// Object returns the object read by the last successful Scan.
+func (s *o5mScanner) Object() osm.Object { return s.obj }
+
+// Err returns the first error encountered, if any.
+func (s *o5mScanner) Err() error { return s.err }
+
+// Close releases nothing; the caller owns the underlying reader.
+func (s *o5mScanner) Close() error { return nil }
+
+func (s *o5mScanner) reset() {
+	s.nodeID, s.wayID, s.relID = 0, 0, 0
+	s.timestamp, s.changeset = 0, 0
+	s.lon, s.lat = 0, 0
+	s.wayRef = 0
+	s.memberRef = [3]int64{}
+	s.table = [o5mStringTableSize][2]string{}
+	s.next = 0
+}
+
+func (s *o5mScanner) remember(pair [2]string) {
+	s.table[s.next] = pair
+	s.next = (s.next + 1) % o5mStringTableSize
+}
+
+// lookup returns the string (pair) added ref insertions ago (1 = latest).
+func (s *o5mScanner) lookup(ref uint64) ([2]string, error) {
+	if ref == 0 || ref > o5mStringTableSize {
+		return [2]string{}, fmt.Errorf("string reference %d out of range", ref)
+	}
+	return s.table[(s.next-int(ref)+o5mStringTableSize)%o5mStringTableSize], nil
+}
+
+var errO5MShort = errors.New("truncated dataset")
+
+// o5mDataset decodes one dataset payload. The first error sticks and turns
+// every later read into a zero value.
+type o5mDataset struct {
+	s   *o5mScanner
+	b   []byte
+	err error
+}
+
+func (d *o5mDataset) uvarint() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		if d.err != nil || len(d.b) == 0 || shift > 63 {
+			d.fail(errO5MShort)
+			return 0
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v
+		}
+	}
+}
+
+// svarint decodes o5m's signed varint: the low bit is the sign.
+func (d *o5mDataset) svarint() int64 {
+	u := d.uvarint()
+	if u&1 != 0 {
+		return -int64(u>>1) - 1
+	}
+	return int64(u >> 1)
+}
+
+func (d *o5mDataset) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// cstring reads bytes up to the next 0x00.
+func (d *o5mDataset) cstring() string {
+	for i, c := range d.b {
+		if c == 0 {
+			str := string(d.b[:i])
+			d.b = d.b[i+1:]
+			return str
+		}
+	}
+	d.fail(errO5MShort)
+	return ""
+}
+
+// pair reads an inline (0x00-prefixed) or referenced string pair. With single
+// set, the inline form holds one string (relation member roles).
+func (d *o5mDataset) pair(single bool) [2]string {
+	if d.err != nil {
+		return [2]string{}
+	}
+	if len(d.b) > 0 && d.b[0] == 0 {
+		d.b = d.b[1:]
+		var p [2]string
+		p[0] = d.cstring()
+		if !single {
+			p[1] = d.cstring()
+		}
+		if d.err == nil && len(p[0])+len(p[1]) <= o5mMaxTableString {
+			d.s.remember(p)
+		}
+		return p
+	}
+	p, err := d.s.lookup(d.uvarint())
+	if err != nil {
+		d.fail(err)
+	}
+	return p
+}
+
+// header decodes the id and version/author section shared by every object.
+// It reports false for a deleted object (a dataset holding only the id).
+func (d *o5mDataset) header(id *int64) bool {
+	*id += d.svarint()
+	if len(d.b) == 0 {
+		return false
+	}
+	if version := d.uvarint(); version != 0 {
+		d.s.timestamp += d.svarint()
+		if d.s.timestamp != 0 {
+			d.s.changeset += d.svarint()
+			d.pair(false) // uid, user
+		}
+	}
+	return true
+}
+
+func (d *o5mDataset) tags() osm.Tags {
+	var tags osm.Tags
+	for len(d.b) > 0 && d.err == nil {
+		p := d.pair(false)
+		tags = append(tags, osm.Tag{Key: p[0], Value: p[1]})
+	}
+	return tags
+}
+
+func (d *o5mDataset) node() osm.Object {
+	if !d.header(&d.s.nodeID) {
+		return nil
+	}
+	d.s.lon += d.svarint()
+	d.s.lat += d.svarint()
+	n := &osm.Node{
+		ID:  osm.NodeID(d.s.nodeID),
+		Lon: float64(d.s.lon) * o5mCoordScale,
+		Lat: float64(d.s.lat) * o5mCoordScale,
+	}
+	n.Tags = d.tags()
+	n.Visible = true
+	return n
+}
+
+// section returns a sub-dataset over the next length-prefixed section.
+func (d *o5mDataset) section() *o5mDataset {
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.b)) {
+		d.fail(errO5MShort)
+		return &o5mDataset{s: d.s, err: d.err}
+	}
+	sub := &o5mDataset{s: d.s, b: d.b[:n]}
+	d.b = d.b[n:]
+	return sub
+}
+
+func (d *o5mDataset) way() osm.Object {
+	if !d.header(&d.s.wayID) {
+		return nil
+	}
+	w := &osm.Way{ID: osm.WayID(d.s.wayID), Visible: true}
+	refs := d.section()
+	for len(refs.b) > 0 && refs.err == nil {
+		d.s.wayRef += refs.svarint()
+		w.Nodes = append(w.Nodes, osm.WayNode{ID: osm.NodeID(d.s.wayRef)})
+	}
+	d.fail(refs.err)
+	w.Tags = d.tags()
+	return w
+}
+
+var o5mMemberTypes = [3]osm.Type{osm.TypeNode, osm.TypeWay, osm.TypeRelation}
+
+func (d *o5mDataset) relation() osm.Object {
+	if !d.header(&d.s.relID) {
+		return nil
+	}
+	rel := &osm.Relation{ID: osm.RelationID(d.s.relID), Visible: true}
+	refs := d.section()
+	for len(refs.b) > 0 && refs.err == nil {
+		delta := refs.svarint()
+		role := refs.pair(true)[0] // type digit followed by the role
+		if refs.err != nil {
+			break
+		}
+		if role == "" || role[0] < '0' || role[0] > '2' {
+			refs.fail(fmt.Errorf("bad member type in %q", role))
+			break
+		}
+		t := role[0] - '0'
+		d.s.memberRef[t] += delta
+		rel.Members = append(rel.Members, osm.Member{
+			Type: o5mMemberTypes[t],
+			Ref:  d.s.memberRef[t],
+			Role: role[1:],
+		})
+	}
+	d.fail(refs.err)
+	rel.Tags = d.tags()
+	return rel
+}
+
+// readUvarint reads an o5m (LEB128) unsigned varint from r.
+func readUvarint(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift <= 63; shift += 7 {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("varint overflow")
+}
+
+var _ osm.Scanner = (*o5mScanner)(nil)
@@ -0,0 +1,194 @@
+package osm
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+// o5mWriter builds o5m test input. Strings are always written inline except
+// where a test deliberately writes a back-reference.
+type o5mWriter struct {
+	out bytes.Buffer
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendSvarint(b []byte, v int64) []byte {
+	if v < 0 {
+		return appendUvarint(b, uint64(-v-1)<<1|1)
+	}
+	return appendUvarint(b, uint64(v)<<1)
+}
+
+func appendPair(b []byte, k, v string) []byte {
+	b = append(b, 0)
+	b = append(b, k...)
+	b = append(b, 0)
+	b = append(b, v...)
+	return append(b, 0)
+}
+
+func (w *o5mWriter) dataset(typ byte, payload []byte) {
+	w.out.WriteByte(typ)
+	w.out.Write(appendUvarint(nil, uint64(len(payload))))
+	w.out.Write(payload)
+}
+
+func buildTestO5M() []byte {
+	var w o5mWriter
+	w.out.WriteByte(o5mReset)
+	w.dataset(o5mHeader, []byte("o5m2"))
+
+	// Nodes 1..3 along a meridian, delta coded; version 0 (no author data).
+	coords := [][2]int64{{1038000000, 13000000}, {1038000000, 13010000}, {1038010000, 13010000}}
+	var prevID, prevLon, prevLat int64
+	for i, c := range coords {
+		id := int64(i + 1)
+		p := appendSvarint(nil, id-prevID)
+		p = appendUvarint(p, 0)
+		p = appendSvarint(p, c[0]-prevLon)
+		p = appendSvarint(p, c[1]-prevLat)
+		if i == 2 {
+			p = appendPair(p, "barrier", "bollard")
+		}
+		w.dataset(o5mNode, p)
+		prevID, prevLon, prevLat = id, c[0], c[1]
+	}
+
+	// Way 10: 1-2-3, with author data to exercise the version section.
+	p := appendSvarint(nil, 10)
+	p = appendUvarint(p, 3)          // version
+	p = appendSvarint(p, 1700000000) // timestamp
+	p = appendSvarint(p, 42)         // changeset
+	p = appendPair(p, "\x07", "alice")
+	refs := appendSvarint(nil, 1)
+	refs = appendSvarint(refs, 1)
+	refs = appendSvarint(refs, 1)
+	p = appendUvarint(p, uint64(len(refs)))
+	p = append(p, refs...)
+	p = appendPair(p, "highway", "residential")
+	w.dataset(o5mWay, p)
+
+	// Way 11: 3-1, reusing "highway=residential" by reference. The table
+	// holds (newest first) highway=residential, then the uid/user pair.
+	p = appendSvarint(nil, 1)
+	p = appendUvarint(p, 0)
+	refs = appendSvarint(nil, 0)   // delta from way 10's last ref: 3
+	refs = appendSvarint(refs, -2) // 3 - 2 = 1
+	p = appendUvarint(p, uint64(len(refs)))
+	p = append(p, refs...)
+	p = appendUvarint(p, 1)
+	w.dataset(o5mWay, p)
+
+	// Relation 100: no_left_turn from way 10 via node 3 to way 11.
+	p = appendSvarint(nil, 100)
+	p = appendUvarint(p, 0)
+	var m []byte
+	m = appendSvarint(m, 10)
+	m = append(m, 0)
+	m = append(m, "1from"...)
+	m = append(m, 0)
+	m = appendSvarint(m, 3)
+	m = append(m, 0)
+	m = append(m, "0via"...)
+	m = append(m, 0)
+	m = appendSvarint(m, 1) // way member delta: 10 + 1 = 11
+	m = append(m, 0)
+	m = append(m, "1to"...)
+	m = append(m, 0)
+	p = appendUvarint(p, uint64(len(m)))
+	p = append(p, m...)
+	p = appendPair(p, "type", "restriction")
+	p = appendPair(p, "restriction", "no_left_turn")
+	w.dataset(o5mRelation, p)
+
+	// A deleted node (id only) is skipped.
+	w.dataset(o5mNode, appendSvarint(nil, 5))
+
+	w.out.WriteByte(o5mEndOfFile)
+	return w.out.Bytes()
+}
+
+func TestO5MScanner(t *testing.T) {
+	s := newO5MScanner(context.Background(), bytes.NewReader(buildTestO5M()))
+	var objs []osm.Object
+	for s.Scan() {
+		objs = append(objs, s.Object())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(objs) != 6 {
+		t.Fatalf("got %d objects, want 6 (3 nodes, 2 ways, 1 relation)", len(objs))
+	}
+
+	n := objs[2].(*osm.Node)
+	if n.ID != 3 || math.Abs(n.Lon-103.801) > 1e-9 || math.Abs(n.Lat-1.301) > 1e-9 {
+		t.Errorf("node 3 = %d (%v, %v)", n.ID, n.Lat, n.Lon)
+	}
+	if n.Tags.Find("barrier") != "bollard" {
+		t.Errorf("node 3 tags = %v", n.Tags)
+	}
+
+	w := objs[4].(*osm.Way)
+	if w.ID != 11 || len(w.Nodes) != 2 || w.Nodes[0].ID != 3 || w.Nodes[1].ID != 1 {
+		t.Errorf("way 11 = %d %v", w.ID, w.Nodes)
+	}
+	if w.Tags.Find("highway") != "residential" {
+		t.Errorf("way 11 string reference not resolved: %v", w.Tags)
+	}
+
+	r := objs[5].(*osm.Relation)
+	if r.ID != 100 || len(r.Members) != 3 {
+		t.Fatalf("relation = %d with %d members", r.ID, len(r.Members))
+	}
+	want := []osm.Member{
+		{Type: osm.TypeWay, Ref: 10, Role: "from"},
+		{Type: osm.TypeNode, Ref: 3, Role: "via"},
+		{Type: osm.TypeWay, Ref: 11, Role: "to"},
+	}
+	for i, m := range r.Members {
+		if m.Type != want[i].Type || m.Ref != want[i].Ref || m.Role != want[i].Role {
+			t.Errorf("member %d = %+v, want %+v", i, m, want[i])
+		}
+	}
+}
+
+func TestO5MScannerTruncated(t *testing.T) {
+	data := buildTestO5M()
+	s := newO5MScanner(context.Background(), bytes.NewReader(data[:len(data)/2]))
+	for s.Scan() {
+	}
+	if s.Err() == nil {
+		t.Error("expected an error for truncated input")
+	}
+}
+
+func TestParseO5M(t *testing.T) {
+	data := buildTestO5M()
+	if f, _ := detectFormat(bytes.NewReader(data)); f != FormatO5M {
+		t.Fatalf("detectFormat = %v, want o5m", f)
+	}
+	result, err := Parse(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Way 10: 2 segments x 2 directions; way 11: 1 segment x 2 directions.
+	if len(result.Edges) != 6 {
+		t.Errorf("got %d edges, want 6", len(result.Edges))
+	}
+	if len(result.Restrictions) != 1 || !slices.Equal(result.Restrictions[0].Path, []osm.NodeID{2, 3, 1}) {
+		t.Errorf("restrictions = %+v, want one over path 2-3-1", result.Restrictions)
+	}
+}
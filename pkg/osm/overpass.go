@@ -0,0 +1,228 @@
+package osm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOverpassEndpoint is the main public Overpass API instance.
+const DefaultOverpassEndpoint = "https://overpass-api.de/api/interpreter"
+
+// OverpassClient downloads OSM data for small areas from an Overpass API
+// instance, so a study area can be built without first fetching a regional
+// PBF extract. Requests are spaced at least MinInterval apart and retried
+// with exponential backoff when the server is busy, as the public instances'
+// usage policy asks. A client is safe for concurrent use.
+type OverpassClient struct {
+	Endpoint    string        // interpreter URL; "" → DefaultOverpassEndpoint
+	HTTPClient  *http.Client  // nil → http.DefaultClient
+	MaxRetries  int           // retries after a failed attempt; 0 → 4, negative → none
+	RetryDelay  time.Duration // first backoff, doubling per retry; 0 → 5s
+	MinInterval time.Duration // minimum gap between request starts; 0 → 2s
+	TimeoutSec  int           // server-side query timeout; 0 → 180
+
+	mu   sync.Mutex
+	last time.Time // start of the previous request
+}
+
+// overpassRemarkError matches the runtime errors Overpass reports inside an
+// otherwise successful (HTTP 200) response, e.g. a query timeout.
+var overpassRemarkError = regexp.MustCompile(`<remark>\s*runtime error:([^<]*)</remark>`)
+
+// FetchArea downloads, as OSM XML, every way of the given highway classes
+// inside bbox (or, when boundary is non-nil, inside its polygons' outer
+// rings), the nodes of those ways and the turn restriction relations over
+// them. The result parses with Parse like any .osm file.
+func (c *OverpassClient) FetchArea(ctx context.Context, highways []string, bbox BBox, boundary *Boundary) ([]byte, error) {
+	q, err := overpassQuery(highways, bbox, boundary, c.timeoutSec())
+	if err != nil {
+		return nil, err
+	}
+	return c.Fetch(ctx, q)
+}
+
+// Fetch runs an Overpass QL query and returns the response body.
+func (c *OverpassClient) Fetch(ctx context.Context, query string) ([]byte, error) {
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = 4
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = 5 * time.Second
+	}
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := c.do(ctx, query)
+		if err == nil {
+			return body, nil
+		}
+		lastErr = err
+		var perm permanentError
+		if errors.As(err, &perm) || ctx.Err() != nil || attempt >= retries {
+			break
+		}
+		wait := max(retryAfter, delay<<attempt)
+		if err := sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("overpass: %w", lastErr)
+}
+
+// permanentError marks a failure that retrying cannot fix (a bad query).
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// do makes one rate-limited request. retryAfter is the server's requested
+// wait, if it named one.
+func (c *OverpassClient) do(ctx context.Context, query string) (body []byte, retryAfter time.Duration, err error) {
+	if err := c.throttle(ctx); err != nil {
+		return nil, 0, err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultOverpassEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint,
+		strings.NewReader(url.Values{"data": {query}}.Encode()))
+	if err != nil {
+		return nil, 0, permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "map_router-preprocess")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			retryAfter = time.Duration(s) * time.Second
+		}
+		return nil, retryAfter, fmt.Errorf("%s", resp.Status)
+	default:
+		return nil, 0, permanentError{fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(firstLine(body)))}
+	}
+	if m := overpassRemarkError.FindSubmatch(body); m != nil {
+		return nil, 0, fmt.Errorf("runtime error:%s", m[1])
+	}
+	return body, 0, nil
+}
+
+// throttle waits until MinInterval has passed since the previous request.
+func (c *OverpassClient) throttle(ctx context.Context) error {
+	interval := c.MinInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	c.mu.Lock()
+	next := c.last.Add(interval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.last = next
+	c.mu.Unlock()
+	return sleepCtx(ctx, time.Until(next))
+}
+
+func (c *OverpassClient) timeoutSec() int {
+	if c.TimeoutSec <= 0 {
+		return 180
+	}
+	return c.TimeoutSec
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func firstLine(b []byte) []byte {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+// overpassQuery builds the Overpass QL for FetchArea. Holes in the boundary
+// are not expressible as Overpass filters; they are left to the parser's own
+// Boundary filter.
+func overpassQuery(highways []string, bbox BBox, boundary *Boundary, timeoutSec int) (string, error) {
+	if len(highways) == 0 {
+		return "", errors.New("overpass: no highway classes to fetch")
+	}
+	var filters []string
+	switch {
+	case boundary != nil:
+		for _, poly := range boundary.polygons {
+			var sb strings.Builder
+			for i, pt := range poly[0] {
+				if i > 0 {
+					sb.WriteByte(' ')
+				}
+				fmt.Fprintf(&sb, "%s %s", formatCoord(pt[1]), formatCoord(pt[0]))
+			}
+			filters = append(filters, fmt.Sprintf(`(poly:"%s")`, sb.String()))
+		}
+	case !bbox.IsZero():
+		filters = []string{fmt.Sprintf("(%s,%s,%s,%s)",
+			formatCoord(bbox.MinLat), formatCoord(bbox.MinLng), formatCoord(bbox.MaxLat), formatCoord(bbox.MaxLng))}
+	default:
+		return "", errors.New("overpass: a bounding box or boundary is required")
+	}
+
+	classes := make([]string, len(highways))
+	for i, hw := range highways {
+		classes[i] = regexp.QuoteMeta(hw)
+	}
+	var q strings.Builder
+	fmt.Fprintf(&q, "[out:xml][timeout:%d];\n(\n", timeoutSec)
+	for _, f := range filters {
+		fmt.Fprintf(&q, "  way[\"highway\"~\"^(%s)$\"]%s;\n", strings.Join(classes, "|"), f)
+	}
+	q.WriteString(")->.ways;\n")
+	q.WriteString("relation(bw.ways)[\"type\"=\"restriction\"]->.restrictions;\n")
+	q.WriteString("(.ways; .ways >; .restrictions;);\n")
+	q.WriteString("out body;\n")
+	return q.String(), nil
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
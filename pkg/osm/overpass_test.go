@@ -0,0 +1,99 @@
+package osm
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOverpassQuery(t *testing.T) {
+	bbox := BBox{MinLat: 1.29, MinLng: 103.84, MaxLat: 1.31, MaxLng: 103.86}
+	q, err := overpassQuery([]string{"primary", "residential"}, bbox, nil, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"[timeout:60]",
+		`way["highway"~"^(primary|residential)$"](1.29,103.84,1.31,103.86);`,
+		`relation(bw.ways)["type"="restriction"]`,
+	} {
+		if !strings.Contains(q, want) {
+			t.Errorf("query lacks %q:\n%s", want, q)
+		}
+	}
+
+	b := &Boundary{polygons: [][]ring{{{{103.84, 1.29}, {103.86, 1.29}, {103.85, 1.31}, {103.84, 1.29}}}}}
+	q, err = overpassQuery([]string{"primary"}, BBox{}, b, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(poly:"1.29 103.84 1.29 103.86 1.31 103.85 1.29 103.84")`; !strings.Contains(q, want) {
+		t.Errorf("query lacks %q:\n%s", want, q)
+	}
+
+	if _, err := overpassQuery([]string{"primary"}, BBox{}, nil, 60); err == nil {
+		t.Error("query without an area was accepted")
+	}
+}
+
+func TestOverpassFetchRetries(t *testing.T) {
+	data, err := os.ReadFile("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.FormValue("data"), "way[") {
+			t.Errorf("request has no query: %q", r.FormValue("data"))
+		}
+		switch calls.Add(1) {
+		case 1:
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		case 2:
+			w.Write([]byte(`<osm><remark> runtime error: Query timed out </remark></osm>`))
+		default:
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+
+	c := &OverpassClient{Endpoint: srv.URL, RetryDelay: time.Millisecond, MinInterval: time.Millisecond}
+	bbox := BBox{MinLat: 1, MinLng: 103, MaxLat: 2, MaxLng: 104}
+	got, err := c.FetchArea(context.Background(), CarProfile{}.Highways(), bbox, nil)
+	if err != nil {
+		t.Fatalf("FetchArea: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("made %d requests, want 3", calls.Load())
+	}
+	result, err := Parse(context.Background(), bytes.NewReader(got), ParseOptions{Format: FormatXML})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(result.Edges) == 0 {
+		t.Error("downloaded data produced no edges")
+	}
+}
+
+func TestOverpassFetchGivesUpOnBadQuery(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "parse error", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := &OverpassClient{Endpoint: srv.URL, RetryDelay: time.Millisecond, MinInterval: time.Millisecond}
+	if _, err := c.Fetch(context.Background(), "nonsense"); err == nil {
+		t.Error("Fetch succeeded on HTTP 400")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("made %d requests, want 1 (400 is not retried)", calls.Load())
+	}
+}
@@ -7,19 +7,30 @@ import (
 	"io"
 	"log"
 	"math"
+	"slices"
+	"time"
 
 	"github.com/paulmach/osm"
-	"github.com/paulmach/osm/osmpbf"
 )
 
 // RawEdge represents a directed edge parsed from OSM data.
 type RawEdge struct {
 	FromNodeID osm.NodeID
 	ToNodeID   osm.NodeID
-	Weight     uint32    // travel time in ms, or physical distance in cm when ParseOptions.Distance is set
-	ShapeLats  []float64 // intermediate shape node latitudes (excluding from/to)
-	ShapeLons  []float64 // intermediate shape node longitudes (excluding from/to)
-	Restricted bool      // gated/private (access=private/permit/residents); last-mile only
+	Weight     uint32        // travel time in ms, or physical distance in cm when ParseOptions.Distance is set
+	SpeedKmh   float64       // free-flow speed in this direction (maxspeed or class default)
+	ShapeLats  []float64     // intermediate shape node latitudes (excluding from/to)
+	ShapeLons  []float64     // intermediate shape node longitudes (excluding from/to)
+	Restricted bool          // gated/private (access=private/permit/residents); last-mile only
+	Name       uint32        // index into ParseResult.Names; 0 = unnamed
+	Ascent     float64       // total climb along the edge in metres (0 without elevation data)
+	Descent    float64       // total drop along the edge in metres (0 without elevation data)
+	Limits     VehicleLimits // maxweight/maxheight/maxwidth/maxlength of the way; zero = none
+	WayID      osm.WayID     // OSM way the edge was built from (the first one, when merged across ways)
+	Roundabout bool          // part of a junction=roundabout or junction=circular way
+	Class      RoadClass     // highway class of the way (the first one, when merged across ways)
+	Flags      WayFlags      // oneway, bridge, tunnel, toll, ferry and surface of the way
+	Window     string        // opening_hours schedule when this direction is open (reversible lanes, see ParseOptions.KeepReversible); "" = always
 }
 
 // computeWeightMs converts a segment length (m) and speed (km/h) to travel time
@@ -52,9 +63,75 @@ func computeWeightDistanceCm(lengthMeters float64) uint32 {
 
 // ParseResult holds the output of parsing an OSM PBF file.
 type ParseResult struct {
-	Edges   []RawEdge
+	Edges []RawEdge
+
+	// Coords holds the coordinates of every node the edges reference. The
+	// parser fills it; hand-built results (tests, embedders) may leave it nil
+	// and use the NodeLat/NodeLon maps instead. Read through NodeCoord.
+	Coords  *NodeCoords
 	NodeLat map[osm.NodeID]float64
 	NodeLon map[osm.NodeID]float64
+
+	Restrictions []TurnRestriction // car turn restrictions over the kept ways
+	Names        []RoadName        // interned way names; Names[0] is the unnamed zero value
+
+	// NodeControls flags routing nodes that slow traffic passing through them
+	// (signals, pedestrian crossings), for junction penalties in the weight
+	// model. Only nodes on kept ways are listed.
+	NodeControls map[osm.NodeID]NodeControl
+}
+
+// NodeCoord returns the coordinates of node id from Coords, or from the
+// NodeLat/NodeLon maps when Coords is nil.
+func (r *ParseResult) NodeCoord(id osm.NodeID) (lat, lon float64, ok bool) {
+	if r.Coords != nil {
+		return r.Coords.Get(id)
+	}
+	lat, ok = r.NodeLat[id]
+	return lat, r.NodeLon[id], ok
+}
+
+// NodeElevation returns the elevation of node id in metres, when
+// ParseOptions.Elevation covered it.
+func (r *ParseResult) NodeElevation(id osm.NodeID) (float64, bool) {
+	return r.Coords.Elevation(id)
+}
+
+// NumNodes returns the number of nodes with known coordinates.
+func (r *ParseResult) NumNodes() int {
+	if r.Coords != nil {
+		return r.Coords.Len()
+	}
+	return len(r.NodeLat)
+}
+
+// NodeControl is a bit set describing traffic control at a node.
+type NodeControl uint8
+
+const (
+	ControlTrafficSignals NodeControl = 1 << iota // highway=traffic_signals, or a signalled crossing
+	ControlCrossing                               // highway=crossing (pedestrian/cyclist crossing)
+)
+
+// Has reports whether every bit of flag is set in c.
+func (c NodeControl) Has(flag NodeControl) bool {
+	return c&flag == flag
+}
+
+// nodeControl classifies a node's traffic control tags. A crossing tagged
+// crossing=traffic_signals counts as both a crossing and a signal.
+func nodeControl(tags osm.Tags) NodeControl {
+	var c NodeControl
+	switch tags.Find("highway") {
+	case "traffic_signals":
+		c |= ControlTrafficSignals
+	case "crossing":
+		c |= ControlCrossing
+		if tags.Find("crossing") == "traffic_signals" {
+			c |= ControlTrafficSignals
+		}
+	}
+	return c
 }
 
 // carHighways lists highway tag values accessible by car.
@@ -77,26 +154,61 @@ var carHighways = map[string]bool{
 
 // classifyAccess decides whether a car-class way is kept, and if kept whether it
 // is "restricted" (gated/private — usable for last-mile access; the
-// restricted-cluster filter later inlines or penalizes it). access governs over
-// motor_vehicle. access=destination and access=customers stay PUBLIC: Google
-// routes through them freely in this region, and restricting them measurably
-// hurt route agreement (round-3 sweep, 2026-07).
+// restricted-cluster filter later inlines or penalizes it), for private cars.
+// See vehicleAccess.
 func classifyAccess(tags osm.Tags) (keep, restricted bool) {
+	return vehicleAccess(tags, "motorcar")
+}
+
+// accessParent maps each transport-mode access key to the next more general
+// one in the OSM access hierarchy (access → vehicle → motor_vehicle →
+// motorcar/hgv/psv/…), ending at access.
+var accessParent = map[string]string{
+	"vehicle": "access", "foot": "access",
+	"bicycle": "vehicle", "motor_vehicle": "vehicle",
+	"motorcar": "motor_vehicle", "motorcycle": "motor_vehicle", "goods": "motor_vehicle",
+	"hgv": "motor_vehicle", "psv": "motor_vehicle",
+	"bus": "psv", "taxi": "psv",
+}
+
+// classAccess returns the access value governing a vehicle class (a key of
+// accessParent, e.g. "motorcar", "hgv", "bus"): the value of the most
+// specific key of its hierarchy that is tagged, and that key. Both are empty
+// when no key in the chain is tagged.
+func classAccess(tags osm.Tags, class string) (value, key string) {
+	for k := class; k != ""; k = accessParent[k] {
+		if v := tags.Find(k); v != "" {
+			return v, k
+		}
+	}
+	return "", ""
+}
+
+// vehicleAccess classifies a car-class way for a motor vehicle class. The
+// most specific tag of the class's hierarchy decides (motorcar=yes opens an
+// access=no road to cars; vehicle=no or goods=no closes one), except that a
+// gated access=private/permit/residents governs over everything: mappers put
+// it on estate roads whose motor_vehicle tags are often stale. access=
+// destination and access=customers stay PUBLIC: Google routes through them
+// freely in this region, and restricting them measurably hurt route agreement
+// (round-3 sweep, 2026-07). The same values on a class-specific key restrict.
+func vehicleAccess(tags osm.Tags, class string) (keep, restricted bool) {
 	hw := tags.Find("highway")
 	if !carHighways[hw] || tags.Find("area") == "yes" {
 		return false, false
 	}
 	switch tags.Find("access") {
-	case "no":
-		return false, false
 	case "private", "permit", "residents":
 		return true, true
 	}
-	switch tags.Find("motor_vehicle") {
-	case "no":
+	v, key := classAccess(tags, class)
+	switch v {
+	case "no", "agricultural", "forestry", "use_sidepath":
 		return false, false
-	case "private", "destination", "customers":
+	case "private", "permit", "residents", "delivery":
 		return true, true
+	case "destination", "customers":
+		return true, key != "access"
 	}
 	return true, false
 }
@@ -163,6 +275,17 @@ func isCarAccessible(tags osm.Tags) bool {
 	return keep
 }
 
+// isRoundabout reports whether a way is part of a roundabout (junction=
+// roundabout, or junction=circular for large gyratories without priority
+// rules), so instructions can count exits along it.
+func isRoundabout(tags osm.Tags) bool {
+	switch tags.Find("junction") {
+	case "roundabout", "circular":
+		return true
+	}
+	return false
+}
+
 // directionFlags returns (forward, backward) based on highway type and oneway tags.
 func directionFlags(tags osm.Tags) (forward, backward bool) {
 	// Default: bidirectional.
@@ -189,7 +312,8 @@ func directionFlags(tags osm.Tags) (forward, backward bool) {
 		forward = true
 		backward = true
 	case "reversible":
-		// Time-dependent — skip entirely.
+		// Time-dependent: closed unless oneway:conditional resolves it
+		// (ParseOptions.AccessTime) or ParseOptions.KeepReversible applies.
 		forward = false
 		backward = false
 	}
@@ -199,11 +323,66 @@ func directionFlags(tags osm.Tags) (forward, backward bool) {
 
 // wayInfo holds parsed way data collected during Pass 1.
 type wayInfo struct {
+	ID         osm.WayID
 	NodeIDs    []osm.NodeID
 	Forward    bool
 	Backward   bool
-	SpeedKmh   float64
+	SpeedKmh   float64 // forward (way direction)
+	BwdKmh     float64 // against the way direction; differs only with maxspeed:backward/forward
+	Penalty    float64 // travel-time multiplier from the profile, >= 1
+	AccessMult float64 // weight multiplier for private/destination access (ParseOptions.PrivatePenalty), >= 1
 	Restricted bool
+	Name       uint32 // index into the name table
+	Limits     VehicleLimits
+	Roundabout bool // junction=roundabout or circular
+	Class      RoadClass
+	Flags      WayFlags
+	FwdWindow  string // schedule of the forward direction; "" = always open
+	BwdWindow  string // schedule of the backward direction; "" = always open
+}
+
+// source is one parser input and its resolved encoding.
+type source struct {
+	rs     io.ReadSeeker
+	format Format
+	size   int64 // bytes; for progress reporting
+}
+
+type sources []source
+
+// wrap names the failing input when there is more than one.
+func (ss sources) wrap(i int, err error) error {
+	if len(ss) > 1 {
+		return fmt.Errorf("input %d: %w", i+1, err)
+	}
+	return err
+}
+
+// scanSources runs one scan pass over every input in order, calling fn for
+// each object the filter admits and reporting progress as phase.
+func scanSources(ctx context.Context, ss sources, want scanFilter, progress *progressTracker, phase Phase, fn func(osm.Object)) error {
+	progress.startPass(phase)
+	for i, src := range ss {
+		scanner, err := newScanner(ctx, progress.wrap(i, src), src.format, want)
+		if err != nil {
+			return ss.wrap(i, err)
+		}
+		for scanner.Scan() {
+			obj := scanner.Object()
+			if !want.admits(obj) {
+				continue
+			}
+			progress.object(obj)
+			fn(obj)
+		}
+		err = scanner.Err()
+		scanner.Close()
+		if err != nil {
+			return ss.wrap(i, err)
+		}
+	}
+	progress.report()
+	return nil
 }
 
 // BBox defines a geographic bounding box for filtering.
@@ -226,188 +405,572 @@ func (b BBox) Contains(lat, lng float64) bool {
 // ParseOptions configures the OSM parser.
 type ParseOptions struct {
 	BBox     BBox       // if non-zero, filter edges to this bounding box
+	Boundary *Boundary  // if non-nil, filter edges to this polygon (after BBox)
 	Speeds   SpeedTable // free-flow speed model; zero value → DefaultSpeedTable()
 	Distance bool       // if true, weight edges by physical road length (cm) for
 	// shortest-distance routing; Speeds is ignored.
+	Format Format // input encoding; FormatAuto sniffs the leading bytes
+
+	// Progress, if non-nil, is called from the parsing goroutine at the start
+	// and end of each phase and every few tens of thousands of objects in
+	// between. It must return quickly.
+	Progress func(Progress)
+
+	// Profile is the travel mode the network is built for; nil selects
+	// CarProfile with Speeds.
+	Profile Profile
+
+	// AccessTime, if non-zero, evaluates time-based access:conditional and
+	// motor_vehicle:conditional tags at this instant (weekday and clock time
+	// are taken in AccessTime's location). Zero ignores conditional tags.
+	AccessTime time.Time
+
+	// KeepReversible, when AccessTime is zero, keeps oneway=reversible ways
+	// whose oneway:conditional gives their schedule instead of dropping
+	// them: each direction that operates at some time is emitted, with the
+	// schedule in RawEdge.Window. With AccessTime set, reversible ways take
+	// the direction in force at that instant (or are dropped while closed)
+	// whatever this says.
+	KeepReversible bool
+
+	// PrivatePenalty, if > 1, keeps restricted ways (access=private, permit,
+	// residents, motor_vehicle=destination, … as the profile decides) and
+	// access=destination ways as ordinary edges with their weight multiplied
+	// by this factor, instead of flagging them Restricted for the graph's
+	// cluster filter. Addresses on private service roads stay reachable —
+	// the final approach simply costs more — while through-routes avoid
+	// them. Applies to both time and distance weights. Barrier nodes still
+	// restrict their edges.
+	PrivatePenalty float64
+
+	// KeepParallel keeps every edge between the same two nodes in the same
+	// direction. By default only the cheapest survives (public preferred
+	// over restricted); see dedupeParallel.
+	KeepParallel bool
+
+	// Elevation, if non-nil, assigns every node an elevation. Edges then
+	// record their climb and descent, and profiles implementing GradeProfile
+	// slow down uphill in time-weighted builds.
+	Elevation ElevationProvider
 }
 
-// Parse reads an OSM PBF file and returns directed edges for car routing.
-// The reader is consumed twice (seeks back to start for the second pass),
-// so it must implement io.ReadSeeker.
+// Parse reads an OSM file (PBF, XML or bzip2-compressed XML) and returns
+// directed edges for car routing. The reader is consumed twice (seeks back to
+// start for the second pass), so it must implement io.ReadSeeker.
 func Parse(ctx context.Context, rs io.ReadSeeker, opts ...ParseOptions) (*ParseResult, error) {
-	var opt ParseOptions
-	if len(opts) > 0 {
-		opt = opts[0]
-	}
-	useBBox := !opt.BBox.IsZero()
-	if opt.Speeds.ClassKmh == nil {
-		opt.Speeds = DefaultSpeedTable()
+	return ParseAll(ctx, []io.ReadSeeker{rs}, opts...)
+}
+
+// ParseAll parses several OSM files as one dataset, e.g. neighbouring country
+// extracts that together cover a cross-border region. Objects present in more
+// than one input (the ways and nodes along the seam) are deduplicated by OSM
+// ID: the first input containing a way or relation wins, and a node's
+// coordinates are identical across extracts of the same planet snapshot.
+// ParseOptions.Format applies to every input; FormatAuto sniffs each one.
+func ParseAll(ctx context.Context, inputs []io.ReadSeeker, opts ...ParseOptions) (*ParseResult, error) {
+	opt := resolveOptions(opts)
+	srcs, err := resolveSources(inputs, opt.Format)
+	if err != nil {
+		return nil, err
 	}
-	// Pass 1: Scan ways to collect referenced node IDs and way info.
-	referencedNodes := make(map[osm.NodeID]struct{})
-	var ways []wayInfo
+	progress := newProgressTracker(opt.Progress, srcs)
 
-	scanner := osmpbf.New(ctx, rs, 1)
-	scanner.SkipNodes = true
-	scanner.SkipRelations = true
+	// Pass 1: Scan ways to collect referenced node IDs and way info, and
+	// turn restriction relations (resolved against the ways afterwards).
+	var refs []osm.NodeID // node IDs of kept ways, with repeats
+	var ways []wayInfo
+	var rawRestrictions []rawRestriction
+	names := newNameTable()
+
+	// Merged extracts overlap along their seams. A single file lists each
+	// object once, so only merges pay for the ID sets (kept objects only:
+	// a way dropped from one extract is dropped from all of them).
+	var seenWays map[osm.WayID]struct{}
+	var seenRelations map[osm.RelationID]struct{}
+	if len(srcs) > 1 {
+		seenWays = make(map[osm.WayID]struct{})
+		seenRelations = make(map[osm.RelationID]struct{})
+	}
 
-	for scanner.Scan() {
-		obj := scanner.Object()
+	err = scanSources(ctx, srcs, scanFilter{ways: true, relations: true}, progress, PhaseWays, func(obj osm.Object) {
+		if r, ok := obj.(*osm.Relation); ok {
+			if _, dup := seenRelations[r.ID]; dup {
+				return
+			}
+			if rr, ok := parseRestriction(r, opt.Profile.TurnRestrictionModes()); ok {
+				rawRestrictions = append(rawRestrictions, rr)
+				if seenRelations != nil {
+					seenRelations[r.ID] = struct{}{}
+				}
+			}
+			return
+		}
 		w, ok := obj.(*osm.Way)
 		if !ok {
-			continue
+			return
 		}
-
-		keep, restricted := classifyAccess(w.Tags)
-		if !keep {
-			continue
+		if _, dup := seenWays[w.ID]; dup {
+			return
 		}
 
-		if len(w.Nodes) < 2 {
-			continue
+		wi, ok := opt.newWayInfo(w.ID, w.Nodes.NodeIDs(), w.Tags, names)
+		if !ok {
+			return
 		}
-
-		fwd, bwd := directionFlags(w.Tags)
-		if !fwd && !bwd {
-			continue
+		refs = append(refs, wi.NodeIDs...)
+		if seenWays != nil {
+			seenWays[w.ID] = struct{}{}
 		}
+		ways = append(ways, wi)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pass 1 (ways): %w", err)
+	}
 
-		nodeIDs := make([]osm.NodeID, len(w.Nodes))
-		for i, wn := range w.Nodes {
-			nodeIDs[i] = wn.ID
-			referencedNodes[wn.ID] = struct{}{}
+	nodes := newNodeSet(refs, opt.Profile)
+	log.Printf("Pass 1 complete: %d ways, %d referenced nodes, %d turn restrictions, %d road names", len(ways), nodes.coords.Len(), len(rawRestrictions), len(names.names)-1)
+
+	restrictions := resolveWayRestrictions(rawRestrictions, ways)
+
+	// Pass 2: Scan nodes to collect coordinates for referenced nodes only.
+	err = scanSources(ctx, srcs, scanFilter{nodes: true}, progress, PhaseNodes, func(obj osm.Object) {
+		if n, ok := obj.(*osm.Node); ok {
+			nodes.add(n.ID, n.Lat, n.Lon, n.Tags)
 		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pass 2 (nodes): %w", err)
+	}
 
-		ways = append(ways, wayInfo{
-			NodeIDs:    nodeIDs,
-			Forward:    fwd,
-			Backward:   bwd,
-			SpeedKmh:   opt.Speeds.SpeedKmh(w.Tags),
-			Restricted: restricted,
-		})
+	nodes.finish()
+	log.Printf("Pass 2 complete: %d node coordinates collected, %d restrictive barrier nodes, %d traffic control nodes", nodes.coords.Len(), len(nodes.barriers), len(nodes.controls))
+
+	progress.startPass(PhaseEdges)
+	return buildResult(ways, restrictions, nodes, names, opt), nil
+}
+
+// resolveOptions returns the effective options of a variadic ParseOptions
+// argument, with defaults filled in.
+func resolveOptions(opts []ParseOptions) ParseOptions {
+	var opt ParseOptions
+	if len(opts) > 0 {
+		opt = opts[0]
 	}
-	if err := scanner.Err(); err != nil {
-		scanner.Close()
-		return nil, fmt.Errorf("pass 1 (ways): %w", err)
+	if opt.Speeds.ClassKmh == nil {
+		opt.Speeds = DefaultSpeedTable()
+	}
+	if opt.Profile == nil {
+		opt.Profile = CarProfile{Speeds: opt.Speeds}
 	}
-	scanner.Close()
+	return opt
+}
 
-	log.Printf("Pass 1 complete: %d ways, %d referenced nodes", len(ways), len(referencedNodes))
+// resolveSources pairs each input with its encoding, sniffing the content
+// when format is FormatAuto.
+func resolveSources(inputs []io.ReadSeeker, format Format) (sources, error) {
+	srcs := make(sources, len(inputs))
+	for i, rs := range inputs {
+		srcs[i] = source{rs: rs, format: format}
+		if format == FormatAuto {
+			f, err := detectFormat(rs)
+			if err != nil {
+				return nil, srcs.wrap(i, err)
+			}
+			srcs[i].format = f
+		}
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, srcs.wrap(i, fmt.Errorf("seek: %w", err))
+		}
+		srcs[i].size = size
+	}
+	return srcs, nil
+}
 
-	// Pass 2: Scan nodes to collect coordinates for referenced nodes only.
-	if _, err := rs.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("seek for pass 2: %w", err)
+// newWayInfo classifies a way for the profile, reporting false when it is
+// dropped (not accessible, degenerate, or closed in both directions).
+func (opt *ParseOptions) newWayInfo(id osm.WayID, nodeIDs []osm.NodeID, tags osm.Tags, names *nameTable) (wayInfo, bool) {
+	condTags := tags
+	if !opt.AccessTime.IsZero() {
+		condTags = applyConditional(tags, opt.AccessTime)
+	}
+	keep, restricted := opt.Profile.Access(condTags)
+	if !keep || len(nodeIDs) < 2 {
+		return wayInfo{}, false
+	}
+	fwd, bwd := opt.Profile.Direction(condTags)
+	var fwdWindow, bwdWindow string
+	if !fwd && !bwd && opt.KeepReversible && opt.AccessTime.IsZero() && tags.Find("oneway") == "reversible" {
+		fwdWindow, bwdWindow = reversibleWindows(tags)
+		fwd, bwd = fwdWindow != "", bwdWindow != ""
+	}
+	if !fwd && !bwd {
+		return wayInfo{}, false
 	}
+	accessMult := 1.0
+	if opt.PrivatePenalty > 1 && (restricted || condTags.Find("access") == "destination") {
+		restricted = false
+		accessMult = opt.PrivatePenalty
+	}
+	fwdKmh, bwdKmh := opt.Profile.Speed(tags)
+	return wayInfo{
+		ID:         id,
+		NodeIDs:    nodeIDs,
+		Forward:    fwd,
+		Backward:   bwd,
+		SpeedKmh:   fwdKmh,
+		BwdKmh:     bwdKmh,
+		Penalty:    max(opt.Profile.Penalty(tags), 1),
+		AccessMult: accessMult,
+		Restricted: restricted,
+		Name:       names.intern(wayRoadName(tags)),
+		Limits:     wayLimits(tags),
+		Roundabout: isRoundabout(tags),
+		Class:      ParseRoadClass(tags.Find("highway")),
+		Flags:      wayFlags(tags, fwd, bwd),
+		FwdWindow:  fwdWindow,
+		BwdWindow:  bwdWindow,
+	}, true
+}
 
-	nodeLat := make(map[osm.NodeID]float64, len(referencedNodes))
-	nodeLon := make(map[osm.NodeID]float64, len(referencedNodes))
-	barrierNodes := make(map[osm.NodeID]struct{})
+// resolveWayRestrictions resolves raw restriction relations against the kept
+// ways. Only the ways restrictions mention are indexed.
+func resolveWayRestrictions(raw []rawRestriction, ways []wayInfo) []TurnRestriction {
+	wayNodes := make(map[osm.WayID][]osm.NodeID)
+	for _, rr := range raw {
+		for _, ids := range [][]osm.WayID{rr.from, rr.to, rr.viaWays} {
+			for _, id := range ids {
+				wayNodes[id] = nil
+			}
+		}
+	}
+	for _, w := range ways {
+		if _, ok := wayNodes[w.ID]; ok {
+			wayNodes[w.ID] = w.NodeIDs
+		}
+	}
+	restrictions, unresolved := resolveRestrictions(raw, wayNodes)
+	if unresolved > 0 {
+		log.Printf("Skipped %d turn restrictions over dropped or disconnected ways", unresolved)
+	}
+	return restrictions
+}
 
-	scanner = osmpbf.New(ctx, rs, 1)
-	scanner.SkipWays = true
-	scanner.SkipRelations = true
+// nodeSet collects what the edge builder needs to know about routing nodes:
+// coordinates, junctions, restrictive barriers and traffic control.
+type nodeSet struct {
+	build     *coordBuilder // nil once finish has run
+	profile   Profile       // decides which barriers restrict
+	coords    *NodeCoords
+	junctions []osm.NodeID // sorted; nodes referenced more than once by the kept ways
+	barriers  map[osm.NodeID]struct{}
+	controls  map[osm.NodeID]NodeControl
+}
 
-	for scanner.Scan() {
-		obj := scanner.Object()
-		n, ok := obj.(*osm.Node)
-		if !ok {
-			continue
+// newNodeSet prepares to collect the nodes in ids (unsorted, with repeats;
+// the slice is reused). A node listed more than once is shared by several
+// ways, or closes or crosses one, so it is a junction.
+func newNodeSet(ids []osm.NodeID, profile Profile) *nodeSet {
+	slices.Sort(ids)
+	var junctions []osm.NodeID
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] && (len(junctions) == 0 || junctions[len(junctions)-1] != ids[i]) {
+			junctions = append(junctions, ids[i])
 		}
+	}
+	b := newCoordBuilder(ids)
+	return &nodeSet{
+		build:     b,
+		profile:   profile,
+		coords:    b.c,
+		junctions: junctions,
+		barriers:  make(map[osm.NodeID]struct{}),
+		controls:  make(map[osm.NodeID]NodeControl),
+	}
+}
+
+// splits reports whether an edge must end at node id: junctions, and nodes
+// whose barrier or traffic control has to stay addressable in the graph.
+func (ns *nodeSet) splits(id osm.NodeID) bool {
+	if _, ok := slices.BinarySearch(ns.junctions, id); ok {
+		return true
+	}
+	if _, ok := ns.barriers[id]; ok {
+		return true
+	}
+	_, ok := ns.controls[id]
+	return ok
+}
+
+// add records a node, ignoring nodes that were not asked for.
+func (ns *nodeSet) add(id osm.NodeID, lat, lon float64, tags osm.Tags) {
+	if !ns.build.set(id, lat, lon) {
+		return
+	}
+	if ns.profile.BarrierRestricts(tags) {
+		ns.barriers[id] = struct{}{}
+	}
+	if c := nodeControl(tags); c != 0 {
+		ns.controls[id] = c
+	}
+}
 
-		if _, needed := referencedNodes[n.ID]; !needed {
-			continue
+// finish drops the requested nodes that never showed up.
+func (ns *nodeSet) finish() {
+	ns.coords = ns.build.finish()
+	ns.build = nil
+}
+
+// buildResult turns the kept ways into directed edges, applying the bbox and
+// boundary filters and the edge weight model.
+//
+// A way becomes one edge per run between split nodes (see nodeSet.splits,
+// plus the nodes of turn restriction paths), with the nodes in between kept
+// as shape points, so the graph has a node per junction rather than per OSM
+// node. Segments with a missing or filtered-out endpoint cut the run, leaving
+// the node before them as a dead end.
+func buildResult(ways []wayInfo, restrictions []TurnRestriction, nodes *nodeSet, names *nameTable, opt ParseOptions) *ParseResult {
+	useBBox := !opt.BBox.IsZero()
+
+	var gradeFactor func(float64) float64
+	if opt.Elevation != nil {
+		n := nodes.coords.setElevations(opt.Elevation)
+		log.Printf("Assigned elevations to %d of %d nodes", n, nodes.coords.Len())
+		if gp, ok := opt.Profile.(GradeProfile); ok && !opt.Distance {
+			gradeFactor = gp.GradeFactor
 		}
+	}
 
-		nodeLat[n.ID] = n.Lat
-		nodeLon[n.ID] = n.Lon
-		if nodeBarrierRestricts(n.Tags) {
-			barrierNodes[n.ID] = struct{}{}
+	// Restriction paths name edges by consecutive node pairs, so every path
+	// node must stay an edge endpoint.
+	pathNodes := make(map[osm.NodeID]struct{})
+	for _, r := range restrictions {
+		for _, id := range r.Path {
+			pathNodes[id] = struct{}{}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		scanner.Close()
-		return nil, fmt.Errorf("pass 2 (nodes): %w", err)
+	splits := func(id osm.NodeID) bool {
+		if _, ok := pathNodes[id]; ok {
+			return true
+		}
+		return nodes.splits(id)
 	}
-	scanner.Close()
-
-	log.Printf("Pass 2 complete: %d node coordinates collected, %d restrictive barrier nodes", len(nodeLat), len(barrierNodes))
 
 	// Build edges from ways.
 	var edges []RawEdge
 	var skippedEdges int
 	var bboxFiltered int
+	var boundaryFiltered int
+	var shapePoints int
 
+	var lats, lons, eles []float64
+	var known, hasEle []bool
 	for _, w := range ways {
-		for i := 0; i < len(w.NodeIDs)-1; i++ {
-			fromID := w.NodeIDs[i]
-			toID := w.NodeIDs[i+1]
-
-			fromLat, fromOk := nodeLat[fromID]
-			fromLon := nodeLon[fromID]
-			toLat, toOk := nodeLat[toID]
-			toLon := nodeLon[toID]
+		ids := w.NodeIDs
+		lats, lons, known = lats[:0], lons[:0], known[:0]
+		eles, hasEle = eles[:0], hasEle[:0]
+		for _, id := range ids {
+			lat, lon, ok := nodes.coords.Get(id)
+			lats, lons, known = append(lats, lat), append(lons, lon), append(known, ok)
+			ele, ok := nodes.coords.Elevation(id)
+			eles, hasEle = append(eles, ele), append(hasEle, ok)
+		}
 
-			if !fromOk || !toOk {
-				skippedEdges++
-				continue
+		// emit appends the edges of the run ids[a..b].
+		var emit func(a, b int)
+		emit = func(a, b int) {
+			if ids[a] == ids[b] && b-a >= 2 {
+				// A closed run would be a self-loop, which no route can use
+				// to get anywhere; break it at its middle node.
+				m := (a + b) / 2
+				emit(a, m)
+				emit(m, b)
+				return
 			}
-
-			// Bounding box filter: skip edges with any endpoint outside.
-			if useBBox && (!opt.BBox.Contains(fromLat, fromLon) || !opt.BBox.Contains(toLat, toLon)) {
-				bboxFiltered++
-				continue
+			// fwdLen and bwdLen are the flat-ground equivalent lengths:
+			// climbing a segment at half speed counts it twice.
+			var dist, fwdLen, bwdLen, ascent, descent float64
+			for i := a; i < b; i++ {
+				d := geo.Haversine(lats[i], lons[i], lats[i+1], lons[i+1])
+				dist += d
+				if !hasEle[i] || !hasEle[i+1] {
+					fwdLen += d
+					bwdLen += d
+					continue
+				}
+				rise := eles[i+1] - eles[i]
+				ascent += max(rise, 0)
+				descent += max(-rise, 0)
+				if gradeFactor == nil || d == 0 {
+					fwdLen += d
+					bwdLen += d
+					continue
+				}
+				grade := min(max(rise/d, -maxGrade), maxGrade)
+				fwdLen += d / gradeFactor(grade)
+				bwdLen += d / gradeFactor(-grade)
 			}
-
-			dist := geo.Haversine(fromLat, fromLon, toLat, toLon)
-			var weight uint32
+			var fwdWeight, bwdWeight uint32
 			if opt.Distance {
-				weight = computeWeightDistanceCm(dist)
+				fwdWeight = computeWeightDistanceCm(dist * w.AccessMult)
+				bwdWeight = fwdWeight
 			} else {
-				weight = computeWeightMs(dist, w.SpeedKmh)
+				fwdWeight = computeWeightMs(fwdLen*w.Penalty*w.AccessMult, w.SpeedKmh)
+				bwdWeight = computeWeightMs(bwdLen*w.Penalty*w.AccessMult, w.BwdKmh)
 			}
 
 			// A restrictive barrier node (gate/bollard/…) makes its adjacent
 			// edges restricted, so the cluster filter treats crossing it as
-			// last-mile access rather than a public through-path.
+			// last-mile access rather than a public through-path. Barriers
+			// are split nodes, so they only ever sit at a run's ends.
+			fromID, toID := ids[a], ids[b]
 			restricted := w.Restricted
 			if !restricted {
-				if _, isBar := barrierNodes[fromID]; isBar {
+				if _, isBar := nodes.barriers[fromID]; isBar {
 					restricted = true
-				} else if _, isBar := barrierNodes[toID]; isBar {
+				} else if _, isBar := nodes.barriers[toID]; isBar {
 					restricted = true
 				}
 			}
 
+			var shapeLats, shapeLons []float64
+			if b-a > 1 {
+				shapeLats = slices.Clone(lats[a+1 : b])
+				shapeLons = slices.Clone(lons[a+1 : b])
+			}
+
 			if w.Forward {
 				edges = append(edges, RawEdge{
 					FromNodeID: fromID,
 					ToNodeID:   toID,
-					Weight:     weight,
+					Weight:     fwdWeight,
+					SpeedKmh:   w.SpeedKmh,
+					ShapeLats:  shapeLats,
+					ShapeLons:  shapeLons,
 					Restricted: restricted,
+					Name:       w.Name,
+					Ascent:     ascent,
+					Descent:    descent,
+					Limits:     w.Limits,
+					WayID:      w.ID,
+					Roundabout: w.Roundabout,
+					Class:      w.Class,
+					Flags:      w.Flags,
+					Window:     w.FwdWindow,
 				})
+				shapePoints += len(shapeLats)
 			}
 			if w.Backward {
+				var revLats, revLons []float64
+				if shapeLats != nil {
+					revLats, revLons = shapeLats, shapeLons
+					if w.Forward {
+						revLats, revLons = slices.Clone(shapeLats), slices.Clone(shapeLons)
+					}
+					slices.Reverse(revLats)
+					slices.Reverse(revLons)
+				}
 				edges = append(edges, RawEdge{
 					FromNodeID: toID,
 					ToNodeID:   fromID,
-					Weight:     weight,
+					Weight:     bwdWeight,
+					SpeedKmh:   w.BwdKmh,
+					ShapeLats:  revLats,
+					ShapeLons:  revLons,
 					Restricted: restricted,
+					Name:       w.Name,
+					Ascent:     descent,
+					Descent:    ascent,
+					Limits:     w.Limits,
+					WayID:      w.ID,
+					Roundabout: w.Roundabout,
+					Class:      w.Class,
+					Flags:      w.Flags,
+					Window:     w.BwdWindow,
 				})
+				shapePoints += len(revLats)
+			}
+		}
+
+		start := -1 // first node of the open run, or -1
+		for i := 0; i < len(ids)-1; i++ {
+			valid := true
+			switch {
+			case !known[i] || !known[i+1]:
+				skippedEdges++
+				valid = false
+			// Bounding box filter: skip segments with any endpoint outside.
+			case useBBox && (!opt.BBox.Contains(lats[i], lons[i]) || !opt.BBox.Contains(lats[i+1], lons[i+1])):
+				bboxFiltered++
+				valid = false
+			case opt.Boundary != nil && (!opt.Boundary.Contains(lats[i], lons[i]) || !opt.Boundary.Contains(lats[i+1], lons[i+1])):
+				boundaryFiltered++
+				valid = false
+			}
+			if !valid {
+				if start >= 0 {
+					emit(start, i)
+					start = -1
+				}
+				continue
+			}
+			if start < 0 {
+				start = i
+			}
+			if i+1 == len(ids)-1 || splits(ids[i+1]) {
+				emit(start, i+1)
+				start = -1
 			}
 		}
 	}
 
 	if skippedEdges > 0 {
-		log.Printf("Warning: skipped %d edges due to missing node coordinates", skippedEdges)
+		log.Printf("Warning: skipped %d segments due to missing node coordinates", skippedEdges)
 	}
 	if bboxFiltered > 0 {
-		log.Printf("Filtered %d edges outside bounding box", bboxFiltered)
+		log.Printf("Filtered %d segments outside bounding box", bboxFiltered)
+	}
+	if boundaryFiltered > 0 {
+		log.Printf("Filtered %d segments outside boundary polygon", boundaryFiltered)
+	}
+	// Runs only end at junctions, but a junction where one way hands over to
+	// the next (or two ways merely touch end to end) still splits the road.
+	keepNode := func(id osm.NodeID) bool {
+		if _, ok := pathNodes[id]; ok {
+			return true
+		}
+		_, isBar := nodes.barriers[id]
+		_, isControl := nodes.controls[id]
+		return isBar || isControl
+	}
+	edges, collapsed := simplifyChains(edges, keepNode, nodes.coords, opt.Distance)
+	var dropped int
+	if !opt.KeepParallel {
+		edges, dropped = dedupeParallel(edges)
+		if dropped > 0 {
+			log.Printf("Dropped %d parallel edges duplicating a cheaper one", dropped)
+			// A junction left with one road in and out is now a chain node.
+			var more int
+			edges, more = simplifyChains(edges, keepNode, nodes.coords, opt.Distance)
+			collapsed += more
+		}
+	}
+	if collapsed > 0 {
+		log.Printf("Collapsed %d degree-2 nodes into their neighbouring edges", collapsed)
 	}
-	log.Printf("Built %d directed edges", len(edges))
+	if collapsed > 0 || dropped > 0 {
+		shapePoints = 0
+		for _, e := range edges {
+			shapePoints += len(e.ShapeLats)
+		}
+	}
+	log.Printf("Built %d directed edges with %d shape points", len(edges), shapePoints)
 
 	return &ParseResult{
-		Edges:   edges,
-		NodeLat: nodeLat,
-		NodeLon: nodeLon,
-	}, nil
+		Edges:        edges,
+		Coords:       nodes.coords,
+		Restrictions: restrictions,
+		Names:        names.names,
+		NodeControls: nodes.controls,
+	}
 }
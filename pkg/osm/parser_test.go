@@ -1,9 +1,16 @@
 package osm
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"math"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/azybler/map_router/pkg/geo"
 	"github.com/paulmach/osm"
 )
 
@@ -232,6 +239,13 @@ func TestClassifyAccess(t *testing.T) {
 		{"access=no dropped", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "access", Value: "no"}}, false, false},
 		{"plain motor_vehicle=no dropped", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "motor_vehicle", Value: "no"}}, false, false},
 		{"motor_vehicle=private restricted", osm.Tags{{Key: "highway", Value: "service"}, {Key: "motor_vehicle", Value: "private"}}, true, true},
+		{"motorcar=no dropped", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "motorcar", Value: "no"}}, false, false},
+		{"vehicle=no dropped", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "vehicle", Value: "no"}}, false, false},
+		{"access=no + motorcar=yes opens", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "access", Value: "no"}, {Key: "motorcar", Value: "yes"}}, true, false},
+		{"motor_vehicle=no + motorcar=yes opens", osm.Tags{{Key: "highway", Value: "residential"}, {Key: "motor_vehicle", Value: "no"}, {Key: "motorcar", Value: "yes"}}, true, false},
+		{"access=no + psv=yes bus road dropped", osm.Tags{{Key: "highway", Value: "tertiary"}, {Key: "access", Value: "no"}, {Key: "psv", Value: "yes"}}, false, false},
+		{"hgv=no ignored by cars", osm.Tags{{Key: "highway", Value: "tertiary"}, {Key: "hgv", Value: "no"}}, true, false},
+		{"motorcar=destination restricted", osm.Tags{{Key: "highway", Value: "service"}, {Key: "motorcar", Value: "destination"}}, true, true},
 		{"footway dropped", osm.Tags{{Key: "highway", Value: "footway"}}, false, false},
 		{"area=yes dropped", osm.Tags{{Key: "highway", Value: "service"}, {Key: "area", Value: "yes"}}, false, false},
 		{"no highway dropped", osm.Tags{{Key: "name", Value: "X"}}, false, false},
@@ -243,3 +257,349 @@ func TestClassifyAccess(t *testing.T) {
 		}
 	}
 }
+
+func TestClassAccess(t *testing.T) {
+	way := tags("highway", "primary", "access", "no", "motor_vehicle", "yes", "goods", "no", "bus", "designated")
+	cases := []struct{ class, wantValue, wantKey string }{
+		{"motorcar", "yes", "motor_vehicle"},
+		{"hgv", "yes", "motor_vehicle"},
+		{"goods", "no", "goods"},
+		{"bus", "designated", "bus"},
+		{"taxi", "yes", "motor_vehicle"},
+		{"bicycle", "no", "access"},
+	}
+	for _, c := range cases {
+		v, k := classAccess(way, c.class)
+		if v != c.wantValue || k != c.wantKey {
+			t.Errorf("classAccess(%s) = %q from %q, want %q from %q", c.class, v, k, c.wantValue, c.wantKey)
+		}
+	}
+}
+
+func TestNodeControl(t *testing.T) {
+	cases := []struct {
+		name string
+		tags osm.Tags
+		want NodeControl
+	}{
+		{"signals", osm.Tags{{Key: "highway", Value: "traffic_signals"}}, ControlTrafficSignals},
+		{"zebra crossing", osm.Tags{{Key: "highway", Value: "crossing"}, {Key: "crossing", Value: "zebra"}}, ControlCrossing},
+		{"signalled crossing", osm.Tags{{Key: "highway", Value: "crossing"}, {Key: "crossing", Value: "traffic_signals"}}, ControlCrossing | ControlTrafficSignals},
+		{"stop sign", osm.Tags{{Key: "highway", Value: "stop"}}, 0},
+		{"untagged", nil, 0},
+	}
+	for _, c := range cases {
+		if got := nodeControl(c.tags); got != c.want {
+			t.Errorf("%s: nodeControl = %b, want %b", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseNodeControls(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	result, err := Parse(context.Background(), f)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Node 5 is a crossing, but only on the dropped footway.
+	want := map[osm.NodeID]NodeControl{
+		2: ControlTrafficSignals,
+		3: ControlCrossing | ControlTrafficSignals,
+	}
+	if len(result.NodeControls) != len(want) {
+		t.Errorf("NodeControls = %v, want %v", result.NodeControls, want)
+	}
+	for id, c := range want {
+		if got := result.NodeControls[id]; got != c {
+			t.Errorf("NodeControls[%d] = %b, want %b", id, got, c)
+		}
+	}
+	if !result.NodeControls[3].Has(ControlCrossing) {
+		t.Error("node 3 should be a crossing")
+	}
+}
+
+func TestParseAllMergesOverlappingExtracts(t *testing.T) {
+	west, err := os.ReadFile("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A neighbouring extract sharing way 11 (and its nodes) on the seam.
+	east := `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="3" lat="1.3020" lon="103.8000"/>
+  <node id="4" lat="1.3020" lon="103.8010"/>
+  <node id="6" lat="1.3020" lon="103.8020"/>
+  <way id="11">
+    <nd ref="3"/>
+    <nd ref="4"/>
+    <tag k="highway" v="primary"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+  <way id="13">
+    <nd ref="4"/>
+    <nd ref="6"/>
+    <tag k="highway" v="residential"/>
+  </way>
+</osm>`
+
+	result, err := ParseAll(context.Background(), []io.ReadSeeker{
+		bytes.NewReader(west), strings.NewReader(east),
+	})
+	if err != nil {
+		t.Fatalf("ParseAll: %v", err)
+	}
+	// tiny.osm's 5 edges, plus way 13 in both directions; way 11 only once.
+	if len(result.Edges) != 7 {
+		t.Errorf("got %d edges, want 7", len(result.Edges))
+	}
+	if result.NumNodes() != 5 {
+		t.Errorf("got %d node coordinates, want 5", result.NumNodes())
+	}
+}
+
+func TestParseAllNamesFailingInput(t *testing.T) {
+	_, err := ParseAll(context.Background(), []io.ReadSeeker{
+		strings.NewReader(`<osm></osm>`), strings.NewReader(`<osm><way id="1"`),
+	}, ParseOptions{Format: FormatXML})
+	if err == nil || !strings.Contains(err.Error(), "input 2") {
+		t.Errorf("ParseAll error = %v, want it to name input 2", err)
+	}
+}
+
+func TestParseReportsProgress(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Progress
+	_, err = Parse(context.Background(), f, ParseOptions{Progress: func(p Progress) { got = append(got, p) }})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var phases []Phase
+	for _, p := range got {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+		if p.TotalBytes != info.Size() {
+			t.Errorf("TotalBytes = %d, want %d", p.TotalBytes, info.Size())
+		}
+	}
+	if want := []Phase{PhaseWays, PhaseNodes, PhaseEdges}; !slices.Equal(phases, want) {
+		t.Errorf("phases = %v, want %v", phases, want)
+	}
+
+	// The last report of the node pass has read the whole file.
+	var endOfNodes Progress
+	for _, p := range got {
+		if p.Phase == PhaseNodes {
+			endOfNodes = p
+		}
+	}
+	if endOfNodes.Fraction() != 1 {
+		t.Errorf("node pass ended at %d/%d bytes", endOfNodes.BytesRead, endOfNodes.TotalBytes)
+	}
+	last := got[len(got)-1]
+	if last.Ways != 3 || last.Nodes != 5 {
+		t.Errorf("final counts: %d ways, %d nodes; want 3, 5", last.Ways, last.Nodes)
+	}
+}
+
+func TestParseSplitsWaysAtJunctions(t *testing.T) {
+	// Way 10 bends through untagged nodes 2 and 3; way 11 joins it at 3.
+	// Way 12 is a closed loop hanging off node 4, named so that node 4 is
+	// not collapsed into a chain with way 10.
+	data := `<osm version="0.6">
+  <node id="1" lat="1.3000" lon="103.8000"/>
+  <node id="2" lat="1.3010" lon="103.8005"/>
+  <node id="3" lat="1.3020" lon="103.8000"/>
+  <node id="4" lat="1.3030" lon="103.8000"/>
+  <node id="5" lat="1.3020" lon="103.8010"/>
+  <node id="6" lat="1.3040" lon="103.8000"/>
+  <node id="7" lat="1.3040" lon="103.8010"/>
+  <way id="10">
+    <nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="4"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <way id="11">
+    <nd ref="3"/><nd ref="5"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <way id="12">
+    <nd ref="4"/><nd ref="6"/><nd ref="7"/><nd ref="4"/>
+    <tag k="highway" v="residential"/>
+    <tag k="name" v="Lingkaran"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+</osm>`
+	result, err := Parse(context.Background(), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	type pair struct{ from, to osm.NodeID }
+	got := make(map[pair]RawEdge)
+	for _, e := range result.Edges {
+		if e.FromNodeID == e.ToNodeID {
+			t.Errorf("self-loop edge at node %d", e.FromNodeID)
+		}
+		got[pair{e.FromNodeID, e.ToNodeID}] = e
+	}
+	for p, e := range got {
+		want := osm.WayID(10)
+		switch {
+		case p.from == 5 || p.to == 5:
+			want = 11
+		case p.from == 6 || p.to == 6:
+			want = 12
+		}
+		if e.WayID != want {
+			t.Errorf("edge %d->%d way = %d, want %d", p.from, p.to, e.WayID, want)
+		}
+	}
+	// 1-3 and 3-4 and 3-5 both ways; the loop broken at its middle node 6.
+	want := []pair{{1, 3}, {3, 1}, {3, 4}, {4, 3}, {3, 5}, {5, 3}, {4, 6}, {6, 4}}
+	if len(result.Edges) != len(want) {
+		t.Errorf("got %d edges, want %d", len(result.Edges), len(want))
+	}
+	for _, p := range want {
+		if _, ok := got[p]; !ok {
+			t.Errorf("missing edge %d->%d", p.from, p.to)
+		}
+	}
+
+	e := got[pair{1, 3}]
+	if !slices.Equal(e.ShapeLats, []float64{1.3010}) || !slices.Equal(e.ShapeLons, []float64{103.8005}) {
+		t.Errorf("1->3 shape = %v/%v, want node 2", e.ShapeLats, e.ShapeLons)
+	}
+	length := geo.Haversine(1.3000, 103.8000, 1.3010, 103.8005) + geo.Haversine(1.3010, 103.8005, 1.3020, 103.8000)
+	if w := computeWeightMs(length, e.SpeedKmh); e.Weight != w {
+		t.Errorf("1->3 weight = %d, want %d (along the shape)", e.Weight, w)
+	}
+	if back := got[pair{6, 4}]; !slices.Equal(back.ShapeLats, []float64{1.3040}) || !slices.Equal(back.ShapeLons, []float64{103.8010}) {
+		t.Errorf("6->4 shape = %v/%v, want node 7", back.ShapeLats, back.ShapeLons)
+	}
+}
+
+func TestParsePrivatePenalty(t *testing.T) {
+	// Public road 1-2, a private driveway 2-3 and a destination-only lane 2-4
+	// of equal length.
+	data := `<osm version="0.6">
+  <node id="1" lat="1.3000" lon="103.8000"/>
+  <node id="2" lat="1.3010" lon="103.8000"/>
+  <node id="3" lat="1.3020" lon="103.8000"/>
+  <node id="4" lat="1.3010" lon="103.8010"/>
+  <way id="10">
+    <nd ref="1"/><nd ref="2"/>
+    <tag k="highway" v="service"/>
+  </way>
+  <way id="11">
+    <nd ref="2"/><nd ref="3"/>
+    <tag k="highway" v="service"/>
+    <tag k="access" v="private"/>
+  </way>
+  <way id="12">
+    <nd ref="2"/><nd ref="4"/>
+    <tag k="highway" v="service"/>
+    <tag k="access" v="destination"/>
+  </way>
+</osm>`
+	edges := func(opt ParseOptions) map[osm.NodeID]RawEdge {
+		t.Helper()
+		result, err := Parse(context.Background(), strings.NewReader(data), opt)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		byTo := make(map[osm.NodeID]RawEdge)
+		for _, e := range result.Edges {
+			if e.FromNodeID == 2 || (e.FromNodeID == 1 && e.ToNodeID == 2) {
+				byTo[e.ToNodeID] = e
+			}
+		}
+		return byTo
+	}
+
+	plain := edges(ParseOptions{})
+	if !plain[3].Restricted || plain[4].Restricted {
+		t.Errorf("default: private restricted = %v, destination restricted = %v; want true, false", plain[3].Restricted, plain[4].Restricted)
+	}
+
+	penalized := edges(ParseOptions{PrivatePenalty: 10})
+	for _, to := range []osm.NodeID{3, 4} {
+		e := penalized[to]
+		if e.Restricted {
+			t.Errorf("2->%d still restricted with a private penalty", to)
+		}
+		if ratio := float64(e.Weight) / float64(plain[to].Weight); math.Abs(ratio-10) > 0.01 {
+			t.Errorf("2->%d weight ratio = %.3f, want 10", to, ratio)
+		}
+	}
+	if penalized[2].Weight != plain[2].Weight {
+		t.Errorf("public edge weight changed: %d -> %d", plain[2].Weight, penalized[2].Weight)
+	}
+}
+
+func TestParseMarksRoundabouts(t *testing.T) {
+	// Roundabout 1-2-3-1 with an approach road 4-1, and a circular arc 5-6
+	// continuing as the plain one-way 6-7: the two must not merge at node 6.
+	data := `<osm version="0.6">
+  <node id="1" lat="1.3000" lon="103.8000"/>
+  <node id="2" lat="1.3003" lon="103.8003"/>
+  <node id="3" lat="1.3000" lon="103.8006"/>
+  <node id="4" lat="1.2990" lon="103.7990"/>
+  <node id="5" lat="1.3100" lon="103.8000"/>
+  <node id="6" lat="1.3110" lon="103.8000"/>
+  <node id="7" lat="1.3120" lon="103.8000"/>
+  <way id="20">
+    <nd ref="1"/><nd ref="2"/><nd ref="3"/><nd ref="1"/>
+    <tag k="highway" v="tertiary"/>
+    <tag k="junction" v="roundabout"/>
+  </way>
+  <way id="21">
+    <nd ref="4"/><nd ref="1"/>
+    <tag k="highway" v="tertiary"/>
+  </way>
+  <way id="22">
+    <nd ref="5"/><nd ref="6"/>
+    <tag k="highway" v="primary"/>
+    <tag k="junction" v="circular"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+  <way id="23">
+    <nd ref="6"/><nd ref="7"/>
+    <tag k="highway" v="primary"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+</osm>`
+	result, err := Parse(context.Background(), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	ways := make(map[osm.WayID]int)
+	for _, e := range result.Edges {
+		ways[e.WayID]++
+		want := e.WayID == 20 || e.WayID == 22
+		if e.Roundabout != want {
+			t.Errorf("edge %d->%d (way %d) Roundabout = %v, want %v", e.FromNodeID, e.ToNodeID, e.WayID, e.Roundabout, want)
+		}
+		if e.WayID == 20 && e.ToNodeID == e.FromNodeID {
+			t.Errorf("roundabout collapsed to a self-loop at node %d", e.FromNodeID)
+		}
+	}
+	if ways[22] != 1 || ways[23] != 1 {
+		t.Errorf("edges per way = %v, want the circular arc and the plain road kept apart", ways)
+	}
+}
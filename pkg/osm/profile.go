@@ -0,0 +1,386 @@
+package osm
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// Profile evaluates OSM tags for one travel mode: which ways the mode may
+// use, in which directions, how fast, and which barriers stop it. The parser
+// consults only the profile, so a new mode is a new Profile rather than a
+// change to the parser.
+type Profile interface {
+	// Name identifies the profile in graph metadata, e.g. "car".
+	Name() string
+
+	// Highways lists the highway classes the mode can use at all, whatever
+	// their access tags say. Datasets store ways by this loose pre-filter.
+	Highways() []string
+
+	// Access reports whether a way is kept, and if kept whether it is
+	// restricted (usable for last-mile access only).
+	Access(tags osm.Tags) (keep, restricted bool)
+
+	// Direction reports whether the mode may travel along (forward) and
+	// against (backward) the way's node order.
+	Direction(tags osm.Tags) (forward, backward bool)
+
+	// Speed returns the free-flow speed (km/h) in each direction.
+	Speed(tags osm.Tags) (forward, backward float64)
+
+	// Penalty returns a travel-time multiplier (>= 1) for ways the mode can
+	// use but should avoid; 1 means no penalty. Distance weights ignore it.
+	Penalty(tags osm.Tags) float64
+
+	// BarrierRestricts reports whether a barrier node makes its adjacent
+	// edges restricted for the mode.
+	BarrierRestricts(tags osm.Tags) bool
+
+	// TurnRestrictionModes lists the vehicle types, most specific first,
+	// whose restriction:<type> tags bind the mode and whose listing in a
+	// restriction's except tag exempts it. Nil ignores turn restrictions.
+	TurnRestrictionModes() []string
+}
+
+// ProfileByName returns a built-in profile: "car", "hgv" (or "truck"),
+// "bike" (or "bicycle") or "foot" (or "walk"). speeds is the profile's speed
+// and penalty table; the zero value selects DefaultSpeedTableFor the profile.
+func ProfileByName(name string, speeds SpeedTable) (Profile, error) {
+	switch strings.ToLower(name) {
+	case "car", "":
+		return CarProfile{Speeds: speeds}, nil
+	case "hgv", "truck":
+		return HGVProfile{Speeds: speeds}, nil
+	case "bike", "bicycle":
+		return BikeProfile{Speeds: speeds}, nil
+	case "foot", "walk":
+		return FootProfile{Speeds: speeds}, nil
+	}
+	return nil, fmt.Errorf("unknown profile %q (want car, hgv, bike or foot)", name)
+}
+
+// CarProfile is the default profile: private cars, with speeds from a
+// SpeedTable.
+type CarProfile struct {
+	Speeds SpeedTable // zero value → DefaultSpeedTable()
+}
+
+func (CarProfile) Name() string { return "car" }
+
+func (CarProfile) Highways() []string { return slices.Sorted(maps.Keys(carHighways)) }
+
+func (CarProfile) Access(tags osm.Tags) (keep, restricted bool) { return classifyAccess(tags) }
+
+func (CarProfile) Direction(tags osm.Tags) (forward, backward bool) { return directionFlags(tags) }
+
+func (p CarProfile) Speed(tags osm.Tags) (forward, backward float64) {
+	return speedTableOrDefault(p.Speeds).DirectionalSpeedKmh(tags)
+}
+
+func (p CarProfile) Penalty(tags osm.Tags) float64 {
+	return speedTableOrDefault(p.Speeds).classPenalty(tags.Find("highway"))
+}
+
+func (CarProfile) BarrierRestricts(tags osm.Tags) bool { return nodeBarrierRestricts(tags) }
+
+func (CarProfile) TurnRestrictionModes() []string { return []string{"motorcar", "motor_vehicle"} }
+
+func speedTableOrDefault(s SpeedTable) SpeedTable { return speedTableFor(s, "car") }
+
+// speedTableFor returns s, or the profile's default table when s is unset.
+func speedTableFor(s SpeedTable, profile string) SpeedTable {
+	if s.ClassKmh == nil {
+		return DefaultSpeedTableFor(profile)
+	}
+	return s
+}
+
+// hgvDefaultMaxKmh is the speed limiter fitted to heavy goods vehicles.
+const hgvDefaultMaxKmh = 80
+
+// HGVProfile is a heavy goods vehicle: the car network and speeds, minus
+// ways closed to hgv or too small for the vehicle (maxweight, maxheight,
+// maxwidth, maxlength), capped at the vehicle's top speed and discouraged
+// from residential streets.
+type HGVProfile struct {
+	Speeds SpeedTable // zero value → DefaultSpeedTableFor("hgv")
+	MaxKmh float64    // top speed; 0 → 80 km/h
+
+	// The vehicle's gross weight (t) and dimensions (m); zero is not checked.
+	Weight, Height, Width, Length float64
+}
+
+func (HGVProfile) Name() string { return "hgv" }
+
+func (HGVProfile) Highways() []string { return CarProfile{}.Highways() }
+
+func (p HGVProfile) Access(tags osm.Tags) (keep, restricted bool) {
+	keep, restricted = vehicleAccess(tags, "hgv")
+	if keep && !wayLimits(tags).Admits(p.Weight, p.Height, p.Width, p.Length) {
+		return false, false
+	}
+	return keep, restricted
+}
+
+func (HGVProfile) Direction(tags osm.Tags) (forward, backward bool) { return directionFlags(tags) }
+
+func (p HGVProfile) Speed(tags osm.Tags) (forward, backward float64) {
+	forward, backward = speedTableFor(p.Speeds, "hgv").DirectionalSpeedKmh(tags)
+	limit := p.MaxKmh
+	if limit <= 0 {
+		limit = hgvDefaultMaxKmh
+	}
+	return min(forward, limit), min(backward, limit)
+}
+
+func (p HGVProfile) Penalty(tags osm.Tags) float64 {
+	return speedTableFor(p.Speeds, "hgv").classPenalty(tags.Find("highway"))
+}
+
+func (HGVProfile) BarrierRestricts(tags osm.Tags) bool { return nodeBarrierRestricts(tags) }
+
+func (HGVProfile) TurnRestrictionModes() []string { return []string{"hgv", "motor_vehicle"} }
+
+// modeAllowed reports whether a mode-specific access value explicitly opens a
+// way to the mode.
+func modeAllowed(v string) bool {
+	switch v {
+	case "yes", "designated", "permissive":
+		return true
+	}
+	return false
+}
+
+// modeAccess applies the access hierarchy for a non-motorised mode: the mode's
+// own tag (e.g. bicycle=*) wins, then access=*. Ways of an optional class
+// (say, footways for bicycles) are kept only when the mode tag opens them.
+func modeAccess(tags osm.Tags, modeKey string, classes, optional map[string]bool) (keep, restricted bool) {
+	hw := tags.Find("highway")
+	if (!classes[hw] && !optional[hw]) || tags.Find("area") == "yes" {
+		return false, false
+	}
+	switch v := tags.Find(modeKey); {
+	case v == "no" || v == "use_sidepath":
+		return false, false
+	case v == "private":
+		return true, true
+	case modeAllowed(v):
+		return true, false
+	}
+	if optional[hw] {
+		return false, false
+	}
+	switch tags.Find("access") {
+	case "no":
+		return false, false
+	case "private", "permit", "residents":
+		return true, true
+	}
+	return true, false
+}
+
+// bikeHighways are the classes open to bicycles by default; bikeOptional are
+// open only with an explicit bicycle=yes/designated/permissive.
+var (
+	bikeHighways = map[string]bool{
+		"cycleway": true, "path": true, "track": true, "living_street": true,
+		"residential": true, "service": true, "unclassified": true,
+		"tertiary": true, "tertiary_link": true, "secondary": true, "secondary_link": true,
+		"primary": true, "primary_link": true,
+	}
+	bikeOptional = map[string]bool{
+		"footway": true, "pedestrian": true, "bridleway": true, "trunk": true, "trunk_link": true,
+	}
+)
+
+// bikeDefaultKmh is the cruising speed on classes the bike table does not
+// list.
+const bikeDefaultKmh = 16
+
+// BikeProfile is a bicycle: cycleways and ordinary streets, oneway rules
+// relaxed by contraflow tags, busy arterials discouraged.
+type BikeProfile struct {
+	Speeds SpeedTable // cruising speeds and penalties; zero value → DefaultSpeedTableFor("bike")
+}
+
+func (BikeProfile) Name() string { return "bike" }
+
+func (BikeProfile) Highways() []string {
+	return slices.Sorted(maps.Keys(mergeSets(bikeHighways, bikeOptional)))
+}
+
+func (BikeProfile) Access(tags osm.Tags) (keep, restricted bool) {
+	if tags.Find("vehicle") == "no" && !modeAllowed(tags.Find("bicycle")) {
+		return false, false
+	}
+	return modeAccess(tags, "bicycle", bikeHighways, bikeOptional)
+}
+
+// Direction follows the car oneway rules, except that oneway:bicycle
+// overrides them and contraflow cycleways open the opposite direction.
+func (BikeProfile) Direction(tags osm.Tags) (forward, backward bool) {
+	forward, backward = directionFlags(tags)
+	switch tags.Find("oneway:bicycle") {
+	case "no":
+		return true, true
+	case "yes", "true", "1":
+		return true, false
+	case "-1":
+		return false, true
+	}
+	for _, key := range []string{"cycleway", "cycleway:left", "cycleway:right", "cycleway:both"} {
+		if strings.HasPrefix(tags.Find(key), "opposite") {
+			return true, true
+		}
+	}
+	return forward, backward
+}
+
+func (p BikeProfile) Speed(tags osm.Tags) (forward, backward float64) {
+	v := speedTableFor(p.Speeds, "bike").classKmh(tags.Find("highway"))
+	return v, v
+}
+
+// Penalty discourages arterials (the table's ClassPenalty) without cycling
+// infrastructure.
+func (p BikeProfile) Penalty(tags osm.Tags) float64 {
+	for _, key := range []string{"cycleway", "cycleway:left", "cycleway:right", "cycleway:both"} {
+		switch tags.Find(key) {
+		case "", "no", "none", "separate":
+		default:
+			return 1
+		}
+	}
+	return speedTableFor(p.Speeds, "bike").classPenalty(tags.Find("highway"))
+}
+
+// bikeBlockingBarriers cannot be ridden (or wheeled) through.
+var bikeBlockingBarriers = map[string]bool{
+	"stile": true, "turnstile": true, "full-height_turnstile": true, "kissing_gate": true,
+	"jersey_barrier": true, "log": true, "debris": true,
+}
+
+func (BikeProfile) BarrierRestricts(tags osm.Tags) bool {
+	return modeBarrierRestricts(tags, "bicycle", bikeBlockingBarriers)
+}
+
+func (BikeProfile) TurnRestrictionModes() []string { return []string{"bicycle"} }
+
+// GradeFactor implements GradeProfile: a 5% climb roughly halves a
+// leisure cyclist's speed, and descents are taken faster up to a braking
+// limit of 1.5× the flat speed.
+func (BikeProfile) GradeFactor(grade float64) float64 {
+	if grade >= 0 {
+		return 1 / (1 + 20*grade)
+	}
+	return min(1-5*grade, 1.5)
+}
+
+// footHighways are the classes open to pedestrians by default; footOptional
+// are open only with an explicit foot=yes/designated/permissive.
+var (
+	footHighways = map[string]bool{
+		"footway": true, "pedestrian": true, "path": true, "steps": true, "track": true,
+		"cycleway": true, "bridleway": true, "living_street": true, "residential": true,
+		"service": true, "unclassified": true, "tertiary": true, "tertiary_link": true,
+		"secondary": true, "secondary_link": true, "primary": true, "primary_link": true,
+	}
+	footOptional = map[string]bool{"trunk": true, "trunk_link": true}
+)
+
+const (
+	footKmh      = 5
+	footStepsKmh = 2
+)
+
+// FootProfile is a pedestrian: footways and streets in both directions.
+type FootProfile struct {
+	Speeds SpeedTable // walking speeds and penalties; zero value → DefaultSpeedTableFor("foot")
+}
+
+func (FootProfile) Name() string { return "foot" }
+
+func (FootProfile) Highways() []string {
+	return slices.Sorted(maps.Keys(mergeSets(footHighways, footOptional)))
+}
+
+func (FootProfile) Access(tags osm.Tags) (keep, restricted bool) {
+	return modeAccess(tags, "foot", footHighways, footOptional)
+}
+
+// Direction ignores oneway (which binds vehicles) unless oneway:foot is set.
+func (FootProfile) Direction(tags osm.Tags) (forward, backward bool) {
+	switch tags.Find("oneway:foot") {
+	case "yes", "true", "1":
+		return true, false
+	case "-1":
+		return false, true
+	}
+	return true, true
+}
+
+func (p FootProfile) Speed(tags osm.Tags) (forward, backward float64) {
+	v := speedTableFor(p.Speeds, "foot").classKmh(tags.Find("highway"))
+	return v, v
+}
+
+// Penalty discourages walking along fast roads (the table's ClassPenalty)
+// without a sidewalk.
+func (p FootProfile) Penalty(tags osm.Tags) float64 {
+	switch tags.Find("sidewalk") {
+	case "both", "left", "right", "yes", "separate":
+		return 1
+	}
+	return speedTableFor(p.Speeds, "foot").classPenalty(tags.Find("highway"))
+}
+
+// footBlockingBarriers cannot be walked through.
+var footBlockingBarriers = map[string]bool{"full-height_turnstile": true}
+
+func (FootProfile) BarrierRestricts(tags osm.Tags) bool {
+	return modeBarrierRestricts(tags, "foot", footBlockingBarriers)
+}
+
+func (FootProfile) TurnRestrictionModes() []string { return nil }
+
+// GradeFactor implements GradeProfile with Tobler's hiking function,
+// normalised to 1 on flat ground: walking is fastest on a gentle descent
+// (-5%) and slows on steeper slopes either way.
+func (FootProfile) GradeFactor(grade float64) float64 {
+	return math.Exp(-3.5*math.Abs(grade+0.05)) / math.Exp(-3.5*0.05)
+}
+
+// modeBarrierRestricts is nodeBarrierRestricts for a non-motorised mode:
+// blocking barriers restrict, gates restrict only when explicitly closed, and
+// a mode tag opening the node (bicycle=yes, ...) always wins.
+func modeBarrierRestricts(tags osm.Tags, modeKey string, blocking map[string]bool) bool {
+	b := tags.Find("barrier")
+	if b == "" || modeAllowed(tags.Find(modeKey)) {
+		return false
+	}
+	if blocking[b] || tags.Find(modeKey) == "no" {
+		return true
+	}
+	if !gateBarriers[b] {
+		return false
+	}
+	if tags.Find("locked") == "yes" {
+		return true
+	}
+	switch tags.Find("access") {
+	case "no", "private", "permit", "residents":
+		return true
+	}
+	return false
+}
+
+func mergeSets(a, b map[string]bool) map[string]bool {
+	out := maps.Clone(a)
+	maps.Copy(out, b)
+	return out
+}
@@ -0,0 +1,123 @@
+package osm
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestProfileAccess(t *testing.T) {
+	cases := []struct {
+		name           string
+		profile        Profile
+		tags           []string
+		wantKeep       bool
+		wantRestricted bool
+	}{
+		{"car residential", CarProfile{}, []string{"highway", "residential"}, true, false},
+		{"car footway", CarProfile{}, []string{"highway", "footway"}, false, false},
+		{"hgv=no", HGVProfile{}, []string{"highway", "primary", "hgv", "no"}, false, false},
+		{"hgv=destination", HGVProfile{}, []string{"highway", "primary", "hgv", "destination"}, true, true},
+		{"hgv ignores motorcar=no", HGVProfile{}, []string{"highway", "primary", "motorcar", "no"}, true, false},
+		{"hgv motor_vehicle=no hgv=yes", HGVProfile{}, []string{"highway", "primary", "motor_vehicle", "no", "hgv", "yes"}, true, false},
+		{"hgv=delivery", HGVProfile{}, []string{"highway", "primary", "hgv", "delivery"}, true, true},
+		{"hgv under a low bridge", HGVProfile{Height: 4.2}, []string{"highway", "primary", "maxheight", "3.8"}, false, false},
+		{"hgv over a weak bridge", HGVProfile{Weight: 40}, []string{"highway", "primary", "maxweight", "32 t"}, false, false},
+		{"hgv fits", HGVProfile{Weight: 18, Height: 3.5}, []string{"highway", "primary", "maxweight", "32", "maxheight", "3.8"}, true, false},
+		{"hgv keeps car restriction", HGVProfile{}, []string{"highway", "service", "access", "private"}, true, true},
+		{"bike cycleway", BikeProfile{}, []string{"highway", "cycleway"}, true, false},
+		{"bike motorway", BikeProfile{}, []string{"highway", "motorway"}, false, false},
+		{"bike footway", BikeProfile{}, []string{"highway", "footway"}, false, false},
+		{"bike footway bicycle=yes", BikeProfile{}, []string{"highway", "footway", "bicycle", "yes"}, true, false},
+		{"bike bicycle=no", BikeProfile{}, []string{"highway", "residential", "bicycle", "no"}, false, false},
+		{"bike access=no bicycle=yes", BikeProfile{}, []string{"highway", "service", "access", "no", "bicycle", "yes"}, true, false},
+		{"bike vehicle=no", BikeProfile{}, []string{"highway", "residential", "vehicle", "no"}, false, false},
+		{"foot steps", FootProfile{}, []string{"highway", "steps"}, true, false},
+		{"foot trunk", FootProfile{}, []string{"highway", "trunk"}, false, false},
+		{"foot private", FootProfile{}, []string{"highway", "footway", "access", "private"}, true, true},
+		{"foot=no", FootProfile{}, []string{"highway", "residential", "foot", "no"}, false, false},
+	}
+	for _, c := range cases {
+		keep, restricted := c.profile.Access(tags(c.tags...))
+		if keep != c.wantKeep || restricted != c.wantRestricted {
+			t.Errorf("%s: Access = (%v,%v), want (%v,%v)", c.name, keep, restricted, c.wantKeep, c.wantRestricted)
+		}
+	}
+}
+
+func TestProfileDirection(t *testing.T) {
+	cases := []struct {
+		name          string
+		profile       Profile
+		tags          []string
+		wantFwd, wBwd bool
+	}{
+		{"bike follows oneway", BikeProfile{}, []string{"highway", "residential", "oneway", "yes"}, true, false},
+		{"bike oneway:bicycle=no", BikeProfile{}, []string{"highway", "residential", "oneway", "yes", "oneway:bicycle", "no"}, true, true},
+		{"bike contraflow lane", BikeProfile{}, []string{"highway", "residential", "oneway", "yes", "cycleway:left", "opposite_lane"}, true, true},
+		{"foot ignores oneway", FootProfile{}, []string{"highway", "residential", "oneway", "yes"}, true, true},
+		{"foot oneway:foot", FootProfile{}, []string{"highway", "footway", "oneway:foot", "-1"}, false, true},
+		{"hgv motorway", HGVProfile{}, []string{"highway", "motorway"}, true, false},
+	}
+	for _, c := range cases {
+		fwd, bwd := c.profile.Direction(tags(c.tags...))
+		if fwd != c.wantFwd || bwd != c.wBwd {
+			t.Errorf("%s: Direction = (%v,%v), want (%v,%v)", c.name, fwd, bwd, c.wantFwd, c.wBwd)
+		}
+	}
+}
+
+func TestProfileSpeedAndPenalty(t *testing.T) {
+	if fwd, _ := (HGVProfile{}).Speed(tags("highway", "motorway")); fwd != hgvDefaultMaxKmh {
+		t.Errorf("hgv motorway speed = %v, want the %d km/h limiter", fwd, hgvDefaultMaxKmh)
+	}
+	if fwd, _ := (HGVProfile{}).Speed(tags("highway", "residential")); fwd != 25 {
+		t.Errorf("hgv residential speed = %v, want the car speed 25", fwd)
+	}
+	if fwd, _ := (FootProfile{}).Speed(tags("highway", "steps")); fwd != footStepsKmh {
+		t.Errorf("foot steps speed = %v, want %d", fwd, footStepsKmh)
+	}
+	if p := (BikeProfile{}).Penalty(tags("highway", "primary")); p <= 1 {
+		t.Errorf("bike primary penalty = %v, want > 1", p)
+	}
+	if p := (BikeProfile{}).Penalty(tags("highway", "primary", "cycleway", "track")); p != 1 {
+		t.Errorf("bike primary with cycle track penalty = %v, want 1", p)
+	}
+	if (BikeProfile{}).BarrierRestricts(tags("barrier", "bollard")) {
+		t.Error("bollards should not stop bicycles")
+	}
+	if !(CarProfile{}).BarrierRestricts(tags("barrier", "bollard")) {
+		t.Error("bollards should stop cars")
+	}
+}
+
+func TestProfileByName(t *testing.T) {
+	for _, name := range []string{"car", "hgv", "bike", "bicycle", "foot"} {
+		if _, err := ProfileByName(name, SpeedTable{}); err != nil {
+			t.Errorf("ProfileByName(%q): %v", name, err)
+		}
+	}
+	if _, err := ProfileByName("hovercraft", SpeedTable{}); err == nil {
+		t.Error("ProfileByName accepted an unknown profile")
+	}
+}
+
+func TestParseWithFootProfile(t *testing.T) {
+	f, err := os.Open("testdata/tiny.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	result, err := Parse(context.Background(), f, ParseOptions{Profile: FootProfile{}})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	// Every way is walkable in both directions, including one-way 11 and
+	// footway 12.
+	if len(result.Edges) != 8 {
+		t.Errorf("got %d edges, want 8", len(result.Edges))
+	}
+	if len(result.Restrictions) != 0 {
+		t.Errorf("foot profile kept %d turn restrictions", len(result.Restrictions))
+	}
+}
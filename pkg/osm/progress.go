@@ -0,0 +1,139 @@
+package osm
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/paulmach/osm"
+)
+
+// Phase names a stage of parsing.
+type Phase string
+
+const (
+	PhaseWays  Phase = "ways"  // pass 1: ways and turn restriction relations
+	PhaseNodes Phase = "nodes" // pass 2: coordinates of routing nodes
+	PhaseEdges Phase = "edges" // building directed edges from the kept ways
+)
+
+// Progress is a snapshot passed to ParseOptions.Progress. Counts are
+// cumulative over the whole parse; byte counts cover the current pass.
+type Progress struct {
+	Phase      Phase
+	Input      int   // index of the input being read
+	BytesRead  int64 // bytes consumed by the current pass, across inputs
+	TotalBytes int64 // bytes the current pass will read; 0 when unknown
+
+	Ways      int64 // ways scanned (kept or not)
+	Relations int64 // relations scanned
+	Nodes     int64 // nodes scanned
+}
+
+// Fraction returns the share of the current pass completed, or -1 when the
+// input size is unknown.
+func (p Progress) Fraction() float64 {
+	if p.TotalBytes <= 0 {
+		return -1
+	}
+	return min(float64(p.BytesRead)/float64(p.TotalBytes), 1)
+}
+
+// progressEvery is how many objects are scanned between callbacks, keeping the
+// callback off the hot path.
+const progressEvery = 1 << 16
+
+// progressTracker accumulates counts and drives the callback. A nil tracker
+// (no callback) ignores every call.
+type progressTracker struct {
+	fn    func(Progress)
+	p     Progress
+	since int
+	done  int64         // bytes of the inputs already finished in this pass
+	pos   *atomic.Int64 // offset within the current input; read concurrently by PBF decoding
+}
+
+func newProgressTracker(fn func(Progress), srcs sources) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	t := &progressTracker{fn: fn}
+	for _, src := range srcs {
+		t.p.TotalBytes += src.size
+	}
+	return t
+}
+
+// startPass resets the byte counters for a new scan over every input.
+func (t *progressTracker) startPass(phase Phase) {
+	if t == nil {
+		return
+	}
+	t.p.Phase = phase
+	t.p.BytesRead = 0
+	t.done = 0
+	t.pos = nil
+	t.report()
+}
+
+// wrap starts reading input i, returning a reader that counts its bytes.
+func (t *progressTracker) wrap(i int, src source) io.ReadSeeker {
+	if t == nil {
+		return src.rs
+	}
+	if t.pos != nil {
+		t.done += t.pos.Load()
+	}
+	t.p.Input = i
+	t.pos = new(atomic.Int64)
+	return &countingReadSeeker{rs: src.rs, pos: t.pos}
+}
+
+// object counts one scanned object, reporting every progressEvery.
+func (t *progressTracker) object(obj osm.Object) {
+	if t == nil {
+		return
+	}
+	switch obj.(type) {
+	case *osm.Node:
+		t.p.Nodes++
+	case *osm.Way:
+		t.p.Ways++
+	case *osm.Relation:
+		t.p.Relations++
+	}
+	if t.since++; t.since >= progressEvery {
+		t.report()
+	}
+}
+
+func (t *progressTracker) report() {
+	if t == nil {
+		return
+	}
+	t.since = 0
+	t.p.BytesRead = t.done
+	if t.pos != nil {
+		t.p.BytesRead += t.pos.Load()
+	}
+	t.fn(t.p)
+}
+
+// countingReadSeeker tracks the read offset of the underlying input.
+type countingReadSeeker struct {
+	rs  io.ReadSeeker
+	pos *atomic.Int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.rs.Read(p)
+	c.pos.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	off, err := c.rs.Seek(offset, whence)
+	if err == nil {
+		c.pos.Store(off)
+	}
+	return off, err
+}
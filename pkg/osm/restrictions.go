@@ -0,0 +1,197 @@
+package osm
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/paulmach/osm"
+)
+
+// TurnRestriction is a type=restriction relation resolved to the node path it
+// governs: the node before the via on the from-way, the via node (or the nodes
+// of the via way(s)), and the node after it on the to-way. Consecutive path
+// nodes are consecutive way nodes, so each pair names one RawEdge.
+type TurnRestriction struct {
+	ID   osm.RelationID
+	Path []osm.NodeID
+	Only bool // only_* (the path is mandatory); otherwise no_* (forbidden)
+}
+
+// rawRestriction is a restriction relation as read in pass 1, before its
+// member ways are resolved to node paths.
+type rawRestriction struct {
+	id      osm.RelationID
+	only    bool
+	from    []osm.WayID
+	to      []osm.WayID
+	viaNode osm.NodeID
+	viaWays []osm.WayID
+}
+
+// parseRestriction extracts a turn restriction binding the vehicle types in
+// modes (see Profile.TurnRestrictionModes) from a relation. It reports false
+// for relations that are not restrictions, do not apply to those modes, or
+// are malformed.
+func parseRestriction(r *osm.Relation, modes []string) (rawRestriction, bool) {
+	if r.Tags.Find("type") != "restriction" || len(modes) == 0 {
+		return rawRestriction{}, false
+	}
+	var kind string
+	for _, m := range modes {
+		if kind = r.Tags.Find("restriction:" + m); kind != "" {
+			break
+		}
+	}
+	if kind == "" {
+		kind = r.Tags.Find("restriction")
+	}
+	var only bool
+	switch {
+	case strings.HasPrefix(kind, "only_"):
+		only = true
+	case strings.HasPrefix(kind, "no_"):
+	default:
+		return rawRestriction{}, false
+	}
+	for ex := range strings.SplitSeq(r.Tags.Find("except"), ";") {
+		if ex = strings.TrimSpace(ex); slices.Contains(modes, ex) {
+			return rawRestriction{}, false
+		}
+	}
+
+	rr := rawRestriction{id: r.ID, only: only}
+	for _, m := range r.Members {
+		switch {
+		case m.Role == "from" && m.Type == osm.TypeWay:
+			rr.from = append(rr.from, osm.WayID(m.Ref))
+		case m.Role == "to" && m.Type == osm.TypeWay:
+			rr.to = append(rr.to, osm.WayID(m.Ref))
+		case m.Role == "via" && m.Type == osm.TypeNode:
+			if rr.viaNode != 0 || len(rr.viaWays) > 0 {
+				return rawRestriction{}, false
+			}
+			rr.viaNode = osm.NodeID(m.Ref)
+		case m.Role == "via" && m.Type == osm.TypeWay:
+			if rr.viaNode != 0 {
+				return rawRestriction{}, false
+			}
+			rr.viaWays = append(rr.viaWays, osm.WayID(m.Ref))
+		}
+	}
+	if len(rr.from) == 0 || len(rr.to) == 0 || (rr.viaNode == 0 && len(rr.viaWays) == 0) {
+		return rawRestriction{}, false
+	}
+	// A mandatory turn names exactly one path; no_entry/no_exit style
+	// prohibitions may list several from or to ways.
+	if only && (len(rr.from) != 1 || len(rr.to) != 1) {
+		return rawRestriction{}, false
+	}
+	return rr, true
+}
+
+// resolveRestrictions turns raw restrictions into node paths over the kept
+// ways. Restrictions whose ways were dropped (not car-accessible, or outside
+// the extract) or that do not connect end to end are skipped and counted.
+func resolveRestrictions(raw []rawRestriction, wayNodes map[osm.WayID][]osm.NodeID) (out []TurnRestriction, unresolved int) {
+	for _, rr := range raw {
+		resolved := false
+		for _, from := range rr.from {
+			for _, to := range rr.to {
+				path, ok := restrictionPath(rr, wayNodes[from], wayNodes[to], wayNodes)
+				if !ok {
+					continue
+				}
+				out = append(out, TurnRestriction{ID: rr.id, Path: path, Only: rr.only})
+				resolved = true
+			}
+		}
+		if !resolved {
+			unresolved++
+		}
+	}
+	return out, unresolved
+}
+
+// restrictionPath builds the node path for one from/to pair.
+func restrictionPath(rr rawRestriction, from, to []osm.NodeID, wayNodes map[osm.WayID][]osm.NodeID) ([]osm.NodeID, bool) {
+	if len(from) < 2 || len(to) < 2 {
+		return nil, false
+	}
+	if rr.viaNode != 0 {
+		prev, ok := endNeighbor(from, rr.viaNode)
+		if !ok {
+			return nil, false
+		}
+		next, ok := endNeighbor(to, rr.viaNode)
+		if !ok {
+			return nil, false
+		}
+		return []osm.NodeID{prev, rr.viaNode, next}, true
+	}
+
+	// Via way(s): chain them from an endpoint of the from-way, in whatever
+	// order the relation lists them.
+	via := make([][]osm.NodeID, 0, len(rr.viaWays))
+	for _, id := range rr.viaWays {
+		nodes := wayNodes[id]
+		if len(nodes) < 2 {
+			return nil, false
+		}
+		via = append(via, nodes)
+	}
+	for _, start := range []osm.NodeID{from[0], from[len(from)-1]} {
+		prev, _ := endNeighbor(from, start)
+		path := []osm.NodeID{prev, start}
+		if path, ok := chainVia(path, via); ok {
+			end := path[len(path)-1]
+			if next, ok := endNeighbor(to, end); ok {
+				return append(path, next), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// chainVia extends path through every via way, each joined at the path's
+// current end.
+func chainVia(path []osm.NodeID, via [][]osm.NodeID) ([]osm.NodeID, bool) {
+	used := make([]bool, len(via))
+	for range via {
+		end := path[len(path)-1]
+		found := false
+		for i, nodes := range via {
+			if used[i] {
+				continue
+			}
+			switch end {
+			case nodes[0]:
+				path = append(path, nodes[1:]...)
+			case nodes[len(nodes)-1]:
+				for j := len(nodes) - 2; j >= 0; j-- {
+					path = append(path, nodes[j])
+				}
+			default:
+				continue
+			}
+			used[i], found = true, true
+			break
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return path, true
+}
+
+// endNeighbor returns the node next to n when n is an endpoint of the way.
+// Restrictions whose via lies mid-way are ambiguous about direction and are
+// not resolved.
+func endNeighbor(nodes []osm.NodeID, n osm.NodeID) (osm.NodeID, bool) {
+	switch n {
+	case nodes[0]:
+		return nodes[1], true
+	case nodes[len(nodes)-1]:
+		return nodes[len(nodes)-2], true
+	}
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package osm
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func restrictionRel(tags osm.Tags, members ...osm.Member) *osm.Relation {
+	return &osm.Relation{ID: 1, Tags: tags, Members: members}
+}
+
+func TestParseRestriction(t *testing.T) {
+	from := osm.Member{Type: osm.TypeWay, Ref: 10, Role: "from"}
+	via := osm.Member{Type: osm.TypeNode, Ref: 3, Role: "via"}
+	to := osm.Member{Type: osm.TypeWay, Ref: 11, Role: "to"}
+
+	tests := []struct {
+		name     string
+		rel      *osm.Relation
+		wantOK   bool
+		wantOnly bool
+	}{
+		{"no_left_turn", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "no_left_turn"}}, from, via, to), true, false},
+		{"only_straight_on", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "only_straight_on"}}, from, via, to), true, true},
+		{"motorcar-specific wins", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction:motorcar", Value: "no_u_turn"}}, from, via, to), true, false},
+		{"except motorcar", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "no_left_turn"}, {Key: "except", Value: "bicycle;motorcar"}}, from, via, to), false, false},
+		{"hgv only", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction:hgv", Value: "no_left_turn"}}, from, via, to), false, false},
+		{"missing via", restrictionRel(osm.Tags{{Key: "type", Value: "restriction"}, {Key: "restriction", Value: "no_left_turn"}}, from, to), false, false},
+		{"not a restriction", restrictionRel(osm.Tags{{Key: "type", Value: "route"}}, from, via, to), false, false},
+	}
+	for _, tt := range tests {
+		rr, ok := parseRestriction(tt.rel, CarProfile{}.TurnRestrictionModes())
+		if ok != tt.wantOK || (ok && rr.only != tt.wantOnly) {
+			t.Errorf("%s: ok=%v only=%v, want ok=%v only=%v", tt.name, ok, rr.only, tt.wantOK, tt.wantOnly)
+		}
+	}
+}
+
+func TestResolveRestrictions(t *testing.T) {
+	wayNodes := map[osm.WayID][]osm.NodeID{
+		10: {1, 2, 3}, // from
+		11: {3, 4},    // to via node 3
+		20: {6, 5, 3}, // via way, listed reversed
+		21: {6, 7},    // to via way 20
+		30: {8, 3, 9}, // passes through node 3
+	}
+	raw := []rawRestriction{
+		{id: 1, from: []osm.WayID{10}, viaNode: 3, to: []osm.WayID{11}},
+		{id: 2, from: []osm.WayID{10}, viaWays: []osm.WayID{20}, to: []osm.WayID{21}, only: true},
+		{id: 3, from: []osm.WayID{10}, viaNode: 3, to: []osm.WayID{30}}, // ambiguous
+		{id: 4, from: []osm.WayID{10}, viaNode: 3, to: []osm.WayID{99}}, // dropped way
+	}
+	got, unresolved := resolveRestrictions(raw, wayNodes)
+	if unresolved != 2 {
+		t.Errorf("unresolved = %d, want 2", unresolved)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d restrictions, want 2: %+v", len(got), got)
+	}
+	if !slices.Equal(got[0].Path, []osm.NodeID{2, 3, 4}) {
+		t.Errorf("via-node path = %v, want [2 3 4]", got[0].Path)
+	}
+	if !slices.Equal(got[1].Path, []osm.NodeID{2, 3, 5, 6, 7}) || !got[1].Only {
+		t.Errorf("via-way path = %v only=%v, want [2 3 5 6 7] only", got[1].Path, got[1].Only)
+	}
+}
@@ -0,0 +1,89 @@
+package osm
+
+// RoadClass is an edge's OSM highway class in one byte, for consumers that
+// keep it per edge (turn instructions, avoid filters, map styling).
+type RoadClass uint8
+
+// Road classes. The zero value is a highway value without its own class.
+const (
+	ClassOther RoadClass = iota
+	ClassMotorway
+	ClassMotorwayLink
+	ClassTrunk
+	ClassTrunkLink
+	ClassPrimary
+	ClassPrimaryLink
+	ClassSecondary
+	ClassSecondaryLink
+	ClassTertiary
+	ClassTertiaryLink
+	ClassUnclassified
+	ClassResidential
+	ClassLivingStreet
+	ClassService
+	ClassTrack
+	ClassPath
+	ClassCycleway
+	ClassFootway
+	ClassPedestrian
+	ClassBridleway
+	ClassSteps
+)
+
+var roadClassNames = [...]string{
+	ClassOther:         "",
+	ClassMotorway:      "motorway",
+	ClassMotorwayLink:  "motorway_link",
+	ClassTrunk:         "trunk",
+	ClassTrunkLink:     "trunk_link",
+	ClassPrimary:       "primary",
+	ClassPrimaryLink:   "primary_link",
+	ClassSecondary:     "secondary",
+	ClassSecondaryLink: "secondary_link",
+	ClassTertiary:      "tertiary",
+	ClassTertiaryLink:  "tertiary_link",
+	ClassUnclassified:  "unclassified",
+	ClassResidential:   "residential",
+	ClassLivingStreet:  "living_street",
+	ClassService:       "service",
+	ClassTrack:         "track",
+	ClassPath:          "path",
+	ClassCycleway:      "cycleway",
+	ClassFootway:       "footway",
+	ClassPedestrian:    "pedestrian",
+	ClassBridleway:     "bridleway",
+	ClassSteps:         "steps",
+}
+
+var roadClassByName = func() map[string]RoadClass {
+	m := make(map[string]RoadClass, len(roadClassNames))
+	for c, name := range roadClassNames {
+		if name != "" {
+			m[name] = RoadClass(c)
+		}
+	}
+	return m
+}()
+
+// ParseRoadClass returns the class of a highway tag value; unlisted values
+// (road, busway, ...) are ClassOther.
+func ParseRoadClass(highway string) RoadClass {
+	return roadClassByName[highway]
+}
+
+// String returns the highway tag value of c, or "" for ClassOther.
+func (c RoadClass) String() string {
+	if int(c) < len(roadClassNames) {
+		return roadClassNames[c]
+	}
+	return ""
+}
+
+// IsLink reports whether c is a *_link ramp or slip road.
+func (c RoadClass) IsLink() bool {
+	switch c {
+	case ClassMotorwayLink, ClassTrunkLink, ClassPrimaryLink, ClassSecondaryLink, ClassTertiaryLink:
+		return true
+	}
+	return false
+}
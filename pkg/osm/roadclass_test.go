@@ -0,0 +1,19 @@
+package osm
+
+import "testing"
+
+func TestRoadClassRoundTrip(t *testing.T) {
+	for c := ClassMotorway; c <= ClassSteps; c++ {
+		if got := ParseRoadClass(c.String()); got != c {
+			t.Errorf("ParseRoadClass(%q) = %d, want %d", c.String(), got, c)
+		}
+	}
+	for _, hw := range []string{"", "road", "busway", "construction"} {
+		if got := ParseRoadClass(hw); got != ClassOther {
+			t.Errorf("ParseRoadClass(%q) = %v, want ClassOther", hw, got)
+		}
+	}
+	if !ClassTrunkLink.IsLink() || ClassTrunk.IsLink() {
+		t.Error("IsLink wrong for trunk/trunk_link")
+	}
+}
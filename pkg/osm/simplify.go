@@ -0,0 +1,192 @@
+package osm
+
+import (
+	"slices"
+
+	"github.com/paulmach/osm"
+)
+
+// chainNode tracks the edges incident to one node while chains are
+// collapsed. Only nodes with at most two edges in and two out can be removed,
+// so larger counts are recorded but their edges are not.
+type chainNode struct {
+	in, out   [2]int
+	nIn, nOut int
+}
+
+// simplifyChains collapses nodes that merely continue a road — one road in,
+// the same road out — into single edges, appending the removed node to the
+// merged edge's shape. Ways are already split at every node shared between
+// them, so these are the junctions where one way ends and the next begins
+// (a street renamed mid-block is not merged, as the edges disagree on Name).
+//
+// Nodes for which keep reports true are never removed. Weights, ascent and
+// descent are summed; SpeedKmh becomes the average speed over the merged
+// length. It returns the surviving edges and the number of nodes removed.
+func simplifyChains(edges []RawEdge, keep func(osm.NodeID) bool, coords *NodeCoords, distance bool) ([]RawEdge, int) {
+	ids := make([]osm.NodeID, 0, 2*len(edges))
+	for _, e := range edges {
+		ids = append(ids, e.FromNodeID, e.ToNodeID)
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	index := func(id osm.NodeID) int {
+		i, _ := slices.BinarySearch(ids, id)
+		return i
+	}
+
+	nodes := make([]chainNode, len(ids))
+	for i, e := range edges {
+		if n := &nodes[index(e.FromNodeID)]; n.nOut < 2 {
+			n.out[n.nOut] = i
+			n.nOut++
+		} else {
+			n.nOut++
+		}
+		if n := &nodes[index(e.ToNodeID)]; n.nIn < 2 {
+			n.in[n.nIn] = i
+			n.nIn++
+		} else {
+			n.nIn++
+		}
+	}
+
+	dead := make([]bool, len(edges))
+	removed := 0
+	for x, n := range nodes {
+		id := ids[x]
+		pairs, ok := chainPairs(edges, n, id)
+		if !ok || keep(id) {
+			continue
+		}
+		lat, lon, ok := coords.Get(id)
+		if !ok {
+			continue
+		}
+		for _, p := range pairs {
+			in, out := p[0], p[1]
+			edges[in] = mergeEdges(edges[in], edges[out], lat, lon, distance)
+			dead[out] = true
+			// The merged edge now arrives where out did.
+			tail := &nodes[index(edges[in].ToNodeID)]
+			for k := 0; k < min(tail.nIn, 2); k++ {
+				if tail.in[k] == out {
+					tail.in[k] = in
+				}
+			}
+		}
+		removed++
+	}
+
+	kept := edges[:0]
+	for i, e := range edges {
+		if !dead[i] {
+			kept = append(kept, e)
+		}
+	}
+	clear(edges[len(kept):]) // release the shapes of merged-away edges
+	return kept, removed
+}
+
+// chainPairs reports whether node x (with ID id) only continues a road, and
+// which (in, out) edge pairs then merge: one for a one-way road, two for a
+// two-way road. Each pair must agree on name, access, time window and
+// vehicle limits, and must not fold back onto itself.
+func chainPairs(edges []RawEdge, n chainNode, id osm.NodeID) ([][2]int, bool) {
+	var pairs [][2]int
+	switch {
+	case n.nIn == 1 && n.nOut == 1:
+		pairs = [][2]int{{n.in[0], n.out[0]}}
+	case n.nIn == 2 && n.nOut == 2:
+		a, b := edges[n.in[0]].FromNodeID, edges[n.in[1]].FromNodeID
+		switch {
+		case a == b:
+			return nil, false
+		case edges[n.out[0]].ToNodeID == b && edges[n.out[1]].ToNodeID == a:
+			pairs = [][2]int{{n.in[0], n.out[0]}, {n.in[1], n.out[1]}}
+		case edges[n.out[0]].ToNodeID == a && edges[n.out[1]].ToNodeID == b:
+			pairs = [][2]int{{n.in[0], n.out[1]}, {n.in[1], n.out[0]}}
+		default:
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	for _, p := range pairs {
+		in, out := edges[p[0]], edges[p[1]]
+		if in.FromNodeID == id || out.ToNodeID == id || in.FromNodeID == out.ToNodeID ||
+			in.Name != out.Name || in.Restricted != out.Restricted || in.Window != out.Window ||
+			in.Limits != out.Limits || in.Roundabout != out.Roundabout || in.Flags != out.Flags {
+			return nil, false
+		}
+	}
+	return pairs, true
+}
+
+// mergeEdges joins a (ending at the node at lat/lon) and b (leaving it). The
+// result keeps a's WayID.
+func mergeEdges(a, b RawEdge, lat, lon float64, distance bool) RawEdge {
+	// Recover each edge's length and time from its weight and speed, so the
+	// merged speed is the true average rather than a mean of the two.
+	var lenA, lenB, timeA, timeB float64 // arbitrary but consistent units
+	if distance {
+		lenA, lenB = float64(a.Weight), float64(b.Weight)
+		timeA, timeB = lenA/max(a.SpeedKmh, 1), lenB/max(b.SpeedKmh, 1)
+	} else {
+		timeA, timeB = float64(a.Weight), float64(b.Weight)
+		lenA, lenB = timeA*a.SpeedKmh, timeB*b.SpeedKmh
+	}
+	speed := a.SpeedKmh
+	if t := timeA + timeB; t > 0 {
+		speed = (lenA + lenB) / t
+	}
+
+	shapeLats := make([]float64, 0, len(a.ShapeLats)+1+len(b.ShapeLats))
+	shapeLats = append(append(append(shapeLats, a.ShapeLats...), lat), b.ShapeLats...)
+	shapeLons := make([]float64, 0, len(a.ShapeLons)+1+len(b.ShapeLons))
+	shapeLons = append(append(append(shapeLons, a.ShapeLons...), lon), b.ShapeLons...)
+
+	a.ToNodeID = b.ToNodeID
+	a.Weight += b.Weight
+	a.Ascent += b.Ascent
+	a.Descent += b.Descent
+	a.SpeedKmh = speed
+	a.ShapeLats, a.ShapeLons = shapeLats, shapeLons
+	return a
+}
+
+// dedupeParallel keeps one edge per directed node pair: the cheapest public
+// one, or the cheapest restricted one when all are restricted (dropping the
+// only public way between two nodes would turn a through-road into a gated
+// one). Parallel edges come from distinct ways joining the same two
+// junctions — dual carriageways mapped twice, a service road beside the main
+// road — and only add CSR entries and equal-looking alternatives. Survivors
+// keep their order. It returns the kept edges and the number dropped.
+func dedupeParallel(edges []RawEdge) ([]RawEdge, int) {
+	type pair struct{ from, to osm.NodeID }
+	best := make(map[pair]int, len(edges))
+	better := func(a, b RawEdge) bool {
+		if a.Restricted != b.Restricted {
+			return !a.Restricted
+		}
+		return a.Weight < b.Weight
+	}
+	for i, e := range edges {
+		p := pair{e.FromNodeID, e.ToNodeID}
+		if j, ok := best[p]; !ok || better(e, edges[j]) {
+			best[p] = i
+		}
+	}
+	if len(best) == len(edges) {
+		return edges, 0
+	}
+	kept := edges[:0]
+	for i, e := range edges {
+		if best[pair{e.FromNodeID, e.ToNodeID}] == i {
+			kept = append(kept, e)
+		}
+	}
+	dropped := len(edges) - len(kept)
+	clear(edges[len(kept):])
+	return kept, dropped
+}
@@ -0,0 +1,120 @@
+package osm
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestSimplifyChains(t *testing.T) {
+	b := newCoordBuilder([]osm.NodeID{1, 2, 3, 4, 5, 6, 7})
+	for id := osm.NodeID(1); id <= 7; id++ {
+		b.set(id, 1.3, 103.8+float64(id)/1000)
+	}
+	coords := b.finish()
+
+	edges := []RawEdge{
+		// Two-way 1-2-3: node 2 continues the road.
+		{FromNodeID: 1, ToNodeID: 2, Weight: 100, SpeedKmh: 50, ShapeLats: []float64{9}, ShapeLons: []float64{9}},
+		{FromNodeID: 2, ToNodeID: 1, Weight: 100, SpeedKmh: 50},
+		{FromNodeID: 2, ToNodeID: 3, Weight: 300, SpeedKmh: 30},
+		{FromNodeID: 3, ToNodeID: 2, Weight: 300, SpeedKmh: 30},
+		// One-way 3->4->5, then 5->6 under another name.
+		{FromNodeID: 3, ToNodeID: 4, Weight: 10, SpeedKmh: 40},
+		{FromNodeID: 4, ToNodeID: 5, Weight: 10, SpeedKmh: 40},
+		{FromNodeID: 5, ToNodeID: 6, Weight: 10, SpeedKmh: 40, Name: 1},
+		// 6->7 continues 5->6, but 6 is kept (say, a traffic signal).
+		{FromNodeID: 6, ToNodeID: 7, Weight: 10, SpeedKmh: 40, Name: 1},
+	}
+	got, removed := simplifyChains(edges, func(id osm.NodeID) bool { return id == 6 }, coords, false)
+	if removed != 2 {
+		t.Errorf("removed %d nodes, want 2 (nodes 2 and 4)", removed)
+	}
+
+	type pair struct{ from, to osm.NodeID }
+	byPair := make(map[pair]RawEdge)
+	for _, e := range got {
+		byPair[pair{e.FromNodeID, e.ToNodeID}] = e
+	}
+	want := []pair{{1, 3}, {3, 1}, {3, 5}, {5, 6}, {6, 7}}
+	if len(got) != len(want) {
+		t.Errorf("got %d edges, want %d: %+v", len(got), len(want), got)
+	}
+	for _, p := range want {
+		if _, ok := byPair[p]; !ok {
+			t.Errorf("missing edge %d->%d", p.from, p.to)
+		}
+	}
+
+	e := byPair[pair{1, 3}]
+	if e.Weight != 400 {
+		t.Errorf("1->3 weight = %d, want 400", e.Weight)
+	}
+	// 100 ms at 50 km/h and 300 ms at 30 km/h: 14000 units over 400 ms.
+	if math.Abs(e.SpeedKmh-35) > 1e-9 {
+		t.Errorf("1->3 speed = %v, want 35", e.SpeedKmh)
+	}
+	if !slices.Equal(e.ShapeLats, []float64{9, 1.3}) || !slices.Equal(e.ShapeLons, []float64{9, 103.802}) {
+		t.Errorf("1->3 shape = %v/%v, want the old shape then node 2", e.ShapeLats, e.ShapeLons)
+	}
+	if back := byPair[pair{3, 1}]; !slices.Equal(back.ShapeLons, []float64{103.802}) {
+		t.Errorf("3->1 shape = %v, want node 2", back.ShapeLons)
+	}
+}
+
+func TestSimplifyChainsKeepsRing(t *testing.T) {
+	b := newCoordBuilder([]osm.NodeID{1, 2, 3})
+	for id := osm.NodeID(1); id <= 3; id++ {
+		b.set(id, 1.3, 103.8+float64(id)/1000)
+	}
+	// An isolated one-way ring must not collapse into a self-loop.
+	edges := []RawEdge{
+		{FromNodeID: 1, ToNodeID: 2, Weight: 1},
+		{FromNodeID: 2, ToNodeID: 3, Weight: 1},
+		{FromNodeID: 3, ToNodeID: 1, Weight: 1},
+	}
+	got, _ := simplifyChains(edges, func(osm.NodeID) bool { return false }, b.finish(), false)
+	for _, e := range got {
+		if e.FromNodeID == e.ToNodeID {
+			t.Errorf("self-loop at node %d", e.FromNodeID)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d edges, want 2", len(got))
+	}
+}
+
+func TestDedupeParallel(t *testing.T) {
+	edges := []RawEdge{
+		{FromNodeID: 1, ToNodeID: 2, Weight: 50},
+		{FromNodeID: 1, ToNodeID: 2, Weight: 30, Name: 7},
+		{FromNodeID: 2, ToNodeID: 1, Weight: 30},
+		// A cheaper restricted edge loses to the public one.
+		{FromNodeID: 2, ToNodeID: 3, Weight: 10, Restricted: true},
+		{FromNodeID: 2, ToNodeID: 3, Weight: 90},
+		// Among restricted edges the cheapest wins.
+		{FromNodeID: 3, ToNodeID: 4, Weight: 20, Restricted: true},
+		{FromNodeID: 3, ToNodeID: 4, Weight: 15, Restricted: true},
+	}
+	got, dropped := dedupeParallel(edges)
+	if dropped != 3 {
+		t.Errorf("dropped %d edges, want 3", dropped)
+	}
+	want := []RawEdge{
+		{FromNodeID: 1, ToNodeID: 2, Weight: 30, Name: 7},
+		{FromNodeID: 2, ToNodeID: 1, Weight: 30},
+		{FromNodeID: 2, ToNodeID: 3, Weight: 90},
+		{FromNodeID: 3, ToNodeID: 4, Weight: 15, Restricted: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d edges, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if g, w := got[i], want[i]; g.FromNodeID != w.FromNodeID || g.ToNodeID != w.ToNodeID ||
+			g.Weight != w.Weight || g.Restricted != w.Restricted || g.Name != w.Name {
+			t.Errorf("edge %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
@@ -21,6 +21,12 @@ import (
 // higher maxspeed tags (links cap at LinkFactor × the parent's cap). Motivation:
 // at-grade arterials tagged maxspeed=80 are not really faster than parallel
 // grade-separated expressways once signals/junctions are accounted for.
+// ClassPenalty is a per-class travel-time multiplier (>= 1) for classes the
+// profile may use but should avoid (links fall back to their parent's entry).
+//
+// Every profile has its own defaults (DefaultSpeedTableFor); the bike and
+// foot profiles read only ClassKmh, LinkFactor, Fallback and ClassPenalty,
+// since posted limits do not bind them.
 type SpeedTable struct {
 	ClassKmh       map[string]float64
 	ZoneKmh        map[string]float64 // maxspeed zone codes, e.g. "MY:urban"
@@ -29,6 +35,7 @@ type SpeedTable struct {
 	MaxspeedFactor float64
 	FloorClassKmh  map[string]float64
 	CapClassKmh    map[string]float64
+	ClassPenalty   map[string]float64
 }
 
 // DefaultSpeedTable returns the Malaysian-urban free-flow priors.
@@ -42,6 +49,14 @@ func DefaultSpeedTable() SpeedTable {
 		ZoneKmh: map[string]float64{
 			"MY:urban": 60, "MY:rural": 90, "MY:expressway": 110,
 			"RM:urban": 60, "RM:rural": 90,
+			// Implicit national limits for extracts that cross into other
+			// countries (posted values, per the OSM default speed limits).
+			"SG:urban": 50, "TH:urban": 80, "TH:rural": 90,
+			"ID:urban": 50, "ID:rural": 80,
+			"AU:urban": 50, "AU:rural": 100,
+			"DE:urban": 50, "DE:rural": 100,
+			"FR:urban": 50, "FR:rural": 80,
+			"GB:nsl_single": 60 * 1.609344, "GB:nsl_dual": 70 * 1.609344, "GB:motorway": 70 * 1.609344,
 		},
 		LinkFactor:     0.7,
 		Fallback:       30,
@@ -49,13 +64,52 @@ func DefaultSpeedTable() SpeedTable {
 	}
 }
 
-// ParseSpeedTable parses a JSON speed table, overlaying it on DefaultSpeedTable.
-// Omitted top-level fields keep their defaults. NOTE: class_kmh and zone_kmh,
-// when present, REPLACE the entire default map (not a per-key merge) — so a
+// DefaultSpeedTableFor returns the built-in table of a profile (see
+// Profile.Name): the Malaysian-urban priors for "car", the same with
+// residential streets discouraged for "hgv", and cruising speeds plus
+// arterial penalties for "bike" and "foot". Unknown names get the car table.
+func DefaultSpeedTableFor(profile string) SpeedTable {
+	switch profile {
+	case "hgv":
+		t := DefaultSpeedTable()
+		t.ClassPenalty = map[string]float64{"residential": 1.5, "living_street": 1.5}
+		return t
+	case "bike":
+		return SpeedTable{
+			ClassKmh: map[string]float64{
+				"cycleway": 18, "path": 12, "track": 12, "footway": 8, "pedestrian": 8, "bridleway": 8,
+			},
+			LinkFactor: 1,
+			Fallback:   bikeDefaultKmh,
+			// Only arterials without a cycleway; see BikeProfile.Penalty.
+			ClassPenalty: map[string]float64{"trunk": 2, "primary": 1.5, "secondary": 1.2},
+		}
+	case "foot":
+		return SpeedTable{
+			ClassKmh:   map[string]float64{"steps": footStepsKmh},
+			LinkFactor: 1,
+			Fallback:   footKmh,
+			// Only fast roads without a sidewalk; see FootProfile.Penalty.
+			ClassPenalty: map[string]float64{"trunk": 1.5, "primary": 1.2, "secondary": 1.2},
+		}
+	}
+	return DefaultSpeedTable()
+}
+
+// ParseSpeedTable parses a JSON speed table for the car profile; see
+// ParseSpeedTableFor.
+func ParseSpeedTable(data []byte) (SpeedTable, error) {
+	return ParseSpeedTableFor(data, "car")
+}
+
+// ParseSpeedTableFor parses a JSON speed table, overlaying it on the
+// profile's DefaultSpeedTableFor. Omitted top-level fields keep their
+// defaults. NOTE: the maps (class_kmh, zone_kmh, class_penalty, ...), when
+// present, REPLACE the entire default map (not a per-key merge) — so a
 // provided class_kmh must list every class you rely on. link_factor/fallback
 // override only when > 0.
-func ParseSpeedTable(data []byte) (SpeedTable, error) {
-	def := DefaultSpeedTable()
+func ParseSpeedTableFor(data []byte, profile string) (SpeedTable, error) {
+	def := DefaultSpeedTableFor(profile)
 	var raw struct {
 		ClassKmh       map[string]float64 `json:"class_kmh"`
 		ZoneKmh        map[string]float64 `json:"zone_kmh"`
@@ -64,6 +118,7 @@ func ParseSpeedTable(data []byte) (SpeedTable, error) {
 		MaxspeedFactor float64            `json:"maxspeed_factor"`
 		FloorClassKmh  map[string]float64 `json:"floor_class_kmh"`
 		CapClassKmh    map[string]float64 `json:"cap_class_kmh"`
+		ClassPenalty   map[string]float64 `json:"class_penalty"`
 	}
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return SpeedTable{}, err
@@ -89,16 +144,24 @@ func ParseSpeedTable(data []byte) (SpeedTable, error) {
 	if raw.CapClassKmh != nil {
 		def.CapClassKmh = raw.CapClassKmh
 	}
+	if raw.ClassPenalty != nil {
+		def.ClassPenalty = raw.ClassPenalty
+	}
 	return def, nil
 }
 
-// LoadSpeedTable reads a JSON speed table from path.
+// LoadSpeedTable reads a JSON speed table for the car profile from path.
 func LoadSpeedTable(path string) (SpeedTable, error) {
+	return LoadSpeedTableFor(path, "car")
+}
+
+// LoadSpeedTableFor reads a JSON speed table for a profile from path.
+func LoadSpeedTableFor(path, profile string) (SpeedTable, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return SpeedTable{}, err
 	}
-	return ParseSpeedTable(data)
+	return ParseSpeedTableFor(data, profile)
 }
 
 // classSpeed returns the base (non-link) speed for a highway class.
@@ -109,21 +172,83 @@ func (s SpeedTable) classSpeed(hw string) float64 {
 	return s.Fallback
 }
 
-// SpeedKmh resolves a way's free-flow speed: maxspeed when parseable, else the
-// class default (links = LinkFactor × parent class). A FloorClassKmh entry for
-// the way's base class sets a minimum effective speed (links floor at
-// LinkFactor × the parent's floor).
+// classKmh returns the table speed of a highway class, ignoring maxspeed: its
+// own entry, else LinkFactor × the parent class for links, else Fallback.
+func (s SpeedTable) classKmh(hw string) float64 {
+	if v, ok := s.ClassKmh[hw]; ok {
+		return v
+	}
+	if base, isLink := strings.CutSuffix(hw, "_link"); isLink {
+		return s.LinkFactor * s.classSpeed(base)
+	}
+	return s.Fallback
+}
+
+// classPenalty returns the ClassPenalty of a highway class (links fall back
+// to their parent's), or 1.
+func (s SpeedTable) classPenalty(hw string) float64 {
+	if v, ok := s.ClassPenalty[hw]; ok {
+		return max(v, 1)
+	}
+	if v, ok := s.ClassPenalty[strings.TrimSuffix(hw, "_link")]; ok {
+		return max(v, 1)
+	}
+	return 1
+}
+
+// SpeedKmh resolves a way's free-flow speed: maxspeed when parseable, else an
+// implicit limit named by maxspeed:type / source:maxspeed / zone:maxspeed
+// (e.g. "DE:rural", resolved through ZoneKmh), else the class default (links =
+// LinkFactor × parent class). A FloorClassKmh entry for the way's base class
+// sets a minimum effective speed (links floor at LinkFactor × the parent's
+// floor).
 func (s SpeedTable) SpeedKmh(t osm.Tags) float64 {
+	return s.speedKmh(t, t.Find("maxspeed"))
+}
+
+// DirectionalSpeedKmh resolves the speed in each direction of travel along
+// the way: maxspeed:forward / maxspeed:backward when present, otherwise the
+// same value as SpeedKmh.
+func (s SpeedTable) DirectionalSpeedKmh(t osm.Tags) (forward, backward float64) {
+	ms := t.Find("maxspeed")
+	fwd, bwd := ms, ms
+	if v := t.Find("maxspeed:forward"); v != "" {
+		fwd = v
+	}
+	if v := t.Find("maxspeed:backward"); v != "" {
+		bwd = v
+	}
+	forward = s.speedKmh(t, fwd)
+	if bwd == fwd {
+		return forward, forward
+	}
+	return forward, s.speedKmh(t, bwd)
+}
+
+// implicitMaxspeedKeys name a zone code when the posted limit is implicit.
+var implicitMaxspeedKeys = []string{"maxspeed:type", "source:maxspeed", "zone:maxspeed"}
+
+func (s SpeedTable) speedKmh(t osm.Tags, maxspeed string) float64 {
 	hw := t.Find("highway")
 	isLink := strings.HasSuffix(hw, "_link")
 	base := strings.TrimSuffix(hw, "_link")
 
 	v := -1.0
-	if ms := strings.TrimSpace(t.Find("maxspeed")); ms != "" {
+	if ms := strings.TrimSpace(maxspeed); ms != "" {
 		if p, ok := s.parseMaxspeed(ms); ok {
 			v = p
 		}
 	}
+	for _, k := range implicitMaxspeedKeys {
+		if v >= 0 {
+			break
+		}
+		if zone := strings.TrimSpace(t.Find(k)); zone != "" {
+			if p, ok := s.parseMaxspeed(zone); ok {
+				v = p
+			}
+		}
+	}
 	if v < 0 {
 		if isLink {
 			v = s.LinkFactor * s.classSpeed(base)
@@ -152,15 +277,21 @@ func (s SpeedTable) SpeedKmh(t osm.Tags) float64 {
 	return v
 }
 
-// parseMaxspeed handles "60", "30 mph", and zone codes; returns ok=false for
-// "none"/"walk"/conditional/per-direction/garbage so the caller falls back.
-// Numeric values (posted limits) are scaled by MaxspeedFactor to approximate
-// typical driven speeds; zone codes are already "typical" values and pass
-// through unscaled.
+// parseMaxspeed handles "60", "30 mph", "30mph", zone codes and numbered
+// zones ("DE:zone30", "DE:zone:30"); returns ok=false for "none"/"walk"/
+// conditional/garbage so the caller falls back. Numeric values (posted limits,
+// numbered zones) are scaled by MaxspeedFactor to approximate typical driven
+// speeds; zone codes are already "typical" values and pass through unscaled.
 func (s SpeedTable) parseMaxspeed(ms string) (float64, bool) {
 	if v, ok := s.ZoneKmh[ms]; ok {
 		return v, true
 	}
+	if _, zone, ok := strings.Cut(ms, ":zone"); ok {
+		ms = strings.TrimPrefix(zone, ":")
+	}
+	if num, ok := strings.CutSuffix(ms, "mph"); ok && !strings.HasSuffix(num, " ") {
+		ms = num + " mph"
+	}
 	fields := strings.Fields(ms)
 	if len(fields) == 0 {
 		return 0, false
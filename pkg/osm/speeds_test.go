@@ -36,6 +36,13 @@ func TestSpeedKmh(t *testing.T) {
 		{"link maxspeed wins over derivation", tags("highway", "motorway_link", "maxspeed", "80"), 80},
 		{"kmh unit", tags("highway", "primary", "maxspeed", "50 km/h"), 50},
 		{"unknown unit falls back", tags("highway", "primary", "maxspeed", "50 knots"), 55},
+		{"mph without space", tags("highway", "primary", "maxspeed", "30mph"), 30 * 1.609344},
+		{"numbered zone", tags("highway", "residential", "maxspeed", "DE:zone30"), 30},
+		{"numbered zone with colon", tags("highway", "residential", "maxspeed", "DE:zone:30"), 30},
+		{"implicit via maxspeed:type", tags("highway", "secondary", "maxspeed:type", "DE:rural"), 100},
+		{"implicit via source:maxspeed", tags("highway", "secondary", "source:maxspeed", "MY:urban"), 60},
+		{"explicit maxspeed beats implicit", tags("highway", "secondary", "maxspeed", "70", "source:maxspeed", "MY:urban"), 70},
+		{"unknown implicit zone falls back", tags("highway", "secondary", "maxspeed:type", "XX:urban"), 45},
 	}
 	for _, c := range cases {
 		got := tbl.SpeedKmh(c.tags)
@@ -111,3 +118,54 @@ func TestFloorAndCapClassKmh(t *testing.T) {
 		t.Errorf("residential = %v, want default", v)
 	}
 }
+
+func TestDirectionalSpeedKmh(t *testing.T) {
+	tbl := DefaultSpeedTable()
+	fwd, bwd := tbl.DirectionalSpeedKmh(tags("highway", "primary", "maxspeed", "60", "maxspeed:backward", "40"))
+	if fwd != 60 || bwd != 40 {
+		t.Errorf("DirectionalSpeedKmh = %v, %v; want 60, 40", fwd, bwd)
+	}
+	fwd, bwd = tbl.DirectionalSpeedKmh(tags("highway", "primary", "maxspeed:forward", "70"))
+	if fwd != 70 || bwd != 55 {
+		t.Errorf("forward-only = %v, %v; want 70, 55 (class default)", fwd, bwd)
+	}
+	fwd, bwd = tbl.DirectionalSpeedKmh(tags("highway", "primary", "maxspeed", "50"))
+	if fwd != 50 || bwd != 50 {
+		t.Errorf("undirected = %v, %v; want 50, 50", fwd, bwd)
+	}
+}
+
+func TestSpeedTableForProfile(t *testing.T) {
+	// A bike table overlays the bike defaults, not the car ones.
+	tbl, err := ParseSpeedTableFor([]byte(`{"class_kmh":{"cycleway":22},"class_penalty":{"trunk":4}}`), "bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Fallback != bikeDefaultKmh {
+		t.Errorf("bike fallback = %v, want %d", tbl.Fallback, bikeDefaultKmh)
+	}
+	bike := BikeProfile{Speeds: tbl}
+	if fwd, _ := bike.Speed(tags("highway", "cycleway")); fwd != 22 {
+		t.Errorf("tuned cycleway speed = %v, want 22", fwd)
+	}
+	if p := bike.Penalty(tags("highway", "trunk_link")); p != 4 {
+		t.Errorf("tuned trunk_link penalty = %v, want the trunk 4", p)
+	}
+	if p := bike.Penalty(tags("highway", "primary")); p != 1 {
+		t.Errorf("primary penalty = %v, want 1 once class_penalty is replaced", p)
+	}
+
+	car, err := ParseSpeedTable([]byte(`{"class_penalty":{"living_street":3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := (CarProfile{Speeds: car}).Penalty(tags("highway", "living_street")); p != 3 {
+		t.Errorf("car living_street penalty = %v, want 3", p)
+	}
+	if p := (CarProfile{}).Penalty(tags("highway", "living_street")); p != 1 {
+		t.Errorf("default car living_street penalty = %v, want 1", p)
+	}
+	if p := (HGVProfile{}).Penalty(tags("highway", "residential")); p != 1.5 {
+		t.Errorf("default hgv residential penalty = %v, want 1.5", p)
+	}
+}
@@ -0,0 +1,64 @@
+package osm
+
+import "github.com/paulmach/osm"
+
+// WayFlags are boolean properties of a way that routes are filtered and
+// annotated by. Roundabouts and restricted access have their own RawEdge
+// fields.
+type WayFlags uint8
+
+const (
+	WayOneway  WayFlags = 1 << iota // traversable in one direction only
+	WayBridge                       // bridge=* other than no
+	WayTunnel                       // tunnel=* other than no (including building passages)
+	WayToll                         // toll=yes
+	WayFerry                        // route=ferry
+	WayUnpaved                      // unpaved surface, tagged or implied by the class
+)
+
+// Has reports whether every flag in f2 is set.
+func (f WayFlags) Has(f2 WayFlags) bool { return f&f2 == f2 }
+
+// unpavedSurfaces are the surface values of unpaved roads. Anything else that
+// is tagged counts as paved.
+var unpavedSurfaces = map[string]bool{
+	"unpaved": true, "compacted": true, "fine_gravel": true, "gravel": true,
+	"pebblestone": true, "rock": true, "ground": true, "dirt": true,
+	"earth": true, "grass": true, "grass_paver": true, "mud": true,
+	"sand": true, "woodchips": true, "snow": true, "ice": true, "salt": true,
+}
+
+// wayFlags derives the flags of a way from its tags; fwd and bwd are the
+// directions it is routable in.
+func wayFlags(tags osm.Tags, fwd, bwd bool) WayFlags {
+	var f WayFlags
+	if fwd != bwd {
+		f |= WayOneway
+	}
+	if v := tags.Find("bridge"); v != "" && v != "no" {
+		f |= WayBridge
+	}
+	if v := tags.Find("tunnel"); v != "" && v != "no" {
+		f |= WayTunnel
+	}
+	if tags.Find("toll") == "yes" {
+		f |= WayToll
+	}
+	if tags.Find("route") == "ferry" {
+		f |= WayFerry
+	}
+	if isUnpaved(tags) {
+		f |= WayUnpaved
+	}
+	return f
+}
+
+// isUnpaved reports whether a way has an unpaved surface. Without a surface
+// tag, tracks are unpaved unless tracktype=grade1, and every other class is
+// taken to be paved.
+func isUnpaved(tags osm.Tags) bool {
+	if s := tags.Find("surface"); s != "" {
+		return unpavedSurfaces[s]
+	}
+	return tags.Find("highway") == "track" && tags.Find("tracktype") != "grade1"
+}
@@ -0,0 +1,40 @@
+package osm
+
+import (
+	"testing"
+
+	"github.com/paulmach/osm"
+)
+
+func TestWayFlags(t *testing.T) {
+	tags := func(kv ...string) osm.Tags {
+		var ts osm.Tags
+		for i := 0; i < len(kv); i += 2 {
+			ts = append(ts, osm.Tag{Key: kv[i], Value: kv[i+1]})
+		}
+		return ts
+	}
+	cases := []struct {
+		name     string
+		tags     osm.Tags
+		fwd, bwd bool
+		want     WayFlags
+	}{
+		{"plain", tags("highway", "residential"), true, true, 0},
+		{"oneway", tags("highway", "residential"), true, false, WayOneway},
+		{"reverse oneway", tags("highway", "residential"), false, true, WayOneway},
+		{"viaduct", tags("highway", "motorway", "bridge", "viaduct", "toll", "yes"), true, false, WayOneway | WayBridge | WayToll},
+		{"not a bridge", tags("highway", "primary", "bridge", "no", "tunnel", "no", "toll", "no"), true, true, 0},
+		{"building passage", tags("highway", "service", "tunnel", "building_passage"), true, true, WayTunnel},
+		{"ferry", tags("route", "ferry"), true, true, WayFerry},
+		{"gravel", tags("highway", "tertiary", "surface", "gravel"), true, true, WayUnpaved},
+		{"asphalt track", tags("highway", "track", "surface", "asphalt"), true, true, 0},
+		{"track", tags("highway", "track"), true, true, WayUnpaved},
+		{"grade1 track", tags("highway", "track", "tracktype", "grade1"), true, true, 0},
+	}
+	for _, c := range cases {
+		if got := wayFlags(c.tags, c.fwd, c.bwd); got != c.want {
+			t.Errorf("%s: wayFlags = %08b, want %08b", c.name, got, c.want)
+		}
+	}
+}
@@ -46,6 +46,33 @@ func buildTestGraphAndCH(t *testing.T) (*graph.Graph, *graph.CHGraph) {
 	return g, chg
 }
 
+// buildGridGraph builds a side×side grid of two-way streets whose weights
+// vary from 100 to 300 in a fixed pattern.
+func buildGridGraph(t *testing.T, side uint32) *graph.Graph {
+	t.Helper()
+	b := graph.NewBuilder()
+	for r := range side {
+		for c := range side {
+			b.AddNode(1+float64(r)*0.001, 103+float64(c)*0.001)
+		}
+	}
+	for u := range side * side {
+		for _, v := range []uint32{u + 1, u + side} {
+			if (v == u+1 && v%side == 0) || v >= side*side {
+				continue
+			}
+			w := 100 + (u*7+v*13)%5*50
+			if _, err := b.AddEdge(u, v, w); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.AddEdge(v, u, w); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return b.Freeze()
+}
+
 // plainDijkstra runs standard Dijkstra on the original graph.
 func plainDijkstra(g *graph.Graph, source, target uint32) uint32 {
 	dist := make([]uint32, g.NumNodes)
@@ -119,6 +146,32 @@ func TestCHDijkstraCorrectness(t *testing.T) {
 	}
 }
 
+// TestCHDijkstraCore checks queries through the uncontracted core that a
+// contraction stopped early leaves at the top of the hierarchy.
+func TestCHDijkstraCore(t *testing.T) {
+	g := buildGridGraph(t, 12)
+	chg := ch.ContractWith(g, ch.Options{MaxShortcutsPerNode: 3})
+	eng := &Engine{chg: chg}
+	for s := uint32(0); s < g.NumNodes; s += 5 {
+		for d := uint32(0); d < g.NumNodes; d += 7 {
+			qs := NewQueryState(chg.NumNodes)
+			qs.touchFwd(s, 0)
+			qs.FwdPQ.Push(s, 0)
+			qs.touchBwd(d, 0)
+			qs.BwdPQ.Push(d, 0)
+			mu, meet := eng.runCHDijkstra(context.Background(), qs)
+			if expected := plainDijkstra(g, s, d); mu != expected {
+				t.Errorf("s=%d d=%d: CH=%d, Dijkstra=%d", s, d, mu, expected)
+				continue
+			}
+			path := unpackOverlayPath(chg, newUnpackCache(chg, 1000), eng.reconstructOverlayPath(meet, qs.PredFwd, qs.PredBwd))
+			if cost := pathCostInOriginalGraph(t, g, path); cost != mu {
+				t.Errorf("s=%d d=%d: unpacked path costs %d, want %d", s, d, cost, mu)
+			}
+		}
+	}
+}
+
 func TestMinHeap(t *testing.T) {
 	var h MinHeap
 
@@ -6,6 +6,8 @@ import (
 	"math"
 	"sync"
 
+	"github.com/paulmach/osm"
+
 	"github.com/azybler/map_router/pkg/geo"
 	"github.com/azybler/map_router/pkg/graph"
 )
@@ -40,8 +42,7 @@ func (e *Engine) snapWithFallback(lat, lng float64) []SnapResult {
 // units using the candidate edge's own weight/length ratio, so it auto-scales
 // whether the metric is distance (mm) or time (ms).
 func accessPenalty(g *graph.Graph, snap SnapResult) uint32 {
-	u, v := snap.NodeU, snap.NodeV
-	lenM := geo.Haversine(g.NodeLat[u], g.NodeLon[u], g.NodeLat[v], g.NodeLon[v])
+	lenM := snapLine(g, snap).length()
 	if lenM <= 0 {
 		return 0
 	}
@@ -50,10 +51,7 @@ func accessPenalty(g *graph.Graph, snap SnapResult) uint32 {
 }
 
 // LatLng represents a geographic coordinate.
-type LatLng struct {
-	Lat float64
-	Lng float64
-}
+type LatLng = graph.LatLng
 
 // Segment represents a road segment in the route result.
 type Segment struct {
@@ -61,11 +59,24 @@ type Segment struct {
 	Geometry       []LatLng
 }
 
+// RouteEdge is one road edge a route travels along, with the attributes
+// turn instructions and annotations are built from.
+type RouteEdge struct {
+	Edge  uint32 // index into the original graph
+	Name  graph.RoadName
+	Attr  graph.EdgeAttr
+	WayID osm.WayID // OSM way the edge was built from; 0 when the graph carries no OSM IDs
+}
+
 // RouteResult is the output of a route query.
 type RouteResult struct {
 	TotalDistanceMeters float64
 	DurationSeconds     float64 // internal: mu/1000; may include access-penalty time; NOT exposed via API in Phase 1
 	Segments            []Segment
+	// Edges lists the edges travelled in order, including the partly
+	// travelled ones the route starts and ends on. nil when the graph has no
+	// attributes section. Internal: NOT exposed via API yet.
+	Edges []RouteEdge
 }
 
 // Router is the interface for route queries.
@@ -78,11 +89,14 @@ type Engine struct {
 	chg       *graph.CHGraph
 	origGraph *graph.Graph // for geometry and snap
 	snapper   *Snapper
+	unpack    *unpackCache
 	qsPool    sync.Pool
 }
 
 // NewEngine creates a routing engine from a CH graph and the original graph,
-// building a Snapper over origGraph.
+// building a Snapper over origGraph. Both constructors unpack the shortcuts
+// at the top of the hierarchy up front (up to 16 MiB of them), so that long
+// routes look them up instead of unpacking them on every query.
 func NewEngine(chg *graph.CHGraph, origGraph *graph.Graph) *Engine {
 	return NewEngineWithSnapper(chg, origGraph, NewSnapper(origGraph))
 }
@@ -101,6 +115,7 @@ func NewEngineWithSnapper(chg *graph.CHGraph, origGraph *graph.Graph, snapper *S
 		chg:       chg,
 		origGraph: origGraph,
 		snapper:   snapper,
+		unpack:    newUnpackCache(chg, defaultUnpackCacheNodes),
 	}
 	e.qsPool.New = func() any {
 		return NewQueryState(chg.NumNodes)
@@ -108,6 +123,13 @@ func NewEngineWithSnapper(chg *graph.CHGraph, origGraph *graph.Graph, snapper *S
 	return e
 }
 
+// SetSnapPolicy makes the engine snap under p (see SnapPolicy), on its own
+// copy of the Snapper, which may be shared. It must be called before the
+// engine serves queries.
+func (e *Engine) SetSnapPolicy(p SnapPolicy) {
+	e.snapper = e.snapper.WithPolicy(p)
+}
+
 // SnapCandidates returns up to k distinct road candidates within radiusMeters of
 // the given point, nearest first, snapped against this engine's own graph.
 //
@@ -121,6 +143,26 @@ func (e *Engine) SnapCandidates(lat, lng float64, k int, radiusMeters float64) [
 	return e.snapper.SnapCandidates(lat, lng, k, radiusMeters)
 }
 
+// SnapDirected snaps a point approached with the given heading onto a
+// directed edge of this engine's graph (see Snapper.SnapDirected), for
+// RouteBetweenSnaps.
+func (e *Engine) SnapDirected(lat, lng, heading float64) (SnapResult, error) {
+	return e.snapper.SnapDirected(lat, lng, heading)
+}
+
+// SnapHeading snaps a point onto the directed edge of this engine's graph
+// that best suits travel at heading (see Snapper.SnapHeading), for
+// RouteBetweenSnaps.
+func (e *Engine) SnapHeading(lat, lng, heading, tolerance float64) (SnapResult, error) {
+	return e.snapper.SnapHeading(lat, lng, heading, tolerance)
+}
+
+// SnapBatch snaps many points onto this engine's graph at once (see
+// Snapper.SnapBatch), for matrix and isochrone requests.
+func (e *Engine) SnapBatch(points []LatLng) ([]SnapResult, []error) {
+	return e.snapper.SnapBatch(points)
+}
+
 // SnapPoint returns the geographic position of a snap result produced by this
 // engine's SnapCandidates. Resolving a SnapResult against any other graph risks
 // reading a different road's coordinates — see SnapCandidates.
@@ -165,18 +207,23 @@ func (e *Engine) Route(ctx context.Context, start, end LatLng) (*RouteResult, er
 	overlayNodes := e.reconstructOverlayPath(meetNode, qs.PredFwd, qs.PredBwd)
 
 	// Step 4: Unpack shortcuts into original node sequence.
-	origNodes := unpackOverlayPath(e.chg, overlayNodes)
+	origNodes := unpackOverlayPath(e.chg, e.unpack, overlayNodes)
 
 	// Step 5: Build geometry, anchored at the actual snapped points so the
 	// partial first/last edges are included. Distance is measured from the
 	// geometry (NOT from mu), which decouples it from the routing metric.
 	geometry := e.buildGeometry(origNodes)
+	startEdge, endEdge := noNode, noNode
 	if len(origNodes) > 0 {
-		if lat, lng, ok := snapPointForCandidates(e.origGraph, startCands, origNodes[0]); ok {
-			geometry = append([]LatLng{{Lat: lat, Lng: lng}}, geometry...)
+		if s, ok := candidateForNode(startCands, origNodes[0]); ok {
+			leg, _ := legToNode(e.origGraph, s, origNodes[0])
+			geometry = append(leg[:len(leg)-1], geometry...)
+			startEdge = s.EdgeIdx
 		}
-		if lat, lng, ok := snapPointForCandidates(e.origGraph, endCands, origNodes[len(origNodes)-1]); ok {
-			geometry = append(geometry, LatLng{Lat: lat, Lng: lng})
+		if s, ok := candidateForNode(endCands, origNodes[len(origNodes)-1]); ok {
+			leg, _ := legFromNode(e.origGraph, origNodes[len(origNodes)-1], s)
+			geometry = append(geometry, leg[1:]...)
+			endEdge = s.EdgeIdx
 		}
 	}
 	totalDistMeters := polylineLengthMeters(geometry)
@@ -190,6 +237,7 @@ func (e *Engine) Route(ctx context.Context, start, end LatLng) (*RouteResult, er
 				Geometry:       geometry,
 			},
 		},
+		Edges: e.routeEdges(startEdge, origNodes, endEdge),
 	}, nil
 }
 
@@ -216,12 +264,12 @@ func (e *Engine) RouteBetweenSnaps(ctx context.Context, start, end SnapResult) (
 		return nil, ErrPointTooFar
 	}
 
-	// Both positions on one segment: travel is a straight run along the chord,
-	// and a graph search cannot express it. The search can only leave an edge via
+	// Both positions on one segment: travel is a run along the edge between
+	// them, and a graph search cannot express it. The search can only leave an edge via
 	// an endpoint, so it would route out to a node and back — or, on a one-way,
 	// all the way around the block — reporting hundreds of metres for a few
 	// metres of travel.
-	if endRatio, ok := sameSegment(start, end); ok {
+	if endRatio, ok := sameSegment(g, start, end); ok {
 		if res, ok := e.routeAlongEdge(start, end, endRatio); ok {
 			return res, nil
 		}
@@ -243,20 +291,21 @@ func (e *Engine) RouteBetweenSnaps(ctx context.Context, start, end SnapResult) (
 		return nil, ErrNoRoute
 	}
 
-	origNodes := unpackOverlayPath(e.chg, e.reconstructOverlayPath(meetNode, qs.PredFwd, qs.PredBwd))
+	origNodes := unpackOverlayPath(e.chg, e.unpack, e.reconstructOverlayPath(meetNode, qs.PredFwd, qs.PredBwd))
 
 	// Anchor the geometry at exactly the positions asked about, so the reported
 	// distance covers the partial first and last edges and nothing else. Unlike
 	// Route, there is no candidate set to choose an anchor from — the caller
 	// named both endpoints, so they are used verbatim.
 	geometry := e.buildGeometry(origNodes)
-	sLat, sLng := snapLatLng(g, start)
-	eLat, eLng := snapLatLng(g, end)
-	if len(geometry) == 0 || geometry[0].Lat != sLat || geometry[0].Lng != sLng {
-		geometry = append([]LatLng{{Lat: sLat, Lng: sLng}}, geometry...)
+	startEdge, endEdge := noNode, noNode
+	if leg, ok := legToNode(g, start, origNodes[0]); ok {
+		geometry = append(leg[:len(leg)-1], geometry...)
+		startEdge = start.EdgeIdx
 	}
-	if last := geometry[len(geometry)-1]; last.Lat != eLat || last.Lng != eLng {
-		geometry = append(geometry, LatLng{Lat: eLat, Lng: eLng})
+	if leg, ok := legFromNode(g, origNodes[len(origNodes)-1], end); ok {
+		geometry = append(geometry, leg[1:]...)
+		endEdge = end.EdgeIdx
 	}
 	totalDistMeters := polylineLengthMeters(geometry)
 
@@ -269,6 +318,7 @@ func (e *Engine) RouteBetweenSnaps(ctx context.Context, start, end SnapResult) (
 				Geometry:       geometry,
 			},
 		},
+		Edges: e.routeEdges(startEdge, origNodes, endEdge),
 	}, nil
 }
 
@@ -282,12 +332,14 @@ func (e *Engine) RouteBetweenSnaps(ctx context.Context, start, end SnapResult) (
 // point receives is not guaranteed to be consistent between nearby points.
 // Missing the twin case sends a few metres of travel into the graph search,
 // which can only leave via an endpoint and so reports the whole way round.
-func sameSegment(start, end SnapResult) (endRatio float64, ok bool) {
+// Matching node pairs is too loose: two different roads may join the same
+// junctions, so twins must also share their shape.
+func sameSegment(g *graph.Graph, start, end SnapResult) (endRatio float64, ok bool) {
 	switch {
 	case start.EdgeIdx == end.EdgeIdx:
 		return end.Ratio, true
-	case start.NodeU == end.NodeV && start.NodeV == end.NodeU:
-		// Opposite twin: the same chord measured from the other end.
+	case snapLine(g, start).reverseOf(snapLine(g, end)):
+		// Opposite twin: the same polyline measured from the other end.
 		return 1 - end.Ratio, true
 	}
 	return 0, false
@@ -295,16 +347,20 @@ func sameSegment(start, end SnapResult) (endRatio float64, ok bool) {
 
 // routeAlongEdge handles two snaps sharing one segment, with endRatio expressed
 // along start's edge (see sameSegment). Returns ok=false when travel would run
-// against a one-way, leaving the caller to search for a legal route around.
+// against a one-way, or against the direction of a Directed snap, leaving the
+// caller to search for a legal route around.
 func (e *Engine) routeAlongEdge(start, end SnapResult, endRatio float64) (*RouteResult, bool) {
 	g := e.origGraph
-	if endRatio < start.Ratio && findEdge(g.FirstOut, g.Head, start.NodeV, start.NodeU) == noNode {
+	forward := endRatio >= start.Ratio
+	if !forward && (start.Directed || reverseEdge(g, start) == noNode) {
+		return nil, false
+	}
+	// A Directed end is reached along its own edge: start's, or its twin.
+	if end.Directed && forward != (end.EdgeIdx == start.EdgeIdx) {
 		return nil, false
 	}
 
-	sLat, sLng := snapLatLng(g, start)
-	eLat, eLng := snapLatLng(g, end)
-	geometry := []LatLng{{Lat: sLat, Lng: sLng}, {Lat: eLat, Lng: eLng}}
+	geometry := snapLine(g, start).between(start.Ratio, endRatio)
 	totalDistMeters := polylineLengthMeters(geometry)
 	mu := uint32(math.Round(float64(g.Weight[start.EdgeIdx]) * math.Abs(endRatio-start.Ratio)))
 
@@ -317,6 +373,7 @@ func (e *Engine) routeAlongEdge(start, end SnapResult, endRatio float64) (*Route
 				Geometry:       geometry,
 			},
 		},
+		Edges: e.routeEdges(start.EdgeIdx, nil, noNode),
 	}, true
 }
 
@@ -354,6 +411,29 @@ func (e *Engine) reconstructOverlayPath(meetNode uint32, predFwd, predBwd []uint
 	return fwdPath
 }
 
+// routeEdges lists the original edges between consecutive nodes, preceded by
+// startEdge and followed by endEdge (the snapped edges a route starts and ends
+// part-way along; noNode when there is none). nil when the graph carries no
+// attributes.
+func (e *Engine) routeEdges(startEdge uint32, nodes []uint32, endEdge uint32) []RouteEdge {
+	g := e.origGraph
+	if g.EdgeAttr == nil {
+		return nil
+	}
+	edges := make([]RouteEdge, 0, len(nodes)+1)
+	add := func(idx uint32) {
+		if idx != noNode {
+			edges = append(edges, RouteEdge{Edge: idx, Name: g.EdgeRoadName(idx), Attr: g.EdgeAttr[idx], WayID: g.EdgeWay(idx)})
+		}
+	}
+	add(startEdge)
+	for i := 0; i+1 < len(nodes); i++ {
+		add(cheapestEdge(g, nodes[i], nodes[i+1]))
+	}
+	add(endEdge)
+	return edges
+}
+
 // buildGeometry converts a sequence of original graph node IDs into lat/lng
 // coordinates, including intermediate shape points from edge geometry.
 func (e *Engine) buildGeometry(nodes []uint32) []LatLng {
@@ -366,7 +446,7 @@ func (e *Engine) buildGeometry(nodes []uint32) []LatLng {
 	geom := make([]LatLng, 0, len(nodes)*2)
 
 	// Add first node.
-	geom = append(geom, LatLng{Lat: g.NodeLat[nodes[0]], Lng: g.NodeLon[nodes[0]]})
+	geom = append(geom, nodeLatLng(g, nodes[0]))
 
 	for i := 0; i < len(nodes)-1; i++ {
 		u := nodes[i]
@@ -374,35 +454,35 @@ func (e *Engine) buildGeometry(nodes []uint32) []LatLng {
 
 		// Look up edge u→v in original graph for intermediate shape points.
 		if g.GeoFirstOut != nil {
-			edgeIdx := findEdge(g.FirstOut, g.Head, u, v)
+			edgeIdx := cheapestEdge(g, u, v)
 			if edgeIdx != noNode && edgeIdx < uint32(len(g.GeoFirstOut)-1) {
 				geoStart := g.GeoFirstOut[edgeIdx]
 				geoEnd := g.GeoFirstOut[edgeIdx+1]
 				for k := geoStart; k < geoEnd; k++ {
 					geom = append(geom, LatLng{
-						Lat: g.GeoShapeLat[k],
-						Lng: g.GeoShapeLon[k],
+						Lat: g.GeoShapeLat[k].Deg(),
+						Lng: g.GeoShapeLon[k].Deg(),
 					})
 				}
 			}
 		}
 
 		// Add target node coordinates.
-		geom = append(geom, LatLng{Lat: g.NodeLat[v], Lng: g.NodeLon[v]})
+		geom = append(geom, nodeLatLng(g, v))
 	}
 
 	return geom
 }
 
-// snapPointForCandidates returns the snap point of the nearest candidate that
-// has `node` as an endpoint (i.e. the candidate that could have seeded it).
+// candidateForNode returns the nearest candidate that has `node` as an
+// endpoint (i.e. the candidate that could have seeded it).
 //
 // When several candidates share `node`, we anchor to the one with the smallest
 // off-road distance — the closest road to the requested point, which is the
 // correct visual start. (Seed cost = partial-edge + access penalty, and the
 // penalty is proportional to off-road distance, so min-distance ≈ min-seed-cost;
 // any residual difference is bounded because all such candidates meet at `node`.)
-func snapPointForCandidates(g *graph.Graph, cands []SnapResult, node uint32) (lat, lng float64, ok bool) {
+func candidateForNode(cands []SnapResult, node uint32) (SnapResult, bool) {
 	best := -1
 	for i := range cands {
 		if cands[i].NodeU == node || cands[i].NodeV == node {
@@ -412,21 +492,16 @@ func snapPointForCandidates(g *graph.Graph, cands []SnapResult, node uint32) (la
 		}
 	}
 	if best < 0 {
-		return 0, 0, false
+		return SnapResult{}, false
 	}
-	lat, lng = snapLatLng(g, cands[best])
-	return lat, lng, true
+	return cands[best], true
 }
 
 // snapLatLng returns the position of a snap result, interpolated along its
-// edge's chord.
-//
-// Linear interpolation is exact here: the OSM parser emits one edge per
-// consecutive node pair and never populates intermediate shape points, and
-// SnapCandidates measures Ratio against that same u→v chord.
+// edge's polyline (Ratio is a fraction of the polyline's length, as measured
+// by SnapCandidates).
 func snapLatLng(g *graph.Graph, s SnapResult) (lat, lng float64) {
-	lat = g.NodeLat[s.NodeU] + s.Ratio*(g.NodeLat[s.NodeV]-g.NodeLat[s.NodeU])
-	lng = g.NodeLon[s.NodeU] + s.Ratio*(g.NodeLon[s.NodeV]-g.NodeLon[s.NodeU])
+	lat, lng, _ = snapLine(g, s).locate(s.Ratio)
 	return lat, lng
 }
 
@@ -441,7 +516,8 @@ func polylineLengthMeters(geom []LatLng) float64 {
 
 // seedForward seeds the forward PQ from the start snap point, respecting edge
 // direction: travel forward to v is always legal (edge u→v exists); travel
-// backward to u is legal only if the reverse edge v→u exists.
+// backward to u is legal only if the reverse edge v→u exists and the snap is
+// not Directed.
 func seedForward(qs *QueryState, g *graph.Graph, snap SnapResult) {
 	seedForwardPenalty(qs, g, snap, accessPenalty(g, snap))
 }
@@ -453,14 +529,14 @@ func seedForwardPenalty(qs *QueryState, g *graph.Graph, snap SnapResult, pen uin
 	weight := g.Weight[snap.EdgeIdx]
 
 	qs.seedFwdMin(v, uint32(math.Round(float64(weight)*(1-snap.Ratio)))+pen)
-	if findEdge(g.FirstOut, g.Head, v, u) != noNode {
+	if !snap.Directed && reverseEdge(g, snap) != noNode {
 		qs.seedFwdMin(u, uint32(math.Round(float64(weight)*snap.Ratio))+pen)
 	}
 }
 
 // seedBackward seeds the backward PQ from the end snap point. Arriving from u
 // (travel u→v, stop at the point) is always legal; arriving from v requires the
-// reverse edge v→u to exist.
+// reverse edge v→u to exist and the snap not to be Directed.
 func seedBackward(qs *QueryState, g *graph.Graph, snap SnapResult) {
 	seedBackwardPenalty(qs, g, snap, accessPenalty(g, snap))
 }
@@ -471,7 +547,7 @@ func seedBackwardPenalty(qs *QueryState, g *graph.Graph, snap SnapResult, pen ui
 	weight := g.Weight[snap.EdgeIdx]
 
 	qs.seedBwdMin(u, uint32(math.Round(float64(weight)*snap.Ratio))+pen)
-	if findEdge(g.FirstOut, g.Head, v, u) != noNode {
+	if !snap.Directed && reverseEdge(g, snap) != noNode {
 		qs.seedBwdMin(v, uint32(math.Round(float64(weight)*(1-snap.Ratio)))+pen)
 	}
 }
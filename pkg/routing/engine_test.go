@@ -2,6 +2,7 @@ package routing
 
 import (
 	"math"
+	"strings"
 	"testing"
 
 	"github.com/paulmach/osm"
@@ -28,7 +29,7 @@ func oneWayParse() *osmparser.ParseResult {
 // nodeIndex finds the compact index whose coords match the given lat/lon.
 func nodeIndex(g *graph.Graph, lat, lon float64) uint32 {
 	for i := uint32(0); i < g.NumNodes; i++ {
-		if g.NodeLat[i] == lat && g.NodeLon[i] == lon {
+		if g.NodeLat[i].Deg() == lat && g.NodeLon[i].Deg() == lon {
 			return i
 		}
 	}
@@ -270,3 +271,41 @@ func TestDurationSecondsPopulated(t *testing.T) {
 		t.Errorf("DurationSeconds = %f, want > 0", res.DurationSeconds)
 	}
 }
+
+func TestRouteListsEdgeAttributes(t *testing.T) {
+	// A-B-C-D as above, A-B and B-C named "Jalan Satu", C-D a primary road.
+	pr := &osmparser.ParseResult{
+		Names:   []osmparser.RoadName{{}, {Name: "Jalan Satu"}, {Name: "Jalan Dua"}},
+		NodeLat: map[osm.NodeID]float64{100: 1.30000, 200: 1.30090, 300: 1.30180, 400: 1.30270},
+		NodeLon: map[osm.NodeID]float64{100: 103.8, 200: 103.8, 300: 103.8, 400: 103.8},
+	}
+	for _, e := range []struct {
+		a, b  osm.NodeID
+		name  uint32
+		class osmparser.RoadClass
+	}{
+		{100, 200, 1, osmparser.ClassResidential},
+		{200, 300, 1, osmparser.ClassResidential},
+		{300, 400, 2, osmparser.ClassPrimary},
+	} {
+		pr.Edges = append(pr.Edges,
+			osmparser.RawEdge{FromNodeID: e.a, ToNodeID: e.b, Weight: 100, Name: e.name, Class: e.class, SpeedKmh: 30},
+			osmparser.RawEdge{FromNodeID: e.b, ToNodeID: e.a, Weight: 100, Name: e.name, Class: e.class, SpeedKmh: 30})
+	}
+	g := graph.Build(pr)
+	eng := NewEngine(chContract(t, g), g)
+
+	res, err := eng.Route(t.Context(),
+		LatLng{Lat: 1.30045, Lng: 103.8}, LatLng{Lat: 1.30225, Lng: 103.8})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	var got []string
+	for _, e := range res.Edges {
+		got = append(got, e.Name.Name+"/"+e.Attr.Class.String())
+	}
+	want := []string{"Jalan Satu/residential", "Jalan Satu/residential", "Jalan Dua/primary"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("route edges = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package routing
+
+import (
+	"errors"
+	"math"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// PHAST computes the distances from one node to all others over a
+// hierarchy in rank order (graph.RenumberByRank). An upward search from the
+// source, through the core if there is one, is followed by one sweep down
+// the hierarchy: from the highest node below the core to node 0, each node
+// takes the best of its edges from higher nodes, its backward upward
+// edges, whose distances are final by then. The sweep reads the backward
+// graph end to end without a queue, so a one-to-all search costs little
+// more than a pass over memory; it suits isochrones and distance tables.
+// A PHAST is not safe for concurrent use.
+type PHAST struct {
+	chg  *graph.CHGraph
+	core uint32 // nodes core and up form the uncontracted core
+	dist []uint32
+	pq   MinHeap
+}
+
+// NewPHAST prepares one-to-all searches over chg, which must be in rank
+// order: its rank is not kept in graph files, so the layout is checked
+// instead. Every upward edge must lead to a higher node, except between
+// the core nodes at the top, whose original edges the forward graph must
+// all carry.
+func NewPHAST(chg *graph.CHGraph) (*PHAST, error) {
+	n := chg.NumNodes
+	core := n
+	for u := range n {
+		for _, v := range chg.FwdHead[chg.FwdFirstOut[u]:chg.FwdFirstOut[u+1]] {
+			if v < u {
+				core = min(core, v)
+			}
+		}
+		for _, v := range chg.BwdHead[chg.BwdFirstOut[u]:chg.BwdFirstOut[u+1]] {
+			if v < u {
+				core = min(core, v)
+			}
+		}
+	}
+	// The forward search stands for Dijkstra in the core, so it must hold
+	// every edge there; a graph out of rank order fails this.
+	fwd := make([]bool, n)
+	for u := core; u < n; u++ {
+		heads := chg.FwdHead[chg.FwdFirstOut[u]:chg.FwdFirstOut[u+1]]
+		for _, v := range heads {
+			fwd[v] = true
+		}
+		for _, v := range chg.OrigHead[chg.OrigFirstOut[u]:chg.OrigFirstOut[u+1]] {
+			if v >= core && v != u && !fwd[v] {
+				return nil, errors.New("graph is not in rank order: renumber it with graph.RenumberByRank")
+			}
+		}
+		for _, v := range heads {
+			fwd[v] = false
+		}
+	}
+	return &PHAST{chg: chg, core: core, dist: make([]uint32, n)}, nil
+}
+
+// Distances returns the distance from source to every node, MaxUint32 for
+// those it cannot reach. The slice is reused by the next call.
+func (p *PHAST) Distances(source uint32) []uint32 {
+	chg, dist := p.chg, p.dist
+	for i := range dist {
+		dist[i] = math.MaxUint32
+	}
+
+	// Upward search, run to the end as every node it reaches may lead down
+	// to some other.
+	dist[source] = 0
+	p.pq.Reset()
+	p.pq.Push(source, 0)
+	for p.pq.Len() > 0 {
+		it := p.pq.Pop()
+		if it.Dist > dist[it.Node] {
+			continue
+		}
+		for e := chg.FwdFirstOut[it.Node]; e < chg.FwdFirstOut[it.Node+1]; e++ {
+			if d := it.Dist + chg.FwdWeight[e]; d < dist[chg.FwdHead[e]] {
+				dist[chg.FwdHead[e]] = d
+				p.pq.Push(chg.FwdHead[e], d)
+			}
+		}
+	}
+
+	// Downward sweep.
+	for v := int64(p.core) - 1; v >= 0; v-- {
+		best := dist[v]
+		for e := chg.BwdFirstOut[v]; e < chg.BwdFirstOut[v+1]; e++ {
+			if du := dist[chg.BwdHead[e]]; du != math.MaxUint32 {
+				best = min(best, du+chg.BwdWeight[e])
+			}
+		}
+		dist[v] = best
+	}
+	return dist
+}
@@ -0,0 +1,53 @@
+package routing
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestPHAST(t *testing.T) {
+	g := buildGridGraph(t, 15)
+	for name, opt := range map[string]ch.Options{
+		"full":  {},
+		"core":  {MaxShortcutsPerNode: 3},
+		"early": {CoreFraction: 0.2},
+	} {
+		chg, err := graph.RenumberByRank(ch.ContractWith(g, opt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewPHAST(chg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		orig := chg.OrigGraph()
+		for s := uint32(0); s < chg.NumNodes; s += 17 {
+			if got, want := p.Distances(s), plainDijkstraMulti(orig, map[uint32]uint32{s: 0}); !slices.Equal(got, want) {
+				t.Errorf("%s: distances from %d differ from Dijkstra's", name, s)
+			}
+		}
+
+		// The renumbered hierarchy answers point-to-point queries as well.
+		eng := &Engine{chg: chg}
+		for s := uint32(0); s < chg.NumNodes; s += 11 {
+			for d := uint32(3); d < chg.NumNodes; d += 13 {
+				qs := NewQueryState(chg.NumNodes)
+				qs.touchFwd(s, 0)
+				qs.FwdPQ.Push(s, 0)
+				qs.touchBwd(d, 0)
+				qs.BwdPQ.Push(d, 0)
+				if mu, _ := eng.runCHDijkstra(context.Background(), qs); mu != plainDijkstra(orig, s, d) {
+					t.Errorf("%s: s=%d d=%d: CH=%d, Dijkstra=%d", name, s, d, mu, plainDijkstra(orig, s, d))
+				}
+			}
+		}
+	}
+
+	if _, err := NewPHAST(ch.Contract(g)); err == nil {
+		t.Error("accepted a hierarchy out of rank order")
+	}
+}
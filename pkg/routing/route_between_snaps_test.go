@@ -248,6 +248,49 @@ func TestRouteBetweenSnaps_ReverseTwinIsDirect(t *testing.T) {
 	}
 }
 
+// TestRouteBetweenSnaps_DirectedSnapsKeepTheirDirection covers Directed snaps
+// on a two-way street: a start heading east must set off east even when the
+// end lies just behind it, and an end approached westward must be reached
+// westward, so both routes turn at node 31 instead of reversing on the spot.
+func TestRouteBetweenSnaps_DirectedSnapsKeepTheirDirection(t *testing.T) {
+	g := graph.Build(twoWayStreet())
+	e := NewEngine(ch.Contract(g), g)
+	ctx := context.Background()
+
+	n30 := nodeIndex(g, 3.00000, 101.60000)
+	n31 := nodeIndex(g, 3.00000, 101.60090)
+	segLen := geo.Haversine(3.00000, 101.60000, 3.00000, 101.60090)
+
+	for _, tc := range []struct {
+		name     string
+		from, to SnapResult
+	}{
+		{"directed start", snapOnEdge(t, g, n30, n31, 0.5), snapOnEdge(t, g, n30, n31, 0.25)},
+		{"directed end", snapOnEdge(t, g, n30, n31, 0.25), snapOnEdge(t, g, n31, n30, 0.5)},
+	} {
+		direct, err := e.RouteBetweenSnaps(ctx, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("%s: undirected RouteBetweenSnaps: %v", tc.name, err)
+		}
+		if want := 0.25 * segLen; math.Abs(direct.TotalDistanceMeters-want) > 0.5 {
+			t.Errorf("%s: undirected distance = %.2f m, want the direct %.2f m", tc.name, direct.TotalDistanceMeters, want)
+		}
+
+		if tc.name == "directed start" {
+			tc.from.Directed = true
+		} else {
+			tc.to.Directed = true
+		}
+		res, err := e.RouteBetweenSnaps(ctx, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("%s: RouteBetweenSnaps: %v", tc.name, err)
+		}
+		if want := 1.25 * segLen; math.Abs(res.TotalDistanceMeters-want) > 0.5 {
+			t.Errorf("%s: distance = %.2f m, want %.2f m by way of node 31", tc.name, res.TotalDistanceMeters, want)
+		}
+	}
+}
+
 // TestRouteBetweenSnaps_NoAccessPenalty pins the decision that snap distance is
 // not charged here. The endpoints are on the network by construction; a map
 // matcher prices off-road distance separately via emission probability, and
@@ -0,0 +1,293 @@
+package routing
+
+import (
+	"math"
+
+	"github.com/azybler/map_router/pkg/geo"
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// edgeLine is the polyline of one directed edge: NodeU, the edge's shape
+// points, then NodeV. The parser emits one edge per junction-to-junction run
+// of a way, so a curved road is a single edge with shape points, and both
+// snapping and geometry must follow the polyline rather than the u→v chord.
+//
+// Positions along an edge (SnapResult.Ratio) are fractions of the polyline's
+// length, which keeps the partial-edge costs weight*Ratio proportional to the
+// distance actually driven.
+type edgeLine struct {
+	g          *graph.Graph
+	u, v       uint32
+	lats, lons []graph.Coord // shape points, excluding u and v
+}
+
+func newEdgeLine(g *graph.Graph, u, e uint32) edgeLine {
+	l := edgeLine{g: g, u: u, v: g.Head[e]}
+	if int(e)+1 < len(g.GeoFirstOut) {
+		start, end := g.GeoFirstOut[e], g.GeoFirstOut[e+1]
+		l.lats, l.lons = g.GeoShapeLat[start:end], g.GeoShapeLon[start:end]
+	}
+	return l
+}
+
+func snapLine(g *graph.Graph, s SnapResult) edgeLine {
+	return newEdgeLine(g, s.NodeU, s.EdgeIdx)
+}
+
+// nodeLatLng returns the position of node n.
+func nodeLatLng(g *graph.Graph, n uint32) LatLng {
+	return g.Coordinate(n)
+}
+
+// points returns the number of polyline points, endpoints included.
+func (l edgeLine) points() int { return len(l.lats) + 2 }
+
+// at returns polyline point k (0 = u, points()-1 = v).
+func (l edgeLine) at(k int) (lat, lng float64) {
+	switch {
+	case k == 0:
+		return l.g.NodeLatLng(l.u)
+	case k > len(l.lats):
+		return l.g.NodeLatLng(l.v)
+	}
+	return l.lats[k-1].Deg(), l.lons[k-1].Deg()
+}
+
+// segmentLength returns the length in meters of segment k (point k to k+1).
+func (l edgeLine) segmentLength(k int) float64 {
+	aLat, aLng := l.at(k)
+	bLat, bLng := l.at(k + 1)
+	return geo.Haversine(aLat, aLng, bLat, bLng)
+}
+
+// length returns the polyline length in meters.
+func (l edgeLine) length() float64 {
+	var total float64
+	for k := 0; k+1 < l.points(); k++ {
+		total += l.segmentLength(k)
+	}
+	return total
+}
+
+// segmentRatio converts a position t along segment k into a ratio of the
+// whole polyline.
+func (l edgeLine) segmentRatio(k int, t float64) float64 {
+	if l.points() == 2 {
+		return t
+	}
+	var before, total float64
+	for j := 0; j+1 < l.points(); j++ {
+		d := l.segmentLength(j)
+		switch {
+		case j < k:
+			before += d
+		case j == k:
+			before += t * d
+		}
+		total += d
+	}
+	if total <= 0 {
+		return 0
+	}
+	return before / total
+}
+
+// nearest returns the distance from a point to the polyline, the position
+// along it of the polyline point closest to it, and the side of it the point
+// lies on.
+func (l edgeLine) nearest(lat, lng float64) (dist, ratio float64, side Side) {
+	dist, seg, t := math.Inf(1), 0, 0.0
+	for k := 0; k+1 < l.points(); k++ {
+		aLat, aLng := l.at(k)
+		bLat, bLng := l.at(k + 1)
+		if d, tk := geo.PointToSegmentDist(lat, lng, aLat, aLng, bLat, bLng); d < dist {
+			dist, seg, t = d, k, tk
+		}
+	}
+	return dist, l.segmentRatio(seg, t), l.side(lat, lng, seg, t)
+}
+
+// side returns the side of the polyline a point lies on, given the position
+// t along segment k of the polyline point closest to it. When that is a
+// shape point the segments on either side of it may disagree; the point then
+// lies in the wedge outside the bend, on the side the road turns away from.
+func (l edgeLine) side(lat, lng float64, k int, t float64) Side {
+	j := k
+	switch {
+	case t == 0 && k > 0:
+		j = k - 1
+	case t == 1 && k+2 < l.points():
+		j = k + 1
+	}
+	a, b := l.segmentSide(lat, lng, min(j, k)), l.segmentSide(lat, lng, max(j, k))
+	if a == b || b == SideOn {
+		return a
+	}
+	if a == SideOn {
+		return b
+	}
+	// The bend turns toward the side of the first segment the second ends on.
+	lat2, lng2 := l.at(max(j, k) + 1)
+	return l.segmentSide(lat2, lng2, min(j, k)).opposite()
+}
+
+// segmentSide returns the side of segment k's line a point lies on. Scaling
+// longitudes by the cosine of the latitude, as a projection would, leaves the
+// sign of the cross product unchanged, so it is taken in degrees.
+func (l edgeLine) segmentSide(lat, lng float64, k int) Side {
+	aLat, aLng := l.at(k)
+	bLat, bLng := l.at(k + 1)
+	switch c := (bLng-aLng)*(lat-aLat) - (bLat-aLat)*(lng-aLng); {
+	case c > 0:
+		return SideLeft
+	case c < 0:
+		return SideRight
+	}
+	return SideOn
+}
+
+// heading returns the direction of travel along the polyline at ratio, in
+// degrees clockwise from north.
+func (l edgeLine) heading(ratio float64) float64 {
+	_, _, k := l.locate(ratio)
+	aLat, aLng := l.at(k)
+	bLat, bLng := l.at(k + 1)
+	return geo.Bearing(LatLng{Lat: aLat, Lng: aLng}, LatLng{Lat: bLat, Lng: bLng})
+}
+
+// headingDiff returns the angle between two headings in degrees, in [0, 180].
+func headingDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return min(d, 360-d)
+}
+
+// locate returns the position at ratio along the polyline, and the segment it
+// falls on.
+func (l edgeLine) locate(ratio float64) (lat, lng float64, seg int) {
+	last := l.points() - 2
+	if l.points() == 2 {
+		aLat, aLng := l.at(0)
+		bLat, bLng := l.at(1)
+		return aLat + ratio*(bLat-aLat), aLng + ratio*(bLng-aLng), 0
+	}
+	target := ratio * l.length()
+	for k := 0; k <= last; k++ {
+		d := l.segmentLength(k)
+		if target <= d || k == last {
+			t := 0.0
+			if d > 0 {
+				t = min(target/d, 1)
+			}
+			aLat, aLng := l.at(k)
+			bLat, bLng := l.at(k + 1)
+			return aLat + t*(bLat-aLat), aLng + t*(bLng-aLng), k
+		}
+		target -= d
+	}
+	lat, lng = l.at(last + 1) // unreachable: the loop returns on the last segment
+	return lat, lng, last
+}
+
+// between returns the polyline from ratio `from` to ratio `to`, both ends
+// interpolated, walking backwards along the edge when to < from.
+func (l edgeLine) between(from, to float64) []LatLng {
+	fLat, fLng, fSeg := l.locate(from)
+	tLat, tLng, tSeg := l.locate(to)
+	out := []LatLng{{Lat: fLat, Lng: fLng}}
+	if from <= to {
+		for k := fSeg + 1; k <= tSeg; k++ {
+			lat, lng := l.at(k)
+			out = append(out, LatLng{Lat: lat, Lng: lng})
+		}
+	} else {
+		for k := fSeg; k > tSeg; k-- {
+			lat, lng := l.at(k)
+			out = append(out, LatLng{Lat: lat, Lng: lng})
+		}
+	}
+	return append(out, LatLng{Lat: tLat, Lng: tLng})
+}
+
+// reverseOf reports whether l is other traversed backwards: the opposite
+// directed half of the same road.
+func (l edgeLine) reverseOf(other edgeLine) bool {
+	if l.u != other.v || l.v != other.u || len(l.lats) != len(other.lats) {
+		return false
+	}
+	n := len(l.lats)
+	for i := range n {
+		if l.lats[i] != other.lats[n-1-i] || l.lons[i] != other.lons[n-1-i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRoad reports whether l and other cover the same geometry, in either
+// direction.
+func (l edgeLine) sameRoad(other edgeLine) bool {
+	if l.reverseOf(other) {
+		return true
+	}
+	if l.u != other.u || l.v != other.v || len(l.lats) != len(other.lats) {
+		return false
+	}
+	for i := range l.lats {
+		if l.lats[i] != other.lats[i] || l.lons[i] != other.lons[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reverseEdge returns the edge running back along snap's edge (v→u over the
+// same shape), or noNode when the road is one-way. A different road joining
+// the same two junctions does not count.
+func reverseEdge(g *graph.Graph, s SnapResult) uint32 {
+	l := snapLine(g, s)
+	start, end := g.EdgesFrom(s.NodeV)
+	for e := start; e < end; e++ {
+		if g.Head[e] == s.NodeU && newEdgeLine(g, s.NodeV, e).reverseOf(l) {
+			return e
+		}
+	}
+	return noNode
+}
+
+// cheapestEdge returns the minimum-weight edge from u to v, or noNode. Ways
+// that join the same two junctions by different routes become parallel edges;
+// the search always relaxes the cheapest, so its geometry is the one driven.
+func cheapestEdge(g *graph.Graph, u, v uint32) uint32 {
+	best := noNode
+	start, end := g.EdgesFrom(u)
+	for e := start; e < end; e++ {
+		if g.Head[e] == v && (best == noNode || g.Weight[e] < g.Weight[best]) {
+			best = e
+		}
+	}
+	return best
+}
+
+// legToNode returns the geometry from snap's position along its edge to
+// node, which must be one of the edge's endpoints.
+func legToNode(g *graph.Graph, s SnapResult, node uint32) ([]LatLng, bool) {
+	switch node {
+	case s.NodeV:
+		return snapLine(g, s).between(s.Ratio, 1), true
+	case s.NodeU:
+		return snapLine(g, s).between(s.Ratio, 0), true
+	}
+	return nil, false
+}
+
+// legFromNode returns the geometry from node (an endpoint of snap's edge) to
+// snap's position.
+func legFromNode(g *graph.Graph, node uint32, s SnapResult) ([]LatLng, bool) {
+	switch node {
+	case s.NodeU:
+		return snapLine(g, s).between(0, s.Ratio), true
+	case s.NodeV:
+		return snapLine(g, s).between(1, s.Ratio), true
+	}
+	return nil, false
+}
@@ -2,8 +2,7 @@ package routing
 
 import (
 	"errors"
-	"math"
-	"sort"
+	"fmt"
 
 	"github.com/azybler/map_router/pkg/geo"
 	"github.com/azybler/map_router/pkg/graph"
@@ -14,6 +13,10 @@ const maxSnapDistMeters = 500.0
 // ErrPointTooFar is returned when the query point is too far from any road.
 var ErrPointTooFar = errors.New("point too far from road")
 
+// ErrAmbiguousSnap is returned by Routers that refuse to guess between
+// equally close candidate roads (e.g. stacked carriageways) for a query point.
+var ErrAmbiguousSnap = errors.New("ambiguous snap")
+
 // SnapResult represents a point snapped to a road segment.
 type SnapResult struct {
 	EdgeIdx uint32  // index into original edge arrays
@@ -21,199 +24,313 @@ type SnapResult struct {
 	NodeV   uint32  // target node of the edge
 	Ratio   float64 // 0.0 = at NodeU, 1.0 = at NodeV
 	Dist    float64 // distance in meters from query point to snapped point
+	// Directed restricts travel to EdgeIdx's direction, NodeU to NodeV, even
+	// on a two-way road (see SnapHeading).
+	Directed bool
+
+	// The road snapped to, for describing the snap: its name and class
+	// (zero when the graph carries no names or attributes), and the side of
+	// it the query point lies on, looking from NodeU to NodeV.
+	Name  graph.RoadName
+	Class graph.RoadClass
+	Side  Side
 }
 
-// Grid cell size in degrees. 0.01° ≈ 1.1 km at the equator.
-// A 3×3 cell search covers ±1.1 km, well over the 500 m max snap distance.
-const gridCellSize = 0.01
+// Side is the side of a road a point lies on, looking along an edge.
+type Side uint8
 
-// gridCell returns the integer cell coordinates for a lat/lon.
-func gridCell(lat, lon float64) (latIdx, lonIdx int32) {
-	return int32(math.Floor(lat / gridCellSize)), int32(math.Floor(lon / gridCellSize))
+const (
+	SideOn Side = iota // on the road's centreline
+	SideLeft
+	SideRight
+)
+
+func (s Side) String() string {
+	switch s {
+	case SideLeft:
+		return "left"
+	case SideRight:
+		return "right"
+	}
+	return "on"
 }
 
-// cellKey packs two int32 cell indices into a single uint64 map key.
-func cellKey(latIdx, lonIdx int32) uint64 {
-	return uint64(uint32(latIdx))<<32 | uint64(uint32(lonIdx))
+// opposite returns the side looking along the road the other way.
+func (s Side) opposite() Side {
+	switch s {
+	case SideLeft:
+		return SideRight
+	case SideRight:
+		return SideLeft
+	}
+	return s
 }
 
-// cellEdge stores a cell key and edge data in a flat sortable structure.
-type cellEdge struct {
-	key     uint64
-	edgeIdx uint32
-	source  uint32
+// describe fills in the name and class of r's road from g.
+func describe(g *graph.Graph, r *SnapResult) {
+	r.Name, r.Class = g.EdgeRoadName(r.EdgeIdx), g.EdgeAttribute(r.EdgeIdx).Class
 }
 
-// Snapper provides nearest-road snapping using a flat sorted grid index.
-// All edges are stored in a single sorted slice keyed by cell, eliminating
-// per-cell slice allocations and map pointer overhead for reduced GC pressure.
+// snapFanout is the number of children of each R-tree node.
+const snapFanout = 16
+
+// Snapper provides nearest-road snapping over a packed R-tree of the original
+// edges' bounding boxes (graph.SnapIndex). The tree lives in a few flat
+// arrays, a constant few words per edge however dense the roads, with no
+// per-node allocations for the GC to chase, and can be stored with the graph.
+// A search descends only into the boxes that may hold a road closer than
+// those it has found, so it finds the nearest road at any distance.
 type Snapper struct {
-	edges []cellEdge // sorted by key
-	g     *graph.Graph
+	idx    *graph.SnapIndex
+	g      *graph.Graph
+	filter *snapFilter // nil: every road (see WithPolicy)
 }
 
-// NewSnapper builds a flat spatial grid index from the original graph's edges.
+// NewSnapper builds the R-tree over the original graph's edges.
 func NewSnapper(g *graph.Graph) *Snapper {
-	// First pass: count total entries to pre-allocate.
-	totalEntries := 0
-	for u := uint32(0); u < g.NumNodes; u++ {
-		start, end := g.EdgesFrom(u)
-		for e := start; e < end; e++ {
-			v := g.Head[e]
-			uLat, uLon := g.NodeLat[u], g.NodeLon[u]
-			vLat, vLon := g.NodeLat[v], g.NodeLon[v]
-
-			latLo, lonLo := gridCell(math.Min(uLat, vLat), math.Min(uLon, vLon))
-			latHi, lonHi := gridCell(math.Max(uLat, vLat), math.Max(uLon, vLon))
-			totalEntries += int(latHi-latLo+1) * int(lonHi-lonLo+1)
-		}
+	return &Snapper{idx: BuildSnapIndex(g), g: g}
+}
+
+// NewSnapperFromIndex returns a Snapper over a prebuilt index, such as one
+// read with the graph (CHGraph.SnapIndex), skipping the build. idx must have
+// been built from g.
+func NewSnapperFromIndex(g *graph.Graph, idx *graph.SnapIndex) (*Snapper, error) {
+	if len(idx.Edge) != int(g.NumEdges) {
+		return nil, fmt.Errorf("snap index of %d edges, graph has %d", len(idx.Edge), g.NumEdges)
 	}
+	return &Snapper{idx: idx, g: g}, nil
+}
 
-	edges := make([]cellEdge, 0, totalEntries)
-
-	// Second pass: populate entries.
-	for u := uint32(0); u < g.NumNodes; u++ {
-		start, end := g.EdgesFrom(u)
-		for e := start; e < end; e++ {
-			v := g.Head[e]
-			uLat, uLon := g.NodeLat[u], g.NodeLon[u]
-			vLat, vLon := g.NodeLat[v], g.NodeLon[v]
-
-			latLo, lonLo := gridCell(math.Min(uLat, vLat), math.Min(uLon, vLon))
-			latHi, lonHi := gridCell(math.Max(uLat, vLat), math.Max(uLon, vLon))
-
-			for la := latLo; la <= latHi; la++ {
-				for lo := lonLo; lo <= lonHi; lo++ {
-					edges = append(edges, cellEdge{
-						key:     cellKey(la, lo),
-						edgeIdx: e,
-						source:  u,
-					})
-				}
-			}
+// boxDist returns a lower bound in meters on the distance from a point to
+// the roads in box i.
+func (s *Snapper) boxDist(lat, lng float64, i uint32) float64 {
+	idx := s.idx
+	return geo.PointToBoxDist(lat, lng, idx.MinLat[i].Deg(), idx.MinLon[i].Deg(), idx.MaxLat[i].Deg(), idx.MaxLon[i].Deg())
+}
+
+// snapItem is a tree box in the search queue, keyed by a lower bound on the
+// distance to the roads in it, or an edge (level -1) keyed by its distance.
+type snapItem struct {
+	dist, ratio float64
+	level       int
+	i           uint32 // box index; for an edge, its entry
+	u           uint32 // for an edge, its source node
+	side        Side   // for an edge, the side of it the point lies on
+}
+
+// snapQueue is a binary min-heap of snapItems by distance.
+type snapQueue []snapItem
+
+func (q *snapQueue) push(it snapItem) {
+	*q = append(*q, it)
+	h := *q
+	for i := len(h) - 1; i > 0; {
+		p := (i - 1) / 2
+		if h[p].dist <= h[i].dist {
+			break
 		}
+		h[p], h[i] = h[i], h[p]
+		i = p
 	}
+}
 
-	sort.Slice(edges, func(i, j int) bool {
-		return edges[i].key < edges[j].key
-	})
-
-	return &Snapper{edges: edges, g: g}
+func (q *snapQueue) pop() snapItem {
+	h := *q
+	top := h[0]
+	last := len(h) - 1
+	h[0] = h[last]
+	h = h[:last]
+	for i := 0; ; {
+		m, l, r := i, 2*i+1, 2*i+2
+		if l < last && h[l].dist < h[m].dist {
+			m = l
+		}
+		if r < last && h[r].dist < h[m].dist {
+			m = r
+		}
+		if m == i {
+			break
+		}
+		h[m], h[i] = h[i], h[m]
+		i = m
+	}
+	*q = h
+	return top
 }
 
-// cellRange returns the slice of edges for the given cell key using binary search.
-func (s *Snapper) cellRange(key uint64) []cellEdge {
-	// Find first entry with this key.
-	lo := sort.Search(len(s.edges), func(i int) bool {
-		return s.edges[i].key >= key
-	})
-	if lo >= len(s.edges) || s.edges[lo].key != key {
-		return nil
+// nearest calls yield with the edges within radiusMeters of the point in
+// order of distance, until yield returns false. The search is best-first:
+// an edge leaves the queue only once no box still queued can hold a closer
+// one, so it reads no more of the tree than the answer needs. q is emptied
+// and used as the queue, so a caller snapping many points reuses its
+// storage.
+func (s *Snapper) nearest(q *snapQueue, lat, lng, radiusMeters float64, yield func(SnapResult) bool) {
+	idx := s.idx
+	*q = (*q)[:0]
+	top := len(idx.Levels) - 2
+	for i := idx.Levels[top]; i < idx.Levels[top+1]; i++ {
+		if d := s.boxDist(lat, lng, i); d <= radiusMeters {
+			q.push(snapItem{dist: d, level: top, i: i})
+		}
+	}
+	for len(*q) > 0 {
+		it := q.pop()
+		switch {
+		case it.level < 0:
+			e := idx.Edge[it.i]
+			r := SnapResult{EdgeIdx: e, NodeU: it.u, NodeV: s.g.Head[e], Ratio: it.ratio, Dist: it.dist, Side: it.side}
+			describe(s.g, &r)
+			if !yield(r) {
+				return
+			}
+		case it.level == 0:
+			e := idx.Edge[it.i]
+			if s.excluded(e) {
+				continue
+			}
+			u := s.g.Edge(e).From
+			d, ratio, side := newEdgeLine(s.g, u, e).nearest(lat, lng)
+			if d <= radiusMeters {
+				q.push(snapItem{dist: d, ratio: ratio, level: -1, i: it.i, u: u, side: side})
+			}
+		default:
+			below := idx.Levels[it.level-1]
+			lo := below + (it.i-idx.Levels[it.level])*idx.Fanout
+			for c := lo; c < min(lo+idx.Fanout, idx.Levels[it.level]); c++ {
+				if d := s.boxDist(lat, lng, c); d <= radiusMeters {
+					q.push(snapItem{dist: d, level: it.level - 1, i: c})
+				}
+			}
+		}
 	}
-	// Find first entry past this key.
-	hi := sort.Search(len(s.edges), func(i int) bool {
-		return s.edges[i].key > key
-	})
-	return s.edges[lo:hi]
 }
 
 // SnapCandidates returns up to k nearest DISTINCT road edges within radiusMeters
 // of the query point, sorted ascending by off-road distance. Distinct = unique
-// undirected node-pair, so the two directed halves of a two-way road and
-// duplicate geometry collapse to one candidate. The grid ring span is derived
-// from radiusMeters, so radii beyond the historical ~1.1 km 3×3 window are
-// searched correctly (used by the escalating-radius fallback in Route).
+// road geometry, so the two directed halves of a two-way road and duplicate
+// geometry collapse to one candidate, while different roads joining the same
+// two junctions stay separate. Any radius is searched exactly (used by the
+// escalating-radius fallback in Route). Like every snap, it follows the
+// Snapper's policy (WithPolicy).
 func (s *Snapper) SnapCandidates(lat, lng float64, k int, radiusMeters float64) []SnapResult {
 	if k <= 0 {
 		return nil
 	}
-	centerLat, centerLon := gridCell(lat, lng)
-
-	// Ring span: each cell is ~1.1 km of latitude; cover radiusMeters fully
-	// (min 1 ring keeps the historical 3×3 behavior for radii ≤ 500 m).
-	span := int32(radiusMeters/(gridCellSize*111000)) + 1
-
-	var all []SnapResult
-	for dLat := -span; dLat <= span; dLat++ {
-		for dLon := -span; dLon <= span; dLon++ {
-			key := cellKey(centerLat+dLat, centerLon+dLon)
-			for _, ce := range s.cellRange(key) {
-				u := ce.source
-				v := s.g.Head[ce.edgeIdx]
-				exactDist, ratio := geo.PointToSegmentDist(
-					lat, lng,
-					s.g.NodeLat[u], s.g.NodeLon[u],
-					s.g.NodeLat[v], s.g.NodeLon[v],
-				)
-				if exactDist <= radiusMeters {
-					all = append(all, SnapResult{
-						EdgeIdx: ce.edgeIdx, NodeU: u, NodeV: v, Ratio: ratio, Dist: exactDist,
-					})
-				}
+	out := make([]SnapResult, 0, k)
+	s.candidates(new(snapQueue), lat, lng, radiusMeters, func(r SnapResult) bool {
+		l := snapLine(s.g, r)
+		for _, o := range out {
+			if snapLine(s.g, o).sameRoad(l) {
+				return true
 			}
 		}
-	}
+		out = append(out, r)
+		return len(out) < k
+	})
+	return out
+}
 
-	sort.Slice(all, func(i, j int) bool { return all[i].Dist < all[j].Dist })
+// SnapCandidate is a road found near a query point by SnapN, with the
+// directions it may be driven in.
+type SnapCandidate struct {
+	SnapResult
+	// TwoWay reports whether the road may also be driven from NodeV to
+	// NodeU, along edge ReverseIdx; EdgeIdx always runs from NodeU to NodeV.
+	TwoWay     bool
+	ReverseIdx uint32
+}
 
-	seen := make(map[uint64]struct{}, len(all))
-	out := make([]SnapResult, 0, k)
-	for _, r := range all {
-		a, b := r.NodeU, r.NodeV
-		if a > b {
-			a, b = b, a
+// SnapN returns the n nearest distinct roads within the maximum snap
+// distance, nearest first, as SnapCandidates would, each with its edge
+// directions: what map matching and ambiguity checks weigh beyond the single
+// nearest road. It returns ErrPointTooFar when no road is in reach.
+func (s *Snapper) SnapN(lat, lng float64, n int) ([]SnapCandidate, error) {
+	cands := s.SnapCandidates(lat, lng, n, maxSnapDistMeters)
+	if len(cands) == 0 {
+		return nil, ErrPointTooFar
+	}
+	out := make([]SnapCandidate, len(cands))
+	for i, c := range cands {
+		out[i].SnapResult = c
+		if r := reverseEdge(s.g, c); r != noNode {
+			out[i].TwoWay, out[i].ReverseIdx = true, r
 		}
-		pairKey := uint64(a)<<32 | uint64(b)
-		if _, dup := seen[pairKey]; dup {
-			continue
+	}
+	return out, nil
+}
+
+// SnapDirected snaps a point approached with the given heading, in degrees
+// clockwise from north, onto a directed edge: SnapHeading with a tolerance
+// of 90°, which passes over only one-way roads against the heading. The
+// result is Directed, so a search seeded from it sets off only along its
+// edge and cannot start out the wrong way down the street.
+func (s *Snapper) SnapDirected(lat, lng, heading float64) (SnapResult, error) {
+	return s.SnapHeading(lat, lng, heading, 90)
+}
+
+// SnapHeading snaps a point onto the directed edge that best suits travel
+// at heading, in degrees clockwise from north, such as a vehicle's bearing
+// or the direction of a matched trace: of the roads within the maximum snap
+// distance, nearest first, the first that may be driven within tolerance
+// degrees of heading, on its edge that runs that way. The result is
+// Directed. When no road in reach runs that way, SnapHeading returns the
+// nearest road undirected, as Snap does.
+func (s *Snapper) SnapHeading(lat, lng, heading, tolerance float64) (SnapResult, error) {
+	cands, err := s.SnapN(lat, lng, snapK)
+	if err != nil {
+		return SnapResult{}, err
+	}
+	for _, c := range cands {
+		r := c.SnapResult
+		r.Directed = true
+		h := snapLine(s.g, r).heading(r.Ratio)
+		if headingDiff(h, heading) <= tolerance {
+			return r, nil
 		}
-		seen[pairKey] = struct{}{}
-		out = append(out, r)
-		if len(out) >= k {
-			break
+		if c.TwoWay && headingDiff(h+180, heading) <= tolerance {
+			r.EdgeIdx, r.NodeU, r.NodeV, r.Ratio, r.Side = c.ReverseIdx, c.NodeV, c.NodeU, 1-c.Ratio, c.Side.opposite()
+			describe(s.g, &r)
+			return r, nil
 		}
 	}
-	return out
+	return cands[0].SnapResult, nil
 }
 
 // Snap finds the nearest road segment to the given lat/lng.
 func (s *Snapper) Snap(lat, lng float64) (SnapResult, error) {
-	centerLat, centerLon := gridCell(lat, lng)
-
-	bestDist := math.Inf(1)
-	var bestResult SnapResult
-
-	// Search 3×3 grid of cells around the query point.
-	for dLat := int32(-1); dLat <= 1; dLat++ {
-		for dLon := int32(-1); dLon <= 1; dLon++ {
-			key := cellKey(centerLat+dLat, centerLon+dLon)
-			for _, ce := range s.cellRange(key) {
-				u := ce.source
-				v := s.g.Head[ce.edgeIdx]
-
-				exactDist, ratio := geo.PointToSegmentDist(
-					lat, lng,
-					s.g.NodeLat[u], s.g.NodeLon[u],
-					s.g.NodeLat[v], s.g.NodeLon[v],
-				)
-
-				if exactDist < bestDist {
-					bestDist = exactDist
-					bestResult = SnapResult{
-						EdgeIdx: ce.edgeIdx,
-						NodeU:   u,
-						NodeV:   v,
-						Ratio:   ratio,
-						Dist:    exactDist,
-					}
-				}
-			}
-		}
-	}
+	return s.snap(new(snapQueue), lat, lng)
+}
 
-	if bestDist > maxSnapDistMeters {
+func (s *Snapper) snap(q *snapQueue, lat, lng float64) (SnapResult, error) {
+	var best SnapResult
+	found := false
+	s.candidates(q, lat, lng, maxSnapDistMeters, func(r SnapResult) bool {
+		best, found = r, true
+		return false
+	})
+	if !found {
 		return SnapResult{}, ErrPointTooFar
 	}
+	return best, nil
+}
+
+// minParallelSnapBatch is the point count below which SnapBatch snaps on the
+// calling goroutine.
+const minParallelSnapBatch = 64
 
-	return bestResult, nil
+// SnapBatch snaps many points at once, as Snap does each: the result and
+// error for points[i] are at index i, the error ErrPointTooFar or nil. It is
+// for matrix and isochrone requests that snap hundreds of points: large
+// batches are split across CPUs, and each goroutine reuses one search queue
+// for all its points instead of growing a new one per point.
+func (s *Snapper) SnapBatch(points []LatLng) ([]SnapResult, []error) {
+	out := make([]SnapResult, len(points))
+	errs := make([]error, len(points))
+	snapParallel(len(points), minParallelSnapBatch, func(lo, hi int) {
+		var q snapQueue
+		for i := lo; i < hi; i++ {
+			out[i], errs[i] = s.snap(&q, points[i].Lat, points[i].Lng)
+		}
+	})
+	return out, errs
 }
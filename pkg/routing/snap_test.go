@@ -1,10 +1,16 @@
 package routing
 
 import (
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/paulmach/osm"
 
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/geo"
 	"github.com/azybler/map_router/pkg/graph"
 	osmparser "github.com/azybler/map_router/pkg/osm"
 )
@@ -67,3 +73,374 @@ func TestSnapCandidatesRespectsK(t *testing.T) {
 		t.Errorf("expected 0 candidates with k=0, got %d", len(got))
 	}
 }
+
+func TestSnapN(t *testing.T) {
+	// Road A two-way, road B one-way eastbound ~30 m north of it.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 40, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.30000, 20: 1.30000, 30: 1.30027, 40: 1.30027},
+		NodeLon: map[osm.NodeID]float64{10: 103.800, 20: 103.801, 30: 103.800, 40: 103.801},
+	})
+	s := NewSnapper(g)
+
+	cands, err := s.SnapN(1.30005, 103.8005, 4)
+	if err != nil {
+		t.Fatalf("SnapN: %v", err)
+	}
+	if len(cands) != 2 || cands[0].Dist > cands[1].Dist {
+		t.Fatalf("SnapN = %+v, want roads A and B, nearest first", cands)
+	}
+	a, b := cands[0], cands[1]
+	if !a.TwoWay || g.Head[a.ReverseIdx] != a.NodeU || reverseEdge(g, a.SnapResult) != a.ReverseIdx {
+		t.Errorf("road A: %+v, want two-way with its reverse edge", a)
+	}
+	if _, lng := g.NodeLatLng(b.NodeU); b.TwoWay || g.Head[b.EdgeIdx] != b.NodeV || lng != 103.800 {
+		t.Errorf("road B: %+v, want one-way eastbound", b)
+	}
+	if got, err := s.SnapN(1.30005, 103.8005, 1); err != nil || len(got) != 1 || got[0] != a {
+		t.Errorf("SnapN with n=1 = %+v, %v; want road A", got, err)
+	}
+	if _, err := s.SnapN(1.4, 103.9, 4); err != ErrPointTooFar {
+		t.Errorf("SnapN far from any road: %v, want ErrPointTooFar", err)
+	}
+}
+
+func TestSnapDirected(t *testing.T) {
+	// Road A two-way, road B one-way eastbound ~30 m north of it.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 40, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.30000, 20: 1.30000, 30: 1.30027, 40: 1.30027},
+		NodeLon: map[osm.NodeID]float64{10: 103.800, 20: 103.801, 30: 103.800, 40: 103.801},
+	})
+	s := NewSnapper(g)
+	west := func(r SnapResult) bool {
+		_, u := g.NodeLatLng(r.NodeU)
+		_, v := g.NodeLatLng(r.NodeV)
+		return u > v
+	}
+
+	// Beside road B, a quarter of the way from its west end.
+	east, err := s.SnapDirected(1.30025, 103.80025, 80)
+	if err != nil {
+		t.Fatalf("SnapDirected east: %v", err)
+	}
+	if !east.Directed || east.Dist > 5 || west(east) || math.Abs(east.Ratio-0.25) > 0.01 {
+		t.Errorf("SnapDirected east = %+v, want road B eastbound", east)
+	}
+
+	// Heading west, one-way road B is passed over for A's westbound edge.
+	w, err := s.SnapDirected(1.30025, 103.80025, 265)
+	if err != nil {
+		t.Fatalf("SnapDirected west: %v", err)
+	}
+	if !w.Directed || w.Dist < 25 || !west(w) || g.Head[w.EdgeIdx] != w.NodeV || math.Abs(w.Ratio-0.75) > 0.01 {
+		t.Errorf("SnapDirected west = %+v, want road A westbound", w)
+	}
+
+	if _, err := s.SnapDirected(1.4, 103.9, 0); err != ErrPointTooFar {
+		t.Errorf("SnapDirected far from any road: %v, want ErrPointTooFar", err)
+	}
+}
+
+func TestSnapHeading(t *testing.T) {
+	// A two-way east-west road, and a two-way north-south road ~50 m east
+	// of the query point.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 40, Weight: 100},
+			{FromNodeID: 40, ToNodeID: 30, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.3000, 20: 1.3000, 30: 1.2995, 40: 1.3005},
+		NodeLon: map[osm.NodeID]float64{10: 103.7990, 20: 103.8010, 30: 103.80045, 40: 103.80045},
+	})
+	s := NewSnapper(g)
+	heading := func(r SnapResult) float64 { return snapLine(g, r).heading(r.Ratio) }
+
+	// Heading south: the east-west road is the nearest, but only the
+	// north-south one runs within 30°, on its southbound edge.
+	r, err := s.SnapHeading(1.3001, 103.8000, 185, 30)
+	if err != nil {
+		t.Fatalf("SnapHeading: %v", err)
+	}
+	if !r.Directed || r.Dist < 40 || headingDiff(heading(r), 180) > 1 {
+		t.Errorf("SnapHeading south = %+v, heading %.0f; want the north-south road southbound", r, heading(r))
+	}
+
+	// Heading west, the nearest road runs that way.
+	if r, err := s.SnapHeading(1.3001, 103.8000, 260, 30); err != nil || !r.Directed || r.Dist > 15 || headingDiff(heading(r), 270) > 1 {
+		t.Errorf("SnapHeading west = %+v, %v; want the east-west road westbound", r, err)
+	}
+
+	// Heading northeast, neither road runs within 30°: the nearest, undirected.
+	if r, err := s.SnapHeading(1.3001, 103.8000, 45, 30); err != nil || r.Directed || r.Dist > 15 {
+		t.Errorf("SnapHeading northeast = %+v, %v; want the nearest road undirected", r, err)
+	}
+}
+
+func TestSnapDescribesRoad(t *testing.T) {
+	// A named two-way residential road east from node 10, turning sharp
+	// left at a shape point to run north-northwest to node 20.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100, Name: 1, Class: osmparser.ClassResidential,
+				ShapeLats: []float64{1.300}, ShapeLons: []float64{103.801}},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100, Name: 1, Class: osmparser.ClassResidential,
+				ShapeLats: []float64{1.300}, ShapeLons: []float64{103.801}},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.300, 20: 1.301},
+		NodeLon: map[osm.NodeID]float64{10: 103.800, 20: 103.8005},
+		Names:   []osmparser.RoadName{{}, {Name: "Jalan Bukit"}},
+	})
+	s := NewSnapper(g)
+	n10 := nodeIndex(g, 1.300, 103.800)
+
+	for _, tc := range []struct {
+		name     string
+		lat, lng float64
+		want     Side // looking from node 10 to node 20
+	}{
+		{"south of the eastward run", 1.2999, 103.8005, SideRight},
+		{"inside the bend", 1.3003, 103.8007, SideLeft},
+		// Nearest the bend, north of the eastward run's line but outside
+		// the bend.
+		{"outside the bend", 1.30005, 103.8012, SideRight},
+		{"east of the northward run", 1.3007, 103.8009, SideRight},
+		{"on the road", 1.300, 103.8005, SideOn},
+	} {
+		r, err := s.Snap(tc.lat, tc.lng)
+		if err != nil {
+			t.Fatalf("%s: Snap: %v", tc.name, err)
+		}
+		want := tc.want
+		if r.NodeU != n10 {
+			want = want.opposite()
+		}
+		if r.Side != want || r.Name.Name != "Jalan Bukit" || r.Class != osmparser.ClassResidential {
+			t.Errorf("%s: Snap = %+v; want side %v of Jalan Bukit, a residential road", tc.name, r, want)
+		}
+	}
+
+	// Heading east, the road is taken on its eastward edge, so the point
+	// south of it is on the right whichever edge Snap found.
+	if r, err := s.SnapHeading(1.2999, 103.8005, 90, 30); err != nil || r.NodeU != n10 || r.Side != SideRight || r.Name.Name != "Jalan Bukit" {
+		t.Errorf("SnapHeading east = %+v, %v; want the right side of the eastward edge", r, err)
+	}
+	if r, err := s.SnapHeading(1.2999, 103.8005, 270, 30); err != nil || r.NodeU == n10 || r.Side != SideLeft {
+		t.Errorf("SnapHeading west = %+v, %v; want the left side of the westward edge", r, err)
+	}
+}
+
+func TestHeading(t *testing.T) {
+	// An edge east from node 10, turning north at a shape point.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges:   []osmparser.RawEdge{{FromNodeID: 10, ToNodeID: 20, Weight: 100, ShapeLats: []float64{1}, ShapeLons: []float64{103.001}}},
+		NodeLat: map[osm.NodeID]float64{10: 1, 20: 1.001},
+		NodeLon: map[osm.NodeID]float64{10: 103, 20: 103.001},
+	})
+	l := newEdgeLine(g, nodeIndex(g, 1, 103), 0)
+	for _, tc := range []struct{ ratio, want float64 }{{0.25, 90}, {0.75, 0}} {
+		if got := l.heading(tc.ratio); headingDiff(got, tc.want) > 0.1 {
+			t.Errorf("heading(%v) = %.2f, want %.0f", tc.ratio, got, tc.want)
+		}
+	}
+	if d := headingDiff(350, 10); d != 20 {
+		t.Errorf("headingDiff(350, 10) = %v, want 20", d)
+	}
+}
+
+// curvedRoadGraph: a two-way road 10<->20 bowing ~110 m north of its chord
+// through one shape point, plus a straight two-way road 20<->30 east of it.
+func curvedRoadGraph() *graph.Graph {
+	return graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 300, ShapeLats: []float64{1.301}, ShapeLons: []float64{103.801}},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 300, ShapeLats: []float64{1.301}, ShapeLons: []float64{103.801}},
+			{FromNodeID: 20, ToNodeID: 30, Weight: 100},
+			{FromNodeID: 30, ToNodeID: 20, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 1.300, 20: 1.300, 30: 1.300},
+		NodeLon: map[osm.NodeID]float64{10: 103.800, 20: 103.802, 30: 103.803},
+	})
+}
+
+func TestSnapFollowsEdgeShape(t *testing.T) {
+	g := curvedRoadGraph()
+	s := NewSnapper(g)
+
+	// Right beside the bend, ~100 m from the chord.
+	cands := s.SnapCandidates(1.3009, 103.801, 4, 50.0)
+	if len(cands) != 1 {
+		t.Fatalf("expected the curved road only, got %d candidates", len(cands))
+	}
+	c := cands[0]
+	if c.Dist > 15 {
+		t.Errorf("distance to the curved road = %.1f m, want the distance to its shape", c.Dist)
+	}
+	if math.Abs(c.Ratio-0.5) > 0.05 {
+		t.Errorf("ratio = %.3f, want ~0.5 (the bend is halfway along)", c.Ratio)
+	}
+	lat, lng := snapLatLng(g, c)
+	if d := geo.Haversine(lat, lng, 1.301, 103.801); d > 15 {
+		t.Errorf("snap point %.6f,%.6f is %.1f m from the bend", lat, lng, d)
+	}
+
+	if got, err := s.Snap(1.3009, 103.801); err != nil || got.Dist > 15 {
+		t.Errorf("Snap = %+v, %v; want the curved road", got, err)
+	}
+}
+
+func TestRouteGeometryFollowsEdgeShape(t *testing.T) {
+	g := curvedRoadGraph()
+	eng := NewEngine(ch.Contract(g), g)
+
+	// From a quarter of the way along the curve to the far end of the
+	// straight road: the geometry must pass through the bend.
+	res, err := eng.Route(t.Context(), LatLng{Lat: 1.3005, Lng: 103.8005}, LatLng{Lat: 1.300, Lng: 103.803})
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	bend := false
+	for _, p := range res.Segments[0].Geometry {
+		if p.Lat == 1.301 && p.Lng == 103.801 {
+			bend = true
+		}
+	}
+	if !bend {
+		t.Errorf("geometry %v skips the bend", res.Segments[0].Geometry)
+	}
+	assertDistanceEqualsPolyline(t, res)
+}
+
+func TestStoredSnapIndex(t *testing.T) {
+	chg := ch.Contract(curvedRoadGraph())
+	chg.SnapIndex = BuildSnapIndex(chg.OrigGraph())
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := graph.WriteBinaryWith(path, chg, graph.WriteOptions{Compress: true}); err != nil {
+		t.Fatalf("WriteBinaryWith: %v", err)
+	}
+	loaded, err := graph.ReadBinary(path)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.SnapIndex, chg.SnapIndex) {
+		t.Fatalf("SnapIndex = %+v, want %+v", loaded.SnapIndex, chg.SnapIndex)
+	}
+	if err := loaded.VerifySections(); err != nil {
+		t.Errorf("VerifySections: %v", err)
+	}
+
+	orig := loaded.OrigGraph()
+	stored, err := NewSnapperFromIndex(orig, loaded.SnapIndex)
+	if err != nil {
+		t.Fatalf("NewSnapperFromIndex: %v", err)
+	}
+	built := NewSnapper(orig)
+	for _, q := range []LatLng{{Lat: 1.3009, Lng: 103.801}, {Lat: 1.3001, Lng: 103.8025}, {Lat: 1.2995, Lng: 103.8}} {
+		if got, want := stored.SnapCandidates(q.Lat, q.Lng, 4, 500), built.SnapCandidates(q.Lat, q.Lng, 4, 500); !reflect.DeepEqual(got, want) {
+			t.Errorf("SnapCandidates(%v) = %+v with the stored index, %+v with a built one", q, got, want)
+		}
+	}
+
+	if _, err := NewSnapperFromIndex(buildGridGraph(t, 3), loaded.SnapIndex); err == nil {
+		t.Error("NewSnapperFromIndex accepted an index of another graph")
+	}
+}
+
+func TestSnapMatchesScan(t *testing.T) {
+	// Enough edges for a tree of several levels.
+	g := buildGridGraph(t, 15)
+	s := NewSnapper(g)
+	if levels := len(s.idx.Levels) - 1; levels < 3 {
+		t.Fatalf("tree of %d levels, want at least 3", levels)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 300 {
+		lat, lng := 0.999+rng.Float64()*0.016, 102.999+rng.Float64()*0.016
+		// Every edge, and the roads (one per two-way pair) within 200 m.
+		best, roads := math.Inf(1), 0
+		for u := range g.NumNodes {
+			start, end := g.EdgesFrom(u)
+			for e := start; e < end; e++ {
+				d, _, _ := newEdgeLine(g, u, e).nearest(lat, lng)
+				best = min(best, d)
+				if u < g.Head[e] && d <= 200 {
+					roads++
+				}
+			}
+		}
+		got, err := s.Snap(lat, lng)
+		if err != nil || got.Dist != best {
+			t.Fatalf("Snap(%v, %v) = %+v, %v; want the edge %.2f m away", lat, lng, got, err, best)
+		}
+		cands := s.SnapCandidates(lat, lng, 100, 200)
+		if len(cands) != roads {
+			t.Errorf("SnapCandidates(%v, %v) found %d roads within 200 m, want %d", lat, lng, len(cands), roads)
+		}
+		for i, c := range cands {
+			if c.Dist > 200 || i > 0 && c.Dist < cands[i-1].Dist {
+				t.Errorf("SnapCandidates(%v, %v): candidate %d at %.2f m out of order or range", lat, lng, i, c.Dist)
+			}
+		}
+	}
+}
+
+func TestSnapBatch(t *testing.T) {
+	g := buildGridGraph(t, 15)
+	s := NewSnapper(g)
+	rng := rand.New(rand.NewPCG(3, 4))
+	// Enough points to run in parallel; every tenth is far from any road.
+	points := make([]LatLng, 500)
+	for i := range points {
+		points[i] = LatLng{Lat: 0.999 + rng.Float64()*0.016, Lng: 102.999 + rng.Float64()*0.016}
+		if i%10 == 0 {
+			points[i].Lat += 1
+		}
+	}
+	got, errs := s.SnapBatch(points)
+	if len(got) != len(points) || len(errs) != len(points) {
+		t.Fatalf("SnapBatch returned %d results and %d errors for %d points", len(got), len(errs), len(points))
+	}
+	for i, p := range points {
+		want, err := s.Snap(p.Lat, p.Lng)
+		if got[i] != want || errs[i] != err {
+			t.Errorf("point %d: SnapBatch = %+v, %v; Snap = %+v, %v", i, got[i], errs[i], want, err)
+		}
+	}
+
+	if got, errs := s.SnapBatch(nil); len(got) != 0 || len(errs) != 0 {
+		t.Errorf("SnapBatch(nil) = %v, %v", got, errs)
+	}
+}
+
+func TestSnapFarNorth(t *testing.T) {
+	// At 70°N a 0.01° cell of the former grid was 380 m wide, so a road
+	// 450 m east of the query point lay outside its 3×3 cell search.
+	g := graph.Build(&osmparser.ParseResult{
+		Edges: []osmparser.RawEdge{
+			{FromNodeID: 10, ToNodeID: 20, Weight: 100},
+			{FromNodeID: 20, ToNodeID: 10, Weight: 100},
+		},
+		NodeLat: map[osm.NodeID]float64{10: 69.995, 20: 70.005},
+		NodeLon: map[osm.NodeID]float64{10: 19.012, 20: 19.012},
+	})
+	got, err := NewSnapper(g).Snap(70, 19.0002)
+	if err != nil {
+		t.Fatalf("Snap: %v", err)
+	}
+	if math.Abs(got.Dist-450) > 5 || math.Abs(got.Ratio-0.5) > 0.01 {
+		t.Errorf("Snap = %+v, want the road 450 m east, halfway along", got)
+	}
+	if _, err := NewSnapper(g).Snap(70, 18.998); err != ErrPointTooFar {
+		t.Errorf("Snap 530 m away: %v, want ErrPointTooFar", err)
+	}
+}
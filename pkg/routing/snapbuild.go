@@ -0,0 +1,188 @@
+package routing
+
+import (
+	"cmp"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// minParallelSnap is the entry count below which the index is built on the
+// calling goroutine; smaller builds finish faster than goroutines start.
+const minParallelSnap = 1 << 14
+
+// snapBox is a bounding box in the index's fixed-point coordinates.
+type snapBox struct {
+	minLat, minLon, maxLat, maxLon graph.Coord
+}
+
+func (b snapBox) union(o snapBox) snapBox {
+	return snapBox{min(b.minLat, o.minLat), min(b.minLon, o.minLon), max(b.maxLat, o.maxLat), max(b.maxLon, o.maxLon)}
+}
+
+// lineBox returns the bounding box of edge e's polyline from u.
+func lineBox(g *graph.Graph, u, e uint32) snapBox {
+	l := newEdgeLine(g, u, e)
+	v := l.v
+	b := snapBox{g.NodeLat[u], g.NodeLon[u], g.NodeLat[u], g.NodeLon[u]}
+	b = b.union(snapBox{g.NodeLat[v], g.NodeLon[v], g.NodeLat[v], g.NodeLon[v]})
+	for i := range l.lats {
+		b = b.union(snapBox{l.lats[i], l.lons[i], l.lats[i], l.lons[i]})
+	}
+	return b
+}
+
+// snapEntry is an edge and its box while the index is built.
+type snapEntry struct {
+	edge uint32
+	box  snapBox
+}
+
+// lonKey and latKey order entries by the centre of their boxes.
+func lonKey(en *snapEntry) int64 { return int64(en.box.minLon) + int64(en.box.maxLon) }
+func latKey(en *snapEntry) int64 { return int64(en.box.minLat) + int64(en.box.maxLat) }
+
+// snapParallel calls fn on ranges covering [0, n), one per CPU when n is at
+// least minN. fn must only write state owned by its range.
+func snapParallel(n, minN int, fn func(lo, hi int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if n < minN || workers == 1 {
+		fn(0, n)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += chunk {
+		wg.Go(func() { fn(lo, min(lo+chunk, n)) })
+	}
+	wg.Wait()
+}
+
+// BuildSnapIndex builds the R-tree NewSnapper uses, for storing with the
+// graph (preprocess --snap-index). It is bulk-loaded with Sort-Tile-Recursive:
+// the edges are cut by longitude into about √(leaves) vertical strips, each
+// sorted by latitude and packed snapFanout to a leaf, and each level above
+// packs consecutive boxes of the one below. Strips run north and south in
+// turn, so the last leaf of one lies beside the first of the next.
+//
+// The strips need no order within them before their own sort, so they are
+// cut by partitioning rather than a full sort, halves in parallel, and
+// sorted in parallel; the boxes are computed in parallel too.
+func BuildSnapIndex(g *graph.Graph) *graph.SnapIndex {
+	// Edges are numbered in source order, so entry e is edge e.
+	n := int(g.NumEdges)
+	entries := make([]snapEntry, n)
+	snapParallel(int(g.NumNodes), minParallelSnap, func(lo, hi int) {
+		for u := uint32(lo); u < uint32(hi); u++ {
+			start, end := g.EdgesFrom(u)
+			for e := start; e < end; e++ {
+				entries[e] = snapEntry{e, lineBox(g, u, e)}
+			}
+		}
+	})
+
+	leaves := (n + snapFanout - 1) / snapFanout
+	strips := max(int(math.Ceil(math.Sqrt(float64(leaves)))), 1)
+	per := (leaves + strips - 1) / strips * snapFanout
+	splitStrips(entries, per)
+	snapParallel((n+per-1)/per, max(minParallelSnap/per, 1), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			strip := entries[i*per : min((i+1)*per, n)]
+			slices.SortFunc(strip, func(a, b snapEntry) int {
+				c := cmp.Compare(latKey(&a), latKey(&b))
+				if i%2 == 1 {
+					return -c
+				}
+				return c
+			})
+		}
+	})
+
+	idx := &graph.SnapIndex{
+		Fanout: snapFanout,
+		Levels: []uint32{0},
+		Edge:   make([]uint32, n),
+	}
+	boxes := make([]snapBox, n, n+n/(snapFanout-1)+1)
+	for i, en := range entries {
+		idx.Edge[i], boxes[i] = en.edge, en.box
+	}
+	for lo, hi := 0, n; ; lo, hi = hi, len(boxes) {
+		idx.Levels = append(idx.Levels, uint32(hi))
+		if hi-lo <= 1 {
+			break
+		}
+		for i := lo; i < hi; i += snapFanout {
+			b := boxes[i]
+			for _, c := range boxes[i+1 : min(i+snapFanout, hi)] {
+				b = b.union(c)
+			}
+			boxes = append(boxes, b)
+		}
+	}
+	idx.MinLat, idx.MinLon = make([]graph.Coord, len(boxes)), make([]graph.Coord, len(boxes))
+	idx.MaxLat, idx.MaxLon = make([]graph.Coord, len(boxes)), make([]graph.Coord, len(boxes))
+	for i, b := range boxes {
+		idx.MinLat[i], idx.MinLon[i], idx.MaxLat[i], idx.MaxLon[i] = b.minLat, b.minLon, b.maxLat, b.maxLon
+	}
+	return idx
+}
+
+// splitStrips reorders es into strips of per entries from the start, each
+// west of the ones after it by lonKey: es is split at a strip boundary near
+// its middle by nthElement, and the halves split the same way, concurrently
+// while they are large.
+func splitStrips(es []snapEntry, per int) {
+	if len(es) <= per {
+		return
+	}
+	k := (len(es)/per + 1) / 2 * per
+	nthElement(es, k, lonKey)
+	if len(es) < minParallelSnap || runtime.GOMAXPROCS(0) == 1 {
+		splitStrips(es[:k], per)
+		splitStrips(es[k:], per)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Go(func() { splitStrips(es[:k], per) })
+	splitStrips(es[k:], per)
+	wg.Wait()
+}
+
+// nthElement reorders es so that es[k] holds the entry a sort by key would
+// put there, with no greater key before it and no smaller after it. It is a
+// quickselect, partitioning three ways so runs of equal keys (the two halves
+// of every two-way road) cannot make it quadratic.
+func nthElement(es []snapEntry, k int, key func(*snapEntry) int64) {
+	lo, hi := 0, len(es)
+	for hi-lo > 16 {
+		a, b, c := key(&es[lo]), key(&es[lo+(hi-lo)/2]), key(&es[hi-1])
+		p := max(min(a, b), min(max(a, b), c)) // median of three
+		lt, i, gt := lo, lo, hi
+		for i < gt {
+			switch ki := key(&es[i]); {
+			case ki < p:
+				es[lt], es[i] = es[i], es[lt]
+				lt++
+				i++
+			case ki > p:
+				gt--
+				es[i], es[gt] = es[gt], es[i]
+			default:
+				i++
+			}
+		}
+		switch {
+		case k < lt:
+			hi = lt
+		case k >= gt:
+			lo = gt
+		default:
+			return
+		}
+	}
+	slices.SortFunc(es[lo:hi], func(a, b snapEntry) int { return cmp.Compare(key(&a), key(&b)) })
+}
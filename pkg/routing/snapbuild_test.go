@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+func TestSplitStrips(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, n := range []int{0, 1, 17, 1000, 50_000} {
+		es := make([]snapEntry, n)
+		for i := range es {
+			// Few distinct keys, so runs of equal keys straddle the splits.
+			lon := graph.Coord(rng.IntN(200))
+			es[i] = snapEntry{edge: uint32(i), box: snapBox{minLon: lon, maxLon: lon}}
+		}
+		const per = 48
+		splitStrips(es, per)
+		for lo := per; lo < n; lo += per {
+			west, east := int64(-1), int64(1<<62)
+			for i := range es[:lo] {
+				west = max(west, lonKey(&es[i]))
+			}
+			for i := range es[lo:] {
+				east = min(east, lonKey(&es[lo+i]))
+			}
+			if west > east {
+				t.Fatalf("n=%d: strips before %d reach %d, east of the %d after", n, lo, west, east)
+			}
+		}
+		seen := make([]bool, n)
+		for _, en := range es {
+			seen[en.edge] = true
+		}
+		for i, ok := range seen {
+			if !ok {
+				t.Fatalf("n=%d: entry %d lost", n, i)
+			}
+		}
+	}
+}
+
+func TestBuildSnapIndexParallel(t *testing.T) {
+	// Enough edges for the build to run in parallel.
+	g := buildGridGraph(t, 70)
+	if g.NumEdges < minParallelSnap {
+		t.Fatalf("%d edges, want at least %d", g.NumEdges, minParallelSnap)
+	}
+	idx := BuildSnapIndex(g)
+
+	// Every box holds its children.
+	for l := 1; l+1 < len(idx.Levels); l++ {
+		for i := idx.Levels[l]; i < idx.Levels[l+1]; i++ {
+			lo := idx.Levels[l-1] + (i-idx.Levels[l])*idx.Fanout
+			for c := lo; c < min(lo+idx.Fanout, idx.Levels[l]); c++ {
+				if idx.MinLat[c] < idx.MinLat[i] || idx.MinLon[c] < idx.MinLon[i] || idx.MaxLat[c] > idx.MaxLat[i] || idx.MaxLon[c] > idx.MaxLon[i] {
+					t.Fatalf("box %d at level %d does not hold its child %d", i, l, c)
+				}
+			}
+		}
+	}
+	for i, e := range idx.Edge {
+		if b := lineBox(g, g.Edge(e).From, e); b != (snapBox{idx.MinLat[i], idx.MinLon[i], idx.MaxLat[i], idx.MaxLon[i]}) {
+			t.Fatalf("entry %d: box of edge %d is %+v", i, e, b)
+		}
+	}
+
+	// The same index as a build on one CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	if !reflect.DeepEqual(BuildSnapIndex(g), idx) {
+		t.Error("the parallel build differs from the sequential one")
+	}
+}
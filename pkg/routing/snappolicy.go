@@ -0,0 +1,98 @@
+package routing
+
+import "github.com/azybler/map_router/pkg/graph"
+
+// SnapPolicy steers snapping by road class (graph.EdgeAttr.Class), away from
+// roads a query point is rarely meant to reach, such as service roads and
+// parking aisles. Graphs without edge attributes have every road in
+// ClassOther. The zero value snaps to every road.
+type SnapPolicy struct {
+	// Exclude lists the classes never snapped to.
+	Exclude []graph.RoadClass
+	// Avoid lists the classes snapped to only when no road of another class
+	// lies within AvoidMeters of the point.
+	Avoid       []graph.RoadClass
+	AvoidMeters float64
+}
+
+// snapFilter is a SnapPolicy as lookup tables by class.
+type snapFilter struct {
+	exclude, avoid [256]bool
+	avoidMeters    float64
+	avoids         bool
+}
+
+func newSnapFilter(p SnapPolicy) *snapFilter {
+	if len(p.Exclude) == 0 && len(p.Avoid) == 0 {
+		return nil
+	}
+	f := &snapFilter{avoidMeters: p.AvoidMeters, avoids: len(p.Avoid) > 0}
+	for _, c := range p.Exclude {
+		f.exclude[c] = true
+	}
+	for _, c := range p.Avoid {
+		f.avoid[c] = true
+	}
+	return f
+}
+
+// WithPolicy returns a Snapper over the same index that snaps under p.
+func (s *Snapper) WithPolicy(p SnapPolicy) *Snapper {
+	return &Snapper{idx: s.idx, g: s.g, filter: newSnapFilter(p)}
+}
+
+// excluded reports whether the policy rules out edge e.
+func (s *Snapper) excluded(e uint32) bool {
+	return s.filter != nil && s.filter.exclude[s.g.EdgeAttribute(e).Class]
+}
+
+// candidates is nearest under the snap policy. While the search is within
+// AvoidMeters of the point, roads of avoided classes are held back: they are
+// dropped, there and beyond, as soon as a road of another class turns up,
+// and passed on once the search leaves AvoidMeters without one. The search
+// runs to AvoidMeters whatever radiusMeters, so a narrow search avoids the
+// same roads as a wide one.
+func (s *Snapper) candidates(q *snapQueue, lat, lng, radiusMeters float64, yield func(SnapResult) bool) {
+	f := s.filter
+	if f == nil || !f.avoids {
+		s.nearest(q, lat, lng, radiusMeters, yield)
+		return
+	}
+	var held []SnapResult
+	decided, avoid := false, false
+	release := func() bool {
+		for _, r := range held {
+			if r.Dist > radiusMeters || !yield(r) {
+				return false
+			}
+		}
+		return true
+	}
+	s.nearest(q, lat, lng, max(radiusMeters, f.avoidMeters), func(r SnapResult) bool {
+		avoided := f.avoid[s.g.EdgeAttribute(r.EdgeIdx).Class]
+		if !decided {
+			switch {
+			case r.Dist > f.avoidMeters:
+				decided = true
+				if !release() {
+					return false
+				}
+			case !avoided:
+				decided, avoid = true, true
+			default:
+				held = append(held, r)
+				return true
+			}
+		}
+		switch {
+		case r.Dist > radiusMeters:
+			return false
+		case avoid && avoided:
+			return true
+		}
+		return yield(r)
+	})
+	if !decided {
+		release()
+	}
+}
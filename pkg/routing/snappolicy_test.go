@@ -0,0 +1,74 @@
+package routing
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/paulmach/osm"
+
+	"github.com/azybler/map_router/pkg/graph"
+	osmparser "github.com/azybler/map_router/pkg/osm"
+)
+
+func TestSnapPolicy(t *testing.T) {
+	// A service road, a residential road ~30 m north of it and a footway
+	// ~11 m south, all two-way.
+	var edges []osmparser.RawEdge
+	for _, r := range []struct {
+		from, to osm.NodeID
+		class    graph.RoadClass
+	}{{10, 20, osmparser.ClassService}, {30, 40, osmparser.ClassResidential}, {50, 60, osmparser.ClassFootway}} {
+		edges = append(edges,
+			osmparser.RawEdge{FromNodeID: r.from, ToNodeID: r.to, Weight: 100, Class: r.class},
+			osmparser.RawEdge{FromNodeID: r.to, ToNodeID: r.from, Weight: 100, Class: r.class})
+	}
+	g := graph.Build(&osmparser.ParseResult{
+		Edges:   edges,
+		NodeLat: map[osm.NodeID]float64{10: 1.30000, 20: 1.30000, 30: 1.30027, 40: 1.30027, 50: 1.29990, 60: 1.29990},
+		NodeLon: map[osm.NodeID]float64{10: 103.800, 20: 103.801, 30: 103.800, 40: 103.801, 50: 103.800, 60: 103.801},
+	})
+	all := NewSnapper(g)
+	classes := func(cands []SnapResult) []graph.RoadClass {
+		var cs []graph.RoadClass
+		for _, c := range cands {
+			cs = append(cs, g.EdgeAttribute(c.EdgeIdx).Class)
+		}
+		return cs
+	}
+	const lat, lng = 1.30002, 103.8005
+
+	for _, tc := range []struct {
+		name   string
+		policy SnapPolicy
+		radius float64
+		want   []graph.RoadClass
+	}{
+		{"no policy", SnapPolicy{}, 500, []graph.RoadClass{osmparser.ClassService, osmparser.ClassFootway, osmparser.ClassResidential}},
+		{"exclude footways", SnapPolicy{Exclude: []graph.RoadClass{osmparser.ClassFootway}}, 500,
+			[]graph.RoadClass{osmparser.ClassService, osmparser.ClassResidential}},
+		{"avoid service roads", SnapPolicy{Avoid: []graph.RoadClass{osmparser.ClassService, osmparser.ClassFootway}, AvoidMeters: 50}, 500,
+			[]graph.RoadClass{osmparser.ClassResidential}},
+		// No other road is within AvoidMeters, so nothing is avoided.
+		{"nothing else near", SnapPolicy{Avoid: []graph.RoadClass{osmparser.ClassService}, AvoidMeters: 10}, 500,
+			[]graph.RoadClass{osmparser.ClassService, osmparser.ClassFootway, osmparser.ClassResidential}},
+		// Within 50 m there is a footway, so the service road is avoided even
+		// by a search too narrow to reach the footway.
+		{"narrow search", SnapPolicy{Avoid: []graph.RoadClass{osmparser.ClassService}, AvoidMeters: 50}, 5, nil},
+	} {
+		s := all.WithPolicy(tc.policy)
+		if got := classes(s.SnapCandidates(lat, lng, 4, tc.radius)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: SnapCandidates classes = %v, want %v", tc.name, got, tc.want)
+		}
+		if len(tc.want) == 0 {
+			continue
+		}
+		if r, err := s.Snap(lat, lng); err != nil || g.EdgeAttribute(r.EdgeIdx).Class != tc.want[0] {
+			t.Errorf("%s: Snap = %+v, %v; want a %v road", tc.name, r, err, tc.want[0])
+		}
+	}
+
+	// The original Snapper is unchanged.
+	if r, _ := all.Snap(lat, lng); g.EdgeAttribute(r.EdgeIdx).Class != osmparser.ClassService {
+		t.Errorf("WithPolicy changed the Snapper it was called on")
+	}
+}
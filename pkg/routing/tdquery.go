@@ -0,0 +1,241 @@
+package routing
+
+import (
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// TDRouter answers departure-time queries over a time-dependent hierarchy.
+// Like an Engine it keeps its per-query state, arrays over the nodes, in a
+// pool, so queries allocate little and may run concurrently.
+type TDRouter struct {
+	tg     *graph.TDCHGraph
+	qsPool sync.Pool
+}
+
+// NewTDRouter returns a TDRouter over tg.
+func NewTDRouter(tg *graph.TDCHGraph) *TDRouter {
+	r := &TDRouter{tg: tg}
+	r.qsPool.New = func() any {
+		return newTDQueryState(tg.NumNodes)
+	}
+	return r
+}
+
+// tdQueryState is the per-node state of one TDRouter query. Only the
+// touched entries are cleared between queries.
+type tdQueryState struct {
+	arrival []float64 // earliest arrival found; +Inf = not reached
+	pred    []uint32  // the edge each reached node was reached by
+	seen    []bool    // reached by the walk up from target
+
+	// The edges from each node on the way down to target, as lists
+	// threaded through downEdge and downNext from downFirst[node].
+	downFirst, downNext, downEdge []uint32
+
+	touched []uint32 // nodes whose arrival or seen is set
+	queue   []uint32
+	pq      tdHeap
+}
+
+func newTDQueryState(n uint32) *tdQueryState {
+	qs := &tdQueryState{
+		arrival:   make([]float64, n),
+		pred:      make([]uint32, n),
+		seen:      make([]bool, n),
+		downFirst: make([]uint32, n),
+	}
+	for i := range qs.arrival {
+		qs.arrival[i] = math.Inf(1)
+		qs.downFirst[i] = noNode
+	}
+	return qs
+}
+
+func (qs *tdQueryState) reset() {
+	for _, v := range qs.touched {
+		qs.arrival[v] = math.Inf(1)
+		qs.seen[v] = false
+		qs.downFirst[v] = noNode
+	}
+	qs.touched = qs.touched[:0]
+	qs.downNext, qs.downEdge = qs.downNext[:0], qs.downEdge[:0]
+	qs.queue = qs.queue[:0]
+	qs.pq.items = qs.pq.items[:0]
+}
+
+// Query finds the earliest arrival at target for leaving source depart
+// milliseconds into a graph.TTFPeriod. It returns the travel time in
+// milliseconds and the route as original node IDs, or ok false if target
+// cannot be reached.
+//
+// The backward half of a static query cannot run: the arrival time it
+// would start from is what the query is looking for. Instead the edges
+// climbing from target are collected first, without weights, and a single
+// time-dependent Dijkstra from source then follows the upward edges and
+// those collected edges back down. Every route it can take is a real one,
+// and the fastest up-down route of the hierarchy is among them, so the
+// first time it settles target is the earliest arrival.
+func (r *TDRouter) Query(source, target uint32, depart float64) (travel float64, path []uint32, ok bool) {
+	tg := r.tg
+	if source >= tg.NumNodes || target >= tg.NumNodes {
+		return 0, nil, false
+	}
+	qs := r.qsPool.Get().(*tdQueryState)
+	defer func() {
+		qs.reset()
+		r.qsPool.Put(qs)
+	}()
+
+	qs.seen[target] = true
+	qs.touched = append(qs.touched, target)
+	qs.queue = append(qs.queue, target)
+	for i := 0; i < len(qs.queue); i++ {
+		v := qs.queue[i]
+		for _, id := range tg.BwdEdge[tg.BwdFirstOut[v]:tg.BwdFirstOut[v+1]] {
+			x := tg.Edges[id].From
+			qs.downEdge = append(qs.downEdge, id)
+			qs.downNext = append(qs.downNext, qs.downFirst[x])
+			qs.downFirst[x] = uint32(len(qs.downEdge) - 1)
+			if !qs.seen[x] {
+				qs.seen[x] = true
+				qs.touched = append(qs.touched, x)
+				qs.queue = append(qs.queue, x)
+			}
+		}
+	}
+
+	arrival, pred := qs.arrival, qs.pred
+	relax := func(id uint32, at float64) {
+		e := &tg.Edges[id]
+		if t := at + e.TTF.Eval(at); t < arrival[e.To] {
+			if math.IsInf(arrival[e.To], 1) {
+				qs.touched = append(qs.touched, e.To)
+			}
+			arrival[e.To], pred[e.To] = t, id
+			qs.pq.push(e.To, t)
+		}
+	}
+	arrival[source] = depart
+	qs.touched = append(qs.touched, source)
+	qs.pq.push(source, depart)
+	for qs.pq.len() > 0 {
+		it := qs.pq.pop()
+		if it.at > arrival[it.node] {
+			continue // stale entry
+		}
+		if it.node == target {
+			var edges []uint32
+			for v := target; v != source; v = tg.Edges[pred[v]].From {
+				edges = append(edges, pred[v])
+			}
+			path = []uint32{source}
+			at := depart
+			for _, id := range slices.Backward(edges) {
+				path = unpackTDEdge(tg, id, at, path)
+				at += tg.Edges[id].TTF.Eval(at)
+			}
+			return it.at - depart, path, true
+		}
+		for _, id := range tg.FwdEdge[tg.FwdFirstOut[it.node]:tg.FwdFirstOut[it.node+1]] {
+			relax(id, it.at)
+		}
+		for d := qs.downFirst[it.node]; d != noNode; d = qs.downNext[d] {
+			relax(qs.downEdge[d], it.at)
+		}
+	}
+	return 0, nil, false
+}
+
+// unpackTDEdge appends the nodes of edge id after its From to path, for
+// leaving at t: a shortcut goes the way of its Via that is fastest then.
+func unpackTDEdge(tg *graph.TDCHGraph, id uint32, t float64, path []uint32) []uint32 {
+	type item struct {
+		id uint32
+		at float64
+	}
+	stack := []item{{id, t}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		e := &tg.Edges[it.id]
+		if e.Via == nil {
+			path = append(path, e.To)
+			continue
+		}
+		var best graph.TDVia
+		bestTravel, firstTravel := math.Inf(1), 0.0
+		for _, via := range e.Via {
+			first := tg.Edges[via.First].TTF.Eval(it.at)
+			travel := first
+			if via.Second >= 0 {
+				travel += tg.Edges[via.Second].TTF.Eval(it.at + first)
+			}
+			if travel < bestTravel {
+				best, bestTravel, firstTravel = via, travel, first
+			}
+		}
+		if best.Second >= 0 {
+			stack = append(stack, item{uint32(best.Second), it.at + firstTravel})
+		}
+		stack = append(stack, item{uint32(best.First), it.at})
+	}
+	return path
+}
+
+// tdHeap is a min-heap of arrival times for TDRouter.Query.
+type tdHeap struct {
+	items []tdHeapItem
+}
+
+type tdHeapItem struct {
+	node uint32
+	at   float64
+}
+
+func (h *tdHeap) len() int { return len(h.items) }
+
+func (h *tdHeap) push(node uint32, at float64) {
+	h.items = append(h.items, tdHeapItem{node, at})
+	i := len(h.items) - 1
+	item := h.items[i]
+	for i > 0 {
+		parent := (i - 1) / 2
+		if item.at >= h.items[parent].at {
+			break
+		}
+		h.items[i] = h.items[parent]
+		i = parent
+	}
+	h.items[i] = item
+}
+
+func (h *tdHeap) pop() tdHeapItem {
+	top := h.items[0]
+	n := len(h.items) - 1
+	item := h.items[n]
+	h.items = h.items[:n]
+	if n == 0 {
+		return top
+	}
+	i := 0
+	for {
+		child := 2*i + 1
+		if child >= n {
+			break
+		}
+		if right := child + 1; right < n && h.items[right].at < h.items[child].at {
+			child = right
+		}
+		if item.at <= h.items[child].at {
+			break
+		}
+		h.items[i] = h.items[child]
+		i = child
+	}
+	h.items[i] = item
+	return top
+}
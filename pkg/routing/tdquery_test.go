@@ -0,0 +1,122 @@
+package routing
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/azybler/map_router/pkg/ch"
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// randomTTFs gives each of g's edges a TTF of its weight slowed by up to
+// three times at a few random times of day.
+func randomTTFs(g *graph.Graph, rng *rand.Rand) []graph.TTF {
+	ttf := make([]graph.TTF, g.NumEdges)
+	for e, w := range g.Weight {
+		at := 0.0
+		for range 4 {
+			ttf[e] = append(ttf[e], graph.TTFPoint{At: at, Travel: float64(w) * (1 + 2*rng.Float64())})
+			at += float64(graph.TTFPeriod) / 4 * (0.5 + rng.Float64()/2)
+		}
+	}
+	return ttf
+}
+
+// tdDijkstra runs a time-dependent Dijkstra on the original graph.
+func tdDijkstra(g *graph.Graph, ttf []graph.TTF, source, target uint32, depart float64) float64 {
+	arrival := make([]float64, g.NumNodes)
+	for i := range arrival {
+		arrival[i] = math.Inf(1)
+	}
+	arrival[source] = depart
+	var pq tdHeap
+	pq.push(source, depart)
+	for pq.len() > 0 {
+		it := pq.pop()
+		if it.at > arrival[it.node] {
+			continue
+		}
+		if it.node == target {
+			return it.at - depart
+		}
+		start, end := g.EdgesFrom(it.node)
+		for e := start; e < end; e++ {
+			if t := it.at + ttf[e].Eval(it.at); t < arrival[g.Head[e]] {
+				arrival[g.Head[e]] = t
+				pq.push(g.Head[e], t)
+			}
+		}
+	}
+	return math.Inf(1)
+}
+
+// tdPathCost returns the travel time along path leaving at depart, taking
+// the fastest edge between each pair of nodes.
+func tdPathCost(t *testing.T, g *graph.Graph, ttf []graph.TTF, path []uint32, depart float64) float64 {
+	t.Helper()
+	at := depart
+	for i := 0; i+1 < len(path); i++ {
+		best := math.Inf(1)
+		start, end := g.EdgesFrom(path[i])
+		for e := start; e < end; e++ {
+			if g.Head[e] == path[i+1] {
+				best = min(best, ttf[e].Eval(at))
+			}
+		}
+		if math.IsInf(best, 1) {
+			t.Fatalf("path has no edge %d->%d", path[i], path[i+1])
+		}
+		at += best
+	}
+	return at - depart
+}
+
+func TestTDRouterQuery(t *testing.T) {
+	g := buildGridGraph(t, 12)
+	rng := rand.New(rand.NewPCG(4, 0))
+	ttf := randomTTFs(g, rng)
+	tg, err := ch.ContractTimeDependent(g, ttf, ch.Contract(g).Rank)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewTDRouter(tg)
+	for range 300 {
+		s, d := rng.Uint32N(g.NumNodes), rng.Uint32N(g.NumNodes)
+		depart := rng.Float64() * 2 * graph.TTFPeriod
+		travel, path, ok := r.Query(s, d, depart)
+		if !ok {
+			t.Fatalf("s=%d d=%d: no route", s, d)
+		}
+		want := tdDijkstra(g, ttf, s, d, depart)
+		if math.Abs(travel-want) > 1e-6*want {
+			t.Errorf("s=%d d=%d depart=%.0f: TCH %.3f, Dijkstra %.3f", s, d, depart, travel, want)
+		}
+		if path[0] != s || path[len(path)-1] != d {
+			t.Fatalf("s=%d d=%d: path runs %d to %d", s, d, path[0], path[len(path)-1])
+		}
+		if cost := tdPathCost(t, g, ttf, path, depart); math.Abs(cost-travel) > 1e-6*travel {
+			t.Errorf("s=%d d=%d: path takes %.3f, query says %.3f", s, d, cost, travel)
+		}
+	}
+}
+
+func TestContractTimeDependentErrors(t *testing.T) {
+	g := buildGridGraph(t, 3)
+	ttf := make([]graph.TTF, g.NumEdges)
+	for e, w := range g.Weight {
+		ttf[e] = graph.ConstantTTF(w)
+	}
+	rank := ch.Contract(g).Rank
+	if _, err := ch.ContractTimeDependent(g, ttf[1:], rank); err == nil {
+		t.Error("accepted too few TTFs")
+	}
+	if _, err := ch.ContractTimeDependent(g, ttf, rank[1:]); err == nil {
+		t.Error("accepted too few ranks")
+	}
+	ttf[2] = graph.TTF{{At: 0, Travel: 1e7}, {At: 1000, Travel: 0}}
+	if _, err := ch.ContractTimeDependent(g, ttf, rank); err == nil {
+		t.Error("accepted a TTF that arrives earlier for leaving later")
+	}
+}
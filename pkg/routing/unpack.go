@@ -7,9 +7,10 @@ const maxUnpackDepth = 200
 const noNode = ^uint32(0) // sentinel for "no node"
 
 // unpackOverlayPath takes a sequence of overlay-level nodes and unpacks all
-// shortcut hops into original-graph node sequences.
+// shortcut hops into original-graph node sequences, taking those in cache
+// (which may be nil) as they are.
 // Uses a single pre-allocated stack across all hops to avoid per-hop allocations.
-func unpackOverlayPath(chg *graph.CHGraph, overlayNodes []uint32) []uint32 {
+func unpackOverlayPath(chg *graph.CHGraph, cache *unpackCache, overlayNodes []uint32) []uint32 {
 	if len(overlayNodes) < 2 {
 		return overlayNodes
 	}
@@ -34,6 +35,14 @@ func unpackOverlayPath(chg *graph.CHGraph, overlayNodes []uint32) []uint32 {
 				continue // safety bound
 			}
 
+			if nodes, ok := cache.lookup(it.from, it.to); ok {
+				if result[len(result)-1] != it.from {
+					result = append(result, it.from)
+				}
+				result = append(result, nodes...)
+				continue
+			}
+
 			middle := findMiddle(chg, it.from, it.to)
 			if middle < 0 {
 				// Original edge — append nodes, avoiding duplication.
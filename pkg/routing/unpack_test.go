@@ -3,6 +3,7 @@ package routing
 import (
 	"context"
 	"math"
+	"slices"
 	"testing"
 
 	"github.com/paulmach/osm"
@@ -69,7 +70,7 @@ func TestUnpackParallelShortcut(t *testing.T) {
 
 	idx := func(lat, lon float64) uint32 {
 		for i := uint32(0); i < g.NumNodes; i++ {
-			if g.NodeLat[i] == lat && g.NodeLon[i] == lon {
+			if g.NodeLat[i].Deg() == lat && g.NodeLon[i].Deg() == lon {
 				return i
 			}
 		}
@@ -90,7 +91,7 @@ func TestUnpackParallelShortcut(t *testing.T) {
 	}
 
 	overlay := eng.reconstructOverlayPath(meet, qs.PredFwd, qs.PredBwd)
-	origNodes := unpackOverlayPath(chg, overlay)
+	origNodes := unpackOverlayPath(chg, nil, overlay)
 
 	// The unpacked path must be a VALID original-graph path whose summed
 	// original-edge weight equals mu (the CH cost). With the bug, unpack returns
@@ -103,3 +104,31 @@ func TestUnpackParallelShortcut(t *testing.T) {
 		t.Errorf("expected mu=20 via the cheap path A->X->B, got %d", mu)
 	}
 }
+
+func TestUnpackCache(t *testing.T) {
+	chg := ch.Contract(buildGridGraph(t, 20))
+
+	cache := newUnpackCache(chg, 500)
+	if cache == nil || len(cache.nodes) > 500 {
+		t.Fatalf("cache %v over a 500-node budget", cache)
+	}
+	if newUnpackCache(chg, 0) != nil {
+		t.Error("zero budget built a cache")
+	}
+	cached := 0
+	for u := range chg.NumNodes {
+		for i := chg.FwdFirstOut[u]; i < chg.FwdFirstOut[u+1]; i++ {
+			v := chg.FwdHead[i]
+			if _, ok := cache.lookup(u, v); ok {
+				cached++
+			}
+			want := unpackOverlayPath(chg, nil, []uint32{u, v})
+			if got := unpackOverlayPath(chg, cache, []uint32{u, v}); !slices.Equal(got, want) {
+				t.Errorf("%d->%d unpacked to %v with the cache, %v without", u, v, got, want)
+			}
+		}
+	}
+	if cached == 0 {
+		t.Error("no forward shortcut cached")
+	}
+}
@@ -0,0 +1,130 @@
+package routing
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/azybler/map_router/pkg/graph"
+)
+
+// defaultUnpackCacheNodes bounds the nodes NewEngine's unpack cache holds:
+// 16 MiB of node IDs.
+const defaultUnpackCacheNodes = 1 << 22
+
+// unpackCache holds the unpacked node sequences of the shortcuts between the
+// highest-ranked nodes. Nearly every long route climbs to the top of the
+// hierarchy, where shortcuts stand for hundreds of original edges, so
+// unpacking them is a lookup rather than a walk down to the bottom.
+type unpackCache struct {
+	index map[uint64]uint32 // from<<32 | to → index into first
+	first []uint32          // shortcut i's nodes: nodes[first[i]:first[i+1]]
+	nodes []uint32          // each after its from node, ending with its to node
+}
+
+// newUnpackCache unpacks the shortcuts of chg from the top of the hierarchy
+// down, while their nodes fit in maxNodes. It returns nil when none do.
+func newUnpackCache(chg *graph.CHGraph, maxNodes int) *unpackCache {
+	if maxNodes <= 0 || chg.NumNodes == 0 {
+		return nil
+	}
+
+	c := &unpackCache{index: make(map[uint64]uint32), first: []uint32{0}}
+	add := func(from, to uint32) bool {
+		key := uint64(from)<<32 | uint64(to)
+		if _, ok := c.index[key]; ok || findMiddle(chg, from, to) < 0 {
+			return true
+		}
+		path := unpackOverlayPath(chg, nil, []uint32{from, to})[1:]
+		if len(c.nodes)+len(path) > maxNodes {
+			return false
+		}
+		c.index[key] = uint32(len(c.first) - 1)
+		c.nodes = append(c.nodes, path...)
+		c.first = append(c.first, uint32(len(c.nodes)))
+		return true
+	}
+	for _, u := range topDown(chg) {
+		for i := chg.FwdFirstOut[u]; i < chg.FwdFirstOut[u+1]; i++ {
+			if !add(u, chg.FwdHead[i]) {
+				return c.done()
+			}
+		}
+		for i := chg.BwdFirstOut[u]; i < chg.BwdFirstOut[u+1]; i++ {
+			if !add(chg.BwdHead[i], u) {
+				return c.done()
+			}
+		}
+	}
+	return c.done()
+}
+
+// done returns c, or nil if it holds nothing.
+func (c *unpackCache) done() *unpackCache {
+	if len(c.index) == 0 {
+		return nil
+	}
+	c.nodes = slices.Clip(c.nodes)
+	return c
+}
+
+// lookup returns the unpacked nodes of the overlay edge from→to after from,
+// if they are cached. A nil cache holds nothing.
+func (c *unpackCache) lookup(from, to uint32) ([]uint32, bool) {
+	if c == nil {
+		return nil, false
+	}
+	i, ok := c.index[uint64(from)<<32|uint64(to)]
+	if !ok {
+		return nil, false
+	}
+	return c.nodes[c.first[i]:c.first[i+1]], true
+}
+
+// topDown returns chg's nodes by their height below the top of the
+// hierarchy: the longest upward path from each, the core and the nodes
+// without upward edges first. Ranks are not stored in graph files, but upward edges only
+// climb, which is all the order needs.
+func topDown(chg *graph.CHGraph) []uint32 {
+	n := chg.NumNodes
+	up := func(u uint32, fn func(v uint32)) {
+		for _, v := range chg.FwdHead[chg.FwdFirstOut[u]:chg.FwdFirstOut[u+1]] {
+			fn(v)
+		}
+		for _, v := range chg.BwdHead[chg.BwdFirstOut[u]:chg.BwdFirstOut[u+1]] {
+			fn(v)
+		}
+	}
+
+	// Order the nodes bottom-up (Kahn), then take heights top-down.
+	below := make([]uint32, n) // upward edges into each node
+	for u := range n {
+		up(u, func(v uint32) { below[v]++ })
+	}
+	order := make([]uint32, 0, n)
+	for u := range n {
+		if below[u] == 0 {
+			order = append(order, u)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		up(order[i], func(v uint32) {
+			if below[v]--; below[v] == 0 {
+				order = append(order, v)
+			}
+		})
+	}
+	// Edges between the nodes of an uncontracted core run both ways, so
+	// Kahn never reaches them; they are the top.
+	core := len(order)
+	for u := range n {
+		if below[u] > 0 {
+			order = append(order, u)
+		}
+	}
+	height := make([]uint32, n)
+	for _, u := range slices.Backward(order[:core]) {
+		up(u, func(v uint32) { height[u] = max(height[u], height[v]+1) })
+	}
+	slices.SortStableFunc(order, func(a, b uint32) int { return cmp.Compare(height[a], height[b]) })
+	return order
+}